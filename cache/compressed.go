@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"sync"
+)
+
+// Values stored by a CompressedCache start with one of these, so that it
+// can share a cache, e.g. memcached, with instances that don't compress.
+const (
+	compressedNone  byte = 0
+	compressedFlate byte = 1
+)
+
+// CompressedCache compresses the values of the underlying cache, so that a
+// cache of a given size holds more of them.
+type CompressedCache struct {
+	c       BytesCache
+	writers sync.Pool
+}
+
+// NewCompressedCache wraps c to compress its values.
+func NewCompressedCache(c BytesCache) *CompressedCache {
+	return &CompressedCache{
+		c: c,
+		writers: sync.Pool{New: func() interface{} {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		}},
+	}
+}
+
+// Get returns the entry for k, decompressed.
+func (cc *CompressedCache) Get(k string) ([]byte, error) {
+	v, err := cc.c.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, ErrNotFound
+	}
+
+	switch v[0] {
+	case compressedNone:
+		return v[1:], nil
+	case compressedFlate:
+		r := flate.NewReader(bytes.NewReader(v[1:]))
+		defer r.Close()
+		if v, err = ioutil.ReadAll(r); err != nil {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// Set stores v for k, compressed unless that doesn't make it smaller.
+func (cc *CompressedCache) Set(k string, v []byte, expire int32) {
+	var buf bytes.Buffer
+	buf.WriteByte(compressedFlate)
+
+	w := cc.writers.Get().(*flate.Writer)
+	w.Reset(&buf)
+	_, err := w.Write(v)
+	if err == nil {
+		err = w.Close()
+	}
+	cc.writers.Put(w)
+
+	if err != nil || buf.Len() > len(v) {
+		buf.Reset()
+		buf.WriteByte(compressedNone)
+		buf.Write(v)
+	}
+
+	cc.c.Set(k, buf.Bytes(), expire)
+}
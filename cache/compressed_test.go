@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressedCache(t *testing.T) {
+	mc := newMapCache()
+	c := NewCompressedCache(mc)
+
+	long := bytes.Repeat([]byte(`[1510913759,1510913340],`), 100)
+	c.Set("long", long, 60)
+	if stored := mc.m["long"]; len(stored) >= len(long) || stored[0] != compressedFlate {
+		t.Errorf("expected a compressed value, stored %d of %d bytes", len(stored), len(long))
+	}
+	if v, err := c.Get("long"); err != nil || !bytes.Equal(v, long) {
+		t.Errorf("Get(long) = %q, %v", v, err)
+	}
+	if mc.expires["long"] != 60 {
+		t.Errorf("expected the expiry to be kept, got %d", mc.expires["long"])
+	}
+
+	c.Set("short", []byte("v"), 60)
+	if stored := mc.m["short"]; !bytes.Equal(stored, []byte{compressedNone, 'v'}) {
+		t.Errorf("expected a value compression doesn't shrink to be stored as is, got %q", stored)
+	}
+	if v, err := c.Get("short"); err != nil || !bytes.Equal(v, []byte("v")) {
+		t.Errorf("Get(short) = %q, %v", v, err)
+	}
+
+	mc.m["bad"] = []byte{compressedFlate, 0xff}
+	if _, err := c.Get("bad"); err != ErrNotFound {
+		t.Errorf("expected corrupt values to be missing, got %v", err)
+	}
+	if _, err := c.Get("missing"); err != ErrNotFound {
+		t.Errorf("expected missing values to be missing, got %v", err)
+	}
+}
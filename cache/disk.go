@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskHeader is the size of the expiry timestamp that prefixes the values
+// stored by a DiskCache.
+const diskHeader = 8
+
+// diskPrefix starts the names of the files of a DiskCache. Files in its
+// directory without it, or not named as an entry, are not the cache's, and
+// are left alone.
+const diskPrefix = "carbonapi-"
+
+// DiskCache stores entries as files in a directory, so that they outlive
+// restarts. Once the entries take more than maxSize bytes, the least
+// recently used are removed.
+type DiskCache struct {
+	dir     string
+	maxSize uint64
+	now     func() time.Time
+
+	mu      sync.Mutex
+	size    uint64
+	entries map[string]*diskEntry
+}
+
+type diskEntry struct {
+	size uint64
+	used time.Time
+}
+
+// NewDiskCache returns a cache in dir, which is created if needed. The
+// entries already in dir are kept, and any other files in it ignored.
+func NewDiskCache(dir string, maxSize uint64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: make(map[string]*diskEntry),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// Leftovers of writes cut short.
+		if strings.HasPrefix(f.Name(), "."+diskPrefix) {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		if !isDiskName(f.Name()) {
+			continue
+		}
+		c.entries[f.Name()] = &diskEntry{size: uint64(f.Size()), used: f.ModTime()}
+		c.size += uint64(f.Size())
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+func diskName(k string) string {
+	sum := sha1.Sum([]byte(k))
+	return diskPrefix + hex.EncodeToString(sum[:])
+}
+
+// isDiskName tells if name is that of an entry.
+func isDiskName(name string) bool {
+	if !strings.HasPrefix(name, diskPrefix) {
+		return false
+	}
+	sum, err := hex.DecodeString(name[len(diskPrefix):])
+
+	return err == nil && len(sum) == sha1.Size
+}
+
+// Get returns the entry for k if it hasn't expired.
+func (c *DiskCache) Get(k string) ([]byte, error) {
+	name := diskName(k)
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+	if err != nil || len(b) < diskHeader {
+		return nil, ErrNotFound
+	}
+
+	if expires := int64(binary.BigEndian.Uint64(b)); expires != 0 && c.now().Unix() >= expires {
+		c.mu.Lock()
+		c.remove(name)
+		c.mu.Unlock()
+		return nil, ErrNotFound
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[name]; ok {
+		e.used = c.now()
+	}
+	c.mu.Unlock()
+
+	return b[diskHeader:], nil
+}
+
+// Set stores v for k for expire seconds, or until it is evicted if expire
+// is 0.
+func (c *DiskCache) Set(k string, v []byte, expire int32) {
+	var expires int64
+	if expire > 0 {
+		expires = c.now().Unix() + int64(expire)
+	}
+
+	b := make([]byte, diskHeader+len(v))
+	binary.BigEndian.PutUint64(b, uint64(expires))
+	copy(b[diskHeader:], v)
+
+	// Written aside and renamed, so that readers never see a partial entry.
+	name := diskName(k)
+	f, err := ioutil.TempFile(c.dir, "."+name)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[name]; ok {
+		c.size -= e.size
+	}
+	c.entries[name] = &diskEntry{size: uint64(len(b)), used: c.now()}
+	c.size += uint64(len(b))
+	c.evict()
+}
+
+// Size returns the bytes the entries take.
+func (c *DiskCache) Size() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// Items returns the number of entries.
+func (c *DiskCache) Items() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// evict removes the least recently used entries once they take more than
+// maxSize, down to 90% of it so that not every Set evicts. Call with c.mu
+// held.
+func (c *DiskCache) evict() {
+	if c.maxSize == 0 || c.size <= c.maxSize {
+		return
+	}
+
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.entries[names[i]].used.Before(c.entries[names[j]].used)
+	})
+
+	for _, name := range names {
+		if c.size <= c.maxSize/10*9 {
+			break
+		}
+		c.remove(name)
+	}
+}
+
+// remove deletes the entry name. Call with c.mu held.
+func (c *DiskCache) remove(name string) {
+	os.Remove(filepath.Join(c.dir, name))
+	if e, ok := c.entries[name]; ok {
+		c.size -= e.size
+		delete(c.entries, name)
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1000, 0)
+	c, err := NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+
+	c.Set("forever", []byte("v1"), 0)
+	c.Set("minute", []byte("v2"), 60)
+	if v, err := c.Get("forever"); err != nil || !bytes.Equal(v, []byte("v1")) {
+		t.Errorf("Get(forever) = %q, %v", v, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := c.Get("minute"); err != ErrNotFound {
+		t.Errorf("expected the expired entry to be gone, got %v", err)
+	}
+	if c.Items() != 1 {
+		t.Errorf("expected the expired entry to be removed, %d left", c.Items())
+	}
+
+	// Entries outlive the cache.
+	c, err = NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get("forever"); err != nil || !bytes.Equal(v, []byte("v1")) {
+		t.Errorf("Get(forever) after reopening = %q, %v", v, err)
+	}
+}
+
+func TestDiskCacheEvicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Room for three entries of 2+diskHeader bytes.
+	now := time.Unix(1000, 0)
+	c, err := NewDiskCache(dir, 3*(2+diskHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+
+	for _, k := range []string{"a", "b", "c"} {
+		now = now.Add(time.Second)
+		c.Set(k, []byte("vv"), 0)
+	}
+	now = now.Add(time.Second)
+	c.Get("a")
+
+	now = now.Add(time.Second)
+	c.Set("d", []byte("vv"), 0)
+
+	if _, err := c.Get("b"); err != ErrNotFound {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	for _, k := range []string{"a", "d"} {
+		if _, err := c.Get(k); err != nil {
+			t.Errorf("expected %s to be kept, got %v", k, err)
+		}
+	}
+	if c.Size() > 3*(2+diskHeader) {
+		t.Errorf("expected at most %d bytes, got %d", 3*(2+diskHeader), c.Size())
+	}
+}
+
+func TestDiskCacheLeavesOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	others := []string{".bashrc", "notes.txt", "carbonapi-notes"}
+	for _, name := range others {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("not the cache's"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "."+diskName("cut")+"123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for one entry of 2+diskHeader bytes.
+	c, err := NewDiskCache(dir, 2+diskHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Items() != 0 || c.Size() != 0 {
+		t.Errorf("expected other files not to count as entries, got %d of %d bytes", c.Items(), c.Size())
+	}
+	c.Set("a", []byte("vv"), 0)
+	c.Set("b", []byte("vv"), 0)
+
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be left alone, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "."+diskName("cut")+"123")); !os.IsNotExist(err) {
+		t.Errorf("expected the leftover of a cut write to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// hashedHeader is the size of the check of the key that prefixes values
+// stored by a HashedCache.
+const hashedHeader = 8
+
+// HashedCache keys the underlying cache by the SHA-256 of the keys it's
+// given, so that the keys of renders of thousands of targets don't take
+// more memory than their responses. Values are prefixed with a second,
+// independent hash of their key, and entries whose key doesn't match it
+// are misses.
+type HashedCache struct {
+	c          BytesCache
+	collisions uint64
+}
+
+// NewHashedCache wraps c to hash its keys.
+func NewHashedCache(c BytesCache) *HashedCache {
+	return &HashedCache{c: c}
+}
+
+// Get returns the entry for k.
+func (h *HashedCache) Get(k string) ([]byte, error) {
+	v, err := h.c.Get(hashKey(k))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < hashedHeader {
+		return nil, ErrNotFound
+	}
+	if binary.BigEndian.Uint64(v) != checkKey(k) {
+		atomic.AddUint64(&h.collisions, 1)
+		return nil, ErrNotFound
+	}
+
+	return v[hashedHeader:], nil
+}
+
+// Set stores v under k.
+func (h *HashedCache) Set(k string, v []byte, expire int32) {
+	b := make([]byte, hashedHeader+len(v))
+	binary.BigEndian.PutUint64(b, checkKey(k))
+	copy(b[hashedHeader:], v)
+
+	h.c.Set(hashKey(k), b, expire)
+}
+
+// Collisions returns how many entries were found under the hash of a key
+// other than theirs.
+func (h *HashedCache) Collisions() uint64 {
+	return atomic.LoadUint64(&h.collisions)
+}
+
+func hashKey(k string) string {
+	sum := sha256.Sum256([]byte(k))
+	return string(sum[:])
+}
+
+func checkKey(k string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(k))
+	return h.Sum64()
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHashedCache(t *testing.T) {
+	mc := newMapCache()
+	c := NewHashedCache(mc)
+
+	long := "target=" + strings.Repeat("some.metric.name,", 10000)
+	c.Set(long, []byte("v"), 60)
+	for k := range mc.m {
+		if len(k) != 32 {
+			t.Errorf("underlying key is %d bytes, want 32", len(k))
+		}
+	}
+	if got := mc.expires[hashKey(long)]; got != 60 {
+		t.Errorf("underlying expiry is %d, want 60", got)
+	}
+
+	v, err := c.Get(long)
+	if err != nil || !bytes.Equal(v, []byte("v")) {
+		t.Fatalf("got %q, %v, want \"v\"", v, err)
+	}
+	if _, err := c.Get(long + "x"); err != ErrNotFound {
+		t.Errorf("Get of another key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestHashedCacheCollision(t *testing.T) {
+	mc := newMapCache()
+	c := NewHashedCache(mc)
+
+	// Store b's entry where a's hash would be, as a collision would.
+	c.Set("b", []byte("b's value"), 60)
+	mc.m[hashKey("a")] = mc.m[hashKey("b")]
+
+	if v, err := c.Get("a"); err != ErrNotFound {
+		t.Errorf("Get of a colliding key returned %q, %v, want ErrNotFound", v, err)
+	}
+	if got := c.Collisions(); got != 1 {
+		t.Errorf("got %d collisions, want 1", got)
+	}
+	if v, err := c.Get("b"); err != nil || !bytes.Equal(v, []byte("b's value")) {
+		t.Errorf("got %q, %v for b", v, err)
+	}
+
+	mc.m[hashKey("c")] = []byte("short")
+	if _, err := c.Get("c"); err != ErrNotFound {
+		t.Errorf("Get of a truncated entry returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// staleHeader is the size of the fresh-until timestamp that prefixes values
+// stored by a StaleCache.
+const staleHeader = 8
+
+// StaleCache keeps entries in the underlying cache for a bounded window past
+// their expiry, so that callers can serve an expired entry right away while
+// they refresh it in the background (stale-while-revalidate).
+type StaleCache struct {
+	c      BytesCache
+	window int32
+	now    func() time.Time
+
+	mu         sync.Mutex
+	refreshing map[string]struct{}
+}
+
+// NewStaleCache wraps c to keep entries for window seconds past their expiry.
+func NewStaleCache(c BytesCache, window int32) *StaleCache {
+	return &StaleCache{
+		c:          c,
+		window:     window,
+		now:        time.Now,
+		refreshing: make(map[string]struct{}),
+	}
+}
+
+// Get returns the entry for k if it hasn't expired.
+func (s *StaleCache) Get(k string) ([]byte, error) {
+	v, stale, err := s.GetStale(k)
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		return nil, ErrNotFound
+	}
+
+	return v, nil
+}
+
+// GetStale returns the entry for k, and whether it has expired.
+func (s *StaleCache) GetStale(k string) ([]byte, bool, error) {
+	v, err := s.c.Get(k)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(v) < staleHeader {
+		return nil, false, ErrNotFound
+	}
+
+	freshUntil := int64(binary.BigEndian.Uint64(v))
+	stale := freshUntil != 0 && s.now().Unix() >= freshUntil
+
+	return v[staleHeader:], stale, nil
+}
+
+// Set stores v under k. It's fresh for expire seconds and then served stale
+// for the window. Entries that don't expire are stored as they are.
+func (s *StaleCache) Set(k string, v []byte, expire int32) {
+	var freshUntil int64
+	if expire > 0 {
+		freshUntil = s.now().Unix() + int64(expire)
+		expire += s.window
+	}
+
+	b := make([]byte, staleHeader+len(v))
+	binary.BigEndian.PutUint64(b, uint64(freshUntil))
+	copy(b[staleHeader:], v)
+
+	s.c.Set(k, b, expire)
+}
+
+// Refresh runs refresh in the background, unless a refresh of k is already
+// running. It reports whether it started one.
+func (s *StaleCache) Refresh(k string, refresh func()) bool {
+	s.mu.Lock()
+	if _, ok := s.refreshing[k]; ok {
+		s.mu.Unlock()
+		return false
+	}
+	s.refreshing[k] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.refreshing, k)
+			s.mu.Unlock()
+		}()
+
+		refresh()
+	}()
+
+	return true
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type mapCache struct {
+	m       map[string][]byte
+	expires map[string]int32
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{m: make(map[string][]byte), expires: make(map[string]int32)}
+}
+
+func (c *mapCache) Get(k string) ([]byte, error) {
+	v, ok := c.m[k]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return v, nil
+}
+
+func (c *mapCache) Set(k string, v []byte, expire int32) {
+	c.m[k] = v
+	c.expires[k] = expire
+}
+
+func TestStaleCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mc := newMapCache()
+	c := NewStaleCache(mc, 30)
+	c.now = func() time.Time { return now }
+
+	c.Set("k", []byte("v"), 60)
+	if got := mc.expires["k"]; got != 90 {
+		t.Errorf("underlying expiry is %d, want 90", got)
+	}
+
+	v, stale, err := c.GetStale("k")
+	if err != nil || stale || !bytes.Equal(v, []byte("v")) {
+		t.Fatalf("fresh entry: got %q, stale %t, err %v", v, stale, err)
+	}
+
+	now = now.Add(60 * time.Second)
+
+	v, stale, err = c.GetStale("k")
+	if err != nil || !stale || !bytes.Equal(v, []byte("v")) {
+		t.Fatalf("expired entry: got %q, stale %t, err %v", v, stale, err)
+	}
+	if _, err := c.Get("k"); err != ErrNotFound {
+		t.Errorf("Get of an expired entry returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestStaleCacheNoExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mc := newMapCache()
+	c := NewStaleCache(mc, 30)
+	c.now = func() time.Time { return now }
+
+	c.Set("k", []byte("v"), 0)
+	if got := mc.expires["k"]; got != 0 {
+		t.Errorf("underlying expiry is %d, want 0", got)
+	}
+
+	now = now.Add(time.Hour)
+	if v, err := c.Get("k"); err != nil || !bytes.Equal(v, []byte("v")) {
+		t.Errorf("got %q, %v", v, err)
+	}
+}
+
+func TestStaleCacheRefreshOnce(t *testing.T) {
+	c := NewStaleCache(newMapCache(), 30)
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	if !c.Refresh("k", func() {
+		<-release
+		close(done)
+	}) {
+		t.Fatal("first refresh didn't start")
+	}
+	if c.Refresh("k", func() {}) {
+		t.Error("second refresh started while the first one runs")
+	}
+
+	close(release)
+	<-done
+
+	// The key is released once the refresh returns.
+	for i := 0; !c.Refresh("k", func() {}); i++ {
+		if i == 100 {
+			t.Fatal("refresh of k never started again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
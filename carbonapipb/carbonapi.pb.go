@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
 	"github.com/bookingcom/carbonapi/util"
 )
 
@@ -12,6 +13,8 @@ type AccessLogDetails struct {
 	Handler                       string            `json:"handler,omitempty"`
 	CarbonapiUuid                 string            `json:"carbonapi_uuid,omitempty"`
 	Username                      string            `json:"username,omitempty"`
+	Tenant                        string            `json:"tenant,omitempty"`
+	Dashboard                     string            `json:"dashboard,omitempty"`
 	Url                           string            `json:"url,omitempty"`
 	PeerIp                        string            `json:"peer_ip,omitempty"`
 	PeerPort                      string            `json:"peer_port,omitempty"`
@@ -25,6 +28,7 @@ type AccessLogDetails struct {
 	CacheTimeout                  int32             `json:"cache_timeout,omitempty"`
 	Metrics                       []string          `json:"metrics,omitempty"`
 	HaveNonFatalErrors            bool              `json:"have_non_fatal_errors,omitempty"`
+	TargetErrors                  map[string]string `json:"target_errors,omitempty"`
 	Runtime                       float64           `json:"runtime,omitempty"`
 	HttpCode                      int32             `json:"http_code,omitempty"`
 	CarbonzipperResponseSizeBytes int64             `json:"carbonzipper_response_size_bytes,omitempty"`
@@ -59,6 +63,7 @@ func NewAccessLogDetails(r *http.Request, handler string, config *cfg.API) Acces
 	return AccessLogDetails{
 		Handler:       handler,
 		Username:      username,
+		Tenant:        tenant.FromContext(r.Context()),
 		CarbonapiUuid: util.GetUUID(r.Context()),
 		HeadersData:   getHeadersData(r, config.HeadersToLog),
 		Url:           r.URL.RequestURI(),
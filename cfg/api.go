@@ -4,6 +4,16 @@ import (
 	"io"
 	"time"
 
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/dashboard"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/objectstore"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/scrub"
+	"github.com/bookingcom/carbonapi/pkg/secrets"
+	"github.com/bookingcom/carbonapi/pkg/signature"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+
 	"gopkg.in/yaml.v2"
 )
 
@@ -65,6 +75,40 @@ func defaultAPIConfig() API {
 		Cache: CacheConfig{
 			Type:              "mem",
 			DefaultTimeoutSec: 60,
+			Disk: DiskCacheConfig{
+				MinAge: 24 * time.Hour,
+			},
+		},
+		StreamRefreshInterval: 10 * time.Second,
+		StreamLifetime:        10 * time.Minute,
+		EvalParallelism:       1,
+		Attribution: AttributionConfig{
+			Header:        "X-Dashboard-Id",
+			MaxDashboards: 1000,
+		},
+		TopQueries: TopQueriesConfig{
+			Window:      10 * time.Minute,
+			PrefixDepth: 2,
+		},
+		Journal: JournalConfig{
+			Size: 64,
+		},
+		SQL: SQLConfig{
+			MaxRows: 100000,
+		},
+		Exports: ExportsConfig{
+			MaxLimiterUse: 0.5,
+		},
+		RecordingRules: RecordingRulesConfig{
+			Protocol: "plaintext",
+			Timeout:  10 * time.Second,
+		},
+		MetricsPut: MetricsPutConfig{
+			Protocol:      "plaintext",
+			Timeout:       10 * time.Second,
+			QueueSize:     100000,
+			BatchSize:     1000,
+			FlushInterval: time.Second,
 		},
 	}
 
@@ -89,10 +133,274 @@ type API struct {
 	BlockHeaderUpdatePeriod time.Duration `yaml:"blockHeaderUpdatePeriod"`
 	HeadersToLog            []string      `yaml:"headersToLog"`
 
+	// RoutePrefix mounts the API under a path, e.g. "/graphite", so that
+	// reverse proxies needn't rewrite it away.
+	RoutePrefix string `yaml:"routePrefix"`
+
+	// ResponseHeaders are set on every response, e.g.
+	// Strict-Transport-Security, or which cluster served it.
+	ResponseHeaders map[string]string `yaml:"responseHeaders"`
+	// RequestIDHeader names the response header that echoes the Carbon
+	// UUID of the request, by which its logs can be found. None if empty.
+	RequestIDHeader string `yaml:"requestIDHeader"`
+
 	UnicodeRangeTables  []string          `yaml:"unicodeRangeTables"`
 	IgnoreClientTimeout bool              `yaml:"ignoreClientTimeout"`
 	DefaultColors       map[string]string `yaml:"defaultColors"`
 	FunctionsConfigs    map[string]string `yaml:"functionsConfig"`
+
+	Events events.Config `yaml:"events"`
+
+	// Dashboards stores the dashboards of the graphite composer.
+	Dashboards dashboard.Config `yaml:"dashboards"`
+
+	// StreamRefreshInterval is how often /render/stream sends new points
+	// unless the client asks for another interval.
+	StreamRefreshInterval time.Duration `yaml:"streamRefreshInterval"`
+
+	// StreamLifetime is how long a /render/stream connection stays open
+	// before the client has to reconnect.
+	StreamLifetime time.Duration `yaml:"streamLifetime"`
+
+	// EvalParallelism is how many targets of a render request are
+	// evaluated at the same time.
+	EvalParallelism int `yaml:"evalParallelism"`
+
+	// FunctionTimeout fails the targets in which a single function call,
+	// including the functions it calls, runs for longer. Functions stop
+	// at the next series they loop over, or the next function they call.
+	FunctionTimeout time.Duration `yaml:"functionTimeout"`
+
+	// StrictRender fails renders as a whole when any of their targets
+	// fails, rather than returning the others. Requests override it with
+	// strict=true or strict=false.
+	StrictRender bool `yaml:"strictRender"`
+
+	// NullPoints is what JSON and CSV renders do with null points: "keep"
+	// them, the default, "drop" them as noNullPoints does, or give them a
+	// value with "fill=<value>".
+	NullPoints string `yaml:"nullPoints"`
+
+	// Macros are expressions that targets can call by name, keyed by
+	// signature, e.g. "errRate(svc)".
+	Macros map[string]string `yaml:"macros"`
+
+	// ExpressionLimits refuses requests whose targets nest, fetch or glob
+	// too much.
+	ExpressionLimits parser.Limits `yaml:"expressionLimits"`
+
+	// RenderCacheControl sets the Cache-Control of render responses.
+	RenderCacheControl RenderCacheControl `yaml:"renderCacheControl"`
+
+	// Attribution counts renders per Grafana dashboard.
+	Attribution AttributionConfig `yaml:"attribution"`
+
+	// TopQueries tracks the targets and metric prefixes that cost the
+	// backends the most.
+	TopQueries TopQueriesConfig `yaml:"topQueries"`
+
+	// TimeoutBudget splits the global timeout of renders between finding,
+	// fetching and evaluating.
+	TimeoutBudget TimeoutBudget `yaml:"timeoutBudget"`
+
+	// DefaultXFilesFactor is the xFilesFactor of fetched series, unless a
+	// render asks for another one.
+	DefaultXFilesFactor float32 `yaml:"defaultXFilesFactor"`
+
+	// Tenancy lets requests of several tenants be served, each restricted
+	// to its own metric namespace.
+	Tenancy tenant.Config `yaml:"tenancy"`
+
+	// ACL restricts the metric paths users may read.
+	ACL acl.Config `yaml:"acl"`
+
+	// Identity says where the user of a request, whose roles the ACL
+	// checks, is taken from.
+	Identity IdentityConfig `yaml:"identity"`
+
+	// Signatures verifies the requests machine-to-machine callers sign
+	// with a shared secret.
+	Signatures signature.Config `yaml:"signatures"`
+
+	// Secrets configures where the references to secrets in the other
+	// values are resolved from.
+	Secrets secrets.Config `yaml:"secrets"`
+
+	// LogScrubbing redacts patterns from the access, slow request, render
+	// and zipper logs.
+	LogScrubbing scrub.Config `yaml:"logScrubbing"`
+
+	// Journal records the parameters of requests, for cmd/replay.
+	Journal JournalConfig `yaml:"journal"`
+
+	// SQL serves the experimental /sql endpoint.
+	SQL SQLConfig `yaml:"sql"`
+
+	// Exports write the results of renders to object storage on a schedule.
+	Exports ExportsConfig `yaml:"exports"`
+
+	// RecordingRules write the results of expressions back to carbon.
+	RecordingRules RecordingRulesConfig `yaml:"recordingRules"`
+
+	// MetricsPut serves /metrics/put, which relays datapoints to carbon.
+	MetricsPut MetricsPutConfig `yaml:"metricsPut"`
+
+	// Renames lets metrics be queried by their names from before and after
+	// a rename.
+	Renames RenamesConfig `yaml:"renames"`
+
+	// Blocklist refuses requests for metrics matching its patterns.
+	Blocklist BlocklistConfig `yaml:"blocklist"`
+
+	// Rollups render long time ranges from pre-aggregated metrics.
+	Rollups []Rollup `yaml:"rollups"`
+}
+
+// Rollup renders the metrics of time ranges at least MinRange long from
+// their rollups under Prefix, e.g. "rollup.1h.", where they exist. The
+// rollup with the longest MinRange a range is long enough for is used.
+type Rollup struct {
+	Prefix   string        `yaml:"prefix"`
+	MinRange time.Duration `yaml:"minRange"`
+}
+
+// IdentityConfig configures how the users of requests are verified. The
+// user of a request is the client that signed it, see Signatures, or else
+// the value of TrustedHeader, which an authenticating proxy in front of
+// carbonapi must set and drop from the requests it receives. Requests with
+// neither are anonymous.
+type IdentityConfig struct {
+	TrustedHeader string `yaml:"trustedHeader"`
+}
+
+// BlocklistConfig reads the patterns of metrics not to serve from File, a
+// YAML list of {glob: ...} and {regex: ...}, which is read again on a POST
+// to /reload-blocklist. Requests with OverrideHeader set are served anyway
+// if its value is OverrideSecret, or if they are signed by one of
+// OverrideClients. Without either, nothing overrides the blocklist.
+type BlocklistConfig struct {
+	File            string   `yaml:"file"`
+	OverrideHeader  string   `yaml:"overrideHeader"`
+	OverrideSecret  string   `yaml:"overrideSecret"`
+	OverrideClients []string `yaml:"overrideClients"`
+}
+
+// RenamesConfig reads the renames of metrics from File, a YAML list of
+// {from: <legacy pattern>, to: <new pattern>}, and reads it again every
+// UpdatePeriod if it is set.
+type RenamesConfig struct {
+	File         string        `yaml:"file"`
+	UpdatePeriod time.Duration `yaml:"updatePeriod"`
+}
+
+// MetricsPutConfig enables /metrics/put, relaying datapoints to the carbon
+// at Address in Protocol, plaintext or pickle. Up to QueueSize points are
+// queued, and sent in batches of BatchSize at least every FlushInterval;
+// requests that don't fit in the queue are refused.
+type MetricsPutConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Address       string        `yaml:"address"`
+	Protocol      string        `yaml:"protocol"`
+	Timeout       time.Duration `yaml:"timeout"`
+	QueueSize     int           `yaml:"queueSize"`
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// RecordingRulesConfig sends the series of Rules to the carbon at Address,
+// in Protocol, plaintext or pickle. Sends time out after Timeout.
+type RecordingRulesConfig struct {
+	Address  string          `yaml:"address"`
+	Protocol string          `yaml:"protocol"`
+	Timeout  time.Duration   `yaml:"timeout"`
+	Rules    []RecordingRule `yaml:"rules"`
+}
+
+// RecordingRule evaluates Expr every Interval, from From, the interval
+// before by default, and records the points of its series as the metric
+// Record. Expressions with several series need "{name}" in Record, which is
+// replaced with the name of each series.
+type RecordingRule struct {
+	Record   string        `yaml:"record"`
+	Expr     string        `yaml:"expr"`
+	Interval time.Duration `yaml:"interval"`
+	From     string        `yaml:"from"`
+}
+
+// ExportsConfig schedules Jobs, which run one at a time, and only while the
+// renders hold at most MaxLimiterUse of the concurrency limit, so that they
+// yield to production traffic.
+type ExportsConfig struct {
+	MaxLimiterUse float64            `yaml:"maxLimiterUse"`
+	Store         objectstore.Config `yaml:"store"`
+	Jobs          []ExportJob        `yaml:"jobs"`
+}
+
+// ExportJob renders Targets from From until Until every Interval, and
+// uploads the results in Format, csv or parquet, to the URL that Path, a
+// text/template, expands to. The render runs at most Timeout, the global
+// timeout by default.
+type ExportJob struct {
+	Name     string        `yaml:"name"`
+	Targets  []string      `yaml:"targets"`
+	From     string        `yaml:"from"`
+	Until    string        `yaml:"until"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Format   string        `yaml:"format"`
+	Path     string        `yaml:"path"`
+}
+
+// SQLConfig enables /sql, which runs SELECTs over the points of renders.
+// Queries answering with more than MaxRows rows fail.
+type SQLConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MaxRows int  `yaml:"maxRows"`
+}
+
+// JournalConfig records the parameters of the requests to the API, by their
+// UUID, to File, which is kept to about Size megabytes, 64 by default. An
+// empty File disables it.
+type JournalConfig struct {
+	File string `yaml:"file"`
+	Size int    `yaml:"size_mb"`
+}
+
+// RenderCacheControl sets how long clients may cache render responses.
+// Those of ranges that ended at least HistoricalAfter ago are taken as
+// final, and cached for HistoricalMaxAge instead of their cacheTimeout. A
+// zero HistoricalAfter takes no range as final.
+type RenderCacheControl struct {
+	HistoricalAfter  time.Duration `yaml:"historicalAfter"`
+	HistoricalMaxAge time.Duration `yaml:"historicalMaxAge"`
+}
+
+// AttributionConfig attributes renders to the dashboards named by Header,
+// or in the Referer. Past MaxDashboards, further dashboards are counted
+// together.
+type AttributionConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Header        string `yaml:"header"`
+	MaxDashboards int    `yaml:"maxDashboards"`
+}
+
+// TopQueriesConfig tracks the Size heaviest targets and metric prefixes,
+// their first PrefixDepth segments, over the last one to two Windows. A zero
+// Size disables it.
+type TopQueriesConfig struct {
+	Size        int           `yaml:"size"`
+	Window      time.Duration `yaml:"window"`
+	PrefixDepth int           `yaml:"prefixDepth"`
+}
+
+// TimeoutBudget is the relative share of the timeout each stage of a render
+// gets. A stage gets its share of the time left when it starts, so time
+// unused by a stage goes to the stages after it. All zero disables the
+// budget.
+type TimeoutBudget struct {
+	Find  float64 `yaml:"find"`
+	Fetch float64 `yaml:"fetch"`
+	Eval  float64 `yaml:"eval"`
 }
 
 type CacheConfig struct {
@@ -100,6 +408,26 @@ type CacheConfig struct {
 	Size              int      `yaml:"size_mb"`
 	MemcachedServers  []string `yaml:"memcachedServers"`
 	DefaultTimeoutSec int32    `yaml:"defaultTimeoutSec"`
+	// StaleWindowSec keeps expired entries for this long, serving them
+	// while they are refreshed in the background. Zero disables it.
+	StaleWindowSec int32 `yaml:"staleWindowSec"`
+	// Compress stores render and find responses compressed, so that more
+	// of them fit.
+	Compress bool `yaml:"compress"`
+	// Disk keeps the renders of historical ranges on disk as well.
+	Disk DiskCacheConfig `yaml:"disk"`
+}
+
+// DiskCacheConfig is the on-disk cache of renders whose range ended at
+// least MinAge ago, whose data no longer changes. Entries are kept for
+// ExpireSec, or until they are evicted if it is 0, and the least recently
+// used evicted once they take more than Size megabytes.
+type DiskCacheConfig struct {
+	// Path is the directory of the cache. Empty disables it.
+	Path      string        `yaml:"path"`
+	Size      int           `yaml:"size_mb"`
+	MinAge    time.Duration `yaml:"minAge"`
+	ExpireSec int32         `yaml:"expireSec"`
 }
 
 type preAPI struct {
@@ -37,12 +37,14 @@ cache:
    type: "memcache"
    size_mb: 0
    defaultTimeoutSec: 60
+   staleWindowSec: 300
    memcachedServers:
        - host1:1234
        - host2:1234
 tz: "UTC+1,3600"
 pidFile: "/var/run/carbonapi/carbonapi.pid"
 ignoreClientTimeout: true
+defaultXFilesFactor: 0.5
 
 logger:
     - logger: ""
@@ -76,6 +78,7 @@ logger:
 				MaxIdleConnsPerHost:       512,
 
 				ExpireDelaySec:             0,
+				PathCacheDepth:             1,
 				GraphiteWeb09Compatibility: false,
 
 				Buckets: 10,
@@ -85,6 +88,17 @@ logger:
 					Interval: 60 * time.Second,
 					Prefix:   "carbon.api",
 				},
+				MetricIndex: MetricIndexConfig{
+					RefreshInterval: 10 * time.Minute,
+					MaxAge:          30 * time.Minute,
+				},
+				Tombstones: TombstonesConfig{
+					RefreshInterval: time.Minute,
+				},
+				GlobExpansion: GlobExpansionConfig{
+					MaxQueries:  100,
+					Parallelism: 8,
+				},
 			},
 		},
 
@@ -100,11 +114,13 @@ logger:
 				"host2:1234",
 			},
 			DefaultTimeoutSec: 60,
+			StaleWindowSec:    300,
 		},
 		TimezoneString: "UTC+1,3600",
 		PidFile:        "/var/run/carbonapi/carbonapi.pid",
 
 		IgnoreClientTimeout: true,
+		DefaultXFilesFactor: 0.5,
 	}
 
 	if !eqCommon(got.Common, expected.Common) {
@@ -181,6 +197,7 @@ logger:
 				MaxIdleConnsPerHost:       1024,
 
 				ExpireDelaySec:             0,
+				PathCacheDepth:             1,
 				GraphiteWeb09Compatibility: false,
 
 				Buckets: 10,
@@ -190,6 +207,17 @@ logger:
 					Interval: 60 * time.Second,
 					Prefix:   "carbon.api",
 				},
+				MetricIndex: MetricIndexConfig{
+					RefreshInterval: 10 * time.Minute,
+					MaxAge:          30 * time.Minute,
+				},
+				Tombstones: TombstonesConfig{
+					RefreshInterval: time.Minute,
+				},
+				GlobExpansion: GlobExpansionConfig{
+					MaxQueries:  100,
+					Parallelism: 8,
+				},
 			},
 		},
 
@@ -234,6 +262,7 @@ type comparableAPI struct {
 	TimezoneString        string
 	PidFile               string
 	IgnoreClientTimeout   bool
+	DefaultXFilesFactor   float32
 }
 
 func toComparableAPI(a API) comparableAPI {
@@ -245,6 +274,7 @@ func toComparableAPI(a API) comparableAPI {
 		TimezoneString:        a.TimezoneString,
 		PidFile:               a.PidFile,
 		IgnoreClientTimeout:   a.IgnoreClientTimeout,
+		DefaultXFilesFactor:   a.DefaultXFilesFactor,
 	}
 }
 
@@ -252,7 +282,10 @@ func eqCacheConfig(a, b CacheConfig) bool {
 	return a.Type == b.Type &&
 		a.Size == b.Size &&
 		eqStringSlice(a.MemcachedServers, b.MemcachedServers) &&
-		a.DefaultTimeoutSec == b.DefaultTimeoutSec
+		a.DefaultTimeoutSec == b.DefaultTimeoutSec &&
+		a.StaleWindowSec == b.StaleWindowSec &&
+		a.Compress == b.Compress &&
+		a.Disk == b.Disk
 }
 
 func eqStringSlice(a, b []string) bool {
@@ -4,6 +4,9 @@ import (
 	"io"
 	"time"
 
+	"github.com/bookingcom/carbonapi/pkg/listener"
+	"github.com/bookingcom/carbonapi/pkg/statsd"
+
 	"github.com/lomik/zapwriter"
 	"gopkg.in/yaml.v2"
 )
@@ -28,23 +31,298 @@ func ParseCommon(r io.Reader) (Common, error) {
 }
 
 type Common struct {
-	Listen         string   `yaml:"listen"`
-	ListenInternal string   `yaml:"listenInternal"`
-	Backends       []string `yaml:"backends"`
+	Listen         string         `yaml:"listen"`
+	ListenInternal string         `yaml:"listenInternal"`
+	Backends       []string       `yaml:"backends"`
+	BackendGroups  []BackendGroup `yaml:"backendGroups"`
+	// BackendWeights scale the load the backends, by address, take when
+	// fanOut.loadAware picks among replicas. The default weight is 1.
+	BackendWeights map[string]float64 `yaml:"backendWeights"`
+	// Region is where this instance runs. Backend groups from other regions
+	// are only queried when the local ones fail or return nothing.
+	Region string `yaml:"region"`
+	// ZipperID names this instance in the X-Forwarded-By header of the
+	// requests it passes on, by which zippers that are backends of each
+	// other detect routing loops. Defaults to the hostname and listen port.
+	ZipperID string `yaml:"zipperID"`
+
+	// Listener tunes the socket of Listen. When set, the server is run
+	// without graceful restarts.
+	Listener listener.Config `yaml:"listener"`
 
 	MaxProcs                  int           `yaml:"maxProcs"`
 	Timeouts                  Timeouts      `yaml:"timeouts"`
 	ConcurrencyLimitPerServer int           `yaml:"concurrencyLimit"`
 	KeepAliveInterval         time.Duration `yaml:"keepAliveInterval"`
 	MaxIdleConnsPerHost       int           `yaml:"maxIdleConnsPerHost"`
+	// Transport tunes the connections to backends, unless their group
+	// has its own.
+	Transport Transport `yaml:"transport"`
+	DNSCache  DNSCache  `yaml:"dnsCache"`
 
 	ExpireDelaySec             int32   `yaml:"expireDelaySec"`
 	GraphiteWeb09Compatibility bool    `yaml:"graphite09compat"`
 	CorruptionThreshold        float64 `yaml:"corruptionThreshold"`
+	// PathCacheDepth is how many leading segments of a path are looked up
+	// in the path cache to find the servers that hold it; 1, the default,
+	// only uses top-level prefixes.
+	PathCacheDepth int `yaml:"pathCacheDepth"`
+	// PathCacheType is the kind of path cache: "expirecache" (the
+	// default), "sharded" or "cow", see pathcache.New.
+	PathCacheType string `yaml:"pathCacheType"`
+	// PathFilter configures the filters of the paths of backends that
+	// decide which are sent requests.
+	PathFilter PathFilterConfig `yaml:"pathFilter"`
+	Probe      ProbeConfig      `yaml:"probe"`
+	// IncrementalProbe has carbonapi fetch from the zippers it is in front
+	// of only how their top-level domains changed since its last probe.
+	// Other backends are still asked for all their domains.
+	IncrementalProbe bool `yaml:"incrementalProbe"`
+	// MaxRenderPoints caps the datapoints a single render may fetch from
+	// the backends; 0 means no cap.
+	MaxRenderPoints int `yaml:"maxRenderPoints"`
+	// StepPolicy is how the parts of a series with different steps are
+	// merged: "finest" (the default) or "coarsest".
+	StepPolicy string `yaml:"stepPolicy"`
 
 	Buckets  int                `yaml:"buckets"`
 	Graphite GraphiteConfig     `yaml:"graphite"`
 	Logger   []zapwriter.Config `yaml:"logger"`
+	// StatsD sends the metrics sent to graphite to a StatsD or DogStatsD
+	// agent too. Its interval defaults to that of graphite.
+	StatsD statsd.Config `yaml:"statsd"`
+	// Histograms are the buckets of the request duration histograms.
+	Histograms HistogramsConfig `yaml:"histograms"`
+	Shutdown   ShutdownConfig   `yaml:"shutdown"`
+	Warmup     WarmupConfig     `yaml:"warmup"`
+
+	MetricIndex MetricIndexConfig `yaml:"metricIndex"`
+	Tombstones  TombstonesConfig  `yaml:"tombstones"`
+	MemoryLimit MemoryLimitConfig `yaml:"memoryLimit"`
+	FanOut      FanOutConfig      `yaml:"fanOut"`
+	Shadow      ShadowConfig      `yaml:"shadow"`
+
+	GlobExpansion GlobExpansionConfig `yaml:"globExpansion"`
+	Quorum        QuorumConfig        `yaml:"quorum"`
+
+	// Chaos injects faults into the requests to backends, by address, or
+	// to all of them with "*". Only for testing, never in production.
+	Chaos map[string]Chaos `yaml:"chaos"`
+}
+
+// ShutdownConfig configures how the process stops on SIGTERM or SIGINT.
+// With a DrainTimeout, requests in flight get that long to finish, then the
+// path cache is saved to PathCacheFile, which it is loaded from on start,
+// and the connections to backends are closed. Without, the process is
+// stopped by gracehttp, which also restarts it on SIGUSR2.
+type ShutdownConfig struct {
+	DrainTimeout  time.Duration `yaml:"drainTimeout"`
+	PathCacheFile string        `yaml:"pathCacheFile"`
+}
+
+// WarmupConfig holds back a starting zipper from reporting ready on
+// /lb_check until it has probed all its backends and replayed the Queries
+// finds that were the most popular when the last zipper stopped, kept in
+// QueriesFile. The zipper reports ready after Timeout, a minute by default,
+// even if it isn't warm yet.
+type WarmupConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Queries     int           `yaml:"queries"`
+	QueriesFile string        `yaml:"queriesFile"`
+}
+
+// PathFilterConfig makes the zipper keep, for each backend, a bloom filter
+// of all its metrics, rebuilt from their list every probe, and only send a
+// request to the backends whose filter may hold its targets. The filters
+// report FalsePositiveRate of the targets a backend doesn't have as if it
+// did. Without a rate, requests are sent to the backends that have the
+// top-level domains of their targets.
+type PathFilterConfig struct {
+	FalsePositiveRate float64 `yaml:"falsePositiveRate"`
+}
+
+// ProbeConfig is how often the zipper probes each backend for its
+// capabilities and top-level domains: every Interval, 5 minutes by default,
+// and up to Jitter later, a tenth of Interval by default. Backends that fail
+// their probes are probed twice as late each time, up to MaxBackoff, 8
+// Intervals by default.
+type ProbeConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	Jitter     time.Duration `yaml:"jitter"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// HistogramsConfig are the upper bounds, in seconds, of the buckets of the
+// duration histograms, by handler and by backend group, that Prometheus
+// scrapes and graphite is sent. Empty, there are as many as Buckets: 100ms
+// wide for Linear, and doubling from 50ms for Exponential and Backend.
+type HistogramsConfig struct {
+	Linear      []float64 `yaml:"linear"`
+	Exponential []float64 `yaml:"exponential"`
+	Backend     []float64 `yaml:"backend"`
+}
+
+// Chaos is the faults injected into the requests to a backend. Each
+// happens to a request with its probability, between 0 and 1.
+type Chaos struct {
+	// Latency delays requests by this much.
+	Latency            time.Duration `yaml:"latency"`
+	LatencyProbability float64       `yaml:"latencyProbability"`
+	// ErrorProbability fails requests before they are sent.
+	ErrorProbability float64 `yaml:"errorProbability"`
+	// TruncateProbability cuts response bodies in half.
+	TruncateProbability float64 `yaml:"truncateProbability"`
+	// ContentTypeProbability answers requests with a content type no
+	// decoder handles.
+	ContentTypeProbability float64 `yaml:"contentTypeProbability"`
+}
+
+// ShadowConfig mirrors a part of the traffic to a canary backend group.
+type ShadowConfig struct {
+	// Group is the backend group requests are mirrored to. Its backends
+	// get no other requests.
+	Group string `yaml:"group"`
+	// Percent is how many of the renders and finds, in percent, are
+	// mirrored.
+	Percent float64 `yaml:"percent"`
+	// DiffPercent is how many of the mirrored responses, in percent, are
+	// compared to the production ones.
+	DiffPercent float64 `yaml:"diffPercent"`
+	// Timeout bounds mirrored requests; 0 uses the global timeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// FanOutConfig schedules the requests sent to the backends of a group.
+type FanOutConfig struct {
+	// Stagger delays the start of each backend request after the first by
+	// this much more than the one before it.
+	Stagger time.Duration `yaml:"stagger"`
+	// Jitter adds up to this much to the delay of each staggered request.
+	// It is derived from the request UUID, so retries are scheduled alike.
+	Jitter time.Duration `yaml:"jitter"`
+	// OrderByLatency sends to the backends that recently answered fastest
+	// first.
+	OrderByLatency bool `yaml:"orderByLatency"`
+	// EarlyStop answers once the backends the path cache knows to hold the
+	// requested paths have, cancelling the requests to the others.
+	EarlyStop bool `yaml:"earlyStop"`
+	// LoadAware sends a request for a single metric that the path cache
+	// knows several backends to hold only to the least loaded of them, by
+	// its requests in flight, recent p95 latency and weight. The others are
+	// asked if it fails or has nothing.
+	LoadAware bool `yaml:"loadAware"`
+	// Failover sends a request to a backend that fails again to another
+	// backend the path cache knows to hold the requested paths, after up
+	// to FailoverJitter, within the request's deadline.
+	Failover       bool          `yaml:"failover"`
+	FailoverJitter time.Duration `yaml:"failoverJitter"`
+}
+
+// MetricIndexConfig configures the in-memory index of metric names that
+// answers find requests without going to the backends.
+type MetricIndexConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+	// MaxAge is how old the index may get before finds go to the backends again.
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// TombstonesConfig configures the fetching of the metrics the backends
+// deleted, which finds and the metric index leave out.
+type TombstonesConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// GlobExpansionConfig has the brace alternatives of the globs of finds, as
+// in a.{b,c}.*, expanded into a query each, sent to the backends
+// Parallelism at a time, rather than left to the backends to expand. Globs
+// that expand into more than MaxQueries queries are refused.
+type GlobExpansionConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxQueries  int  `yaml:"maxQueries"`
+	Parallelism int  `yaml:"parallelism"`
+}
+
+// QuorumConfig marks responses partial unless backends of enough failure
+// domains, the domains of the backend groups, answered. Domains is how
+// many of the domains asked must have answered; 0 requires all of them.
+// Backends without a domain aren't counted.
+type QuorumConfig struct {
+	Domains int `yaml:"domains"`
+}
+
+// MemoryLimitConfig bounds the memory held by the renders in flight.
+type MemoryLimitConfig struct {
+	// Budget is how many bytes of fetched data all renders may hold
+	// together; 0 disables the limit.
+	Budget int64 `yaml:"budget"`
+	// QueueTimeout is how long a render waits for budget before it is
+	// rejected; 0 waits as long as the global timeout allows.
+	QueueTimeout time.Duration `yaml:"queueTimeout"`
+}
+
+// BackendGroup is a named set of backends, e.g. all the stores of a cluster.
+// Requests can be pinned to or kept away from groups by name.
+type BackendGroup struct {
+	Name     string   `yaml:"name"`
+	Region   string   `yaml:"region"`
+	Backends []string `yaml:"backends"`
+	// Transport overrides the top level transport for the group's backends.
+	Transport *Transport `yaml:"transport"`
+	// Protocol is the version of the carbonapi protocol the group's
+	// backends speak: "v2" for carbonservers and "v3" for zippers. "auto",
+	// the default, uses the richest the backends announce at
+	// /capabilities/, or tries "v3" and falls back to "v2".
+	Protocol string `yaml:"protocol"`
+	// Rewrite maps the paths asked of the group's backends to those they
+	// store their metrics under, e.g. when a cluster keeps them under a
+	// root of its own. Its backends then have only the paths it maps.
+	Rewrite []PathRewrite `yaml:"rewrite"`
+	// Domain is the failure domain of the group's backends, e.g. their
+	// rack or datacenter, which the quorum counts answers by.
+	Domain string `yaml:"domain"`
+}
+
+// PathRewrite is a rule mapping paths to those of a backend group, and
+// those of its responses back. Exactly one of StripPrefix, AddPrefix and
+// Regex is set; the first rule that matches a path maps it.
+type PathRewrite struct {
+	// StripPrefix is cut off the paths asked of the backends, and put
+	// back on those they answer.
+	StripPrefix string `yaml:"stripPrefix"`
+	// AddPrefix is put on the paths asked of the backends, and cut off
+	// those they answer.
+	AddPrefix string `yaml:"addPrefix"`
+	// Regex rewrites the paths asked of the backends with Replacement, and
+	// ReverseRegex those they answer with ReverseReplacement.
+	Regex              string `yaml:"regex"`
+	Replacement        string `yaml:"replacement"`
+	ReverseRegex       string `yaml:"reverseRegex"`
+	ReverseReplacement string `yaml:"reverseReplacement"`
+}
+
+// DNSCache configures the cache of backend hostname lookups.
+type DNSCache struct {
+	// TTL is how long a lookup is used before it is refreshed; 0 disables
+	// the cache.
+	TTL time.Duration `yaml:"ttl"`
+	// RefreshInterval also refreshes all lookups in the background; 0 only
+	// refreshes them when they are used.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// Transport tunes the HTTP connections to a set of backends.
+type Transport struct {
+	// MaxConnsPerHost caps the connections to a backend; 0 means no cap.
+	MaxConnsPerHost int `yaml:"maxConnsPerHost"`
+	// IdleConnTimeout closes connections idle for longer; 0 keeps them.
+	IdleConnTimeout    time.Duration `yaml:"idleConnTimeout"`
+	ForceAttemptHTTP2  bool          `yaml:"forceAttemptHTTP2"`
+	DisableCompression bool          `yaml:"disableCompression"`
+	// Prewarm is how many connections to open to each backend at startup.
+	Prewarm int `yaml:"prewarm"`
 }
 
 type Timeouts struct {
@@ -68,6 +346,7 @@ var DefaultConfig = Common{
 	MaxIdleConnsPerHost:       100,
 
 	ExpireDelaySec: 10 * 60,
+	PathCacheDepth: 1,
 
 	Buckets: 10,
 	Graphite: GraphiteConfig{
@@ -77,6 +356,18 @@ var DefaultConfig = Common{
 		Pattern:  "{prefix}.{fqdn}",
 	},
 	Logger: []zapwriter.Config{DefaultLoggerConfig},
+
+	MetricIndex: MetricIndexConfig{
+		RefreshInterval: 10 * time.Minute,
+		MaxAge:          30 * time.Minute,
+	},
+	Tombstones: TombstonesConfig{
+		RefreshInterval: time.Minute,
+	},
+	GlobExpansion: GlobExpansionConfig{
+		MaxQueries:  100,
+		Parallelism: 8,
+	},
 }
 
 var DefaultLoggerConfig = zapwriter.Config{
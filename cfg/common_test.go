@@ -1,9 +1,12 @@
 package cfg
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/statsd"
 )
 
 func TestParseCommon(t *testing.T) {
@@ -14,13 +17,87 @@ listen: ":8000"
 maxProcs: 32
 concurrencyLimit: 2048
 maxIdleConnsPerHost: 1024
+transport:
+    idleConnTimeout: "90s"
+    prewarm: 4
+dnsCache:
+    ttl: "1m"
 timeouts:
     global: "20s"
     afterStarted: "15s"
 graphite09compat: true
+stepPolicy: "coarsest"
+pathCacheDepth: 3
+pathCacheType: "sharded"
+pathFilter:
+    falsePositiveRate: 0.001
+incrementalProbe: true
+probe:
+    interval: "1m"
+    jitter: "10s"
+    maxBackoff: "30m"
+memoryLimit:
+    budget: 1073741824
+    queueTimeout: "2s"
+fanOut:
+    stagger: "5ms"
+    jitter: "2ms"
+    earlyStop: true
+    loadAware: true
+shadow:
+    group: "canary"
+    percent: 1
+    diffPercent: 10
+chaos:
+    "http://10.190.197.9:8080":
+        latency: "2s"
+        latencyProbability: 0.1
+        errorProbability: 0.05
+statsd:
+    address: "127.0.0.1:8125"
+    sampleRate: 0.5
+    dogstatsd: true
+    tags:
+        - "dc:eu"
+shutdown:
+    drainTimeout: "30s"
+    pathCacheFile: "/var/lib/carbonzipper/pathcache.json"
+warmup:
+    enabled: true
+    queries: 100
+    queriesFile: "/var/lib/carbonzipper/queries.json"
+histograms:
+    backend: [0.01, 0.1, 1]
+backendWeights:
+    "http://10.190.202.30:8080": 2
+region: "eu"
+zipperID: "zipper-eu:8000"
 backends:
         - "http://10.190.202.30:8080"
         - "http://10.190.197.9:8080"
+backendGroups:
+    - name: "eu-west"
+      region: "eu"
+      domain: "rack1"
+      backends:
+          - "http://10.190.202.30:8080"
+    - name: "us-east"
+      region: "us"
+      backends:
+          - "http://10.190.197.9:8080"
+      transport:
+          maxConnsPerHost: 16
+          forceAttemptHTTP2: true
+    - name: "global"
+      protocol: "auto"
+      backends:
+          - "http://zipper-us:8000"
+      rewrite:
+          - stripPrefix: "us."
+          - regex: "^legacy\\.(.*)"
+            replacement: "old.$1"
+            reverseRegex: "^old\\.(.*)"
+            reverseReplacement: "legacy.$1"
 logger:
     -
        logger: ""
@@ -41,6 +118,18 @@ logger:
 			"http://10.190.202.30:8080",
 			"http://10.190.197.9:8080",
 		},
+		Region: "eu",
+		BackendGroups: []BackendGroup{
+			{Name: "eu-west", Region: "eu", Domain: "rack1", Backends: []string{"http://10.190.202.30:8080"}},
+			{Name: "us-east", Region: "us", Backends: []string{"http://10.190.197.9:8080"},
+				Transport: &Transport{MaxConnsPerHost: 16, ForceAttemptHTTP2: true}},
+			{Name: "global", Protocol: "auto", Backends: []string{"http://zipper-us:8000"},
+				Rewrite: []PathRewrite{
+					{StripPrefix: "us."},
+					{Regex: `^legacy\.(.*)`, Replacement: "old.$1", ReverseRegex: `^old\.(.*)`, ReverseReplacement: "legacy.$1"},
+				}},
+		},
+		ZipperID: "zipper-eu:8000",
 
 		MaxProcs: 32,
 		Timeouts: Timeouts{
@@ -51,9 +140,26 @@ logger:
 		ConcurrencyLimitPerServer: 2048,
 		KeepAliveInterval:         30 * time.Second,
 		MaxIdleConnsPerHost:       1024,
+		Transport: Transport{
+			IdleConnTimeout: 90 * time.Second,
+			Prewarm:         4,
+		},
+		DNSCache: DNSCache{TTL: time.Minute},
 
-		ExpireDelaySec:             600,
+		ExpireDelaySec: 600,
+		PathCacheDepth: 3,
+		PathCacheType:  "sharded",
+		PathFilter: PathFilterConfig{
+			FalsePositiveRate: 0.001,
+		},
+		Probe: ProbeConfig{
+			Interval:   time.Minute,
+			Jitter:     10 * time.Second,
+			MaxBackoff: 30 * time.Minute,
+		},
+		IncrementalProbe:           true,
 		GraphiteWeb09Compatibility: true,
+		StepPolicy:                 "coarsest",
 
 		Buckets: 10,
 		Graphite: GraphiteConfig{
@@ -62,6 +168,58 @@ logger:
 			Interval: 60 * time.Second,
 			Prefix:   "carbon.zipper",
 		},
+		MetricIndex: MetricIndexConfig{
+			RefreshInterval: 10 * time.Minute,
+			MaxAge:          30 * time.Minute,
+		},
+		Tombstones: TombstonesConfig{
+			RefreshInterval: time.Minute,
+		},
+		GlobExpansion: GlobExpansionConfig{
+			MaxQueries:  100,
+			Parallelism: 8,
+		},
+		MemoryLimit: MemoryLimitConfig{
+			Budget:       1 << 30,
+			QueueTimeout: 2 * time.Second,
+		},
+		FanOut: FanOutConfig{
+			Stagger:   5 * time.Millisecond,
+			Jitter:    2 * time.Millisecond,
+			EarlyStop: true,
+			LoadAware: true,
+		},
+		Shadow: ShadowConfig{
+			Group:       "canary",
+			Percent:     1,
+			DiffPercent: 10,
+		},
+		StatsD: statsd.Config{
+			Address:    "127.0.0.1:8125",
+			SampleRate: 0.5,
+			DogStatsD:  true,
+			Tags:       []string{"dc:eu"},
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout:  30 * time.Second,
+			PathCacheFile: "/var/lib/carbonzipper/pathcache.json",
+		},
+		Warmup: WarmupConfig{
+			Enabled:     true,
+			Queries:     100,
+			QueriesFile: "/var/lib/carbonzipper/queries.json",
+		},
+		Histograms: HistogramsConfig{
+			Backend: []float64{0.01, 0.1, 1},
+		},
+		BackendWeights: map[string]float64{"http://10.190.202.30:8080": 2},
+		Chaos: map[string]Chaos{
+			"http://10.190.197.9:8080": {
+				Latency:            2 * time.Second,
+				LatencyProbability: 0.1,
+				ErrorProbability:   0.05,
+			},
+		},
 	}
 
 	if !eqCommon(got, expected) {
@@ -71,33 +229,96 @@ logger:
 
 type comparableCommon struct {
 	Listen                     string
+	Region                     string
+	ZipperID                   string
 	MaxProcs                   int
 	Timeouts                   Timeouts
 	ConcurrencyLimitPerServer  int
 	KeepAliveInterval          time.Duration
 	MaxIdleConnsPerHost        int
 	ExpireDelaySec             int32
+	PathCacheDepth             int
+	PathCacheType              string
+	PathFilter                 PathFilterConfig
+	Probe                      ProbeConfig
+	IncrementalProbe           bool
 	GraphiteWeb09Compatibility bool
+	StepPolicy                 string
 	Buckets                    int
 	Graphite                   GraphiteConfig
+	MetricIndex                MetricIndexConfig
+	Tombstones                 TombstonesConfig
+	MemoryLimit                MemoryLimitConfig
+	FanOut                     FanOutConfig
+	Shadow                     ShadowConfig
+	GlobExpansion              GlobExpansionConfig
+	Quorum                     QuorumConfig
+	Transport                  Transport
+	DNSCache                   DNSCache
+	Shutdown                   ShutdownConfig
+	Warmup                     WarmupConfig
 }
 
 func toComparableCommon(a Common) comparableCommon {
 	return comparableCommon{
 		Listen:                     a.Listen,
+		Region:                     a.Region,
+		ZipperID:                   a.ZipperID,
 		MaxProcs:                   a.MaxProcs,
 		Timeouts:                   a.Timeouts,
 		ConcurrencyLimitPerServer:  a.ConcurrencyLimitPerServer,
 		KeepAliveInterval:          a.KeepAliveInterval,
 		MaxIdleConnsPerHost:        a.MaxIdleConnsPerHost,
 		ExpireDelaySec:             a.ExpireDelaySec,
+		PathCacheDepth:             a.PathCacheDepth,
+		PathCacheType:              a.PathCacheType,
+		PathFilter:                 a.PathFilter,
+		Probe:                      a.Probe,
+		IncrementalProbe:           a.IncrementalProbe,
 		GraphiteWeb09Compatibility: a.GraphiteWeb09Compatibility,
+		StepPolicy:                 a.StepPolicy,
 		Buckets:                    a.Buckets,
 		Graphite:                   a.Graphite,
+		MetricIndex:                a.MetricIndex,
+		Tombstones:                 a.Tombstones,
+		MemoryLimit:                a.MemoryLimit,
+		FanOut:                     a.FanOut,
+		Shadow:                     a.Shadow,
+		GlobExpansion:              a.GlobExpansion,
+		Quorum:                     a.Quorum,
+		Transport:                  a.Transport,
+		DNSCache:                   a.DNSCache,
+		Shutdown:                   a.Shutdown,
+		Warmup:                     a.Warmup,
 	}
 }
 
 func eqCommon(a, b Common) bool {
 	return toComparableCommon(a) == toComparableCommon(b) &&
-		eqStringSlice(a.Backends, b.Backends)
+		eqStringSlice(a.Backends, b.Backends) &&
+		eqBackendGroups(a.BackendGroups, b.BackendGroups) &&
+		reflect.DeepEqual(a.BackendWeights, b.BackendWeights) &&
+		reflect.DeepEqual(a.Chaos, b.Chaos) &&
+		reflect.DeepEqual(a.StatsD, b.StatsD) &&
+		reflect.DeepEqual(a.Histograms, b.Histograms)
+}
+
+func eqBackendGroups(a, b []BackendGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Region != b[i].Region || a[i].Protocol != b[i].Protocol || a[i].Domain != b[i].Domain || !eqStringSlice(a[i].Backends, b[i].Backends) {
+			return false
+		}
+		if (a[i].Transport == nil) != (b[i].Transport == nil) || a[i].Transport != nil && *a[i].Transport != *b[i].Transport {
+			return false
+		}
+		if !reflect.DeepEqual(a[i].Rewrite, b[i].Rewrite) {
+			return false
+		}
+	}
+
+	return true
 }
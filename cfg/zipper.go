@@ -4,11 +4,15 @@ import (
 	"io"
 
 	"github.com/bookingcom/carbonapi/pathcache"
+	"github.com/bookingcom/carbonapi/pkg/intern"
 )
 
 type Zipper struct {
 	Common    `yaml:",inline"`
 	PathCache pathcache.PathCache
+	// Names interns the metric names the path cache and the zipper keep,
+	// so that the copies of a name share one allocation.
+	Names *intern.Pool `yaml:"-"`
 }
 
 func ParseZipperConfig(r io.Reader) (Zipper, error) {
@@ -18,13 +22,19 @@ func ParseZipperConfig(r io.Reader) (Zipper, error) {
 		return Zipper{}, err
 	}
 
-	return fromCommon(cfg), nil
+	z := fromCommon(cfg)
+	z.Names = intern.New()
+	if z.PathCache, err = pathcache.New(cfg.PathCacheType, cfg.ExpireDelaySec, cfg.PathCacheDepth, z.Names); err != nil {
+		return Zipper{}, err
+	}
+
+	return z, nil
 }
 
 func fromCommon(c Common) Zipper {
 	return Zipper{
 		Common:    c,
-		PathCache: pathcache.NewPathCache(c.ExpireDelaySec),
+		PathCache: pathcache.NewPathCacheDepth(c.ExpireDelaySec, c.PathCacheDepth),
 	}
 }
 
@@ -0,0 +1,336 @@
+// carbonapi-bench sends render and find requests to a carbonapi and reports
+// throughput, latency percentiles and errors.
+//
+// Targets are read from a file with one target per line, optionally prefixed
+// by a weight: "3 sumSeries(servers.*.cpu)" is picked three times as often
+// as a target of weight 1. Blank lines and lines starting with # are skipped.
+// Find queries are the targets' metric names with their last node replaced
+// by *.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type target struct {
+	expr   string
+	weight int
+}
+
+// workload picks the requests to send.
+type workload struct {
+	targets     []target
+	totalWeight int
+	ranges      []string
+	findRatio   float64
+}
+
+type request struct {
+	kind string // "render" or "find"
+	path string
+}
+
+func (w *workload) next(rnd *rand.Rand) request {
+	n := rnd.Intn(w.totalWeight)
+	var t target
+	for _, t = range w.targets {
+		if n < t.weight {
+			break
+		}
+		n -= t.weight
+	}
+
+	if rnd.Float64() < w.findRatio {
+		v := url.Values{"query": {findQuery(t.expr)}, "format": {"json"}}
+		return request{kind: "find", path: "/metrics/find/?" + v.Encode()}
+	}
+
+	v := url.Values{
+		"target": {t.expr},
+		"from":   {"-" + w.ranges[rnd.Intn(len(w.ranges))]},
+		"format": {"json"},
+	}
+	return request{kind: "render", path: "/render/?" + v.Encode()}
+}
+
+// findQuery turns the first metric name of an expression into a find query
+// for its siblings.
+func findQuery(expr string) string {
+	name := expr
+	if i := strings.LastIndex(name, "("); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, ",)"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSpace(name)
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i] + ".*"
+	}
+	return "*"
+}
+
+// stats collects the outcome of the requests of one kind.
+type stats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) add(latency time.Duration, err string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != "" {
+		s.errors[err]++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (s *stats) report(w io.Writer, kind string, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed := 0
+	for _, n := range s.errors {
+		failed += n
+	}
+	total := len(s.latencies) + failed
+	if total == 0 {
+		return
+	}
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	fmt.Fprintf(w, "%s: %d requests, %.1f/s, %d errors\n", kind, total, float64(total)/elapsed.Seconds(), failed)
+	if len(sorted) > 0 {
+		fmt.Fprintf(w, "  latency p50 %v p90 %v p99 %v max %v\n",
+			percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), sorted[len(sorted)-1])
+	}
+
+	reasons := make([]string, 0, len(s.errors))
+	for reason := range s.errors {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %6d %s\n", s.errors[reason], reason)
+	}
+}
+
+type bench struct {
+	base        string
+	client      *http.Client
+	work        *workload
+	concurrency int
+	rate        float64
+	requests    int
+	duration    time.Duration
+	seed        int64
+
+	stats map[string]*stats
+}
+
+// run sends requests until the number of requests or the duration is
+// reached, whichever is set and comes first.
+func (b *bench) run() time.Duration {
+	b.stats = map[string]*stats{"render": newStats(), "find": newStats()}
+
+	var ticks <-chan time.Time
+	if b.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / b.rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var deadline time.Time
+	if b.duration > 0 {
+		deadline = time.Now().Add(b.duration)
+	}
+
+	var mu sync.Mutex
+	sent := 0
+	more := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if b.requests > 0 && sent >= b.requests {
+			return false
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return false
+		}
+		sent++
+		return true
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			for more() {
+				if ticks != nil {
+					<-ticks
+				}
+				req := b.work.next(rnd)
+				latency, err := b.do(req.path)
+				b.stats[req.kind].add(latency, err)
+			}
+		}(rand.New(rand.NewSource(b.seed + int64(i))))
+	}
+	wg.Wait()
+
+	return time.Since(start)
+}
+
+func (b *bench) do(path string) (time.Duration, string) {
+	t0 := time.Now()
+	resp, err := b.client.Get(b.base + path)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok && uerr.Timeout() {
+			return 0, "timeout"
+		}
+		return 0, "connection error"
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(t0)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, "HTTP " + strconv.Itoa(resp.StatusCode)
+	}
+
+	return latency, ""
+}
+
+func readTargets(path string) ([]target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []target
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		t := target{expr: line, weight: 1}
+		if fields := strings.SplitN(line, " ", 2); len(fields) == 2 {
+			if w, err := strconv.Atoi(fields[0]); err == nil {
+				if w <= 0 {
+					return nil, fmt.Errorf("bad weight in %q", line)
+				}
+				t = target{expr: strings.TrimSpace(fields[1]), weight: w}
+			}
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, scanner.Err()
+}
+
+func newWorkload(targets []target, ranges string, findRatio float64) (*workload, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+
+	w := &workload{targets: targets, findRatio: findRatio}
+	for _, t := range targets {
+		w.totalWeight += t.weight
+	}
+	for _, r := range strings.Split(ranges, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			w.ranges = append(w.ranges, strings.TrimPrefix(r, "-"))
+		}
+	}
+	if len(w.ranges) == 0 {
+		return nil, fmt.Errorf("no time ranges")
+	}
+
+	return w, nil
+}
+
+func main() {
+	base := flag.String("url", "http://localhost:8081", "Base `URL` of the carbonapi.")
+	targetsFile := flag.String("targets", "", "`File` with the targets to render, one per line, optionally prefixed by a weight.")
+	ranges := flag.String("ranges", "1h,6h,1d,7d", "Comma separated time ranges renders ask for, picked at random.")
+	findRatio := flag.Float64("find-ratio", 0.1, "Share of requests that are finds.")
+	concurrency := flag.Int("concurrency", 8, "Requests in flight at the same time.")
+	rate := flag.Float64("rate", 0, "Requests per second, 0 for as fast as possible.")
+	requests := flag.Int("requests", 0, "Requests to send, 0 for no limit.")
+	duration := flag.Duration("duration", time.Minute, "How long to run, 0 for no limit.")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout of each request.")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "Seed of the request mix.")
+	flag.Parse()
+
+	if *targetsFile == "" || *concurrency <= 0 || (*requests <= 0 && *duration <= 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	targets, err := readTargets(*targetsFile)
+	if err != nil {
+		log.Fatalf("reading targets: %v", err)
+	}
+	work, err := newWorkload(targets, *ranges, *findRatio)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b := &bench{
+		base: strings.TrimSuffix(*base, "/"),
+		client: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+		},
+		work:        work,
+		concurrency: *concurrency,
+		rate:        *rate,
+		requests:    *requests,
+		duration:    *duration,
+		seed:        *seed,
+	}
+
+	elapsed := b.run()
+	fmt.Printf("ran for %v\n", elapsed.Round(time.Millisecond))
+	b.stats["render"].report(os.Stdout, "render", elapsed)
+	b.stats["find"].report(os.Stdout, "find", elapsed)
+}
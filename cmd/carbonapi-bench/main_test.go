@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFindQuery(t *testing.T) {
+	tests := map[string]string{
+		"foo.bar.baz":                     "foo.bar.*",
+		"sumSeries(foo.bar.*)":            "foo.bar.*",
+		"scale(divideSeries(a.b,c.d), 2)": "a.*",
+		"foo":                             "*",
+	}
+
+	for expr, want := range tests {
+		if got := findQuery(expr); got != want {
+			t.Errorf("findQuery(%q) = %q, want %q", expr, got, want)
+		}
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	f, err := ioutil.TempFile("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\n3 sumSeries(a.*)\n\nb.c\n")
+	f.Close()
+
+	targets, err := readTargets(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []target{{"sumSeries(a.*)", 3}, {"b.c", 1}}
+	if len(targets) != len(want) || targets[0] != want[0] || targets[1] != want[1] {
+		t.Errorf("got %v, want %v", targets, want)
+	}
+}
+
+func TestBench(t *testing.T) {
+	var renders, finds int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/render/":
+			if atomic.AddInt64(&renders, 1)%5 == 0 {
+				http.Error(w, "oops", http.StatusInternalServerError)
+				return
+			}
+		case "/metrics/find/":
+			atomic.AddInt64(&finds, 1)
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	work, err := newWorkload([]target{{"a.b", 1}, {"sumSeries(c.*)", 2}}, "1h,1d", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bench{
+		base:        server.URL,
+		client:      &http.Client{Timeout: time.Second},
+		work:        work,
+		concurrency: 4,
+		requests:    100,
+		seed:        1,
+	}
+	elapsed := b.run()
+
+	if got := atomic.LoadInt64(&renders) + atomic.LoadInt64(&finds); got != 100 {
+		t.Errorf("sent %d requests, want 100", got)
+	}
+
+	var out bytes.Buffer
+	b.stats["render"].report(&out, "render", elapsed)
+	b.stats["find"].report(&out, "find", elapsed)
+	for _, s := range []string{"render: ", "find: ", "latency p50", "HTTP 500"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report misses %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestWorkloadMix(t *testing.T) {
+	work, err := newWorkload([]target{{"a.b", 9}, {"c.d", 1}}, "-1h", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	heavy := 0
+	for i := 0; i < 1000; i++ {
+		req := work.next(rnd)
+		if req.kind != "render" || !strings.Contains(req.path, "from=-1h") {
+			t.Fatalf("unexpected request %+v", req)
+		}
+		if strings.Contains(req.path, "target=a.b") {
+			heavy++
+		}
+	}
+
+	if heavy < 850 || heavy > 950 {
+		t.Errorf("a.b picked %d times out of 1000, want about 900", heavy)
+	}
+}
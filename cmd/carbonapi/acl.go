@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/util"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"go.uber.org/zap"
+)
+
+// aclHandler gives the request the roles of its verified user, which
+// aclZipper checks the requested paths against.
+func aclHandler(h http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.acl == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(acl.NewContext(r.Context(), config.acl.Principal(requestUser(r)))))
+	})
+}
+
+// aclZipper leaves out of responses the paths the user of the request may
+// not read, and refuses info on them.
+type aclZipper struct {
+	CarbonZipper
+	acl *acl.ACL
+}
+
+// auditDenied logs a path the user of ctx was refused.
+func auditDenied(ctx context.Context, p acl.Principal, op, path string) {
+	apiMetrics.ACLRejections.Add(1)
+	scrubbedLogger("acl").Warn("access denied",
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+		zap.String("username", p.User),
+		zap.Strings("roles", p.Roles),
+		zap.String("operation", op),
+		zap.String("path", path),
+	)
+}
+
+func (z aclZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	p, ok := acl.FromContext(ctx)
+	resp, err := z.CarbonZipper.Find(ctx, metric)
+	if !ok {
+		return resp, err
+	}
+
+	matches := resp.Matches[:0]
+	for _, m := range resp.Matches {
+		visible := z.acl.Allowed(p, m.Path)
+		if !m.IsLeaf {
+			visible = z.acl.Visible(p, m.Path)
+		}
+		if !visible {
+			auditDenied(ctx, p, "find", m.Path)
+			continue
+		}
+		matches = append(matches, m)
+	}
+	resp.Matches = matches
+
+	return resp, err
+}
+
+func (z aclZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	if p, ok := acl.FromContext(ctx); ok && !z.acl.Allowed(p, metric) {
+		auditDenied(ctx, p, "info", metric)
+		return nil, acl.ErrDenied
+	}
+
+	return z.CarbonZipper.Info(ctx, metric)
+}
+
+func (z aclZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	p, ok := acl.FromContext(ctx)
+	data, err := z.CarbonZipper.Render(ctx, metrics, from, until)
+	if !ok {
+		return data, err
+	}
+
+	// Globs may be sent as they are, so the series are checked rather than
+	// the requested paths.
+	result := data[:0]
+	for _, d := range data {
+		if !z.acl.Allowed(p, d.Name) {
+			auditDenied(ctx, p, "render", d.Name)
+			continue
+		}
+		result = append(result, d)
+	}
+	if err == nil && len(result) == 0 {
+		err = errNoMetrics
+	}
+
+	return result, err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/signature"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLZipper(t *testing.T) {
+	a, err := acl.New(acl.Config{
+		DenyByDefault: true,
+		Roles:         map[string]acl.Role{"team-a": {Allow: []string{"teams.a"}}},
+		Users:         map[string][]string{"alice": {"team-a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := aclZipper{CarbonZipper: &namespacedZipper{}, acl: a}
+	ctx := acl.NewContext(context.Background(), a.Principal("alice"))
+	rejected := apiMetrics.ACLRejections.Value()
+
+	glob, err := z.Find(ctx, "teams.*.foo.bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []pb.GlobMatch{{Path: "teams.a.foo.bar", IsLeaf: true}}, glob.Matches)
+
+	data, err := z.Render(ctx, []string{"teams.*.foo.bar"}, 0, 120)
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, "teams.a.foo.bar", data[0].Name)
+	}
+
+	_, err = z.Info(ctx, "teams.b.foo.bar")
+	assert.Equal(t, acl.ErrDenied, err)
+
+	assert.Equal(t, rejected+3, apiMetrics.ACLRejections.Value())
+
+	// without a principal, e.g. ACLs set up after the request came in
+	glob, err = z.Find(context.Background(), "teams.*.foo.bar")
+	assert.NoError(t, err)
+	assert.Len(t, glob.Matches, 2)
+}
+
+func TestInfoHandlerACL(t *testing.T) {
+	a, err := acl.New(acl.Config{
+		DenyByDefault: true,
+		Roles:         map[string]acl.Role{"team-a": {Allow: []string{"teams.a"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(origACL *acl.ACL, origZipper CarbonZipper) {
+		config.acl = origACL
+		config.zipper = origZipper
+	}(config.acl, config.zipper)
+	config.acl = a
+	config.zipper = aclZipper{CarbonZipper: config.zipper, acl: a}
+
+	req, rr := setUpRequest(t, "/info/?target=foo.bar")
+	aclHandler(http.HandlerFunc(infoHandler))(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestACLHandlerIdentity(t *testing.T) {
+	a, err := acl.New(acl.Config{
+		Roles: map[string]acl.Role{"team-a": {Allow: []string{"teams.a"}}},
+		Users: map[string][]string{"alice": {"team-a"}, "exporter": {"team-a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(origACL *acl.ACL, origIdentity cfg.IdentityConfig) {
+		config.acl = origACL
+		config.Identity = origIdentity
+	}(config.acl, config.Identity)
+	config.acl = a
+
+	var got acl.Principal
+	h := aclHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = acl.FromContext(r.Context())
+	}))
+
+	// basic auth usernames are not verified
+	req, rr := setUpRequest(t, "/render/?target=teams.a.foo")
+	req.SetBasicAuth("alice", "anything")
+	req.Header.Set("X-Webauth-User", "alice")
+	h(rr, req)
+	assert.Equal(t, "", got.User)
+	assert.Empty(t, got.Roles)
+
+	config.Identity.TrustedHeader = "X-Webauth-User"
+	h(rr, req)
+	assert.Equal(t, "alice", got.User)
+	assert.Equal(t, []string{"team-a"}, got.Roles)
+
+	req, rr = setUpRequest(t, "/render/?target=teams.a.foo")
+	req.Header.Set("X-Webauth-User", "alice")
+	h(rr, req.WithContext(signature.NewContext(req.Context(), "exporter")))
+	assert.Equal(t, "exporter", got.User)
+}
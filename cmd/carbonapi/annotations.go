@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/pkg/events"
+)
+
+// annotationsRequest is what Grafana's simple-json datasource POSTs to
+// /annotations. The query of the annotation is the tags of the events to
+// mark, all of them if empty.
+type annotationsRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+	// Query is read from the annotation.
+	Query string `json:"-"`
+}
+
+// annotation is an event as the simple-json datasource reads it.
+type annotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	// Time is in milliseconds.
+	Time  int64    `json:"time"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+// annotationsHandler serves the events as annotations to Grafana's
+// simple-json datasource, which tests its connection with a GET of /, which
+// usageHandler answers.
+func annotationsHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "annotations", &config.API)
+	accessLogDetails.Format = jsonFormat
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		accessLogDetails.HttpCode = http.StatusMethodNotAllowed
+		accessLogDetails.Reason = "only POST is supported"
+		logAsError = true
+		return
+	}
+
+	store := events.Default()
+	if store == nil {
+		http.Error(w, events.ErrNotConfigured.Error(), http.StatusNotImplemented)
+		accessLogDetails.HttpCode = http.StatusNotImplemented
+		accessLogDetails.Reason = events.ErrNotConfigured.Error()
+		logAsError = true
+		return
+	}
+
+	req, err := parseAnnotationsRequest(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	query := events.Query{
+		From:  int32(req.Range.From.Unix()),
+		Until: int32(req.Range.To.Unix()),
+		Tags:  events.ParseTags(strings.Replace(req.Query, "#", " ", -1)),
+	}
+	accessLogDetails.From = query.From
+	accessLogDetails.Until = query.Until
+
+	evs, err := store.Find(ctx, query)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	annotations := make([]annotation, 0, len(evs))
+	for _, ev := range evs {
+		tags := ev.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		annotations = append(annotations, annotation{
+			Annotation: req.Annotation,
+			Time:       ev.When * 1000,
+			Title:      ev.What,
+			Text:       ev.Data,
+			Tags:       tags,
+		})
+	}
+
+	b, err := json.Marshal(annotations)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	writeResponse(w, b, jsonFormat, "")
+	accessLogDetails.HttpCode = http.StatusOK
+}
+
+func parseAnnotationsRequest(r *http.Request) (annotationsRequest, error) {
+	var req annotationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, err
+	}
+	if len(req.Annotation) == 0 {
+		req.Annotation = json.RawMessage("null")
+		return req, nil
+	}
+
+	var a struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(req.Annotation, &a); err != nil {
+		return req, err
+	}
+	req.Query = a.Query
+
+	return req, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Renders can be attributed to the Grafana dashboards they come from, to
+// tell which dashboard loads the backends. The dashboard is named by a
+// header, or else taken from the Referer, e.g. https://grafana/d/<uid>/name.
+
+// otherDashboards stands in for the dashboards beyond
+// attribution.maxDashboards, which bounds the label values of the metrics.
+const otherDashboards = "other"
+
+var dashboardMetrics = struct {
+	Requests   *prometheus.CounterVec
+	Seconds    *prometheus.CounterVec
+	Datapoints *prometheus.CounterVec
+}{
+	Requests: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dashboard_render_requests_total",
+			Help: "Count of render requests, partitioned by dashboard",
+		},
+		[]string{"dashboard"},
+	),
+	Seconds: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dashboard_render_seconds_total",
+			Help: "Time spent serving render requests, partitioned by dashboard",
+		},
+		[]string{"dashboard"},
+	),
+	Datapoints: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dashboard_render_datapoints_total",
+			Help: "Datapoints returned by render requests, partitioned by dashboard",
+		},
+		[]string{"dashboard"},
+	),
+}
+
+// attributed are the dashboards metrics are kept for.
+var attributed = struct {
+	sync.Mutex
+	dashboards map[string]struct{}
+}{dashboards: make(map[string]struct{})}
+
+// dashboardOf returns the dashboard r comes from, "" if it can't tell or
+// attribution is disabled.
+func dashboardOf(r *http.Request) string {
+	if !config.Attribution.Enabled {
+		return ""
+	}
+
+	if id := r.Header.Get(config.Attribution.Header); id != "" {
+		return id
+	}
+
+	return dashboardFromReferer(r.Referer())
+}
+
+// dashboardFromReferer returns the UID of the Grafana dashboard at referer,
+// or the slug of those of Grafana before 5.0.
+func dashboardFromReferer(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		switch parts[i] {
+		case "d", "d-solo":
+			return parts[i+1]
+		case "dashboard", "dashboard-solo":
+			if i+2 < len(parts) && (parts[i+1] == "db" || parts[i+1] == "script") {
+				return parts[i+2]
+			}
+		}
+	}
+
+	return ""
+}
+
+// attributeRender counts a render of dashboard that took d and returned
+// points.
+func attributeRender(dashboard string, d time.Duration, points int) {
+	if dashboard == "" {
+		return
+	}
+
+	attributed.Lock()
+	if _, ok := attributed.dashboards[dashboard]; !ok {
+		if len(attributed.dashboards) >= config.Attribution.MaxDashboards {
+			dashboard = otherDashboards
+		} else {
+			attributed.dashboards[dashboard] = struct{}{}
+		}
+	}
+	attributed.Unlock()
+
+	apiMetrics.DashboardRequests.Add(dashboard, 1)
+	apiMetrics.DashboardRuntimeNS.Add(dashboard, d.Nanoseconds())
+	apiMetrics.DashboardDatapoints.Add(dashboard, int64(points))
+
+	dashboardMetrics.Requests.WithLabelValues(dashboard).Inc()
+	dashboardMetrics.Seconds.WithLabelValues(dashboard).Add(d.Seconds())
+	dashboardMetrics.Datapoints.WithLabelValues(dashboard).Add(float64(points))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestDashboardOf(t *testing.T) {
+	defer func(c cfg.AttributionConfig) { config.Attribution = c }(config.Attribution)
+	config.Attribution = cfg.AttributionConfig{Enabled: true, Header: "X-Dashboard-Id", MaxDashboards: 10}
+
+	for _, c := range []struct {
+		header, referer, exp string
+	}{
+		{"abc", "https://grafana/d/xyz/name", "abc"},
+		{"", "https://grafana/d/xyz/name?orgId=1", "xyz"},
+		{"", "https://grafana/d-solo/xyz/name?panelId=2", "xyz"},
+		{"", "https://grafana/grafana/d/xyz", "xyz"},
+		{"", "https://grafana/dashboard/db/old-name", "old-name"},
+		{"", "https://grafana/explore", ""},
+		{"", "", ""},
+	} {
+		r := httptest.NewRequest("GET", "/render", nil)
+		r.Header.Set("X-Dashboard-Id", c.header)
+		r.Header.Set("Referer", c.referer)
+		if got := dashboardOf(r); got != c.exp {
+			t.Errorf("dashboardOf(%q, %q) = %q, want %q", c.header, c.referer, got, c.exp)
+		}
+	}
+
+	config.Attribution.Enabled = false
+	r := httptest.NewRequest("GET", "/render", nil)
+	r.Header.Set("X-Dashboard-Id", "abc")
+	if got := dashboardOf(r); got != "" {
+		t.Errorf("Expected no attribution when disabled, got %q", got)
+	}
+}
+
+func TestAttributeRender(t *testing.T) {
+	defer func(c cfg.AttributionConfig) { config.Attribution = c }(config.Attribution)
+	config.Attribution.MaxDashboards = len(attributed.dashboards) + 1
+
+	attributeRender("first", time.Second, 10)
+	attributeRender("first", time.Second, 5)
+	attributeRender("second", time.Second, 1)
+
+	if got := apiMetrics.DashboardDatapoints.Get("first").String(); got != "15" {
+		t.Errorf("Expected 15 points for first, got %s", got)
+	}
+	if apiMetrics.DashboardRequests.Get("second") != nil {
+		t.Error("Expected dashboards beyond the limit to be counted as other")
+	}
+	if apiMetrics.DashboardRequests.Get(otherDashboards) == nil {
+		t.Error("Expected other to be counted")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/pkg/blocklist"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/signature"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// blocked holds the blocklist, which /reload-blocklist reads again from
+// its file.
+type blocked struct {
+	mu   sync.Mutex
+	list *blocklist.List
+}
+
+func (b *blocked) get() *blocklist.List {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.list
+}
+
+func (b *blocked) set(l *blocklist.List) {
+	b.mu.Lock()
+	b.list = l
+	b.mu.Unlock()
+}
+
+// blockedError is the error of requests for a metric the blocklist blocks.
+type blockedError struct {
+	metric  string
+	pattern string
+}
+
+func (e blockedError) Error() string {
+	return fmt.Sprintf("%s is blocked by %s", e.metric, e.pattern)
+}
+
+type blocklistOverrideKey struct{}
+
+// withBlocklistOverride lets the request through the blocklist if it has
+// the override header and may use it.
+func withBlocklistOverride(r *http.Request) *http.Request {
+	if !mayOverrideBlocklist(r) {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), blocklistOverrideKey{}, true))
+}
+
+// mayOverrideBlocklist tells if r has the override header set to the
+// override secret, or set at all by one of the override clients.
+func mayOverrideBlocklist(r *http.Request) bool {
+	b := config.Blocklist
+	if b.OverrideHeader == "" {
+		return false
+	}
+	v := r.Header.Get(b.OverrideHeader)
+	if v == "" {
+		return false
+	}
+
+	if b.OverrideSecret != "" && subtle.ConstantTimeCompare([]byte(v), []byte(b.OverrideSecret)) == 1 {
+		return true
+	}
+	if client := signature.FromContext(r.Context()); client != "" {
+		for _, c := range b.OverrideClients {
+			if c == client {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkBlocklist returns a blockedError for the first of metrics the
+// blocklist blocks, unless the request of ctx overrides it.
+func checkBlocklist(ctx context.Context, metrics ...string) error {
+	if config.blocked == nil || ctx.Value(blocklistOverrideKey{}) != nil {
+		return nil
+	}
+
+	l := config.blocked.get()
+	for _, m := range metrics {
+		if pattern, ok := l.Blocked(m); ok {
+			apiMetrics.BlockedRequests.Add(1)
+			scrubbedLogger("blocklist").Warn("request blocked",
+				zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+				zap.String("metric", m),
+				zap.String("pattern", pattern),
+			)
+			return blockedError{metric: m, pattern: pattern}
+		}
+	}
+
+	return nil
+}
+
+// checkBlocklistExpr is checkBlocklist of the metrics exp fetches.
+func checkBlocklistExpr(ctx context.Context, exp parser.Expr) error {
+	ms := exp.Metrics()
+	metrics := make([]string, 0, len(ms))
+	for _, m := range ms {
+		metrics = append(metrics, m.Metric)
+	}
+
+	return checkBlocklist(ctx, metrics...)
+}
+
+// reloadBlocklist reads the blocklist again from its file. A blocklist
+// that fails to read is reported, and the one read before kept.
+func reloadBlocklist(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+	apiMetrics.Requests.Add(1)
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "reloadBlocklist", &config.API)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	if config.blocked == nil {
+		http.Error(w, "no blocklist file is configured", http.StatusNotFound)
+		accessLogDetails.HttpCode = http.StatusNotFound
+		logAsError = true
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		accessLogDetails.HttpCode = http.StatusMethodNotAllowed
+		logAsError = true
+		return
+	}
+
+	l, err := blocklist.Load(config.Blocklist.File)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	config.blocked.set(l)
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	fmt.Fprintf(w, `{"patterns":%d}`, l.Len())
+}
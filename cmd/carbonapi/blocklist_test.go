@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cache"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/blocklist"
+	"github.com/bookingcom/carbonapi/pkg/signature"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklist(t *testing.T) {
+	l, err := blocklist.New([]blocklist.Pattern{{Glob: "foo.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(b *blocked, c cfg.BlocklistConfig) {
+		config.blocked = b
+		config.Blocklist = c
+	}(config.blocked, config.Blocklist)
+	config.blocked = &blocked{list: l}
+	config.Blocklist.OverrideHeader = "X-Override-Blocklist"
+	config.Blocklist.OverrideSecret = "s3cret"
+	config.Blocklist.OverrideClients = []string{"oncall"}
+
+	render := validateRequest(http.HandlerFunc(renderHandler), "render")
+	req, rr := setUpRequest(t, "/render/?target=sumSeries(foo.bar)&format=json&noCache=1")
+	render.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "foo.bar is blocked by foo.*")
+
+	req, rr = setUpRequest(t, "/render/?target=sumSeries(foo.bar)&format=json&noCache=1")
+	req.Header.Set("X-Override-Blocklist", "1")
+	render.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "anyone may not override the blocklist")
+
+	req, rr = setUpRequest(t, "/render/?target=sumSeries(foo.bar)&format=json&noCache=1")
+	req.Header.Set("X-Override-Blocklist", "s3cret")
+	render.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=sumSeries(foo.bar)&format=json&noCache=1")
+	req.Header.Set("X-Override-Blocklist", "1")
+	render.ServeHTTP(rr, req.WithContext(signature.NewContext(req.Context(), "oncall")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=sumSeries(foo.bar)&format=json&noCache=1")
+	req.Header.Set("X-Override-Blocklist", "1")
+	render.ServeHTTP(rr, req.WithContext(signature.NewContext(req.Context(), "exporter")))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo.*")
+	validateRequest(http.HandlerFunc(findHandler), "find").ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo")
+	validateRequest(http.HandlerFunc(findHandler), "find").ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestBlocklistCachedRender(t *testing.T) {
+	defer func(b *blocked, qc cache.BytesCache) {
+		config.blocked, config.queryCache = b, qc
+	}(config.blocked, config.queryCache)
+	config.blocked = nil
+	config.queryCache = cache.NewExpireCache(1024 * 1024)
+
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&format=json")
+	renderHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, config.queryCache.(*cache.ExpireCache).Items())
+
+	l, err := blocklist.New([]blocklist.Pattern{{Glob: "foo.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.blocked = &blocked{list: l}
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&format=json")
+	renderHandler(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "blocked targets aren't served from the cache")
+}
+
+func TestReloadBlocklist(t *testing.T) {
+	f, err := ioutil.TempFile("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("- glob: \"*.*.*.*\"\n- regex: \"^foo\\\\.\"\n")
+	f.Close()
+
+	defer func(b *blocked, file string) {
+		config.blocked = b
+		config.Blocklist.File = file
+	}(config.blocked, config.Blocklist.File)
+	config.blocked = &blocked{list: &blocklist.List{}}
+	config.Blocklist.File = f.Name()
+
+	req, rr := setUpRequest(t, "/reload-blocklist")
+	reloadBlocklist(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	req, rr = setUpRequest(t, "/reload-blocklist")
+	req.Method = http.MethodPost
+	reloadBlocklist(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"patterns":2}`, rr.Body.String())
+	assert.Equal(t, 2, config.blocked.get().Len())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// stage is a step of a render that gets its share of the request timeout.
+type stage int
+
+const (
+	stageFind stage = iota
+	stageFetch
+	stageEval
+)
+
+// stageContext bounds a stage of a render by its share of the time left
+// before the request deadline. The shares are those of the stage and of the
+// stages after it, so time a stage doesn't use goes to the next ones and the
+// last stage gets whatever is left. Without a configured budget, or a
+// deadline, stages run until the request deadline.
+func stageContext(ctx context.Context, s stage) (context.Context, context.CancelFunc) {
+	b := config.TimeoutBudget
+	shares := [...]float64{b.Find, b.Fetch, b.Eval}
+
+	var total float64
+	for _, share := range shares[s:] {
+		total += share
+	}
+
+	deadline, ok := ctx.Deadline()
+	if total <= 0 || !ok {
+		return context.WithCancel(ctx)
+	}
+
+	left := time.Until(deadline)
+
+	return context.WithTimeout(ctx, time.Duration(float64(left)*shares[s]/total))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+
+	"go.uber.org/zap"
+)
+
+func TestStageContext(t *testing.T) {
+	defer func(b cfg.TimeoutBudget) {
+		config.TimeoutBudget = b
+	}(config.TimeoutBudget)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	left := func(ctx context.Context) time.Duration {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("expected a deadline")
+		}
+		return time.Until(deadline)
+	}
+	near := func(got, want time.Duration) bool {
+		return got > want-100*time.Millisecond && got <= want
+	}
+
+	config.TimeoutBudget = cfg.TimeoutBudget{}
+	find, cancelFind := stageContext(ctx, stageFind)
+	defer cancelFind()
+	if !near(left(find), 8*time.Second) {
+		t.Errorf("expected the whole timeout without a budget, got %v", left(find))
+	}
+
+	config.TimeoutBudget = cfg.TimeoutBudget{Find: 1, Fetch: 2, Eval: 1}
+	find, cancelFind = stageContext(ctx, stageFind)
+	defer cancelFind()
+	if !near(left(find), 2*time.Second) {
+		t.Errorf("expected a quarter of the timeout to find, got %v", left(find))
+	}
+
+	// A find done right away leaves its time to the next stages.
+	fetch, cancelFetch := stageContext(ctx, stageFetch)
+	defer cancelFetch()
+	if want := 8 * time.Second * 2 / 3; !near(left(fetch), want) {
+		t.Errorf("expected %v to fetch, got %v", want, left(fetch))
+	}
+
+	eval, cancelEval := stageContext(ctx, stageEval)
+	defer cancelEval()
+	if !near(left(eval), 8*time.Second) {
+		t.Errorf("expected eval to get all the time left, got %v", left(eval))
+	}
+}
+
+func TestEvalTargetsDeadline(t *testing.T) {
+	metricMap := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo", From: 0, Until: 1}: {types.MakeMetricData("foo", []float64{1}, 1, 0)},
+	}
+	exp, _, err := parser.ParseExpr("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := evalTargets(ctx, []evalTarget{{target: "foo", exp: exp}}, 0, 1, metricMap, 1, zap.NewNop())
+	if results[0].err != context.Canceled {
+		t.Errorf("expected targets not to be evaluated once time is up, got %v", results[0].err)
+	}
+}
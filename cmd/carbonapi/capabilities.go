@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+)
+
+// The formats the endpoints serve, as the format parameter takes them.
+var (
+	renderFormats = []string{jsonFormat, protobufFormat, protobuf3Format, pickleFormat, rawFormat, csvFormat, pngFormat, svgFormat, histogramFormat, arrowFormat, parquetFormat}
+	findFormats   = []string{treejsonFormat, jsonFormat, rawFormat, protobufFormat, protobuf3Format, pickleFormat}
+	infoFormats   = []string{jsonFormat, protobufFormat, protobuf3Format}
+	checkFormats  = []string{jsonFormat, nagiosFormat}
+)
+
+// capabilities describe what this carbonapi supports, for clients to
+// detect features with rather than assume them per deployment.
+type capabilities struct {
+	BuildVersion    string              `json:"buildVersion"`
+	GraphiteVersion string              `json:"graphiteVersion"`
+	Formats         map[string][]string `json:"formats"`
+	// Functions are evaluated in carbonapi, ProxiedFunctions by graphite-web.
+	Functions        []string        `json:"functions"`
+	ProxiedFunctions []string        `json:"proxiedFunctions"`
+	Features         map[string]bool `json:"features"`
+}
+
+// graphiteVersion is the graphite-web version carbonapi answers as.
+func graphiteVersion() string {
+	if config.GraphiteWeb09Compatibility {
+		return "0.9.15"
+	}
+
+	return "1.0.0"
+}
+
+// functionNames returns the sorted names of the functions available,
+// native and proxied to graphite-web.
+func functionNames() (native, proxied []string) {
+	native, proxied = make([]string, 0), make([]string, 0)
+
+	metadata.FunctionMD.RLock()
+	for name, d := range metadata.FunctionMD.Descriptions {
+		if d.Proxied {
+			proxied = append(proxied, name)
+		} else {
+			native = append(native, name)
+		}
+	}
+	metadata.FunctionMD.RUnlock()
+
+	sort.Strings(native)
+	sort.Strings(proxied)
+
+	return native, proxied
+}
+
+func currentCapabilities() capabilities {
+	native, proxied := functionNames()
+
+	return capabilities{
+		BuildVersion:    BuildVersion,
+		GraphiteVersion: graphiteVersion(),
+		Formats: map[string][]string{
+			"render": renderFormats,
+			"find":   findFormats,
+			"info":   infoFormats,
+			"check":  checkFormats,
+		},
+		Functions:        native,
+		ProxiedFunctions: proxied,
+		Features: map[string]bool{
+			"graphOptions": png.HaveGraphSupport,
+			"renderStream": true,
+			"dashboards":   config.dashboards != nil,
+			"acl":          config.acl != nil,
+			"tenancy":      config.tenancy != nil,
+			"metricsPut":   config.MetricsPut.Enabled,
+		},
+	}
+}
+
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "capabilities", &config.API)
+	accessLogDetails.Format = jsonFormat
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	b, err := json.Marshal(currentCapabilities())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	writeResponse(w, b, jsonFormat, r.FormValue("jsonp"))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	req, rr := setUpRequest(t, "/capabilities/")
+	capabilitiesHandler(rr, req)
+
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, contentTypeJSON, rr.Header().Get("Content-Type"))
+
+	var caps capabilities
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &caps))
+	assert.Equal(t, BuildVersion, caps.BuildVersion)
+	assert.Equal(t, "1.0.0", caps.GraphiteVersion)
+	assert.Contains(t, caps.Functions, "sumSeries")
+	assert.Contains(t, caps.Formats["render"], "pickle")
+	assert.Contains(t, caps.Formats["find"], "treejson")
+	assert.False(t, caps.Features["dashboards"])
+}
+
+func TestVersionHandler(t *testing.T) {
+	req, rr := setUpRequest(t, "/version/")
+	versionHandler(rr, req)
+
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, "1.0.0\n", rr.Body.String())
+
+	req, rr = setUpRequest(t, "/version/?format=json")
+	versionHandler(rr, req)
+
+	assert.Equal(t, 200, rr.Code)
+	var info versionInfo
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, "1.0.0", info.Version)
+	assert.Equal(t, BuildVersion, info.BuildVersion)
+	assert.Contains(t, info.Protocols, "protobuf")
+	assert.Contains(t, info.Functions, "sumSeries")
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// nagiosFormat answers /check with the output of a nagios plugin.
+const nagiosFormat = "nagios"
+
+// The statuses of checks, by their nagios exit codes.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatusNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+type checkSeries struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Value  *float64 `json:"value"`
+	Time   int32    `json:"time,omitempty"`
+
+	code int
+}
+
+type checkResult struct {
+	Status  string        `json:"status"`
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Series  []checkSeries `json:"series"`
+}
+
+// checkThresholds tells the status of values. Values past warn are warnings
+// and past crit critical, where past is above if crit is at least warn, and
+// below otherwise.
+type checkThresholds struct {
+	warn, crit float64
+}
+
+func (t checkThresholds) status(v float64) int {
+	past := func(threshold float64) bool {
+		if t.crit >= t.warn {
+			return v >= threshold
+		}
+		return v <= threshold
+	}
+
+	switch {
+	case past(t.crit):
+		return checkCritical
+	case past(t.warn):
+		return checkWarning
+	}
+
+	return checkOK
+}
+
+// evalCheck checks the latest value of each series, consolidated as it is
+// drawn. The check has the worst status of its series, and is unknown
+// without any.
+func evalCheck(data []*types.MetricData, t checkThresholds) checkResult {
+	res := checkResult{Code: checkOK, Series: make([]checkSeries, 0, len(data))}
+	if len(data) == 0 {
+		res.Code = checkUnknown
+	}
+
+	var problems []string
+	for _, d := range data {
+		s := checkSeries{Name: d.Name, code: checkUnknown}
+
+		values, absent := d.AggregatedValues(), d.AggregatedAbsent()
+		for i := len(values) - 1; i >= 0; i-- {
+			if absent[i] || math.IsNaN(values[i]) {
+				continue
+			}
+			v := values[i]
+			s.Value = &v
+			s.Time = d.StartTime + int32(i)*d.AggregatedTimeStep()
+			s.code = t.status(v)
+			break
+		}
+		s.Status = checkStatusNames[s.code]
+
+		if s.code > res.Code {
+			res.Code = s.code
+		}
+		if s.code != checkOK {
+			problems = append(problems, s.String())
+		}
+		res.Series = append(res.Series, s)
+	}
+
+	res.Status = checkStatusNames[res.Code]
+	switch {
+	case len(data) == 0:
+		res.Message = "no series"
+	case len(problems) == 0:
+		res.Message = fmt.Sprintf("%d series OK", len(data))
+	default:
+		res.Message = strings.Join(problems, ", ")
+	}
+
+	return res
+}
+
+func (s checkSeries) String() string {
+	if s.Value == nil {
+		return s.Name + " has no value"
+	}
+
+	return s.Name + " is " + strconv.FormatFloat(*s.Value, 'f', -1, 64)
+}
+
+// nagios formats r as the output of a nagios plugin, with the values of
+// the series as performance data.
+func (r checkResult) nagios(t checkThresholds) string {
+	var b strings.Builder
+	b.WriteString(r.Status + " - " + r.Message)
+
+	sep := " | "
+	for _, s := range r.Series {
+		if s.Value == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s'%s'=%s;%s;%s", sep, strings.Replace(s.Name, "'", "''", -1),
+			strconv.FormatFloat(*s.Value, 'f', -1, 64),
+			strconv.FormatFloat(t.warn, 'f', -1, 64),
+			strconv.FormatFloat(t.crit, 'f', -1, 64))
+		sep = " "
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// checkHandler evaluates the target and compares the latest value of its
+// series against the warn and crit thresholds, for pollers to alert on.
+func checkHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = jsonFormat
+	}
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "check", &config.API)
+	accessLogDetails.Format = format
+	logger := scrubbedLogger("check").With(
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+		zap.String("username", accessLogDetails.Username),
+	)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	fail := func(code int, msg string) {
+		http.Error(w, http.StatusText(code)+": "+msg, code)
+		accessLogDetails.HttpCode = int32(code)
+		accessLogDetails.Reason = msg
+		logAsError = true
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if format != jsonFormat && format != nagiosFormat {
+		fail(http.StatusBadRequest, "unsupported format "+format)
+		return
+	}
+
+	target := r.FormValue("target")
+	if target == "" {
+		fail(http.StatusBadRequest, "no target specified")
+		return
+	}
+	accessLogDetails.Targets = []string{target}
+
+	var t checkThresholds
+	var err error
+	if t.warn, err = strconv.ParseFloat(r.FormValue("warn"), 64); err != nil {
+		fail(http.StatusBadRequest, "invalid warn threshold")
+		return
+	}
+	if t.crit, err = strconv.ParseFloat(r.FormValue("crit"), 64); err != nil {
+		fail(http.StatusBadRequest, "invalid crit threshold")
+		return
+	}
+
+	from := r.FormValue("from")
+	if from == "" {
+		from = "-5min"
+	}
+	until := r.FormValue("until")
+	qtz := r.FormValue("tz")
+	ec, err := parseEvalContext(r.Form, qtz)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	from32, until32, err := ec.timeRange(from, until, qtz)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if from32 >= until32 {
+		fail(http.StatusBadRequest, "invalid empty time range")
+		return
+	}
+	accessLogDetails.FromRaw = from
+	accessLogDetails.From = from32
+	accessLogDetails.UntilRaw = until
+	accessLogDetails.Until = until32
+
+	data, code, err := evalRender(ctx, []string{target}, from32, until32, parser.TemplatesFromForm(r.Form), ec, &accessLogDetails, logger)
+	if err != nil {
+		fail(code, err.Error())
+		return
+	}
+	if maxDataPoints, _ := strconv.Atoi(r.FormValue("maxDataPoints")); maxDataPoints != 0 {
+		types.ConsolidateJSON(maxDataPoints, data)
+	}
+
+	res := evalCheck(data, t)
+	if format == nagiosFormat {
+		writeResponse(w, []byte(res.nagios(t)), rawFormat, "")
+		return
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, b, jsonFormat, r.FormValue("jsonp"))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckThresholds(t *testing.T) {
+	above := checkThresholds{warn: 10, crit: 20}
+	assert.Equal(t, checkOK, above.status(5))
+	assert.Equal(t, checkWarning, above.status(10))
+	assert.Equal(t, checkCritical, above.status(25))
+
+	below := checkThresholds{warn: 10, crit: 5}
+	assert.Equal(t, checkOK, below.status(15))
+	assert.Equal(t, checkWarning, below.status(7))
+	assert.Equal(t, checkCritical, below.status(5))
+}
+
+func TestEvalCheck(t *testing.T) {
+	th := checkThresholds{warn: 10, crit: 20}
+	data := []*types.MetricData{
+		types.MakeMetricData("ok", []float64{30, 1, 0}, 60, 100),
+		types.MakeMetricData("warn", []float64{1, 15, 0}, 60, 100),
+		types.MakeMetricData("none", []float64{0, 0}, 60, 100),
+	}
+	data[0].IsAbsent[2] = true
+	data[1].IsAbsent[2] = true
+	data[2].IsAbsent[0], data[2].IsAbsent[1] = true, true
+
+	res := evalCheck(data, th)
+	assert.Equal(t, "UNKNOWN", res.Status)
+	assert.Equal(t, checkUnknown, res.Code)
+	assert.Equal(t, "warn is 15, none has no value", res.Message)
+	assert.Equal(t, "OK", res.Series[0].Status)
+	assert.Equal(t, int32(160), res.Series[0].Time)
+	assert.Equal(t, "WARNING", res.Series[1].Status)
+	assert.Nil(t, res.Series[2].Value)
+	assert.Equal(t, "UNKNOWN - warn is 15, none has no value | 'ok'=1;10;20 'warn'=15;10;20\n", res.nagios(th))
+
+	res = evalCheck(data[:1], th)
+	assert.Equal(t, "OK", res.Status)
+	assert.Equal(t, "1 series OK", res.Message)
+
+	assert.Equal(t, "UNKNOWN", evalCheck(nil, th).Status)
+}
+
+func TestCheckHandler(t *testing.T) {
+	req, rr := setUpRequest(t, "/check?target=foo.bar&from=1510913000&until=1510914000&warn=1510913800&crit=1510913900")
+	checkHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"WARNING","code":1,"message":"foo.bar is 1510913818",
+		"series":[{"name":"foo.bar","status":"WARNING","value":1510913818,"time":1510913400}]}`, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/check?target=foo.bar&from=1510913000&until=1510914000&warn=1510913800&crit=1510913900&format=nagios")
+	checkHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "WARNING - foo.bar is 1510913818 | 'foo.bar'=1510913818;1510913800;1510913900\n", rr.Body.String())
+
+	req, rr = setUpRequest(t, "/check?target=foo.bar&warn=1")
+	checkHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,16 @@
+-- clampBelow(seriesList, floor)
+function render(series, floor)
+  local out = {}
+  for i, s in ipairs(series) do
+    local values = {}
+    for j, v in ipairs(s.values) do
+      if v == v and v < floor then
+        values[j] = floor
+      else
+        values[j] = v
+      end
+    end
+    out[i] = {name = "clampBelow(" .. s.name .. "," .. floor .. ")", values = values}
+  end
+  return out
+end
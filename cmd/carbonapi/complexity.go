@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+// checkComplexity checks the targets of a request, with their macros and
+// templates expanded, against the expression limits. Targets that don't
+// parse are left for the render to report.
+func checkComplexity(targets []string, templates parser.Templates) error {
+	limits := config.ExpressionLimits
+	if limits == (parser.Limits{}) {
+		return nil
+	}
+
+	var c parser.Complexity
+	for _, target := range targets {
+		if err := limits.CheckNesting(target); err != nil {
+			return err
+		}
+		exp, e, err := parser.ParseExpr(target)
+		if err != nil || e != "" {
+			continue
+		}
+		if exp, err = config.macros.Expand(exp); err != nil {
+			continue
+		}
+		if exp, err = templates.Expand(exp); err != nil {
+			continue
+		}
+		c.Add(parser.Measure(exp))
+	}
+
+	return limits.Check(c)
+}
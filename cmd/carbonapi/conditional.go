@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeRenderResponse writes a render response with the headers that let
+// clients and proxies cache it, or just 304 Not Modified if the client has
+// it already. Responses are fresh for cacheTimeout, or for
+// renderCacheControl.historicalMaxAge when their range ended long enough ago
+// that its data is final. Only those are last modified at the end of their
+// range; the data of others may change any time.
+func writeRenderResponse(w http.ResponseWriter, r *http.Request, body []byte, format string, jsonp string, until int32, cacheTimeout int32) {
+	now := timeNow()
+	end := time.Unix(int64(until), 0)
+
+	maxAge := time.Duration(cacheTimeout) * time.Second
+	var lastModified time.Time
+	historical := config.RenderCacheControl.HistoricalAfter > 0 && now.Sub(end) >= config.RenderCacheControl.HistoricalAfter
+	if historical {
+		lastModified = end
+		if config.RenderCacheControl.HistoricalMaxAge > 0 {
+			maxAge = config.RenderCacheControl.HistoricalMaxAge
+		}
+	}
+
+	etag := renderETag(body, jsonp)
+	h := w.Header()
+	h.Set("ETag", etag)
+	if historical {
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	h.Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge/time.Second)))
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeResponse(w, body, format, jsonp)
+}
+
+// renderETag hashes what writeResponse writes for body.
+func renderETag(body []byte, jsonp string) string {
+	h := fnv.New64a()
+	h.Write([]byte(jsonp))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// notModified reports whether the conditional headers of r match a response
+// with etag, last modified at lastModified unless that is zero.
+// If-None-Match takes precedence over If-Modified-Since, as in RFC 7232.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestWriteRenderResponse(t *testing.T) {
+	defer func(c cfg.RenderCacheControl, now func() time.Time) {
+		config.RenderCacheControl, timeNow = c, now
+	}(config.RenderCacheControl, timeNow)
+	now := time.Unix(1600000000, 0)
+	timeNow = func() time.Time { return now }
+	config.RenderCacheControl.HistoricalAfter = 24 * time.Hour
+	config.RenderCacheControl.HistoricalMaxAge = 7 * 24 * time.Hour
+
+	write := func(until time.Time, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/render?target=foo", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		writeRenderResponse(w, req, []byte("[]"), jsonFormat, "", int32(until.Unix()), 60)
+		return w
+	}
+
+	live := write(now, "", "")
+	if cc := live.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("Expected live ranges to be cached for their cacheTimeout, got %q", cc)
+	}
+	if lm := live.Header().Get("Last-Modified"); lm != "" {
+		t.Errorf("Expected no Last-Modified for live ranges, got %q", lm)
+	}
+	if w := write(now, "If-None-Match", live.Header().Get("ETag")); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := write(now, "If-None-Match", `"other"`); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Errorf("Expected the response for another ETag, got %d", w.Code)
+	}
+	if w := write(now, "If-Modified-Since", now.UTC().Format(http.TimeFormat)); w.Code != http.StatusOK {
+		t.Errorf("Expected live ranges to ignore If-Modified-Since, got %d", w.Code)
+	}
+
+	end := now.Add(-48 * time.Hour)
+	old := write(end, "", "")
+	if cc := old.Header().Get("Cache-Control"); cc != "max-age=604800" {
+		t.Errorf("Expected historical ranges to be cached longer, got %q", cc)
+	}
+	if w := write(end, "If-Modified-Since", old.Header().Get("Last-Modified")); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for historical ranges not modified since, got %d", w.Code)
+	}
+	if w := write(end, "If-Modified-Since", end.Add(-time.Hour).UTC().Format(http.TimeFormat)); w.Code != http.StatusOK {
+		t.Errorf("Expected the response for historical ranges modified since, got %d", w.Code)
+	}
+}
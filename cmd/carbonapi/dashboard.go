@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/pkg/dashboard"
+)
+
+// dashboardHandler serves graphite-web's /dashboard/save/<name>,
+// /dashboard/load/<name>, /dashboard/find/ and /dashboard/delete/<name> the
+// way the graphite composer expects them. Like graphite-web it reports what
+// went wrong with the dashboards in an "error" field of the response.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "dashboard", &config.API)
+	accessLogDetails.Format = jsonFormat
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	fail := func(code int, reason string) {
+		http.Error(w, http.StatusText(code)+": "+reason, code)
+		accessLogDetails.HttpCode = int32(code)
+		accessLogDetails.Reason = reason
+		logAsError = true
+	}
+
+	store := config.dashboards
+	if store == nil {
+		fail(http.StatusNotImplemented, dashboard.ErrNotConfigured.Error())
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dashboard"), "/")
+	var name string
+	if i := strings.IndexByte(action, '/'); i >= 0 {
+		action, name = action[:i], action[i+1:]
+	}
+	if name == "" && action != "find" {
+		fail(http.StatusBadRequest, "missing dashboard name")
+		return
+	}
+
+	var resp interface{}
+	var err error
+	switch action {
+	case "save":
+		if r.Method != http.MethodPost {
+			fail(http.StatusMethodNotAllowed, "only POST is supported")
+			return
+		}
+		err = store.Save(ctx, name, json.RawMessage(r.FormValue("state")))
+		resp = map[string]bool{"success": true}
+	case "load":
+		var state json.RawMessage
+		state, err = store.Load(ctx, name)
+		resp = map[string]json.RawMessage{"state": state}
+	case "find":
+		var names []string
+		names, err = store.Find(ctx, r.FormValue("query"))
+		found := make([]map[string]string, 0, len(names))
+		for _, n := range names {
+			found = append(found, map[string]string{"name": n})
+		}
+		resp = map[string]interface{}{"dashboards": found}
+	case "delete":
+		err = store.Delete(ctx, name)
+		resp = map[string]bool{"success": true}
+	default:
+		fail(http.StatusNotFound, "unknown dashboard action '"+action+"'")
+		return
+	}
+
+	switch err {
+	case nil:
+	case dashboard.ErrNotFound:
+		resp = map[string]string{"error": "Dashboard '" + name + "' does not exist. "}
+		accessLogDetails.Reason = err.Error()
+	case dashboard.ErrBadState, dashboard.ErrBadName:
+		resp = map[string]string{"error": err.Error()}
+		accessLogDetails.Reason = err.Error()
+	default:
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, b, jsonFormat, r.FormValue("jsonp"))
+	accessLogDetails.HttpCode = http.StatusOK
+}
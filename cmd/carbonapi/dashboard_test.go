@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/dashboard"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	config.dashboards = dashboard.NewMemory()
+	defer func() { config.dashboards = nil }()
+
+	form := url.Values{"state": []string{`{"name":"ops","graphs":[]}`}}
+	req, err := http.NewRequest("POST", "/dashboard/save/ops", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	dashboardHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"success":true}`, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/dashboard/save/ops")
+	dashboardHandler(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	req, rr = setUpRequest(t, "/dashboard/load/ops")
+	dashboardHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"state":{"name":"ops","graphs":[]}}`, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/dashboard/load/missing")
+	dashboardHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"error":"Dashboard 'missing' does not exist. "}`, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/dashboard/find/?query=OP")
+	dashboardHandler(rr, req)
+	assert.Equal(t, `{"dashboards":[{"name":"ops"}]}`, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/dashboard/delete/ops")
+	dashboardHandler(rr, req)
+	assert.Equal(t, `{"success":true}`, rr.Body.String())
+
+	req, rr = setUpRequest(t, "/dashboard/find/")
+	dashboardHandler(rr, req)
+	assert.Equal(t, `{"dashboards":[]}`, rr.Body.String())
+}
+
+func TestDashboardHandlerNotConfigured(t *testing.T) {
+	req, rr := setUpRequest(t, "/dashboard/find/?query=ops")
+	dashboardHandler(rr, req)
+
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
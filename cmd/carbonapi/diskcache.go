@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bookingcom/carbonapi/cache"
+)
+
+// historical reports whether the disk cache takes the renders of ranges
+// ending at until, whose data no longer changes.
+func historical(until int32) bool {
+	return config.diskCache != nil && timeNow().Sub(time.Unix(int64(until), 0)) >= config.Cache.Disk.MinAge
+}
+
+// diskCacheGet looks up the render of a range ending at until in the disk
+// cache, which has only those of historical ranges.
+func diskCacheGet(k string, until int32) ([]byte, error) {
+	if !historical(until) {
+		return nil, cache.ErrNotFound
+	}
+
+	v, err := config.diskCache.Get(k)
+	if err != nil {
+		apiMetrics.DiskCacheMisses.Add(1)
+		return nil, err
+	}
+	apiMetrics.DiskCacheHits.Add(1)
+
+	return v, nil
+}
+
+// diskCacheSet stores the render of a historical range ending at until in
+// the disk cache.
+func diskCacheSet(k string, v []byte, until int32) {
+	if historical(until) {
+		config.diskCache.Set(k, v, config.Cache.Disk.ExpireSec)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cache"
+)
+
+func TestRenderHandlerDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(qc cache.BytesCache, dc *cache.DiskCache, minAge time.Duration) {
+		config.queryCache, config.diskCache, config.Cache.Disk.MinAge = qc, dc, minAge
+	}(config.queryCache, config.diskCache, config.Cache.Disk.MinAge)
+	// Renders are only looked up on disk when they aren't in memory.
+	config.queryCache = cache.NullCache{}
+	config.diskCache, err = cache.NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Cache.Disk.MinAge = 24 * time.Hour
+
+	hits := apiMetrics.DiskCacheHits.Value()
+	render := func(until string) string {
+		req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10d&until="+until+"&format=json")
+		renderHandler(rr, req)
+		return rr.Body.String()
+	}
+
+	render("now")
+	if render("now"); apiMetrics.DiskCacheHits.Value() != hits || config.diskCache.Items() != 0 {
+		t.Error("Expected live ranges to be left out of the disk cache")
+	}
+
+	old := render("-2d")
+	if config.diskCache.Items() != 1 {
+		t.Fatalf("Expected the historical range to be stored, %d entries", config.diskCache.Items())
+	}
+	if got := render("-2d"); got != old || apiMetrics.DiskCacheHits.Value() != hits+1 {
+		t.Errorf("Expected the historical range from the disk cache, got %q", got)
+	}
+}
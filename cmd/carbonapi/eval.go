@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/expr"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+
+	"go.uber.org/zap"
+)
+
+// evalTarget is a parsed target whose series have all been fetched.
+type evalTarget struct {
+	target string
+	exp    parser.Expr
+}
+
+type evalResult struct {
+	data []*types.MetricData
+	err  error
+}
+
+// evalTargets evaluates the targets against the fetched series, up to
+// parallelism of them at a time, and returns their results in order.
+// Evaluation only reads metricMap, so the targets share the fetched series.
+func evalTargets(ctx context.Context, targets []evalTarget, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData, parallelism int, logger *zap.Logger) []evalResult {
+	ctx, cancel := stageContext(ctx, stageEval)
+	defer cancel()
+	ctx, done := traceEval(ctx)
+	defer done()
+
+	results := make([]evalResult, len(targets))
+
+	if parallelism <= 1 || len(targets) == 1 {
+		for i, t := range targets {
+			results[i] = evalOne(ctx, t, from, until, metricMap, logger)
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t evalTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[i] = evalOne(ctx, t, from, until, metricMap, logger)
+		}(i, t)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// evalOne evaluates a target, unless the time to evaluate is already up.
+// Evaluation stops with the error of ctx once it is done. A target that
+// panics fails on its own.
+func evalOne(ctx context.Context, t evalTarget, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData, logger *zap.Logger) (res evalResult) {
+	if err := ctx.Err(); err != nil {
+		return evalResult{err: err}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("panic during eval:",
+				zap.String("target", t.target),
+				zap.Any("reason", r),
+				zap.Stack("stack"),
+			)
+			res = evalResult{err: fmt.Errorf("panic during eval: %v", r)}
+		}
+	}()
+
+	data, err := expr.EvalExpr(ctx, t.exp, from, until, metricMap)
+	if terr, ok := err.(*expr.FunctionTimeoutError); ok {
+		functionMetrics.Timeouts.WithLabelValues(terr.Function).Inc()
+		logger.Warn("function timed out",
+			zap.String("target", t.target),
+			zap.Error(err),
+		)
+	}
+
+	return evalResult{data: data, err: err}
+}
+
+// evalErrorCode is the status of a render failing with the evaluation
+// error err.
+func evalErrorCode(err error) int {
+	switch err {
+	case parser.ErrBadType, parser.ErrMissingArgument, parser.ErrMissingTimeseries, parser.ErrUnknownTimeUnits,
+		types.ErrWildcardNotAllowed, types.ErrTooManyArguments:
+		return http.StatusBadRequest
+	case context.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	if _, ok := err.(*expr.FunctionTimeoutError); ok {
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusInternalServerError
+}
+
+// evalRender renders targets as a strict render does, for the handlers that
+// answer with something else than the series: it fails as a whole if any
+// target fails, with the status a render would answer with.
+func evalRender(ctx context.Context, targets []string, from, until int32, templates parser.Templates, ec evalContext, accessLogDetails *carbonapipb.AccessLogDetails, logger *zap.Logger) ([]*types.MetricData, int, error) {
+	ctx = ec.withNow(ctx)
+	if err := checkComplexity(targets, templates); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	fetched := make(map[parser.MetricRequest]struct{})
+
+	var pending []evalTarget
+	for len(targets) > 0 {
+		round := make([]evalTarget, 0, len(targets))
+		exps := make([]parser.Expr, 0, len(targets))
+		for _, target := range targets {
+			exp, msg := expandTarget(target, templates, ec)
+			if msg != "" {
+				return nil, http.StatusBadRequest, errors.New(msg)
+			}
+			if err := checkBlocklistExpr(ctx, exp); err != nil {
+				return nil, http.StatusForbidden, err
+			}
+			round = append(round, evalTarget{target: target, exp: exp})
+			exps = append(exps, exp)
+		}
+
+		prefetch(ctx, exps, from, until, metricMap, fetched, true, accessLogDetails, logger)
+		for _, data := range metricMap {
+			for _, d := range data {
+				d.XFilesFactor = config.DefaultXFilesFactor
+			}
+		}
+
+		targets = nil
+		for _, t := range round {
+			rewritten, newTargets, err := expr.RewriteExpr(ctx, t.exp, from, until, metricMap)
+			if err != nil && err != parser.ErrSeriesDoesNotExist {
+				return nil, evalErrorCode(err), err
+			}
+			if rewritten {
+				targets = append(targets, newTargets...)
+				continue
+			}
+			pending = append(pending, t)
+		}
+	}
+
+	var results []*types.MetricData
+	for _, res := range evalTargets(ctx, pending, from, until, metricMap, config.EvalParallelism, logger) {
+		if res.err != nil && res.err != parser.ErrSeriesDoesNotExist {
+			return nil, evalErrorCode(res.err), res.err
+		}
+		results = append(results, res.data...)
+	}
+
+	return results, http.StatusOK, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestEvalTargetsParallel(t *testing.T) {
+	metricMap := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.*", From: 0, Until: 1}: {
+			types.MakeMetricData("foo.b", []float64{1, 2, 3}, 1, 0),
+			types.MakeMetricData("foo.a", []float64{4, 5}, 1, 1),
+		},
+	}
+
+	var targets []evalTarget
+	for _, target := range []string{
+		"sumSeries(foo.*)",
+		"sortByName(foo.*)",
+		"asPercent(foo.*, foo.*)",
+		"unknownFunction(foo.*)",
+		"maxSeries(foo.*)",
+	} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, evalTarget{target: target, exp: exp})
+	}
+
+	sequential := evalTargets(context.Background(), targets, 0, 1, metricMap, 1, zap.NewNop())
+	parallel := evalTargets(context.Background(), targets, 0, 1, metricMap, 4, zap.NewNop())
+
+	if !assert.Equal(t, len(targets), len(parallel)) {
+		return
+	}
+	for i := range targets {
+		assert.Equal(t, sequential[i].err, parallel[i].err, targets[i].target)
+		assert.Equal(t, len(sequential[i].data), len(parallel[i].data), targets[i].target)
+		for j := range sequential[i].data {
+			assert.Equal(t, sequential[i].data[j].Name, parallel[i].data[j].Name, targets[i].target)
+			assert.Equal(t, sequential[i].data[j].Values, parallel[i].data[j].Values, targets[i].target)
+		}
+	}
+
+	assert.Error(t, parallel[3].err)
+	assert.Equal(t, "sumSeries(foo.*)", parallel[0].data[0].Name)
+
+	// the shared series are left alone
+	fetched := metricMap[parser.MetricRequest{Metric: "foo.*", From: 0, Until: 1}]
+	assert.Equal(t, "foo.b", fetched[0].Name)
+	assert.Equal(t, int32(1), fetched[1].StartTime)
+	assert.Equal(t, []float64{4, 5}, fetched[1].Values)
+}
+
+func TestEvalTargetsFunctionTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { config.FunctionTimeout = timeout }(config.FunctionTimeout)
+	config.FunctionTimeout = time.Nanosecond
+
+	metricMap := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo", From: 0, Until: 1}: {types.MakeMetricData("foo", []float64{1, 2, 3}, 1, 0)},
+	}
+	exp, _, err := parser.ParseExpr("absolute(foo)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := evalTargets(context.Background(), []evalTarget{{target: "absolute(foo)", exp: exp}}, 0, 1, metricMap, 1, zap.NewNop())
+	if assert.IsType(t, &expr.FunctionTimeoutError{}, results[0].err) {
+		assert.Equal(t, http.StatusGatewayTimeout, evalErrorCode(results[0].err))
+	}
+
+	config.FunctionTimeout = 0
+	results = evalTargets(context.Background(), []evalTarget{{target: "absolute(foo)", exp: exp}}, 0, 1, metricMap, 1, zap.NewNop())
+	assert.NoError(t, results[0].err)
+}
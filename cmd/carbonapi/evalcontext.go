@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bookingcom/carbonapi/date"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+// evalContext is what evaluating a request depends on besides the series it
+// fetches: the time its relative times are taken from, and the seed of the
+// functions whose results are random. Debug parameters can pin both, for the
+// responses to the same request to be exactly the same, e.g. for tests that
+// compare them.
+type evalContext struct {
+	now    time.Time
+	seed   int64
+	seeded bool
+}
+
+// parseEvalContext returns the context of a request, pinned by its "now" and
+// "seed" parameters. Without "now", it is taken at the current time; without
+// "seed", random functions stay random.
+func parseEvalContext(form url.Values, qtz string) (evalContext, error) {
+	ec := evalContext{now: timeNow()}
+
+	if s := form.Get("now"); s != "" {
+		now, err := date.ParseDateParamAt(s, qtz, 0, config.defaultTimeZone, ec.now)
+		if err != nil || now == 0 {
+			return ec, fmt.Errorf("invalid now %q", s)
+		}
+		ec.now = time.Unix(int64(now), 0)
+	}
+
+	if s := form.Get("seed"); s != "" {
+		seed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return ec, fmt.Errorf("invalid seed %q", s)
+		}
+		ec.seed, ec.seeded = seed, true
+	}
+
+	return ec, nil
+}
+
+// timeRange returns the epochs of the from and until parameters, from
+// defaulting to a day before now, until to now.
+func (ec evalContext) timeRange(from, until, qtz string) (int32, int32, error) {
+	from32, err := date.ParseDateParamAt(from, qtz, ec.now.Add(-24*time.Hour).Unix(), config.defaultTimeZone, ec.now)
+	if err != nil {
+		return 0, 0, err
+	}
+	until32, err := date.ParseDateParamAt(until, qtz, ec.now.Unix(), config.defaultTimeZone, ec.now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return from32, until32, nil
+}
+
+type evalNowKey struct{}
+
+// withNow returns ctx carrying the now of the context, for what renders
+// below the evaluation, like futureZipper, to take the same now.
+func (ec evalContext) withNow(ctx context.Context) context.Context {
+	return context.WithValue(ctx, evalNowKey{}, ec.now)
+}
+
+// evalNow returns the now the request of ctx is evaluated at, or the
+// current time if it carries none.
+func evalNow(ctx context.Context) time.Time {
+	if now, ok := ctx.Value(evalNowKey{}).(time.Time); ok {
+		return now
+	}
+
+	return timeNow()
+}
+
+// apply seeds the random functions of e, if the context has a seed.
+func (ec evalContext) apply(e parser.Expr) (parser.Expr, error) {
+	if !ec.seeded {
+		return e, nil
+	}
+
+	return parser.Seed(e, ec.seed)
+}
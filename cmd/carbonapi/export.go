@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/objectstore"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Export jobs render targets on a schedule and upload the results to object
+// storage, in place of cron jobs scraping /render.
+
+var exportRuns = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "export_runs_total",
+		Help: "Count of runs of export jobs, partitioned by job and status",
+	},
+	[]string{"job", "status"},
+)
+
+// exports runs the export jobs, if there are any.
+var exports *exporter
+
+// errExportStopped fails the runs that were waiting when the exporter
+// stopped.
+var errExportStopped = errors.New("exports stopped")
+
+// exportPath is what the path of a job expands with. The times are UTC.
+type exportPath struct {
+	Job    string
+	Time   time.Time
+	From   time.Time
+	Until  time.Time
+	Format string
+}
+
+type exportJob struct {
+	cfg.ExportJob
+	path *template.Template
+}
+
+// exporter runs the export jobs. Runs take turns, and wait for the
+// concurrency limiter of the renders to be at most maxLimiterUse.
+type exporter struct {
+	jobs          []exportJob
+	store         *objectstore.Store
+	maxLimiterUse float64
+	logger        *zap.Logger
+
+	turn sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newExporter(c cfg.ExportsConfig, logger *zap.Logger) (*exporter, error) {
+	e := &exporter{
+		store:         objectstore.New(c.Store, &http.Client{}),
+		maxLimiterUse: c.MaxLimiterUse,
+		logger:        logger,
+		stop:          make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for _, j := range c.Jobs {
+		if j.Name == "" || names[j.Name] {
+			return nil, fmt.Errorf("export jobs need distinct names, got %q", j.Name)
+		}
+		names[j.Name] = true
+
+		if len(j.Targets) == 0 || j.Interval <= 0 {
+			return nil, fmt.Errorf("export job %s needs targets and a positive interval", j.Name)
+		}
+		if j.Format != csvFormat && j.Format != parquetFormat {
+			return nil, fmt.Errorf("export job %s has format %q, it must be csv or parquet", j.Name, j.Format)
+		}
+		if j.Timeout <= 0 {
+			j.Timeout = config.Timeouts.Global
+		}
+
+		path, err := template.New(j.Name).Option("missingkey=error").Parse(j.Path)
+		if err != nil {
+			return nil, fmt.Errorf("export job %s: %v", j.Name, err)
+		}
+		job := exportJob{ExportJob: j, path: path}
+		now := time.Now().UTC()
+		dest, err := job.dest(exportPath{Job: j.Name, Time: now, From: now, Until: now, Format: j.Format})
+		if err == nil {
+			err = objectstore.Validate(dest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("export job %s: %v", j.Name, err)
+		}
+
+		e.jobs = append(e.jobs, job)
+	}
+
+	return e, nil
+}
+
+func (j exportJob) dest(p exportPath) (string, error) {
+	var b bytes.Buffer
+	if err := j.path.Execute(&b, p); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// Start schedules the jobs.
+func (e *exporter) Start() {
+	for _, j := range e.jobs {
+		e.wg.Add(1)
+		go func(j exportJob) {
+			defer e.wg.Done()
+			e.schedule(j)
+		}(j)
+	}
+}
+
+// Stop stops scheduling the jobs, and waits for the running one to finish.
+func (e *exporter) Stop() {
+	close(e.stop)
+	e.wg.Wait()
+}
+
+func (e *exporter) schedule(j exportJob) {
+	runEvery(j.Interval, e.stop, func(at time.Time) {
+		if err := e.run(j, at); err != nil {
+			e.logger.Error("export failed",
+				zap.String("job", j.Name),
+				zap.Time("at", at),
+				zap.Error(err),
+			)
+		}
+	})
+}
+
+// run renders j as if at were now, and uploads the results.
+func (e *exporter) run(j exportJob, at time.Time) (err error) {
+	defer func() {
+		status := "ok"
+		if err != nil {
+			status = "failed"
+		}
+		exportRuns.WithLabelValues(j.Name, status).Inc()
+	}()
+
+	e.turn.Lock()
+	defer e.turn.Unlock()
+
+	select {
+	case <-e.stop:
+		return errExportStopped
+	default:
+	}
+	for config.limiter.MaxLimiterUse() > e.maxLimiterUse {
+		select {
+		case <-time.After(time.Second):
+		case <-e.stop:
+			return errExportStopped
+		}
+	}
+
+	t0 := time.Now()
+	ctx, cancel := context.WithTimeout(util.WithUUID(context.Background()), j.Timeout)
+	defer cancel()
+
+	logger := e.logger.With(
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+		zap.String("job", j.Name),
+	)
+
+	ec := evalContext{now: at}
+	from, until, err := ec.timeRange(j.From, j.Until, "")
+	if err != nil {
+		return err
+	}
+	if from >= until {
+		return errors.New("invalid empty time range")
+	}
+
+	accessLogDetails := carbonapipb.AccessLogDetails{
+		Handler:       "export",
+		CarbonapiUuid: util.GetUUID(ctx),
+		Targets:       j.Targets,
+		FromRaw:       j.From,
+		From:          from,
+		UntilRaw:      j.Until,
+		Until:         until,
+		Format:        j.Format,
+	}
+	results, _, err := evalRender(ctx, j.Targets, from, until, nil, ec, &accessLogDetails, logger)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	contentType := contentTypeCSV
+	if j.Format == parquetFormat {
+		body = types.MarshalParquet(results)
+		contentType = contentTypeParquet
+	} else {
+		body = types.MarshalCSVWithNulls(results, config.nullPolicy)
+	}
+
+	dest, err := j.dest(exportPath{
+		Job:    j.Name,
+		Time:   at.UTC(),
+		From:   time.Unix(int64(from), 0).UTC(),
+		Until:  time.Unix(int64(until), 0).UTC(),
+		Format: j.Format,
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.store.Put(ctx, dest, body, contentType); err != nil {
+		return err
+	}
+
+	logger.Info("exported",
+		zap.String("destination", dest),
+		zap.Int("series", len(results)),
+		zap.Int("bytes", len(body)),
+		zap.Duration("runtime", time.Since(t0)),
+	)
+
+	return nil
+}
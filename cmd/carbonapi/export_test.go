@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e, err := newExporter(cfg.ExportsConfig{
+		MaxLimiterUse: 1,
+		Jobs: []cfg.ExportJob{{
+			Name:     "foo",
+			Targets:  []string{"foo.bar"},
+			From:     "1510913000",
+			Until:    "1510914000",
+			Interval: time.Hour,
+			Format:   csvFormat,
+			Path:     "file://" + dir + `/{{.Job}}/{{.Time.Format "2006-01-02T15"}}.{{.Format}}`,
+		}},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2017, 11, 17, 11, 0, 0, 0, time.UTC)
+	if err := e.run(e.jobs[0], at); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "foo", "2017-11-17T11.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], `"foo.bar",2017-11-17 10:09:00,1510913759`), lines[1])
+
+	e.Stop()
+	assert.Equal(t, errExportStopped, e.run(e.jobs[0], at))
+}
+
+func TestNewExporterInvalid(t *testing.T) {
+	job := cfg.ExportJob{
+		Name:     "foo",
+		Targets:  []string{"foo.bar"},
+		Interval: time.Hour,
+		Format:   parquetFormat,
+		Path:     "s3://bucket/{{.Job}}.parquet",
+	}
+	_, err := newExporter(cfg.ExportsConfig{Jobs: []cfg.ExportJob{job}}, zap.NewNop())
+	assert.NoError(t, err)
+
+	for _, f := range []func(j *cfg.ExportJob){
+		func(j *cfg.ExportJob) { j.Name = "" },
+		func(j *cfg.ExportJob) { j.Interval = 0 },
+		func(j *cfg.ExportJob) { j.Format = "json" },
+		func(j *cfg.ExportJob) { j.Path = "s3://bucket/{{.Bucket}}" },
+		func(j *cfg.ExportJob) { j.Path = "/tmp/{{.Job}}" },
+	} {
+		j := job
+		f(&j)
+		_, err := newExporter(cfg.ExportsConfig{Jobs: []cfg.ExportJob{j}}, zap.NewNop())
+		assert.Error(t, err, "%+v", j)
+	}
+
+	_, err = newExporter(cfg.ExportsConfig{Jobs: []cfg.ExportJob{job, job}}, zap.NewNop())
+	assert.Error(t, err, "duplicate names")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+)
+
+// maxJSONForm bounds the JSON bodies of requests, as net/http bounds the
+// url-encoded ones.
+const maxJSONForm = 10 << 20
+
+// jsonForm lets the parameters of a request be POSTed as a JSON object, as
+// graphite-web takes them, for dashboards with more targets than proxies
+// let through in a URL. Url-encoded bodies need nothing, net/http parses
+// them along with the URL.
+func jsonForm(h http.Handler, handler string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONForm))
+		var form url.Values
+		if err == nil {
+			form, err = parseJSONForm(body)
+		}
+		if err != nil {
+			t0 := time.Now()
+			accessLogDetails := carbonapipb.NewAccessLogDetails(r, handler, &config.API)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = err.Error()
+			defer deferredAccessLogging(r, &accessLogDetails, t0, true)
+			http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The signature of the request is over the body.
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// The body comes before the URL, as in the forms net/http parses.
+		r.PostForm = form
+		r.Form = make(url.Values, len(form))
+		for k, vs := range form {
+			r.Form[k] = append([]string(nil), vs...)
+		}
+		for k, vs := range r.URL.Query() {
+			r.Form[k] = append(r.Form[k], vs...)
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseJSONForm reads the parameters of a JSON object: each value is a
+// string, a number or a boolean, or an array of them for a repeated
+// parameter.
+func parseJSONForm(body []byte) (url.Values, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, err
+	}
+
+	form := make(url.Values, len(obj))
+	for k, v := range obj {
+		vs, ok := v.([]interface{})
+		if !ok {
+			vs = []interface{}{v}
+		}
+		for _, v := range vs {
+			s, err := formValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %v", k, err)
+			}
+			form.Add(k, s)
+		}
+	}
+
+	return form, nil
+}
+
+func formValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONForm(t *testing.T) {
+	got, err := parseJSONForm([]byte(`{"target": ["foo.bar", "foo.baz"], "from": "-1h", "maxDataPoints": 100, "noCache": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"target":        {"foo.bar", "foo.baz"},
+		"from":          {"-1h"},
+		"maxDataPoints": {"100"},
+		"noCache":       {"true"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, body := range []string{`["foo.bar"]`, `{"target": {"name": "foo.bar"}}`, `{"target": [null]}`, `{`} {
+		if _, err := parseJSONForm([]byte(body)); err == nil {
+			t.Errorf("expected an error for %s", body)
+		}
+	}
+}
+
+func TestRenderHandlerPost(t *testing.T) {
+	const expected = `[{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]},` +
+		`{"target":"sumSeries(foo.bar)","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`
+
+	form := url.Values{"target": {"foo.bar", "sumSeries(foo.bar)"}, "from": {"-10minutes"}}
+	req := httptest.NewRequest("POST", "/render/?format=json&noCache=1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	jsonForm(http.HandlerFunc(renderHandler), "render")(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Errorf("url-encoded body: expected 200 %s, got %d %s", expected, rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/render/?format=json", strings.NewReader(`{"target": ["foo.bar", "sumSeries(foo.bar)"], "from": "-10minutes", "noCache": 1}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rr = httptest.NewRecorder()
+	jsonForm(http.HandlerFunc(renderHandler), "render")(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Errorf("JSON body: expected 200 %s, got %d %s", expected, rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/render/?format=json", strings.NewReader(`{"target": `))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	jsonForm(http.HandlerFunc(renderHandler), "render")(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed body, got %d", rr.Code)
+	}
+}
+
+func TestFindHandlerPost(t *testing.T) {
+	req := httptest.NewRequest("POST", "/metrics/find/", strings.NewReader(`{"query": "foo.bar", "format": "json"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	jsonForm(http.HandlerFunc(findHandler), "find")(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"text"`) {
+		t.Errorf("expected a treejson find, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var functionMetrics = struct {
+	Calls    *prometheus.CounterVec
+	Seconds  *prometheus.CounterVec
+	Timeouts *prometheus.CounterVec
+}{
+	Calls: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "render_function_calls_total",
+			Help: "Count of render function calls, partitioned by function",
+		},
+		[]string{"function"},
+	),
+	Seconds: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "render_function_seconds_total",
+			Help: "Time spent in render functions, including the functions they call, partitioned by function",
+		},
+		[]string{"function"},
+	),
+	Timeouts: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "render_function_timeouts_total",
+			Help: "Count of render function calls that took longer than functionTimeout, partitioned by function",
+		},
+		[]string{"function"},
+	),
+}
+
+// traceEval returns a context that times the functions evaluated with it,
+// failing those that run longer than functionTimeout, and a func to call
+// once they are evaluated. That counts the time of each function, and logs
+// where it went if it adds up to a slow request.
+func traceEval(ctx context.Context) (context.Context, func()) {
+	trace := &expr.Trace{Timeout: config.FunctionTimeout}
+
+	return expr.WithTrace(ctx, trace), func() {
+		functions := trace.Functions()
+		var slowest time.Duration
+		for name, ft := range functions {
+			functionMetrics.Calls.WithLabelValues(name).Add(float64(ft.Calls))
+			functionMetrics.Seconds.WithLabelValues(name).Add(ft.Time.Seconds())
+			if ft.Time > slowest {
+				slowest = ft.Time
+			}
+		}
+
+		if slowest >= time.Duration(config.Buckets)*100*time.Millisecond {
+			scrubbedLogger("slow").Warn("Slow evaluation",
+				zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+				zap.Strings("functions", functionTimes(functions)),
+			)
+		}
+	}
+}
+
+// functionTimes describes the time of each function, the slowest first.
+func functionTimes(functions map[string]expr.FunctionTime) []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return functions[names[i]].Time > functions[names[j]].Time
+	})
+
+	times := make([]string, len(names))
+	for i, name := range names {
+		ft := functions[name]
+		times[i] = fmt.Sprintf("%s: %v in %d calls", name, ft.Time.Round(time.Microsecond), ft.Calls)
+	}
+
+	return times
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+// futureZipper doesn't ask the backends for the future: renders until a
+// time past now, pinned or not, are rendered until now, and those starting
+// after it not at all. Functions that forecast, like linearRegression, extend the series
+// to the until of the request themselves.
+type futureZipper struct {
+	CarbonZipper
+}
+
+func (z futureZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	now := int32(evalNow(ctx).Unix())
+	if from >= now {
+		return nil, errNoMetrics
+	}
+	if until > now {
+		until = now
+	}
+
+	return z.CarbonZipper.Render(ctx, metrics, from, until)
+}
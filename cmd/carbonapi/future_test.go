@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rangeZipper records the time ranges it is asked to render.
+type rangeZipper struct {
+	ranges *[][2]int32
+}
+
+func (z rangeZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	return pb.GlobResponse{}, nil
+}
+
+func (z rangeZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	return nil, nil
+}
+
+func (z rangeZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	*z.ranges = append(*z.ranges, [2]int32{from, until})
+	return []*types.MetricData{types.MakeMetricData(metrics[0], []float64{1}, until-from, from)}, nil
+}
+
+func TestFutureZipper(t *testing.T) {
+	now := time.Unix(10000, 0)
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	var ranges [][2]int32
+	z := futureZipper{CarbonZipper: rangeZipper{&ranges}}
+
+	_, err := z.Render(context.Background(), []string{"a"}, 5000, 9000)
+	assert.NoError(t, err)
+	_, err = z.Render(context.Background(), []string{"a"}, 5000, 20000)
+	assert.NoError(t, err)
+	_, err = z.Render(context.Background(), []string{"a"}, 15000, 20000)
+	assert.Equal(t, errNoMetrics, err)
+
+	assert.Equal(t, [][2]int32{{5000, 9000}, {5000, 10000}}, ranges)
+}
+
+func TestFutureZipperPinnedNow(t *testing.T) {
+	var ranges [][2]int32
+	z := futureZipper{CarbonZipper: rangeZipper{&ranges}}
+	ctx := evalContext{now: time.Unix(10000, 0)}.withNow(context.Background())
+
+	_, err := z.Render(ctx, []string{"a"}, 5000, 20000)
+	assert.NoError(t, err)
+	_, err = z.Render(ctx, []string{"a"}, 15000, 20000)
+	assert.Equal(t, errNoMetrics, err)
+
+	assert.Equal(t, [][2]int32{{5000, 10000}}, ranges)
+}
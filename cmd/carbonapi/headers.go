@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// withResponseHeaders sets the configured response headers, and the
+// request ID header to the Carbon UUID of the request, on every response
+// of h, errors included.
+func withResponseHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		for name, value := range config.ResponseHeaders {
+			header.Set(name, value)
+		}
+		if config.RequestIDHeader != "" {
+			if id := util.GetUUID(r.Context()); id != "" {
+				header.Set(config.RequestIDHeader, id)
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkResponseHeaders refuses header names and values that would make
+// malformed responses.
+func checkResponseHeaders(headers map[string]string, requestID string) error {
+	for name, value := range headers {
+		if err := checkHeaderName(name); err != nil {
+			return err
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("response header %s has a line break in its value", name)
+		}
+	}
+	if requestID != "" {
+		return checkHeaderName(requestID)
+	}
+
+	return nil
+}
+
+func checkHeaderName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty response header name")
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return fmt.Errorf("response header name %q has %q in it", name, c)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/util"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	defer func(headers map[string]string, requestID string) {
+		config.ResponseHeaders, config.RequestIDHeader = headers, requestID
+	}(config.ResponseHeaders, config.RequestIDHeader)
+	config.ResponseHeaders = map[string]string{
+		"Strict-Transport-Security": "max-age=31536000",
+		"X-Carbonapi-Cluster":       "ams4",
+	}
+	config.RequestIDHeader = "X-Request-Id"
+	h := util.UUIDHandler(initHandlers())
+
+	for _, url := range []string{
+		"/metrics/find/?query=foo.bar&format=json",
+		"/render/?target=sum(&format=json",
+	} {
+		req, rr := setUpRequest(t, url)
+		req.Header.Set(util.HeaderUUID, "4f1e9b9c")
+		h.ServeHTTP(rr, req)
+
+		for name, value := range config.ResponseHeaders {
+			if got := rr.Header().Get(name); got != value {
+				t.Errorf("%s: expected %s: %s, got %q", url, name, value, got)
+			}
+		}
+		if got := rr.Header().Get("X-Request-Id"); got != "4f1e9b9c" {
+			t.Errorf("%s: expected the request ID to be echoed, got %q", url, got)
+		}
+	}
+
+	config.RequestIDHeader = ""
+	req, rr := setUpRequest(t, "/lb_check")
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Request-Id") != "" {
+		t.Errorf("expected no request ID header when not configured, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestCheckResponseHeaders(t *testing.T) {
+	for _, c := range []struct {
+		headers   map[string]string
+		requestID string
+		valid     bool
+	}{
+		{map[string]string{"X-Carbonapi-Cluster": "ams4"}, "X-Request-Id", true},
+		{nil, "", true},
+		{map[string]string{"X-Cluster": "ams4\r\nSet-Cookie: x=1"}, "", false},
+		{map[string]string{"X Cluster": "ams4"}, "", false},
+		{map[string]string{"": "ams4"}, "", false},
+		{nil, "X-Request-Id:", false},
+	} {
+		err := checkResponseHeaders(c.headers, c.requestID)
+		if (err == nil) != c.valid {
+			t.Errorf("%v, %q: expected valid %t, got %v", c.headers, c.requestID, c.valid, err)
+		}
+	}
+}
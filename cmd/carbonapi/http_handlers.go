@@ -10,27 +10,29 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/bookingcom/carbonapi/carbonapipb"
-	"github.com/bookingcom/carbonapi/date"
 	"github.com/bookingcom/carbonapi/expr"
 	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/intervalset"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/events"
 	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/signature"
 	"github.com/bookingcom/carbonapi/util"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 
 	"sync"
 
-	"github.com/dgryski/httputil"
 	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/dgryski/httputil"
 	pickle "github.com/lomik/og-rek"
-	"github.com/lomik/zapwriter"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
@@ -46,6 +48,9 @@ const (
 	protobufFormat  = "protobuf"
 	protobuf3Format = "protobuf3"
 	pickleFormat    = "pickle"
+	histogramFormat = "histogram"
+	arrowFormat     = "arrow"
+	parquetFormat   = "parquet"
 )
 
 type Rule map[string]string
@@ -65,8 +70,20 @@ func validateRequest(h http.Handler, handler string) http.HandlerFunc {
 				deferredAccessLogging(r, &accessLogDetails, t0, true)
 			}()
 			w.WriteHeader(http.StatusForbidden)
+		} else if client, err := verifySignature(r); err != nil {
+			accessLogDetails := carbonapipb.NewAccessLogDetails(r, handler, &config.API)
+			accessLogDetails.HttpCode = http.StatusUnauthorized
+			accessLogDetails.Reason = err.Error()
+			defer func() {
+				deferredAccessLogging(r, &accessLogDetails, t0, true)
+			}()
+			http.Error(w, http.StatusText(http.StatusUnauthorized)+": "+err.Error(), http.StatusUnauthorized)
 		} else {
-			h.ServeHTTP(w, r)
+			if client != "" {
+				r = r.WithContext(signature.NewContext(r.Context(), client))
+			}
+			journalRequest(r, handler)
+			h.ServeHTTP(w, withBlocklistOverride(r))
 		}
 	})
 }
@@ -74,13 +91,18 @@ func validateRequest(h http.Handler, handler string) http.HandlerFunc {
 func initHandlersInternal() http.Handler {
 	r := http.NewServeMux()
 
-	r.HandleFunc("/block-headers/", httputil.TimeHandler(blockHeaders, bucketRequestTimes))
-	r.HandleFunc("/block-headers", httputil.TimeHandler(blockHeaders, bucketRequestTimes))
+	r.HandleFunc("/block-headers/", httputil.TimeHandler(blockHeaders, bucketRequestTimes("block_headers")))
+	r.HandleFunc("/block-headers", httputil.TimeHandler(blockHeaders, bucketRequestTimes("block_headers")))
+
+	r.HandleFunc("/unblock-headers/", httputil.TimeHandler(unblockHeaders, bucketRequestTimes("unblock_headers")))
+	r.HandleFunc("/unblock-headers", httputil.TimeHandler(unblockHeaders, bucketRequestTimes("unblock_headers")))
+
+	r.HandleFunc("/reload-blocklist", httputil.TimeHandler(reloadBlocklist, bucketRequestTimes("reload_blocklist")))
 
-	r.HandleFunc("/unblock-headers/", httputil.TimeHandler(unblockHeaders, bucketRequestTimes))
-	r.HandleFunc("/unblock-headers", httputil.TimeHandler(unblockHeaders, bucketRequestTimes))
+	r.HandleFunc("/admin/schema-check", httputil.TimeHandler(schemaCheckHandler, bucketRequestTimes("schema_check")))
 
 	r.HandleFunc("/debug/version", debugVersionHandler)
+	r.HandleFunc("/debug/topqueries", topQueriesHandler)
 
 	r.Handle("/debug/vars", expvar.Handler())
 	r.HandleFunc("/debug/pprof/", pprof.Index)
@@ -97,24 +119,75 @@ func initHandlersInternal() http.Handler {
 func initHandlers() http.Handler {
 	r := http.NewServeMux()
 
-	r.HandleFunc("/render/", httputil.TimeHandler(validateRequest(http.HandlerFunc(renderHandler), "render"), bucketRequestTimes))
-	r.HandleFunc("/render", httputil.TimeHandler(validateRequest(http.HandlerFunc(renderHandler), "render"), bucketRequestTimes))
+	r.HandleFunc("/render/", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(joinRetries(http.HandlerFunc(renderHandler))), "render"), "render"), "render"), bucketRequestTimes("render")))
+	r.HandleFunc("/render", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(joinRetries(http.HandlerFunc(renderHandler))), "render"), "render"), "render"), bucketRequestTimes("render")))
 
-	r.HandleFunc("/metrics/find/", httputil.TimeHandler(validateRequest(http.HandlerFunc(findHandler), "find"), bucketRequestTimes))
-	r.HandleFunc("/metrics/find", httputil.TimeHandler(validateRequest(http.HandlerFunc(findHandler), "find"), bucketRequestTimes))
+	// graphite-web serves the composer's renders, and finds, on these too.
+	r.HandleFunc("/composer/render/", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(joinRetries(http.HandlerFunc(renderHandler))), "render"), "render"), "render"), bucketRequestTimes("render")))
+	r.HandleFunc("/composer/render", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(joinRetries(http.HandlerFunc(renderHandler))), "render"), "render"), "render"), bucketRequestTimes("render")))
+	r.HandleFunc("/metrics", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(findHandler)), "find"), "find"), "find"), bucketRequestTimes("find")))
 
-	r.HandleFunc("/info/", httputil.TimeHandler(validateRequest(http.HandlerFunc(infoHandler), "info"), bucketRequestTimes))
-	r.HandleFunc("/info", httputil.TimeHandler(validateRequest(http.HandlerFunc(infoHandler), "info"), bucketRequestTimes))
+	r.HandleFunc("/render/stream/", validateRequest(tenantHandler(aclHandler(http.HandlerFunc(renderStreamHandler)), "render_stream"), "render_stream"))
+	r.HandleFunc("/render/stream", validateRequest(tenantHandler(aclHandler(http.HandlerFunc(renderStreamHandler)), "render_stream"), "render_stream"))
 
-	r.HandleFunc("/lb_check", httputil.TimeHandler(lbcheckHandler, bucketRequestTimes))
+	r.HandleFunc("/metrics/find/", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(findHandler)), "find"), "find"), "find"), bucketRequestTimes("find")))
+	r.HandleFunc("/metrics/find", httputil.TimeHandler(jsonForm(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(findHandler)), "find"), "find"), "find"), bucketRequestTimes("find")))
 
-	r.HandleFunc("/version", httputil.TimeHandler(versionHandler, bucketRequestTimes))
-	r.HandleFunc("/version/", httputil.TimeHandler(versionHandler, bucketRequestTimes))
+	r.HandleFunc("/info/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(infoHandler)), "info"), "info"), bucketRequestTimes("info")))
+	r.HandleFunc("/info", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(infoHandler)), "info"), "info"), bucketRequestTimes("info")))
+
+	r.HandleFunc("/events/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(eventsHandler)), "events"), "events"), bucketRequestTimes("events")))
+	r.HandleFunc("/events", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(eventsHandler)), "events"), "events"), bucketRequestTimes("events")))
+
+	r.HandleFunc("/events/get_data/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(eventsGetDataHandler)), "events"), "events"), bucketRequestTimes("events")))
+	r.HandleFunc("/events/get_data", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(eventsGetDataHandler)), "events"), "events"), bucketRequestTimes("events")))
+
+	r.HandleFunc("/annotations", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(annotationsHandler)), "annotations"), "annotations"), bucketRequestTimes("annotations")))
+	r.HandleFunc("/annotations/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(annotationsHandler)), "annotations"), "annotations"), bucketRequestTimes("annotations")))
+
+	r.HandleFunc("/dashboard/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(dashboardHandler)), "dashboard"), "dashboard"), bucketRequestTimes("dashboard")))
+
+	r.HandleFunc("/check", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(checkHandler)), "check"), "check"), bucketRequestTimes("check")))
+	r.HandleFunc("/check/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(checkHandler)), "check"), "check"), bucketRequestTimes("check")))
+
+	if config.MetricsPut.Enabled {
+		r.HandleFunc("/metrics/put", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(putHandler)), "put"), "put"), bucketRequestTimes("put")))
+		r.HandleFunc("/metrics/put/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(putHandler)), "put"), "put"), bucketRequestTimes("put")))
+	}
+
+	if config.SQL.Enabled {
+		r.HandleFunc("/sql", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(sqlHandler)), "sql"), "sql"), bucketRequestTimes("sql")))
+		r.HandleFunc("/sql/", httputil.TimeHandler(validateRequest(tenantHandler(aclHandler(http.HandlerFunc(sqlHandler)), "sql"), "sql"), bucketRequestTimes("sql")))
+	}
 
-	r.HandleFunc("/functions", httputil.TimeHandler(functionsHandler, bucketRequestTimes))
-	r.HandleFunc("/functions/", httputil.TimeHandler(functionsHandler, bucketRequestTimes))
+	r.HandleFunc("/lb_check", httputil.TimeHandler(lbcheckHandler, bucketRequestTimes("lbcheck")))
 
-	r.HandleFunc("/", httputil.TimeHandler(usageHandler, bucketRequestTimes))
+	r.HandleFunc("/version", httputil.TimeHandler(versionHandler, bucketRequestTimes("version")))
+	r.HandleFunc("/version/", httputil.TimeHandler(versionHandler, bucketRequestTimes("version")))
+
+	r.HandleFunc("/capabilities", httputil.TimeHandler(capabilitiesHandler, bucketRequestTimes("capabilities")))
+	r.HandleFunc("/capabilities/", httputil.TimeHandler(capabilitiesHandler, bucketRequestTimes("capabilities")))
+
+	r.HandleFunc("/functions", httputil.TimeHandler(functionsHandler, bucketRequestTimes("functions")))
+	r.HandleFunc("/functions/", httputil.TimeHandler(functionsHandler, bucketRequestTimes("functions")))
+
+	r.HandleFunc("/", httputil.TimeHandler(usageHandler, bucketRequestTimes("usage")))
+
+	if config.RoutePrefix != "" {
+		return withResponseHeaders(withRoutePrefix(config.RoutePrefix, r))
+	}
+
+	return withResponseHeaders(r)
+}
+
+// withRoutePrefix serves h under prefix. Load balancers still find
+// /lb_check where it always was.
+func withRoutePrefix(prefix string, h http.Handler) http.Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+
+	r := http.NewServeMux()
+	r.Handle(prefix+"/", http.StripPrefix(prefix, h))
+	r.Handle("/lb_check", h)
 
 	return r
 }
@@ -122,7 +195,7 @@ func initHandlers() http.Handler {
 func writeResponse(w http.ResponseWriter, b []byte, format string, jsonp string) {
 
 	switch format {
-	case jsonFormat:
+	case jsonFormat, histogramFormat:
 		if jsonp != "" {
 			w.Header().Set("Content-Type", contentTypeJavaScript)
 			w.Write([]byte(jsonp))
@@ -145,6 +218,12 @@ func writeResponse(w http.ResponseWriter, b []byte, format string, jsonp string)
 	case csvFormat:
 		w.Header().Set("Content-Type", contentTypeCSV)
 		w.Write(b)
+	case arrowFormat:
+		w.Header().Set("Content-Type", contentTypeArrow)
+		w.Write(b)
+	case parquetFormat:
+		w.Header().Set("Content-Type", contentTypeParquet)
+		w.Write(b)
 	case pngFormat:
 		w.Header().Set("Content-Type", contentTypePNG)
 		w.Write(b)
@@ -163,6 +242,8 @@ const (
 	contentTypePNG        = "image/png"
 	contentTypeCSV        = "text/csv"
 	contentTypeSVG        = "image/svg+xml"
+	contentTypeArrow      = "application/vnd.apache.arrow.stream"
+	contentTypeParquet    = "application/vnd.apache.parquet"
 )
 
 type renderResponse struct {
@@ -177,14 +258,17 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "render", &config.API)
-	logger := zapwriter.Logger("render").With(
+	logger := scrubbedLogger("render").With(
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 		zap.String("username", accessLogDetails.Username),
 	)
 
+	accessLogDetails.Dashboard = dashboardOf(r)
+	points := 0
 	logAsError := false
 	defer func() {
 		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+		attributeRender(accessLogDetails.Dashboard, time.Since(t0), points)
 	}()
 
 	size := 0
@@ -205,13 +289,15 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	until := r.FormValue("until")
 	format := r.FormValue("format")
 	template := r.FormValue("template")
+	templates := parser.TemplatesFromForm(r.Form)
 	useCache := !parser.TruthyBool(r.FormValue("noCache"))
-
-	var jsonp string
-
-	if format == jsonFormat {
-		// TODO(dgryski): check jsonp only has valid characters
-		jsonp = r.FormValue("jsonp")
+	strict := config.StrictRender
+	nulls := config.nullPolicy
+	if parser.TruthyBool(r.FormValue("noNullPoints")) {
+		nulls = types.NullPolicy{Drop: true}
+	}
+	if s := r.FormValue("strict"); s != "" {
+		strict = parser.TruthyBool(s)
 	}
 
 	if format == "" && (parser.TruthyBool(r.FormValue("rawData")) || parser.TruthyBool(r.FormValue("rawdata"))) {
@@ -219,7 +305,26 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if format == "" {
-		format = pngFormat
+		w.Header().Add("Vary", "Accept")
+		var ok bool
+		if format, ok = negotiateFormat(r, pngFormat, renderFormats); !ok {
+			notAcceptable(w, pngFormat, renderFormats)
+			accessLogDetails.HttpCode = http.StatusNotAcceptable
+			accessLogDetails.Reason = "no acceptable format"
+			logAsError = true
+			return
+		}
+		// Responses in other formats than the default are cached apart.
+		if format != pngFormat {
+			r.Form.Set("format", format)
+		}
+	}
+
+	var jsonp string
+
+	if format == jsonFormat || format == histogramFormat {
+		// TODO(dgryski): check jsonp only has valid characters
+		jsonp = r.FormValue("jsonp")
 	}
 
 	cacheTimeout := config.Cache.DefaultTimeoutSec
@@ -251,8 +356,23 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 
 	// normalize from and until values
 	qtz := r.FormValue("tz")
-	from32 := date.DateParamToEpoch(from, qtz, timeNow().Add(-24*time.Hour).Unix(), config.defaultTimeZone)
-	until32 := date.DateParamToEpoch(until, qtz, timeNow().Unix(), config.defaultTimeZone)
+	ec, err := parseEvalContext(r.Form, qtz)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	ctx = ec.withNow(ctx)
+	from32, until32, err := ec.timeRange(from, until, qtz)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
 
 	accessLogDetails.UseCache = useCache
 	accessLogDetails.FromRaw = from
@@ -263,9 +383,37 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	accessLogDetails.CacheTimeout = cacheTimeout
 	accessLogDetails.Format = format
 	accessLogDetails.Targets = targets
+	if err := checkComplexity(targets, templates); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	// The blocklist is checked before the caches, so that blocking a
+	// metric stops the responses cached for it from being served too. The
+	// targets that don't parse are reported below.
+	for _, target := range targets {
+		exp, msg := expandTarget(target, templates, ec)
+		if msg != "" {
+			continue
+		}
+		if err := checkBlocklistExpr(ctx, exp); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden)+": "+err.Error(), http.StatusForbidden)
+			accessLogDetails.HttpCode = http.StatusForbidden
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+			return
+		}
+	}
 	if useCache {
 		tc := time.Now()
-		response, err := config.queryCache.Get(cacheKey)
+		response, err := cacheGet(config.queryCache, scopedCacheKey(ctx, cacheKey), refreshRender(r, cacheKey))
+		if err != nil {
+			if response, err = diskCacheGet(scopedCacheKey(ctx, cacheKey), until32); err == nil {
+				config.queryCache.Set(scopedCacheKey(ctx, cacheKey), response, cacheTimeout)
+			}
+		}
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.RenderCacheOverheadNS.Add(td)
 
@@ -274,7 +422,7 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 
 		if err == nil {
 			apiMetrics.RequestCacheHits.Add(1)
-			writeResponse(w, response, format, jsonp)
+			writeRenderResponse(w, r, response, format, jsonp, until32, cacheTimeout)
 			accessLogDetails.FromCache = true
 			return
 		}
@@ -289,152 +437,140 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var results []*types.MetricData
-	errors := make(map[string]string)
-	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
-
-	var metrics []string
-	var targetIdx = 0
-	// TODO(gmagnusson): Put the body of this loop in a select { } and cancel work
-	for targetIdx < len(targets) {
-		var target = targets[targetIdx]
-		targetIdx++
-
-		exp, e, err := parser.ParseExpr(target)
-		if err != nil || e != "" {
-			msg := buildParseErrorString(target, e, err)
-			http.Error(w, msg, http.StatusBadRequest)
-			accessLogDetails.Reason = msg
+	xFilesFactor := config.DefaultXFilesFactor
+	if s := r.FormValue("xFilesFactor"); s != "" {
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil || f < 0 || f > 1 {
+			http.Error(w, "xFilesFactor must be between 0 and 1", http.StatusBadRequest)
 			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = "invalid xFilesFactor"
 			logAsError = true
 			return
 		}
+		xFilesFactor = float32(f)
+	}
 
-		for _, m := range exp.Metrics() {
-			metrics = append(metrics, m.Metric)
-			mfetch := m
-			mfetch.From += from32
-			mfetch.Until += until32
-
-			if _, ok := metricMap[mfetch]; ok {
-				// already fetched this metric for this request
+	var results []*types.MetricData
+	errors := make(map[string]string)
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	// fetched also remembers the fetches that came back empty or failed,
+	// which metricMap doesn't keep
+	fetched := make(map[parser.MetricRequest]struct{})
+
+	var pending []evalTarget
+	// Bad targets are left out, unless the request has no good ones.
+	planned := 0
+	badRequest := ""
+	// Targets are planned in rounds: parse them all, fetch everything they
+	// need at once, then evaluate or rewrite them. Rewritten targets (e.g.
+	// from applyByNode) make up the next round.
+	// TODO(gmagnusson): Put the body of this loop in a select { } and cancel work
+	for len(targets) > 0 {
+		round := make([]evalTarget, 0, len(targets))
+		exps := make([]parser.Expr, 0, len(targets))
+		for _, target := range targets {
+			exp, msg := expandTarget(target, templates, ec)
+			if msg != "" {
+				if strict {
+					http.Error(w, msg, http.StatusBadRequest)
+					accessLogDetails.Reason = msg
+					accessLogDetails.HttpCode = http.StatusBadRequest
+					logAsError = true
+					return
+				}
+				if badRequest == "" {
+					badRequest = msg
+				}
+				errors[target] = msg
 				continue
 			}
-
-			renderRequests, err := getRenderRequests(ctx, m, useCache, &accessLogDetails)
-			if err != nil {
-				logger.Error("find error",
-					zap.String("metric", m.Metric),
-					zap.Error(err),
-				)
-				continue
+			// Targets rewritten from others are checked here.
+			if err := checkBlocklistExpr(ctx, exp); err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden)+": "+err.Error(), http.StatusForbidden)
+				accessLogDetails.HttpCode = http.StatusForbidden
+				accessLogDetails.Reason = err.Error()
+				logAsError = true
+				return
 			}
 
-			// TODO(dgryski): group the render requests into batches
-			rch := make(chan renderResponse, len(renderRequests))
-			for _, m := range renderRequests {
-				go func(path string, from, until int32) {
-					config.limiter.Enter(localHostName)
-					defer config.limiter.Leave(localHostName)
-
-					apiMetrics.RenderRequests.Add(1)
-					atomic.AddInt64(&accessLogDetails.ZipperRequests, 1)
+			planned++
+			round = append(round, evalTarget{target: target, exp: exp})
+			exps = append(exps, exp)
+		}
 
-					r, err := config.zipper.Render(ctx, path, from, until)
-					rch <- renderResponse{r, err}
-				}(m, mfetch.From, mfetch.Until)
+		tf := time.Now()
+		fetchedSize := prefetch(ctx, exps, from32, until32, metricMap, fetched, useCache, &accessLogDetails, logger)
+		size += fetchedSize
+		roundTargets := make([]string, len(round))
+		for i, t := range round {
+			roundTargets[i] = t.target
+		}
+		heavyHitters.addTargets(roundTargets, time.Since(tf), fetchedSize)
+		for _, data := range metricMap {
+			for _, d := range data {
+				d.XFilesFactor = xFilesFactor
 			}
+		}
 
-			errors := make([]error, 0)
-			for i := 0; i < len(renderRequests); i++ {
-				resp := <-rch
-				if resp.error != nil {
-					errors = append(errors, resp.error)
-					continue
-				}
-
-				for _, r := range resp.data {
-					size += r.Size()
-					metricMap[mfetch] = append(metricMap[mfetch], r)
+		targets = nil
+		for _, t := range round {
+			rewritten, newTargets, err := expr.RewriteExpr(ctx, t.exp, from32, until32, metricMap)
+			if err != nil && err != parser.ErrSeriesDoesNotExist {
+				if strict {
+					code := evalErrorCode(err)
+					http.Error(w, http.StatusText(code)+": "+err.Error(), code)
+					accessLogDetails.Reason = err.Error()
+					accessLogDetails.HttpCode = int32(code)
+					logAsError = true
+					return
 				}
+				errors[t.target] = err.Error()
+				accessLogDetails.Reason = err.Error()
+				continue
 			}
 
-			close(rch)
-
-			if len(errors) != 0 {
-				logger.Error("render error occurred while fetching data",
-					zap.Any("errors", errors),
-				)
+			if rewritten {
+				targets = append(targets, newTargets...)
+				continue
 			}
 
-			expr.SortMetrics(metricMap[mfetch], mfetch)
-		}
-		accessLogDetails.Metrics = metrics
-
-		var rewritten bool
-		var newTargets []string
-		rewritten, newTargets, err = expr.RewriteExpr(exp, from32, until32, metricMap)
-		if err != nil && err != parser.ErrSeriesDoesNotExist {
-			// TODO(gmagnusson): Set access logger HTTP code to != 200
-			errors[target] = err.Error()
-			accessLogDetails.Reason = err.Error()
-			logAsError = true
-			return
-		}
-
-		if rewritten {
-			// TODO(gmagnusson): Have the loop be
-			//
-			//		for i := 0; i < total; i++
-			//
-			// and update total here with len(newTargets) so we actually
-			// end up looking at any of the things in there.
-			//
-			// Ugh, I'm now paranoid that the compiler or the runtime will
-			// inline 'total' at some point in the future as an optimization.
-			// Maybe have the loop instead be:
-			//
-			// for {
-			//		if len(targets) == 0 {
-			//			break
-			//		}
-			//
-			//		target = targets[0]
-			//		targets = targets[1:]
-			// }
-			//
-			// If it walks like a stack, and it quacks like a stack ...
-
-			targets = append(targets, newTargets...)
-			continue
+			pending = append(pending, t)
 		}
+	}
 
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					logger.Error("panic during eval:",
-						zap.String("cache_key", cacheKey),
-						zap.Any("reason", r),
-						zap.Stack("stack"),
-					)
-				}
-			}()
+	if planned == 0 && badRequest != "" {
+		http.Error(w, badRequest, http.StatusBadRequest)
+		accessLogDetails.Reason = badRequest
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		logAsError = true
+		return
+	}
 
-			exprs, err := expr.EvalExpr(exp, from32, until32, metricMap)
-			if err != nil {
-				if err != parser.ErrSeriesDoesNotExist {
-					errors[target] = err.Error()
-					accessLogDetails.Reason = err.Error()
+	evalLogger := logger.With(zap.String("cache_key", cacheKey))
+	for i, res := range evalTargets(ctx, pending, from32, until32, metricMap, config.EvalParallelism, evalLogger) {
+		if res.err != nil {
+			if res.err != parser.ErrSeriesDoesNotExist {
+				if strict {
+					code := evalErrorCode(res.err)
+					http.Error(w, http.StatusText(code)+": "+res.err.Error(), code)
+					accessLogDetails.Reason = res.err.Error()
+					accessLogDetails.HttpCode = int32(code)
 					logAsError = true
+					return
 				}
-
-				// If err == parser.ErrSeriesDoesNotExist, exprs == nil, so we
-				// can just return here.
-				return
+				errors[pending[i].target] = res.err.Error()
+				accessLogDetails.Reason = res.err.Error()
+				logAsError = true
 			}
 
-			results = append(results, exprs...)
-		}()
+			// If err == parser.ErrSeriesDoesNotExist, data == nil
+			continue
+		}
+
+		results = append(results, res.data...)
+	}
+	for _, m := range results {
+		points += len(m.Values)
 	}
 
 	var body []byte
@@ -445,7 +581,9 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 			types.ConsolidateJSON(maxDataPoints, results)
 		}
 
-		body = types.MarshalJSON(results)
+		body = types.MarshalJSONWithNulls(results, parser.TruthyBool(r.FormValue("meta")), nulls)
+	case histogramFormat:
+		body = types.MarshalHistogram(results)
 	case protobufFormat, protobuf3Format:
 		body, err = types.MarshalProtobuf(results)
 		if err != nil {
@@ -462,25 +600,73 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	case rawFormat:
 		body = types.MarshalRaw(results)
 	case csvFormat:
-		body = types.MarshalCSV(results)
+		body = types.MarshalCSVWithNulls(results, nulls)
 	case pickleFormat:
 		body = types.MarshalPickle(results)
+	case arrowFormat:
+		body = types.MarshalArrow(results)
+	case parquetFormat:
+		body = types.MarshalParquet(results)
 	case pngFormat:
 		body = png.MarshalPNGRequest(r, results, template)
 	case svgFormat:
 		body = png.MarshalSVGRequest(r, results, template)
 	}
 
-	writeResponse(w, body, format, jsonp)
+	if len(errors) > 0 {
+		// The targets that failed are told in a header, which all formats
+		// can carry.
+		if b, err := json.Marshal(errors); err == nil {
+			w.Header().Set(targetErrorsHeader, string(b))
+		}
+	}
 
-	if len(results) != 0 {
+	writeRenderResponse(w, r, body, format, jsonp, until32, cacheTimeout)
+
+	// Partial responses aren't cached, the failed targets may well succeed
+	// when asked again.
+	if len(results) != 0 && len(errors) == 0 {
 		tc := time.Now()
-		config.queryCache.Set(cacheKey, body, cacheTimeout)
+		config.queryCache.Set(scopedCacheKey(ctx, cacheKey), body, cacheTimeout)
+		diskCacheSet(scopedCacheKey(ctx, cacheKey), body, until32)
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.RenderCacheOverheadNS.Add(td)
 	}
 
 	accessLogDetails.HaveNonFatalErrors = len(errors) > 0
+	if len(errors) > 0 {
+		accessLogDetails.TargetErrors = errors
+	}
+}
+
+// targetErrorsHeader carries the errors of the targets of a render that
+// failed, as a JSON object keyed by target.
+const targetErrorsHeader = "X-Carbonapi-Target-Errors"
+
+// expandTarget parses target, expands its macros and templates, and seeds it
+// from ec. It returns the message to answer the client with if it can't.
+func expandTarget(target string, templates parser.Templates, ec evalContext) (parser.Expr, string) {
+	exp, e, err := parser.ParseExpr(target)
+	if err != nil || e != "" {
+		return nil, buildParseErrorString(target, e, err)
+	}
+
+	exp, err = config.macros.Expand(exp)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	exp, err = templates.Expand(exp)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	exp, err = ec.apply(exp)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	return exp, ""
 }
 
 func sendGlobs(glob pb.GlobResponse) bool {
@@ -494,24 +680,19 @@ func sendGlobs(glob pb.GlobResponse) bool {
 
 func resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails) (pb.GlobResponse, error) {
 	var glob pb.GlobResponse
-	var haveCacheData bool
 
 	if useCache {
 		tc := time.Now()
-		response, err := config.findCache.Get(metric)
+		response, err := cacheGet(config.findCache, scopedCacheKey(ctx, globCacheKey(metric)), refreshGlob(ctx, metric))
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.FindCacheOverheadNS.Add(td)
 
-		if err == nil {
-			err := glob.Unmarshal(response)
-			haveCacheData = err == nil
+		if err == nil && glob.Unmarshal(response) == nil {
+			apiMetrics.FindCacheHits.Add(1)
+			return glob, nil
 		}
 	}
 
-	if haveCacheData {
-		apiMetrics.FindCacheHits.Add(1)
-	}
-
 	apiMetrics.FindCacheMisses.Add(1)
 	var err error
 	apiMetrics.FindRequests.Add(1)
@@ -525,7 +706,7 @@ func resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDe
 	b, err := glob.Marshal()
 	if err == nil {
 		tc := time.Now()
-		config.findCache.Set(metric, b, 5*60)
+		config.findCache.Set(scopedCacheKey(ctx, globCacheKey(metric)), b, 5*60)
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.FindCacheOverheadNS.Add(td)
 	}
@@ -533,6 +714,58 @@ func resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDe
 	return glob, nil
 }
 
+// fetchMetric renders all series matching m from the zipper, one request per
+// resolved path, and returns them sorted along with the size of the data.
+func fetchMetric(ctx context.Context, m parser.MetricRequest, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails, logger *zap.Logger) ([]*types.MetricData, int, error) {
+	renderRequests, err := getRenderRequests(ctx, m, useCache, accessLogDetails)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// TODO(dgryski): group the render requests into batches
+	rch := make(chan renderResponse, len(renderRequests))
+	for _, path := range renderRequests {
+		go func(path string, from, until int32) {
+			config.limiter.Enter(localHostName)
+			defer config.limiter.Leave(localHostName)
+
+			apiMetrics.RenderRequests.Add(1)
+			atomic.AddInt64(&accessLogDetails.ZipperRequests, 1)
+
+			r, err := config.zipper.Render(ctx, []string{path}, from, until)
+			rch <- renderResponse{r, err}
+		}(path, m.From, m.Until)
+	}
+
+	var data []*types.MetricData
+	size := 0
+	errors := make([]error, 0)
+	for i := 0; i < len(renderRequests); i++ {
+		resp := <-rch
+		if resp.error != nil {
+			errors = append(errors, resp.error)
+			continue
+		}
+
+		for _, r := range resp.data {
+			size += r.Size()
+			data = append(data, r)
+		}
+	}
+
+	close(rch)
+
+	if len(errors) != 0 {
+		logger.Error("render error occurred while fetching data",
+			zap.Any("errors", errors),
+		)
+	}
+
+	expr.SortMetrics(data, m)
+
+	return data, size, nil
+}
+
 func getRenderRequests(ctx context.Context, m parser.MetricRequest, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails) ([]string, error) {
 	if config.AlwaysSendGlobsAsIs {
 		accessLogDetails.SendGlobs = true
@@ -592,7 +825,23 @@ func findHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if format == "" {
-		format = treejsonFormat
+		w.Header().Add("Vary", "Accept")
+		var ok bool
+		if format, ok = negotiateFormat(r, treejsonFormat, findFormats); !ok {
+			notAcceptable(w, treejsonFormat, findFormats)
+			accessLogDetails.HttpCode = http.StatusNotAcceptable
+			accessLogDetails.Reason = "no acceptable format"
+			logAsError = true
+			return
+		}
+	}
+
+	if err := checkBlocklist(ctx, query); err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden)+": "+err.Error(), http.StatusForbidden)
+		accessLogDetails.HttpCode = http.StatusForbidden
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
 	}
 
 	globs, err := config.zipper.Find(ctx, query)
@@ -744,18 +993,27 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 	apiMetrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
 
-	if format == "" {
-		format = jsonFormat
-	}
-
 	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "info", &config.API)
-	accessLogDetails.Format = format
 
 	logAsError := false
 	defer func() {
 		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
 	}()
 
+	if format == "" {
+		w.Header().Add("Vary", "Accept")
+		var ok bool
+		// Only JSON is implemented so far.
+		if format, ok = negotiateFormat(r, jsonFormat, nil); !ok {
+			notAcceptable(w, jsonFormat, nil)
+			accessLogDetails.HttpCode = http.StatusNotAcceptable
+			accessLogDetails.Reason = "no acceptable format"
+			logAsError = true
+			return
+		}
+	}
+	accessLogDetails.Format = format
+
 	var data map[string]pb.InfoResponse
 	var err error
 
@@ -768,9 +1026,21 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := checkBlocklist(ctx, query); err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden)+": "+err.Error(), http.StatusForbidden)
+		accessLogDetails.HttpCode = http.StatusForbidden
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
 	if data, err = config.zipper.Info(ctx, query); err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		accessLogDetails.HttpCode = http.StatusInternalServerError
+		code := http.StatusInternalServerError
+		if err == acl.ErrDenied {
+			code = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(code), code)
+		accessLogDetails.HttpCode = int32(code)
 		accessLogDetails.Reason = err.Error()
 		logAsError = true
 		return
@@ -779,7 +1049,11 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 	var b []byte
 	switch format {
 	case jsonFormat:
-		b, err = json.Marshal(data)
+		if r.FormValue("view") == "merged" {
+			b, err = json.Marshal(mergeInfo(data))
+		} else {
+			b, err = json.Marshal(data)
+		}
 	case protobufFormat, protobuf3Format:
 		err = fmt.Errorf("not implemented yet")
 	default:
@@ -794,11 +1068,148 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", contentTypeJSON)
 	w.Write(b)
 	accessLogDetails.Runtime = time.Since(t0).Seconds()
 	accessLogDetails.HttpCode = http.StatusOK
 }
 
+// eventsHandler stores events POSTed as JSON, the way graphite-web's /events/ does.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "events", &config.API)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		accessLogDetails.HttpCode = http.StatusMethodNotAllowed
+		accessLogDetails.Reason = "only POST is supported"
+		logAsError = true
+		return
+	}
+
+	store := events.Default()
+	if store == nil {
+		http.Error(w, events.ErrNotConfigured.Error(), http.StatusNotImplemented)
+		accessLogDetails.HttpCode = http.StatusNotImplemented
+		accessLogDetails.Reason = events.ErrNotConfigured.Error()
+		logAsError = true
+		return
+	}
+
+	var ev events.Event
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	if err := store.Add(ctx, ev); err != nil {
+		code := http.StatusInternalServerError
+		if err == events.ErrEmptyWhat {
+			code = http.StatusBadRequest
+		}
+		http.Error(w, http.StatusText(code)+": "+err.Error(), code)
+		accessLogDetails.HttpCode = int32(code)
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	accessLogDetails.HttpCode = http.StatusOK
+}
+
+// eventsGetDataHandler mirrors graphite-web's /events/get_data.
+func eventsGetDataHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "events", &config.API)
+	accessLogDetails.Format = jsonFormat
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	store := events.Default()
+	if store == nil {
+		http.Error(w, events.ErrNotConfigured.Error(), http.StatusNotImplemented)
+		accessLogDetails.HttpCode = http.StatusNotImplemented
+		accessLogDetails.Reason = events.ErrNotConfigured.Error()
+		logAsError = true
+		return
+	}
+
+	qtz := r.FormValue("tz")
+	var from, until int32
+	ec, err := parseEvalContext(r.Form, qtz)
+	if err == nil {
+		from, until, err = ec.timeRange(r.FormValue("from"), r.FormValue("until"), qtz)
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	query := events.Query{
+		From:  from,
+		Until: until,
+		Tags:  events.ParseTags(r.FormValue("tags")),
+		Union: r.FormValue("set") == "union",
+	}
+	accessLogDetails.FromRaw = r.FormValue("from")
+	accessLogDetails.UntilRaw = r.FormValue("until")
+	accessLogDetails.From = query.From
+	accessLogDetails.Until = query.Until
+
+	evs, err := store.Find(ctx, query)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	if evs == nil {
+		evs = []events.Event{}
+	}
+
+	b, err := json.Marshal(evs)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	writeResponse(w, b, jsonFormat, r.FormValue("jsonp"))
+	accessLogDetails.HttpCode = http.StatusOK
+}
+
 func lbcheckHandler(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
 
@@ -813,7 +1224,14 @@ func lbcheckHandler(w http.ResponseWriter, r *http.Request) {
 
 	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "lbcheck", &config.API)
 	accessLogDetails.Runtime = time.Since(t0).Seconds()
-	zapwriter.Logger("access").Info("request served", zap.Any("data", accessLogDetails))
+	scrubbedLogger("access").Info("request served", zap.Any("data", accessLogDetails))
+}
+
+type versionInfo struct {
+	Version      string   `json:"version"`
+	BuildVersion string   `json:"buildVersion"`
+	Protocols    []string `json:"protocols"`
+	Functions    []string `json:"functions"`
 }
 
 func versionHandler(w http.ResponseWriter, r *http.Request) {
@@ -826,15 +1244,27 @@ func versionHandler(w http.ResponseWriter, r *http.Request) {
 		prometheusMetrics.Responses.WithLabelValues("200", "version").Inc()
 	}()
 
-	if config.GraphiteWeb09Compatibility {
-		w.Write([]byte("0.9.15\n"))
+	// Clients like grafana read the plain graphite-web version, the
+	// details are only given in JSON.
+	if r.FormValue("format") == jsonFormat {
+		native, proxied := functionNames()
+		functions := append(native, proxied...)
+		sort.Strings(functions)
+
+		b, _ := json.Marshal(versionInfo{
+			Version:      graphiteVersion(),
+			BuildVersion: BuildVersion,
+			Protocols:    renderFormats,
+			Functions:    functions,
+		})
+		writeResponse(w, b, jsonFormat, r.FormValue("jsonp"))
 	} else {
-		w.Write([]byte("1.0.0\n"))
+		w.Write([]byte(graphiteVersion() + "\n"))
 	}
 
 	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "version", &config.API)
 	accessLogDetails.Runtime = time.Since(t0).Seconds()
-	zapwriter.Logger("access").Info("request served", zap.Any("data", accessLogDetails))
+	scrubbedLogger("access").Info("request served", zap.Any("data", accessLogDetails))
 }
 
 func functionsHandler(w http.ResponseWriter, r *http.Request) {
@@ -956,7 +1386,7 @@ func functionsHandler(w http.ResponseWriter, r *http.Request) {
 // Otherwise, it creates the config file with the rule
 func blockHeaders(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
-	logger := zapwriter.Logger("logger")
+	logger := scrubbedLogger("logger")
 
 	apiMetrics.Requests.Add(1)
 
@@ -1070,9 +1500,22 @@ func shouldBlockRequest(r *http.Request) bool {
 var usageMsg = []byte(`
 supported requests:
 	/render/?target=
+	/render/stream?target=&from=&refresh=
 	/metrics/find/?query=
-	/info/?target=
+	/info/?target=&view=merged
 	/functions/
+	/version/?format=json
+	/capabilities/
+	/events/get_data?from=&until=&tags=
+	/annotations (POST, Grafana simple-json)
+	/dashboard/find/?query=
+	/dashboard/load/<name>
+
+/render/, /metrics/find/ and /info/ take the format from the Accept header
+when the format parameter is not given.
+
+/render/ and /metrics/find/ also take their parameters POSTed, url-encoded or
+as a JSON object.
 `)
 
 func usageHandler(w http.ResponseWriter, r *http.Request) {
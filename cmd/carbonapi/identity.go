@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/pkg/signature"
+)
+
+// requestUser returns the verified user of r: the client that signed it or,
+// if identity.trustedHeader is set, the user the authenticating proxy in
+// front of carbonapi put there. It returns "" for anonymous requests. Basic
+// auth usernames are not verified by carbonapi and never used.
+func requestUser(r *http.Request) string {
+	if client := signature.FromContext(r.Context()); client != "" {
+		return client
+	}
+	if config.Identity.TrustedHeader != "" {
+		return r.Header.Get(config.Identity.TrustedHeader)
+	}
+
+	return ""
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// mergedInfo is the schema of a metric as all the backends that have it
+// see it, which /info serves with view=merged instead of one copy per
+// backend.
+type mergedInfo struct {
+	Name string `json:"name"`
+	// AggregationMethod and XFilesFactor are those of most backends, and
+	// MaxRetention the longest of them.
+	AggregationMethod string              `json:"aggregationMethod"`
+	XFilesFactor      float32             `json:"xFilesFactor"`
+	MaxRetention      int32               `json:"maxRetention"`
+	Retentions        []mergedRetention   `json:"retentions"`
+	Backends          []string            `json:"backends"`
+	Conflicts         map[string]conflict `json:"conflicts,omitempty"`
+}
+
+// mergedRetention is an archive of the metric on some of the backends.
+type mergedRetention struct {
+	SecondsPerPoint int32 `json:"secondsPerPoint"`
+	NumberOfPoints  int32 `json:"numberOfPoints"`
+	// Backends is set if not all the backends have the archive.
+	Backends []string `json:"backends,omitempty"`
+}
+
+// conflict lists the backends that have each of the values of a field
+// the backends disagree on.
+type conflict map[string][]string
+
+// mergeInfo merges the infos of a metric by backend.
+func mergeInfo(infos map[string]pb.InfoResponse) mergedInfo {
+	backends := make([]string, 0, len(infos))
+	for b := range infos {
+		backends = append(backends, b)
+	}
+	sort.Strings(backends)
+
+	m := mergedInfo{
+		Backends:   backends,
+		Retentions: []mergedRetention{},
+	}
+	methods := make(conflict)
+	factors := make(conflict)
+	schemas := make(conflict)
+	type archive struct{ step, points int32 }
+	archives := make(map[archive][]string)
+	for _, b := range backends {
+		info := infos[b]
+		if m.Name == "" {
+			m.Name = info.Name
+		}
+		if info.MaxRetention > m.MaxRetention {
+			m.MaxRetention = info.MaxRetention
+		}
+		methods[info.AggregationMethod] = append(methods[info.AggregationMethod], b)
+		factor := fmt.Sprint(info.XFilesFactor)
+		factors[factor] = append(factors[factor], b)
+
+		schema := make([]string, 0, len(info.Retentions))
+		for _, r := range info.Retentions {
+			schema = append(schema, fmt.Sprintf("%d:%d", r.SecondsPerPoint, r.NumberOfPoints))
+			a := archive{r.SecondsPerPoint, r.NumberOfPoints}
+			archives[a] = append(archives[a], b)
+		}
+		s := strings.Join(schema, ",")
+		schemas[s] = append(schemas[s], b)
+	}
+
+	m.AggregationMethod = methods.majority()
+	if len(backends) > 0 {
+		m.XFilesFactor = infos[factors[factors.majority()][0]].XFilesFactor
+	}
+
+	for a, bs := range archives {
+		mr := mergedRetention{SecondsPerPoint: a.step, NumberOfPoints: a.points}
+		if len(bs) < len(backends) {
+			mr.Backends = bs
+		}
+		m.Retentions = append(m.Retentions, mr)
+	}
+	sort.Slice(m.Retentions, func(i, j int) bool {
+		a, b := m.Retentions[i], m.Retentions[j]
+		if a.SecondsPerPoint != b.SecondsPerPoint {
+			return a.SecondsPerPoint < b.SecondsPerPoint
+		}
+		return a.NumberOfPoints < b.NumberOfPoints
+	})
+
+	for field, c := range map[string]conflict{
+		"aggregationMethod": methods,
+		"xFilesFactor":      factors,
+		"retentions":        schemas,
+	} {
+		if len(c) > 1 {
+			if m.Conflicts == nil {
+				m.Conflicts = make(map[string]conflict)
+			}
+			m.Conflicts[field] = c
+		}
+	}
+
+	return m
+}
+
+// majority returns the value most backends have, the first in order of
+// ties.
+func (c conflict) majority() string {
+	var best string
+	n := -1
+	for v, bs := range c {
+		if len(bs) > n || (len(bs) == n && v < best) {
+			best, n = v, len(bs)
+		}
+	}
+
+	return best
+}
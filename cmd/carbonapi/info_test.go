@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeInfo(t *testing.T) {
+	daily := []pb.Retention{{SecondsPerPoint: 60, NumberOfPoints: 1440}, {SecondsPerPoint: 3600, NumberOfPoints: 720}}
+	infos := map[string]pb.InfoResponse{
+		"store3": {Name: "foo.bar", AggregationMethod: "sum", MaxRetention: 2592000, XFilesFactor: 0.5, Retentions: daily},
+		"store1": {Name: "foo.bar", AggregationMethod: "average", MaxRetention: 2592000, XFilesFactor: 0.5, Retentions: daily},
+		"store2": {Name: "foo.bar", AggregationMethod: "average", MaxRetention: 31536000, XFilesFactor: 0.5, Retentions: append(daily[:1:1], pb.Retention{SecondsPerPoint: 3600, NumberOfPoints: 8760})},
+	}
+
+	b, err := json.Marshal(mergeInfo(infos))
+	assert.Nil(t, err)
+
+	expected := `{"name":"foo.bar","aggregationMethod":"average","xFilesFactor":0.5,"maxRetention":31536000,` +
+		`"retentions":[{"secondsPerPoint":60,"numberOfPoints":1440},{"secondsPerPoint":3600,"numberOfPoints":720,"backends":["store1","store3"]},{"secondsPerPoint":3600,"numberOfPoints":8760,"backends":["store2"]}],` +
+		`"backends":["store1","store2","store3"],` +
+		`"conflicts":{"aggregationMethod":{"average":["store1","store2"],"sum":["store3"]},"retentions":{"60:1440,3600:720":["store1","store3"],"60:1440,3600:8760":["store2"]}}}`
+	assert.Equal(t, expected, string(b))
+}
+
+func TestMergeInfoAgreeing(t *testing.T) {
+	info := pb.InfoResponse{Name: "foo", AggregationMethod: "max", Retentions: []pb.Retention{{SecondsPerPoint: 10, NumberOfPoints: 6}}}
+	m := mergeInfo(map[string]pb.InfoResponse{"a": info, "b": info})
+
+	assert.Equal(t, "max", m.AggregationMethod)
+	assert.Nil(t, m.Conflicts)
+	assert.Equal(t, []mergedRetention{{SecondsPerPoint: 10, NumberOfPoints: 6}}, m.Retentions)
+}
+
+func TestInfoHandlerMerged(t *testing.T) {
+	req, rr := setUpRequest(t, "/info/?target=foo.bar&format=json&view=merged")
+	infoHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var m mergedInfo
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &m))
+	assert.Equal(t, len(getMockInfoResponse()), len(m.Backends))
+}
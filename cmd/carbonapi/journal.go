@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/pkg/journal"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// requestJournal records the parameters of requests by their UUID, for
+// cmd/replay to issue them again. It is nil unless journal is configured.
+var requestJournal *journal.Journal
+
+// journalRequest records r in the request journal, if there is one.
+func journalRequest(r *http.Request, handler string) {
+	if requestJournal == nil {
+		return
+	}
+
+	e := journal.FromRequest(util.GetUUID(r.Context()), handler, r)
+	if err := requestJournal.Record(e); err != nil {
+		zapwriter.Logger("journal").Warn("failed to journal request",
+			zap.String("carbonapi_uuid", e.UUID),
+			zap.Error(err),
+		)
+	}
+}
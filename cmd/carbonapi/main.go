@@ -2,17 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -22,11 +23,31 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions"
 	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
 	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
 	"github.com/bookingcom/carbonapi/expr/rewrite"
+	exprtypes "github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/limiter"
 	"github.com/bookingcom/carbonapi/mstats"
 	"github.com/bookingcom/carbonapi/pathcache"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/blocklist"
+	"github.com/bookingcom/carbonapi/pkg/buildinfo"
+	"github.com/bookingcom/carbonapi/pkg/carbon"
+	"github.com/bookingcom/carbonapi/pkg/dashboard"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/histogram"
+	"github.com/bookingcom/carbonapi/pkg/intern"
+	"github.com/bookingcom/carbonapi/pkg/journal"
+	"github.com/bookingcom/carbonapi/pkg/listener"
 	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/rename"
+	"github.com/bookingcom/carbonapi/pkg/scrub"
+	"github.com/bookingcom/carbonapi/pkg/secrets"
+	"github.com/bookingcom/carbonapi/pkg/shutdown"
+	"github.com/bookingcom/carbonapi/pkg/signature"
+	"github.com/bookingcom/carbonapi/pkg/statsd"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/util"
 	realZipper "github.com/bookingcom/carbonapi/zipper"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
@@ -44,10 +65,11 @@ import (
 )
 
 var prometheusMetrics = struct {
-	Requests     prometheus.Counter
-	Responses    *prometheus.CounterVec
-	DurationsExp prometheus.Histogram
-	DurationsLin prometheus.Histogram
+	Requests  prometheus.Counter
+	Responses *prometheus.CounterVec
+	// The durations are set up with the configured buckets.
+	DurationsExp *histogram.Vec
+	DurationsLin *histogram.Vec
 }{
 	Requests: prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -62,20 +84,6 @@ var prometheusMetrics = struct {
 		},
 		[]string{"code", "handler"},
 	),
-	DurationsExp: prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds_exp",
-			Help:    "The duration of HTTP requests (exponential)",
-			Buckets: prometheus.ExponentialBuckets((50 * time.Millisecond).Seconds(), 2.0, 20),
-		},
-	),
-	DurationsLin: prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds_lin",
-			Help:    "The duration of HTTP requests (linear)",
-			Buckets: prometheus.LinearBuckets(0.0, (50 * time.Millisecond).Seconds(), 40), // Up to 2 seconds
-		},
-	),
 }
 
 var apiMetrics = struct {
@@ -94,13 +102,53 @@ var apiMetrics = struct {
 	RequestCacheHits      *expvar.Int
 	RequestCacheMisses    *expvar.Int
 	RenderCacheOverheadNS *expvar.Int
+	// SavedFetches counts fetches skipped because another target of the
+	// same request already fetched the metric.
+	SavedFetches *expvar.Int
+	// JoinedRetries counts renders answered with the response of the
+	// running render they retried.
+	JoinedRetries *expvar.Int
 
 	FindRequests        *expvar.Int
 	FindCacheHits       *expvar.Int
 	FindCacheMisses     *expvar.Int
 	FindCacheOverheadNS *expvar.Int
+	// CacheStaleHits counts expired render and find cache entries served
+	// while they were refreshed.
+	CacheStaleHits *expvar.Int
+	// DiskCacheHits and DiskCacheMisses count the renders of historical
+	// ranges looked up in the disk cache.
+	DiskCacheHits   *expvar.Int
+	DiskCacheMisses *expvar.Int
+
+	// TenantRequests and TenantRejections count the requests of each
+	// tenant, and those turned away for exceeding its quota.
+	TenantRequests   *expvar.Map
+	TenantRejections *expvar.Map
+
+	// DashboardRequests, DashboardRuntimeNS and DashboardDatapoints count
+	// the renders of each dashboard, the time they took and the points
+	// they returned.
+	DashboardRequests   *expvar.Map
+	DashboardRuntimeNS  *expvar.Map
+	DashboardDatapoints *expvar.Map
+
+	// ACLRejections counts paths left out of responses, or refused, by
+	// the ACL.
+	ACLRejections *expvar.Int
+
+	// SignatureRejections counts requests refused for a missing, expired
+	// or bad signature.
+	SignatureRejections *expvar.Int
+
+	// BlockedRequests counts requests refused for a metric the blocklist
+	// blocks.
+	BlockedRequests *expvar.Int
 
 	MemcacheTimeouts expvar.Func
+	// CacheKeyCollisions counts entries of the memory caches found under
+	// the hash of another key.
+	CacheKeyCollisions expvar.Func
 
 	CacheSize  expvar.Func
 	CacheItems expvar.Func
@@ -114,12 +162,30 @@ var apiMetrics = struct {
 	RequestCacheHits:      expvar.NewInt("request_cache_hits"),
 	RequestCacheMisses:    expvar.NewInt("request_cache_misses"),
 	RenderCacheOverheadNS: expvar.NewInt("render_cache_overhead_ns"),
+	SavedFetches:          expvar.NewInt("saved_fetches"),
+	JoinedRetries:         expvar.NewInt("joined_retries"),
 
 	FindRequests: expvar.NewInt("find_requests"),
 
 	FindCacheHits:       expvar.NewInt("find_cache_hits"),
 	FindCacheMisses:     expvar.NewInt("find_cache_misses"),
 	FindCacheOverheadNS: expvar.NewInt("find_cache_overhead_ns"),
+	CacheStaleHits:      expvar.NewInt("cache_stale_hits"),
+	DiskCacheHits:       expvar.NewInt("disk_cache_hits"),
+	DiskCacheMisses:     expvar.NewInt("disk_cache_misses"),
+
+	TenantRequests:   expvar.NewMap("tenant_requests"),
+	TenantRejections: expvar.NewMap("tenant_rejections"),
+
+	DashboardRequests:   expvar.NewMap("dashboard_requests"),
+	DashboardRuntimeNS:  expvar.NewMap("dashboard_runtime_ns"),
+	DashboardDatapoints: expvar.NewMap("dashboard_datapoints"),
+
+	ACLRejections: expvar.NewInt("acl_rejections"),
+
+	SignatureRejections: expvar.NewInt("signature_rejections"),
+
+	BlockedRequests: expvar.NewInt("blocked_requests"),
 }
 
 var zipperMetrics = struct {
@@ -137,8 +203,10 @@ var zipperMetrics = struct {
 	CacheSize  expvar.Func
 	CacheItems expvar.Func
 
-	CacheMisses *expvar.Int
-	CacheHits   *expvar.Int
+	CacheMisses  *expvar.Int
+	CacheHits    *expvar.Int
+	Rebroadcasts *expvar.Int
+	Pushdowns    *expvar.Int
 }{
 	FindRequests: expvar.NewInt("zipper_find_requests"),
 	FindErrors:   expvar.NewInt("zipper_find_errors"),
@@ -151,8 +219,10 @@ var zipperMetrics = struct {
 
 	Timeouts: expvar.NewInt("zipper_timeouts"),
 
-	CacheHits:   expvar.NewInt("zipper_cache_hits"),
-	CacheMisses: expvar.NewInt("zipper_cache_misses"),
+	CacheHits:    expvar.NewInt("zipper_cache_hits"),
+	CacheMisses:  expvar.NewInt("zipper_cache_misses"),
+	Rebroadcasts: expvar.NewInt("zipper_rebroadcasts"),
+	Pushdowns:    expvar.NewInt("zipper_pushdowns"),
 }
 
 const (
@@ -162,6 +232,13 @@ const (
 // BuildVersion is provided to be overridden at build time. Eg. go build -ldflags -X 'main.BuildVersion=...'
 var BuildVersion = "(development build)"
 
+// BuildRevision is the git revision, overridden at build time like BuildVersion.
+var BuildRevision = ""
+
+// buildInfo exports BuildVersion, BuildRevision and the hash of the config
+// to Prometheus.
+var buildInfo = buildinfo.New(BuildVersion, BuildRevision)
+
 // for testing
 var timeNow = time.Now
 
@@ -179,7 +256,7 @@ func buildParseErrorString(target, e string, err error) string {
 }
 
 func deferredAccessLogging(r *http.Request, accessLogDetails *carbonapipb.AccessLogDetails, t time.Time, logAsError bool) {
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := scrubbedLogger("access")
 
 	accessLogDetails.Runtime = time.Since(t).Seconds()
 	accessLogDetails.RequestMethod = r.Method
@@ -258,10 +335,25 @@ var config = struct {
 
 	queryCache       cache.BytesCache
 	findCache        cache.BytesCache
+	diskCache        *cache.DiskCache
 	blockHeaderRules RuleConfig
 
 	defaultTimeZone *time.Location
 
+	macros parser.Macros
+
+	nullPolicy exprtypes.NullPolicy
+
+	tenancy *tenant.Tenancy
+	acl     *acl.ACL
+	signer  *signature.Verifier
+	renames *renames
+	blocked *blocked
+
+	scrubber *scrub.Scrubber
+
+	dashboards dashboard.Store
+
 	zipper CarbonZipper
 
 	// Limiter limits concurrent zipper requests
@@ -284,6 +376,8 @@ func zipperStats(stats *realZipper.Stats) {
 
 	zipperMetrics.CacheMisses.Add(stats.CacheMisses)
 	zipperMetrics.CacheHits.Add(stats.CacheHits)
+	zipperMetrics.Rebroadcasts.Add(stats.Rebroadcasts)
+	zipperMetrics.Pushdowns.Add(stats.Pushdowns)
 }
 
 var graphTemplates map[string]png.PictureParams
@@ -309,9 +403,82 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 	rewrite.New(config.FunctionsConfigs)
 	functions.New(config.FunctionsConfigs)
 
+	config.nullPolicy, err = exprtypes.ParseNullPolicy(config.NullPoints)
+	if err != nil {
+		logger.Fatal("invalid nullPoints", zap.Error(err))
+	}
+
+	if config.TopQueries.Size > 0 {
+		if config.TopQueries.Window <= 0 || config.TopQueries.PrefixDepth <= 0 {
+			logger.Fatal("topQueries window and prefixDepth must be positive")
+		}
+		heavyHitters = newTopQueries(config.TopQueries.Size, config.TopQueries.Window, config.TopQueries.PrefixDepth)
+		prometheus.MustRegister(heavyHitters)
+	}
+
+	if config.Journal.File != "" {
+		requestJournal, err = journal.Open(config.Journal.File, int64(config.Journal.Size)*1024*1024)
+		if err != nil {
+			logger.Fatal("failed to open the request journal",
+				zap.String("file", config.Journal.File),
+				zap.Error(err),
+			)
+		}
+	}
+
+	config.macros, err = parser.NewMacros(config.Macros)
+	if err != nil {
+		logger.Fatal("invalid macros", zap.Error(err))
+	}
+	for name := range config.macros {
+		if _, ok := metadata.FunctionMD.Functions[name]; ok {
+			logger.Fatal("macro has the name of a function",
+				zap.String("macro", name),
+			)
+		}
+	}
+
+	if b := config.TimeoutBudget; b != (cfg.TimeoutBudget{}) && (b.Find <= 0 || b.Fetch <= 0 || b.Eval <= 0) {
+		logger.Fatal("every stage of the timeout budget needs a positive share",
+			zap.Any("timeout_budget", b),
+		)
+	}
+
+	if config.DefaultXFilesFactor < 0 || config.DefaultXFilesFactor > 1 {
+		logger.Fatal("defaultXFilesFactor must be between 0 and 1",
+			zap.Float32("default_xfiles_factor", config.DefaultXFilesFactor),
+		)
+	}
+
+	config.tenancy, err = tenant.New(config.Tenancy)
+	if err != nil {
+		logger.Fatal("invalid tenancy", zap.Error(err))
+	}
+
+	config.acl, err = acl.New(config.ACL)
+	if err != nil {
+		logger.Fatal("invalid acl", zap.Error(err))
+	}
+
+	config.signer, err = signature.New(config.Signatures)
+	if err != nil {
+		logger.Fatal("invalid signatures", zap.Error(err))
+	}
+
+	if config.Blocklist.File != "" {
+		l, err := blocklist.Load(config.Blocklist.File)
+		if err != nil {
+			logger.Fatal("invalid blocklist", zap.Error(err))
+		}
+		config.blocked = &blocked{list: l}
+	}
+
 	expvar.NewString("GoVersion").Set(runtime.Version())
 	expvar.NewString("BuildVersion").Set(BuildVersion)
 	expvar.Publish("config", expvar.Func(func() interface{} { return config }))
+	if err := buildInfo.SetConfig(config.API); err != nil {
+		logger.Warn("failed to hash the config", zap.Error(err))
+	}
 
 	apiMetrics.Goroutines = expvar.Func(func() interface{} {
 		return runtime.NumGoroutine()
@@ -326,7 +493,53 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 
 	// TODO(gmagnusson): Shouldn't limiter live in config.zipper?
 	config.limiter = limiter.NewServerLimiter([]string{localHostName}, config.ConcurrencyLimitPerServer)
-	config.zipper = zipper
+	config.zipper = futureZipper{CarbonZipper: zipper}
+	if len(config.Rollups) > 0 {
+		config.zipper, err = newRollupZipper(config.zipper, config.Rollups)
+		if err != nil {
+			logger.Fatal("invalid rollups", zap.Error(err))
+		}
+	}
+	if config.Renames.File != "" {
+		table, err := rename.Load(config.Renames.File)
+		if err != nil {
+			logger.Fatal("invalid renames", zap.Error(err))
+		}
+		config.renames = &renames{table: table}
+		config.zipper = renameZipper{CarbonZipper: config.zipper, renames: config.renames}
+	}
+	if config.tenancy != nil {
+		config.zipper = tenantZipper{CarbonZipper: config.zipper, tenancy: config.tenancy}
+	}
+	if config.acl != nil {
+		config.zipper = aclZipper{CarbonZipper: config.zipper, acl: config.acl}
+	}
+
+	if len(config.Exports.Jobs) > 0 {
+		exports, err = newExporter(config.Exports, logger.With(zap.String("handler", "export")))
+		if err != nil {
+			logger.Fatal("invalid exports", zap.Error(err))
+		}
+	}
+
+	if len(config.RecordingRules.Rules) > 0 {
+		recordingRules, err = newRuleRecorder(config.RecordingRules, logger.With(zap.String("handler", "recording_rule")))
+		if err != nil {
+			logger.Fatal("invalid recording rules", zap.Error(err))
+		}
+	}
+
+	if config.MetricsPut.Enabled {
+		client, err := carbon.New(config.MetricsPut.Address, config.MetricsPut.Protocol, config.MetricsPut.Timeout)
+		if err != nil {
+			logger.Fatal("invalid metricsPut", zap.Error(err))
+		}
+		putRelay = carbon.NewRelay(client, config.MetricsPut.QueueSize, config.MetricsPut.BatchSize,
+			config.MetricsPut.FlushInterval, logger.With(zap.String("handler", "put")))
+		expvar.Publish("metrics_put_queued", expvar.Func(func() interface{} {
+			return putRelay.Queued()
+		}))
+	}
 
 	apiMetrics.LimiterUse = expvar.Func(func() interface{} {
 		return config.limiter.LimiterUse()
@@ -391,6 +604,81 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 		)
 	}
 
+	// The keys of renders have all their targets in them, so the memory
+	// caches are keyed by their hash. Memcached hashes keys already.
+	var hashed []*cache.HashedCache
+	if _, ok := config.queryCache.(*cache.ExpireCache); ok {
+		hc := cache.NewHashedCache(config.queryCache)
+		config.queryCache = hc
+		hashed = append(hashed, hc)
+	}
+	if _, ok := config.findCache.(*cache.ExpireCache); ok {
+		hc := cache.NewHashedCache(config.findCache)
+		config.findCache = hc
+		hashed = append(hashed, hc)
+	}
+	if len(hashed) > 0 {
+		apiMetrics.CacheKeyCollisions = expvar.Func(func() interface{} {
+			var collisions uint64
+			for _, hc := range hashed {
+				collisions += hc.Collisions()
+			}
+			return collisions
+		})
+		expvar.Publish("cache_key_collisions", apiMetrics.CacheKeyCollisions)
+	}
+
+	if config.Cache.Compress {
+		config.queryCache = cache.NewCompressedCache(config.queryCache)
+		config.findCache = cache.NewCompressedCache(config.findCache)
+	}
+
+	if config.Cache.StaleWindowSec > 0 {
+		config.queryCache = cache.NewStaleCache(config.queryCache, config.Cache.StaleWindowSec)
+		config.findCache = cache.NewStaleCache(config.findCache, config.Cache.StaleWindowSec)
+	}
+
+	if config.Cache.Disk.Path != "" {
+		config.diskCache, err = cache.NewDiskCache(config.Cache.Disk.Path, uint64(config.Cache.Disk.Size)*1024*1024)
+		if err != nil {
+			logger.Fatal("failed to set up disk cache",
+				zap.String("path", config.Cache.Disk.Path),
+				zap.Error(err),
+			)
+		}
+
+		dcache := config.diskCache
+		expvar.Publish("disk_cache_size", expvar.Func(func() interface{} {
+			return dcache.Size()
+		}))
+		expvar.Publish("disk_cache_items", expvar.Func(func() interface{} {
+			return dcache.Items()
+		}))
+	}
+
+	eventsStore, err := events.New(config.Events)
+	if err != nil {
+		logger.Fatal("failed to set up events store",
+			zap.String("events_type", config.Events.Type),
+			zap.Error(err),
+		)
+	}
+	if eventsStore != nil && config.tenancy != nil {
+		eventsStore = tenantEvents{Store: eventsStore}
+	}
+	events.SetDefault(eventsStore)
+
+	config.dashboards, err = dashboard.New(config.Dashboards)
+	if err != nil {
+		logger.Fatal("failed to set up dashboard store",
+			zap.String("dashboards_type", config.Dashboards.Type),
+			zap.Error(err),
+		)
+	}
+	if config.dashboards != nil && config.tenancy != nil {
+		config.dashboards = tenantDashboards{Store: config.dashboards}
+	}
+
 	if config.TimezoneString != "" {
 		fields := strings.Split(config.TimezoneString, ",")
 
@@ -417,9 +705,24 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 		)
 	}
 
+	if err := checkResponseHeaders(config.ResponseHeaders, config.RequestIDHeader); err != nil {
+		logger.Fatal("invalid response headers",
+			zap.Error(err),
+		)
+	}
+
 	if len(config.UnicodeRangeTables) != 0 {
 		for _, stringRange := range config.UnicodeRangeTables {
-			parser.RangeTables = append(parser.RangeTables, unicode.Scripts[stringRange])
+			table, ok := unicode.Scripts[stringRange]
+			if !ok {
+				table, ok = unicode.Categories[stringRange]
+			}
+			if !ok {
+				logger.Fatal("unknown unicode range table",
+					zap.String("table", stringRange),
+				)
+			}
+			parser.RangeTables = append(parser.RangeTables, table)
 		}
 	} else {
 		parser.RangeTables = append(parser.RangeTables, unicode.Latin)
@@ -446,15 +749,20 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 		zap.Any("config", config),
 	)
 
-	// +1 to track every over the number of buckets we track
-	timeBuckets = make([]int64, config.Buckets+1)
-	expTimeBuckets = make([]int64, config.Buckets+1)
-	expvar.Publish("requestBuckets", expvar.Func(renderTimeBuckets))
-	expvar.Publish("expRequestBuckets", expvar.Func(renderExpTimeBuckets))
-
-	if host != "" {
-		// register our metrics with graphite
-		graphite := g2g.NewGraphite(host, config.Graphite.Interval, 10*time.Second)
+	setUpDurations()
+	expvar.Publish("requestBuckets", expvar.Func(func() interface{} {
+		return prometheusMetrics.DurationsLin.Counts()
+	}))
+	expvar.Publish("expRequestBuckets", expvar.Func(func() interface{} {
+		return prometheusMetrics.DurationsExp.Counts()
+	}))
+
+	if host != "" || config.StatsD.Address != "" {
+		// register our metrics with graphite and statsd
+		var graphite statsd.Tee
+		if host != "" {
+			graphite = append(graphite, g2g.NewGraphite(host, config.Graphite.Interval, 10*time.Second))
+		}
 
 		hostname, _ := os.Hostname()
 		hostname = strings.Replace(hostname, ".", "_", -1)
@@ -465,14 +773,21 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 		pattern = strings.Replace(pattern, "{prefix}", prefix, -1)
 		pattern = strings.Replace(pattern, "{fqdn}", hostname, -1)
 
+		if config.StatsD.Address != "" {
+			graphite = append(graphite, newStatsD(pattern, logger))
+		}
+
 		graphite.Register(fmt.Sprintf("%s.requests", pattern), apiMetrics.Requests)
 		graphite.Register(fmt.Sprintf("%s.responses", pattern), apiMetrics.Responses)
 		graphite.Register(fmt.Sprintf("%s.errors", pattern), apiMetrics.Errors)
 
-		for i := 0; i <= config.Buckets; i++ {
-			graphite.Register(fmt.Sprintf("%s.requests_in_%dms_to_%dms", pattern, i*100, (i+1)*100), bucketEntry(i))
-			lower, upper := util.Bounds(i)
-			graphite.Register(fmt.Sprintf("%s.exp.requests_in_%05dms_to_%05dms", pattern, lower, upper), bucketEntry(i))
+		for i := 0; i < prometheusMetrics.DurationsLin.Buckets(); i++ {
+			lower, upper := prometheusMetrics.DurationsLin.Bounds(i)
+			graphite.Register(fmt.Sprintf("%s.requests_in_%dms_to_%dms", pattern, lower, upper), prometheusMetrics.DurationsLin.Bucket(i))
+		}
+		for i := 0; i < prometheusMetrics.DurationsExp.Buckets(); i++ {
+			lower, upper := prometheusMetrics.DurationsExp.Bounds(i)
+			graphite.Register(fmt.Sprintf("%s.exp.requests_in_%05dms_to_%05dms", pattern, lower, upper), prometheusMetrics.DurationsExp.Bucket(i))
 		}
 
 		graphite.Register(fmt.Sprintf("%s.request_cache_hits", pattern), apiMetrics.RequestCacheHits)
@@ -483,13 +798,25 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 		graphite.Register(fmt.Sprintf("%s.find_cache_hits", pattern), apiMetrics.FindCacheHits)
 		graphite.Register(fmt.Sprintf("%s.find_cache_misses", pattern), apiMetrics.FindCacheMisses)
 		graphite.Register(fmt.Sprintf("%s.find_cache_overhead_ns", pattern), apiMetrics.FindCacheOverheadNS)
+		graphite.Register(fmt.Sprintf("%s.cache_stale_hits", pattern), apiMetrics.CacheStaleHits)
+		graphite.Register(fmt.Sprintf("%s.disk_cache_hits", pattern), apiMetrics.DiskCacheHits)
+		graphite.Register(fmt.Sprintf("%s.disk_cache_misses", pattern), apiMetrics.DiskCacheMisses)
+		graphite.Register(fmt.Sprintf("%s.acl_rejections", pattern), apiMetrics.ACLRejections)
+		graphite.Register(fmt.Sprintf("%s.signature_rejections", pattern), apiMetrics.SignatureRejections)
+		graphite.Register(fmt.Sprintf("%s.blocked_requests", pattern), apiMetrics.BlockedRequests)
 
 		graphite.Register(fmt.Sprintf("%s.render_requests", pattern), apiMetrics.RenderRequests)
+		graphite.Register(fmt.Sprintf("%s.saved_fetches", pattern), apiMetrics.SavedFetches)
+		graphite.Register(fmt.Sprintf("%s.joined_retries", pattern), apiMetrics.JoinedRetries)
 
 		if apiMetrics.MemcacheTimeouts != nil {
 			graphite.Register(fmt.Sprintf("%s.memcache_timeouts", pattern), apiMetrics.MemcacheTimeouts)
 		}
 
+		if apiMetrics.CacheKeyCollisions != nil {
+			graphite.Register(fmt.Sprintf("%s.cache_key_collisions", pattern), apiMetrics.CacheKeyCollisions)
+		}
+
 		if apiMetrics.CacheSize != nil {
 			graphite.Register(fmt.Sprintf("%s.cache_size", pattern), apiMetrics.CacheSize)
 			graphite.Register(fmt.Sprintf("%s.cache_items", pattern), apiMetrics.CacheItems)
@@ -511,6 +838,8 @@ func setUpConfig(logger *zap.Logger, zipper CarbonZipper) {
 
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_hits", pattern), zipperMetrics.CacheHits)
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_misses", pattern), zipperMetrics.CacheMisses)
+		graphite.Register(fmt.Sprintf("%s.zipper.rebroadcasts", pattern), zipperMetrics.Rebroadcasts)
+		graphite.Register(fmt.Sprintf("%s.zipper.pushdowns", pattern), zipperMetrics.Pushdowns)
 
 		go mstats.Start(config.Graphite.Interval)
 
@@ -547,73 +876,91 @@ func setUpConfigUpstreams(logger *zap.Logger) {
 		logger.Fatal("no backends specified for upstreams!")
 	}
 
+	if _, err := types.ParseStepPolicy(config.StepPolicy); err != nil {
+		logger.Fatal("invalid stepPolicy", zap.Error(err))
+	}
+
 	// Setup in-memory path cache for carbonzipper requests
-	config.PathCache = pathcache.NewPathCache(config.ExpireDelaySec)
+	var err error
+	config.Names = intern.New()
+	config.PathCache, err = pathcache.New(config.PathCacheType, config.ExpireDelaySec, config.PathCacheDepth, config.Names)
+	if err != nil {
+		logger.Fatal("invalid pathCacheType", zap.Error(err))
+	}
+	if config.ExpireDelaySec > 0 {
+		go func() {
+			// A generation outlasts the path cache entries that intern
+			// the names.
+			for range time.Tick(time.Duration(config.ExpireDelaySec) * time.Second) {
+				config.Names.Rotate()
+			}
+		}()
+	}
 
 	zipperMetrics.CacheSize = expvar.Func(func() interface{} { return config.PathCache.ECSize() })
 	expvar.Publish("cacheSize", zipperMetrics.CacheSize)
 
 	zipperMetrics.CacheItems = expvar.Func(func() interface{} { return config.PathCache.ECItems() })
 	expvar.Publish("cacheItems", zipperMetrics.CacheItems)
-}
-
-var timeBuckets []int64
-var expTimeBuckets []int64
-
-type bucketEntry int
-type expBucketEntry int
 
-func (b bucketEntry) String() string {
-	return strconv.Itoa(int(atomic.LoadInt64(&timeBuckets[b])))
+	loadPathCache(logger)
 }
 
-func (b expBucketEntry) String() string {
-	return strconv.Itoa(int(atomic.LoadInt64(&expTimeBuckets[b])))
-}
-
-func renderTimeBuckets() interface{} {
-	return timeBuckets
+func setUpLogScrubbing(logger *zap.Logger) {
+	var err error
+	config.scrubber, err = scrub.New(config.LogScrubbing)
+	if err != nil {
+		logger.Fatal("invalid logScrubbing", zap.Error(err))
+	}
 }
 
-func renderExpTimeBuckets() interface{} {
-	return timeBuckets
+// scrubbedLogger returns the named logger, which redacts what logScrubbing
+// asks for.
+func scrubbedLogger(name string) *zap.Logger {
+	return config.scrubber.Wrap(zapwriter.Logger(name))
 }
 
-func findBucketIndex(buckets []int64, bucket int) int {
-	var i int
-	if bucket < 0 {
-		i = 0
-	} else if bucket < len(buckets)-1 {
-		i = bucket
-	} else {
-		i = len(buckets) - 1
+// setUpDurations sets up the histograms of request durations, by handler.
+// Their buckets default to those graphite has always been sent.
+func setUpDurations() {
+	lin := config.Histograms.Linear
+	if len(lin) == 0 {
+		lin = prometheus.LinearBuckets(0.1, 0.1, config.Buckets)
+	}
+	exp := config.Histograms.Exponential
+	if len(exp) == 0 {
+		exp = prometheus.ExponentialBuckets(0.05, 2, config.Buckets)
 	}
 
-	return i
+	prometheusMetrics.DurationsLin = histogram.New(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds_lin",
+		Help:    "The duration of HTTP requests (linear), partitioned by handler",
+		Buckets: lin,
+	}, "handler")
+	prometheusMetrics.DurationsExp = histogram.New(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds_exp",
+		Help:    "The duration of HTTP requests (exponential), partitioned by handler",
+		Buckets: exp,
+	}, "handler")
 }
 
-func bucketRequestTimes(req *http.Request, t time.Duration) {
-	logger := zapwriter.Logger("slow")
-
-	ms := t.Nanoseconds() / int64(time.Millisecond)
-
-	bucket := int(ms / 100)
-	bucketIdx := findBucketIndex(timeBuckets, bucket)
-	atomic.AddInt64(&timeBuckets[bucketIdx], 1)
-
-	expBucket := util.Bucket(ms, config.Buckets)
-	expBucketIdx := findBucketIndex(expTimeBuckets, expBucket)
-	atomic.AddInt64(&expTimeBuckets[expBucketIdx], 1)
-
-	prometheusMetrics.DurationsExp.Observe(t.Seconds())
-	prometheusMetrics.DurationsLin.Observe(t.Seconds())
-
-	// This seems slow enough to count as a slow request
-	if bucket >= config.Buckets {
-		logger.Warn("Slow Request",
-			zap.Duration("time", t),
-			zap.String("url", req.URL.String()),
-		)
+// bucketRequestTimes returns the func that counts the durations of the
+// requests to handler.
+func bucketRequestTimes(handler string) func(*http.Request, time.Duration) {
+	return func(req *http.Request, t time.Duration) {
+		logger := scrubbedLogger("slow")
+
+		prometheusMetrics.DurationsExp.Observe(t, handler)
+		prometheusMetrics.DurationsLin.Observe(t, handler)
+
+		// This seems slow enough to count as a slow request
+		if t >= time.Duration(config.Buckets)*100*time.Millisecond {
+			logger.Warn("Slow Request",
+				zap.Duration("time", t),
+				zap.String("url", req.URL.String()),
+				zap.String("dashboard", dashboardOf(req)),
+			)
+		}
 	}
 }
 
@@ -640,24 +987,46 @@ func main() {
 			zap.Error(err),
 		)
 	}
-	config.API = api
 	fh.Close()
 
+	raw := api
+	resolver := secrets.New(api.Secrets)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	ttl, err := resolver.Resolve(ctx, &api)
+	cancel()
+	if err != nil {
+		logger.Fatal("Failed to resolve secrets in config file",
+			zap.Error(err),
+		)
+	}
+	config.API = api
+
 	setUpConfigUpstreams(logger)
-	zipper := newZipper(zipperStats, config.Zipper, logger.With(zap.String("handler", "zipper")))
+	setUpLogScrubbing(logger)
+	zipper := newZipper(zipperStats, config.Zipper, config.scrubber.Wrap(logger).With(zap.String("handler", "zipper")))
 	setUpConfig(logger, zipper)
+	go watchSecrets(resolver, raw, api, ttl, zapwriter.Logger("secrets"))
 
 	handler := initHandlers()
-	handler = handlers.CompressHandler(handler)
+	handler = compressHandler(handler)
 	handler = handlers.CORS()(handler)
 	handler = handlers.ProxyHeaders(handler)
 	handler = util.UUIDHandler(handler)
 
 	go func() {
+		prometheus.MustRegister(buildInfo)
 		prometheus.MustRegister(prometheusMetrics.Requests)
 		prometheus.MustRegister(prometheusMetrics.Responses)
 		prometheus.MustRegister(prometheusMetrics.DurationsExp)
 		prometheus.MustRegister(prometheusMetrics.DurationsLin)
+		prometheus.MustRegister(functionMetrics.Calls)
+		prometheus.MustRegister(functionMetrics.Seconds)
+		prometheus.MustRegister(functionMetrics.Timeouts)
+		if config.Attribution.Enabled {
+			prometheus.MustRegister(dashboardMetrics.Requests)
+			prometheus.MustRegister(dashboardMetrics.Seconds)
+			prometheus.MustRegister(dashboardMetrics.Datapoints)
+		}
 
 		writeTimeout := config.Timeouts.Global
 		if writeTimeout < 30*time.Second {
@@ -678,17 +1047,41 @@ func main() {
 		}
 	}()
 
+	if exports != nil {
+		prometheus.MustRegister(exportRuns)
+		exports.Start()
+	}
+
+	if recordingRules != nil {
+		prometheus.MustRegister(recordingRuleRuns)
+		recordingRules.Start()
+	}
+
+	if config.renames != nil && config.Renames.UpdatePeriod > 0 {
+		go reloadRenames(config.renames, config.Renames.File, config.Renames.UpdatePeriod, logger)
+	}
+
 	if config.BlockHeaderUpdatePeriod > 0 {
 		ticker := time.NewTicker(config.BlockHeaderUpdatePeriod)
 		go loadBlockRuleHeaderConfig(ticker, logger)
 	}
 
-	err = gracehttp.Serve(&http.Server{
+	server := &http.Server{
 		Addr:         config.Listen,
 		Handler:      handler,
 		ReadTimeout:  1 * time.Second,
 		WriteTimeout: config.Timeouts.Global,
-	})
+	}
+
+	if config.Shutdown.DrainTimeout > 0 || config.Listener != (listener.Config{}) {
+		var listeners []net.Listener
+		listeners, err = listener.Listen(config.Listen, config.Listener)
+		if err == nil {
+			err = shutdown.Serve(server, listeners, config.Shutdown.DrainTimeout, logger, shutdownHooks(zipper, logger)...)
+		}
+	} else {
+		err = gracehttp.Serve(server)
+	}
 
 	if err != nil {
 		logger.Fatal("gracehttp failed",
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/parser"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 
 	"github.com/lomik/zapwriter"
@@ -34,7 +38,7 @@ func (z mockCarbonZipper) Info(ctx context.Context, metric string) (map[string]p
 	return response, nil
 }
 
-func (z mockCarbonZipper) Render(ctx context.Context, metric string, from, until int32) ([]*types.MetricData, error) {
+func (z mockCarbonZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
 	var result []*types.MetricData
 	multiFetchResponse := getMultiFetchResponse()
 	result = append(result, &types.MetricData{FetchResponse: multiFetchResponse.Metrics[0]})
@@ -132,6 +136,162 @@ func TestRenderHandler(t *testing.T) {
 	}
 }
 
+func TestRenderHandlerNullPoints(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=json&noNullPoints=true&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `[{"target":"foo.bar","datapoints":[[1510913759,1510913340],[1510913818,1510913400]]}]`, rr.Body.String())
+
+	defer func(nulls types.NullPolicy) { config.nullPolicy = nulls }(config.nullPolicy)
+	config.nullPolicy = types.NullPolicy{Fill: true}
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `[{"target":"foo.bar","datapoints":[[0,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`, rr.Body.String())
+}
+
+func TestRenderHandlerSharesFetches(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=divideSeries(foo.bar,foo.bar)&target=foo.bar&from=-10minutes&format=json&noCache=1")
+	saved := apiMetrics.SavedFetches.Value()
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, saved+2, apiMetrics.SavedFetches.Value(), "foo.bar should only be fetched once")
+	assert.Contains(t, rr.Body.String(), `"target":"divideSeries(foo.bar,foo.bar)"`)
+	assert.Contains(t, rr.Body.String(), `"target":"foo.bar"`)
+}
+
+func TestRenderHandlerIsolatesTargets(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&target=sum(foo&target=movingAverage(foo.bar)&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"foo.bar"`)
+
+	var errs map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(rr.Header().Get(targetErrorsHeader)), &errs))
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs["sum(foo"], parser.ErrMissingComma.Error())
+	assert.Equal(t, parser.ErrMissingArgument.Error(), errs["movingAverage(foo.bar)"])
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&target=movingAverage(foo.bar)&from=-10minutes&format=json&noCache=1&strict=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&target=sum(foo&from=-10minutes&format=json&noCache=1&strict=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=sum(foo&target=foo(&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "a request without good targets should fail")
+}
+
+func TestRenderHandlerMacros(t *testing.T) {
+	macros, err := parser.NewMacros(map[string]string{"doubled(m)": "scale($m,2)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig parser.Macros) { config.macros = orig }(config.macros)
+	config.macros = macros
+
+	req, rr := setUpRequest(t, "/render/?target=doubled(foo.bar)&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"scale(foo.bar,2)"`)
+
+	req, rr = setUpRequest(t, "/render/?target=doubled(foo.bar,3)&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRenderHandlerExpressionLimits(t *testing.T) {
+	defer func(orig parser.Limits) { config.ExpressionLimits = orig }(config.ExpressionLimits)
+	config.ExpressionLimits = parser.Limits{MaxDepth: 2, MaxFetches: 2, MaxGlobs: 1}
+
+	req, rr := setUpRequest(t, "/render/?target=scale(absolute(foo.bar),2)&target=foo.bar&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	for _, target := range []string{
+		"scale(absolute(offset(foo.bar,1)),2)",
+		"sumSeries(foo.bar,foo.bar,foo.bar)",
+		"sumSeries(foo.*.*)",
+	} {
+		req, rr := setUpRequest(t, "/render/?target="+url.QueryEscape(target)+"&from=-10minutes&format=json&noCache=1")
+		renderHandler(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+		assert.Contains(t, rr.Body.String(), "expression too complex", target)
+	}
+}
+
+func TestRenderHandlerTemplates(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=template(foo.$name,name=baz)&template[name]=bar&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"target":"foo.bar"`)
+}
+
+func TestRenderHandlerPinned(t *testing.T) {
+	render := func() string {
+		req, rr := setUpRequest(t, "/render/?target=randomWalk('x')&from=-10minutes&now=1600000000&seed=7&format=json&noCache=1")
+		renderHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	body := render()
+	assert.Contains(t, body, `"target":"x"`)
+	assert.Contains(t, body, `,1599999400]`)
+	assert.Equal(t, body, render())
+
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&seed=x&format=json")
+	renderHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRenderHandlerColumnar(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=arrow&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeArrow, rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "\xff\xff\xff\xff"))
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=parquet&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeParquet, rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "PAR1"))
+	assert.True(t, strings.HasSuffix(rr.Body.String(), "PAR1"))
+}
+
+func TestRenderHandlerMeta(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=json&meta=1&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"meta":{"backends":[],"healedPoints":0,"step":60}`)
+}
+
+func TestRenderHandlerBadTime(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=yesterday-ish&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `invalid ISO 8601 time "yesterday-ish"`)
+}
+
 func TestFindHandler(t *testing.T) {
 	req, rr := setUpRequest(t, "/metrics/find/?query=foo.bar&format=json")
 	findHandler(rr, req)
@@ -187,3 +347,108 @@ func TestInfoHandler(t *testing.T) {
 		t.Error("Http response should be same.")
 	}
 }
+
+func TestEventsHandlers(t *testing.T) {
+	events.SetDefault(events.NewRing(10))
+	defer events.SetDefault(nil)
+
+	for _, body := range []string{
+		`{"what":"deploy api","tags":"deploy api","when":1510913280}`,
+		`{"what":"deploy web","tags":["deploy","web"],"when":1510913340}`,
+	} {
+		req, err := http.NewRequest("POST", "/events/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		eventsHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, "HttpStatusCode should be 200 OK.")
+	}
+
+	req, rr := setUpRequest(t, "/events/get_data?from=1510913000&until=1510914000&tags=deploy+api")
+	eventsGetDataHandler(rr, req)
+
+	expected := `[{"id":1,"when":1510913280,"what":"deploy api","data":"","tags":["deploy","api"]}]`
+	assert.Equal(t, http.StatusOK, rr.Code, "HttpStatusCode should be 200 OK.")
+	assert.Equal(t, expected, rr.Body.String(), "Http response should be same.")
+
+	req, rr = setUpRequest(t, "/events/get_data?from=1510913000&until=1510914000&tags=api,web&set=union")
+	eventsGetDataHandler(rr, req)
+
+	var got []events.Event
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, 2, len(got), "union should return both events")
+}
+
+func TestAnnotationsHandler(t *testing.T) {
+	ring := events.NewRing(10)
+	events.SetDefault(ring)
+	defer events.SetDefault(nil)
+
+	for _, ev := range []events.Event{
+		{What: "deploy api", Data: "v1.2", Tags: []string{"deploy", "api"}, When: 1510913280},
+		{What: "deploy web", Tags: []string{"deploy", "web"}, When: 1510913340},
+		{What: "outage", Tags: []string{"incident"}, When: 1510920000},
+	} {
+		assert.Nil(t, ring.Add(context.Background(), ev))
+	}
+
+	body := `{"range":{"from":"2017-11-17T10:00:00.000Z","to":"2017-11-17T11:00:00.000Z"},"annotation":{"name":"deploys","enable":true,"query":"#deploy #api"}}`
+	req := httptest.NewRequest("POST", "/annotations", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	annotationsHandler(rr, req)
+
+	expected := `[{"annotation":{"name":"deploys","enable":true,"query":"#deploy #api"},"time":1510913280000,"title":"deploy api","text":"v1.2","tags":["deploy","api"]}]`
+	assert.Equal(t, http.StatusOK, rr.Code, "HttpStatusCode should be 200 OK.")
+	assert.Equal(t, expected, rr.Body.String())
+
+	body = `{"range":{"from":"2017-11-17T10:00:00Z","to":"2017-11-17T11:00:00Z"},"annotation":{"name":"all"}}`
+	req = httptest.NewRequest("POST", "/annotations", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	annotationsHandler(rr, req)
+
+	var got []annotation
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, 2, len(got), "an empty query should return all events in range")
+
+	req = httptest.NewRequest("GET", "/annotations", nil)
+	rr = httptest.NewRecorder()
+	annotationsHandler(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	req = httptest.NewRequest("POST", "/annotations", strings.NewReader(`{"range":{"from":"yesterday"}}`))
+	rr = httptest.NewRecorder()
+	annotationsHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestEventsHandlerNotConfigured(t *testing.T) {
+	req, rr := setUpRequest(t, "/events/get_data?from=1510913000&until=1510914000")
+	eventsGetDataHandler(rr, req)
+
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestRoutePrefix(t *testing.T) {
+	defer func(prefix string) { config.RoutePrefix = prefix }(config.RoutePrefix)
+	config.RoutePrefix = "/graphite/"
+	h := initHandlers()
+
+	for _, c := range []struct {
+		url  string
+		code int
+	}{
+		{"/graphite/metrics/find/?query=foo.bar&format=json", http.StatusOK},
+		{"/graphite/metrics?query=foo.bar&format=json", http.StatusOK},
+		{"/graphite/composer/render/?target=foo.bar&from=-10minutes&format=json", http.StatusOK},
+		{"/graphite/lb_check", http.StatusOK},
+		{"/lb_check", http.StatusOK},
+		{"/metrics/find/?query=foo.bar&format=json", http.StatusNotFound},
+	} {
+		req, rr := setUpRequest(t, c.url)
+		h.ServeHTTP(rr, req)
+		if rr.Code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.url, c.code, rr.Code)
+		}
+	}
+}
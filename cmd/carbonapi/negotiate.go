@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// formatContentTypes are the content types writeResponse serves the formats
+// in, for the formats asked for with the Accept header.
+var formatContentTypes = map[string]string{
+	jsonFormat:      contentTypeJSON,
+	treejsonFormat:  contentTypeJSON,
+	histogramFormat: contentTypeJSON,
+	protobufFormat:  contentTypeProtobuf,
+	protobuf3Format: contentTypeProtobuf,
+	rawFormat:       contentTypeRaw,
+	pickleFormat:    contentTypePickle,
+	csvFormat:       contentTypeCSV,
+	arrowFormat:     contentTypeArrow,
+	parquetFormat:   contentTypeParquet,
+	pngFormat:       contentTypePNG,
+	svgFormat:       contentTypeSVG,
+}
+
+// mediaRange is a content type of an Accept header, which may have a
+// wildcard type or subtype, and its quality.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses an Accept header. Ranges with a malformed quality are
+// dropped.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		slash := strings.IndexByte(mt, '/')
+		if slash <= 0 || slash == len(mt)-1 {
+			continue
+		}
+
+		r := mediaRange{typ: mt[:slash], subtype: mt[slash+1:], q: 1}
+		valid := true
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 || strings.ToLower(kv[0]) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			r.q = q
+		}
+		if valid {
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges
+}
+
+// quality returns the quality ranges give contentType, that of the most
+// specific range matching it, and 0 if none does.
+func quality(ranges []mediaRange, contentType string) float64 {
+	slash := strings.IndexByte(contentType, '/')
+	typ, subtype := contentType[:slash], contentType[slash+1:]
+
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		var s int
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+
+	return q
+}
+
+// negotiateFormat picks the format of a request without a format parameter
+// from its Accept header: the one of formats, def first, the client
+// prefers most, the first of them on ties. It returns def for requests
+// without an Accept header, and reports false if the client accepts none of
+// them.
+func negotiateFormat(r *http.Request, def string, formats []string) (string, bool) {
+	header := strings.Join(r.Header["Accept"], ",")
+	if strings.TrimSpace(header) == "" {
+		return def, true
+	}
+	ranges := parseAccept(header)
+	if len(ranges) == 0 {
+		return def, true
+	}
+
+	best, bestQ := "", 0.0
+	for _, f := range append([]string{def}, formats...) {
+		contentType, ok := formatContentTypes[f]
+		if !ok {
+			continue
+		}
+		if q := quality(ranges, contentType); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+
+	return best, best != ""
+}
+
+// notAcceptable answers a request whose Accept header allows none of
+// formats with the content types it could have asked for.
+func notAcceptable(w http.ResponseWriter, def string, formats []string) {
+	seen := make(map[string]bool)
+	var types []string
+	for _, f := range append([]string{def}, formats...) {
+		if ct, ok := formatContentTypes[f]; ok && !seen[ct] {
+			seen[ct] = true
+			types = append(types, ct)
+		}
+	}
+
+	http.Error(w, http.StatusText(http.StatusNotAcceptable)+": available content types are "+strings.Join(types, ", "), http.StatusNotAcceptable)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", pngFormat, true},
+		{"*/*", pngFormat, true},
+		{"application/json", jsonFormat, true},
+		{"Application/JSON; charset=utf-8", jsonFormat, true},
+		{"text/csv", csvFormat, true},
+		{"application/x-protobuf", protobufFormat, true},
+		{"text/csv;q=0.5, application/json", jsonFormat, true},
+		{"text/csv;q=0.5, application/json;q=0.2", csvFormat, true},
+		// What browsers send for images.
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", pngFormat, true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", pngFormat, true},
+		{"image/*;q=0.5, image/svg+xml", svgFormat, true},
+		{"application/json;q=0, */*", pngFormat, true},
+		{"application/x-msgpack", "", false},
+		{"text/html", "", false},
+		// Malformed headers are ignored.
+		{"application/json;q=2", pngFormat, true},
+		{"json", pngFormat, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/render/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		got, ok := negotiateFormat(r, pngFormat, renderFormats)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Accept %q: expected %q, %v, got %q, %v", tt.accept, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestHandlersHonorAccept(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&noCache=1")
+	req.Header.Set("Accept", "application/json")
+	renderHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("expected a %s render, got %s", contentTypeJSON, ct)
+	}
+	if !strings.HasPrefix(rr.Body.String(), `[{"target":"foo.bar"`) {
+		t.Errorf("expected a JSON render, got %s", rr.Body.String())
+	}
+	if rr.Header().Get("Vary") != "Accept" {
+		t.Errorf("expected the render to vary by Accept, got %q", rr.Header().Get("Vary"))
+	}
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&format=json&noCache=1")
+	req.Header.Set("Accept", "text/csv")
+	renderHandler(rr, req)
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("expected the format parameter to take precedence, got %s", ct)
+	}
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar&from=-10minutes&noCache=1")
+	req.Header.Set("Accept", "application/x-msgpack")
+	renderHandler(rr, req)
+	if rr.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406 for msgpack, got %d", rr.Code)
+	}
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo.*")
+	req.Header.Set("Accept", "text/plain")
+	findHandler(rr, req)
+	if ct := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK || ct != contentTypeRaw {
+		t.Errorf("expected a 200 raw find, got %d, %s", rr.Code, ct)
+	}
+
+	req, rr = setUpRequest(t, "/metrics/find/?query=foo.*")
+	req.Header.Set("Accept", "image/png")
+	findHandler(rr, req)
+	if rr.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406 for a PNG find, got %d", rr.Code)
+	}
+
+	req, rr = setUpRequest(t, "/info/?target=foo.bar")
+	req.Header.Set("Accept", "application/x-protobuf")
+	infoHandler(rr, req)
+	if rr.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406 for a protobuf info, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/expr"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+
+	"go.uber.org/zap"
+)
+
+// fetchPlan is what prefetch found out about one metric request.
+type fetchPlan struct {
+	req parser.MetricRequest
+	// paths are the names asked from the zipper for the request
+	paths []string
+	// glob is set if paths is the request's glob sent as is; its series
+	// can't be told apart from other ones by name, so it gets a request of
+	// its own
+	glob bool
+	// pushdown is pushed down with the fetch, which then gets a request
+	// of its own too, as the zipper applies it to all the series of a
+	// request
+	pushdown pushdown
+}
+
+type timeRange struct {
+	from, until int32
+}
+
+// batchKey tells the plans whose paths are fetched together.
+type batchKey struct {
+	timeRange
+	// own is the metric of a plan fetched on its own
+	own      string
+	pushdown string
+}
+
+func (p *fetchPlan) batchKey() batchKey {
+	k := batchKey{timeRange: timeRange{p.req.From, p.req.Until}}
+	if p.glob || len(p.pushdown) > 0 {
+		k.own = p.req.Metric
+		k.pushdown = p.pushdown.String()
+	}
+
+	return k
+}
+
+// prefetch collects the fetches of all the expressions, resolves their globs
+// and fetches the resulting paths in as few zipper requests as possible: one
+// per time range and maxBatchSize paths. The series go into metricMap keyed
+// the same way a fetch per metric would store them. It returns the size of
+// the fetched data.
+//
+// Requests already in fetched are skipped, the rest are added to it, but
+// for those fetched with a pushdown: they only have the series of one
+// function, and are fetched again if later expressions use them.
+func prefetch(ctx context.Context, exps []parser.Expr, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData, fetched map[parser.MetricRequest]struct{}, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails, logger *zap.Logger) int {
+	pushed := pushdowns(exps)
+
+	var plans []*fetchPlan
+	for _, exp := range exps {
+		for _, m := range exp.Metrics() {
+			accessLogDetails.Metrics = append(accessLogDetails.Metrics, m.Metric)
+
+			mfetch := m
+			mfetch.From += from
+			mfetch.Until += until
+
+			if _, ok := fetched[mfetch]; ok {
+				// already fetched this metric for this request
+				apiMetrics.SavedFetches.Add(1)
+				continue
+			}
+			fetched[mfetch] = struct{}{}
+
+			plans = append(plans, &fetchPlan{req: mfetch, pushdown: pushed[m]})
+		}
+	}
+	for _, p := range plans {
+		if len(p.pushdown) > 0 {
+			delete(fetched, p.req)
+		}
+	}
+
+	if len(plans) == 0 {
+		return 0
+	}
+
+	// the zipper aggregates each of its targets, so an aggregated glob is
+	// sent as is
+	var resolve []*fetchPlan
+	for _, p := range plans {
+		if p.pushdown.aggregates() {
+			p.paths = []string{p.req.Metric}
+			p.glob = true
+			accessLogDetails.SendGlobs = true
+			continue
+		}
+		resolve = append(resolve, p)
+	}
+	resolvePlans(ctx, resolve, useCache, accessLogDetails, logger)
+
+	// batches of paths per time range, each path once
+	var order []batchKey
+	batches := make(map[batchKey][]string)
+	batchPushdowns := make(map[batchKey]pushdown)
+	seen := make(map[batchKey]map[string]struct{})
+	for _, p := range plans {
+		k := p.batchKey()
+
+		if _, ok := batches[k]; !ok {
+			order = append(order, k)
+			batchPushdowns[k] = p.pushdown
+			seen[k] = make(map[string]struct{})
+		}
+		for _, path := range p.paths {
+			if _, ok := seen[k][path]; !ok {
+				seen[k][path] = struct{}{}
+				batches[k] = append(batches[k], path)
+			}
+		}
+	}
+
+	type batchResponse struct {
+		key   batchKey
+		paths []string
+		took  time.Duration
+		data  []*types.MetricData
+		err   error
+	}
+
+	batchSize := config.MaxBatchSize
+	if batchSize <= 0 {
+		batchSize = len(plans)
+	}
+
+	ctx, cancel := stageContext(ctx, stageFetch)
+	defer cancel()
+
+	rch := make(chan batchResponse)
+	requests := 0
+	for _, k := range order {
+		paths := batches[k]
+		for len(paths) > 0 {
+			n := batchSize
+			if n > len(paths) {
+				n = len(paths)
+			}
+			requests++
+
+			go func(k batchKey, paths []string) {
+				config.limiter.Enter(localHostName)
+				defer config.limiter.Leave(localHostName)
+
+				apiMetrics.RenderRequests.Add(1)
+				atomic.AddInt64(&accessLogDetails.ZipperRequests, 1)
+
+				t0 := time.Now()
+				r, err := config.zipper.Render(batchPushdowns[k].context(ctx), paths, k.from, k.until)
+				rch <- batchResponse{k, paths, time.Since(t0), r, err}
+			}(k, paths[:n])
+
+			paths = paths[n:]
+		}
+	}
+
+	byName := make(map[timeRange]map[string][]*types.MetricData)
+	byOwn := make(map[batchKey][]*types.MetricData)
+	size := 0
+	var errors []error
+	for i := 0; i < requests; i++ {
+		resp := <-rch
+		heavyHitters.addFetch(resp.paths, resp.took)
+		if resp.err != nil {
+			errors = append(errors, resp.err)
+			continue
+		}
+
+		for _, r := range resp.data {
+			size += r.Size()
+			heavyHitters.addSeries(r.Name, r.Size())
+			if resp.key.own != "" {
+				byOwn[resp.key] = append(byOwn[resp.key], r)
+				continue
+			}
+
+			names, ok := byName[resp.key.timeRange]
+			if !ok {
+				names = make(map[string][]*types.MetricData)
+				byName[resp.key.timeRange] = names
+			}
+			names[r.Name] = append(names[r.Name], r)
+		}
+	}
+
+	if len(errors) != 0 {
+		logger.Error("render error occurred while fetching data",
+			zap.Any("errors", errors),
+		)
+	}
+
+	for _, p := range plans {
+		var data []*types.MetricData
+		if k := p.batchKey(); k.own != "" {
+			data = byOwn[k]
+		} else {
+			names := byName[timeRange{p.req.From, p.req.Until}]
+			for _, path := range p.paths {
+				data = append(data, names[path]...)
+			}
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		// the series may be shared with other requests, sort a copy
+		data = append([]*types.MetricData(nil), data...)
+		expr.SortMetrics(data, p.req)
+		metricMap[p.req] = data
+	}
+
+	return size
+}
+
+// resolvePlans finds the paths to fetch for each plan, resolving globs in
+// parallel.
+func resolvePlans(ctx context.Context, plans []*fetchPlan, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails, logger *zap.Logger) {
+	ctx, cancel := stageContext(ctx, stageFind)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, p := range plans {
+		wg.Add(1)
+		go func(p *fetchPlan) {
+			defer wg.Done()
+
+			// accessLogDetails isn't safe for concurrent use
+			var details carbonapipb.AccessLogDetails
+			paths, err := getRenderRequests(ctx, p.req, useCache, &details)
+
+			mu.Lock()
+			defer mu.Unlock()
+			accessLogDetails.ZipperRequests += details.ZipperRequests
+			accessLogDetails.SendGlobs = accessLogDetails.SendGlobs || details.SendGlobs
+
+			if err != nil {
+				logger.Error("find error",
+					zap.String("metric", p.req.Metric),
+					zap.Error(err),
+				)
+				return
+			}
+
+			p.paths = paths
+			p.glob = details.SendGlobs && isGlob(p.req.Metric)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func isGlob(metric string) bool {
+	return strings.ContainsAny(metric, "*?[{")
+}
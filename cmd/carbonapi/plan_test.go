@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// batchZipper knows foo.bar and foo.bat, answers renders with a series per
+// requested name and records the batches it was asked for.
+type batchZipper struct {
+	mockCarbonZipper
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (z *batchZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	resp := pb.GlobResponse{Name: metric}
+	for _, name := range []string{"foo.bar", "foo.bat"} {
+		if ok, _ := path.Match(metric, name); ok {
+			resp.Matches = append(resp.Matches, pb.GlobMatch{Path: name, IsLeaf: true})
+		}
+	}
+	return resp, nil
+}
+
+func (z *batchZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	z.mu.Lock()
+	z.batches = append(z.batches, metrics)
+	z.mu.Unlock()
+
+	var result []*types.MetricData
+	for _, m := range metrics {
+		result = append(result, types.MakeMetricData(m, []float64{1, 2}, 60, from))
+	}
+	return result, nil
+}
+
+func TestPrefetchBatches(t *testing.T) {
+	z := &batchZipper{}
+	defer func(orig CarbonZipper) { config.zipper = orig }(config.zipper)
+	config.zipper = z
+
+	var exps []parser.Expr
+	for _, target := range []string{"sumSeries(foo.bar,foo.b*)", "foo.bar", "foo.baz"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exps = append(exps, exp)
+	}
+
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	fetched := make(map[parser.MetricRequest]struct{})
+	var details carbonapipb.AccessLogDetails
+	size := prefetch(context.Background(), exps, 1000, 2000, metricMap, fetched, false, &details, zap.NewNop())
+
+	// foo.baz doesn't resolve to anything
+	assert.Equal(t, [][]string{{"foo.bar", "foo.bat"}}, z.batches)
+	assert.True(t, size > 0)
+	assert.Len(t, fetched, 3)
+
+	names := func(data []*types.MetricData) []string {
+		var res []string
+		for _, d := range data {
+			res = append(res, d.Name)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"foo.bar"}, names(metricMap[parser.MetricRequest{Metric: "foo.bar", From: 1000, Until: 2000}]))
+	assert.Equal(t, []string{"foo.bar", "foo.bat"}, names(metricMap[parser.MetricRequest{Metric: "foo.b*", From: 1000, Until: 2000}]))
+	assert.Len(t, metricMap, 2)
+
+	// everything was fetched already
+	prefetch(context.Background(), exps, 1000, 2000, metricMap, fetched, false, &details, zap.NewNop())
+	assert.Len(t, z.batches, 1)
+}
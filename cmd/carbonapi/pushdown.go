@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/filter"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	realZipper "github.com/bookingcom/carbonapi/zipper"
+)
+
+// The fetch of a metric request is pushed down to the zipper when every use
+// of the request in the expressions of a render is the series list of the
+// same filtering function, such as highestAverage(x.*, 5). Zippers that
+// apply the function send only the series it keeps, and those tied with
+// them, so evaluating it here again gives what it gives on all the series.
+// Zippers that don't apply it send all the series.
+//
+// sumSeries and averageSeries of a glob alone are pushed down the same way,
+// the glob sent as is. A zipper that aggregates sends the one series of the
+// aggregate, which they give back unchanged. Those are only pushed down if
+// nothing here changes the series before they are aggregated.
+
+// pushdown are the filtering functions pushed down with a fetch.
+type pushdown []types.FilterFunction
+
+func (p pushdown) String() string {
+	return fmt.Sprint([]types.FilterFunction(p))
+}
+
+// context has the zipper renders made with ctx push p down.
+func (p pushdown) context(ctx context.Context) context.Context {
+	if len(p) == 0 {
+		return ctx
+	}
+
+	return realZipper.WithFilters(ctx, p)
+}
+
+// aggregates tells if p has the zipper aggregate the series of its fetch.
+func (p pushdown) aggregates() bool {
+	if len(p) != 1 {
+		return false
+	}
+
+	_, ok := aggregate.Of(p[0])
+	return ok
+}
+
+// aggregatePushdowns are the aggregating functions pushed down, by the
+// names they are called by.
+var aggregatePushdowns = map[string]string{
+	"sum":           "sumSeries",
+	"sumSeries":     "sumSeries",
+	"avg":           "averageSeries",
+	"averageSeries": "averageSeries",
+}
+
+// pushdowns returns the filtering functions to push down with the fetches
+// of the metric requests of exps, keyed by the requests as exps make them.
+func pushdowns(exps []parser.Expr) map[parser.MetricRequest]pushdown {
+	// The ACL drops the series the user can't see after they are fetched,
+	// and the ones kept instead would be missing.
+	if config.acl != nil {
+		return nil
+	}
+
+	uses := make(map[parser.MetricRequest]int)
+	for _, exp := range exps {
+		for _, m := range exp.Metrics() {
+			uses[m]++
+		}
+	}
+
+	pushed := make(map[parser.MetricRequest]pushdown)
+	pushes := make(map[parser.MetricRequest]int)
+	conflicting := make(map[parser.MetricRequest]bool)
+	var walk func(e parser.Expr)
+	walk = func(e parser.Expr) {
+		if !e.IsFunc() {
+			return
+		}
+		if m, fs, ok := pushdownOf(e); ok {
+			if prev, ok := pushed[m]; ok && prev.String() != fs.String() {
+				conflicting[m] = true
+			}
+			pushed[m] = fs
+			pushes[m]++
+			return
+		}
+		// The requests below a function that shifts their time ranges
+		// aren't made as they appear.
+		if !keepsRequests(e) {
+			return
+		}
+		for _, a := range e.Args() {
+			walk(a)
+		}
+	}
+	for _, exp := range exps {
+		walk(exp)
+	}
+
+	for m := range pushed {
+		if conflicting[m] || pushes[m] != uses[m] {
+			delete(pushed, m)
+		}
+	}
+
+	return pushed
+}
+
+// pushdownOf returns the metric request of e and the filtering function
+// to push down with it, if e is a function that can be pushed down.
+func pushdownOf(e parser.Expr) (parser.MetricRequest, pushdown, bool) {
+	args := e.Args()
+	if len(args) == 0 || !args[0].IsName() || len(e.NamedArgs()) > 0 || !evaluatedHere(e.Target()) {
+		return parser.MetricRequest{}, nil, false
+	}
+
+	f := types.FilterFunction{Name: e.Target()}
+	for _, a := range args[1:] {
+		switch {
+		case a.IsConst():
+			f.Arguments = append(f.Arguments, strconv.FormatFloat(a.FloatValue(), 'f', -1, 64))
+		case a.IsString():
+			f.Arguments = append(f.Arguments, a.StringValue())
+		default:
+			return parser.MetricRequest{}, nil, false
+		}
+	}
+
+	fs := pushdown{f}
+	if name, ok := aggregatePushdowns[e.Target()]; ok {
+		if len(args) != 1 || !isGlob(args[0].Target()) || !aggregatesAsFetched() {
+			return parser.MetricRequest{}, nil, false
+		}
+		fs = pushdown{{Name: name}}
+	} else if filter.Check(fs) != nil {
+		return parser.MetricRequest{}, nil, false
+	}
+
+	return parser.MetricRequest{Metric: args[0].Target()}, fs, true
+}
+
+// aggregatesAsFetched tells if the series are aggregated here as the zipper
+// sends them. Rollups, renames and tenancy change them first, and a default
+// xFilesFactor drops points the zipper would aggregate.
+func aggregatesAsFetched() bool {
+	return config.DefaultXFilesFactor == 0 && len(config.Rollups) == 0 && config.renames == nil && config.tenancy == nil
+}
+
+// evaluatedHere tells if the function name is evaluated by carbonapi
+// rather than proxied to graphite-web.
+func evaluatedHere(name string) bool {
+	metadata.FunctionMD.RLock()
+	defer metadata.FunctionMD.RUnlock()
+
+	_, ok := metadata.FunctionMD.Functions[name]
+	return ok && !metadata.FunctionMD.Descriptions[name].Proxied
+}
+
+// keepsRequests tells if the function e requests the series of its
+// arguments as they request them.
+func keepsRequests(e parser.Expr) bool {
+	var args []parser.MetricRequest
+	for _, a := range e.Args() {
+		args = append(args, a.Metrics()...)
+	}
+
+	ms := e.Metrics()
+	if len(ms) != len(args) {
+		return false
+	}
+	for i := range ms {
+		if ms[i] != args[i] {
+			return false
+		}
+	}
+
+	return true
+}
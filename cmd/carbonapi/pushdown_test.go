@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pathcache"
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/filter"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	pkgtypes "github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+	realZipper "github.com/bookingcom/carbonapi/zipper"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"go.uber.org/zap"
+)
+
+func TestPushdowns(t *testing.T) {
+	highest := pushdown{{Name: "highestAverage", Arguments: []string{"5"}}}
+	sum := pushdown{{Name: "sumSeries"}}
+
+	tests := []struct {
+		name    string
+		targets []string
+		want    map[string]pushdown
+	}{
+		{"filter", []string{"highestAverage(a.*, 5)"}, map[string]pushdown{"a.*": highest}},
+		{"below other functions", []string{"sumSeries(highestAverage(a.*, 5))"}, map[string]pushdown{"a.*": highest}},
+		{"used twice alike", []string{"highestAverage(a.*, 5)", "aliasByNode(highestAverage(a.*, 5), 1)"}, map[string]pushdown{"a.*": highest}},
+		{"used unfiltered", []string{"highestAverage(a.*, 5)", "a.*"}, map[string]pushdown{}},
+		{"filtered differently", []string{"highestAverage(a.*, 5)", "lowestAverage(a.*, 5)"}, map[string]pushdown{}},
+		{"time shifted", []string{"timeShift(highestAverage(a.*, 5), '1d')", "a.*"}, map[string]pushdown{}},
+		{"not a series list", []string{"highestAverage(scale(a.*, 2), 5)"}, map[string]pushdown{}},
+		{"bad argument", []string{"highestAverage(a.*, 'x')"}, map[string]pushdown{}},
+		{"not a filter", []string{"sortByMaxima(a.*)"}, map[string]pushdown{}},
+		{"sum", []string{"sumSeries(a.*)"}, map[string]pushdown{"a.*": sum}},
+		{"sum alias", []string{"sum(a.*)", "sumSeries(a.*)"}, map[string]pushdown{"a.*": sum}},
+		{"average", []string{"avg(a.*)"}, map[string]pushdown{"a.*": {{Name: "averageSeries"}}}},
+		{"aggregate below other functions", []string{"highestAverage(sumSeries(a.*), 5)"}, map[string]pushdown{"a.*": sum}},
+		{"aggregated differently", []string{"sumSeries(a.*)", "averageSeries(a.*)"}, map[string]pushdown{}},
+		{"aggregated and filtered", []string{"sumSeries(a.*)", "highestAverage(a.*, 5)"}, map[string]pushdown{}},
+		{"aggregate of several series lists", []string{"sumSeries(a.*, b.*)"}, map[string]pushdown{}},
+		{"aggregate of a path", []string{"sumSeries(a.b)"}, map[string]pushdown{}},
+		{"unpushed aggregate", []string{"countSeries(a.*)"}, map[string]pushdown{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exps []parser.Expr
+			for _, target := range tt.targets {
+				exp, _, err := parser.ParseExpr(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				exps = append(exps, exp)
+			}
+
+			got := make(map[string]pushdown)
+			for m, p := range pushdowns(exps) {
+				got[m.Metric] = p
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// pushdownZipper records the filtering functions of its renders.
+type pushdownZipper struct {
+	batchZipper
+
+	mu      sync.Mutex
+	filters map[string][]pkgtypes.FilterFunction
+}
+
+func (z *pushdownZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	z.mu.Lock()
+	sorted := append([]string(nil), metrics...)
+	sort.Strings(sorted)
+	z.filters[path.Join(sorted...)] = realZipper.Filters(ctx)
+	z.mu.Unlock()
+
+	return z.batchZipper.Render(ctx, metrics, from, until)
+}
+
+func TestPrefetchPushdown(t *testing.T) {
+	z := &pushdownZipper{filters: make(map[string][]pkgtypes.FilterFunction)}
+	defer func(orig CarbonZipper) { config.zipper = orig }(config.zipper)
+	config.zipper = z
+
+	var exps []parser.Expr
+	for _, target := range []string{"highestMax(foo.b*, 1)", "foo.bar"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exps = append(exps, exp)
+	}
+
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	fetched := make(map[parser.MetricRequest]struct{})
+	var details carbonapipb.AccessLogDetails
+	prefetch(context.Background(), exps, 1000, 2000, metricMap, fetched, false, &details, zap.NewNop())
+
+	// The filtered glob is fetched on its own, with its filter.
+	want := map[string][]pkgtypes.FilterFunction{
+		"foo.bar/foo.bat": {{Name: "highestMax", Arguments: []string{"1"}}},
+		"foo.bar":         nil,
+	}
+	if !reflect.DeepEqual(z.filters, want) {
+		t.Errorf("expected renders %v, got %v", want, z.filters)
+	}
+	if len(metricMap[parser.MetricRequest{Metric: "foo.b*", From: 1000, Until: 2000}]) != 2 {
+		t.Errorf("expected the series of the glob, got %v", metricMap)
+	}
+	if _, ok := fetched[parser.MetricRequest{Metric: "foo.b*", From: 1000, Until: 2000}]; ok {
+		t.Error("expected the filtered fetch to be fetched again for other uses")
+	}
+}
+
+// fakeZipperServer is a zipper serving the series foo.a, foo.b and foo.c,
+// whose values are 1, 2 and 3. If v3 is set, it announces version 3 and
+// the filtering and aggregating functions, and applies those of render
+// requests.
+func fakeZipperServer(t *testing.T, v3 bool, pushed *[][]pkgtypes.FilterFunction) *httptest.Server {
+	series := func(from, until int32) []pkgtypes.Metric {
+		var ms []pkgtypes.Metric
+		for i, name := range []string{"foo.a", "foo.b", "foo.c"} {
+			ms = append(ms, pkgtypes.Metric{
+				Name:      name,
+				StartTime: from,
+				StopTime:  from + 60,
+				StepTime:  60,
+				Values:    []float64{float64(i + 1)},
+				IsAbsent:  []bool{false},
+			})
+		}
+		return ms
+	}
+
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case r.URL.Path == "/capabilities/" && v3:
+			blob, _ := json.Marshal(pkgtypes.Capabilities{
+				Formats:         []string{pkgtypes.FormatCarbonAPIv3, pkgtypes.FormatCarbonAPIv2},
+				FilterFunctions: append(append([]string(nil), filter.Names...), aggregate.Names...),
+			})
+			w.Write(blob)
+		case r.URL.Path == "/metrics/find/":
+			resp := pb.GlobResponse{Name: r.FormValue("query")}
+			for _, name := range []string{"foo", "foo.a", "foo.b", "foo.c"} {
+				if ok, _ := path.Match(r.FormValue("query"), name); ok {
+					resp.Matches = append(resp.Matches, pb.GlobMatch{Path: name, IsLeaf: name != "foo"})
+				}
+			}
+			blob, _ := resp.Marshal()
+			w.Write(blob)
+		case r.URL.Path == "/render/":
+			var ms []pkgtypes.Metric
+			for _, m := range series(0, 60) {
+				for _, target := range r.Form["target"] {
+					if ok, _ := path.Match(target, m.Name); ok {
+						ms = append(ms, m)
+					}
+				}
+			}
+			blob, _ := carbonapi_v2.RenderEncoder(ms)
+			w.Write(blob)
+		case r.URL.Path == "/api/v3/render" && v3:
+			body, _ := ioutil.ReadAll(r.Body)
+			requests, err := carbonapi_v3.RenderRequestDecoder(body)
+			if err != nil || len(requests) == 0 {
+				t.Errorf("bad render request: %v", err)
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			*pushed = append(*pushed, requests[0].Filters)
+			mu.Unlock()
+
+			var ms []pkgtypes.Metric
+			for _, req := range requests {
+				var matched []pkgtypes.Metric
+				for _, m := range series(req.From, req.Until) {
+					if ok, _ := path.Match(req.Name, m.Name); ok {
+						matched = append(matched, m)
+					}
+				}
+
+				agg, ok := aggregationOf(req.Filters)
+				if !ok {
+					ms = append(ms, filter.Apply(matched, req.Filters)...)
+					continue
+				}
+				if p, ok := aggregate.Compute(matched); ok {
+					ms = append(ms, p.Result(agg, req.Name))
+				}
+			}
+			blob, _ := carbonapi_v3.RenderEncoder(ms)
+			w.Write(blob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// aggregationOf returns the aggregation fs ask for, if they aggregate.
+func aggregationOf(fs []pkgtypes.FilterFunction) (string, bool) {
+	if len(fs) != 1 {
+		return "", false
+	}
+
+	return aggregate.Of(fs[0])
+}
+
+// newTestZipper returns a zipper of the servers, once it probed them.
+func newTestZipper(servers ...string) *zipper {
+	zc := cfg.Zipper{Common: cfg.DefaultConfig, PathCache: pathcache.NewPathCache(60)}
+	zc.Common.Backends = servers
+	zc.Common.Timeouts.Global = time.Second
+	z := newZipper(func(*realZipper.Stats) {}, zc, zap.NewNop())
+	// The probe is only taken once the first one is done.
+	z.z.ProbeForce <- 1
+
+	return z
+}
+
+func TestRenderPushdown(t *testing.T) {
+	defer func(orig CarbonZipper) { config.zipper = orig }(config.zipper)
+
+	for _, v3 := range []bool{true, false} {
+		var pushed [][]pkgtypes.FilterFunction
+		srv := fakeZipperServer(t, v3, &pushed)
+		z := newTestZipper(srv.URL)
+		config.zipper = z
+
+		var details carbonapipb.AccessLogDetails
+		data, _, err := evalRender(context.Background(), []string{"highestAverage(foo.*, 1)"}, 0, 60, nil, evalContext{now: time.Unix(60, 0)}, &details, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 1 || data[0].Name != "foo.c" {
+			t.Errorf("v3 %v: expected foo.c, got %v", v3, data)
+		}
+
+		want := [][]pkgtypes.FilterFunction{{{Name: "highestAverage", Arguments: []string{"1"}}}}
+		if !v3 {
+			want = nil
+		}
+		if !reflect.DeepEqual(pushed, want) {
+			t.Errorf("v3 %v: expected pushdowns %v, got %v", v3, want, pushed)
+		}
+
+		z.Close()
+		srv.Close()
+	}
+}
+
+func TestRenderAggregatePushdown(t *testing.T) {
+	defer func(orig CarbonZipper) { config.zipper = orig }(config.zipper)
+
+	tests := []struct {
+		target string
+		name   string
+		value  float64
+		pushed pushdown
+	}{
+		{"sumSeries(foo.*)", "sumSeries(foo.*)", 6, pushdown{{Name: "sumSeries"}}},
+		{"sum(foo.*)", "sumSeries(foo.*)", 6, pushdown{{Name: "sumSeries"}}},
+		{"averageSeries(foo.*)", "averageSeries(foo.*)", 2, pushdown{{Name: "averageSeries"}}},
+	}
+
+	for _, tt := range tests {
+		for _, v3 := range []bool{true, false} {
+			var pushed [][]pkgtypes.FilterFunction
+			srv := fakeZipperServer(t, v3, &pushed)
+			z := newTestZipper(srv.URL)
+			config.zipper = z
+
+			var details carbonapipb.AccessLogDetails
+			data, _, err := evalRender(context.Background(), []string{tt.target}, 0, 60, nil, evalContext{now: time.Unix(60, 0)}, &details, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != 1 || data[0].Name != tt.name || data[0].Values[0] != tt.value {
+				t.Errorf("%s, v3 %v: expected %s of %v, got %v", tt.target, v3, tt.name, tt.value, data)
+			}
+
+			var want [][]pkgtypes.FilterFunction
+			if v3 {
+				want = [][]pkgtypes.FilterFunction{tt.pushed}
+			}
+			if !reflect.DeepEqual(pushed, want) {
+				t.Errorf("%s, v3 %v: expected pushdowns %v, got %v", tt.target, v3, want, pushed)
+			}
+
+			z.Close()
+			srv.Close()
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/carbon"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// maxPutBody is the largest body /metrics/put reads.
+const maxPutBody = 8 << 20
+
+// putRelay relays the datapoints of /metrics/put to carbon. It is nil
+// unless metricsPut is enabled.
+var putRelay *carbon.Relay
+
+type putPoint struct {
+	Path      string   `json:"path"`
+	Value     *float64 `json:"value"`
+	Timestamp *int32   `json:"timestamp"`
+}
+
+// parsePutPlaintext parses lines of "<path> <value> [<timestamp>]", as
+// carbon takes them, with points without a timestamp at now.
+func parsePutPlaintext(body []byte, now int32) ([]carbon.Point, error) {
+	var points []carbon.Point
+	s := bufio.NewScanner(bytes.NewReader(body))
+	s.Buffer(nil, maxPutBody)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a path, a value and an optional timestamp", line)
+		}
+
+		p := carbon.Point{Path: fields[0], Timestamp: now}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value %q", line, fields[1])
+		}
+		p.Value = v
+		if len(fields) == 3 {
+			ts, err := strconv.ParseInt(fields[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid timestamp %q", line, fields[2])
+			}
+			p.Timestamp = int32(ts)
+		}
+		if err := checkPutPoint(p); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		points = append(points, p)
+	}
+
+	return points, s.Err()
+}
+
+// parsePutJSON parses an array of {"path": ..., "value": ..., "timestamp": ...},
+// with points without a timestamp at now.
+func parsePutJSON(body []byte, now int32) ([]carbon.Point, error) {
+	var in []putPoint
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	points := make([]carbon.Point, 0, len(in))
+	for i, pp := range in {
+		if pp.Value == nil {
+			return nil, fmt.Errorf("point %d: no value", i)
+		}
+		p := carbon.Point{Path: pp.Path, Value: *pp.Value, Timestamp: now}
+		if pp.Timestamp != nil {
+			p.Timestamp = *pp.Timestamp
+		}
+		if err := checkPutPoint(p); err != nil {
+			return nil, fmt.Errorf("point %d: %v", i, err)
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+func checkPutPoint(p carbon.Point) error {
+	if p.Path == "" || strings.IndexFunc(p.Path, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid path %q", p.Path)
+	}
+	if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+		return fmt.Errorf("%s has no finite value", p.Path)
+	}
+
+	return nil
+}
+
+// putHandler queues the datapoints of the body, plaintext as carbon takes
+// it or JSON, to be relayed to carbon. The paths are those the user may
+// read, and go into its tenant's namespace.
+func putHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "put", &config.API)
+	accessLogDetails.Format = jsonFormat
+	logger := scrubbedLogger("put").With(
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+		zap.String("username", accessLogDetails.Username),
+	)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	fail := func(code int, msg string) {
+		http.Error(w, http.StatusText(code)+": "+msg, code)
+		accessLogDetails.HttpCode = int32(code)
+		accessLogDetails.Reason = msg
+		logAsError = true
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		fail(http.StatusMethodNotAllowed, "datapoints must be POSTed or PUT")
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPutBody))
+	if err != nil {
+		fail(http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	now := int32(timeNow().Unix())
+	var points []carbon.Point
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		points, err = parsePutJSON(body, now)
+	} else {
+		points, err = parsePutPlaintext(body, now)
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if p, ok := acl.FromContext(ctx); ok {
+		for _, point := range points {
+			if !config.acl.Allowed(p, point.Path) {
+				auditDenied(ctx, p, "put", point.Path)
+				fail(http.StatusForbidden, "access to "+point.Path+" denied")
+				return
+			}
+		}
+	}
+	if config.tenancy != nil {
+		if ns := config.tenancy.Namespace(tenant.FromContext(ctx)); ns != "" {
+			for i := range points {
+				points[i].Path = ns.Prefix(points[i].Path)
+			}
+		}
+	}
+
+	if err := putRelay.Put(points); err != nil {
+		w.Header().Set("Retry-After", "1")
+		fail(http.StatusServiceUnavailable, err.Error())
+		logger.Warn("refused datapoints",
+			zap.Int("points", len(points)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"accepted":%d}`, len(points))
+	accessLogDetails.HttpCode = http.StatusAccepted
+}
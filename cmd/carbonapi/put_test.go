@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/carbon"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestParsePut(t *testing.T) {
+	points, err := parsePutPlaintext([]byte("a.b 1.5 100\n\na.c -2\n"), 200)
+	assert.NoError(t, err)
+	assert.Equal(t, []carbon.Point{{Path: "a.b", Value: 1.5, Timestamp: 100}, {Path: "a.c", Value: -2, Timestamp: 200}}, points)
+
+	points, err = parsePutJSON([]byte(`[{"path":"a.b","value":1.5,"timestamp":100},{"path":"a.c","value":-2}]`), 200)
+	assert.NoError(t, err)
+	assert.Equal(t, []carbon.Point{{Path: "a.b", Value: 1.5, Timestamp: 100}, {Path: "a.c", Value: -2, Timestamp: 200}}, points)
+
+	for _, body := range []string{"a.b", "a.b x 100", "a.b 1 x", "a.b 1 100 extra", "a.b nan 100"} {
+		_, err := parsePutPlaintext([]byte(body), 200)
+		assert.Error(t, err, body)
+	}
+	for _, body := range []string{`{}`, `[{"path":"a.b"}]`, `[{"path":"a b","value":1}]`, `[{"value":1}]`} {
+		_, err := parsePutJSON([]byte(body), 200)
+		assert.Error(t, err, body)
+	}
+}
+
+func TestPutHandler(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		received <- string(b)
+	}()
+
+	client, err := carbon.New(l.Addr().String(), carbon.Plaintext, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRelay = carbon.NewRelay(client, 2, 10, time.Hour, zap.NewNop())
+	defer func() { putRelay = nil }()
+
+	put := func(method, contentType, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/metrics/put", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		putHandler(rr, req)
+		return rr
+	}
+
+	rr := put("POST", "application/json; charset=utf-8", `[{"path":"a.b","value":1,"timestamp":100}]`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.JSONEq(t, `{"accepted":1}`, rr.Body.String())
+
+	rr = put("POST", "text/plain", "a.c 2 100\na.d 3 100\n")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusBadRequest, put("POST", "text/plain", "a.c\n").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, put("GET", "", "").Code)
+
+	putRelay.Close()
+	assert.Equal(t, "a.b 1 100\n", <-received)
+}
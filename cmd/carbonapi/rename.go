@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/rename"
+
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"go.uber.org/zap"
+)
+
+// renames holds the renames of metrics, read again from their file as it
+// changes.
+type renames struct {
+	mu    sync.Mutex
+	table *rename.Table
+}
+
+func (r *renames) get() *rename.Table {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.table
+}
+
+func (r *renames) set(t *rename.Table) {
+	r.mu.Lock()
+	r.table = t
+	r.mu.Unlock()
+}
+
+// reloadRenames reads the renames from file every period. Renames that
+// fail to read are logged, and the ones read before kept.
+func reloadRenames(r *renames, file string, period time.Duration, logger *zap.Logger) {
+	for range time.Tick(period) {
+		t, err := rename.Load(file)
+		if err != nil {
+			logger.Error("failed to reload renames",
+				zap.String("file", file),
+				zap.Error(err),
+			)
+			continue
+		}
+		r.set(t)
+	}
+}
+
+// renameZipper asks for the metrics of requests also by the names they
+// had before a rename, or have after it, and merges what it gets under the
+// names asked for, so that dashboards keep working through the rename.
+type renameZipper struct {
+	CarbonZipper
+	renames *renames
+}
+
+func (z renameZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	table := z.renames.get()
+	other, _, ok := table.Map(metric)
+	if !ok {
+		return z.CarbonZipper.Find(ctx, metric)
+	}
+
+	var otherResp pb.GlobResponse
+	var otherErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		otherResp, otherErr = z.CarbonZipper.Find(ctx, other)
+	}()
+	resp, err := z.CarbonZipper.Find(ctx, metric)
+	<-done
+
+	if otherErr != nil {
+		return resp, err
+	}
+	if err != nil {
+		resp, err = pb.GlobResponse{Name: metric}, nil
+	}
+
+	seen := make(map[string]int, len(resp.Matches))
+	for i, m := range resp.Matches {
+		seen[m.Path] = i
+	}
+	for _, m := range otherResp.Matches {
+		path, _, ok := table.Map(m.Path)
+		if !ok {
+			continue
+		}
+		if i, ok := seen[path]; ok {
+			resp.Matches[i].IsLeaf = resp.Matches[i].IsLeaf || m.IsLeaf
+			continue
+		}
+		m.Path = path
+		seen[path] = len(resp.Matches)
+		resp.Matches = append(resp.Matches, m)
+	}
+
+	return resp, err
+}
+
+func (z renameZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	table := z.renames.get()
+	var others []string
+	for _, m := range metrics {
+		if other, _, ok := table.Map(m); ok {
+			others = append(others, other)
+		}
+	}
+	if len(others) == 0 {
+		return z.CarbonZipper.Render(ctx, metrics, from, until)
+	}
+
+	var otherData []*types.MetricData
+	var otherErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		otherData, otherErr = z.CarbonZipper.Render(ctx, others, from, until)
+	}()
+	data, err := z.CarbonZipper.Render(ctx, metrics, from, until)
+	<-done
+
+	if otherErr != nil {
+		return data, err
+	}
+	if err != nil {
+		data, err = nil, nil
+	}
+
+	byName := make(map[string]int, len(data))
+	for i, d := range data {
+		_, legacy, _ := table.Map(d.Name)
+		d.Legacy = legacy
+		byName[d.Name] = i
+	}
+	for _, o := range otherData {
+		name, legacy, ok := table.Map(o.Name)
+		if !ok {
+			continue
+		}
+		o.Name = name
+		o.Legacy = legacy
+
+		i, ok := byName[name]
+		if !ok {
+			byName[name] = len(data)
+			data = append(data, o)
+			continue
+		}
+		if legacy {
+			data[i] = mergeRenamed(data[i], o)
+		} else {
+			data[i] = mergeRenamed(o, data[i])
+		}
+	}
+
+	return data, err
+}
+
+// mergeRenamed fills the absent points of current, the series by the new
+// name of a metric, with those of legacy, the series by its old name, when
+// they line up. current is tagged legacy if it gets any.
+func mergeRenamed(current, legacy *types.MetricData) *types.MetricData {
+	current.Legacy = false
+	if current.StartTime != legacy.StartTime || current.StepTime != legacy.StepTime || len(current.Values) != len(legacy.Values) {
+		return current
+	}
+
+	for i := range current.Values {
+		if current.IsAbsent[i] && !legacy.IsAbsent[i] {
+			current.Values[i] = legacy.Values[i]
+			current.IsAbsent[i] = false
+			current.Legacy = true
+		}
+	}
+
+	return current
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/rename"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// renamedZipper has a metric by its legacy name until the middle of its
+// series, and by its new name after.
+type renamedZipper struct{}
+
+func (z renamedZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	path := "hosts.web1.cpu"
+	if strings.HasPrefix(metric, "servers.") {
+		path = "servers.web1.cpu"
+	}
+	return pb.GlobResponse{Name: metric, Matches: []pb.GlobMatch{{Path: path, IsLeaf: true}}}, nil
+}
+
+func (z renamedZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	return nil, nil
+}
+
+func (z renamedZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	var data []*types.MetricData
+	for _, m := range metrics {
+		if strings.HasPrefix(m, "servers.") {
+			data = append(data, types.MakeMetricData(m, []float64{1, 2, math.NaN(), math.NaN()}, 60, from))
+		} else {
+			data = append(data, types.MakeMetricData(m, []float64{math.NaN(), math.NaN(), 3, 4}, 60, from))
+		}
+	}
+	return data, nil
+}
+
+func TestRenameZipper(t *testing.T) {
+	table, err := rename.New([]rename.Rule{{From: "servers.*", To: "hosts.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := renameZipper{CarbonZipper: renamedZipper{}, renames: &renames{table: table}}
+
+	for _, name := range []string{"servers.web1.cpu", "hosts.web1.cpu"} {
+		glob, err := z.Find(context.Background(), name)
+		assert.NoError(t, err)
+		assert.Equal(t, []pb.GlobMatch{{Path: name, IsLeaf: true}}, glob.Matches)
+
+		data, err := z.Render(context.Background(), []string{name}, 0, 240)
+		assert.NoError(t, err)
+		if assert.Len(t, data, 1) {
+			assert.Equal(t, name, data[0].Name)
+			assert.Equal(t, []float64{1, 2, 3, 4}, data[0].Values)
+			assert.Equal(t, []bool{false, false, false, false}, data[0].IsAbsent)
+			assert.True(t, data[0].Legacy)
+		}
+	}
+
+	data, err := z.Render(context.Background(), []string{"apps.web1.cpu"}, 0, 240)
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, "apps.web1.cpu", data[0].Name)
+		assert.False(t, data[0].Legacy)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// Clients such as Grafana retry slow renders while the first attempt is
+// still being evaluated. A retry that carries the Idempotency-Key, or the
+// Carbon UUID, of a running render joins it and is answered with its
+// response instead of starting the same work again.
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// running are the renders retries can join, by the key of joinRetries.
+var running = struct {
+	sync.Mutex
+	byKey map[string]*runningRequest
+}{byKey: make(map[string]*runningRequest)}
+
+type runningRequest struct {
+	done   chan struct{}
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// joinRetries runs h once for all the requests with the same idempotency
+// key and form. The first one is evaluated, and kept going once its client
+// is gone so that its retries still get an answer; the others wait for its
+// response.
+func joinRetries(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(idempotencyKeyHeader)
+		if id == "" {
+			id = r.Header.Get(util.HeaderUUID)
+		}
+		if id == "" || r.ParseForm() != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		k := scopedCacheKey(r.Context(), id+"\x00"+r.URL.Path+"?"+r.Form.Encode())
+
+		running.Lock()
+		req, ok := running.byKey[k]
+		if !ok {
+			req = &runningRequest{done: make(chan struct{}), header: make(http.Header), status: http.StatusOK}
+			running.byKey[k] = req
+		}
+		running.Unlock()
+
+		if ok {
+			apiMetrics.JoinedRetries.Add(1)
+			select {
+			case <-req.done:
+				req.replay(w)
+			case <-r.Context().Done():
+			}
+			return
+		}
+
+		defer func() {
+			running.Lock()
+			delete(running.byKey, k)
+			running.Unlock()
+			close(req.done)
+		}()
+
+		h.ServeHTTP(&recorder{ResponseWriter: w, req: req}, r.WithContext(detached{r.Context()}))
+	})
+}
+
+func (req *runningRequest) replay(w http.ResponseWriter) {
+	for k, v := range req.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(req.status)
+	w.Write(req.body.Bytes())
+}
+
+// recorder writes a response and keeps it for the retries that joined. Only
+// the headers of the handler are kept, not those of the middleware around
+// it, e.g. the content encoding of a client.
+type recorder struct {
+	http.ResponseWriter
+	req         *runningRequest
+	wroteHeader bool
+}
+
+func (w *recorder) Header() http.Header {
+	return w.req.header
+}
+
+func (w *recorder) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	for k, v := range w.req.header {
+		w.ResponseWriter.Header()[k] = v
+	}
+	w.req.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.req.body.Write(b)
+
+	return w.ResponseWriter.Write(b)
+}
+
+// detached keeps the values of a request context, e.g. its UUID, tenant and
+// ACL principal, without the cancellation that comes with its client going
+// away. The render handler bounds it with the global timeout.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
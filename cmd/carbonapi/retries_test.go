@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJoinRetries(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	h := joinRetries(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-release
+		if r.Context().Err() != nil {
+			t.Error("Expected the render to outlive its client")
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(r.FormValue("target")))
+	}))
+
+	get := func(ctx context.Context, key, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/render?target="+target, nil).WithContext(ctx)
+		req.Header.Set(idempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	joined := apiMetrics.JoinedRetries.Value()
+
+	// The first client gives up, its retry joins the render it started.
+	ctx, cancel := context.WithCancel(context.Background())
+	go get(ctx, "a", "foo")
+	<-started
+	cancel()
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 2)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = get(context.Background(), "a", "foo")
+		}(i)
+	}
+	for apiMetrics.JoinedRetries.Value() < joined+2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("Expected a single render, got %d", calls)
+	}
+	for _, w := range responses {
+		if w.Code != http.StatusAccepted || w.Body.String() != "foo" || w.Header().Get("Content-Type") != contentTypeJSON {
+			t.Errorf("Expected the response of the render, got %d %v %q", w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	// Other queries with the same key, and requests once the render is
+	// done, are rendered again.
+	get(context.Background(), "a", "bar")
+	<-started
+	get(context.Background(), "a", "foo")
+	<-started
+	if calls := atomic.LoadInt32(&calls); calls != 3 {
+		t.Errorf("Expected a render per query, got %d", calls)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+// rollupZipper renders the metrics of long time ranges from the rollups
+// that pre-aggregate them, under the prefix of the rollup for the range,
+// and the metrics a rollup doesn't have from their raw data.
+type rollupZipper struct {
+	CarbonZipper
+	// rollups are sorted by decreasing MinRange.
+	rollups []cfg.Rollup
+}
+
+func newRollupZipper(z CarbonZipper, rollups []cfg.Rollup) (rollupZipper, error) {
+	sorted := make([]cfg.Rollup, len(rollups))
+	copy(sorted, rollups)
+	for _, r := range sorted {
+		if r.MinRange <= 0 || !strings.HasSuffix(r.Prefix, ".") || r.Prefix == "." {
+			return rollupZipper{}, fmt.Errorf("rollup %q needs a positive minRange and a prefix ending with a dot", r.Prefix)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinRange > sorted[j].MinRange })
+
+	return rollupZipper{CarbonZipper: z, rollups: sorted}, nil
+}
+
+// rollupFor returns the prefix of the rollup for the range from until, if
+// it is long enough for one.
+func (z rollupZipper) rollupFor(from, until int32) (string, bool) {
+	d := time.Duration(until-from) * time.Second
+	for _, r := range z.rollups {
+		if d >= r.MinRange {
+			return r.Prefix, true
+		}
+	}
+
+	return "", false
+}
+
+func (z rollupZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	prefix, ok := z.rollupFor(from, until)
+	if !ok {
+		return z.CarbonZipper.Render(ctx, metrics, from, until)
+	}
+
+	prefixed := make([]string, len(metrics))
+	for i, m := range metrics {
+		prefixed[i] = prefix + m
+	}
+
+	// A failed rollup render is as if the rollup had none of the metrics.
+	data, _ := z.CarbonZipper.Render(ctx, prefixed, from, until)
+	result := data[:0]
+	names := make(map[string]bool, len(data))
+	for _, d := range data {
+		if !strings.HasPrefix(d.Name, prefix) {
+			continue
+		}
+		d.Name = d.Name[len(prefix):]
+		d.Rollup = prefix
+		names[d.Name] = true
+		result = append(result, d)
+	}
+
+	var raw []string
+	for _, m := range metrics {
+		if !rolledUp(m, names) {
+			raw = append(raw, m)
+		}
+	}
+	if len(raw) == 0 {
+		return result, nil
+	}
+
+	rawData, err := z.CarbonZipper.Render(ctx, raw, from, until)
+	for _, d := range rawData {
+		if !names[d.Name] {
+			result = append(result, d)
+		}
+	}
+	if len(result) > 0 {
+		err = nil
+	}
+
+	return result, err
+}
+
+// rolledUp tells if the rollup had metric, by the names of its series.
+// Globs with braces are taken to be rolled up if any series are.
+func rolledUp(metric string, names map[string]bool) bool {
+	if !strings.ContainsAny(metric, "*?[{") {
+		return names[metric]
+	}
+
+	segments := strings.Split(metric, ".")
+	for name := range names {
+		if strings.ContainsAny(metric, "{}") {
+			return true
+		}
+
+		ns := strings.Split(name, ".")
+		if len(ns) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, s := range segments {
+			if ok, _ := path.Match(s, ns[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
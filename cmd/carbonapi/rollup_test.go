@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rolledUpZipper has svc.a and svc.b, and a rollup of svc.a only.
+type rolledUpZipper struct {
+	requested *[]string
+}
+
+func (z rolledUpZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	return pb.GlobResponse{}, nil
+}
+
+func (z rolledUpZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	return nil, nil
+}
+
+func (z rolledUpZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	*z.requested = append(*z.requested, strings.Join(metrics, ","))
+	var data []*types.MetricData
+	for _, m := range metrics {
+		switch m {
+		case "rollup.1h.svc.a", "rollup.1h.svc.*":
+			data = append(data, types.MakeMetricData("rollup.1h.svc.a", []float64{1}, 3600, from))
+		case "svc.a", "svc.b":
+			data = append(data, types.MakeMetricData(m, []float64{1, 2}, 60, from))
+		}
+	}
+	if len(data) == 0 {
+		return nil, errNoMetrics
+	}
+	return data, nil
+}
+
+func TestRollupZipper(t *testing.T) {
+	var requested []string
+	z, err := newRollupZipper(rolledUpZipper{&requested}, []cfg.Rollup{
+		{Prefix: "rollup.1h.", MinRange: 7 * 24 * time.Hour},
+		{Prefix: "rollup.1m.", MinRange: 24 * time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	week := int32(8 * 24 * 3600)
+	data, err := z.Render(context.Background(), []string{"svc.a", "svc.b"}, 0, week)
+	assert.NoError(t, err)
+	if assert.Len(t, data, 2) {
+		assert.Equal(t, "svc.a", data[0].Name)
+		assert.Equal(t, "rollup.1h.", data[0].Rollup)
+		assert.Equal(t, "svc.b", data[1].Name)
+		assert.Equal(t, "", data[1].Rollup)
+	}
+	assert.Equal(t, []string{"rollup.1h.svc.a,rollup.1h.svc.b", "svc.b"}, requested)
+
+	requested = nil
+	data, err = z.Render(context.Background(), []string{"svc.*"}, 0, week)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1)
+	assert.Equal(t, []string{"rollup.1h.svc.*"}, requested, "globs the rollup has aren't rendered raw")
+
+	requested = nil
+	data, err = z.Render(context.Background(), []string{"svc.b"}, 0, 3600)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1)
+	assert.Equal(t, []string{"svc.b"}, requested, "short ranges are rendered raw")
+
+	_, err = newRollupZipper(z, []cfg.Rollup{{Prefix: "rollup", MinRange: time.Hour}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/carbon"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Recording rules evaluate expressions on an interval and send their series
+// back to carbon under new names, so that dashboards can read expensive
+// aggregations precomputed.
+
+var recordingRuleRuns = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "recording_rule_runs_total",
+		Help: "Count of evaluations of recording rules, partitioned by rule and status",
+	},
+	[]string{"record", "status"},
+)
+
+// recordingRules evaluates the recording rules, if there are any.
+var recordingRules *ruleRecorder
+
+// recordName is replaced with the names of the series of a rule.
+const recordName = "{name}"
+
+// ruleRecorder evaluates the recording rules. Evaluations take turns.
+type ruleRecorder struct {
+	rules  []cfg.RecordingRule
+	carbon *carbon.Client
+	logger *zap.Logger
+
+	turn sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newRuleRecorder(c cfg.RecordingRulesConfig, logger *zap.Logger) (*ruleRecorder, error) {
+	client, err := carbon.New(c.Address, c.Protocol, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]bool)
+	for _, r := range c.Rules {
+		if r.Record == "" || records[r.Record] {
+			return nil, fmt.Errorf("recording rules need distinct records, got %q", r.Record)
+		}
+		records[r.Record] = true
+
+		if r.Interval <= 0 {
+			return nil, fmt.Errorf("recording rule %s needs a positive interval", r.Record)
+		}
+		if _, e, err := parser.ParseExpr(r.Expr); err != nil || e != "" {
+			return nil, fmt.Errorf("recording rule %s: %s", r.Record, buildParseErrorString(r.Expr, e, err))
+		}
+	}
+
+	return &ruleRecorder{
+		rules:  c.Rules,
+		carbon: client,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// Start schedules the rules.
+func (rec *ruleRecorder) Start() {
+	for _, r := range rec.rules {
+		rec.wg.Add(1)
+		go func(r cfg.RecordingRule) {
+			defer rec.wg.Done()
+			runEvery(r.Interval, rec.stop, func(at time.Time) {
+				if err := rec.run(r, at); err != nil {
+					rec.logger.Error("recording rule failed",
+						zap.String("record", r.Record),
+						zap.Time("at", at),
+						zap.Error(err),
+					)
+				}
+			})
+		}(r)
+	}
+}
+
+// Stop stops scheduling the rules, and waits for the running one to finish.
+func (rec *ruleRecorder) Stop() {
+	close(rec.stop)
+	rec.wg.Wait()
+}
+
+// run evaluates r as if at were now, and sends its points to carbon.
+func (rec *ruleRecorder) run(r cfg.RecordingRule, at time.Time) (err error) {
+	defer func() {
+		status := "ok"
+		if err != nil {
+			status = "failed"
+		}
+		recordingRuleRuns.WithLabelValues(r.Record, status).Inc()
+	}()
+
+	rec.turn.Lock()
+	defer rec.turn.Unlock()
+
+	ctx, cancel := context.WithTimeout(util.WithUUID(context.Background()), config.Timeouts.Global)
+	defer cancel()
+
+	logger := rec.logger.With(
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+		zap.String("record", r.Record),
+	)
+
+	ec := evalContext{now: at}
+	from, until := int32(at.Add(-r.Interval).Unix()), int32(at.Unix())
+	if r.From != "" {
+		if from, until, err = ec.timeRange(r.From, "", ""); err != nil {
+			return err
+		}
+	}
+
+	accessLogDetails := carbonapipb.AccessLogDetails{
+		Handler:       "recording_rule",
+		CarbonapiUuid: util.GetUUID(ctx),
+		Targets:       []string{r.Expr},
+		From:          from,
+		Until:         until,
+	}
+	results, _, err := evalRender(ctx, []string{r.Expr}, from, until, nil, ec, &accessLogDetails, logger)
+	if err != nil {
+		return err
+	}
+
+	points, err := recordPoints(r.Record, results)
+	if err != nil {
+		return err
+	}
+	if err := rec.carbon.Send(points); err != nil {
+		return err
+	}
+
+	logger.Debug("recorded",
+		zap.Int("series", len(results)),
+		zap.Int("points", len(points)),
+	)
+
+	return nil
+}
+
+// recordPoints returns the points of results, named after record.
+func recordPoints(record string, results []*types.MetricData) ([]carbon.Point, error) {
+	named := strings.Contains(record, recordName)
+	if len(results) > 1 && !named {
+		return nil, fmt.Errorf("%d series can't all be recorded as %s", len(results), record)
+	}
+
+	var points []carbon.Point
+	for _, d := range results {
+		path := record
+		if named {
+			path = strings.Replace(record, recordName, metricPath(d.Name), -1)
+		}
+
+		for i, v := range d.Values {
+			if d.IsAbsent[i] || math.IsNaN(v) {
+				continue
+			}
+			points = append(points, carbon.Point{
+				Path:      path,
+				Value:     v,
+				Timestamp: d.StartTime + int32(i)*d.StepTime,
+			})
+		}
+	}
+
+	return points, nil
+}
+
+// metricPath replaces what can't be in a metric name, like the parentheses
+// and commas of the names of series that functions return.
+func metricPath(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9',
+			r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
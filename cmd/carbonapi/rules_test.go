@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/carbon"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRecordingRule(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		received <- string(b)
+	}()
+
+	rule := cfg.RecordingRule{
+		Record:   "recorded.foo",
+		Expr:     "foo.bar",
+		Interval: time.Minute,
+	}
+	rec, err := newRuleRecorder(cfg.RecordingRulesConfig{
+		Address:  l.Addr().String(),
+		Protocol: carbon.Plaintext,
+		Timeout:  time.Second,
+		Rules:    []cfg.RecordingRule{rule},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.run(rule, time.Unix(1510914000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "recorded.foo 1510913759 1510913340\nrecorded.foo 1510913818 1510913400\n", <-received)
+}
+
+func TestNewRuleRecorderInvalid(t *testing.T) {
+	c := cfg.RecordingRulesConfig{
+		Address:  "localhost:2003",
+		Protocol: carbon.Pickle,
+		Rules:    []cfg.RecordingRule{{Record: "a", Expr: "sum(b.*)", Interval: time.Minute}},
+	}
+	_, err := newRuleRecorder(c, zap.NewNop())
+	assert.NoError(t, err)
+
+	for _, r := range []cfg.RecordingRule{
+		{Record: "", Expr: "b", Interval: time.Minute},
+		{Record: "a", Expr: "sum(b", Interval: time.Minute},
+		{Record: "a", Expr: "b", Interval: 0},
+	} {
+		c.Rules = []cfg.RecordingRule{r}
+		_, err := newRuleRecorder(c, zap.NewNop())
+		assert.Error(t, err, "%+v", r)
+	}
+}
+
+func TestRecordPoints(t *testing.T) {
+	results := []*types.MetricData{
+		types.MakeMetricData("sumSeries(a.*)", []float64{1, 2}, 60, 0),
+		types.MakeMetricData("b", []float64{3, 4}, 60, 0),
+	}
+	results[0].IsAbsent[0] = true
+
+	_, err := recordPoints("rollup", results)
+	assert.Error(t, err)
+
+	points, err := recordPoints("rollup.{name}.1m", results)
+	assert.NoError(t, err)
+	assert.Equal(t, []carbon.Point{
+		{Path: "rollup.sumSeries_a.__.1m", Value: 2, Timestamp: 60},
+		{Path: "rollup.b.1m", Value: 3, Timestamp: 0},
+		{Path: "rollup.b.1m", Value: 4, Timestamp: 60},
+	}, points)
+}
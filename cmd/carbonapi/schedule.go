@@ -0,0 +1,24 @@
+package main
+
+import (
+	"time"
+)
+
+// runEvery calls run at every multiple of interval since the epoch, so that
+// periodic work doesn't drift with restarts, until stop is closed.
+func runEvery(interval time.Duration, stop <-chan struct{}, run func(at time.Time)) {
+	for {
+		at := nextRun(timeNow(), interval)
+		select {
+		case <-time.After(at.Sub(timeNow())):
+		case <-stop:
+			return
+		}
+
+		run(at)
+	}
+}
+
+func nextRun(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
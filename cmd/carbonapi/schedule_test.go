@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2017, 11, 17, 11, 20, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2017, 11, 17, 12, 0, 0, 0, time.UTC), nextRun(now, time.Hour))
+	assert.Equal(t, time.Date(2017, 11, 17, 11, 25, 0, 0, time.UTC), nextRun(now, 5*time.Minute))
+}
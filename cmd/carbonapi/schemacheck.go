@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// schemaCheckLimit is how many metrics of a glob /admin/schema-check
+	// checks unless asked for another limit.
+	schemaCheckLimit = 1000
+	// schemaCheckWorkers is how many infos it asks for at once.
+	schemaCheckWorkers = 8
+)
+
+// schemaReport is the answer of /admin/schema-check: the metrics of a glob
+// the backends disagree on the schema of.
+type schemaReport struct {
+	Query   string `json:"query"`
+	Checked int    `json:"checked"`
+	// Truncated tells that the glob has more metrics than were checked.
+	Truncated    bool          `json:"truncated"`
+	Inconsistent []mergedInfo  `json:"inconsistent"`
+	Errors       []schemaError `json:"errors"`
+}
+
+type schemaError struct {
+	Metric string `json:"metric"`
+	Error  string `json:"error"`
+}
+
+// schemaCheckHandler reports the metrics of the glob query whose
+// retentions, xFilesFactor or aggregation method differ between the
+// backends that have them.
+func schemaCheckHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	query := r.FormValue("query")
+	if query == "" {
+		http.Error(w, "no query specified", http.StatusBadRequest)
+		return
+	}
+	limit := schemaCheckLimit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "bad limit "+strconv.Quote(s), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	report, err := checkSchemas(ctx, query, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(report)
+}
+
+// checkSchemas checks the schemas of up to limit metrics of query.
+func checkSchemas(ctx context.Context, query string, limit int) (schemaReport, error) {
+	report := schemaReport{
+		Query:        query,
+		Inconsistent: []mergedInfo{},
+		Errors:       []schemaError{},
+	}
+
+	glob, err := config.zipper.Find(ctx, query)
+	if err != nil && err != errNoMetrics {
+		return report, err
+	}
+
+	var metrics []string
+	for _, m := range glob.Matches {
+		if m.IsLeaf {
+			metrics = append(metrics, m.Path)
+		}
+	}
+	sort.Strings(metrics)
+	if len(metrics) > limit {
+		metrics = metrics[:limit]
+		report.Truncated = true
+	}
+	report.Checked = len(metrics)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < schemaCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for metric := range work {
+				infos, err := config.zipper.Info(ctx, metric)
+
+				mu.Lock()
+				if err != nil {
+					report.Errors = append(report.Errors, schemaError{Metric: metric, Error: err.Error()})
+				} else if m := mergeInfo(infos); len(m.Conflicts) > 0 {
+					if m.Name == "" {
+						m.Name = metric
+					}
+					report.Inconsistent = append(report.Inconsistent, m)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, m := range metrics {
+		work <- m
+	}
+	close(work)
+	wg.Wait()
+
+	sort.Slice(report.Inconsistent, func(i, j int) bool { return report.Inconsistent[i].Name < report.Inconsistent[j].Name })
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Metric < report.Errors[j].Metric })
+
+	return report, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaZipper has the metrics of infos, by backend.
+type schemaZipper struct {
+	mockCarbonZipper
+	infos map[string]map[string]pb.InfoResponse
+}
+
+func (z schemaZipper) Find(ctx context.Context, query string) (pb.GlobResponse, error) {
+	resp := pb.GlobResponse{Name: query, Matches: []pb.GlobMatch{{Path: "servers", IsLeaf: false}}}
+	for metric := range z.infos {
+		resp.Matches = append(resp.Matches, pb.GlobMatch{Path: metric, IsLeaf: true})
+	}
+
+	return resp, nil
+}
+
+func (z schemaZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	infos := z.infos[metric]
+	if infos == nil {
+		return nil, errors.New("backend timeout")
+	}
+
+	return infos, nil
+}
+
+func TestSchemaCheckHandler(t *testing.T) {
+	minutely := []pb.Retention{{SecondsPerPoint: 60, NumberOfPoints: 1440}}
+	consistent := pb.InfoResponse{AggregationMethod: "average", XFilesFactor: 0.5, Retentions: minutely}
+	defer func(orig CarbonZipper) { config.zipper = orig }(config.zipper)
+	config.zipper = schemaZipper{infos: map[string]map[string]pb.InfoResponse{
+		"servers.a.cpu": {"store1": consistent, "store2": consistent},
+		"servers.b.cpu": {"store1": consistent, "store2": {AggregationMethod: "average", XFilesFactor: 0, Retentions: minutely}},
+		"servers.c.cpu": {"store1": consistent, "store2": {AggregationMethod: "max", XFilesFactor: 0.5, Retentions: minutely}},
+		"servers.d.cpu": nil,
+	}}
+
+	req := httptest.NewRequest("GET", "/admin/schema-check?query=servers.*.cpu", nil)
+	rr := httptest.NewRecorder()
+	schemaCheckHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var report schemaReport
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 4, report.Checked)
+	assert.False(t, report.Truncated)
+	if assert.Equal(t, 2, len(report.Inconsistent)) {
+		assert.Equal(t, "servers.b.cpu", report.Inconsistent[0].Name)
+		assert.Equal(t, conflict{"0": {"store2"}, "0.5": {"store1"}}, report.Inconsistent[0].Conflicts["xFilesFactor"])
+		assert.Equal(t, "servers.c.cpu", report.Inconsistent[1].Name)
+		assert.Equal(t, conflict{"average": {"store1"}, "max": {"store2"}}, report.Inconsistent[1].Conflicts["aggregationMethod"])
+	}
+	assert.Equal(t, []schemaError{{Metric: "servers.d.cpu", Error: "backend timeout"}}, report.Errors)
+
+	req = httptest.NewRequest("GET", "/admin/schema-check?query=servers.*.cpu&limit=1", nil)
+	rr = httptest.NewRecorder()
+	schemaCheckHandler(rr, req)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 1, report.Checked)
+	assert.True(t, report.Truncated)
+
+	for _, u := range []string{"/admin/schema-check", "/admin/schema-check?query=x&limit=0"} {
+		rr = httptest.NewRecorder()
+		schemaCheckHandler(rr, httptest.NewRequest("GET", u, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, u)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/secrets"
+
+	"go.uber.org/zap"
+)
+
+// secretsRetry is how soon resolving the secrets is tried again after it
+// failed, while those in use are about to expire.
+const secretsRetry = time.Minute
+
+// watchSecrets resolves the secrets of raw, the config as read, again on
+// SIGHUP and before those resolved to applied expire in ttl, and puts the
+// new ones to use.
+func watchSecrets(r *secrets.Resolver, raw cfg.API, applied cfg.API, ttl time.Duration, logger *zap.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	wait := renewal(ttl)
+	for {
+		var renew <-chan time.Time
+		if wait > 0 {
+			renew = time.After(wait)
+		}
+		select {
+		case <-hup:
+		case <-renew:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		api := raw
+		next, err := r.Resolve(ctx, &api)
+		cancel()
+		if err != nil {
+			logger.Error("failed to resolve secrets", zap.Error(err))
+			if wait > 0 {
+				wait = secretsRetry
+			}
+			continue
+		}
+
+		applySecrets(applied, api, logger)
+		applied = api
+		wait = renewal(next)
+		logger.Info("resolved secrets", zap.Duration("ttl", next))
+	}
+}
+
+// renewal is how long before secrets that expire in ttl are resolved
+// again, 0 for those that don't.
+func renewal(ttl time.Duration) time.Duration {
+	return ttl * 2 / 3
+}
+
+// applySecrets rebuilds what uses the secrets that changed from old to
+// api.
+func applySecrets(old, api cfg.API, logger *zap.Logger) {
+	if api.Events != old.Events {
+		store, err := events.New(api.Events)
+		if err != nil {
+			logger.Error("failed to set up events store", zap.Error(err))
+		} else {
+			events.SetDefault(store)
+		}
+	}
+
+	if config.signer != nil && !reflect.DeepEqual(api.Signatures, old.Signatures) {
+		if err := config.signer.Update(api.Signatures); err != nil {
+			logger.Error("failed to update signatures", zap.Error(err))
+		}
+	}
+
+	if exports != nil && api.Exports.Store != old.Exports.Store {
+		exports.store.Update(api.Exports.Store)
+	}
+}
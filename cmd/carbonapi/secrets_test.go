@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/signature"
+
+	"go.uber.org/zap"
+)
+
+func TestApplySecrets(t *testing.T) {
+	defer func(s *signature.Verifier) { config.signer = s }(config.signer)
+
+	old := config.API
+	old.Signatures = signature.Config{Clients: map[string]string{"exporter": "old"}}
+	var err error
+	config.signer, err = signature.New(old.Signatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := old
+	rotated.Signatures = signature.Config{Clients: map[string]string{"exporter": "new"}}
+	applySecrets(old, rotated, zap.NewNop())
+
+	req := httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	signature.Sign(req, "exporter", "new", time.Now())
+	if _, err := config.signer.Verify(req); err != nil {
+		t.Errorf("expected the rotated secret to verify, got %v", err)
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	if got := renewal(0); got != 0 {
+		t.Errorf("expected secrets without a lease not to be renewed, got %v", got)
+	}
+	if got := renewal(time.Hour); got != 40*time.Minute {
+		t.Errorf("expected renewal at two thirds of the lease, got %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"go.uber.org/zap"
+)
+
+// loadPathCache fills the path cache with the snapshot the last carbonapi
+// to stop saved, so that it doesn't start cold.
+func loadPathCache(logger *zap.Logger) {
+	if config.Shutdown.PathCacheFile == "" {
+		return
+	}
+
+	if err := config.PathCache.Load(config.Shutdown.PathCacheFile); err != nil {
+		logger.Warn("failed to load the path cache",
+			zap.String("path", config.Shutdown.PathCacheFile),
+			zap.Error(err),
+		)
+	}
+}
+
+// shutdownHooks are run once carbonapi has drained. They stop the exports
+// and the recording rules, send the queued datapoints, save the path cache,
+// stop the zipper and close the request journal.
+func shutdownHooks(z *zipper, logger *zap.Logger) []func() {
+	return []func(){
+		func() {
+			if exports != nil {
+				exports.Stop()
+			}
+			if recordingRules != nil {
+				recordingRules.Stop()
+			}
+			if putRelay != nil {
+				putRelay.Close()
+			}
+		},
+		func() {
+			if config.Shutdown.PathCacheFile == "" {
+				return
+			}
+			if err := config.PathCache.Save(config.Shutdown.PathCacheFile); err != nil {
+				logger.Error("failed to save the path cache",
+					zap.String("path", config.Shutdown.PathCacheFile),
+					zap.Error(err),
+				)
+			}
+		},
+		z.Close,
+		func() {
+			if requestJournal != nil {
+				requestJournal.Close()
+			}
+		},
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// verifySignature checks the signature of r, if signatures are configured,
+// and returns the client that signed it.
+func verifySignature(r *http.Request) (string, error) {
+	if config.signer == nil {
+		return "", nil
+	}
+
+	client, err := config.signer.Verify(r)
+	if err != nil {
+		apiMetrics.SignatureRejections.Add(1)
+		scrubbedLogger("signature").Warn("refused request",
+			zap.String("carbonapi_uuid", util.GetUUID(r.Context())),
+			zap.String("client", client),
+			zap.String("url", r.URL.RequestURI()),
+			zap.Error(err),
+		)
+	}
+
+	return client, err
+}
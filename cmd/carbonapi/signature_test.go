@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/signature"
+)
+
+func TestValidateRequestSignature(t *testing.T) {
+	defer func(s *signature.Verifier) { config.signer = s }(config.signer)
+	var err error
+	config.signer, err = signature.New(signature.Config{
+		Clients:  map[string]string{"exporter": "s3cret"},
+		Required: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := jsonForm(validateRequest(http.HandlerFunc(renderHandler), "render"), "render")
+
+	req := httptest.NewRequest("POST", "/render/?format=json&noCache=1", strings.NewReader(`{"target": "foo.bar", "from": "-10minutes"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if err := signature.Sign(req, "exporter", "s3cret", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Body.String(), `[{"target":"foo.bar"`) {
+		t.Errorf("expected a signed request to be served, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rejected := apiMetrics.SignatureRejections.Value()
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar&format=json", nil)
+	signature.Sign(req, "exporter", "s3cret", time.Now().Add(-time.Hour))
+	rr = httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a replayed request, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar&format=json", nil)
+	rr = httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an unsigned request, got %d", rr.Code)
+	}
+	if got := apiMetrics.SignatureRejections.Value() - rejected; got != 2 {
+		t.Errorf("expected 2 rejections, got %d", got)
+	}
+}
+
+func TestValidateRequestSignatureRoutePrefix(t *testing.T) {
+	defer func(s *signature.Verifier, prefix string) {
+		config.signer, config.RoutePrefix = s, prefix
+	}(config.signer, config.RoutePrefix)
+	var err error
+	config.signer, err = signature.New(signature.Config{
+		Clients:  map[string]string{"exporter": "s3cret"},
+		Required: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.RoutePrefix = "/graphite/"
+	h := initHandlers()
+
+	req := httptest.NewRequest("GET", "/graphite/render/?target=foo.bar&format=json&noCache=1", nil)
+	if err := signature.Sign(req, "exporter", "s3cret", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a signed request under the route prefix to be served, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/graphite/render/?target=foo.bar&format=json&noCache=1", nil)
+	signature.Sign(req, "exporter", "s3cret", time.Now())
+	req.RequestURI = "/graphite/render/?target=secrets.*&format=json&noCache=1"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a tampered request line, got %d", rr.Code)
+	}
+}
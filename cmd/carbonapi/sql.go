@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/sqlquery"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// sqlHandler runs the SELECT in the q parameter over the series of the
+// target it asks for, and answers with its rows as JSON.
+func sqlHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "sql", &config.API)
+	accessLogDetails.Format = jsonFormat
+	logger := scrubbedLogger("sql").With(
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+		zap.String("username", accessLogDetails.Username),
+	)
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	fail := func(code int, msg string) {
+		http.Error(w, http.StatusText(code)+": "+msg, code)
+		accessLogDetails.HttpCode = int32(code)
+		accessLogDetails.Reason = msg
+		logAsError = true
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.FormValue("q")
+	if query == "" {
+		fail(http.StatusBadRequest, "no query specified")
+		return
+	}
+	q, err := sqlquery.Parse(query)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	accessLogDetails.Targets = []string{q.Target}
+
+	qtz := r.FormValue("tz")
+	ec, err := parseEvalContext(r.Form, qtz)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	from32, until32, err := ec.timeRange(q.From, q.Until, qtz)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if from32 >= until32 {
+		fail(http.StatusBadRequest, "invalid empty time range")
+		return
+	}
+	accessLogDetails.FromRaw = q.From
+	accessLogDetails.From = from32
+	accessLogDetails.UntilRaw = q.Until
+	accessLogDetails.Until = until32
+
+	data, code, err := evalRender(ctx, []string{q.Target}, from32, until32, parser.TemplatesFromForm(r.Form), ec, &accessLogDetails, logger)
+	if err != nil {
+		fail(code, err.Error())
+		return
+	}
+
+	series := make([]sqlquery.Series, len(data))
+	for i, d := range data {
+		series[i] = sqlquery.Series{
+			Name:   d.Name,
+			Start:  d.StartTime,
+			Step:   d.StepTime,
+			Values: d.Values,
+			Absent: d.IsAbsent,
+		}
+	}
+
+	res, err := q.Run(series, from32, until32, config.SQL.MaxRows)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, b, jsonFormat, "")
+	accessLogDetails.HttpCode = http.StatusOK
+}
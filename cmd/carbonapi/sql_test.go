@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLHandler(t *testing.T) {
+	query := func(q string) (int, string) {
+		req, rr := setUpRequest(t, "/sql?q="+url.QueryEscape(q))
+		sqlHandler(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	code, body := query("SELECT name, count(*), count(value), max(value) FROM series WHERE target = 'foo.bar' AND time >= 1510913000 AND time < 1510914000 GROUP BY name")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"columns":["name","count(*)","count(value)","max(value)"],"rows":[["foo.bar",3,2,1510913818]]}`, body)
+
+	code, body = query("SELECT time FROM series WHERE target = 'foo.bar' AND time >= 1510913000 AND time < 1510914000 AND value IS NULL")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"columns":["time"],"rows":[[1510913280]]}`, body)
+
+	code, _ = query("SELECT * FROM series")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = query("SELECT * FROM series WHERE target = 'foo.bar(' AND time >= 1510913000")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cache"
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// cacheGet looks k up in c. When c keeps stale entries, an expired entry is a
+// hit too, and refresh is started in the background to replace it.
+func cacheGet(c cache.BytesCache, k string, refresh func()) ([]byte, error) {
+	sc, ok := c.(*cache.StaleCache)
+	if !ok {
+		return c.Get(k)
+	}
+
+	v, stale, err := sc.GetStale(k)
+	if err == nil && stale {
+		apiMetrics.CacheStaleHits.Add(1)
+		sc.Refresh(k, refresh)
+	}
+
+	return v, err
+}
+
+// refreshRender renders the request the cache key came from again, bypassing
+// the cache, which stores the new response.
+func refreshRender(r *http.Request, cacheKey string) func() {
+	parent := r.Context()
+	return func() {
+		ctx := detach(parent)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL.Path+"?"+cacheKey+"&noCache=1", nil)
+		if err != nil {
+			return
+		}
+		req.Header = r.Header.Clone()
+
+		renderHandler(discardWriter{header: make(http.Header)}, req)
+	}
+}
+
+// refreshGlob resolves metric again for the request of parent, bypassing the
+// cache, which stores the new response.
+func refreshGlob(parent context.Context, metric string) func() {
+	return func() {
+		ctx, cancel := context.WithTimeout(detach(parent), config.Timeouts.Global)
+		defer cancel()
+
+		var accessLogDetails carbonapipb.AccessLogDetails
+		resolveGlobs(ctx, metric, false, &accessLogDetails)
+	}
+}
+
+// detach returns a context for background work on behalf of the request of
+// parent. It keeps who the request is from, but not its deadline.
+func detach(parent context.Context) context.Context {
+	ctx := util.WithUUID(context.Background())
+	ctx = tenant.NewContext(ctx, tenant.FromContext(parent))
+	if p, ok := acl.FromContext(parent); ok {
+		ctx = acl.NewContext(ctx, p)
+	}
+
+	return ctx
+}
+
+// scopedCacheKey scopes k to the tenant and roles of the request, so they
+// don't get each other's cached responses. The tenant and roles are named
+// and escaped, so that neither can pass for the other, nor commas in roles
+// make up the roles of others.
+func scopedCacheKey(ctx context.Context, k string) string {
+	if p, ok := acl.FromContext(ctx); ok {
+		roles := make([]string, len(p.Roles))
+		for i, role := range p.Roles {
+			roles[i] = url.QueryEscape(role)
+		}
+		k = "roles=" + strings.Join(roles, ",") + "\x00" + k
+	}
+	if id := tenant.FromContext(ctx); id != "" {
+		k = "tenant=" + url.QueryEscape(id) + "\x00" + k
+	}
+
+	return k
+}
+
+// globCacheKey is the key of the cached matches of glob. It is escaped like
+// the keys of renders, as a glob can have anything in it, including the
+// separators of scopedCacheKey.
+func globCacheKey(glob string) string {
+	return "query=" + url.QueryEscape(glob)
+}
+
+// discardWriter is the response writer of background refreshes.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header       { return w.header }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/bookingcom/carbonapi/pkg/statsd"
+
+	"go.uber.org/zap"
+)
+
+// newStatsD returns the statsd client of the metrics graphite gets as
+// pattern.<name>. They are sent as <statsd prefix>.<name>, the prefix being
+// that of graphite unless configured.
+func newStatsD(pattern string, logger *zap.Logger) *statsd.Client {
+	c := config.StatsD
+	if c.Interval <= 0 {
+		c.Interval = config.Graphite.Interval
+	}
+	if c.Prefix == "" {
+		c.Prefix = config.Graphite.Prefix
+	}
+
+	client, err := statsd.New(c, pattern+".")
+	if err != nil {
+		logger.Fatal("failed to set up statsd",
+			zap.String("address", c.Address),
+			zap.Error(err),
+		)
+	}
+
+	return client
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/date"
+	"github.com/bookingcom/carbonapi/expr"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+	"github.com/bookingcom/carbonapi/util"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/gorilla/handlers"
+	"github.com/satori/go.uuid"
+
+	"go.uber.org/zap"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+// renderStream holds the state of a /render/stream subscription between ticks.
+type renderStream struct {
+	// id names the stream in its event ids, and key is who asked for what,
+	// which a reconnect has to match to resume it.
+	id  string
+	key string
+
+	exprs  []parser.Expr
+	window int32
+
+	// fetched keeps the data of the previous tick so that only the newest
+	// points have to be asked from the zipper.
+	fetched map[parser.MetricRequest][]*types.MetricData
+	// fetchedUntil is the until of the previous fetch for each metric.
+	fetchedUntil map[parser.MetricRequest]int32
+	// sent is the timestamp of the last point sent for each series.
+	sent map[string]int32
+	// resumeAfter is the Last-Event-ID of a reconnecting client.
+	resumeAfter int32
+}
+
+// streams keeps the state of the streams that ended, until their clients
+// reconnect.
+var streams = &streamStore{
+	byID:     make(map[string]*renderStream),
+	expiries: make(map[string]time.Time),
+}
+
+// streamStore keeps streams by id for as long as their clients may take to
+// reconnect.
+type streamStore struct {
+	mu       sync.Mutex
+	byID     map[string]*renderStream
+	expiries map[string]time.Time
+}
+
+// put keeps s until expires.
+func (ss *streamStore) put(s *renderStream, expires time.Time) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.byID[s.id] = s
+	ss.expiries[s.id] = expires
+
+	time.AfterFunc(time.Until(expires), func() {
+		ss.expire(s.id, expires)
+	})
+}
+
+// expire drops the stream id if it was kept until expires, and not put again
+// since.
+func (ss *streamStore) expire(id string, expires time.Time) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if e, ok := ss.expiries[id]; ok && e.Equal(expires) {
+		delete(ss.byID, id)
+		delete(ss.expiries, id)
+	}
+}
+
+// take returns the stream id of key, if it is kept, and stops keeping it so
+// that only one connection resumes it.
+func (ss *streamStore) take(id, key string) (*renderStream, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	s, ok := ss.byID[id]
+	if !ok || s.key != key || time.Now().After(ss.expiries[id]) {
+		return nil, false
+	}
+	delete(ss.byID, id)
+	delete(ss.expiries, id)
+
+	return s, true
+}
+
+// parseEventID splits the id of an event into the id of its stream and the
+// timestamp up to which it sent every series. Ids without a stream are
+// timestamps alone.
+func parseEventID(eventID string) (string, int32, bool) {
+	stream := ""
+	if i := strings.LastIndex(eventID, "-"); i >= 0 {
+		stream, eventID = eventID[:i], eventID[i+1:]
+	}
+
+	ts, err := strconv.Atoi(eventID)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return stream, int32(ts), true
+}
+
+// compressHandler compresses the responses of h, except for streams. These
+// are flushed event by event, and the compressing writer hides the connection
+// whose write deadline they move.
+func compressHandler(h http.Handler) http.Handler {
+	compressed := handlers.CompressHandler(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/render/stream" || strings.HasPrefix(r.URL.Path, "/render/stream/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		compressed.ServeHTTP(w, r)
+	})
+}
+
+// renderStreamHandler serves server-sent events with the new datapoints of
+// the requested targets every refresh interval. The response of each tick is
+// the same as format=json, limited to points not sent before.
+//
+// Streams end after the stream lifetime; EventSource clients reconnect on
+// their own and pass the last event id. The state of the stream is kept
+// until then, so a reconnect only fetches and sends the points that came
+// since.
+func renderStreamHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+	logger := scrubbedLogger("render").With(
+		zap.String("carbonapi_uuid", util.GetUUID(r.Context())),
+		zap.String("handler", "render_stream"),
+	)
+
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, "render_stream", &config.API)
+	accessLogDetails.Format = jsonFormat
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(r, &accessLogDetails, t0, logAsError)
+	}()
+
+	apiMetrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	targets := r.Form["target"]
+	accessLogDetails.Targets = targets
+	if len(targets) == 0 {
+		http.Error(w, "no target specified", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "no target specified"
+		logAsError = true
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		accessLogDetails.HttpCode = http.StatusInternalServerError
+		accessLogDetails.Reason = "response writer can't flush"
+		logAsError = true
+		return
+	}
+
+	s := &renderStream{
+		id:           uuid.NewV4().String(),
+		key:          tenant.FromContext(r.Context()) + "\x00" + requestUser(r) + "\x00" + r.URL.RawQuery,
+		fetched:      make(map[parser.MetricRequest][]*types.MetricData),
+		fetchedUntil: make(map[parser.MetricRequest]int32),
+		sent:         make(map[string]int32),
+	}
+
+	templates := parser.TemplatesFromForm(r.Form)
+	if err := checkComplexity(targets, templates); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	for _, target := range targets {
+		exp, e, err := parser.ParseExpr(target)
+		if err != nil || e != "" {
+			msg := buildParseErrorString(target, e, err)
+			http.Error(w, msg, http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = msg
+			logAsError = true
+			return
+		}
+
+		exp, err = config.macros.Expand(exp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+			return
+		}
+
+		exp, err = templates.Expand(exp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+			return
+		}
+
+		if err := checkBlocklistExpr(r.Context(), exp); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden)+": "+err.Error(), http.StatusForbidden)
+			accessLogDetails.HttpCode = http.StatusForbidden
+			accessLogDetails.Reason = err.Error()
+			logAsError = true
+			return
+		}
+		s.exprs = append(s.exprs, exp)
+	}
+
+	now := timeNow().Unix()
+	from := r.FormValue("from")
+	if from == "" {
+		from = "-1h"
+	}
+	from32, err := date.ParseDateParam(from, r.FormValue("tz"), now-3600, config.defaultTimeZone)
+	accessLogDetails.FromRaw = from
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+	s.window = int32(now) - from32
+	if s.window <= 0 {
+		http.Error(w, "from must be in the past", http.StatusBadRequest)
+		accessLogDetails.HttpCode = http.StatusBadRequest
+		accessLogDetails.Reason = "from must be in the past"
+		logAsError = true
+		return
+	}
+
+	refresh := config.StreamRefreshInterval
+	if rs := r.FormValue("refresh"); rs != "" {
+		secs, err := parser.IntervalString(rs, 1)
+		if err != nil || secs <= 0 {
+			http.Error(w, "invalid refresh interval", http.StatusBadRequest)
+			accessLogDetails.HttpCode = http.StatusBadRequest
+			accessLogDetails.Reason = "invalid refresh interval"
+			logAsError = true
+			return
+		}
+		refresh = time.Duration(secs) * time.Second
+	}
+
+	if id, ts, ok := parseEventID(r.Header.Get("Last-Event-ID")); ok {
+		if resumed, ok := streams.take(id, s.key); ok {
+			s = resumed
+			// the client may have missed the events after its last one
+			for name, sent := range s.sent {
+				if sent > ts {
+					s.sent[name] = ts
+				}
+			}
+		}
+		s.resumeAfter = ts
+	}
+
+	w.Header().Set("Content-Type", contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "retry: %d\n\n", refresh/time.Millisecond)
+
+	// The server write timeout applies to the whole response, streams move
+	// it past their lifetime and the last tick. Where they can't, they end
+	// before it.
+	deadline := t0.Add(config.StreamLifetime)
+	err = http.NewResponseController(w).SetWriteDeadline(deadline.Add(config.Timeouts.Global))
+	if timeout := t0.Add(config.Timeouts.Global - time.Second); err != nil && timeout.Before(deadline) {
+		deadline = timeout
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	defer func() {
+		streams.put(s, time.Now().Add(refresh+config.Timeouts.Global))
+	}()
+
+	for {
+		body, id := s.tick(r.Context(), &accessLogDetails, logger)
+		if body != nil {
+			fmt.Fprintf(w, "id: %s-%d\ndata: %s\n\n", s.id, id, body)
+		} else {
+			// keeps proxies from closing an idle connection
+			w.Write([]byte(": no new points\n\n"))
+		}
+		flusher.Flush()
+
+		if time.Now().Add(refresh).After(deadline) {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick fetches what changed since the last tick, evaluates the targets and
+// returns the JSON encoded new points, or nil if there are none. The returned
+// id is the timestamp up to which every series has been sent.
+func (s *renderStream) tick(ctx context.Context, accessLogDetails *carbonapipb.AccessLogDetails, logger *zap.Logger) ([]byte, int32) {
+	ctx, cancel := context.WithTimeout(ctx, config.Timeouts.Global)
+	defer cancel()
+
+	until := int32(timeNow().Unix())
+	from := until - s.window
+
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	fetched := make(map[parser.MetricRequest]struct{})
+	for _, exp := range s.exprs {
+		for _, m := range exp.Metrics() {
+			mfetch := m
+			mfetch.From += from
+			mfetch.Until += until
+
+			if _, ok := fetched[mfetch]; ok {
+				apiMetrics.SavedFetches.Add(1)
+				continue
+			}
+			fetched[mfetch] = struct{}{}
+
+			fetchFrom := mfetch.From
+			if prev, ok := s.fetchedUntil[m]; ok {
+				// refetch the last step of the previous tick, it may have
+				// been incomplete
+				fetchFrom = prev - maxStep(s.fetched[m])
+				if fetchFrom < mfetch.From {
+					fetchFrom = mfetch.From
+				}
+			}
+
+			data, _, err := fetchMetric(ctx, parser.MetricRequest{Metric: m.Metric, From: fetchFrom, Until: mfetch.Until}, true, accessLogDetails, logger)
+			if err != nil {
+				logger.Error("find error",
+					zap.String("metric", m.Metric),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			s.fetched[m] = spliceMetrics(s.fetched[m], data, mfetch.From)
+			s.fetchedUntil[m] = mfetch.Until
+			if len(s.fetched[m]) > 0 {
+				metricMap[mfetch] = s.fetched[m]
+			}
+		}
+	}
+
+	var results []*types.MetricData
+	ctx, done := traceEval(ctx)
+	for _, exp := range s.exprs {
+		exprs, err := expr.EvalExpr(ctx, exp, from, until, metricMap)
+		if err != nil {
+			if err != parser.ErrSeriesDoesNotExist {
+				logger.Error("stream eval failed",
+					zap.String("target", exp.ToString()),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		results = append(results, exprs...)
+	}
+	done()
+
+	fresh := make([]*types.MetricData, 0, len(results))
+	id := until
+	for _, r := range results {
+		after, sent := s.sent[r.Name]
+		if !sent {
+			after = s.resumeAfter
+		}
+
+		if f := pointsAfter(r, after); f != nil {
+			fresh = append(fresh, f)
+			after = f.StopTime - f.StepTime
+			s.sent[r.Name] = after
+			sent = true
+		}
+
+		if sent && after < id {
+			id = after
+		}
+	}
+
+	if len(fresh) == 0 {
+		return nil, id
+	}
+
+	return types.MarshalJSON(fresh), id
+}
+
+// pointsAfter returns the points of r newer than ts, leaving out trailing
+// absent points that may still be filled in. It returns nil if nothing is left.
+func pointsAfter(r *types.MetricData, ts int32) *types.MetricData {
+	if r.StepTime <= 0 {
+		return nil
+	}
+
+	start := 0
+	if ts >= r.StartTime {
+		start = int((ts-r.StartTime)/r.StepTime) + 1
+	}
+
+	end := len(r.Values)
+	for end > start && r.IsAbsent[end-1] {
+		end--
+	}
+
+	if start >= end {
+		return nil
+	}
+
+	startTime := r.StartTime + int32(start)*r.StepTime
+	return &types.MetricData{FetchResponse: pb.FetchResponse{
+		Name:      r.Name,
+		StartTime: startTime,
+		StopTime:  startTime + int32(end-start)*r.StepTime,
+		StepTime:  r.StepTime,
+		Values:    r.Values[start:end],
+		IsAbsent:  r.IsAbsent[start:end],
+	}}
+}
+
+// spliceMetrics appends freshly fetched series to the ones kept from the
+// previous tick, dropping points before from.
+func spliceMetrics(old, fresh []*types.MetricData, from int32) []*types.MetricData {
+	byName := make(map[string]*types.MetricData, len(old))
+	for _, o := range old {
+		byName[o.Name] = o
+	}
+
+	res := make([]*types.MetricData, 0, len(fresh))
+	for _, f := range fresh {
+		if o, ok := byName[f.Name]; ok {
+			f = spliceSeries(o, f, from)
+			delete(byName, f.Name)
+		}
+		res = append(res, f)
+	}
+
+	// keep series the last fetch didn't return, e.g. because a backend failed
+	for _, o := range old {
+		if _, ok := byName[o.Name]; ok {
+			res = append(res, o)
+		}
+	}
+
+	return res
+}
+
+func spliceSeries(o, f *types.MetricData, from int32) *types.MetricData {
+	step := f.StepTime
+	if o.StepTime != step || step <= 0 || (f.StartTime-o.StartTime)%step != 0 {
+		return f
+	}
+
+	start := o.StartTime
+	if start < from {
+		start += (from - start + step - 1) / step * step
+	}
+	if start >= f.StartTime {
+		return f
+	}
+
+	count := int((f.StartTime - start) / step)
+	values := make([]float64, 0, count+len(f.Values))
+	absent := make([]bool, 0, count+len(f.Values))
+	for i := 0; i < count; i++ {
+		j := int((start-o.StartTime)/step) + i
+		if j < len(o.Values) {
+			values = append(values, o.Values[j])
+			absent = append(absent, o.IsAbsent[j])
+		} else {
+			values = append(values, 0)
+			absent = append(absent, true)
+		}
+	}
+	values = append(values, f.Values...)
+	absent = append(absent, f.IsAbsent...)
+
+	return &types.MetricData{FetchResponse: pb.FetchResponse{
+		Name:      f.Name,
+		StartTime: start,
+		StopTime:  f.StopTime,
+		StepTime:  step,
+		Values:    values,
+		IsAbsent:  absent,
+	}}
+}
+
+func maxStep(data []*types.MetricData) int32 {
+	var step int32
+	for _, d := range data {
+		if d.StepTime > step {
+			step = d.StepTime
+		}
+	}
+
+	return step
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderStreamHandler(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/stream?target=foo.bar&from=-10minutes&refresh=1s")
+	ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	renderStreamHandler(rr, req.WithContext(ctx))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeEventStream, rr.Header().Get("Content-Type"))
+
+	body := rr.Body.String()
+	eventID := regexp.MustCompile(`(?m)^id: (.*)$`).FindStringSubmatch(body)
+	if assert.Len(t, eventID, 2) {
+		_, ts, ok := parseEventID(eventID[1])
+		assert.True(t, ok)
+		assert.Equal(t, int32(1510913400), ts)
+	}
+	assert.True(t, strings.HasPrefix(body, "retry: 1000\n\n"), body)
+	assert.Contains(t, body, `data: [{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`+"\n\n")
+}
+
+// fromZipper records the from of its renders.
+type fromZipper struct {
+	mockCarbonZipper
+
+	mu    sync.Mutex
+	froms []int32
+}
+
+func (z *fromZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	z.mu.Lock()
+	z.froms = append(z.froms, from)
+	z.mu.Unlock()
+
+	return z.mockCarbonZipper.Render(ctx, metrics, from, until)
+}
+
+func TestRenderStreamHandlerReconnect(t *testing.T) {
+	z := &fromZipper{}
+	defer func(orig CarbonZipper, lifetime time.Duration) {
+		config.zipper, config.StreamLifetime = orig, lifetime
+	}(config.zipper, config.StreamLifetime)
+	config.zipper = z
+	// the stream ends after its first event
+	config.StreamLifetime = time.Second
+
+	req, rr := setUpRequest(t, "/render/stream?target=foo.bar&from=-10minutes&refresh=1s")
+	renderStreamHandler(rr, req)
+	eventID := regexp.MustCompile(`(?m)^id: (.*)$`).FindStringSubmatch(rr.Body.String())
+	if !assert.Len(t, eventID, 2, rr.Body.String()) {
+		return
+	}
+
+	req, rr = setUpRequest(t, "/render/stream?target=foo.bar&from=-10minutes&refresh=1s")
+	req.Header.Set("Last-Event-ID", eventID[1])
+	renderStreamHandler(rr, req)
+
+	if assert.Len(t, z.froms, 2) {
+		assert.True(t, z.froms[1]-z.froms[0] >= 500, "expected the reconnect to fetch the newest points only, got froms %v", z.froms)
+	}
+	assert.NotContains(t, rr.Body.String(), "data:", "expected no points sent again")
+}
+
+func TestRenderStreamHandlerResume(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/stream?target=foo.bar&from=-10minutes&refresh=1s")
+	req.Header.Set("Last-Event-ID", "1510913340")
+	ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	renderStreamHandler(rr, req.WithContext(ctx))
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `"datapoints":[[1510913818,1510913400]]`) {
+		t.Errorf("expected only points after the last event id, got %q", body)
+	}
+}
+
+func TestRenderStreamHandlerLifetime(t *testing.T) {
+	defer func(global, lifetime time.Duration) {
+		config.Timeouts.Global, config.StreamLifetime = global, lifetime
+	}(config.Timeouts.Global, config.StreamLifetime)
+	config.Timeouts.Global = time.Second
+	config.StreamLifetime = 2500 * time.Millisecond
+
+	srv := httptest.NewUnstartedServer(compressHandler(http.HandlerFunc(renderStreamHandler)))
+	srv.Config.WriteTimeout = config.Timeouts.Global
+	srv.Start()
+	defer srv.Close()
+
+	t0 := time.Now()
+	resp, err := http.Get(srv.URL + "/render/stream?target=foo.bar&from=-10minutes&refresh=1s")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.True(t, time.Since(t0) >= 2*time.Second, "expected the stream to outlive the write timeout, it lasted %v", time.Since(t0))
+	assert.Equal(t, 3, strings.Count(string(body), "\n\n")-1, string(body))
+}
+
+func TestStreamStoreExpire(t *testing.T) {
+	ss := &streamStore{
+		byID:     make(map[string]*renderStream),
+		expiries: make(map[string]time.Time),
+	}
+
+	ss.put(&renderStream{id: "a"}, time.Now().Add(10*time.Millisecond))
+	ss.put(&renderStream{id: "b"}, time.Now().Add(time.Hour))
+	time.Sleep(50 * time.Millisecond)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	assert.NotContains(t, ss.byID, "a")
+	assert.NotContains(t, ss.expiries, "a")
+	assert.Contains(t, ss.byID, "b")
+}
+
+func TestRenderStreamHandlerBadRequest(t *testing.T) {
+	for _, url := range []string{
+		"/render/stream",
+		"/render/stream?target=foo.bar(",
+		"/render/stream?target=foo.bar&refresh=soon",
+	} {
+		req, rr := setUpRequest(t, url)
+		renderStreamHandler(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+}
+
+func TestSpliceSeries(t *testing.T) {
+	nan := math.NaN()
+	old := types.MakeMetricData("foo", []float64{1, 2, 3, nan}, 10, 100)
+	fresh := types.MakeMetricData("foo", []float64{4, 5, 6}, 10, 130)
+
+	got := spliceSeries(old, fresh, 110)
+	want := types.MakeMetricData("foo", []float64{2, 3, 4, 5, 6}, 10, 110)
+
+	assert.Equal(t, want.StartTime, got.StartTime)
+	assert.Equal(t, want.StopTime, got.StopTime)
+	assert.Equal(t, want.Values, got.Values)
+	assert.Equal(t, want.IsAbsent, got.IsAbsent)
+
+	// different steps can't be spliced, the fresh series wins
+	coarse := types.MakeMetricData("foo", []float64{4}, 60, 120)
+	assert.Equal(t, coarse, spliceSeries(old, coarse, 110))
+}
+
+func TestPointsAfter(t *testing.T) {
+	nan := math.NaN()
+	r := types.MakeMetricData("foo", []float64{1, 2, 3, nan}, 10, 100)
+
+	got := pointsAfter(r, 110)
+	assert.Equal(t, int32(120), got.StartTime)
+	assert.Equal(t, []float64{3}, got.Values)
+
+	assert.Nil(t, pointsAfter(r, 120), "trailing absent points must not be sent")
+}
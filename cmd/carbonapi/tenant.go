@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/dashboard"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// tenantHandler identifies the tenant of the request and holds one of its
+// request slots while h serves it.
+func tenantHandler(h http.Handler, handler string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.tenancy == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := config.tenancy.Identify(r, requestUser(r))
+		if err != nil {
+			code := http.StatusForbidden
+			if err == tenant.ErrNoTenant {
+				code = http.StatusUnauthorized
+			}
+			rejectTenantRequest(w, r, handler, code, err.Error())
+			return
+		}
+		if id == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		apiMetrics.TenantRequests.Add(id, 1)
+		release, ok := config.tenancy.Acquire(id)
+		if !ok {
+			apiMetrics.TenantRejections.Add(id, 1)
+			rejectTenantRequest(w, r.WithContext(tenant.NewContext(r.Context(), id)), handler, http.StatusTooManyRequests, "too many concurrent requests for tenant")
+			return
+		}
+		defer release()
+
+		h.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), id)))
+	})
+}
+
+func rejectTenantRequest(w http.ResponseWriter, r *http.Request, handler string, code int, reason string) {
+	accessLogDetails := carbonapipb.NewAccessLogDetails(r, handler, &config.API)
+	accessLogDetails.HttpCode = int32(code)
+	accessLogDetails.Reason = reason
+	defer deferredAccessLogging(r, &accessLogDetails, time.Now(), true)
+
+	http.Error(w, http.StatusText(code)+": "+reason, code)
+}
+
+// tenantZipper moves the metric paths of requests into the namespace of
+// their tenant, and those of responses out of it, leaving out anything from
+// other namespaces.
+type tenantZipper struct {
+	CarbonZipper
+	tenancy *tenant.Tenancy
+}
+
+func (z tenantZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	ns := z.tenancy.Namespace(tenant.FromContext(ctx))
+	if ns == "" {
+		return z.CarbonZipper.Find(ctx, metric)
+	}
+
+	resp, err := z.CarbonZipper.Find(ctx, ns.Prefix(metric))
+	resp.Name = metric
+	matches := resp.Matches[:0]
+	for _, m := range resp.Matches {
+		if path, ok := ns.Strip(m.Path); ok {
+			m.Path = path
+			matches = append(matches, m)
+		}
+	}
+	resp.Matches = matches
+
+	return resp, err
+}
+
+func (z tenantZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	ns := z.tenancy.Namespace(tenant.FromContext(ctx))
+	if ns == "" {
+		return z.CarbonZipper.Info(ctx, metric)
+	}
+
+	infos, err := z.CarbonZipper.Info(ctx, ns.Prefix(metric))
+	for server, info := range infos {
+		name, ok := ns.Strip(info.Name)
+		if !ok {
+			delete(infos, server)
+			continue
+		}
+		info.Name = name
+		infos[server] = info
+	}
+
+	return infos, err
+}
+
+func (z tenantZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	ns := z.tenancy.Namespace(tenant.FromContext(ctx))
+	if ns == "" {
+		return z.CarbonZipper.Render(ctx, metrics, from, until)
+	}
+
+	prefixed := make([]string, len(metrics))
+	for i, m := range metrics {
+		prefixed[i] = ns.Prefix(m)
+	}
+
+	data, err := z.CarbonZipper.Render(ctx, prefixed, from, until)
+	result := data[:0]
+	for _, d := range data {
+		if name, ok := ns.Strip(d.Name); ok {
+			d.Name = name
+			result = append(result, d)
+		}
+	}
+	if err == nil && len(result) == 0 {
+		err = errNoMetrics
+	}
+
+	return result, err
+}
+
+// tenantTagPrefix starts the tag the events of a tenant are stored with.
+const tenantTagPrefix = "tenant:"
+
+// tenantEvents keeps the events of each tenant apart, by tagging those it
+// adds with their tenant and finding only those tagged with it. Requests of
+// no tenant see the events of none.
+type tenantEvents struct {
+	events.Store
+}
+
+func (s tenantEvents) Add(ctx context.Context, e events.Event) error {
+	tags := make([]string, 0, len(e.Tags)+1)
+	for _, t := range e.Tags {
+		if !strings.HasPrefix(t, tenantTagPrefix) {
+			tags = append(tags, t)
+		}
+	}
+	if id := tenant.FromContext(ctx); id != "" {
+		tags = append(tags, tenantTagPrefix+id)
+	}
+	e.Tags = tags
+
+	return s.Store.Add(ctx, e)
+}
+
+func (s tenantEvents) Find(ctx context.Context, q events.Query) ([]events.Event, error) {
+	evs, err := s.Store.Find(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	id := tenant.FromContext(ctx)
+	found := evs[:0]
+	for _, e := range evs {
+		owner := ""
+		tags := make([]string, 0, len(e.Tags))
+		for _, t := range e.Tags {
+			if strings.HasPrefix(t, tenantTagPrefix) {
+				owner = t[len(tenantTagPrefix):]
+				continue
+			}
+			tags = append(tags, t)
+		}
+		if owner != id {
+			continue
+		}
+		e.Tags = tags
+		found = append(found, e)
+	}
+
+	return found, nil
+}
+
+// tenantDashboardSeparator separates the tenant from the name of its
+// dashboards in the store.
+const tenantDashboardSeparator = ":"
+
+// tenantDashboards keeps the dashboards of each tenant apart, under names
+// prefixed by their tenant. Requests of no tenant see the dashboards of
+// none. Names can't have the separator, so that none is taken for another.
+type tenantDashboards struct {
+	dashboard.Store
+}
+
+func scopedDashboard(ctx context.Context, name string) (string, error) {
+	if strings.Contains(name, tenantDashboardSeparator) {
+		return "", dashboard.ErrBadName
+	}
+	if id := tenant.FromContext(ctx); id != "" {
+		return id + tenantDashboardSeparator + name, nil
+	}
+
+	return name, nil
+}
+
+func (s tenantDashboards) Save(ctx context.Context, name string, state json.RawMessage) error {
+	name, err := scopedDashboard(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Save(ctx, name, state)
+}
+
+func (s tenantDashboards) Load(ctx context.Context, name string) (json.RawMessage, error) {
+	name, err := scopedDashboard(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Store.Load(ctx, name)
+}
+
+func (s tenantDashboards) Delete(ctx context.Context, name string) error {
+	name, err := scopedDashboard(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Delete(ctx, name)
+}
+
+func (s tenantDashboards) Find(ctx context.Context, query string) ([]string, error) {
+	names, err := s.Store.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if id := tenant.FromContext(ctx); id != "" {
+		prefix = id + tenantDashboardSeparator
+	}
+	terms := strings.Fields(strings.ToLower(query))
+	found := names[:0]
+next:
+	for _, n := range names {
+		if !strings.HasPrefix(n, prefix) {
+			continue
+		}
+		n = n[len(prefix):]
+		if strings.Contains(n, tenantDashboardSeparator) {
+			continue
+		}
+		// The terms may have matched the prefix only.
+		for _, t := range terms {
+			if !strings.Contains(strings.ToLower(n), t) {
+				continue next
+			}
+		}
+		found = append(found, n)
+	}
+
+	return found, nil
+}
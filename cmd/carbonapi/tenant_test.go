@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/acl"
+	"github.com/bookingcom/carbonapi/pkg/dashboard"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/signature"
+	"github.com/bookingcom/carbonapi/pkg/tenant"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namespacedZipper answers every request with a path in its namespace and
+// one outside of it.
+type namespacedZipper struct {
+	requested []string
+}
+
+func (z *namespacedZipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
+	z.requested = append(z.requested, metric)
+	return pb.GlobResponse{
+		Name: metric,
+		Matches: []pb.GlobMatch{
+			{Path: "teams.a.foo.bar", IsLeaf: true},
+			{Path: "teams.b.foo.bar", IsLeaf: true},
+		},
+	}, nil
+}
+
+func (z *namespacedZipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error) {
+	z.requested = append(z.requested, metric)
+	return map[string]pb.InfoResponse{
+		"a": {Name: "teams.a.foo.bar"},
+		"b": {Name: "teams.b.foo.bar"},
+	}, nil
+}
+
+func (z *namespacedZipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
+	z.requested = append(z.requested, metrics...)
+	return []*types.MetricData{
+		types.MakeMetricData("teams.a.foo.bar", []float64{1, 2}, 60, from),
+		types.MakeMetricData("teams.b.foo.bar", []float64{3, 4}, 60, from),
+	}, nil
+}
+
+func TestTenantZipper(t *testing.T) {
+	tenancy, err := tenant.New(tenant.Config{
+		Header:  "X-Tenant",
+		Tenants: map[string]tenant.Tenant{"team-a": {Namespace: "teams.a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &namespacedZipper{}
+	z := tenantZipper{CarbonZipper: backend, tenancy: tenancy}
+	ctx := tenant.NewContext(context.Background(), "team-a")
+
+	glob, err := z.Find(ctx, "foo.*")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo.*", glob.Name)
+	assert.Equal(t, []pb.GlobMatch{{Path: "foo.bar", IsLeaf: true}}, glob.Matches)
+
+	infos, err := z.Info(ctx, "foo.bar")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]pb.InfoResponse{"a": {Name: "foo.bar"}}, infos)
+
+	data, err := z.Render(ctx, []string{"foo.bar"}, 0, 120)
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, "foo.bar", data[0].Name)
+	}
+
+	assert.Equal(t, []string{"teams.a.foo.*", "teams.a.foo.bar", "teams.a.foo.bar"}, backend.requested)
+
+	backend.requested = nil
+	_, err = z.Find(context.Background(), "foo.*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo.*"}, backend.requested, "requests without a tenant are passed as they are")
+}
+
+func TestTenantEvents(t *testing.T) {
+	s := tenantEvents{Store: events.NewRing(10)}
+	a := tenant.NewContext(context.Background(), "team-a")
+	b := tenant.NewContext(context.Background(), "team-b")
+	shared := context.Background()
+
+	assert.NoError(t, s.Add(a, events.Event{When: 1, What: "deploy a", Tags: []string{"deploy"}}))
+	// Tags naming a tenant are the store's, not the user's.
+	assert.NoError(t, s.Add(b, events.Event{When: 2, What: "deploy b", Tags: []string{"deploy", "tenant:team-a"}}))
+	assert.NoError(t, s.Add(shared, events.Event{When: 3, What: "deploy", Tags: []string{"deploy"}}))
+
+	for ctx, what := range map[context.Context]string{a: "deploy a", b: "deploy b", shared: "deploy"} {
+		evs, err := s.Find(ctx, events.Query{From: 0, Until: 10, Tags: []string{"deploy"}})
+		assert.NoError(t, err)
+		if assert.Len(t, evs, 1) {
+			assert.Equal(t, what, evs[0].What)
+			assert.Equal(t, []string{"deploy"}, evs[0].Tags)
+		}
+	}
+}
+
+func TestTenantDashboards(t *testing.T) {
+	s := tenantDashboards{Store: dashboard.NewMemory()}
+	a := tenant.NewContext(context.Background(), "team-a")
+	b := tenant.NewContext(context.Background(), "team-b")
+	shared := context.Background()
+
+	assert.NoError(t, s.Save(a, "ops", json.RawMessage(`{"graphs":"a"}`)))
+	assert.NoError(t, s.Save(shared, "ops", json.RawMessage(`{"graphs":"shared"}`)))
+	assert.Equal(t, dashboard.ErrBadName, s.Save(shared, "team-a:ops", json.RawMessage(`{}`)))
+
+	state, err := s.Load(a, "ops")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"graphs":"a"}`, string(state))
+	state, err = s.Load(shared, "ops")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"graphs":"shared"}`, string(state))
+	_, err = s.Load(b, "ops")
+	assert.Equal(t, dashboard.ErrNotFound, err)
+	assert.Equal(t, dashboard.ErrNotFound, s.Delete(b, "ops"))
+
+	names, err := s.Find(a, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ops"}, names)
+	names, err = s.Find(a, "team")
+	assert.NoError(t, err)
+	assert.Empty(t, names, "terms don't match the tenant")
+	names, err = s.Find(b, "")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestTenantHandler(t *testing.T) {
+	tenancy, err := tenant.New(tenant.Config{
+		Header:   "X-Tenant",
+		Required: true,
+		Tenants:  map[string]tenant.Tenant{"team-a": {Namespace: "teams.a", MaxConcurrentRequests: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig *tenant.Tenancy) { config.tenancy = orig }(config.tenancy)
+	config.tenancy = tenancy
+
+	var served string
+	var inner http.Handler
+	h := tenantHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = tenant.FromContext(r.Context())
+		if inner != nil {
+			inner.ServeHTTP(w, r)
+		}
+	}), "render")
+
+	req, rr := setUpRequest(t, "/render/?target=foo.bar")
+	h(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar")
+	req.Header.Set("X-Tenant", "team-z")
+	h(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar")
+	req.Header.Set("X-Tenant", "team-a")
+	h(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "team-a", served)
+
+	// a request of the tenant arriving while another is served is over quota
+	var nested int
+	inner = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = nil
+		req, rr := setUpRequest(t, "/render/?target=foo.bar")
+		req.Header.Set("X-Tenant", "team-a")
+		h(rr, req)
+		nested = rr.Code
+	})
+	req, rr = setUpRequest(t, "/render/?target=foo.bar")
+	req.Header.Set("X-Tenant", "team-a")
+	h(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusTooManyRequests, nested)
+}
+
+func TestTenantHandlerFromUser(t *testing.T) {
+	tenancy, err := tenant.New(tenant.Config{
+		FromUser: true,
+		Required: true,
+		Tenants:  map[string]tenant.Tenant{"team-a": {Namespace: "teams.a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig *tenant.Tenancy) { config.tenancy = orig }(config.tenancy)
+	config.tenancy = tenancy
+
+	var served string
+	h := tenantHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = tenant.FromContext(r.Context())
+	}), "render")
+
+	// basic auth usernames are not verified
+	req, rr := setUpRequest(t, "/render/?target=foo.bar")
+	req.SetBasicAuth("team-a", "anything")
+	h(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req, rr = setUpRequest(t, "/render/?target=foo.bar")
+	h(rr, req.WithContext(signature.NewContext(req.Context(), "team-a")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "team-a", served)
+}
+
+func TestScopedCacheKey(t *testing.T) {
+	assert.Equal(t, "target=a", scopedCacheKey(context.Background(), "target=a"))
+	assert.NotEqual(t,
+		scopedCacheKey(tenant.NewContext(context.Background(), "team-a"), "target=a"),
+		scopedCacheKey(tenant.NewContext(context.Background(), "team-b"), "target=a"))
+}
+
+func FuzzScopedGlobCacheKey(f *testing.F) {
+	f.Add("team-a", "", "foo.*", "", "", "team-a\x00foo.*")
+	f.Add("", "a,b", "foo.*", "", "a\nb", "foo.*")
+	f.Add("", "a", "foo bar.*", "", "a", "foo+bar.*")
+	f.Add("t", "", "x", "", "", "query=x")
+	f.Add("d", "", "0", "", "d", "0")
+
+	scoped := func(id, roles, glob string) string {
+		ctx := tenant.NewContext(context.Background(), id)
+		if roles != "" {
+			ctx = acl.NewContext(ctx, acl.Principal{Roles: strings.Split(roles, "\n")})
+		}
+		return scopedCacheKey(ctx, globCacheKey(glob))
+	}
+
+	f.Fuzz(func(t *testing.T, id1, roles1, glob1, id2, roles2, glob2 string) {
+		if id1 == id2 && roles1 == roles2 && glob1 == glob2 {
+			return
+		}
+		if k := scoped(id1, roles1, glob1); k == scoped(id2, roles2, glob2) {
+			t.Fatalf("(%q, %q, %q) and (%q, %q, %q) share the key %q", id1, roles1, glob1, id2, roles2, glob2, k)
+		}
+	})
+}
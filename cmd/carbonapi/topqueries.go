@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// heavyHitters are the targets and metric prefixes that recently cost the
+// backends the most time and bytes, nil unless topQueries is configured.
+var heavyHitters *topQueries
+
+type topQueries struct {
+	prefixDepth int
+
+	targetSeconds *topk.Rolling
+	targetBytes   *topk.Rolling
+	prefixSeconds *topk.Rolling
+	prefixBytes   *topk.Rolling
+}
+
+func newTopQueries(size int, window time.Duration, prefixDepth int) *topQueries {
+	return &topQueries{
+		prefixDepth:   prefixDepth,
+		targetSeconds: topk.NewRolling(size, window),
+		targetBytes:   topk.NewRolling(size, window),
+		prefixSeconds: topk.NewRolling(size, window),
+		prefixBytes:   topk.NewRolling(size, window),
+	}
+}
+
+// addTargets splits the cost of fetching the series of targets between
+// them.
+func (t *topQueries) addTargets(targets []string, took time.Duration, bytes int) {
+	if t == nil || len(targets) == 0 {
+		return
+	}
+
+	n := float64(len(targets))
+	for _, target := range targets {
+		t.targetSeconds.Add(target, took.Seconds()/n)
+		t.targetBytes.Add(target, float64(bytes)/n)
+	}
+}
+
+// addFetch splits the time a fetch of paths took between their prefixes.
+func (t *topQueries) addFetch(paths []string, took time.Duration) {
+	if t == nil || len(paths) == 0 {
+		return
+	}
+
+	n := float64(len(paths))
+	for _, p := range paths {
+		t.prefixSeconds.Add(t.prefix(p), took.Seconds()/n)
+	}
+}
+
+// addSeries counts the bytes of a fetched series to its prefix.
+func (t *topQueries) addSeries(name string, bytes int) {
+	if t == nil {
+		return
+	}
+
+	t.prefixBytes.Add(t.prefix(name), float64(bytes))
+}
+
+func (t *topQueries) prefix(path string) string {
+	parts := strings.SplitN(path, ".", t.prefixDepth+1)
+	if len(parts) > t.prefixDepth {
+		parts = parts[:t.prefixDepth]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+type topQueriesReport struct {
+	Targets  topQueriesCosts `json:"targets"`
+	Prefixes topQueriesCosts `json:"prefixes"`
+}
+
+type topQueriesCosts struct {
+	Seconds []topk.Item `json:"seconds"`
+	Bytes   []topk.Item `json:"bytes"`
+}
+
+func (t *topQueries) report() topQueriesReport {
+	return topQueriesReport{
+		Targets:  topQueriesCosts{Seconds: t.targetSeconds.Top(), Bytes: t.targetBytes.Top()},
+		Prefixes: topQueriesCosts{Seconds: t.prefixSeconds.Top(), Bytes: t.prefixBytes.Top()},
+	}
+}
+
+// topQueriesHandler serves the heavy hitters of the recent windows.
+func topQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	if heavyHitters == nil {
+		http.Error(w, "topQueries is not configured", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(heavyHitters.report())
+}
+
+var topQueriesDesc = prometheus.NewDesc(
+	"top_query_cost",
+	"Backend cost of the heaviest targets and metric prefixes of the recent windows, partitioned by kind (target or prefix), resource (seconds or bytes) and key",
+	[]string{"kind", "resource", "key"}, nil,
+)
+
+// Describe and Collect export the heavy hitters as Prometheus gauges, as
+// many per kind and resource as topQueries.size.
+func (t *topQueries) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topQueriesDesc
+}
+
+func (t *topQueries) Collect(ch chan<- prometheus.Metric) {
+	report := t.report()
+	for kind, costs := range map[string]topQueriesCosts{"target": report.Targets, "prefix": report.Prefixes} {
+		for resource, items := range map[string][]topk.Item{"seconds": costs.Seconds, "bytes": costs.Bytes} {
+			for _, it := range items {
+				ch <- prometheus.MustNewConstMetric(topQueriesDesc, prometheus.GaugeValue, it.Count, kind, resource, it.Key)
+			}
+		}
+	}
+}
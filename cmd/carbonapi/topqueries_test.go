@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTopQueries(t *testing.T) {
+	defer func(h *topQueries) { heavyHitters = h }(heavyHitters)
+	heavyHitters = newTopQueries(5, time.Minute, 1)
+
+	req, rr := setUpRequest(t, "/render/?target=sumSeries(foo.bar)&from=-10minutes&format=json&noCache=1")
+	renderHandler(rr, req)
+
+	w := httptest.NewRecorder()
+	topQueriesHandler(w, httptest.NewRequest("GET", "/debug/topqueries", nil))
+	var report topQueriesReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if ts := report.Targets.Bytes; len(ts) != 1 || ts[0].Key != "sumSeries(foo.bar)" || ts[0].Count <= 0 {
+		t.Errorf("Expected the bytes of the target, got %+v", ts)
+	}
+	if ts := report.Targets.Seconds; len(ts) != 1 || ts[0].Key != "sumSeries(foo.bar)" {
+		t.Errorf("Expected the time of the target, got %+v", ts)
+	}
+	if ps := report.Prefixes.Bytes; len(ps) != 1 || ps[0].Key != "foo" {
+		t.Errorf("Expected the bytes of the prefix, got %+v", ps)
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	heavyHitters.Collect(ch)
+	if len(ch) != 4 {
+		t.Errorf("Expected a gauge per kind and resource, got %d", len(ch))
+	}
+}
+
+func TestTopQueriesPrefix(t *testing.T) {
+	tq := newTopQueries(1, time.Minute, 2)
+	for path, exp := range map[string]string{"a": "a", "a.b": "a.b", "a.b.c.d": "a.b"} {
+		if got := tq.prefix(path); got != exp {
+			t.Errorf("prefix(%q) = %q, want %q", path, got, exp)
+		}
+	}
+}
@@ -27,7 +27,7 @@ type zipper struct {
 type CarbonZipper interface {
 	Find(ctx context.Context, metric string) (pb.GlobResponse, error)
 	Info(ctx context.Context, metric string) (map[string]pb.InfoResponse, error)
-	Render(ctx context.Context, metric string, from, until int32) ([]*types.MetricData, error)
+	Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error)
 }
 
 func newZipper(sender func(*realZipper.Stats), config cfg.Zipper, logger *zap.Logger) *zipper {
@@ -40,6 +40,11 @@ func newZipper(sender func(*realZipper.Stats), config cfg.Zipper, logger *zap.Lo
 	return z
 }
 
+// Close stops the zipper for the process to exit.
+func (z zipper) Close() {
+	z.z.Close()
+}
+
 func (z zipper) Find(ctx context.Context, metric string) (pb.GlobResponse, error) {
 	var pbresp pb.GlobResponse
 	res, stats, err := z.z.Find(ctx, z.logger, metric)
@@ -66,9 +71,9 @@ func (z zipper) Info(ctx context.Context, metric string) (map[string]pb.InfoResp
 	return resp, nil
 }
 
-func (z zipper) Render(ctx context.Context, metric string, from, until int32) ([]*types.MetricData, error) {
+func (z zipper) Render(ctx context.Context, metrics []string, from, until int32) ([]*types.MetricData, error) {
 	var result []*types.MetricData
-	pbresp, stats, err := z.z.Render(ctx, z.logger, metric, from, until)
+	pbresp, stats, err := z.z.Render(ctx, z.logger, metrics, from, until)
 	if err != nil {
 		return result, err
 	}
@@ -80,7 +85,12 @@ func (z zipper) Render(ctx context.Context, metric string, from, until int32) ([
 	}
 
 	for i := range pbresp.Metrics {
-		result = append(result, &types.MetricData{FetchResponse: pbresp.Metrics[i]})
+		meta := stats.Series[pbresp.Metrics[i].Name]
+		result = append(result, &types.MetricData{
+			FetchResponse: pbresp.Metrics[i],
+			Backends:      meta.Backends,
+			HealedPoints:  meta.Healed,
+		})
 	}
 
 	return result, nil
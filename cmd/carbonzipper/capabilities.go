@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/filter"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// zipperFormats are the formats the zipper serves, richest first.
+var zipperFormats = []string{
+	types.FormatCarbonAPIv3,
+	types.FormatCarbonAPIv2,
+	types.FormatJSON,
+	types.FormatPickle,
+}
+
+// capabilitiesHandler announces what the zipper supports, so that zippers
+// in front of it pick the protocol to speak to it in.
+func capabilitiesHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "capabilities"),
+		zap.String("carbonapi_uuid", util.GetUUID(req.Context())),
+	)
+
+	blob, err := json.Marshal(types.Capabilities{
+		Name:    config.ZipperID,
+		Version: BuildVersion,
+		Formats: zipperFormats,
+		// the zipper applies those of render requests as it merges
+		FilterFunctions: append(append([]string(nil), filter.Names...), aggregate.Names...),
+	})
+	if err != nil {
+		http.Error(w, "error marshaling data", http.StatusInternalServerError)
+		accessLogger.Error("request failed",
+			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues("500", "capabilities").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(blob)
+
+	accessLogger.Info("request served",
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", "capabilities").Inc()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	defer func(id string) { config.ZipperID = id }(config.ZipperID)
+	config.ZipperID = "zipper-eu:8080"
+
+	rr := httptest.NewRecorder()
+	capabilitiesHandler(rr, httptest.NewRequest("GET", "/capabilities/?format=json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("expected content type %s, got %s", contentTypeJSON, ct)
+	}
+
+	var caps types.Capabilities
+	if err := json.Unmarshal(rr.Body.Bytes(), &caps); err != nil {
+		t.Fatal(err)
+	}
+	if caps.Name != "zipper-eu:8080" || len(caps.Formats) == 0 || caps.Formats[0] != types.FormatCarbonAPIv3 {
+		t.Errorf("unexpected capabilities %+v", caps)
+	}
+}
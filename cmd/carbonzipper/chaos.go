@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/bookingcom/carbonapi/cfg"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+
+	"go.uber.org/zap"
+)
+
+// chaosFor returns the faults configured for the backend at host, nil if
+// there are none.
+func chaosFor(host string, logger *zap.Logger) *bnet.Chaos {
+	c, ok := config.Chaos[host]
+	if !ok {
+		c, ok = config.Chaos["*"]
+	}
+	if !ok || c == (cfg.Chaos{}) {
+		return nil
+	}
+
+	logger.Warn("Injecting faults into the requests to the backend, never do this in production",
+		zap.String("host", host),
+		zap.Duration("latency", c.Latency),
+		zap.Float64("latency_probability", c.LatencyProbability),
+		zap.Float64("error_probability", c.ErrorProbability),
+		zap.Float64("truncate_probability", c.TruncateProbability),
+		zap.Float64("content_type_probability", c.ContentTypeProbability),
+	)
+
+	return &bnet.Chaos{
+		Latency:                c.Latency,
+		LatencyProbability:     c.LatencyProbability,
+		ErrorProbability:       c.ErrorProbability,
+		TruncateProbability:    c.TruncateProbability,
+		ContentTypeProbability: c.ContentTypeProbability,
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/bookingcom/carbonapi/pkg/backend"
+)
+
+// backendErrorCode is the status of a response failed with the backend
+// error err. It counts the error by its kind.
+func backendErrorCode(err error) int {
+	kind := backend.KindOf(err)
+	Metrics.BackendErrors.Add(kind.String(), 1)
+
+	return kind.Code()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+)
+
+// withFanOut schedules the backend requests for paths made with ctx as
+// configured. With early stops, the backends that answered with data for
+// the same paths before are kept in the path cache, and once they answered
+// again the requests to the others are cancelled. Only requests for a
+// single path whose holders aren't known are remembered, so the holders are
+// learned again once they expire. Nil paths never stop early.
+//
+// Load-aware fan-outs learn the holders of paths alike. The holders of a
+// single metric all have it, so only the least loaded of them is asked.
+//
+// With failover, the requests that fail are sent again to the alternates
+// withAlternates gives the fan-out, and counted by the backend that failed.
+// Failover learns the holders of paths too, as its alternates are taken
+// from them.
+func withFanOut(ctx context.Context, paths []string) context.Context {
+	f := backend.FanOut{
+		Stagger:        config.FanOut.Stagger,
+		Jitter:         config.FanOut.Jitter,
+		OrderByLatency: config.FanOut.OrderByLatency,
+		Weights:        backendWeights,
+	}
+
+	if (config.FanOut.EarlyStop || config.FanOut.LoadAware) && len(paths) > 0 {
+		f.Holders = pathHolders(paths)
+		f.Replicas = config.FanOut.LoadAware && len(paths) == 1 && !strings.ContainsAny(paths[0], "*?[]{}")
+	}
+
+	learn := config.FanOut.EarlyStop || config.FanOut.LoadAware || config.FanOut.Failover
+	if learn && len(paths) == 1 && pathHosts(paths) == nil {
+		var hosts []string
+		f.Seen = func(b backend.Backend) {
+			hosts = append(hosts, backendHosts[b])
+			config.PathCache.Set(paths[0], append([]string(nil), hosts...))
+		}
+	}
+
+	if q := quorumFrom(ctx); q != nil {
+		f.Answered = q.answer
+	}
+
+	if config.FanOut.Failover {
+		f.FailoverJitter = config.FanOut.FailoverJitter
+		f.FailedOver = func(failed backend.Backend, _ backend.Backend) {
+			Metrics.Failovers.Add(backendHosts[failed], 1)
+		}
+	}
+
+	return backend.WithFanOut(ctx, f)
+}
+
+// pathHolders returns the backends the path cache knows to hold any of
+// paths, or nil if it doesn't know of all of them.
+func pathHolders(paths []string) []backend.Backend {
+	hosts := pathHosts(paths)
+	if hosts == nil {
+		return nil
+	}
+
+	holders := make([]backend.Backend, 0, len(hosts))
+	for _, b := range backends {
+		if hosts[backendHosts[b]] {
+			holders = append(holders, b)
+		}
+	}
+
+	return holders
+}
+
+// pathHosts returns the hosts the path cache knows to hold any of paths, or
+// nil if it doesn't know of all of them.
+func pathHosts(paths []string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, p := range paths {
+		hs, ok := config.PathCache.Get(p)
+		if !ok || len(hs) == 0 {
+			return nil
+		}
+		for _, h := range hs {
+			hosts[h] = true
+		}
+	}
+
+	return hosts
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestWithFanOutEarlyStop(t *testing.T) {
+	holder := mock.New(mock.Config{
+		Find: func(context.Context, string) (types.Matches, error) {
+			return types.Matches{Name: "fanout.*", Matches: []types.Match{{Path: "fanout.foo", IsLeaf: true}}}, nil
+		},
+	})
+	blocked := mock.New(mock.Config{
+		Find: func(ctx context.Context, _ string) (types.Matches, error) {
+			<-ctx.Done()
+			return types.Matches{}, ctx.Err()
+		},
+	})
+	bs := []backend.Backend{&blocked, &holder}
+
+	defer func(bs []backend.Backend, hosts map[backend.Backend]string, earlyStop bool) {
+		backends, backendHosts, config.FanOut.EarlyStop = bs, hosts, earlyStop
+	}(backends, backendHosts, config.FanOut.EarlyStop)
+	backends = bs
+	backendHosts = map[backend.Backend]string{&blocked: "blocked:8080", &holder: "holder:8080"}
+	config.FanOut.EarlyStop = true
+
+	// Nothing is known of the path yet, so all backends are waited for.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := findsByRegion(withFanOut(ctx, []string{"fanout.*"}), [][]backend.Backend{bs}, "fanout.*"); err != nil {
+		t.Fatal(err)
+	}
+	if hosts, ok := config.PathCache.Get("fanout.*"); !ok || len(hosts) != 1 || hosts[0] != "holder:8080" {
+		t.Fatalf("expected the holder to be cached, got %v", hosts)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t0 := time.Now()
+	m, err := findsByRegion(withFanOut(ctx, []string{"fanout.*"}), [][]backend.Backend{bs}, "fanout.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Matches) != 1 {
+		t.Errorf("expected the match of the holder, got %v", m.Matches)
+	}
+	if d := time.Since(t0); d > time.Second {
+		t.Errorf("expected the find to stop once the holder answered, took %v", d)
+	}
+}
+
+func TestWithFanOutLoadAware(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	replica := func(name string) backend.Backend {
+		b := mock.New(mock.Config{
+			Render: func(context.Context, int32, int32, []string) ([]types.Metric, error) {
+				mu.Lock()
+				calls[name]++
+				mu.Unlock()
+				return []types.Metric{{Name: "loadaware.foo", Values: []float64{1}, IsAbsent: []bool{false}}}, nil
+			},
+		})
+		return &b
+	}
+	a, b := replica("a"), replica("b")
+	bs := []backend.Backend{a, b}
+
+	defer func(bs []backend.Backend, hosts map[backend.Backend]string, weights map[backend.Backend]float64, loadAware bool) {
+		backends, backendHosts, backendWeights, config.FanOut.LoadAware = bs, hosts, weights, loadAware
+	}(backends, backendHosts, backendWeights, config.FanOut.LoadAware)
+	backends = bs
+	backendHosts = map[backend.Backend]string{a: "a:8080", b: "b:8080"}
+	backendWeights = map[backend.Backend]float64{b: 1000}
+	config.FanOut.LoadAware = true
+
+	targets := []string{"loadaware.foo"}
+	for i := 0; i < 3; i++ {
+		if _, err := rendersByRegion(withFanOut(context.Background(), targets), [][]backend.Backend{bs}, 0, 60, targets); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first render learns that both replicas have the metric, the
+	// others only go to the far heavier weighted one.
+	if calls["a"] != 1 || calls["b"] != 3 {
+		t.Errorf("expected a once and b thrice, got %v", calls)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// expansionError refuses globs whose brace alternatives expand into more
+// queries than allowed.
+type expansionError struct {
+	glob string
+	max  int
+}
+
+func (e *expansionError) Error() string {
+	return fmt.Sprintf("%s expands into more than %d queries, narrow down its {} alternatives", e.glob, e.max)
+}
+
+// expandBraces returns the globs the brace alternatives of glob expand to,
+// e.g. a.{b,c}.{d,e} to a.b.d, a.b.e, a.c.d and a.c.e. Braces may nest, and
+// unbalanced ones are left to the backends. It fails with an
+// *expansionError past max globs.
+func expandBraces(glob string, max int) ([]string, error) {
+	open := strings.IndexByte(glob, '{')
+	if open < 0 {
+		return []string{glob}, nil
+	}
+
+	depth := 0
+	alternatives := make([]string, 0)
+	start := open + 1
+	for i := open; i < len(glob); i++ {
+		switch glob[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, glob[start:i])
+				start = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			alternatives = append(alternatives, glob[start:i])
+
+			prefix, suffix := glob[:open], glob[i+1:]
+			var globs []string
+			seen := make(map[string]struct{})
+			for _, alt := range alternatives {
+				expanded, err := expandBraces(prefix+alt+suffix, max-len(globs))
+				if err != nil {
+					return nil, &expansionError{glob: glob, max: max}
+				}
+				for _, g := range expanded {
+					if _, ok := seen[g]; !ok {
+						seen[g] = struct{}{}
+						globs = append(globs, g)
+					}
+				}
+				if len(globs) > max {
+					return nil, &expansionError{glob: glob, max: max}
+				}
+			}
+			return globs, nil
+		}
+	}
+
+	return []string{glob}, nil
+}
+
+// findExpanded finds glob in tiers as findsByRegion does, with its brace
+// alternatives expanded into queries sent globExpansion.parallelism at a
+// time, when globExpansion is enabled. The find fails if any query does.
+func findExpanded(ctx context.Context, tiers [][]backend.Backend, glob string) (types.Matches, error) {
+	if !config.GlobExpansion.Enabled {
+		return findsByRegion(withFanOut(ctx, []string{glob}), tiers, glob)
+	}
+
+	globs, err := expandBraces(glob, config.GlobExpansion.MaxQueries)
+	if err != nil {
+		return types.Matches{}, err
+	}
+	if len(globs) == 1 {
+		return findsByRegion(withFanOut(ctx, globs), tiers, globs[0])
+	}
+	Metrics.ExpandedFinds.Add(int64(len(globs)))
+
+	parallelism := config.GlobExpansion.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+
+	matches := make([]types.Matches, len(globs))
+	errs := make([]error, len(globs))
+	var wg sync.WaitGroup
+	for i, g := range globs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, g string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			matches[i], errs[i] = findsByRegion(withFanOut(ctx, []string{g}), tiers, g)
+		}(i, g)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return types.Matches{}, err
+		}
+	}
+
+	merged := types.MergeMatches(matches)
+	merged.Name = glob
+
+	return merged, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		glob string
+		want []string
+		err  bool
+	}{
+		{glob: "a.b.*", want: []string{"a.b.*"}},
+		{glob: "a.{b,c}.d", want: []string{"a.b.d", "a.c.d"}},
+		{glob: "a.{b,c}.{d,e}", want: []string{"a.b.d", "a.b.e", "a.c.d", "a.c.e"}},
+		{glob: "a.{b,{c,d}x}", want: []string{"a.b", "a.cx", "a.dx"}},
+		{glob: "a.{b,b}", want: []string{"a.b"}},
+		{glob: "a.{b,}", want: []string{"a.b", "a."}},
+		{glob: "a.{b,c", want: []string{"a.{b,c"}},
+		{glob: "{a,b}.{c,d}.{e,f}", err: true},
+		{glob: "{a,b,c,d,e,f}", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := expandBraces(tt.glob, 5)
+		if tt.err {
+			if _, ok := err.(*expansionError); !ok {
+				t.Errorf("%s: expected an expansion error, got %v, %v", tt.glob, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.glob, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.glob, tt.want, got)
+		}
+	}
+}
+
+func TestFindExpanded(t *testing.T) {
+	defer func(c cfg.GlobExpansionConfig) { config.GlobExpansion = c }(config.GlobExpansion)
+	config.GlobExpansion = cfg.GlobExpansionConfig{Enabled: true, MaxQueries: 10, Parallelism: 2}
+
+	var mu sync.Mutex
+	var queries []string
+	b := mock.New(mock.Config{
+		Find: func(_ context.Context, query string) (types.Matches, error) {
+			mu.Lock()
+			queries = append(queries, query)
+			mu.Unlock()
+			if query == "a.fail" {
+				return types.Matches{}, errors.New("no")
+			}
+			return types.Matches{Name: query, Matches: []types.Match{{Path: query + ".x", IsLeaf: true}}}, nil
+		},
+	})
+	tiers := [][]backend.Backend{{&b}}
+
+	m, err := findExpanded(context.Background(), tiers, "a.{b,c,d}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "a.{b,c,d}" {
+		t.Errorf("expected the matches named after the glob, got %s", m.Name)
+	}
+	var paths []string
+	for _, match := range m.Matches {
+		paths = append(paths, match.Path)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, []string{"a.b.x", "a.c.x", "a.d.x"}) {
+		t.Errorf("unexpected matches %v", paths)
+	}
+	sort.Strings(queries)
+	if !reflect.DeepEqual(queries, []string{"a.b", "a.c", "a.d"}) {
+		t.Errorf("expected a query per alternative, got %v", queries)
+	}
+
+	if _, err := findExpanded(context.Background(), tiers, "a.{b,fail}"); err == nil {
+		t.Error("expected the find to fail with one of its queries")
+	}
+	if _, err := findExpanded(context.Background(), tiers, "{a,b,c,d}.{e,f,g}"); err == nil {
+		t.Error("expected too many queries to be refused")
+	}
+
+	config.GlobExpansion.Enabled = false
+	queries = nil
+	if _, err := findExpanded(context.Background(), tiers, "a.{b,c,d}"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(queries, []string{"a.{b,c,d}"}) {
+		t.Errorf("expected the glob as is when disabled, got %v", queries)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	clusterParam         = "cluster"
+	excludeClusterParam  = "excludeCluster"
+	clusterHeader        = "X-Carbonzipper-Cluster"
+	excludeClusterHeader = "X-Carbonzipper-Exclude-Cluster"
+)
+
+var errUnknownGroup = errors.New("unknown backend group")
+
+// selectBackends returns the backends a request should be sent to. Clients
+// pin requests to backend groups with the cluster parameter or header and
+// skip groups with excludeCluster. Both take comma separated group names.
+func selectBackends(req *http.Request, all []backend.Backend, groups map[string][]backend.Backend) ([]backend.Backend, error) {
+	include := requestGroups(req, clusterParam, clusterHeader)
+	exclude := requestGroups(req, excludeClusterParam, excludeClusterHeader)
+
+	if len(include) == 0 && len(exclude) == 0 {
+		return all, nil
+	}
+
+	for _, name := range append(include, exclude...) {
+		if _, ok := groups[name]; !ok {
+			return nil, errors.Wrapf(errUnknownGroup, "'%s'", name)
+		}
+	}
+
+	candidates := all
+	if len(include) > 0 {
+		candidates = make([]backend.Backend, 0)
+		seen := make(map[backend.Backend]struct{})
+		for _, name := range include {
+			for _, b := range groups[name] {
+				if _, ok := seen[b]; !ok {
+					seen[b] = struct{}{}
+					candidates = append(candidates, b)
+				}
+			}
+		}
+	}
+
+	excluded := make(map[backend.Backend]struct{})
+	for _, name := range exclude {
+		for _, b := range groups[name] {
+			excluded[b] = struct{}{}
+		}
+	}
+
+	selected := make([]backend.Backend, 0, len(candidates))
+	for _, b := range candidates {
+		if _, ok := excluded[b]; !ok {
+			selected = append(selected, b)
+		}
+	}
+
+	return selected, nil
+}
+
+func requestGroups(req *http.Request, param string, header string) []string {
+	values := append(append([]string(nil), req.Form[param]...), req.Header[header]...)
+
+	var names []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+
+	"github.com/pkg/errors"
+)
+
+func TestSelectBackends(t *testing.T) {
+	newBackend := func() backend.Backend {
+		b := mock.New(mock.Config{})
+		return &b
+	}
+
+	a, b, c := newBackend(), newBackend(), newBackend()
+	all := []backend.Backend{a, b, c}
+	groups := map[string][]backend.Backend{
+		"eu": {a, b},
+		"us": {b, c},
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		want   []backend.Backend
+		err    error
+	}{
+		{name: "no selection", url: "/render/", want: all},
+		{name: "pin", url: "/render/?cluster=eu", want: []backend.Backend{a, b}},
+		{name: "pin several", url: "/render/?cluster=eu,us", want: []backend.Backend{a, b, c}},
+		{name: "exclude", url: "/render/?excludeCluster=eu", want: []backend.Backend{c}},
+		{name: "pin and exclude", url: "/render/?cluster=us&excludeCluster=eu", want: []backend.Backend{c}},
+		{name: "header", url: "/render/", header: http.Header{clusterHeader: {"us"}}, want: []backend.Backend{b, c}},
+		{name: "unknown", url: "/render/?cluster=ap", err: errUnknownGroup},
+		{name: "unknown exclude", url: "/render/?excludeCluster=ap", err: errUnknownGroup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			req.ParseForm()
+
+			got, err := selectBackends(req, all, groups)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d backends, got %d", len(tt.want), len(got))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("unexpected backend at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestGroupsKeepsForm(t *testing.T) {
+	req, err := http.NewRequest("GET", "/render/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := make([]string, 1, 2)
+	form[0] = "eu"
+	req.Form = url.Values{clusterParam: form}
+	req.Header.Set(clusterHeader, "us")
+
+	if got := requestGroups(req, clusterParam, clusterHeader); len(got) != 2 {
+		t.Fatalf("expected 2 groups, got %v", got)
+	}
+	if spare := form[:2][1]; spare != "" {
+		t.Errorf("expected the form to be left alone, got %q appended to it", spare)
+	}
+}
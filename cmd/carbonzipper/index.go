@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/index"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+var metricIndex = struct {
+	sync.RWMutex
+	idx *index.Index
+}{}
+
+func currentIndex() *index.Index {
+	metricIndex.RLock()
+	defer metricIndex.RUnlock()
+
+	return metricIndex.idx
+}
+
+// findInIndex answers a find request from the metric index. It reports false
+// if the index is disabled or stale, or if the request is pinned to backend
+// groups, which the index doesn't know about.
+func findInIndex(req *http.Request, query string) (types.Matches, bool) {
+	if !config.MetricIndex.Enabled {
+		return types.Matches{}, false
+	}
+
+	if len(requestGroups(req, clusterParam, clusterHeader)) > 0 || len(requestGroups(req, excludeClusterParam, excludeClusterHeader)) > 0 {
+		return types.Matches{}, false
+	}
+
+	idx := currentIndex()
+	if idx == nil || idx.Age() > config.MetricIndex.MaxAge {
+		Metrics.IndexMisses.Add(1)
+		return types.Matches{}, false
+	}
+
+	matches, err := idx.Find(query)
+	if err != nil {
+		Metrics.IndexMisses.Add(1)
+		return types.Matches{}, false
+	}
+
+	Metrics.IndexHits.Add(1)
+	return matches, true
+}
+
+// refreshIndex rebuilds the metric index from the metric lists of all
+// backends. The old index is kept if any backend fails, as a partial index
+// would hide metrics.
+func refreshIndex(ctx context.Context, bs []backend.Backend) error {
+	type result struct {
+		names []string
+		err   error
+	}
+
+	results := make(chan result, len(bs))
+	listers := 0
+	for _, b := range bs {
+		l, ok := b.(backend.Lister)
+		if !ok {
+			continue
+		}
+		listers++
+
+		go func(l backend.Lister) {
+			names, err := l.List(ctx)
+			results <- result{names, err}
+		}(l)
+	}
+
+	if listers == 0 {
+		return errors.New("no backend supports listing metrics")
+	}
+
+	var names []string
+	var errs []error
+	for i := 0; i < listers; i++ {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		config.Names.Strings(r.names)
+		for _, name := range r.names {
+			if !deleted(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("%d of %d backends failed to list metrics, first error: %v", len(errs), listers, errs[0])
+	}
+
+	idx := index.New(names)
+
+	metricIndex.Lock()
+	metricIndex.idx = idx
+	metricIndex.Unlock()
+
+	return nil
+}
+
+func runIndexer(bs []backend.Backend, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	for {
+		t0 := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := refreshIndex(ctx, bs)
+		cancel()
+
+		if err != nil {
+			logger.Warn("failed to refresh metric index",
+				zap.Error(err),
+			)
+		} else {
+			logger.Info("refreshed metric index",
+				zap.Int("metrics", currentIndex().Len()),
+				zap.Duration("runtime", time.Since(t0)),
+			)
+		}
+
+		<-ticker.C
+	}
+}
+
+// search searches the index for the metrics that weren't deleted since it
+// was built.
+func search(idx *index.Index, query string, limit int) []string {
+	found := idx.Search(query, limit)
+	kept := found[:0]
+	for _, name := range found {
+		if !deleted(name) {
+			kept = append(kept, name)
+		}
+	}
+
+	return kept
+}
+
+// searchHandler serves /metrics/search, a case-insensitive and fuzzy search
+// over the metric names in the index meant for autocompletion.
+func searchHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+	Metrics.SearchRequests.Add(1)
+
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "search"),
+		zap.String("carbonapi_uuid", util.GetUUID(req.Context())),
+	)
+
+	fail := func(code int, reason string, err error) {
+		http.Error(w, "search: "+reason, code)
+		accessLogger.Error("request failed",
+			zap.String("reason", reason),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "search").Inc()
+	}
+
+	if err := req.ParseForm(); err != nil {
+		fail(http.StatusBadRequest, "failed to parse arguments", err)
+		return
+	}
+
+	query := req.FormValue("query")
+	accessLogger = accessLogger.With(zap.String("query", query))
+	if query == "" {
+		fail(http.StatusBadRequest, "empty query", nil)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l := req.FormValue("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			fail(http.StatusBadRequest, "invalid limit", err)
+			return
+		}
+		limit = n
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	idx := currentIndex()
+	if !config.MetricIndex.Enabled || idx == nil {
+		fail(http.StatusServiceUnavailable, "metric index is not available", nil)
+		return
+	}
+
+	blob, err := json.Marshal(struct {
+		Query   string   `json:"query"`
+		Metrics []string `json:"metrics"`
+	}{
+		Query:   query,
+		Metrics: search(idx, query, limit),
+	})
+	if err != nil {
+		fail(http.StatusInternalServerError, "error marshaling data", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(blob)
+
+	accessLogger.Info("request served",
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", "search").Inc()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/index"
+	"github.com/bookingcom/carbonapi/pkg/intern"
+
+	"github.com/pkg/errors"
+)
+
+func TestRefreshIndex(t *testing.T) {
+	listing := func(names ...string) backend.Backend {
+		b := mock.New(mock.Config{
+			List: func(context.Context) ([]string, error) {
+				return names, nil
+			},
+		})
+		return &b
+	}
+	failing := func() backend.Backend {
+		b := mock.New(mock.Config{
+			List: func(context.Context) ([]string, error) {
+				return nil, errors.New("no")
+			},
+		})
+		return &b
+	}
+
+	defer func(cfg bool, maxAge time.Duration) {
+		config.MetricIndex.Enabled, config.MetricIndex.MaxAge = cfg, maxAge
+		metricIndex.idx = nil
+	}(config.MetricIndex.Enabled, config.MetricIndex.MaxAge)
+	config.MetricIndex.Enabled = true
+	config.MetricIndex.MaxAge = time.Hour
+
+	req := httptest.NewRequest("GET", "/metrics/find/?query=foo.*", nil)
+	if _, ok := findInIndex(req, "foo.*"); ok {
+		t.Error("expected a miss before the index is built")
+	}
+
+	err := refreshIndex(context.Background(), []backend.Backend{listing("foo.bar", "foo.baz"), listing("foo.qux.a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := findInIndex(req, "foo.*")
+	if !ok {
+		t.Fatal("expected a hit after the index is built")
+	}
+	if len(m.Matches) != 3 {
+		t.Errorf("expected 3 matches, got %v", m.Matches)
+	}
+
+	pinned := httptest.NewRequest("GET", "/metrics/find/?query=foo.*&cluster=eu", nil)
+	pinned.ParseForm()
+	if _, ok := findInIndex(pinned, "foo.*"); ok {
+		t.Error("expected requests pinned to a group to skip the index")
+	}
+
+	err = refreshIndex(context.Background(), []backend.Backend{listing("foo.bar"), failing()})
+	if err == nil {
+		t.Error("expected an error when a backend fails")
+	}
+	if currentIndex().Len() != 3 {
+		t.Errorf("expected the old index to be kept, got %d names", currentIndex().Len())
+	}
+
+	config.MetricIndex.MaxAge = 0
+	if _, ok := findInIndex(req, "foo.*"); ok {
+		t.Error("expected a stale index to be skipped")
+	}
+}
+
+func TestRefreshIndexInternsNames(t *testing.T) {
+	listing := func(names ...string) backend.Backend {
+		b := mock.New(mock.Config{
+			List: func(context.Context) ([]string, error) {
+				// Fresh copies, as a decoded response would have.
+				copies := make([]string, len(names))
+				for i, n := range names {
+					copies[i] = strings.Clone(n)
+				}
+				return copies, nil
+			},
+		})
+		return &b
+	}
+	path := func() string {
+		m, err := currentIndex().Find("foo.bar")
+		if err != nil || len(m.Matches) != 1 {
+			t.Fatalf("expected foo.bar in the index, got %v, %v", m, err)
+		}
+		return m.Matches[0].Path
+	}
+
+	defer func(names *intern.Pool) {
+		config.Names = names
+		metricIndex.idx = nil
+	}(config.Names)
+	config.Names = intern.New()
+
+	bs := []backend.Backend{listing("foo.bar", "foo.baz"), listing("foo.bar")}
+	if err := refreshIndex(context.Background(), bs); err != nil {
+		t.Fatal(err)
+	}
+	first := path()
+
+	config.Names.Rotate()
+	if err := refreshIndex(context.Background(), bs); err != nil {
+		t.Fatal(err)
+	}
+	if unsafe.StringData(path()) != unsafe.StringData(first) {
+		t.Error("expected the refreshed index to reuse the interned name")
+	}
+	if config.Names.Len() != 2 {
+		t.Errorf("expected 2 interned names, got %d", config.Names.Len())
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	defer func(enabled bool) {
+		config.MetricIndex.Enabled = enabled
+		metricIndex.idx = nil
+	}(config.MetricIndex.Enabled)
+
+	config.MetricIndex.Enabled = true
+	metricIndex.idx = nil
+
+	rr := httptest.NewRecorder()
+	searchHandler(rr, httptest.NewRequest("GET", "/metrics/search/?query=cpu", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without an index, got %d", rr.Code)
+	}
+
+	metricIndex.idx = index.New([]string{"servers.web01.cpu.user", "cpu.total", "apps.disk"})
+
+	rr = httptest.NewRecorder()
+	searchHandler(rr, httptest.NewRequest("GET", "/metrics/search/?query=CPU&limit=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	expected := `{"query":"CPU","metrics":["cpu.total"]}`
+	if rr.Body.String() != expected {
+		t.Errorf("expected %s, got %s", expected, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	searchHandler(rr, httptest.NewRequest("GET", "/metrics/search/?query=cpu&limit=x", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad limit, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// Zippers can be backends of other zippers, e.g. a global one in front of
+// regional ones. Each passes on the zippers a request went through in the
+// X-Forwarded-By header, so that a request coming back to one, because the
+// zippers are configured as backends of each other, is refused rather than
+// sent round again.
+
+// loopHandler refuses requests that already went through this zipper, and
+// adds it to the chain of the others.
+func loopHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		chain := util.ForwardedBy(req)
+		for _, id := range chain {
+			if id != config.ZipperID {
+				continue
+			}
+
+			Metrics.Loops.Add(1)
+			Metrics.Errors.Add(1)
+			prometheusMetrics.Responses.WithLabelValues("508", "loop").Inc()
+			zapwriter.Logger("access").Error("routing loop detected",
+				zap.String("carbonapi_uuid", util.GetUUID(req.Context())),
+				zap.String("url", req.URL.RequestURI()),
+				zap.Strings("forwarded_by", chain),
+				zap.Int("http_code", http.StatusLoopDetected),
+			)
+			http.Error(w, "routing loop detected: "+strings.Join(chain, " -> ")+" -> "+config.ZipperID, http.StatusLoopDetected)
+			return
+		}
+
+		ctx := util.WithForwardedBy(req.Context(), append(chain, config.ZipperID))
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// defaultZipperID names the zipper after its host and the port it listens
+// on, which tells apart zippers sharing a host.
+func defaultZipperID(listen string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil || port == "" {
+		return host
+	}
+
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/util"
+)
+
+func TestLoopHandler(t *testing.T) {
+	defer func(id string) { config.ZipperID = id }(config.ZipperID)
+	config.ZipperID = "regional:8080"
+
+	var chain []string
+	h := loopHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		chain = util.GetForwardedBy(req.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/render/?target=foo", nil)
+	req.Header.Set("X-Forwarded-By", "global:8080")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if expected := []string{"global:8080", "regional:8080"}; !reflect.DeepEqual(chain, expected) {
+		t.Errorf("expected chain %v, got %v", expected, chain)
+	}
+
+	chain = nil
+	loops := Metrics.Loops.Value()
+	req = httptest.NewRequest("GET", "/render/?target=foo", nil)
+	req.Header.Set("X-Forwarded-By", "global:8080, regional:8080, global:8081")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusLoopDetected {
+		t.Errorf("expected 508, got %d", rr.Code)
+	}
+	if chain != nil {
+		t.Error("expected the looping request not to be served")
+	}
+	if Metrics.Loops.Value() != loops+1 {
+		t.Error("expected the loop to be counted")
+	}
+}
+
+func TestDefaultZipperID(t *testing.T) {
+	id := defaultZipperID(":8080")
+	if id == "" || id[len(id)-5:] != ":8080" {
+		t.Errorf("expected an ID ending in the port, got %q", id)
+	}
+}
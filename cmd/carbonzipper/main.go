@@ -14,13 +14,19 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/limiter"
 	"github.com/bookingcom/carbonapi/mstats"
 	"github.com/bookingcom/carbonapi/pkg/backend"
 	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/buildinfo"
+	"github.com/bookingcom/carbonapi/pkg/dnscache"
+	"github.com/bookingcom/carbonapi/pkg/histogram"
+	"github.com/bookingcom/carbonapi/pkg/listener"
+	"github.com/bookingcom/carbonapi/pkg/shutdown"
+	"github.com/bookingcom/carbonapi/pkg/statsd"
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/json"
@@ -39,10 +45,12 @@ import (
 )
 
 var prometheusMetrics = struct {
-	Requests     prometheus.Counter
-	Responses    *prometheus.CounterVec
-	DurationsExp prometheus.Histogram
-	DurationsLin prometheus.Histogram
+	Requests  prometheus.Counter
+	Responses *prometheus.CounterVec
+	// The durations are set up with the configured buckets.
+	DurationsExp     *histogram.Vec
+	DurationsLin     *histogram.Vec
+	BackendDurations *histogram.Vec
 }{
 	Requests: prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -57,25 +65,21 @@ var prometheusMetrics = struct {
 		},
 		[]string{"code", "handler"},
 	),
-	DurationsExp: prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds_exp",
-			Help:    "The duration of HTTP requests (exponential)",
-			Buckets: prometheus.ExponentialBuckets((50 * time.Millisecond).Seconds(), 2.0, 20),
-		},
-	),
-	DurationsLin: prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds_lin",
-			Help:    "The duration of HTTP requests (linear)",
-			Buckets: prometheus.LinearBuckets(0.0, (50 * time.Millisecond).Seconds(), 40), // Up to 2 seconds
-		},
-	),
 }
 
 var (
-	config   cfg.Zipper = cfg.DefaultZipperConfig
-	backends []backend.Backend
+	config        cfg.Zipper = cfg.DefaultZipperConfig
+	backends      []backend.Backend
+	backendGroups map[string][]backend.Backend
+	// backendRegions maps backends to the region of their group.
+	backendRegions map[backend.Backend]string
+	// backendDomains maps backends to the failure domain of their group.
+	backendDomains map[backend.Backend]string
+	// backendHosts maps backends to their address, by which the path cache
+	// keeps them.
+	backendHosts = make(map[backend.Backend]string)
+	// backendWeights are the configured weights of the backends.
+	backendWeights map[backend.Backend]float64
 )
 
 // Metrics contains grouped expvars for /debug/vars and graphite
@@ -96,8 +100,42 @@ var Metrics = struct {
 	InfoRequests *expvar.Int
 	InfoErrors   *expvar.Int
 
+	SearchRequests *expvar.Int
+
 	Timeouts *expvar.Int
 
+	RegionFallbacks *expvar.Int
+
+	ExpandedFinds *expvar.Int
+
+	// Failovers counts the failed backend requests sent again to another
+	// backend, by the address of the backend that failed.
+	Failovers *expvar.Map
+
+	// BackendErrors counts the responses failed with backend errors, by
+	// the kind of error.
+	BackendErrors *expvar.Map
+
+	// PartialResponses counts responses fewer failure domains answered
+	// than the quorum requires.
+	PartialResponses *expvar.Int
+
+	Loops *expvar.Int
+
+	ShadowRequests *expvar.Int
+	ShadowErrors   *expvar.Int
+	ShadowDiffs    *expvar.Int
+
+	IndexHits   *expvar.Int
+	IndexMisses *expvar.Int
+	IndexSize   expvar.Func
+
+	InternedNames expvar.Func
+
+	MemoryRejects *expvar.Int
+	MemoryInUse   expvar.Func
+	TooLarge      *expvar.Int
+
 	CacheSize   expvar.Func
 	CacheItems  expvar.Func
 	CacheMisses *expvar.Int
@@ -116,8 +154,30 @@ var Metrics = struct {
 	InfoRequests: expvar.NewInt("info_requests"),
 	InfoErrors:   expvar.NewInt("info_errors"),
 
+	SearchRequests: expvar.NewInt("search_requests"),
+
 	Timeouts: expvar.NewInt("timeouts"),
 
+	RegionFallbacks: expvar.NewInt("region_fallbacks"),
+
+	ExpandedFinds: expvar.NewInt("expanded_finds"),
+
+	Failovers:        expvar.NewMap("failovers"),
+	BackendErrors:    expvar.NewMap("backend_errors"),
+	PartialResponses: expvar.NewInt("partial_responses"),
+
+	Loops: expvar.NewInt("loops"),
+
+	ShadowRequests: expvar.NewInt("shadow_requests"),
+	ShadowErrors:   expvar.NewInt("shadow_errors"),
+	ShadowDiffs:    expvar.NewInt("shadow_diffs"),
+
+	IndexHits:   expvar.NewInt("index_hits"),
+	IndexMisses: expvar.NewInt("index_misses"),
+
+	MemoryRejects: expvar.NewInt("memory_rejects"),
+	TooLarge:      expvar.NewInt("too_large"),
+
 	CacheHits:   expvar.NewInt("cache_hits"),
 	CacheMisses: expvar.NewInt("cache_misses"),
 }
@@ -125,10 +185,18 @@ var Metrics = struct {
 // BuildVersion is defined at build and reported at startup and as expvar
 var BuildVersion = "(development version)"
 
+// BuildRevision is the git revision, defined at build like BuildVersion
+var BuildRevision = ""
+
+// buildInfo exports BuildVersion, BuildRevision and the hash of the config
+// to Prometheus
+var buildInfo = buildinfo.New(BuildVersion, BuildRevision)
+
 const (
-	contentTypeJSON     = "application/json"
-	contentTypeProtobuf = "application/x-protobuf"
-	contentTypePickle   = "application/pickle"
+	contentTypeJSON          = "application/json"
+	contentTypeProtobuf      = "application/x-protobuf"
+	contentTypeCarbonAPIv3PB = "application/x-carbonapi-v3-pb"
+	contentTypePickle        = "application/pickle"
 )
 
 const (
@@ -170,20 +238,53 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
 
-	backends := backend.Filter(backends, []string{originalQuery})
-	metrics, err := backend.Finds(ctx, backends, originalQuery)
+	bs, err := selectBackends(req, backends, backendGroups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "invalid backend group selection"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "find").Inc()
+		return
+	}
+
+	metrics, ok := findInIndex(req, originalQuery)
+	if !ok {
+		tiers := regionTiers(bs, backendRegions, config.Region)
+		metrics, err = findExpanded(ctx, tiers, originalQuery)
+	}
+	if _, ok := err.(*expansionError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "too many expanded queries"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "find").Inc()
+		return
+	}
 	if err != nil {
+		code := backendErrorCode(err)
 		accessLogger.Error("find failed",
-			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Int("http_code", code),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 			zap.Error(err),
 		)
-		http.Error(w, "error fetching the data", http.StatusInternalServerError)
+		http.Error(w, "error fetching the data", code)
 		Metrics.Errors.Add(1)
-		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusInternalServerError), "find").Inc()
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "find").Inc()
 		return
 	}
 
+	metrics = dropDeleted(metrics)
+	recordFind(originalQuery)
+
 	sort.Slice(metrics.Matches, func(i, j int) bool {
 		if metrics.Matches[i].Path < metrics.Matches[j].Path {
 			return true
@@ -193,6 +294,7 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 		}
 		return metrics.Matches[i].Path < metrics.Matches[j].Path
 	})
+	mirrorFind(ctx, originalQuery, metrics)
 
 	var contentType string
 	var blob []byte
@@ -282,11 +384,12 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	target := req.FormValue("target")
+	// carbonapi batches the fetches of a request into one render
+	targets := req.Form["target"]
 	format := req.FormValue("format")
 	accessLogger = accessLogger.With(
 		zap.String("format", format),
-		zap.String("target", target),
+		zap.Strings("targets", targets),
 	)
 
 	from, err := strconv.Atoi(req.FormValue("from"))
@@ -319,7 +422,7 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if target == "" {
+	if len(targets) == 0 || targets[0] == "" {
 		http.Error(w, "empty target", http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
@@ -332,21 +435,82 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	backends := backend.Filter(backends, []string{target})
-	metrics, err := backend.Renders(ctx, backends, int32(from), int32(until), []string{target})
+	bs, err := selectBackends(req, backends, backendGroups)
 	if err != nil {
-		http.Error(w, "error fetching the data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "invalid backend group selection"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
 			zap.Error(err),
-			zap.Int("http_code", http.StatusInternalServerError),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "render").Inc()
+		return
+	}
+
+	queueCtx := ctx
+	if config.MemoryLimit.QueueTimeout > 0 {
+		var queueCancel context.CancelFunc
+		queueCtx, queueCancel = context.WithTimeout(ctx, config.MemoryLimit.QueueTimeout)
+		defer queueCancel()
+	}
+	reservation, err := memoryLimiter.Reserve(queueCtx, estimateRenderBytes(targets, int32(from), int32(until)))
+	if err != nil {
+		http.Error(w, "memory budget exceeded", http.StatusServiceUnavailable)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "memory budget exceeded"),
+			zap.Int("http_code", http.StatusServiceUnavailable),
 			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
 		)
 		Metrics.Errors.Add(1)
-		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusInternalServerError), "render").Inc()
+		Metrics.MemoryRejects.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusServiceUnavailable), "render").Inc()
+		return
+	}
+	defer reservation.Release()
+
+	if config.MaxRenderPoints > 0 {
+		ctx = backend.WithPointLimit(ctx, config.MaxRenderPoints)
+	}
+
+	tiers := regionTiers(bs, backendRegions, config.Region)
+	metrics, err := rendersByRegion(withFanOut(ctx, targets), tiers, int32(from), int32(until), targets)
+	if tooLarge, ok := err.(*backend.TooLargeError); ok {
+		http.Error(w, tooLarge.Error(), http.StatusUnprocessableEntity)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "result too large"),
+			zap.Int("points", tooLarge.Points),
+			zap.Int("http_code", http.StatusUnprocessableEntity),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		Metrics.TooLarge.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusUnprocessableEntity), "render").Inc()
+		return
+	}
+	if err != nil {
+		code := backendErrorCode(err)
+		http.Error(w, "error fetching the data", code)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.Error(err),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "render").Inc()
 		return
 	}
 
+	reservation.Adjust(metricsBytes(metrics))
+	mirrorRender(ctx, int32(from), int32(until), targets, metrics)
+	memoryUsage = int(reservation.Bytes())
+
 	var blob []byte
 	var contentType string
 	switch format {
@@ -449,17 +613,32 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	backends := backend.Filter(backends, []string{target})
-	infos, err := backend.Infos(ctx, backends, target)
+	bs, err := selectBackends(req, backends, backendGroups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "invalid backend group selection"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusBadRequest), "info").Inc()
+		return
+	}
+
+	tiers := regionTiers(bs, backendRegions, config.Region)
+	infos, err := infosByRegion(withFanOut(ctx, nil), tiers, target)
 	if err != nil {
+		code := backendErrorCode(err)
 		accessLogger.Error("info failed",
-			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Int("http_code", code),
 			zap.Error(err),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
-		http.Error(w, "info: error processing request", http.StatusInternalServerError)
+		http.Error(w, "info: error processing request", code)
 		Metrics.Errors.Add(1)
-		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusInternalServerError), "info").Inc()
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), "info").Inc()
 		return
 	}
 
@@ -515,6 +694,18 @@ func lbCheckHandler(w http.ResponseWriter, req *http.Request) {
 	Metrics.Requests.Add(1)
 	prometheusMetrics.Requests.Inc()
 
+	if !isWarm() {
+		http.Error(w, "Warming up", http.StatusServiceUnavailable)
+		accessLogger.Info("lb request served",
+			zap.Int("http_code", http.StatusServiceUnavailable),
+			zap.String("reason", "warming up"),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		Metrics.Responses.Add(1)
+		prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", http.StatusServiceUnavailable), "lbcheck").Inc()
+		return
+	}
+
 	/* #nosec */
 	fmt.Fprintf(w, "Ok\n")
 	accessLogger.Info("lb request served",
@@ -561,10 +752,14 @@ func main() {
 	}
 	fh.Close()
 
-	if len(config.Backends) == 0 {
+	if len(config.Backends) == 0 && len(config.BackendGroups) == 0 {
 		logger.Fatal("no Backends loaded -- exiting")
 	}
 
+	if config.ZipperID == "" {
+		config.ZipperID = defaultZipperID(config.Listen)
+	}
+
 	if err := zapwriter.ApplyConfig(config.Logger); err != nil {
 		logger.Fatal("Failed to apply config",
 			zap.Any("config", config.Logger),
@@ -572,24 +767,49 @@ func main() {
 		)
 	}
 
-	client := &http.Client{}
-	client.Transport = &http.Transport{
-		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
-		DialContext: (&net.Dialer{
-			Timeout:   config.Timeouts.Connect,
-			KeepAlive: config.KeepAliveInterval,
-			DualStack: true,
-		}).DialContext,
+	setUpDurations()
+	setUpWarmup()
+	loadPathCache(logger)
+
+	if config.DNSCache.TTL > 0 {
+		resolver = dnscache.New(config.DNSCache.TTL)
+		if config.DNSCache.RefreshInterval > 0 {
+			go refreshDNS(config.DNSCache.RefreshInterval)
+		}
 	}
 
-	backends = make([]backend.Backend, 0, len(config.Backends))
-	for _, host := range config.Backends {
+	// Backends with the same transport settings share a client.
+	clients := make(map[cfg.Transport]*http.Client)
+	prewarmConns := make(map[*bnet.Backend]int)
+
+	// A host listed several times, in backends or in groups, is still
+	// queried only once per request, with the transport, protocol and path
+	// rewriting of its first listing.
+	byHost := make(map[string]backend.Backend)
+	getBackend := func(host string, transport cfg.Transport, protocol string, rewrite *bnet.Rewrite, group string) backend.Backend {
+		if b, ok := byHost[host]; ok {
+			return b
+		}
+
+		client, ok := clients[transport]
+		if !ok {
+			client = newClient(transport)
+			clients[transport] = client
+		}
+
 		b, err := bnet.New(bnet.Config{
-			Address: host,
-			Client:  client,
-			Timeout: config.Timeouts.AfterStarted,
-			Limit:   config.ConcurrencyLimitPerServer,
-			Logger:  logger,
+			Address:  host,
+			Client:   client,
+			Timeout:  config.Timeouts.AfterStarted,
+			Limit:    config.ConcurrencyLimitPerServer,
+			Logger:   logger,
+			Protocol: protocol,
+			Chaos:    chaosFor(host, logger),
+			Rewrite:  rewrite,
+
+			FalsePositiveRate: config.PathFilter.FalsePositiveRate,
+
+			Durations: prometheusMetrics.BackendDurations.WithLabelValues(group),
 		})
 
 		if err != nil {
@@ -599,21 +819,128 @@ func main() {
 			)
 		}
 
+		byHost[host] = b
+		backendHosts[b] = host
 		backends = append(backends, b)
+		prewarmConns[b] = transport.Prewarm
+
+		return b
 	}
 
-	go func() {
-		probeTicker := time.NewTicker(5 * time.Minute)
-		for {
-			for _, b := range backends {
-				go b.Probe()
+	backends = make([]backend.Backend, 0, len(config.Backends))
+	for _, host := range config.Backends {
+		getBackend(host, config.Transport, bnet.ProtocolAuto, nil, defaultGroup)
+	}
+
+	backendGroups = make(map[string][]backend.Backend, len(config.BackendGroups))
+	backendRegions = make(map[backend.Backend]string)
+	backendDomains = make(map[backend.Backend]string)
+	for _, group := range config.BackendGroups {
+		if _, ok := backendGroups[group.Name]; ok || group.Name == "" {
+			logger.Fatal("Backend group names must be unique and non-empty",
+				zap.String("group", group.Name),
+			)
+		}
+
+		transport := config.Transport
+		if group.Transport != nil {
+			transport = *group.Transport
+		}
+		protocol := group.Protocol
+		if protocol == "" {
+			protocol = bnet.ProtocolAuto
+		}
+		rewrite, err := newRewrite(group.Rewrite)
+		if err != nil {
+			logger.Fatal("Invalid path rewriting of backend group",
+				zap.String("group", group.Name),
+				zap.Error(err),
+			)
+		}
+
+		bs := make([]backend.Backend, 0, len(group.Backends))
+		for _, host := range group.Backends {
+			b := getBackend(host, transport, protocol, rewrite, group.Name)
+			if r, ok := backendRegions[b]; ok && r != group.Region {
+				logger.Fatal("Backend belongs to groups in different regions",
+					zap.String("host", host),
+					zap.String("region", r),
+					zap.String("other_region", group.Region),
+				)
 			}
-			<-probeTicker.C
+			backendRegions[b] = group.Region
+			if d, ok := backendDomains[b]; ok && d != group.Domain {
+				logger.Fatal("Backend belongs to groups in different failure domains",
+					zap.String("host", host),
+					zap.String("domain", d),
+					zap.String("other_domain", group.Domain),
+				)
+			}
+			backendDomains[b] = group.Domain
+			bs = append(bs, b)
+		}
+		backendGroups[group.Name] = bs
+	}
+	for b, d := range backendDomains {
+		if d == "" {
+			delete(backendDomains, b)
+		}
+	}
+
+	if config.Shadow.Group != "" {
+		shadowBackends = takeShadowGroup(config.Shadow.Group, logger)
+	}
+
+	backendWeights = make(map[backend.Backend]float64, len(config.BackendWeights))
+	for host, weight := range config.BackendWeights {
+		b, ok := byHost[host]
+		if !ok || weight <= 0 {
+			logger.Fatal("Backend weights must be positive and for configured backends",
+				zap.String("host", host),
+				zap.Float64("weight", weight),
+			)
+		}
+		backendWeights[b] = weight
+	}
+
+	prewarmCtx, prewarmCancel := context.WithTimeout(context.Background(), config.Timeouts.Global)
+	prewarm(prewarmCtx, prewarmConns, logger)
+	prewarmCancel()
+
+	trackProbes(backends)
+	go func() {
+		// Warming up probes the backends first.
+		warmingUp := !isWarm()
+		if warmingUp {
+			warmUp(backends, zapwriter.Logger("warmup"))
 		}
+		runProbes(backends, newProbeSchedule(), warmingUp, zapwriter.Logger("probe"))
 	}()
 
+	if interval := namesRotation(); interval > 0 {
+		go rotateNames(config.Names, interval)
+	}
+
+	if config.MetricIndex.Enabled {
+		go runIndexer(backends, config.MetricIndex.RefreshInterval, zapwriter.Logger("index"))
+	}
+
+	if config.Tombstones.Enabled {
+		go runTombstones(backends, config.Tombstones.RefreshInterval, zapwriter.Logger("tombstones"))
+	}
+
+	if config.MemoryLimit.Budget > 0 {
+		memoryLimiter = limiter.NewMemoryLimiter(config.MemoryLimit.Budget)
+	}
+
 	types.SetCorruptionWatcher(config.CorruptionThreshold, logger)
 
+	stepPolicy, err := types.ParseStepPolicy(config.StepPolicy)
+	if err != nil {
+		logger.Fatal("invalid stepPolicy", zap.Error(err))
+	}
+	types.SetStepPolicy(stepPolicy)
+
 	// Should print nicer stack traces in case of unexpected panic.
 	defer func() {
 		if r := recover(); r != nil {
@@ -631,13 +958,13 @@ func main() {
 
 	runtime.GOMAXPROCS(config.MaxProcs)
 
-	// +1 to track every over the number of buckets we track
-	timeBuckets = make([]int64, config.Buckets+1)
-	expTimeBuckets = make([]int64, config.Buckets+1)
-
 	httputil.PublishTrackedConnections("httptrack")
-	expvar.Publish("requestBuckets", expvar.Func(renderTimeBuckets))
-	expvar.Publish("expRequestBuckets", expvar.Func(renderExpTimeBuckets))
+	expvar.Publish("requestBuckets", expvar.Func(func() interface{} {
+		return prometheusMetrics.DurationsLin.Counts()
+	}))
+	expvar.Publish("expRequestBuckets", expvar.Func(func() interface{} {
+		return prometheusMetrics.DurationsExp.Counts()
+	}))
 
 	Metrics.Goroutines = expvar.Func(func() interface{} {
 		return runtime.NumGoroutine()
@@ -652,24 +979,49 @@ func main() {
 
 	// export config via expvars
 	expvar.Publish("config", expvar.Func(func() interface{} { return config }))
+	if err := buildInfo.SetConfig(config); err != nil {
+		logger.Warn("failed to hash the config", zap.Error(err))
+	}
 
 	/* Configure zipper */
 	// set up caches
 
+	Metrics.MemoryInUse = expvar.Func(func() interface{} { return memoryLimiter.Used() })
+	expvar.Publish("memoryInUse", Metrics.MemoryInUse)
+
 	Metrics.CacheSize = expvar.Func(func() interface{} { return config.PathCache.ECSize() })
 	expvar.Publish("cacheSize", Metrics.CacheSize)
 
 	Metrics.CacheItems = expvar.Func(func() interface{} { return config.PathCache.ECItems() })
 	expvar.Publish("cacheItems", Metrics.CacheItems)
 
+	Metrics.IndexSize = expvar.Func(func() interface{} {
+		if idx := currentIndex(); idx != nil {
+			return idx.Len()
+		}
+		return 0
+	})
+	expvar.Publish("indexSize", Metrics.IndexSize)
+
+	Metrics.InternedNames = expvar.Func(func() interface{} { return config.Names.Len() })
+	expvar.Publish("internedNames", Metrics.InternedNames)
+
 	r := http.NewServeMux()
 
-	r.HandleFunc("/metrics/find/", httputil.TrackConnections(httputil.TimeHandler(findHandler, bucketRequestTimes)))
-	r.HandleFunc("/render/", httputil.TrackConnections(httputil.TimeHandler(renderHandler, bucketRequestTimes)))
-	r.HandleFunc("/info/", httputil.TrackConnections(httputil.TimeHandler(infoHandler, bucketRequestTimes)))
+	r.HandleFunc("/metrics/find/", httputil.TrackConnections(httputil.TimeHandler(trackQuery("find", findHandler), bucketRequestTimes("find"))))
+	r.HandleFunc("/render/", httputil.TrackConnections(httputil.TimeHandler(trackQuery("render", renderHandler), bucketRequestTimes("render"))))
+	r.HandleFunc("/info/", httputil.TrackConnections(httputil.TimeHandler(trackQuery("info", infoHandler), bucketRequestTimes("info"))))
+	r.HandleFunc("/metrics/search/", httputil.TrackConnections(httputil.TimeHandler(searchHandler, bucketRequestTimes("search"))))
+
+	r.HandleFunc("/api/v3/find", httputil.TrackConnections(httputil.TimeHandler(trackQuery("find_v3", findV3Handler), bucketRequestTimes("find_v3"))))
+	r.HandleFunc("/api/v3/render", httputil.TrackConnections(httputil.TimeHandler(trackQuery("render_v3", renderV3Handler), bucketRequestTimes("render_v3"))))
+	r.HandleFunc("/api/v3/info", httputil.TrackConnections(httputil.TimeHandler(trackQuery("info_v3", infoV3Handler), bucketRequestTimes("info_v3"))))
+	r.HandleFunc("/capabilities/", capabilitiesHandler)
+	r.HandleFunc("/metrics/tlds/", tldsHandler)
+	r.HandleFunc("/metrics/tombstones/", tombstonesHandler)
 	r.HandleFunc("/lb_check", lbCheckHandler)
 
-	handler := util.UUIDHandler(r)
+	handler := util.UUIDHandler(loopHandler(withQuorum(r)))
 
 	// nothing in the config? check the environment
 	if config.Graphite.Host == "" {
@@ -686,10 +1038,14 @@ func main() {
 		config.Graphite.Prefix = "carbon.zipper"
 	}
 
-	// only register g2g if we have a graphite host
-	if config.Graphite.Host != "" {
-		// register our metrics with graphite
-		graphite := g2g.NewGraphite(config.Graphite.Host, config.Graphite.Interval, 10*time.Second)
+	// only register g2g if we have a graphite host, or statsd if we have an
+	// agent
+	if config.Graphite.Host != "" || config.StatsD.Address != "" {
+		// register our metrics with graphite and statsd
+		var graphite statsd.Tee
+		if config.Graphite.Host != "" {
+			graphite = append(graphite, g2g.NewGraphite(config.Graphite.Host, config.Graphite.Interval, 10*time.Second))
+		}
 
 		/* #nosec */
 		hostname, _ := os.Hostname()
@@ -701,6 +1057,10 @@ func main() {
 		pattern = strings.Replace(pattern, "{prefix}", prefix, -1)
 		pattern = strings.Replace(pattern, "{fqdn}", hostname, -1)
 
+		if config.StatsD.Address != "" {
+			graphite = append(graphite, newStatsD(pattern, logger))
+		}
+
 		graphite.Register(fmt.Sprintf("%s.requests", pattern), Metrics.Requests)
 		graphite.Register(fmt.Sprintf("%s.responses", pattern), Metrics.Responses)
 		graphite.Register(fmt.Sprintf("%s.errors", pattern), Metrics.Errors)
@@ -714,14 +1074,40 @@ func main() {
 		graphite.Register(fmt.Sprintf("%s.info_requests", pattern), Metrics.InfoRequests)
 		graphite.Register(fmt.Sprintf("%s.info_errors", pattern), Metrics.InfoErrors)
 
+		graphite.Register(fmt.Sprintf("%s.search_requests", pattern), Metrics.SearchRequests)
+
 		graphite.Register(fmt.Sprintf("%s.timeouts", pattern), Metrics.Timeouts)
 
-		for i := 0; i <= config.Buckets; i++ {
-			graphite.Register(fmt.Sprintf("%s.requests_in_%dms_to_%dms", pattern, i*100, (i+1)*100), bucketEntry(i))
-			lower, upper := util.Bounds(i)
-			graphite.Register(fmt.Sprintf("%s.exp.requests_in_%05dms_to_%05dms", pattern, lower, upper), expBucketEntry(i))
+		graphite.Register(fmt.Sprintf("%s.region_fallbacks", pattern), Metrics.RegionFallbacks)
+		graphite.Register(fmt.Sprintf("%s.expanded_finds", pattern), Metrics.ExpandedFinds)
+		graphite.Register(fmt.Sprintf("%s.partial_responses", pattern), Metrics.PartialResponses)
+		graphite.Register(fmt.Sprintf("%s.loops", pattern), Metrics.Loops)
+		graphite.Register(fmt.Sprintf("%s.shadow_requests", pattern), Metrics.ShadowRequests)
+		graphite.Register(fmt.Sprintf("%s.shadow_errors", pattern), Metrics.ShadowErrors)
+		graphite.Register(fmt.Sprintf("%s.shadow_diffs", pattern), Metrics.ShadowDiffs)
+
+		graphite.Register(fmt.Sprintf("%s.index_hits", pattern), Metrics.IndexHits)
+		graphite.Register(fmt.Sprintf("%s.index_misses", pattern), Metrics.IndexMisses)
+		graphite.Register(fmt.Sprintf("%s.index_size", pattern), Metrics.IndexSize)
+		graphite.Register(fmt.Sprintf("%s.interned_names", pattern), Metrics.InternedNames)
+
+		for i := 0; i < prometheusMetrics.DurationsLin.Buckets(); i++ {
+			lower, upper := prometheusMetrics.DurationsLin.Bounds(i)
+			graphite.Register(fmt.Sprintf("%s.requests_in_%dms_to_%dms", pattern, lower, upper), prometheusMetrics.DurationsLin.Bucket(i))
+		}
+		for i := 0; i < prometheusMetrics.DurationsExp.Buckets(); i++ {
+			lower, upper := prometheusMetrics.DurationsExp.Bounds(i)
+			graphite.Register(fmt.Sprintf("%s.exp.requests_in_%05dms_to_%05dms", pattern, lower, upper), prometheusMetrics.DurationsExp.Bucket(i))
+		}
+		for i := 0; i < prometheusMetrics.BackendDurations.Buckets(); i++ {
+			lower, upper := prometheusMetrics.BackendDurations.Bounds(i)
+			graphite.Register(fmt.Sprintf("%s.backend.requests_in_%05dms_to_%05dms", pattern, lower, upper), prometheusMetrics.BackendDurations.Bucket(i))
 		}
 
+		graphite.Register(fmt.Sprintf("%s.memory_rejects", pattern), Metrics.MemoryRejects)
+		graphite.Register(fmt.Sprintf("%s.memory_in_use", pattern), Metrics.MemoryInUse)
+		graphite.Register(fmt.Sprintf("%s.too_large", pattern), Metrics.TooLarge)
+
 		graphite.Register(fmt.Sprintf("%s.cache_size", pattern), Metrics.CacheSize)
 		graphite.Register(fmt.Sprintf("%s.cache_items", pattern), Metrics.CacheItems)
 
@@ -747,10 +1133,13 @@ func main() {
 	}
 
 	go func() {
+		prometheus.MustRegister(buildInfo)
 		prometheus.MustRegister(prometheusMetrics.Requests)
 		prometheus.MustRegister(prometheusMetrics.Responses)
 		prometheus.MustRegister(prometheusMetrics.DurationsExp)
 		prometheus.MustRegister(prometheusMetrics.DurationsLin)
+		prometheus.MustRegister(prometheusMetrics.BackendDurations)
+		prometheus.MustRegister(probeAges{})
 
 		writeTimeout := config.Timeouts.Global
 		if writeTimeout < 30*time.Second {
@@ -761,6 +1150,8 @@ func main() {
 		r.Handle("/metrics", promhttp.Handler())
 
 		r.Handle("/debug/vars", expvar.Handler())
+		r.HandleFunc("/debug/queries", queriesHandler)
+		r.HandleFunc("/debug/queries/cancel", cancelQueryHandler)
 		r.HandleFunc("/debug/pprof/", pprof.Index)
 		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -781,12 +1172,22 @@ func main() {
 		}
 	}()
 
-	err = gracehttp.Serve(&http.Server{
+	server := &http.Server{
 		Addr:         config.Listen,
 		Handler:      handler,
 		ReadTimeout:  1 * time.Second,
 		WriteTimeout: config.Timeouts.Global,
-	})
+	}
+
+	if config.Shutdown.DrainTimeout > 0 || config.Listener != (listener.Config{}) {
+		var listeners []net.Listener
+		listeners, err = listener.Listen(config.Listen, config.Listener)
+		if err == nil {
+			err = shutdown.Serve(server, listeners, config.Shutdown.DrainTimeout, logger, shutdownHooks(clients, logger)...)
+		}
+	} else {
+		err = gracehttp.Serve(server)
+	}
 
 	if err != nil {
 		log.Fatal("error during gracehttp.Serve()",
@@ -795,52 +1196,49 @@ func main() {
 	}
 }
 
-var timeBuckets []int64
-var expTimeBuckets []int64
-
-type bucketEntry int
-type expBucketEntry int
-
-func (b bucketEntry) String() string {
-	return strconv.Itoa(int(atomic.LoadInt64(&timeBuckets[b])))
-}
-
-func (b expBucketEntry) String() string {
-	return strconv.Itoa(int(atomic.LoadInt64(&expTimeBuckets[b])))
-}
-
-func renderTimeBuckets() interface{} {
-	return timeBuckets
-}
-
-func renderExpTimeBuckets() interface{} {
-	return expTimeBuckets
-}
-
-func findBucketIndex(buckets []int64, bucket int) int {
-	var i int
-	if bucket < 0 {
-		i = 0
-	} else if bucket < len(buckets)-1 {
-		i = bucket
-	} else {
-		i = len(buckets) - 1
+// defaultGroup is the backend group of the backends listed outside of
+// groups, in the metrics.
+const defaultGroup = "default"
+
+// setUpDurations sets up the histograms of request durations, by handler,
+// and of backend call durations, by backend group. Their buckets default to
+// those graphite has always been sent.
+func setUpDurations() {
+	lin := config.Histograms.Linear
+	if len(lin) == 0 {
+		lin = prometheus.LinearBuckets(0.1, 0.1, config.Buckets)
+	}
+	exp := config.Histograms.Exponential
+	if len(exp) == 0 {
+		exp = prometheus.ExponentialBuckets(0.05, 2, config.Buckets)
+	}
+	backend := config.Histograms.Backend
+	if len(backend) == 0 {
+		backend = prometheus.ExponentialBuckets(0.05, 2, config.Buckets)
 	}
 
-	return i
+	prometheusMetrics.DurationsLin = histogram.New(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds_lin",
+		Help:    "The duration of HTTP requests (linear), partitioned by handler",
+		Buckets: lin,
+	}, "handler")
+	prometheusMetrics.DurationsExp = histogram.New(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds_exp",
+		Help:    "The duration of HTTP requests (exponential), partitioned by handler",
+		Buckets: exp,
+	}, "handler")
+	prometheusMetrics.BackendDurations = histogram.New(prometheus.HistogramOpts{
+		Name:    "backend_request_duration_seconds",
+		Help:    "The duration of requests to backends, partitioned by backend group",
+		Buckets: backend,
+	}, "group")
 }
 
-func bucketRequestTimes(req *http.Request, t time.Duration) {
-	ms := t.Nanoseconds() / int64(time.Millisecond)
-
-	bucket := int(ms / 100)
-	bucketIdx := findBucketIndex(timeBuckets, bucket)
-	atomic.AddInt64(&timeBuckets[bucketIdx], 1)
-
-	expBucket := util.Bucket(ms, config.Buckets)
-	expBucketIdx := findBucketIndex(expTimeBuckets, expBucket)
-	atomic.AddInt64(&expTimeBuckets[expBucketIdx], 1)
-
-	prometheusMetrics.DurationsExp.Observe(t.Seconds())
-	prometheusMetrics.DurationsLin.Observe(t.Seconds())
+// bucketRequestTimes returns the func that counts the durations of the
+// requests to handler.
+func bucketRequestTimes(handler string) func(*http.Request, time.Duration) {
+	return func(req *http.Request, t time.Duration) {
+		prometheusMetrics.DurationsExp.Observe(t, handler)
+		prometheusMetrics.DurationsLin.Observe(t, handler)
+	}
 }
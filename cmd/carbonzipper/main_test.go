@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/limiter"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+
+	"github.com/pkg/errors"
+)
+
+func TestRenderHandlerTargets(t *testing.T) {
+	b := mock.New(mock.Config{
+		Render: func(ctx context.Context, from, until int32, targets []string) ([]types.Metric, error) {
+			metrics := make([]types.Metric, 0, len(targets))
+			for _, target := range targets {
+				metrics = append(metrics, types.Metric{
+					Name:      target,
+					StartTime: from,
+					StopTime:  until,
+					StepTime:  60,
+					Values:    []float64{1},
+					IsAbsent:  []bool{false},
+				})
+			}
+			return metrics, nil
+		},
+	})
+
+	defer func(bs []backend.Backend, global time.Duration) {
+		backends, config.Timeouts.Global = bs, global
+	}(backends, config.Timeouts.Global)
+	backends = []backend.Backend{&b}
+	config.Timeouts.Global = time.Second
+
+	rr := httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&target=bar&from=0&until=60&format=protobuf", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	metrics, err := carbonapi_v2.RenderDecoder(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	if expected := []string{"bar", "foo"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	rr = httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?from=0&until=60", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without targets, got %d", rr.Code)
+	}
+}
+
+func TestRenderHandlerMemoryLimit(t *testing.T) {
+	b := mock.New(mock.Config{})
+
+	defer func(bs []backend.Backend, global time.Duration, l *limiter.MemoryLimiter) {
+		backends, config.Timeouts.Global, memoryLimiter = bs, global, l
+	}(backends, config.Timeouts.Global, memoryLimiter)
+	backends = []backend.Backend{&b}
+	config.Timeouts.Global = 100 * time.Millisecond
+	memoryLimiter = limiter.NewMemoryLimiter(1000)
+
+	rr := httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&from=0&until=86400&format=protobuf", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a render over the budget, got %d", rr.Code)
+	}
+
+	held, err := memoryLimiter.Reserve(context.Background(), 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&from=0&until=600&format=protobuf", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while the budget is used up, got %d", rr.Code)
+	}
+
+	held.Release()
+	rr = httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&from=0&until=600&format=protobuf", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 once the budget is released, got %d", rr.Code)
+	}
+	if used := memoryLimiter.Used(); used != 0 {
+		t.Errorf("expected the render to release its memory, %d bytes still used", used)
+	}
+}
+
+func TestRenderHandlerPointLimit(t *testing.T) {
+	b := mock.New(mock.Config{
+		Render: func(ctx context.Context, from, until int32, targets []string) ([]types.Metric, error) {
+			return []types.Metric{{
+				Name:     "foo",
+				Values:   make([]float64, 100),
+				IsAbsent: make([]bool, 100),
+			}}, nil
+		},
+	})
+
+	defer func(bs []backend.Backend, global time.Duration, points int) {
+		backends, config.Timeouts.Global, config.MaxRenderPoints = bs, global, points
+	}(backends, config.Timeouts.Global, config.MaxRenderPoints)
+	backends = []backend.Backend{&b}
+	config.Timeouts.Global = time.Second
+
+	config.MaxRenderPoints = 50
+	rr := httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&from=0&until=6000&format=protobuf", nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 over the point limit, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "result too large") {
+		t.Errorf("expected a result too large error, got %q", rr.Body.String())
+	}
+
+	config.MaxRenderPoints = 100
+	rr = httptest.NewRecorder()
+	renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&from=0&until=6000&format=protobuf", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 within the point limit, got %d", rr.Code)
+	}
+}
+
+func TestRenderHandlerBackendErrors(t *testing.T) {
+	failing := func(err error) backend.Backend {
+		b := mock.New(mock.Config{
+			Render: func(context.Context, int32, int32, []string) ([]types.Metric, error) {
+				return nil, err
+			},
+		})
+		return &b
+	}
+
+	defer func(bs []backend.Backend, global time.Duration) {
+		backends, config.Timeouts.Global = bs, global
+	}(backends, config.Timeouts.Global)
+	config.Timeouts.Global = time.Second
+
+	tests := []struct {
+		name string
+		errs []error
+		code int
+	}{
+		{"not found", []error{&backend.Error{Kind: backend.NotFound, Err: errors.New("no")}}, http.StatusNotFound},
+		{"throttled", []error{
+			&backend.Error{Kind: backend.Throttled, Err: errors.New("slow down")},
+			errors.Wrap(&backend.Error{Kind: backend.Throttled, Err: errors.New("busy")}, "HTTP call failed"),
+		}, http.StatusServiceUnavailable},
+		{"timeout", []error{context.DeadlineExceeded}, http.StatusGatewayTimeout},
+		{"mixed kinds", []error{
+			&backend.Error{Kind: backend.NotFound, Err: errors.New("no")},
+			context.DeadlineExceeded,
+		}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends = nil
+			for _, err := range tt.errs {
+				backends = append(backends, failing(err))
+			}
+
+			rr := httptest.NewRecorder()
+			renderHandler(rr, httptest.NewRequest("GET", "/render/?target=foo&from=0&until=60&format=protobuf", nil))
+			if rr.Code != tt.code {
+				t.Errorf("expected %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/bookingcom/carbonapi/limiter"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// memoryLimiter bounds the data held by the renders in flight. It is
+// replaced at startup when config.MemoryLimit.Budget is set.
+var memoryLimiter = limiter.NewMemoryLimiter(0)
+
+const (
+	// bytesPerPoint is the size of a datapoint in a types.Metric: a float64
+	// value and its absent flag.
+	bytesPerPoint = 9
+	// estimateStep is the resolution renders are assumed to have before the
+	// backends answer.
+	estimateStep = 60
+)
+
+// estimateRenderBytes guesses how much a render will hold before the metrics
+// are fetched, assuming one series per target.
+func estimateRenderBytes(targets []string, from, until int32) int64 {
+	points := int64(until-from)/estimateStep + 1
+	if points < 1 {
+		points = 1
+	}
+
+	var size int64
+	for _, target := range targets {
+		size += int64(len(target)) + points*bytesPerPoint
+	}
+
+	return size
+}
+
+// metricsBytes is how much fetched metrics hold.
+func metricsBytes(metrics []types.Metric) int64 {
+	var size int64
+	for _, m := range metrics {
+		size += int64(len(m.Name)) + int64(len(m.Values))*bytesPerPoint
+	}
+
+	return size
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/intern"
+)
+
+// rotateNames starts a new generation of the interned metric names every
+// interval. The path cache and the metric index intern the names they keep,
+// so a generation has to outlast both the cache entries and the index
+// refreshes for the names still in use to be kept.
+func rotateNames(names *intern.Pool, interval time.Duration) {
+	for range time.Tick(interval) {
+		names.Rotate()
+	}
+}
+
+// namesRotation returns the interval to rotate the interned names at.
+func namesRotation() time.Duration {
+	interval := time.Duration(config.ExpireDelaySec) * time.Second
+	if config.MetricIndex.Enabled && config.MetricIndex.RefreshInterval > interval {
+		interval = config.MetricIndex.RefreshInterval
+	}
+
+	return interval
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const defaultProbeInterval = 5 * time.Minute
+
+// Each backend is probed on its own schedule, every interval and up to a
+// jitter later, so that the backends aren't all probed at once. A backend
+// that fails its probe is probed again after twice as long each time, up to
+// the max backoff, so that backends that are down don't get probes piling up
+// on their timeouts.
+
+// probeSchedule is how often backends are probed.
+type probeSchedule struct {
+	interval   time.Duration
+	jitter     time.Duration
+	maxBackoff time.Duration
+}
+
+// newProbeSchedule returns the configured schedule. The jitter defaults to a
+// tenth of the interval, and the max backoff to 8 intervals.
+func newProbeSchedule() probeSchedule {
+	s := probeSchedule{
+		interval:   config.Probe.Interval,
+		jitter:     config.Probe.Jitter,
+		maxBackoff: config.Probe.MaxBackoff,
+	}
+	if s.interval <= 0 {
+		s.interval = defaultProbeInterval
+	}
+	if s.jitter <= 0 {
+		s.jitter = s.interval / 10
+	}
+	if s.maxBackoff < s.interval {
+		s.maxBackoff = 8 * s.interval
+	}
+
+	return s
+}
+
+// next returns how long to wait after a probe, given how many probes in a
+// row have failed.
+func (s probeSchedule) next(failures int) time.Duration {
+	d := s.interval
+	for i := 0; i < failures && d < s.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.maxBackoff {
+		d = s.maxBackoff
+	}
+
+	return d + time.Duration(rand.Int63n(int64(s.jitter)+1))
+}
+
+// lastProbes are the unix times, in nanoseconds, backends were last probed.
+// It is filled by trackProbes before the probes start, which only update the
+// times.
+var lastProbes = make(map[backend.Backend]*int64)
+
+func trackProbes(bs []backend.Backend) {
+	for _, b := range bs {
+		if _, ok := lastProbes[b]; !ok {
+			lastProbes[b] = new(int64)
+		}
+	}
+}
+
+// probe probes b, and tells if it failed. The top-level domains b was
+// probed for go into the index served to the probers of the zipper.
+func probe(b backend.Backend) error {
+	if last, ok := lastProbes[b]; ok {
+		defer func() { atomic.StoreInt64(last, time.Now().UnixNano()) }()
+	}
+
+	if p, ok := b.(backend.Prober); ok {
+		err := p.TryProbe()
+		if err == nil {
+			updateTLDIndex(backends)
+		}
+		return err
+	}
+	b.Probe()
+	updateTLDIndex(backends)
+
+	return nil
+}
+
+// runProbes probes each backend on the schedule, forever. The first probes
+// are spread over the jitter, past an interval if the backends were just
+// probed by the warmup.
+func runProbes(bs []backend.Backend, s probeSchedule, warmedUp bool, logger *zap.Logger) {
+	for _, b := range bs {
+		first := time.Duration(rand.Int63n(int64(s.jitter) + 1))
+		if warmedUp {
+			first = s.next(0)
+		}
+
+		go func(b backend.Backend) {
+			time.Sleep(first)
+
+			failures := 0
+			for {
+				if err := probe(b); err != nil {
+					failures++
+					logger.Warn("probe failed",
+						zap.String("host", backendHosts[b]),
+						zap.Int("failures", failures),
+						zap.Error(err),
+					)
+				} else {
+					failures = 0
+				}
+				time.Sleep(s.next(failures))
+			}
+		}(b)
+	}
+}
+
+var probeAgeDesc = prometheus.NewDesc(
+	"backend_last_probe_age_seconds",
+	"Seconds since the backend was last probed",
+	[]string{"backend"}, nil,
+)
+
+// probeAges collects the ages of the last probes of the backends.
+type probeAges struct{}
+
+// Describe implements prometheus.Collector.
+func (probeAges) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeAgeDesc
+}
+
+// Collect implements prometheus.Collector. Backends not probed yet are
+// left out.
+func (probeAges) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for b, last := range lastProbes {
+		t := atomic.LoadInt64(last)
+		if t == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(probeAgeDesc, prometheus.GaugeValue, now.Sub(time.Unix(0, t)).Seconds(), backendHosts[b])
+	}
+}
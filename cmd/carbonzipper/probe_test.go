@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProbeScheduleNext(t *testing.T) {
+	s := probeSchedule{
+		interval:   time.Minute,
+		jitter:     10 * time.Second,
+		maxBackoff: 5 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			if got := s.next(tt.failures); got < tt.want || got > tt.want+s.jitter {
+				t.Errorf("Expected %d failures to wait %v plus up to %v, got %v", tt.failures, tt.want, s.jitter, got)
+			}
+		}
+	}
+}
+
+type failingProber struct {
+	mock.Backend
+}
+
+func (failingProber) TryProbe() error {
+	return errors.New("unreachable")
+}
+
+func TestProbe(t *testing.T) {
+	defer func(l map[backend.Backend]*int64, h map[backend.Backend]string) {
+		lastProbes, backendHosts = l, h
+	}(lastProbes, backendHosts)
+
+	// Mock backends hold funcs, only pointers to them can be map keys.
+	mb := mock.New(mock.Config{})
+	ok := &mb
+	failing := &failingProber{mock.New(mock.Config{})}
+	lastProbes = make(map[backend.Backend]*int64)
+	backendHosts = map[backend.Backend]string{ok: "ok:8080", failing: "failing:8080"}
+	trackProbes([]backend.Backend{ok, failing})
+
+	if n := collectProbeAges(); n != 0 {
+		t.Errorf("Expected no ages before the backends are probed, got %d", n)
+	}
+
+	if err := probe(ok); err != nil {
+		t.Errorf("Expected the probe to succeed, got %v", err)
+	}
+	if err := probe(failing); err == nil {
+		t.Error("Expected the probe to fail")
+	}
+
+	if n := collectProbeAges(); n != 2 {
+		t.Errorf("Expected the ages of 2 backends, got %d", n)
+	}
+}
+
+func collectProbeAges() int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		probeAges{}.Collect(ch)
+		close(ch)
+	}()
+
+	n := 0
+	for range ch {
+		n++
+	}
+
+	return n
+}
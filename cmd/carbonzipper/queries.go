@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/pkg/inflight"
+)
+
+// queries are the requests being served, listed on /debug/queries of the
+// internal listener so that the ones loading the backends can be found, and
+// cancelled, while they run.
+var queries = inflight.NewRegistry()
+
+// trackQuery serves the requests of handler as queries. Their targets are
+// taken from the URL, v3 requests carry theirs in the body.
+func trackQuery(handler string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		args := req.URL.Query()
+		targets := append(args["target"], args["query"]...)
+
+		ctx, done := queries.Start(req.Context(), handler, targets)
+		defer done()
+
+		h(w, req.WithContext(ctx))
+	}
+}
+
+// queriesHandler lists the queries being served, oldest first.
+func queriesHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queries.List())
+}
+
+// cancelQueryHandler cancels the query with the ID given as id.
+func cancelQueryHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "cancel queries with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !queries.Cancel(req.FormValue("id")) {
+		http.Error(w, "no such query", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/inflight"
+)
+
+func TestQueriesHandlers(t *testing.T) {
+	started := make(chan struct{})
+	finished := make(chan error)
+	h := trackQuery("render", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-req.Context().Done()
+		finished <- req.Context().Err()
+	})
+	returned := make(chan struct{})
+	go func() {
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/render/?target=foo.bar&from=0&until=60", nil))
+		close(returned)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	queriesHandler(w, httptest.NewRequest("GET", "/debug/queries", nil))
+	var list []inflight.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Handler != "render" || len(list[0].Targets) != 1 || list[0].Targets[0] != "foo.bar" {
+		t.Fatalf("Expected the running render, got %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	cancelQueryHandler(w, httptest.NewRequest("GET", "/debug/queries/cancel?id="+list[0].ID, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	cancelQueryHandler(w, httptest.NewRequest("POST", "/debug/queries/cancel?id="+list[0].ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected the query to be cancelled, got %d", w.Code)
+	}
+	if err := <-finished; err == nil {
+		t.Error("Expected the render to see its cancellation")
+	}
+	<-returned
+
+	w = httptest.NewRecorder()
+	cancelQueryHandler(w, httptest.NewRequest("POST", "/debug/queries/cancel?id="+list[0].ID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected finished queries to be unknown, got %d", w.Code)
+	}
+}
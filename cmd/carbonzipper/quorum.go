@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+)
+
+// partialHeader names, on the responses of requests fewer failure domains
+// answered than the quorum requires, the domains that didn't.
+const partialHeader = "X-Carbonzipper-Partial"
+
+type quorumKey struct{}
+
+// quorum keeps track of which failure domains the backends asked for a
+// response are in, and whether any backend of each answered.
+type quorum struct {
+	mu       sync.Mutex
+	answered map[string]bool
+}
+
+func quorumFrom(ctx context.Context) *quorum {
+	q, _ := ctx.Value(quorumKey{}).(*quorum)
+	return q
+}
+
+func (q *quorum) answer(b backend.Backend, err error) {
+	domain := backendDomains[b]
+	if domain == "" {
+		return
+	}
+
+	q.mu.Lock()
+	q.answered[domain] = q.answered[domain] || err == nil
+	q.mu.Unlock()
+}
+
+// missing returns the domains asked that didn't answer, if fewer answered
+// than the quorum requires, and nil otherwise.
+func (q *quorum) missing() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var missing []string
+	for domain, answered := range q.answered {
+		if !answered {
+			missing = append(missing, domain)
+		}
+	}
+
+	required := config.Quorum.Domains
+	if required <= 0 || required > len(q.answered) {
+		required = len(q.answered)
+	}
+	if len(q.answered)-len(missing) >= required {
+		return nil
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+// withQuorum counts the failure domains that answer the backend requests
+// made for the requests to h, and marks its successful responses partial
+// when too few did.
+func withQuorum(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(backendDomains) == 0 {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		q := &quorum{answered: make(map[string]bool)}
+		ctx := context.WithValue(req.Context(), quorumKey{}, q)
+		h.ServeHTTP(&quorumWriter{ResponseWriter: w, q: q}, req.WithContext(ctx))
+	})
+}
+
+// quorumWriter sets the partial header once the response is written, when
+// all its backend requests are done.
+type quorumWriter struct {
+	http.ResponseWriter
+	q           *quorum
+	wroteHeader bool
+}
+
+func (w *quorumWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if missing := w.q.missing(); len(missing) > 0 && code < 300 {
+			w.Header().Set(partialHeader, strings.Join(missing, ","))
+			Metrics.PartialResponses.Add(1)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *quorumWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
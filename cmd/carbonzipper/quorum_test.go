@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+func TestQuorum(t *testing.T) {
+	found := func() backend.Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo", IsLeaf: true}}}, nil
+			},
+		})
+		return &b
+	}
+	failing := func() backend.Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{}, errors.New("no")
+			},
+		})
+		return &b
+	}
+
+	rack1a, rack1b, rack2, rack3, plain := found(), failing(), failing(), found(), failing()
+	defer func(domains map[backend.Backend]string, quorum int) {
+		backendDomains, config.Quorum.Domains = domains, quorum
+	}(backendDomains, config.Quorum.Domains)
+	backendDomains = map[backend.Backend]string{rack1a: "rack1", rack1b: "rack1", rack2: "rack2", rack3: "rack3"}
+
+	find := func(bs ...backend.Backend) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if _, err := findsByRegion(withFanOut(req.Context(), []string{"foo"}), [][]backend.Backend{bs}, "foo"); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte("ok"))
+		})
+	}
+
+	tests := []struct {
+		name     string
+		quorum   int
+		backends []backend.Backend
+		code     int
+		partial  string
+	}{
+		{"all domains answered", 0, []backend.Backend{rack1a, rack1b, rack3}, http.StatusOK, ""},
+		{"a domain failed", 0, []backend.Backend{rack1a, rack2, rack3}, http.StatusOK, "rack2"},
+		{"enough domains answered", 2, []backend.Backend{rack1a, rack2, rack3}, http.StatusOK, ""},
+		{"too few domains answered", 2, []backend.Backend{rack1b, rack2, rack3}, http.StatusOK, "rack1,rack2"},
+		{"backends without a domain", 0, []backend.Backend{rack1a, plain}, http.StatusOK, ""},
+		{"errors aren't partial", 0, []backend.Backend{rack1b, rack2}, http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Quorum.Domains = tt.quorum
+			rr := httptest.NewRecorder()
+			withQuorum(find(tt.backends...)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/find/?query=foo", nil))
+
+			if rr.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, rr.Code)
+			}
+			if got := rr.Header().Get(partialHeader); got != tt.partial {
+				t.Errorf("expected %s: %q, got %q", partialHeader, tt.partial, got)
+			}
+		})
+	}
+}
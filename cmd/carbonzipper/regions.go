@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// regionTiers splits backends into the ones in the local region, which are
+// always queried, and the remote ones, which are only queried when the local
+// region fails or has no data. Backends without a region count as local.
+// Without a configured local region all backends form a single tier.
+func regionTiers(bs []backend.Backend, regions map[backend.Backend]string, local string) [][]backend.Backend {
+	if local == "" {
+		return [][]backend.Backend{bs}
+	}
+
+	near := make([]backend.Backend, 0, len(bs))
+	far := make([]backend.Backend, 0)
+	for _, b := range bs {
+		if r := regions[b]; r == "" || r == local {
+			near = append(near, b)
+		} else {
+			far = append(far, b)
+		}
+	}
+
+	if len(near) == 0 {
+		return [][]backend.Backend{far}
+	}
+	if len(far) == 0 {
+		return [][]backend.Backend{near}
+	}
+
+	return [][]backend.Backend{near, far}
+}
+
+// withAlternates has the requests of the fan-out of ctx to tier i that
+// fail sent again to the other backends the path cache knows to hold paths.
+// Regions only order them: the holders in tier i that the fan-out doesn't
+// ask come first, then those of the tiers after it, the nearest first.
+// Without known holders failed requests aren't sent again, as no other
+// backend is known to have what the failed one had.
+func withAlternates(ctx context.Context, tiers [][]backend.Backend, i int, paths []string) context.Context {
+	if !config.FanOut.Failover {
+		return ctx
+	}
+
+	hosts := pathHosts(paths)
+	if hosts == nil {
+		return ctx
+	}
+
+	asked := make(map[backend.Backend]bool)
+	for _, b := range backend.Filter(tiers[i], paths) {
+		asked[b] = true
+	}
+
+	order := make([]int, 0, len(tiers))
+	order = append(order, i)
+	for j := range tiers {
+		if j != i {
+			order = append(order, j)
+		}
+	}
+
+	var alternates []backend.Backend
+	for _, j := range order {
+		for _, b := range tiers[j] {
+			if !asked[b] && hosts[backendHosts[b]] {
+				alternates = append(alternates, b)
+				asked[b] = true
+			}
+		}
+	}
+
+	f := backend.FanOutFrom(ctx)
+	f.Alternates = alternates
+
+	return backend.WithFanOut(ctx, f)
+}
+
+// The *ByRegion functions try each tier in turn and return the first
+// non-empty answer. An empty answer from a tier that succeeded wins over
+// errors from the tiers after it.
+
+func findsByRegion(ctx context.Context, tiers [][]backend.Backend, query string) (types.Matches, error) {
+	var (
+		result    types.Matches
+		err       error
+		succeeded bool
+	)
+
+	for i, bs := range tiers {
+		if i > 0 {
+			Metrics.RegionFallbacks.Add(1)
+		}
+
+		m, e := backend.Finds(withAlternates(ctx, tiers, i, []string{query}), backend.Filter(bs, []string{query}), query)
+		if e == nil && len(m.Matches) > 0 {
+			return m, nil
+		}
+
+		if e == nil {
+			result, err, succeeded = m, nil, true
+		} else if !succeeded {
+			err = e
+		}
+	}
+
+	return result, err
+}
+
+func rendersByRegion(ctx context.Context, tiers [][]backend.Backend, from int32, until int32, targets []string) ([]types.Metric, error) {
+	var (
+		result    []types.Metric
+		err       error
+		succeeded bool
+	)
+
+	for i, bs := range tiers {
+		if i > 0 {
+			Metrics.RegionFallbacks.Add(1)
+		}
+
+		m, e := backend.Renders(withAlternates(ctx, tiers, i, targets), backend.Filter(bs, targets), from, until, targets)
+		if e == nil && len(m) > 0 {
+			return m, nil
+		}
+		if _, ok := e.(*backend.TooLargeError); ok {
+			// Other regions have the same data, so it would be too large there too.
+			return nil, e
+		}
+
+		if e == nil {
+			result, err, succeeded = m, nil, true
+		} else if !succeeded {
+			err = e
+		}
+	}
+
+	return result, err
+}
+
+func infosByRegion(ctx context.Context, tiers [][]backend.Backend, target string) ([]types.Info, error) {
+	var (
+		result    []types.Info
+		err       error
+		succeeded bool
+	)
+
+	for i, bs := range tiers {
+		if i > 0 {
+			Metrics.RegionFallbacks.Add(1)
+		}
+
+		m, e := backend.Infos(withAlternates(ctx, tiers, i, []string{target}), backend.Filter(bs, []string{target}), target)
+		if e == nil && len(m) > 0 {
+			return m, nil
+		}
+
+		if e == nil {
+			result, err, succeeded = m, nil, true
+		} else if !succeeded {
+			err = e
+		}
+	}
+
+	return result, err
+}
+
+func aggregateByRegion(ctx context.Context, tiers [][]backend.Backend, from int32, until int32, glob string, agg string) (*types.Metric, error) {
+	var (
+		err       error
+		succeeded bool
+	)
+
+	for i, bs := range tiers {
+		if i > 0 {
+			Metrics.RegionFallbacks.Add(1)
+		}
+
+		m, e := backend.Aggregate(ctx, backend.Filter(bs, []string{glob}), from, until, glob, agg)
+		if e == nil && m != nil {
+			return m, nil
+		}
+
+		if e == nil {
+			err, succeeded = nil, true
+		} else if !succeeded {
+			err = e
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+func TestRegionTiers(t *testing.T) {
+	newBackend := func() backend.Backend {
+		b := mock.New(mock.Config{})
+		return &b
+	}
+
+	local, remote, plain := newBackend(), newBackend(), newBackend()
+	all := []backend.Backend{local, remote, plain}
+	regions := map[backend.Backend]string{local: "eu", remote: "us"}
+
+	if tiers := regionTiers(all, regions, ""); len(tiers) != 1 || len(tiers[0]) != 3 {
+		t.Errorf("expected a single tier without a local region, got %v", tiers)
+	}
+
+	tiers := regionTiers(all, regions, "eu")
+	if len(tiers) != 2 {
+		t.Fatalf("expected 2 tiers, got %d", len(tiers))
+	}
+	if len(tiers[0]) != 2 || tiers[0][0] != local || tiers[0][1] != plain {
+		t.Errorf("unexpected local tier %v", tiers[0])
+	}
+	if len(tiers[1]) != 1 || tiers[1][0] != remote {
+		t.Errorf("unexpected remote tier %v", tiers[1])
+	}
+
+	if tiers := regionTiers([]backend.Backend{remote}, regions, "eu"); len(tiers) != 1 || tiers[0][0] != remote {
+		t.Errorf("expected remote backends to be used when there are no local ones, got %v", tiers)
+	}
+}
+
+func TestFindsByRegion(t *testing.T) {
+	found := func(path string) backend.Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{Name: "foo", Matches: []types.Match{{Path: path, IsLeaf: true}}}, nil
+			},
+		})
+		return &b
+	}
+	empty := func() backend.Backend {
+		b := mock.New(mock.Config{})
+		return &b
+	}
+	failing := func() backend.Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{}, errors.New("no")
+			},
+		})
+		return &b
+	}
+
+	tests := []struct {
+		name    string
+		tiers   [][]backend.Backend
+		want    string
+		wantErr bool
+	}{
+		{"local has data", [][]backend.Backend{{found("local")}, {found("remote")}}, "local", false},
+		{"local empty", [][]backend.Backend{{empty()}, {found("remote")}}, "remote", false},
+		{"local fails", [][]backend.Backend{{failing()}, {found("remote")}}, "remote", false},
+		{"local empty, remote fails", [][]backend.Backend{{empty()}, {failing()}}, "", false},
+		{"all fail", [][]backend.Backend{{failing()}, {failing()}}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findsByRegion(context.Background(), tt.tiers, "foo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			path := ""
+			if len(got.Matches) > 0 {
+				path = got.Matches[0].Path
+			}
+			if path != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, path)
+			}
+		})
+	}
+}
+
+func TestFindsByRegionFailover(t *testing.T) {
+	found := func(path string, contains bool) backend.Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{Name: "failover.*", Matches: []types.Match{{Path: path, IsLeaf: true}}}, nil
+			},
+			Contains: func([]string) bool { return contains },
+		})
+		return &b
+	}
+	broken := mock.New(mock.Config{
+		Find: func(context.Context, string) (types.Matches, error) {
+			return types.Matches{}, errors.New("no")
+		},
+	})
+	near, far, spare := found("failover.near", true), found("failover.far", true), found("failover.spare", false)
+
+	defer func(failover bool, hosts map[backend.Backend]string) {
+		config.FanOut.Failover, backendHosts = failover, hosts
+	}(config.FanOut.Failover, backendHosts)
+	backendHosts = map[backend.Backend]string{
+		&broken: "broken:8080",
+		near:    "near:8080",
+		far:     "far:8080",
+		spare:   "spare:8080",
+	}
+
+	find := func(tiers [][]backend.Backend) types.Matches {
+		t.Helper()
+		got, err := findsByRegion(withFanOut(context.Background(), []string{"failover.*"}), tiers, "failover.*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	tiers := [][]backend.Backend{{near, &broken}, {far}}
+	config.PathCache.Set("failover.*", []string{"broken:8080", "far:8080"})
+
+	if got := find(tiers); len(got.Matches) != 1 {
+		t.Errorf("expected only the near match without failover, got %v", got.Matches)
+	}
+
+	config.FanOut.Failover = true
+	before := Metrics.Failovers.Get("broken:8080")
+	if got := find(tiers); len(got.Matches) != 2 {
+		t.Errorf("expected the far holder to answer for the broken one, got %v", got.Matches)
+	}
+	if after := Metrics.Failovers.Get("broken:8080"); after == nil || (before != nil && after.String() == before.String()) {
+		t.Errorf("expected the failover to be counted, got %v", after)
+	}
+
+	// Without regions, a holder the fan-out doesn't ask answers instead.
+	config.PathCache.Set("failover.*", []string{"broken:8080", "spare:8080"})
+	got := find([][]backend.Backend{{near, &broken, spare}})
+	paths := make(map[string]bool)
+	for _, m := range got.Matches {
+		paths[m.Path] = true
+	}
+	if len(got.Matches) != 2 || !paths["failover.spare"] {
+		t.Errorf("expected the spare holder to answer for the broken one, got %v", got.Matches)
+	}
+
+	// Backends the path cache doesn't know to hold the paths aren't asked.
+	config.PathCache.Set("failover.*", []string{"broken:8080"})
+	if got := find(tiers); len(got.Matches) != 1 {
+		t.Errorf("expected no failover to a backend not known to hold the paths, got %v", got.Matches)
+	}
+}
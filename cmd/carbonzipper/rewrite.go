@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/bookingcom/carbonapi/cfg"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+)
+
+// newRewrite returns the path rewriting of a backend group, nil if it has
+// none.
+func newRewrite(rules []cfg.PathRewrite) (*bnet.Rewrite, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	rs := make([]bnet.RewriteRule, 0, len(rules))
+	for _, r := range rules {
+		rs = append(rs, bnet.RewriteRule{
+			StripPrefix:        r.StripPrefix,
+			AddPrefix:          r.AddPrefix,
+			Regex:              r.Regex,
+			Replacement:        r.Replacement,
+			ReverseRegex:       r.ReverseRegex,
+			ReverseReplacement: r.ReverseReplacement,
+		})
+	}
+
+	return bnet.NewRewrite(rs)
+}
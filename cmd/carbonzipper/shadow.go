@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// A part of the renders and finds can be mirrored to a canary backend
+// group, e.g. stores running a new go-carbon or a zipper of a new build,
+// which gets no other traffic. Mirrored requests are sent once the
+// production one is answered and are never waited for. Some of their
+// responses are compared to the production ones, and differences logged.
+
+// shadowBackends is the canary group requests are mirrored to.
+var shadowBackends []backend.Backend
+
+// takeShadowGroup takes the named group out of the production backends and
+// returns its backends.
+func takeShadowGroup(name string, logger *zap.Logger) []backend.Backend {
+	canary, ok := backendGroups[name]
+	if !ok {
+		logger.Fatal("Unknown shadow backend group", zap.String("group", name))
+	}
+	delete(backendGroups, name)
+
+	isCanary := make(map[backend.Backend]bool, len(canary))
+	for _, b := range canary {
+		isCanary[b] = true
+	}
+	for group, bs := range backendGroups {
+		for _, b := range bs {
+			if isCanary[b] {
+				logger.Fatal("Shadow backends must not be in other groups",
+					zap.String("host", backendHosts[b]),
+					zap.String("group", group),
+				)
+			}
+		}
+	}
+	for _, host := range config.Backends {
+		for _, b := range canary {
+			if backendHosts[b] == host {
+				logger.Fatal("Shadow backends must not be in backends", zap.String("host", host))
+			}
+		}
+	}
+
+	production := make([]backend.Backend, 0, len(backends))
+	for _, b := range backends {
+		if !isCanary[b] {
+			production = append(production, b)
+		}
+	}
+	backends = production
+
+	return canary
+}
+
+// sampled reports whether an event with the given chance, in percent,
+// happens.
+func sampled(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// detached keeps the values of a context, e.g. the request UUID, without its
+// deadline and cancellation, which end with the production request.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// mirror runs call against the canary group in the background for a
+// sample of requests.
+func mirror(ctx context.Context, call func(context.Context, []backend.Backend)) {
+	if len(shadowBackends) == 0 || !sampled(config.Shadow.Percent) {
+		return
+	}
+
+	timeout := config.Shadow.Timeout
+	if timeout <= 0 {
+		timeout = config.Timeouts.Global
+	}
+
+	Metrics.ShadowRequests.Add(1)
+	go func() {
+		ctx, cancel := context.WithTimeout(detached{ctx}, timeout)
+		defer cancel()
+
+		call(ctx, shadowBackends)
+	}()
+}
+
+func shadowLogger(ctx context.Context, handler string) *zap.Logger {
+	return zapwriter.Logger("shadow").With(
+		zap.String("handler", handler),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+}
+
+// mirrorRender mirrors a render answered with metrics.
+func mirrorRender(ctx context.Context, from int32, until int32, targets []string, metrics []types.Metric) {
+	mirror(ctx, func(ctx context.Context, bs []backend.Backend) {
+		got, err := backend.Renders(ctx, bs, from, until, targets)
+		if err != nil {
+			Metrics.ShadowErrors.Add(1)
+			shadowLogger(ctx, "render").Warn("mirrored request failed",
+				zap.Strings("targets", targets),
+				zap.Error(err),
+			)
+			return
+		}
+
+		if !sampled(config.Shadow.DiffPercent) {
+			return
+		}
+		if missing, extra, differing := diffMetrics(metrics, got); len(missing)+len(extra)+len(differing) > 0 {
+			Metrics.ShadowDiffs.Add(1)
+			shadowLogger(ctx, "render").Warn("mirrored response differs",
+				zap.Strings("targets", targets),
+				zap.Strings("missing", missing),
+				zap.Strings("extra", extra),
+				zap.Strings("differing", differing),
+			)
+		}
+	})
+}
+
+// mirrorFind mirrors a find answered with matches.
+func mirrorFind(ctx context.Context, query string, matches types.Matches) {
+	mirror(ctx, func(ctx context.Context, bs []backend.Backend) {
+		got, err := backend.Finds(ctx, bs, query)
+		if err != nil {
+			Metrics.ShadowErrors.Add(1)
+			shadowLogger(ctx, "find").Warn("mirrored request failed",
+				zap.String("query", query),
+				zap.Error(err),
+			)
+			return
+		}
+
+		if !sampled(config.Shadow.DiffPercent) {
+			return
+		}
+		if missing, extra := diffMatches(matches, got); len(missing)+len(extra) > 0 {
+			Metrics.ShadowDiffs.Add(1)
+			shadowLogger(ctx, "find").Warn("mirrored response differs",
+				zap.String("query", query),
+				zap.Strings("missing", missing),
+				zap.Strings("extra", extra),
+			)
+		}
+	})
+}
+
+// diffMetrics returns the names of the series of want missing from got,
+// those got has in addition, and those whose points differ.
+func diffMetrics(want, got []types.Metric) (missing, extra, differing []string) {
+	byName := make(map[string]types.Metric, len(got))
+	for _, m := range got {
+		byName[m.Name] = m
+	}
+
+	for _, w := range want {
+		g, ok := byName[w.Name]
+		if !ok {
+			missing = append(missing, w.Name)
+			continue
+		}
+		delete(byName, w.Name)
+
+		if !samePoints(w, g) {
+			differing = append(differing, w.Name)
+		}
+	}
+
+	for name := range byName {
+		extra = append(extra, name)
+	}
+
+	return missing, extra, differing
+}
+
+func samePoints(a, b types.Metric) bool {
+	if a.StartTime != b.StartTime || a.StepTime != b.StepTime || len(a.Values) != len(b.Values) {
+		return false
+	}
+
+	for i := range a.Values {
+		aAbsent, bAbsent := absent(a, i), absent(b, i)
+		if aAbsent != bAbsent || !aAbsent && a.Values[i] != b.Values[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func absent(m types.Metric, i int) bool {
+	return i < len(m.IsAbsent) && m.IsAbsent[i] || math.IsNaN(m.Values[i])
+}
+
+// diffMatches returns the paths of want missing from got, and those got has
+// in addition.
+func diffMatches(want, got types.Matches) (missing, extra []string) {
+	paths := make(map[string]bool, len(got.Matches))
+	for _, m := range got.Matches {
+		paths[m.Path] = true
+	}
+
+	for _, w := range want.Matches {
+		if !paths[w.Path] {
+			missing = append(missing, w.Path)
+		}
+		delete(paths, w.Path)
+	}
+
+	for p := range paths {
+		extra = append(extra, p)
+	}
+
+	return missing, extra
+}
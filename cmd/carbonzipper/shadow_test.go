@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestDiffMetrics(t *testing.T) {
+	series := func(name string, values ...float64) types.Metric {
+		return types.Metric{Name: name, StepTime: 60, Values: values, IsAbsent: make([]bool, len(values))}
+	}
+
+	want := []types.Metric{series("same", 1, 2), series("changed", 1, 2), series("gone", 1)}
+	got := []types.Metric{series("same", 1, 2), series("changed", 1, 3), series("new", 1)}
+
+	missing, extra, differing := diffMetrics(want, got)
+	if !reflect.DeepEqual(missing, []string{"gone"}) || !reflect.DeepEqual(extra, []string{"new"}) || !reflect.DeepEqual(differing, []string{"changed"}) {
+		t.Errorf("unexpected diff: missing %v, extra %v, differing %v", missing, extra, differing)
+	}
+}
+
+func TestDiffMatches(t *testing.T) {
+	want := types.Matches{Matches: []types.Match{{Path: "a"}, {Path: "b"}}}
+	got := types.Matches{Matches: []types.Match{{Path: "b"}, {Path: "c"}}}
+
+	missing, extra := diffMatches(want, got)
+	sort.Strings(extra)
+	if !reflect.DeepEqual(missing, []string{"a"}) || !reflect.DeepEqual(extra, []string{"c"}) {
+		t.Errorf("unexpected diff: missing %v, extra %v", missing, extra)
+	}
+}
+
+func TestMirrorFind(t *testing.T) {
+	queried := make(chan string, 1)
+	canary := mock.New(mock.Config{
+		Find: func(ctx context.Context, query string) (types.Matches, error) {
+			queried <- query
+			return types.Matches{Name: query, Matches: []types.Match{{Path: "foo.new"}}}, nil
+		},
+	})
+
+	defer func(bs []backend.Backend, shadow cfg.ShadowConfig) {
+		shadowBackends, config.Shadow = bs, shadow
+	}(shadowBackends, config.Shadow)
+	shadowBackends = []backend.Backend{canary}
+	config.Shadow = cfg.ShadowConfig{Percent: 100, DiffPercent: 100}
+
+	// The production request is over before the mirrored one runs.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	diffs := Metrics.ShadowDiffs.Value()
+	mirrorFind(ctx, "foo.*", types.Matches{Matches: []types.Match{{Path: "foo.old"}}})
+
+	select {
+	case q := <-queried:
+		if q != "foo.*" {
+			t.Errorf("expected foo.* to be mirrored, got %q", q)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the find to be mirrored")
+	}
+
+	for deadline := time.Now().Add(time.Second); Metrics.ShadowDiffs.Value() == diffs; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the difference to be counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"go.uber.org/zap"
+)
+
+// loadPathCache fills the path cache with the snapshot the last zipper to
+// stop saved, so that it doesn't start cold.
+func loadPathCache(logger *zap.Logger) {
+	if config.Shutdown.PathCacheFile == "" {
+		return
+	}
+
+	if err := config.PathCache.Load(config.Shutdown.PathCacheFile); err != nil {
+		logger.Warn("failed to load the path cache",
+			zap.String("path", config.Shutdown.PathCacheFile),
+			zap.Error(err),
+		)
+		return
+	}
+
+	logger.Info("loaded the path cache",
+		zap.String("path", config.Shutdown.PathCacheFile),
+		zap.Int("items", config.PathCache.ECItems()),
+	)
+}
+
+// shutdownHooks are run once the zipper has drained. They save the path
+// cache and the popular finds, and close the connections of the clients to
+// the backends.
+func shutdownHooks(clients map[cfg.Transport]*http.Client, logger *zap.Logger) []func() {
+	return []func(){
+		func() {
+			if config.Shutdown.PathCacheFile == "" {
+				return
+			}
+			if err := config.PathCache.Save(config.Shutdown.PathCacheFile); err != nil {
+				logger.Error("failed to save the path cache",
+					zap.String("path", config.Shutdown.PathCacheFile),
+					zap.Error(err),
+				)
+			}
+		},
+		func() {
+			savePopularFinds(logger)
+		},
+		func() {
+			for _, client := range clients {
+				client.CloseIdleConnections()
+			}
+		},
+	}
+}
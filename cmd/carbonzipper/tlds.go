@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/tlds"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// tldHistory is how many generations of changes to its top-level domains
+// the zipper keeps, for the probers that are that far behind.
+const tldHistory = 64
+
+// tldIndex is the top-level domains of all the backends, which the probers
+// in front of the zipper fetch the deltas of from /metrics/tlds/.
+var tldIndex = tlds.NewIndex(tldHistory)
+
+// tldIndexMu keeps the backends probed concurrently from updating the index
+// with domains listed before those of the last update.
+var tldIndexMu sync.Mutex
+
+type tldLister interface {
+	TLDs() []string
+}
+
+// updateTLDIndex updates the index with the top-level domains the backends
+// were last probed for.
+func updateTLDIndex(bs []backend.Backend) {
+	tldIndexMu.Lock()
+	defer tldIndexMu.Unlock()
+
+	var all []string
+	for _, b := range bs {
+		if l, ok := b.(tldLister); ok {
+			all = append(all, l.TLDs()...)
+		}
+	}
+
+	tldIndex.Update(all)
+}
+
+// tldsHandler serves the delta of the top-level domains since the
+// generation in the since parameter.
+func tldsHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "tlds"),
+		zap.String("carbonapi_uuid", util.GetUUID(req.Context())),
+	)
+
+	var since uint64
+	if s := req.FormValue("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, "bad since: "+err.Error(), http.StatusBadRequest)
+			accessLogger.Error("request failed",
+				zap.Int("http_code", http.StatusBadRequest),
+				zap.Error(err),
+			)
+			Metrics.Errors.Add(1)
+			prometheusMetrics.Responses.WithLabelValues("400", "tlds").Inc()
+			return
+		}
+	}
+
+	blob, err := json.Marshal(tldIndex.Since(since))
+	if err != nil {
+		http.Error(w, "error marshaling data", http.StatusInternalServerError)
+		accessLogger.Error("request failed",
+			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues("500", "tlds").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(blob)
+
+	accessLogger.Info("request served",
+		zap.Uint64("since", since),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", "tlds").Inc()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/tlds"
+)
+
+type tldBackend struct {
+	mock.Backend
+	tlds []string
+}
+
+func (b *tldBackend) TLDs() []string {
+	return b.tlds
+}
+
+func TestTLDsHandler(t *testing.T) {
+	defer func(idx *tlds.Index) { tldIndex = idx }(tldIndex)
+	tldIndex = tlds.NewIndex(tldHistory)
+
+	a := &tldBackend{Backend: mock.New(mock.Config{}), tlds: []string{"carbon", "servers"}}
+	b := &tldBackend{Backend: mock.New(mock.Config{}), tlds: []string{"servers"}}
+	updateTLDIndex([]backend.Backend{a, b})
+
+	get := func(since string) tlds.Delta {
+		rr := httptest.NewRecorder()
+		tldsHandler(rr, httptest.NewRequest("GET", "/metrics/tlds/?format=json&since="+since, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+		var d tlds.Delta
+		if err := json.Unmarshal(rr.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	full := get("0")
+	if !full.Full || !reflect.DeepEqual(full.Added, []string{"carbon", "servers"}) {
+		t.Errorf("Expected all the domains, got %+v", full)
+	}
+
+	a.tlds = []string{"hosts"}
+	updateTLDIndex([]backend.Backend{a, b})
+	delta := get(strconv.FormatUint(full.Generation, 10))
+	if delta.Full || !reflect.DeepEqual(delta.Added, []string{"hosts"}) || !reflect.DeepEqual(delta.Removed, []string{"carbon"}) {
+		t.Errorf("Expected hosts added and carbon removed, got %+v", delta)
+	}
+
+	rr := httptest.NewRecorder()
+	tldsHandler(rr, httptest.NewRequest("GET", "/metrics/tlds/?since=x", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad generation, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// tombstones holds the metrics the backends deleted recently. Backends may
+// find them until their files are gone, and the metric index lists them
+// until its next refresh; finds leave them out meanwhile.
+var tombstones = struct {
+	sync.RWMutex
+	paths map[string]struct{}
+}{}
+
+// deleted tells if path or one of its parents was deleted.
+func deleted(path string) bool {
+	tombstones.RLock()
+	defer tombstones.RUnlock()
+
+	if len(tombstones.paths) == 0 {
+		return false
+	}
+	for {
+		if _, ok := tombstones.paths[path]; ok {
+			return true
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
+
+// dropDeleted removes the deleted metrics from the matches of a find.
+func dropDeleted(matches types.Matches) types.Matches {
+	kept := matches.Matches[:0]
+	for _, m := range matches.Matches {
+		if !deleted(m.Path) {
+			kept = append(kept, m)
+		}
+	}
+	matches.Matches = kept
+
+	return matches
+}
+
+// refreshTombstones fetches the deleted metrics of all backends. The old
+// ones are kept if any backend fails, as the metrics it deleted would show
+// up again otherwise.
+func refreshTombstones(ctx context.Context, bs []backend.Backend) error {
+	type result struct {
+		paths []string
+		err   error
+	}
+
+	results := make(chan result, len(bs))
+	tombstoners := 0
+	for _, b := range bs {
+		t, ok := b.(backend.Tombstoner)
+		if !ok {
+			continue
+		}
+		tombstoners++
+
+		go func(t backend.Tombstoner) {
+			paths, err := t.Tombstones(ctx)
+			results <- result{paths, err}
+		}(t)
+	}
+
+	paths := make(map[string]struct{})
+	var errs []error
+	for i := 0; i < tombstoners; i++ {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		for _, p := range r.paths {
+			paths[p] = struct{}{}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("%d of %d backends failed to list their tombstones, first error: %v", len(errs), tombstoners, errs[0])
+	}
+
+	tombstones.Lock()
+	tombstones.paths = paths
+	tombstones.Unlock()
+
+	return nil
+}
+
+// tombstoneList returns the deleted metrics, sorted.
+func tombstoneList() []string {
+	tombstones.RLock()
+	defer tombstones.RUnlock()
+
+	paths := make([]string, 0, len(tombstones.paths))
+	for p := range tombstones.paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func runTombstones(bs []backend.Backend, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := refreshTombstones(ctx, bs)
+		cancel()
+
+		if err != nil {
+			logger.Warn("failed to refresh tombstones",
+				zap.Error(err),
+			)
+		} else {
+			logger.Debug("refreshed tombstones",
+				zap.Int("metrics", len(tombstoneList())),
+			)
+		}
+
+		<-ticker.C
+	}
+}
+
+// tombstonesHandler serves the metrics the backends deleted, so that the
+// zippers and carbonapis in front of this one leave them out too.
+func tombstonesHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+
+	format := req.FormValue("format")
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "tombstones"),
+		zap.String("format", format),
+		zap.String("carbonapi_uuid", util.GetUUID(req.Context())),
+	)
+
+	paths := tombstoneList()
+
+	var contentType string
+	var blob []byte
+	var err error
+	switch format {
+	case formatTypeProtobuf, formatTypeProtobuf3:
+		contentType = contentTypeProtobuf
+		blob, err = carbonapi_v2.ListEncoder(paths)
+	case formatTypeEmpty, formatTypeJSON:
+		contentType = contentTypeJSON
+		blob, err = json.Marshal(paths)
+	default:
+		err = errors.Errorf("Unknown format %s", format)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		prometheusMetrics.Responses.WithLabelValues("400", "tombstones").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(blob)
+
+	accessLogger.Info("request served",
+		zap.Int("metrics", len(paths)),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", "tombstones").Inc()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+func tombstoning(paths ...string) backend.Backend {
+	b := mock.New(mock.Config{
+		Tombstones: func(context.Context) ([]string, error) {
+			return paths, nil
+		},
+		List: func(context.Context) ([]string, error) {
+			return []string{"foo.bar", "foo.baz", "old.a", "old.b"}, nil
+		},
+	})
+	return &b
+}
+
+func TestRefreshTombstones(t *testing.T) {
+	defer func() { tombstones.paths = nil }()
+
+	failing := mock.New(mock.Config{
+		Tombstones: func(context.Context) ([]string, error) {
+			return nil, errors.New("no")
+		},
+	})
+
+	err := refreshTombstones(context.Background(), []backend.Backend{tombstoning("foo.baz"), tombstoning("old")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tombstoneList(), []string{"foo.baz", "old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected tombstones %v, got %v", want, got)
+	}
+
+	err = refreshTombstones(context.Background(), []backend.Backend{tombstoning(), &failing})
+	if err == nil {
+		t.Error("expected an error when a backend fails")
+	}
+	if got := tombstoneList(); len(got) != 2 {
+		t.Errorf("expected the old tombstones to be kept, got %v", got)
+	}
+
+	for path, want := range map[string]bool{
+		"foo.baz":    true,
+		"foo.bar":    false,
+		"foo":        false,
+		"old":        true,
+		"old.a":      true,
+		"old.a.b":    true,
+		"older.a":    false,
+		"foo.bazaar": false,
+	} {
+		if got := deleted(path); got != want {
+			t.Errorf("expected deleted(%q) to be %v", path, want)
+		}
+	}
+
+	matches := dropDeleted(types.Matches{
+		Name: "*.*",
+		Matches: []types.Match{
+			{Path: "foo.bar", IsLeaf: true},
+			{Path: "foo.baz", IsLeaf: true},
+			{Path: "old.a", IsLeaf: true},
+		},
+	})
+	if len(matches.Matches) != 1 || matches.Matches[0].Path != "foo.bar" {
+		t.Errorf("expected only foo.bar to be kept, got %v", matches.Matches)
+	}
+}
+
+func TestIndexLeavesOutTombstones(t *testing.T) {
+	defer func() {
+		tombstones.paths = nil
+		metricIndex.idx = nil
+	}()
+
+	bs := []backend.Backend{tombstoning("foo.baz", "old")}
+	if err := refreshTombstones(context.Background(), bs); err != nil {
+		t.Fatal(err)
+	}
+	if err := refreshIndex(context.Background(), bs); err != nil {
+		t.Fatal(err)
+	}
+	if n := currentIndex().Len(); n != 1 {
+		t.Errorf("expected only foo.bar in the index, got %d names", n)
+	}
+
+	tombstones.paths = map[string]struct{}{"foo.bar": {}}
+	if got := search(currentIndex(), "foo", 10); len(got) != 0 {
+		t.Errorf("expected metrics deleted after the index was built not to be found, got %v", got)
+	}
+}
+
+func TestTombstonesHandler(t *testing.T) {
+	defer func() { tombstones.paths = nil }()
+	tombstones.paths = map[string]struct{}{"b": {}, "a": {}}
+
+	rr := httptest.NewRecorder()
+	tombstonesHandler(rr, httptest.NewRequest("GET", "/metrics/tombstones/?format=json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if expected := `["a","b"]`; rr.Body.String() != expected {
+		t.Errorf("expected %s, got %s", expected, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	tombstonesHandler(rr, httptest.NewRequest("GET", "/metrics/tombstones/?format=xml", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown format, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/dnscache"
+
+	"go.uber.org/zap"
+)
+
+// resolver caches the lookups of backend hostnames when config.DNSCache
+// enables it.
+var resolver *dnscache.Resolver
+
+// newClient creates the client backends with the given transport settings
+// share.
+func newClient(t cfg.Transport) *http.Client {
+	dial := (&net.Dialer{
+		Timeout:   config.Timeouts.Connect,
+		KeepAlive: config.KeepAliveInterval,
+		DualStack: true,
+	}).DialContext
+	if resolver != nil {
+		dial = resolver.DialContext(dial)
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     t.MaxConnsPerHost,
+			IdleConnTimeout:     t.IdleConnTimeout,
+			ForceAttemptHTTP2:   t.ForceAttemptHTTP2,
+			DisableCompression:  t.DisableCompression,
+			DialContext:         dial,
+		},
+	}
+}
+
+func refreshDNS(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		resolver.Refresh(ctx)
+		cancel()
+	}
+}
+
+// prewarm opens connections to all backends at once, so the first renders
+// after a restart don't wait for them. Failures are only logged: a backend
+// down now may be up by the time requests come.
+func prewarm(ctx context.Context, conns map[*bnet.Backend]int, logger *zap.Logger) {
+	var wg sync.WaitGroup
+	for b, n := range conns {
+		if n > config.MaxIdleConnsPerHost {
+			// More would be closed as soon as they are idle.
+			n = config.MaxIdleConnsPerHost
+		}
+		if n <= 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(b *bnet.Backend, n int) {
+			defer wg.Done()
+			if err := b.Prewarm(ctx, n); err != nil {
+				logger.Warn("failed to prewarm backend connections",
+					zap.Error(err),
+				)
+			}
+		}(b, n)
+	}
+	wg.Wait()
+}
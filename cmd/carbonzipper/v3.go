@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/filter"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// The /api/v3/ handlers take carbonapi_v3 protocol buffer requests POSTed
+// by carbonapi or another zipper, so that zippers can be stacked without
+// going through the query strings and lossy responses of version 2.
+
+// v3Request reads the body of a version 3 request, answering the request
+// itself if it can't.
+func v3Request(w http.ResponseWriter, req *http.Request, accessLogger *zap.Logger, handler string, t0 time.Time) ([]byte, bool) {
+	if req.Method != http.MethodPost {
+		v3Error(w, accessLogger, handler, t0, http.StatusMethodNotAllowed, "only POST is supported", nil)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		v3Error(w, accessLogger, handler, t0, http.StatusBadRequest, "failed to read request", err)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// v3Error answers a version 3 request with an error, and logs and counts it.
+func v3Error(w http.ResponseWriter, accessLogger *zap.Logger, handler string, t0 time.Time, code int, reason string, err error) {
+	http.Error(w, reason, code)
+	accessLogger.Error("request failed",
+		zap.String("reason", reason),
+		zap.Int("http_code", code),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+		zap.Error(err),
+	)
+	Metrics.Errors.Add(1)
+	prometheusMetrics.Responses.WithLabelValues(fmt.Sprintf("%d", code), handler).Inc()
+}
+
+func v3Response(w http.ResponseWriter, blob []byte, accessLogger *zap.Logger, handler string, t0 time.Time) {
+	w.Header().Set("Content-Type", contentTypeCarbonAPIv3PB)
+	w.Write(blob)
+
+	accessLogger.Info("request served",
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+
+	Metrics.Responses.Add(1)
+	prometheusMetrics.Responses.WithLabelValues("200", handler).Inc()
+}
+
+func findV3Handler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(req.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+	Metrics.FindRequests.Add(1)
+
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "find_v3"),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+
+	body, ok := v3Request(w, req, accessLogger, "find_v3", t0)
+	if !ok {
+		return
+	}
+	globs, err := carbonapi_v3.FindRequestDecoder(body)
+	if err != nil || len(globs) == 0 {
+		v3Error(w, accessLogger, "find_v3", t0, http.StatusBadRequest, "bad or empty find request", err)
+		return
+	}
+	accessLogger = accessLogger.With(zap.Strings("targets", globs))
+
+	bs, err := selectBackends(req, backends, backendGroups)
+	if err != nil {
+		v3Error(w, accessLogger, "find_v3", t0, http.StatusBadRequest, "invalid backend group selection", err)
+		return
+	}
+	tiers := regionTiers(bs, backendRegions, config.Region)
+
+	responses := make([]types.Matches, 0, len(globs))
+	for _, glob := range globs {
+		metrics, ok := findInIndex(req, glob)
+		if !ok {
+			metrics, err = findExpanded(ctx, tiers, glob)
+			if _, ok := err.(*expansionError); ok {
+				v3Error(w, accessLogger, "find_v3", t0, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+			if err != nil {
+				v3Error(w, accessLogger, "find_v3", t0, backendErrorCode(err), "error fetching the data", err)
+				return
+			}
+		}
+		metrics = dropDeleted(metrics)
+		metrics.Name = glob
+		sort.Slice(metrics.Matches, func(i, j int) bool {
+			return metrics.Matches[i].Path < metrics.Matches[j].Path
+		})
+		responses = append(responses, metrics)
+	}
+
+	blob, err := carbonapi_v3.FindEncoder(responses)
+	if err != nil {
+		v3Error(w, accessLogger, "find_v3", t0, http.StatusInternalServerError, "error marshaling data", err)
+		return
+	}
+
+	v3Response(w, blob, accessLogger, "find_v3", t0)
+}
+
+func renderV3Handler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(req.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+	Metrics.RenderRequests.Add(1)
+
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "render_v3"),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+
+	body, ok := v3Request(w, req, accessLogger, "render_v3", t0)
+	if !ok {
+		return
+	}
+	requests, err := carbonapi_v3.RenderRequestDecoder(body)
+	if err != nil || len(requests) == 0 {
+		v3Error(w, accessLogger, "render_v3", t0, http.StatusBadRequest, "bad or empty render request", err)
+		return
+	}
+
+	// Targets of a version 3 request each have their own time range and
+	// filtering functions, the backends are asked for those sharing them at
+	// once. Targets with an aggregating function are aggregated one by one.
+	type timeRange struct {
+		from, until int32
+		filters     string
+		agg         string
+	}
+	var ranges []timeRange
+	targets := make(map[timeRange][]string)
+	filters := make(map[timeRange][]types.FilterFunction)
+	var estimate int64
+	var names []string
+	for _, r := range requests {
+		if r.Name == "" {
+			v3Error(w, accessLogger, "render_v3", t0, http.StatusBadRequest, "empty target", nil)
+			return
+		}
+		var agg string
+		if len(r.Filters) == 1 {
+			agg, _ = aggregate.Of(r.Filters[0])
+		}
+		if err := filter.Check(r.Filters); agg == "" && err != nil {
+			v3Error(w, accessLogger, "render_v3", t0, http.StatusBadRequest, "bad filtering function", err)
+			return
+		}
+		tr := timeRange{r.From, r.Until, fmt.Sprint(r.Filters), agg}
+		if _, ok := targets[tr]; !ok {
+			ranges = append(ranges, tr)
+			filters[tr] = r.Filters
+		}
+		targets[tr] = append(targets[tr], r.Name)
+		estimate += estimateRenderBytes([]string{r.Name}, r.From, r.Until)
+		names = append(names, r.Name)
+	}
+	accessLogger = accessLogger.With(zap.Strings("targets", names))
+
+	bs, err := selectBackends(req, backends, backendGroups)
+	if err != nil {
+		v3Error(w, accessLogger, "render_v3", t0, http.StatusBadRequest, "invalid backend group selection", err)
+		return
+	}
+
+	queueCtx := ctx
+	if config.MemoryLimit.QueueTimeout > 0 {
+		var queueCancel context.CancelFunc
+		queueCtx, queueCancel = context.WithTimeout(ctx, config.MemoryLimit.QueueTimeout)
+		defer queueCancel()
+	}
+	reservation, err := memoryLimiter.Reserve(queueCtx, estimate)
+	if err != nil {
+		Metrics.MemoryRejects.Add(1)
+		v3Error(w, accessLogger, "render_v3", t0, http.StatusServiceUnavailable, "memory budget exceeded", err)
+		return
+	}
+	defer reservation.Release()
+
+	if config.MaxRenderPoints > 0 {
+		ctx = backend.WithPointLimit(ctx, config.MaxRenderPoints)
+	}
+
+	tiers := regionTiers(bs, backendRegions, config.Region)
+	var metrics []types.Metric
+	for _, tr := range ranges {
+		if tr.agg != "" {
+			for _, glob := range targets[tr] {
+				m, err := aggregateByRegion(withFanOut(ctx, nil), tiers, tr.from, tr.until, glob, tr.agg)
+				if err != nil {
+					v3Error(w, accessLogger, "render_v3", t0, backendErrorCode(err), "error aggregating the data", err)
+					return
+				}
+				if m != nil {
+					metrics = append(metrics, *m)
+				}
+			}
+			continue
+		}
+
+		rctx := withFanOut(ctx, targets[tr])
+		if len(filters[tr]) > 0 {
+			rctx = backend.WithFilters(rctx, filters[tr])
+		}
+		ms, err := rendersByRegion(rctx, tiers, tr.from, tr.until, targets[tr])
+		if tooLarge, ok := err.(*backend.TooLargeError); ok {
+			Metrics.TooLarge.Add(1)
+			v3Error(w, accessLogger, "render_v3", t0, http.StatusUnprocessableEntity, tooLarge.Error(), nil)
+			return
+		}
+		if err != nil {
+			v3Error(w, accessLogger, "render_v3", t0, backendErrorCode(err), "error fetching the data", err)
+			return
+		}
+		metrics = append(metrics, ms...)
+	}
+
+	reservation.Adjust(metricsBytes(metrics))
+	accessLogger = accessLogger.With(zap.Int("memory_usage_bytes", int(reservation.Bytes())))
+
+	blob, err := carbonapi_v3.RenderEncoder(metrics)
+	if err != nil {
+		v3Error(w, accessLogger, "render_v3", t0, http.StatusInternalServerError, "error marshaling data", err)
+		return
+	}
+
+	v3Response(w, blob, accessLogger, "render_v3", t0)
+}
+
+func infoV3Handler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(req.Context(), config.Timeouts.Global)
+	defer cancel()
+
+	Metrics.Requests.Add(1)
+	prometheusMetrics.Requests.Inc()
+	Metrics.InfoRequests.Add(1)
+
+	accessLogger := zapwriter.Logger("access").With(
+		zap.String("handler", "info_v3"),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+
+	body, ok := v3Request(w, req, accessLogger, "info_v3", t0)
+	if !ok {
+		return
+	}
+	names, err := carbonapi_v3.InfoRequestDecoder(body)
+	if err != nil || len(names) == 0 {
+		v3Error(w, accessLogger, "info_v3", t0, http.StatusBadRequest, "bad or empty info request", err)
+		return
+	}
+	accessLogger = accessLogger.With(zap.Strings("targets", names))
+
+	bs, err := selectBackends(req, backends, backendGroups)
+	if err != nil {
+		v3Error(w, accessLogger, "info_v3", t0, http.StatusBadRequest, "invalid backend group selection", err)
+		return
+	}
+	tiers := regionTiers(bs, backendRegions, config.Region)
+
+	var infos []types.Info
+	for _, name := range names {
+		is, err := infosByRegion(withFanOut(ctx, nil), tiers, name)
+		if err != nil {
+			v3Error(w, accessLogger, "info_v3", t0, backendErrorCode(err), "info: error processing request", err)
+			return
+		}
+		infos = append(infos, is...)
+	}
+
+	blob, err := carbonapi_v3.InfoEncoder(infos)
+	if err != nil {
+		v3Error(w, accessLogger, "info_v3", t0, http.StatusInternalServerError, "error marshaling data", err)
+		return
+	}
+
+	v3Response(w, blob, accessLogger, "info_v3", t0)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+)
+
+func TestV3Handlers(t *testing.T) {
+	var renders [][]string
+	b := mock.New(mock.Config{
+		Find: func(ctx context.Context, query string) (types.Matches, error) {
+			return types.Matches{Name: query, Matches: []types.Match{{Path: query + ".b", IsLeaf: true}, {Path: query + ".a"}}}, nil
+		},
+		Info: func(ctx context.Context, metric string) ([]types.Info, error) {
+			return []types.Info{{Host: "store", Name: metric, AggregationMethod: "avg"}}, nil
+		},
+		Render: func(ctx context.Context, from, until int32, targets []string) ([]types.Metric, error) {
+			renders = append(renders, targets)
+			metrics := make([]types.Metric, 0, len(targets))
+			for _, target := range targets {
+				metrics = append(metrics, types.Metric{
+					Name:      target,
+					StartTime: from,
+					StopTime:  until,
+					StepTime:  60,
+					Values:    []float64{1, 0},
+					IsAbsent:  []bool{false, true},
+				})
+			}
+			return metrics, nil
+		},
+	})
+
+	defer func(bs []backend.Backend, global time.Duration) {
+		backends, config.Timeouts.Global = bs, global
+	}(backends, config.Timeouts.Global)
+	backends = []backend.Backend{&b}
+	config.Timeouts.Global = time.Second
+
+	body, _ := carbonapi_v3.FindRequestEncoder([]string{"foo", "bar"})
+	rr := httptest.NewRecorder()
+	findV3Handler(rr, httptest.NewRequest("POST", "/api/v3/find", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeCarbonAPIv3PB {
+		t.Errorf("expected content type %s, got %s", contentTypeCarbonAPIv3PB, ct)
+	}
+	matches, err := carbonapi_v3.FindDecoder(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMatches := []types.Matches{
+		{Name: "foo", Matches: []types.Match{{Path: "foo.a"}, {Path: "foo.b", IsLeaf: true}}},
+		{Name: "bar", Matches: []types.Match{{Path: "bar.a"}, {Path: "bar.b", IsLeaf: true}}},
+	}
+	if !reflect.DeepEqual(matches, expectedMatches) {
+		t.Errorf("expected %+v, got %+v", expectedMatches, matches)
+	}
+
+	body, _ = carbonapi_v3.RenderRequestEncoder([]carbonapi_v3.FetchRequest{
+		{Name: "foo", From: 0, Until: 120},
+		{Name: "bar", From: 60, Until: 180},
+		{Name: "baz", From: 0, Until: 120},
+	})
+	rr = httptest.NewRecorder()
+	renderV3Handler(rr, httptest.NewRequest("POST", "/api/v3/render", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	metrics, err := carbonapi_v3.RenderDecoder(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+		if !m.IsAbsent[1] {
+			t.Errorf("expected the absent point of %s to stay absent", m.Name)
+		}
+	}
+	sort.Strings(names)
+	if expected := []string{"bar", "baz", "foo"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	if len(renders) != 2 {
+		t.Errorf("expected a backend render per time range, got %v", renders)
+	}
+
+	body, _ = carbonapi_v3.InfoRequestEncoder([]string{"foo"})
+	rr = httptest.NewRecorder()
+	infoV3Handler(rr, httptest.NewRequest("POST", "/api/v3/info", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	infos, err := carbonapi_v3.InfoDecoder(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []types.Info{{Host: "store", Name: "foo", AggregationMethod: "avg"}}; !reflect.DeepEqual(infos, expected) {
+		t.Errorf("expected %+v, got %+v", expected, infos)
+	}
+}
+
+func TestV3HandlersBadRequests(t *testing.T) {
+	rr := httptest.NewRecorder()
+	findV3Handler(rr, httptest.NewRequest("GET", "/api/v3/find", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	renderV3Handler(rr, httptest.NewRequest("POST", "/api/v3/render", bytes.NewReader([]byte{0x0a, 0x05})))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a truncated request, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	infoV3Handler(rr, httptest.NewRequest("POST", "/api/v3/info", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an empty request, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/topk"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultWarmupTimeout is how long a zipper warms up unless configured.
+	defaultWarmupTimeout = time.Minute
+	// popularWindow is how recent the popular finds replayed on warmup are.
+	popularWindow = time.Hour
+	// warmupParallelism is how many finds are replayed at once on warmup.
+	warmupParallelism = 16
+)
+
+// warm is whether the zipper is ready for traffic; it starts so unless
+// warmup is enabled.
+var warm int32 = 1
+
+// popularFinds are the most popular recent finds, saved on shutdown for the
+// next zipper to replay.
+var popularFinds *topk.Rolling
+
+func isWarm() bool {
+	return atomic.LoadInt32(&warm) == 1
+}
+
+// setUpWarmup holds back reporting ready until warmUp is done.
+func setUpWarmup() {
+	if !config.Warmup.Enabled {
+		return
+	}
+
+	atomic.StoreInt32(&warm, 0)
+	if config.Warmup.Queries > 0 {
+		popularFinds = topk.NewRolling(config.Warmup.Queries, popularWindow)
+	}
+}
+
+func recordFind(query string) {
+	if popularFinds != nil {
+		popularFinds.Add(query, 1)
+	}
+}
+
+// warmUp probes bs, then replays the finds the last zipper saved, and
+// reports the zipper ready once done or after the warmup timeout.
+func warmUp(bs []backend.Backend, logger *zap.Logger) {
+	if isWarm() {
+		return
+	}
+	defer atomic.StoreInt32(&warm, 1)
+
+	timeout := config.Warmup.Timeout
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t0 := time.Now()
+	probed := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, b := range bs {
+			wg.Add(1)
+			go func(b backend.Backend) {
+				defer wg.Done()
+				probe(b)
+			}(b)
+		}
+		wg.Wait()
+		close(probed)
+	}()
+	select {
+	case <-probed:
+	case <-ctx.Done():
+		logger.Warn("warmup timed out probing the backends")
+		return
+	}
+
+	queries := loadPopularFinds(logger)
+	replayFinds(ctx, bs, queries)
+
+	logger.Info("warmed up",
+		zap.Duration("runtime", time.Since(t0)),
+		zap.Int("queries", len(queries)),
+		zap.Bool("timed_out", ctx.Err() != nil),
+	)
+}
+
+// replayFinds runs queries, for their servers to land in the path cache.
+func replayFinds(ctx context.Context, bs []backend.Backend, queries []string) {
+	tiers := regionTiers(bs, backendRegions, config.Region)
+
+	sem := make(chan struct{}, warmupParallelism)
+	var wg sync.WaitGroup
+	for _, q := range queries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(q string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			findsByRegion(withFanOut(ctx, []string{q}), tiers, q)
+		}(q)
+	}
+	wg.Wait()
+}
+
+func loadPopularFinds(logger *zap.Logger) []string {
+	if config.Warmup.QueriesFile == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(config.Warmup.QueriesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	var queries []string
+	if err == nil {
+		err = json.Unmarshal(b, &queries)
+	}
+	if err != nil {
+		logger.Warn("failed to load the finds to warm up with",
+			zap.String("path", config.Warmup.QueriesFile),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	if len(queries) > config.Warmup.Queries {
+		queries = queries[:config.Warmup.Queries]
+	}
+
+	return queries
+}
+
+// savePopularFinds keeps the most popular finds for the next zipper.
+func savePopularFinds(logger *zap.Logger) {
+	if popularFinds == nil || config.Warmup.QueriesFile == "" {
+		return
+	}
+
+	top := popularFinds.Top()
+	queries := make([]string, 0, len(top))
+	for _, it := range top {
+		queries = append(queries, it.Key)
+	}
+
+	b, err := json.Marshal(queries)
+	if err == nil {
+		err = ioutil.WriteFile(config.Warmup.QueriesFile, b, 0644)
+	}
+	if err != nil {
+		logger.Error("failed to save the finds to warm up with",
+			zap.String("path", config.Warmup.QueriesFile),
+			zap.Error(err),
+		)
+	}
+}
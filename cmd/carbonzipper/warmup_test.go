@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+func TestWarmUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warmup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(c cfg.WarmupConfig, w int32) {
+		config.Warmup, warm = c, w
+		popularFinds = nil
+	}(config.Warmup, warm)
+	config.Warmup = cfg.WarmupConfig{
+		Enabled:     true,
+		Queries:     2,
+		QueriesFile: filepath.Join(dir, "queries.json"),
+	}
+
+	setUpWarmup()
+	lbCheck := func() int {
+		w := httptest.NewRecorder()
+		lbCheckHandler(w, httptest.NewRequest("GET", "/lb_check", nil))
+		return w.Code
+	}
+	if code := lbCheck(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a zipper warming up not to be ready, got %d", code)
+	}
+
+	// The popular finds of the last zipper are replayed.
+	for _, q := range []string{"a.*", "b.*", "a.*", "c.*", "a.*", "b.*"} {
+		recordFind(q)
+	}
+	savePopularFinds(zap.NewNop())
+
+	var mu sync.Mutex
+	var found []string
+	b := mock.New(mock.Config{
+		Find: func(ctx context.Context, query string) (types.Matches, error) {
+			mu.Lock()
+			found = append(found, query)
+			mu.Unlock()
+			return types.Matches{}, nil
+		},
+	})
+	warmUp([]backend.Backend{&b}, zap.NewNop())
+
+	if code := lbCheck(); code != http.StatusOK {
+		t.Errorf("Expected a warm zipper to be ready, got %d", code)
+	}
+	if len(found) != 2 {
+		t.Errorf("Expected the 2 most popular finds to be replayed, got %v", found)
+	}
+	for _, q := range found {
+		if q != "a.*" && q != "b.*" {
+			t.Errorf("Expected only the most popular finds, got %q", q)
+		}
+	}
+
+	var saved []string
+	blob, _ := ioutil.ReadFile(config.Warmup.QueriesFile)
+	if err := json.Unmarshal(blob, &saved); err != nil || len(saved) != 2 || saved[0] != "a.*" {
+		t.Errorf("Expected the popular finds to be saved heaviest first, got %v %v", saved, err)
+	}
+	if atomic.LoadInt32(&warm) != 1 {
+		t.Error("Expected the zipper to stay warm")
+	}
+}
@@ -0,0 +1,224 @@
+// compat replays render queries against carbonapi and a reference
+// graphite-web and reports where their responses differ.
+//
+// The corpus is a file of render query strings, one per line, e.g.
+//
+//	target=sumSeries(servers.*.cpu)&from=-1h
+//
+// Blank lines and lines starting with # are skipped. Relative from and until
+// are resolved once per query, so both servers are asked the same time range.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/date"
+	"github.com/bookingcom/carbonapi/pkg/compat"
+)
+
+type result struct {
+	Query       string              `json:"query"`
+	Error       string              `json:"error,omitempty"`
+	Differences []compat.Difference `json:"-"`
+	Report      []string            `json:"differences,omitempty"`
+}
+
+func main() {
+	carbonapiURL := flag.String("carbonapi", "http://localhost:8081", "Base `URL` of the carbonapi under test.")
+	graphiteURL := flag.String("graphite", "", "Base `URL` of the reference graphite-web.")
+	corpus := flag.String("queries", "", "`File` with one render query string per line, - for stdin.")
+	absolute := flag.Float64("abs", 0, "Absolute difference allowed between values.")
+	relative := flag.Float64("rel", 1e-9, "Relative difference allowed between values.")
+	concurrency := flag.Int("concurrency", 4, "Queries replayed at the same time.")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout of each request.")
+	maxShown := flag.Int("max-differences", 10, "Differences shown per query, 0 for all.")
+	asJSON := flag.Bool("json", false, "Write the report as JSON.")
+	flag.Parse()
+
+	if *graphiteURL == "" || *corpus == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	queries, err := readQueries(*corpus)
+	if err != nil {
+		log.Fatalf("reading queries: %v", err)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	cmp := compat.Tolerance{Absolute: *absolute, Relative: *relative}
+
+	results := make([]result, len(queries))
+	sem := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, q string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = replay(context.Background(), client, *carbonapiURL, *graphiteURL, q, cmp)
+		}(i, q)
+	}
+	wg.Wait()
+
+	failed := report(os.Stdout, results, *maxShown, *asJSON)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func readQueries(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var queries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		queries = append(queries, line)
+	}
+
+	return queries, scanner.Err()
+}
+
+// pin resolves the time range of the query and asks for JSON.
+func pin(query string, now int64) (url.Values, error) {
+	v, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil {
+		return nil, err
+	}
+
+	tz := v.Get("tz")
+	from := date.DateParamToEpoch(v.Get("from"), tz, now-24*60*60, time.Local)
+	until := date.DateParamToEpoch(v.Get("until"), tz, now, time.Local)
+	v.Set("from", strconv.Itoa(int(from)))
+	v.Set("until", strconv.Itoa(int(until)))
+	v.Set("format", "json")
+
+	return v, nil
+}
+
+func replay(ctx context.Context, client *http.Client, carbonapiURL, graphiteURL, query string, cmp compat.Comparator) result {
+	res := result{Query: query}
+
+	v, err := pin(query, time.Now().Unix())
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	want, err := render(ctx, client, graphiteURL, v)
+	if err != nil {
+		res.Error = "graphite-web: " + err.Error()
+		return res
+	}
+
+	got, err := render(ctx, client, carbonapiURL, v)
+	if err != nil {
+		res.Error = "carbonapi: " + err.Error()
+		return res
+	}
+
+	res.Differences = compat.Diff(want, got, cmp)
+	return res
+}
+
+func render(ctx context.Context, client *http.Client, base string, v url.Values) ([]compat.Series, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+"/render/?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return compat.ParseJSON(body)
+}
+
+// report writes the results and returns how many queries failed.
+func report(w io.Writer, results []result, maxShown int, asJSON bool) int {
+	failed := 0
+	for i, r := range results {
+		if r.Error != "" || len(r.Differences) > 0 {
+			failed++
+		}
+
+		shown := r.Differences
+		if maxShown > 0 && len(shown) > maxShown {
+			shown = shown[:maxShown]
+		}
+		for _, d := range shown {
+			results[i].Report = append(results[i].Report, d.String())
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Queries int      `json:"queries"`
+			Failed  int      `json:"failed"`
+			Results []result `json:"results"`
+		}{len(results), failed, results})
+		return failed
+	}
+
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(w, "ERROR %s\n\t%s\n", r.Query, r.Error)
+		case len(r.Differences) > 0:
+			fmt.Fprintf(w, "DIFF  %s (%d differences)\n", r.Query, len(r.Differences))
+			for _, d := range r.Report {
+				fmt.Fprintf(w, "\t%s\n", d)
+			}
+			if len(r.Report) < len(r.Differences) {
+				fmt.Fprintf(w, "\t...\n")
+			}
+		default:
+			fmt.Fprintf(w, "OK    %s\n", r.Query)
+		}
+	}
+	fmt.Fprintf(w, "\n%d queries, %d ok, %d failed\n", len(results), len(results)-failed, failed)
+
+	return failed
+}
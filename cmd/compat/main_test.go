@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/compat"
+)
+
+func TestPin(t *testing.T) {
+	v, err := pin("target=a.b&from=-1h", 1500000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Get("format") != "json" {
+		t.Errorf("format = %q, want json", v.Get("format"))
+	}
+	if v.Get("until") != "1500000000" {
+		t.Errorf("until = %q, want 1500000000", v.Get("until"))
+	}
+	if v.Get("from") == "-1h" || v.Get("from") == "" {
+		t.Errorf("from = %q, want an epoch", v.Get("from"))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	responder := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("format") != "json" || r.FormValue("until") == "" {
+				t.Errorf("unpinned request %s", r.URL)
+			}
+			w.Write([]byte(body))
+		}))
+	}
+
+	graphite := responder(`[{"target": "a.b", "datapoints": [[1, 60], [2, 120]]}]`)
+	defer graphite.Close()
+	same := responder(`[{"target": "a.b", "datapoints": [[1, 60], [2, 120]]}]`)
+	defer same.Close()
+	different := responder(`[{"target": "a.b", "datapoints": [[1, 60], [3, 120]]}]`)
+	defer different.Close()
+
+	cmp := compat.Tolerance{}
+	ok := replay(context.Background(), http.DefaultClient, same.URL, graphite.URL, "target=a.b&from=-1h", cmp)
+	diff := replay(context.Background(), http.DefaultClient, different.URL, graphite.URL, "target=a.b&from=-1h", cmp)
+	broken := replay(context.Background(), http.DefaultClient, "http://127.0.0.1:0", graphite.URL, "target=a.b", cmp)
+
+	var out bytes.Buffer
+	failed := report(&out, []result{ok, diff, broken}, 10, false)
+
+	if failed != 2 {
+		t.Errorf("failed = %d, want 2", failed)
+	}
+	for _, line := range []string{
+		"OK    target=a.b&from=-1h",
+		"DIFF  target=a.b&from=-1h (1 differences)",
+		"a.b at 120: want 2, got 3",
+		"ERROR target=a.b",
+		"3 queries, 1 ok, 2 failed",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("report misses %q:\n%s", line, out.String())
+		}
+	}
+}
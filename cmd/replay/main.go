@@ -0,0 +1,103 @@
+// replay issues a request recorded in the request journal of carbonapi
+// again, against any carbonapi, and writes its response to stdout.
+//
+// The relative from and until of renders are resolved at the time the
+// request was journaled, so that the replay asks for the range the user
+// saw, unless -now is given.
+//
+//	replay -journal /var/lib/carbonapi/journal -uuid 8c5d... -carbonapi http://staging:8081
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/date"
+	"github.com/bookingcom/carbonapi/pkg/journal"
+)
+
+func main() {
+	journalFile := flag.String("journal", "", "`File` of the request journal.")
+	uuid := flag.String("uuid", "", "`UUID` of the request to replay.")
+	carbonapiURL := flag.String("carbonapi", "http://localhost:8081", "Base `URL` of the carbonapi to replay the request against.")
+	now := flag.Bool("now", false, "Resolve relative time ranges now, rather than when the request was journaled.")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout of the request.")
+	flag.Parse()
+
+	if *journalFile == "" || *uuid == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	e, ok, err := journal.Find(*journalFile, *uuid)
+	if err != nil {
+		log.Fatalf("reading journal: %v", err)
+	}
+	if !ok {
+		log.Fatalf("request %s is not in the journal", *uuid)
+	}
+	if !*now {
+		pin(&e)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := replay(context.Background(), client, *carbonapiURL, e)
+	if err != nil {
+		log.Fatalf("replaying request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Fprintf(os.Stderr, "%s %s%s at %s: %s\n", e.Method, e.Handler, e.Path, e.Time.Format(time.RFC3339), resp.Status)
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
+
+// pin resolves the time range of a render at the time it was journaled.
+func pin(e *journal.Entry) {
+	if e.Handler != "render" {
+		return
+	}
+
+	now := e.Time.Unix()
+	tz := e.Params.Get("tz")
+	from := date.DateParamToEpochAt(e.Params.Get("from"), tz, now-24*60*60, time.Local, e.Time)
+	until := date.DateParamToEpochAt(e.Params.Get("until"), tz, now, time.Local, e.Time)
+	e.Params.Set("from", strconv.Itoa(int(from)))
+	e.Params.Set("until", strconv.Itoa(int(until)))
+}
+
+// replay issues e against the carbonapi at base, with the method it was
+// issued with.
+func replay(ctx context.Context, client *http.Client, base string, e journal.Entry) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + e.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if e.Method == "POST" {
+		req, err = http.NewRequest("POST", u.String(), strings.NewReader(e.Params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		u.RawQuery = e.Params.Encode()
+		req, err = http.NewRequest("GET", u.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Do(req.WithContext(ctx))
+}
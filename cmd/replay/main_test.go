@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/journal"
+)
+
+func TestPin(t *testing.T) {
+	at := time.Unix(1500000000, 0)
+	e := journal.Entry{
+		Handler: "render",
+		Time:    at,
+		Params:  url.Values{"target": {"a.b"}, "from": {"-1h"}},
+	}
+	pin(&e)
+
+	if got := e.Params.Get("until"); got != "1500000000" {
+		t.Errorf("until = %q, want 1500000000", got)
+	}
+	if got := e.Params.Get("from"); got != "1499996400" {
+		t.Errorf("from = %q, want 1499996400", got)
+	}
+
+	find := journal.Entry{Handler: "find", Time: at, Params: url.Values{"query": {"a.*"}}}
+	pin(&find)
+	if find.Params.Get("until") != "" {
+		t.Error("Expected only renders to be pinned")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r
+	}))
+	defer server.Close()
+
+	for _, method := range []string{"GET", "POST"} {
+		e := journal.Entry{
+			Method:  method,
+			Handler: "render",
+			Path:    "/render/",
+			Params:  url.Values{"target": {"sum(a.*)"}, "from": {"1499996400"}},
+		}
+		resp, err := replay(context.Background(), server.Client(), server.URL+"/", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got.Method != method || got.URL.Path != "/render/" || got.Form.Get("target") != "sum(a.*)" || got.Form.Get("from") != "1499996400" {
+			t.Errorf("Expected the journaled %s request, got %s %s %v", method, got.Method, got.URL.Path, got.Form)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package date
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -40,43 +42,121 @@ func parseTime(s string) (hour, minute int, err error) {
 		return 0, 0, errBadTime
 	}
 
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, errBadTime
+	}
+
 	return hour, minute, nil
 }
 
 var TimeFormats = []string{"20060102", "01/02/06"}
 
-// DateParamToEpoch turns a passed string parameter into a unix epoch
+// ISO8601Formats are the ISO 8601 forms accepted for absolute times. Those
+// without a zone are in the requested time zone.
+var ISO8601Formats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// epochMillisDigits is the length from which a number is taken for epoch
+// milliseconds rather than seconds. Epoch seconds have 10 digits until 2286.
+const epochMillisDigits = 13
+
+// DateParamToEpoch turns a passed string parameter into a unix epoch, or
+// returns d if it can't be parsed.
 func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Location) int32 {
+	t, err := ParseDateParam(s, qtz, d, defaultTimeZone)
+	if err != nil {
+		return int32(d)
+	}
+
+	return t
+}
+
+// DateParamToEpochAt is DateParamToEpoch, with relative times and named
+// days taken from now.
+func DateParamToEpochAt(s string, qtz string, d int64, defaultTimeZone *time.Location, now time.Time) int32 {
+	t, err := ParseDateParamAt(s, qtz, d, defaultTimeZone, now)
+	if err != nil {
+		return int32(d)
+	}
+
+	return t
+}
 
+// ParseDateParam turns a passed string parameter into a unix epoch. It
+// accepts relative times (-1d), epoch seconds and milliseconds, ISO 8601
+// timestamps, and the graphite HH:MM_YYYYMMDD form with its named times and
+// days, and returns an error for anything else. Empty is d.
+func ParseDateParam(s string, qtz string, d int64, defaultTimeZone *time.Location) (int32, error) {
+	return ParseDateParamAt(s, qtz, d, defaultTimeZone, timeNow())
+}
+
+// ParseDateParamAt is ParseDateParam, with relative times and named days
+// taken from now.
+func ParseDateParamAt(s string, qtz string, d int64, defaultTimeZone *time.Location, now time.Time) (int32, error) {
 	if s == "" {
 		// return the default if nothing was passed
-		return int32(d)
+		return int32(d), nil
 	}
 
 	// relative timestamp
 	if s[0] == '-' {
 		offset, err := parser.IntervalString(s, -1)
 		if err != nil {
-			return int32(d)
+			return 0, fmt.Errorf("invalid relative time %q: %v", s, err)
 		}
 
-		return int32(timeNow().Add(time.Duration(offset) * time.Second).Unix())
+		return int32(now.Add(time.Duration(offset) * time.Second).Unix()), nil
+	}
+
+	var tz = defaultTimeZone
+	if qtz != "" {
+		z, err := time.LoadLocation(qtz)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time zone %q", qtz)
+		}
+		tz = z
 	}
 
 	switch s {
 	case "now":
-		return int32(timeNow().Unix())
+		return int32(now.Unix()), nil
 	case "midnight", "noon", "teatime":
-		yy, mm, dd := timeNow().Date()
+		yy, mm, dd := now.In(tz).Date()
 		hh, min, _ := parseTime(s) // error ignored, we know it's valid
-		dt := time.Date(yy, mm, dd, hh, min, 0, 0, defaultTimeZone)
-		return int32(dt.Unix())
+		dt := time.Date(yy, mm, dd, hh, min, 0, 0, tz)
+		return int32(dt.Unix()), nil
 	}
 
-	sint, err := strconv.Atoi(s)
-	// need to check that len(s) > 8 to avoid turning 20060102 into seconds
-	if err == nil && len(s) > 8 {
-		return int32(sint) // We got a timestamp so returning it
+	if isDigits(s) {
+		// need to check that len(s) > 8 to avoid turning 20060102 into seconds
+		if len(s) > 8 {
+			sint, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid timestamp %q", s)
+			}
+			if len(s) >= epochMillisDigits {
+				sint /= 1000
+			}
+			if sint > math.MaxInt32 {
+				return 0, fmt.Errorf("timestamp %q is out of range", s)
+			}
+			return int32(sint), nil // We got a timestamp so returning it
+		}
+	}
+
+	if strings.Contains(s, "-") {
+		for _, format := range ISO8601Formats {
+			t, err := time.ParseInLocation(format, s, tz)
+			if err == nil {
+				return int32(t.Unix()), nil
+			}
+		}
+
+		return 0, fmt.Errorf("invalid ISO 8601 time %q", s)
 	}
 
 	s = strings.Replace(s, "_", " ", 1) // Go can't parse _ in date strings
@@ -84,51 +164,57 @@ func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Locat
 	var ts, ds string
 	split := strings.Fields(s)
 
-	switch {
-	case len(split) == 1:
-		ds = s
-	case len(split) == 2:
+	switch len(split) {
+	case 1:
+		ds = split[0]
+	case 2:
 		ts, ds = split[0], split[1]
-	case len(split) > 2:
-		return int32(d)
-	}
-
-	var tz = defaultTimeZone
-	if qtz != "" {
-		if z, err := time.LoadLocation(qtz); err != nil {
-			tz = z
-		}
+	default:
+		return 0, fmt.Errorf("invalid time %q", s)
 	}
 
 	var t time.Time
 dateStringSwitch:
 	switch ds {
 	case "today":
-		t = timeNow()
-		// nothing
+		t = now.In(tz)
 	case "yesterday":
-		t = timeNow().AddDate(0, 0, -1)
+		t = now.In(tz).AddDate(0, 0, -1)
 	case "tomorrow":
-		t = timeNow().AddDate(0, 0, 1)
+		t = now.In(tz).AddDate(0, 0, 1)
 	default:
 		for _, format := range TimeFormats {
+			var err error
 			t, err = time.ParseInLocation(format, ds, tz)
 			if err == nil {
 				break dateStringSwitch
 			}
 		}
 
-		return int32(d)
+		return 0, fmt.Errorf("invalid date %q", ds)
 	}
 
 	var hour, minute int
 	if ts != "" {
-		hour, minute, _ = parseTime(ts)
-		// defaults to hour=0, minute=0 on error, which is midnight, which is fine for now
+		var err error
+		hour, minute, err = parseTime(ts)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time of day %q", ts)
+		}
 	}
 
 	yy, mm, dd := t.Date()
-	t = time.Date(yy, mm, dd, hour, minute, 0, 0, defaultTimeZone)
+	t = time.Date(yy, mm, dd, hour, minute, 0, 0, tz)
+
+	return int32(t.Unix()), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
 
-	return int32(t.Unix())
+	return true
 }
@@ -47,3 +47,60 @@ func TestDateParamToEpoch(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDateParam(t *testing.T) {
+	timeNow = func() time.Time {
+		//16 Aug 1994 15:30
+		return time.Date(1994, time.August, 16, 15, 30, 0, 100, time.UTC)
+	}
+
+	var tests = []struct {
+		input  string
+		tz     string
+		output int32
+	}{
+		{"", "", 42},
+		{"775000000", "", 775000000},
+		{"1500000000123", "", 1500000000},
+		{"1994-08-12T17:04:05Z", "", 776711045},
+		{"1994-08-12T19:04:05+02:00", "", 776711045},
+		{"1994-08-12T17:04:05.5Z", "", 776711045},
+		{"1994-08-12T17:04", "", 776711040},
+		{"1994-08-12T19:04", "Europe/Amsterdam", 776711040},
+		{"1994-08-12", "", 776649600},
+		{"17:04_19940812", "", 776711040},
+		{"19:04_19940812", "Europe/Amsterdam", 776711040},
+		{"midnight", "", 776995200},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDateParam(tt.input, tt.tz, 42, time.UTC)
+		if err != nil {
+			t.Errorf("ParseDateParam(%q, %q): unexpected error %v", tt.input, tt.tz, err)
+			continue
+		}
+		if got != tt.output {
+			t.Errorf("ParseDateParam(%q, %q)=%v, want %v", tt.input, tt.tz, got, tt.output)
+		}
+	}
+
+	for _, input := range []string{
+		"-1fortnight",
+		"1994-08-12T17:04:05Zulu",
+		"1994-13-12",
+		"25:00_19940812",
+		"17:4x_19940812",
+		"17:04_1994081",
+		"noon today please",
+		"sometime",
+		"99999999999999999",
+	} {
+		if got, err := ParseDateParam(input, "", 42, time.UTC); err == nil {
+			t.Errorf("ParseDateParam(%q)=%v, want an error", input, got)
+		}
+	}
+
+	if _, err := ParseDateParam("now", "Mars/Olympus_Mons", 42, time.UTC); err == nil {
+		t.Error("expected an error for an unknown time zone")
+	}
+}
@@ -1,6 +1,8 @@
 package expr
 
 import (
+	"context"
+
 	// Import all known functions
 	_ "github.com/bookingcom/carbonapi/expr/functions"
 	"github.com/bookingcom/carbonapi/expr/helper"
@@ -13,8 +15,8 @@ import (
 type evaluator struct{}
 
 // EvalExpr evalualtes expressions
-func (eval evaluator) EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	return EvalExpr(e, from, until, values)
+func (eval evaluator) EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	return EvalExpr(ctx, e, from, until, values)
 }
 
 var _evaluator = evaluator{}
@@ -24,8 +26,9 @@ func init() {
 	metadata.SetEvaluator(_evaluator)
 }
 
-// EvalExpr is the main expression evaluator
-func EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+// EvalExpr is the main expression evaluator. Once ctx is done, it fails
+// with the error of ctx rather than evaluating more functions.
+func EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if e.IsName() {
 		return values[parser.MetricRequest{Metric: e.Target(), From: from, Until: until}], nil
 	} else if e.IsConst() {
@@ -48,7 +51,13 @@ func EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest]
 	f, ok := metadata.FunctionMD.Functions[e.Target()]
 	metadata.FunctionMD.RUnlock()
 	if ok {
-		return f.Do(e, from, until, values)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if t := traceFrom(ctx); t != nil {
+			return t.do(ctx, f, e, from, until, values)
+		}
+		return f.Do(ctx, e, from, until, values)
 	}
 
 	return nil, helper.ErrUnknownFunction(e.Target())
@@ -59,13 +68,13 @@ func EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest]
 // applyByNode(foo*, 1, "%") -> (true, ["foo1", "foo2"], nil)
 // sumSeries(foo) -> (false, nil, nil)
 // Assumes that applyByNode only appears as the outermost function.
-func RewriteExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
+func RewriteExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
 	if e.IsFunc() {
 		metadata.FunctionMD.RLock()
 		f, ok := metadata.FunctionMD.RewriteFunctions[e.Target()]
 		metadata.FunctionMD.RUnlock()
 		if ok {
-			return f.Do(e, from, until, values)
+			return f.Do(ctx, e, from, until, values)
 		}
 	}
 	return false, nil, nil
@@ -1,6 +1,7 @@
 package expr
 
 import (
+	"context"
 	"math"
 	"testing"
 	"time"
@@ -202,7 +203,7 @@ func TestEvalExpr(t *testing.T) {
 				&data,
 			}
 
-			EvalExpr(exp, int32(request.From), int32(request.Until), metricMap)
+			EvalExpr(context.Background(), exp, int32(request.From), int32(request.Until), metricMap)
 		})
 	}
 }
@@ -308,7 +309,7 @@ func TestEvalExpression(t *testing.T) {
 			map[parser.MetricRequest][]*types.MetricData{
 				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{2, 4, 6, 10, 14, 20, math.NaN()}, 1, now32)},
 			},
-			[]*types.MetricData{types.MakeMetricData("nPercentile(metric1,50)", []float64{8, 8, 8, 8, 8, 8, 8}, 1, now32)},
+			[]*types.MetricData{types.MakeMetricData("nPercentile(metric1,50)", []float64{10, 10, 10, 10, 10, 10, 10}, 1, now32)},
 		},
 		{
 			parser.NewExpr("nonNegativeDerivative",
@@ -1040,6 +1041,22 @@ func TestEvalExpression(t *testing.T) {
 				types.MakeMetricData("metric1", []float64{1, 2, -1, 7, 8, 20, 30, math.NaN()}, 1, now32),
 			},
 		},
+		{
+			parser.NewExpr("removeEmptySeries",
+
+				"metric*",
+				0.5,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric*", 0, 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, -1, 7, math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+					types.MakeMetricData("metric2", []float64{1, math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("metric1", []float64{1, 2, -1, 7, math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+			},
+		},
 		{
 			parser.NewExpr("removeBelowValue",
 
@@ -1432,6 +1449,58 @@ func TestEvalSummarize(t *testing.T) {
 			tenThirtyTwo,
 			tenThirtyTwo + 25*60,
 		},
+		{
+			parser.NewExpr("summarize",
+				"metric1", parser.ArgValue("5s"),
+				parser.NamedArgs{
+					"func": parser.ArgValue("median"),
+				},
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 0, 0, 0.5, 1, 2, 1, 1, 1.5, 2, 3, 2, math.NaN(), 1.5, 3}, 1, now32)},
+			},
+			[]float64{0.5, 1.5, 2.5},
+			"summarize(metric1,'5s','median')",
+			5,
+			now32,
+			now32 + 15*1,
+		},
+		{
+			// weeks are aligned to the epoch, as in graphite-web, not to
+			// the zero time
+			parser.NewExpr("summarize",
+				"metric1",
+				parser.ArgValue("1w"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{
+					1, 2, 3, 4, 5, 6, 7,
+					8, 9, 10, 11, 12, 13, 14,
+				}, 86400, 1410307200)},
+			},
+			[]float64{1, 35, 69},
+			"summarize(metric1,'1w')",
+			7 * 86400,
+			1409788800,
+			1409788800 + 3*7*86400,
+		},
+		{
+			parser.NewExpr("smartSummarize",
+				"metric1",
+				parser.ArgValue("10min"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{
+					1, 1, 1, 1, 1, 2, 2, 2, 2, 2,
+					3, 3, 3, 3, 3, 4, 4, 4, 4, 4,
+					5, 5, 5, 5, 5}, 60, tenThirtyTwo)},
+			},
+			[]float64{15, 35, 25},
+			"smartSummarize(metric1,'10min')",
+			600,
+			tenThirtyTwo,
+			tenThirtyTwo + 25*60,
+		},
 	}
 
 	for _, tt := range tests {
@@ -1532,7 +1601,7 @@ func TestRewriteExpr(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rewritten, newTargets, err := RewriteExpr(tt.e, 0, 1, tt.m)
+			rewritten, newTargets, err := RewriteExpr(context.Background(), tt.e, 0, 1, tt.m)
 
 			if err != nil {
 				t.Errorf("failed to rewrite %v: %+v", tt.name, err)
@@ -2123,12 +2192,61 @@ func TestEvalCustomFromUntil(t *testing.T) {
 			4200,
 			4350,
 		},
+		{
+			parser.NewExpr("linearRegression",
+
+				"metric1",
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 10}: {types.MakeMetricData("metric1", []float64{1, 2, math.NaN(), 4}, 1, 0)},
+			},
+			[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			"linearRegression(metric1)",
+			0,
+			10,
+		},
+		{
+			parser.NewExpr("holtWintersForecast",
+
+				"metric1",
+				parser.ArgValue("3min"),
+				parser.ArgValue("2min"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 600}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6, 7, 8}, 60, 0)},
+			},
+			[]float64{1.3813298775000002, 1.7260968017928753, 2.2112762108008353, 2.7344913980228602, 3.3679841819340175, 4.021553338739757, 4.263102117437519},
+			"holtWintersForecast(metric1)",
+			180,
+			600,
+		},
+		{
+			// the day before from is fetched for from to be aligned to
+			// its day
+			parser.NewExpr("smartSummarize",
+
+				"metric1",
+				parser.ArgValue("1d"),
+				parser.ArgValue("sum"),
+				parser.ArgValue("days"),
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 1410307200 + 5*3600 - 86400, 1410307200 + 86400}: {types.MakeMetricData("metric1", []float64{
+					1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+					1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 2,
+				}, 3600, 1410307200-86400)},
+			},
+			[]float64{25},
+			"smartSummarize(metric1,'1d','sum','days')",
+			1410307200 + 5*3600,
+			1410307200 + 86400,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			originalMetrics := th.DeepClone(tt.m)
-			g, err := EvalExpr(tt.e, tt.from, tt.until, tt.m)
+			g, err := EvalExpr(context.Background(), tt.e, tt.from, tt.until, tt.m)
 			if err != nil {
 				t.Errorf("failed to eval %v: %s", tt.name, err)
 				return
@@ -1,9 +1,9 @@
 package asPercent
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
 	"sort"
 	"strings"
 
@@ -31,284 +31,217 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // asPercent(seriesList, total=None, *nodes)
-func (f *asPercent) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *asPercent) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
 
-	var getTotal func(i int) float64
-	var formatName func(a, b string) string
-	var totalString string
-	var multipleSeries bool
-	var numerators []*types.MetricData
-	var denominators []*types.MetricData
+	if len(e.Args()) >= 3 {
+		return asPercentByNodes(ctx, e, arg, from, until, values)
+	}
 
 	var results []*types.MetricData
 
-	if len(e.Args()) == 1 {
-		getTotal = func(i int) float64 {
-			var t float64
-			var atLeastOne bool
-			for _, a := range arg {
-				if a.IsAbsent[i] {
-					continue
-				}
-				atLeastOne = true
-				t += a.Values[i]
-			}
-			if !atLeastOne {
-				t = math.NaN()
-			}
-
-			return t
-		}
-		formatName = func(a, b string) string {
-			return fmt.Sprintf("asPercent(%s)", a)
+	if len(e.Args()) == 1 || isNone(e.Args()[1]) {
+		total := sumSeries("sumSeries", helper.Normalize(arg))
+		for _, a := range arg {
+			results = append(results, percentOf(fmt.Sprintf("asPercent(%s)", a.Name), a, total))
 		}
-	} else if len(e.Args()) == 2 && e.Args()[1].IsConst() {
+	} else if e.Args()[1].IsConst() {
 		total, err := e.GetFloatArg(1)
 		if err != nil {
 			return nil, err
 		}
-		getTotal = func(i int) float64 { return total }
-		totalString = fmt.Sprintf("%g", total)
-		formatName = func(a, b string) string {
-			return fmt.Sprintf("asPercent(%s,%s)", a, b)
+		totalString := fmt.Sprintf("%g", total)
+
+		for _, a := range arg {
+			r := *a
+			r.Name = fmt.Sprintf("asPercent(%s,%s)", a.Name, totalString)
+			r.Values = make([]float64, len(a.Values))
+			r.IsAbsent = make([]bool, len(a.Values))
+			for i := range a.Values {
+				if a.IsAbsent[i] || total == 0 {
+					r.IsAbsent[i] = true
+					continue
+				}
+				r.Values[i] = (a.Values[i] / total) * 100
+			}
+			results = append(results, &r)
 		}
-	} else if len(e.Args()) == 2 && (e.Args()[1].IsName() || e.Args()[1].IsFunc()) {
-		total, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+	} else if e.Args()[1].IsName() || e.Args()[1].IsFunc() {
+		total, err := helper.GetSeriesArg(ctx, e.Args()[1], from, until, values)
 		if err != nil {
 			return nil, err
 		}
 		if len(total) != 1 && len(total) != len(arg) {
 			return nil, types.ErrWildcardNotAllowed
 		}
+
 		if len(total) == 1 {
-			getTotal = func(i int) float64 {
-				if total[0].IsAbsent[i] {
-					return math.NaN()
-				}
-				return total[0].Values[i]
-			}
+			var totalString string
 			if e.Args()[1].IsName() {
 				totalString = e.Args()[1].Target()
 			} else {
 				totalString = fmt.Sprintf("%s(%s)", e.Args()[1].Target(), e.Args()[1].RawArgs())
 			}
+
+			for _, a := range arg {
+				results = append(results, percentOf(fmt.Sprintf("asPercent(%s,%s)", a.Name, totalString), a, total[0]))
+			}
 		} else {
-			multipleSeries = true
-			numerators = arg
-			denominators = total
-			// Sort lists by name so that they match up.
+			// Sort copies of the lists by name so that they match up, the
+			// originals may be shared with other targets.
+			numerators := append([]*types.MetricData(nil), arg...)
+			denominators := append([]*types.MetricData(nil), total...)
 			sort.Sort(helper.ByName(numerators))
 			sort.Sort(helper.ByName(denominators))
-		}
-		formatName = func(a, b string) string {
-			return fmt.Sprintf("asPercent(%s,%s)", a, b)
-		}
-	} else if len(e.Args()) >= 3 {
-		total, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
-		if err != nil {
-			return nil, err
-		}
-
-		if len(total) != 1 && len(total) != len(arg) {
-			return nil, types.ErrWildcardNotAllowed
-		}
-
-		nodeIndexes, err := e.GetIntArgs(2)
-		if err != nil {
-			return nil, err
-		}
-
-		sumSeries := func(seriesList []*types.MetricData) (*types.MetricData, error) {
-			seriesNames := make([]string, len(seriesList))
-			for i, series := range seriesList {
-				seriesNames[i] = series.Name
-			}
-
-			seriesNameExprs := make([]parser.Expr, len(seriesList))
-			for i, seriesName := range seriesNames {
-				seriesNameExprs[i] = parser.NewTargetExpr(seriesName)
-			}
-
-			result, err := f.Evaluator.EvalExpr(parser.NewExprTyped("sumSeries", seriesNameExprs), from, until, values)
 
-			if err != nil {
-				return nil, err
+			for i, a := range numerators {
+				b := denominators[i]
+				results = append(results, percentOf(fmt.Sprintf("asPercent(%s,%s)", a.Name, b.Name), a, b))
 			}
-
-			// sumSeries uses aggregateSeries function which returns only one series
-			return result[0], nil
 		}
+	} else {
+		return nil, errors.New("total must be either a constant or a series")
+	}
 
-		groupByNodes := func(seriesList []*types.MetricData, nodeIndexes []int) (map[string][]*types.MetricData, []string) {
-			var nodeKeys []string
-			groups := make(map[string][]*types.MetricData)
-
-			for _, series := range seriesList {
-				metric := helper.ExtractMetric(series.Name)
-				nodes := strings.Split(metric, ".")
-				nodeKey := make([]string, 0, len(nodeIndexes))
-				for _, index := range nodeIndexes {
-					nodeKey = append(nodeKey, nodes[index])
-				}
-				node := strings.Join(nodeKey, ".")
-				_, exist := groups[node]
-
-				if !exist {
-					nodeKeys = append(nodeKeys, node)
-				}
-
-				groups[node] = append(groups[node], series)
-			}
+	return results, nil
+}
 
-			return groups, nodeKeys
+// asPercentByNodes matches the series with their totals by the nodes of their
+// names. Without a total the series of each group are the percentage of their
+// sum; series and totals without a match give all absent series.
+func asPercentByNodes(ctx context.Context, e parser.Expr, arg []*types.MetricData, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	var total []*types.MetricData
+	if !isNone(e.Args()[1]) {
+		var err error
+		total, err = helper.GetSeriesArg(ctx, e.Args()[1], from, until, values)
+		if err != nil && err != parser.ErrSeriesDoesNotExist {
+			return nil, err
 		}
+	}
 
-		distinct := func(slice []string) []string {
-			keys := make(map[string]bool)
-			var list []string
-			for _, entry := range slice {
-				if _, value := keys[entry]; !value {
-					keys[entry] = true
-					list = append(list, entry)
-				}
-			}
-			return list
-		}
+	nodeIndexes, err := e.GetIntArgs(2)
+	if err != nil {
+		return nil, err
+	}
 
-		metaSeriesGroup, metaKeys := groupByNodes(arg, nodeIndexes)
+	metaSeriesGroup, metaKeys := helper.GroupByNodes(arg, nodeIndexes)
 
-		totalSeriesGroup := make(map[string]*types.MetricData)
-		var groups map[string][]*types.MetricData
-		var groupKeys []string
+	groups, groupKeys := metaSeriesGroup, metaKeys
+	if total != nil {
+		groups, groupKeys = helper.GroupByNodes(total, nodeIndexes)
+	}
 
-		if len(total) == 0 {
-			groups, groupKeys = metaSeriesGroup, metaKeys
+	totalSeriesGroup := make(map[string]*types.MetricData)
+	for _, nodeKey := range groupKeys {
+		if len(groups[nodeKey]) == 1 {
+			totalSeriesGroup[nodeKey] = groups[nodeKey][0]
 		} else {
-			groups, groupKeys = groupByNodes(total, nodeIndexes)
+			totalSeriesGroup[nodeKey] = sumSeries(seriesNames("sumSeries", groups[nodeKey]), helper.Normalize(groups[nodeKey]))
 		}
+	}
 
-		for _, nodeKey := range groupKeys {
-			if len(groups[nodeKey]) == 1 {
-				totalSeriesGroup[nodeKey] = groups[nodeKey][0]
-			} else {
-				totalSeriesGroup[nodeKey], err = sumSeries(groups[nodeKey])
-				if err != nil {
-					return nil, err
-				}
-			}
+	var results []*types.MetricData
+	for _, nodeKey := range distinct(append(metaKeys[:len(metaKeys):len(metaKeys)], groupKeys...)) {
+		metaSeriesList, existInMeta := metaSeriesGroup[nodeKey]
+		if !existInMeta {
+			totalSeries := totalSeriesGroup[nodeKey]
+			results = append(results, missing(fmt.Sprintf("asPercent(MISSING,%s)", totalSeries.Name), totalSeries))
+			continue
 		}
 
-		nodeKeys := distinct(append(metaKeys, groupKeys...))
-
-		for _, nodeKey := range nodeKeys {
-			metaSeriesList, existInMeta := metaSeriesGroup[nodeKey]
-			if !existInMeta {
-				totalSeries := totalSeriesGroup[nodeKey]
-				result := *totalSeries
-				result.Name = fmt.Sprintf("asPercent(MISSING,%s)", totalSeries.Name)
-				result.Values = make([]float64, len(totalSeries.Values))
-				result.IsAbsent = make([]bool, len(totalSeries.Values))
-				for i := range result.Values {
-					result.Values[i] = 0
-					result.IsAbsent[i] = true
-				}
-
-				results = append(results, &result)
+		for _, metaSeries := range metaSeriesList {
+			totalSeries, existInTotal := totalSeriesGroup[nodeKey]
+			if !existInTotal {
+				results = append(results, missing(fmt.Sprintf("asPercent(%s,MISSING)", metaSeries.Name), metaSeries))
 				continue
 			}
 
-			for _, metaSeries := range metaSeriesList {
-				result := *metaSeries
-				totalSeries, existInTotal := totalSeriesGroup[nodeKey]
-				if !existInTotal {
-					result.Name = fmt.Sprintf("asPercent(%s,MISSING)", metaSeries.Name)
-					result.Values = make([]float64, len(metaSeries.Values))
-					result.IsAbsent = make([]bool, len(metaSeries.Values))
-					for i := range result.Values {
-						result.Values[i] = 0
-						result.IsAbsent[i] = true
-					}
-				} else {
-					result.Name = fmt.Sprintf("asPercent(%s,%s)", metaSeries.Name, totalSeries.Name)
-					result.Values = make([]float64, len(metaSeries.Values))
-					result.IsAbsent = make([]bool, len(metaSeries.Values))
-					for i := range metaSeries.Values {
-						if metaSeries.IsAbsent[i] || totalSeries.IsAbsent[i] {
-							result.Values[i] = 0
-							result.IsAbsent[i] = true
-							continue
-						}
-						result.Values[i] = (metaSeries.Values[i] / totalSeries.Values[i]) * 100
-					}
-				}
-
-				results = append(results, &result)
-			}
+			results = append(results, percentOf(fmt.Sprintf("asPercent(%s,%s)", metaSeries.Name, totalSeries.Name), metaSeries, totalSeries))
 		}
+	}
 
-		return results, nil
+	return results, nil
+}
 
-	} else {
-		return nil, errors.New("total must be either a constant or a series")
+// percentOf returns a as a percentage of total, after bringing the two to
+// the same step and time range.
+func percentOf(name string, a, total *types.MetricData) *types.MetricData {
+	aligned := helper.Normalize([]*types.MetricData{a, total})
+	a, total = aligned[0], aligned[1]
+
+	r := *a
+	r.Name = name
+	r.Values = make([]float64, len(a.Values))
+	r.IsAbsent = make([]bool, len(a.Values))
+	for i := range a.Values {
+		if a.IsAbsent[i] || i >= len(total.Values) || total.IsAbsent[i] || total.Values[i] == 0 {
+			r.IsAbsent[i] = true
+			continue
+		}
+		r.Values[i] = (a.Values[i] / total.Values[i]) * 100
 	}
 
-	if multipleSeries {
-		/* We should have two equal length lists of arguments
-		   First one will be numerators
-		   Second one - denominators
-
-		   For each of them we will compute numerator/denominator
-		*/
-		for i := range numerators {
-			a := numerators[i]
-			b := denominators[i]
+	return &r
+}
 
-			r := *a
-			r.Name = formatName(a.Name, b.Name)
-			r.Values = make([]float64, len(a.Values))
-			r.IsAbsent = make([]bool, len(a.Values))
-			for k := range a.Values {
-				if a.IsAbsent[k] || b.IsAbsent[k] {
-					r.Values[k] = 0
-					r.IsAbsent[k] = true
-					continue
-				}
-				r.Values[k] = (a.Values[k] / b.Values[k]) * 100
+// sumSeries sums aligned series the way sumSeries does, absent points are
+// left out and points absent in all series stay absent.
+func sumSeries(name string, args []*types.MetricData) *types.MetricData {
+	r := *args[0]
+	r.Name = name
+	r.Values = make([]float64, len(args[0].Values))
+	r.IsAbsent = make([]bool, len(args[0].Values))
+	for i := range r.Values {
+		r.IsAbsent[i] = true
+		for _, a := range args {
+			if i < len(a.Values) && !a.IsAbsent[i] {
+				r.Values[i] += a.Values[i]
+				r.IsAbsent[i] = false
 			}
-			results = append(results, &r)
-		}
-	} else {
-		for _, a := range arg {
-			r := *a
-			r.Name = formatName(a.Name, totalString)
-			r.Values = make([]float64, len(a.Values))
-			r.IsAbsent = make([]bool, len(a.Values))
-			results = append(results, &r)
 		}
+	}
 
-		for i := range results[0].Values {
+	return &r
+}
 
-			total := getTotal(i)
+func missing(name string, like *types.MetricData) *types.MetricData {
+	r := *like
+	r.Name = name
+	r.Values = make([]float64, len(like.Values))
+	r.IsAbsent = make([]bool, len(like.Values))
+	for i := range r.IsAbsent {
+		r.IsAbsent[i] = true
+	}
 
-			for j := range results {
-				r := results[j]
-				a := arg[j]
+	return &r
+}
 
-				if a.IsAbsent[i] || math.IsNaN(total) || total == 0 {
-					r.Values[i] = 0
-					r.IsAbsent[i] = true
-					continue
-				}
+func seriesNames(target string, args []*types.MetricData) string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = a.Name
+	}
 
-				r.Values[i] = (a.Values[i] / total) * 100
-			}
+	return fmt.Sprintf("%s(%s)", target, strings.Join(names, ","))
+}
+
+func isNone(e parser.Expr) bool {
+	return e.IsName() && e.Target() == "None"
+}
+
+func distinct(slice []string) []string {
+	keys := make(map[string]bool)
+	var list []string
+	for _, entry := range slice {
+		if _, value := keys[entry]; !value {
+			keys[entry] = true
+			list = append(list, entry)
 		}
 	}
-	return results, nil
+	return list
 }
 
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
@@ -95,6 +95,57 @@ func TestAliasByNode(t *testing.T) {
 				types.MakeMetricData("asPercent(MISSING,Server3.memory.total)", []float64{math.NaN(), math.NaN(), math.NaN()}, 1, now32),
 			},
 		},
+		{
+			parser.NewExpr("asPercent",
+				"Server*.cpu.*", "None", 0,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"Server*.cpu.*", 0, 1}: {
+					types.MakeMetricData("Server1.cpu.user", []float64{1, 3, math.NaN()}, 1, now32),
+					types.MakeMetricData("Server1.cpu.system", []float64{3, 1, 2}, 1, now32),
+					types.MakeMetricData("Server2.cpu.user", []float64{5, 0, 0}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("asPercent(Server1.cpu.user,sumSeries(Server1.cpu.user,Server1.cpu.system))", []float64{25, 75, math.NaN()}, 1, now32),
+				types.MakeMetricData("asPercent(Server1.cpu.system,sumSeries(Server1.cpu.user,Server1.cpu.system))", []float64{75, 25, 100}, 1, now32),
+				types.MakeMetricData("asPercent(Server2.cpu.user,Server2.cpu.user)", []float64{100, math.NaN(), math.NaN()}, 1, now32),
+			},
+		},
+		{
+			parser.NewExpr("asPercent",
+				"Server*.connections.{failed,succeeded}", "Server*.connections.attempted", 0,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"Server*.connections.{failed,succeeded}", 0, 1}: {
+					types.MakeMetricData("Server1.connections.failed", []float64{1, 2}, 1, now32),
+					types.MakeMetricData("Server1.connections.succeeded", []float64{3, 2}, 1, now32),
+					types.MakeMetricData("Server2.connections.failed", []float64{0, 5}, 1, now32),
+				},
+				{"Server*.connections.attempted", 0, 1}: {
+					types.MakeMetricData("Server1.connections.attempted", []float64{4, 4}, 1, now32),
+					types.MakeMetricData("Server2.connections.attempted", []float64{10, 10}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("asPercent(Server1.connections.failed,Server1.connections.attempted)", []float64{25, 50}, 1, now32),
+				types.MakeMetricData("asPercent(Server1.connections.succeeded,Server1.connections.attempted)", []float64{75, 50}, 1, now32),
+				types.MakeMetricData("asPercent(Server2.connections.failed,Server2.connections.attempted)", []float64{0, 50}, 1, now32),
+			},
+		},
+		{
+			// the total has a coarser step, the series is consolidated to it
+			parser.NewExpr("asPercent",
+				"metric1", "metric2",
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4}, 1, now32)},
+				{"metric2", 0, 1}: {types.MakeMetricData("metric2", []float64{10, 20}, 2, now32)},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("asPercent(metric1,metric2)", []float64{15, 17.5}, 2, now32),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,76 @@
+package averageOutsidePercentile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+// Expected values are graphite-web's.
+func TestAverageOutsidePercentile(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	input := map[parser.MetricRequest][]*types.MetricData{
+		{"metric.*", 0, 1}: {
+			types.MakeMetricData("metric.a", []float64{1, 1, 1}, 1, now32),
+			types.MakeMetricData("metric.b", []float64{5, 6, 4}, 1, now32),
+			types.MakeMetricData("metric.c", []float64{10, 10, 10}, 1, now32),
+			types.MakeMetricData("metric.d", []float64{20, 21, 19}, 1, now32),
+			types.MakeMetricData("metric.e", []float64{30, 30, 30}, 1, now32),
+			types.MakeMetricData("metric.empty", []float64{nan, nan, nan}, 1, now32),
+		},
+	}
+
+	outside := []*types.MetricData{
+		types.MakeMetricData("metric.a", []float64{1, 1, 1}, 1, now32),
+		types.MakeMetricData("metric.b", []float64{5, 6, 4}, 1, now32),
+		types.MakeMetricData("metric.e", []float64{30, 30, 30}, 1, now32),
+		types.MakeMetricData("metric.empty", []float64{nan, nan, nan}, 1, now32),
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("averageOutsidePercentile", "metric.*", 70),
+			input,
+			outside,
+		},
+		{
+			parser.NewExpr("averageOutsidePercentile", "metric.*", 30),
+			input,
+			outside,
+		},
+		{
+			parser.NewExpr("averageOutsidePercentile", "metric.*", 95),
+			input,
+			[]*types.MetricData{
+				types.MakeMetricData("metric.a", []float64{1, 1, 1}, 1, now32),
+				types.MakeMetricData("metric.e", []float64{30, 30, 30}, 1, now32),
+				types.MakeMetricData("metric.empty", []float64{nan, nan, nan}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -38,6 +38,18 @@ func TestAverageSeries(t *testing.T) {
 			[]*types.MetricData{types.MakeMetricData("averageSeries(metric1,metric2,metric3)",
 				[]float64{2, math.NaN(), 3, 4, 5, 5.5}, 1, now32)},
 		},
+		{
+			parser.NewExpr("averageSeries",
+				"metric1", "metric2", "metric3",
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {withXFilesFactor(types.MakeMetricData("metric1", []float64{1, math.NaN(), 2, 3}, 1, now32), 0.5)},
+				{"metric2", 0, 1}: {types.MakeMetricData("metric2", []float64{2, math.NaN(), math.NaN(), math.NaN()}, 1, now32)},
+				{"metric3", 0, 1}: {types.MakeMetricData("metric3", []float64{3, 1, math.NaN(), 5}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("averageSeries(metric1,metric2,metric3)",
+				[]float64{2, math.NaN(), math.NaN(), 4}, 1, now32)},
+		},
 	}
 
 	for _, tt := range tests {
@@ -48,3 +60,8 @@ func TestAverageSeries(t *testing.T) {
 	}
 
 }
+
+func withXFilesFactor(m *types.MetricData, xFilesFactor float32) *types.MetricData {
+	m.XFilesFactor = xFilesFactor
+	return m
+}
@@ -4,6 +4,7 @@ package png
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image/color"
 	"io/ioutil"
@@ -670,12 +671,12 @@ func Description() map[string]types.FunctionDescription {
 }
 
 // TODO(civil): Split this into several separate functions.
-func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 
 	switch e.Target() {
 
 	case "color": // color(seriesList, theColor)
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -696,7 +697,7 @@ func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricReq
 		return results, nil
 
 	case "stacked": // stacked(seriesList, stackname="__DEFAULT__")
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -718,7 +719,7 @@ func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricReq
 		return results, nil
 
 	case "areaBetween":
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -758,7 +759,7 @@ func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricReq
 		return []*types.MetricData{&lower, &upper}, nil
 
 	case "alpha": // alpha(seriesList, theAlpha)
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -780,7 +781,7 @@ func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricReq
 		return results, nil
 
 	case "dashed", "drawAsInfinite", "secondYAxis":
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -809,7 +810,7 @@ func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricReq
 		return results, nil
 
 	case "lineWidth": // lineWidth(seriesList, width)
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,433 @@
+// +build !cairo
+
+package png
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+// chart lays out a graph once for both the PNG and the SVG it is drawn as.
+// It supports the basic graph options; the others need the cairo build.
+type chart struct {
+	width, height int
+	// scale is how many pixels wide a pixel of the font is.
+	scale int
+
+	fg, bg, grid color.RGBA
+	fontSize     float64
+	fontName     string
+	lineWidth    float64
+
+	title      string
+	titleY     float64
+	plot       rect
+	yTicks     []tick
+	xTicks     []tick
+	xLabelY    float64
+	legend     []legendItem
+	legendY    float64
+	showYLabel bool
+	showXLabel bool
+
+	shapes []shape
+}
+
+type point struct{ x, y float64 }
+
+type rect struct{ x0, y0, x1, y1 float64 }
+
+// tick is a grid line at pos, labelled label.
+type tick struct {
+	pos   float64
+	label string
+}
+
+type legendItem struct {
+	name  string
+	color color.RGBA
+}
+
+// shape is what is drawn of a series: the polylines of its present points
+// and, depending on the area mode, the polygons under them.
+type shape struct {
+	color color.RGBA
+	lines [][]point
+	areas [][]point
+	alpha float64
+}
+
+func newChart(params PictureParams, results []*types.MetricData) *chart {
+	c := &chart{
+		width:     int(params.Width),
+		height:    int(params.Height),
+		scale:     int(math.Max(1, math.Floor(params.FontSize/10+0.5))),
+		fg:        string2RGBA(params.FgColor),
+		bg:        string2RGBA(params.BgColor),
+		grid:      string2RGBA(params.MajorGridLineColor),
+		fontSize:  params.FontSize,
+		fontName:  params.FontName,
+		lineWidth: params.LineWidth,
+	}
+	if c.width < 1 {
+		c.width = int(DefaultParams.Width)
+	}
+	if c.height < 1 {
+		c.height = int(DefaultParams.Height)
+	}
+	if c.lineWidth <= 0 {
+		c.lineWidth = 1
+	}
+	colors := params.ColorList
+	if len(colors) == 0 {
+		colors = DefaultColorList
+	}
+
+	from, until := timeRange(results)
+	stacked := params.AreaMode == AreaModeStacked
+
+	margin := float64(params.Margin)
+	charH := c.charHeight()
+	c.plot = rect{margin, margin, float64(c.width) - margin, float64(c.height) - margin}
+	if params.GraphOnly {
+		c.plot = rect{0, 0, float64(c.width), float64(c.height)}
+	} else {
+		if params.Title != "" {
+			c.title = params.Title
+			c.titleY = c.plot.y0
+			c.plot.y0 += charH + margin/2
+		}
+		if !params.HideLegend && len(results) > 0 {
+			height := float64(len(results)) * charH
+			// the legend may not take more than half of the picture
+			if c.plot.y1-height-margin/2 > c.plot.y0+(c.plot.y1-c.plot.y0)/2 {
+				for i, r := range results {
+					c.legend = append(c.legend, legendItem{name: r.Name, color: string2RGBA(colors[i%len(colors)])})
+				}
+				c.legendY = c.plot.y1 - height
+				c.plot.y1 = c.legendY - margin/2
+			}
+		}
+		c.showXLabel = !params.HideAxes && !params.HideXAxis
+		c.showYLabel = !params.HideAxes && !params.HideYAxis
+		if c.showXLabel {
+			c.plot.y1 -= charH + 2
+			c.xLabelY = c.plot.y1 + 4
+		}
+	}
+
+	// Series are consolidated to at most a point per pixel.
+	for _, r := range results {
+		if n := len(r.Values); n > 0 {
+			r.SetValuesPerPoint(int(math.Ceil(float64(n) / math.Max(1, c.plot.x1-c.plot.x0))))
+		}
+	}
+
+	tops := make([][]float64, len(results))
+	var base []float64
+	for i, r := range results {
+		values := r.AggregatedValues()
+		absent := r.AggregatedAbsent()
+		tops[i] = make([]float64, len(values))
+		for j, v := range values {
+			if absent[j] || math.IsNaN(v) || math.IsInf(v, 0) {
+				v = math.NaN()
+			}
+			if stacked {
+				if math.IsNaN(v) {
+					v = 0
+				}
+				if j < len(base) {
+					v += base[j]
+				}
+			}
+			tops[i][j] = v
+		}
+		if stacked {
+			base = tops[i]
+		}
+	}
+
+	yMin, yMax := valueRange(tops, params.LogBase)
+	if params.AreaMode != AreaModeNone && params.LogBase == 0 && yMin > 0 {
+		yMin = 0
+	}
+	var ticks []float64
+	if params.LogBase > 0 {
+		yMin, yMax, ticks = logTicks(yMin, yMax, params.YMin, params.YMax, params.LogBase)
+	} else {
+		yMin, yMax, ticks = linearTicks(yMin, yMax, params.YMin, params.YMax)
+	}
+
+	yPos := func(v float64) float64 {
+		ratio := (v - yMin) / (yMax - yMin)
+		if params.LogBase > 0 {
+			if v <= 0 {
+				return math.NaN()
+			}
+			ratio = math.Log(v/yMin) / math.Log(yMax/yMin)
+		}
+		// far off points are only clipped, but must stay finite
+		ratio = math.Max(-1, math.Min(2, ratio))
+		return c.plot.y1 - ratio*(c.plot.y1-c.plot.y0)
+	}
+
+	maxLabel := 0
+	for _, t := range ticks {
+		label := formatValue(t)
+		if len(label) > maxLabel {
+			maxLabel = len(label)
+		}
+		c.yTicks = append(c.yTicks, tick{label: label, pos: t})
+	}
+	if c.showYLabel {
+		c.plot.x0 += float64(maxLabel)*c.charWidth() + 4
+	}
+	for i := range c.yTicks {
+		c.yTicks[i].pos = yPos(c.yTicks[i].pos)
+	}
+
+	xPos := func(t float64) float64 {
+		return c.plot.x0 + (t-float64(from))/float64(until-from)*(c.plot.x1-c.plot.x0)
+	}
+	c.xTicks = timeTicks(from, until, params.Tz, c.plot.x1-c.plot.x0, c.charWidth())
+	for i := range c.xTicks {
+		c.xTicks[i].pos = xPos(c.xTicks[i].pos)
+	}
+
+	for i, r := range results {
+		s := shape{color: string2RGBA(colors[i%len(colors)]), alpha: 1}
+		if !math.IsNaN(params.AreaAlpha) {
+			s.alpha = math.Max(0, math.Min(1, params.AreaAlpha))
+		}
+		filled := params.AreaMode == AreaModeAll || stacked || (params.AreaMode == AreaModeFirst && i == 0)
+
+		step := float64(r.AggregatedTimeStep())
+		var line []point
+		var bottoms []point
+		flush := func() {
+			if len(line) == 0 {
+				return
+			}
+			s.lines = append(s.lines, line)
+			if filled {
+				area := append([]point(nil), line...)
+				for k := len(bottoms) - 1; k >= 0; k-- {
+					area = append(area, bottoms[k])
+				}
+				s.areas = append(s.areas, area)
+			}
+			line, bottoms = nil, nil
+		}
+		for j, v := range tops[i] {
+			y := yPos(v)
+			if math.IsNaN(y) {
+				flush()
+				continue
+			}
+			x := xPos(float64(r.StartTime) + float64(j)*step)
+			line = append(line, point{x, y})
+			bottom := c.plot.y1
+			if stacked && i > 0 && j < len(tops[i-1]) {
+				bottom = yPos(tops[i-1][j])
+			}
+			bottoms = append(bottoms, point{x, bottom})
+		}
+		flush()
+		c.shapes = append(c.shapes, s)
+	}
+
+	return c
+}
+
+func (c *chart) charWidth() float64 {
+	return float64((glyphWidth + 1) * c.scale)
+}
+
+func (c *chart) charHeight() float64 {
+	return float64((glyphHeight + 3) * c.scale)
+}
+
+// timeRange returns the time the series start and stop at.
+func timeRange(results []*types.MetricData) (int32, int32) {
+	var from, until int32
+	for i, r := range results {
+		if i == 0 || r.StartTime < from {
+			from = r.StartTime
+		}
+		if i == 0 || r.StopTime > until {
+			until = r.StopTime
+		}
+	}
+	if until <= from {
+		until = from + 1
+	}
+
+	return from, until
+}
+
+// valueRange returns the lowest and highest of values, ignoring those that
+// can't be drawn with logBase.
+func valueRange(values [][]float64, logBase float64) (float64, float64) {
+	yMin, yMax := math.NaN(), math.NaN()
+	for _, vs := range values {
+		for _, v := range vs {
+			if math.IsNaN(v) || (logBase > 0 && v <= 0) {
+				continue
+			}
+			if math.IsNaN(yMin) || v < yMin {
+				yMin = v
+			}
+			if math.IsNaN(yMax) || v > yMax {
+				yMax = v
+			}
+		}
+	}
+
+	return yMin, yMax
+}
+
+// linearTicks rounds the range out to a step of about a fifth of it, unless
+// fixed by min and max, and returns the steps in it.
+func linearTicks(yMin, yMax, min, max float64) (float64, float64, []float64) {
+	if math.IsNaN(yMin) {
+		yMin, yMax = 0, 1
+	}
+	if !math.IsNaN(min) {
+		yMin = min
+	}
+	if !math.IsNaN(max) {
+		yMax = max
+	}
+	if yMax < yMin {
+		yMin, yMax = yMax, yMin
+	}
+	if yMax == yMin {
+		yMax = yMin + math.Max(1, math.Abs(yMin)/10)
+	}
+
+	step := niceStep((yMax - yMin) / 5)
+	if math.IsNaN(min) {
+		yMin = math.Floor(yMin/step) * step
+	}
+	if math.IsNaN(max) {
+		yMax = math.Ceil(yMax/step) * step
+	}
+
+	var ticks []float64
+	for i := math.Ceil(yMin / step); i*step <= yMax+step/1e6; i++ {
+		ticks = append(ticks, i*step)
+	}
+
+	return yMin, yMax, ticks
+}
+
+func niceStep(raw float64) float64 {
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 2.5, 5} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// logTicks rounds the range out to powers of base, unless fixed by min and
+// max, and returns the powers in it.
+func logTicks(yMin, yMax, min, max, base float64) (float64, float64, []float64) {
+	if math.IsNaN(yMin) {
+		yMin, yMax = 1, base
+	}
+	if !math.IsNaN(min) && min > 0 {
+		yMin = min
+	} else {
+		yMin = math.Pow(base, math.Floor(math.Log(yMin)/math.Log(base)))
+	}
+	if !math.IsNaN(max) && max > yMin {
+		yMax = max
+	} else {
+		yMax = math.Pow(base, math.Ceil(math.Log(yMax)/math.Log(base)))
+	}
+	if yMax <= yMin {
+		yMax = yMin * base
+	}
+
+	var ticks []float64
+	for p := math.Ceil(math.Log(yMin)/math.Log(base) - 1e-9); ; p++ {
+		v := math.Pow(base, p)
+		if v > yMax*(1+1e-9) {
+			break
+		}
+		ticks = append(ticks, v)
+	}
+
+	return yMin, yMax, ticks
+}
+
+var siPrefixes = []struct {
+	value  float64
+	prefix string
+}{
+	{1e15, "P"},
+	{1e12, "T"},
+	{1e9, "G"},
+	{1e6, "M"},
+	{1e3, "K"},
+}
+
+// formatValue formats axis values with SI prefixes, as yUnitSystem=si does.
+func formatValue(v float64) string {
+	for _, p := range siPrefixes {
+		if math.Abs(v) >= p.value {
+			return strconv.FormatFloat(v/p.value, 'g', 4, 64) + p.prefix
+		}
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+var timeSteps = []int32{1, 5, 10, 30, 60, 120, 300, 600, 900, 1800, 3600, 2 * 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400, 2 * 86400, 7 * 86400, 14 * 86400, 30 * 86400, 91 * 86400, 365 * 86400}
+
+// timeTicks returns ticks at round times between from and until, as many as
+// their labels fit in width.
+func timeTicks(from, until int32, tz *time.Location, width, charWidth float64) []tick {
+	if tz == nil {
+		tz = time.Local
+	}
+
+	span := until - from
+	layout := "15:04"
+	if span > 2*86400 {
+		layout = "01/02"
+	} else if span > 86400 {
+		layout = "01/02 15:04"
+	}
+	max := int32(width / (float64(len(layout)+2) * charWidth))
+	if max < 1 {
+		max = 1
+	}
+
+	step := timeSteps[len(timeSteps)-1]
+	for _, s := range timeSteps {
+		if span/s <= max {
+			step = s
+			break
+		}
+	}
+
+	_, offset := time.Unix(int64(from), 0).In(tz).Zone()
+	first := from - (from+int32(offset))%step
+	if first < from {
+		first += step
+	}
+
+	var ticks []tick
+	for t := first; t <= until; t += step {
+		ticks = append(ticks, tick{pos: float64(t), label: time.Unix(int64(t), 0).In(tz).Format(layout)})
+	}
+
+	return ticks
+}
@@ -0,0 +1,130 @@
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"image"
+	imagepng "image/png"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+func TestLinearTicks(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name             string
+		yMin, yMax       float64
+		min, max         float64
+		wantMin, wantMax float64
+		want             string
+	}{
+		{"rounded out", 3, 97, nan, nan, 0, 100, "0 20 40 60 80 100"},
+		{"fixed", 3, 97, 10, 50, 10, 50, "10 20 30 40 50"},
+		{"no values", nan, nan, nan, nan, 0, 1, "0 0.2 0.4 0.6 0.8 1"},
+		{"flat", 5, 5, nan, nan, 5, 6, "5 5.2 5.4 5.6 5.8 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yMin, yMax, ticks := linearTicks(tt.yMin, tt.yMax, tt.min, tt.max)
+			if yMin != tt.wantMin || yMax != tt.wantMax {
+				t.Errorf("expected range %v..%v, got %v..%v", tt.wantMin, tt.wantMax, yMin, yMax)
+			}
+			var labels []string
+			for _, v := range ticks {
+				labels = append(labels, formatValue(v))
+			}
+			if got := strings.Join(labels, " "); got != tt.want {
+				t.Errorf("expected ticks %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLogTicks(t *testing.T) {
+	yMin, yMax, ticks := logTicks(3, 4000, math.NaN(), math.NaN(), 10)
+	if yMin != 1 || yMax != 10000 {
+		t.Errorf("expected range 1..10000, got %v..%v", yMin, yMax)
+	}
+	if want := []float64{1, 10, 100, 1000, 10000}; !reflect.DeepEqual(ticks, want) {
+		t.Errorf("expected ticks %v, got %v", want, ticks)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	for v, want := range map[float64]string{0: "0", 0.25: "0.25", 1500: "1.5K", -2e6: "-2M", 3e9: "3G"} {
+		if got := formatValue(v); got != want {
+			t.Errorf("%v: expected %q, got %q", v, want, got)
+		}
+	}
+}
+
+func testSeries() []*types.MetricData {
+	return []*types.MetricData{
+		types.MakeMetricData("web.cpu", []float64{1, 2, math.NaN(), 4, 5, 6}, 60, 1500000000),
+		types.MakeMetricData("db.cpu", []float64{6, 5, 4, 3, 2, 1}, 60, 1500000000),
+	}
+}
+
+func testRequest(t *testing.T, query string) *http.Request {
+	r, err := http.NewRequest("GET", "/render?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestMarshalPNG(t *testing.T) {
+	r := testRequest(t, "width=200&height=120&bgcolor=white&colorList=ff0000,0000ff&areaMode=first&yMax=10")
+	b := MarshalPNGRequest(r, testSeries(), "default")
+
+	img, err := imagepng.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(200, 120) {
+		t.Fatalf("expected a 200x120 picture, got %v", got)
+	}
+
+	var red, blue bool
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			red = red || (r == 0xffff && g == 0 && b == 0)
+			blue = blue || (r == 0 && g == 0 && b == 0xffff)
+		}
+	}
+	if !red || !blue {
+		t.Errorf("expected both series drawn, red %v, blue %v", red, blue)
+	}
+}
+
+func TestMarshalSVG(t *testing.T) {
+	r := testRequest(t, "width=300&height=200&title=CPU+%3Cusage%3E&areaMode=stacked&logBase=10")
+	svg := string(MarshalSVGRequest(r, testSeries(), "default"))
+
+	for _, want := range []string{
+		`width="300" height="200"`,
+		`>CPU &lt;usage&gt;</text>`,
+		`>web.cpu</text>`,
+		`>db.cpu</text>`,
+		`<polygon points=`,
+		`<polyline points=`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("expected SVG to contain %q", want)
+		}
+	}
+	// stacked, the NaN of the first series is 0, which splits its line on a
+	// log scale
+	if n := strings.Count(svg, `stroke-linejoin="round"`); n != 3 {
+		t.Errorf("expected 3 series lines, got %d", n)
+	}
+}
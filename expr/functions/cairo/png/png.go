@@ -3,31 +3,36 @@
 package png
 
 import (
+	"context"
+	"net/http"
+
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/pkg/parser"
-	"net/http"
 )
 
+// HaveGraphSupport tells if the graph functions, like color() or
+// lineWidth(), and the full set of graph options are supported. Without
+// cairo graphs are drawn in process with the basic options only.
 const HaveGraphSupport = false
 
-func EvalExprGraph(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	return nil, nil
 }
 
 func MarshalPNG(params PictureParams, results []*types.MetricData) []byte {
-	return nil
+	return newChart(params, results).png()
 }
 
 func MarshalSVG(params PictureParams, results []*types.MetricData) []byte {
-	return nil
+	return newChart(params, results).svg()
 }
 
 func MarshalPNGRequest(r *http.Request, results []*types.MetricData, templateName string) []byte {
-	return nil
+	return MarshalPNG(GetPictureParamsWithTemplate(r, templateName, results), results)
 }
 
 func MarshalSVGRequest(r *http.Request, results []*types.MetricData, templateName string) []byte {
-	return nil
+	return MarshalSVG(GetPictureParamsWithTemplate(r, templateName, results), results)
 }
 
 func Description() map[string]types.FunctionDescription {
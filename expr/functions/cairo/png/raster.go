@@ -0,0 +1,184 @@
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	imagepng "image/png"
+	"math"
+	"sort"
+)
+
+type align int
+
+const (
+	alignLeft align = iota
+	alignCenter
+	alignRight
+)
+
+// png draws the chart as a PNG.
+func (c *chart) png() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c.bg}, image.ZP, draw.Src)
+
+	plotRect := image.Rect(int(c.plot.x0), int(c.plot.y0), int(math.Ceil(c.plot.x1)), int(math.Ceil(c.plot.y1)))
+	plot := img.SubImage(plotRect).(*image.RGBA)
+
+	for _, t := range c.yTicks {
+		for x := plotRect.Min.X; x < plotRect.Max.X; x++ {
+			blend(plot, x, int(t.pos), c.grid, 0.4)
+		}
+		if c.showYLabel {
+			c.text(img, t.label, c.plot.x0-4, t.pos-c.charHeight()/2+float64(c.scale), alignRight)
+		}
+	}
+	for _, t := range c.xTicks {
+		for y := plotRect.Min.Y; y < plotRect.Max.Y; y++ {
+			blend(plot, int(t.pos), y, c.grid, 0.4)
+		}
+		if c.showXLabel {
+			c.text(img, t.label, t.pos, c.xLabelY, alignCenter)
+		}
+	}
+
+	for _, s := range c.shapes {
+		for _, area := range s.areas {
+			fillPolygon(plot, area, s.color, s.alpha)
+		}
+		for _, line := range s.lines {
+			strokePolyline(plot, line, s.color, c.lineWidth)
+		}
+	}
+
+	if c.showXLabel || c.showYLabel {
+		strokePolyline(img, []point{{c.plot.x0, c.plot.y0}, {c.plot.x0, c.plot.y1}, {c.plot.x1, c.plot.y1}}, c.fg, 1)
+	}
+
+	if c.title != "" {
+		c.text(img, c.title, float64(c.width)/2, c.titleY, alignCenter)
+	}
+	for i, l := range c.legend {
+		y := c.legendY + float64(i)*c.charHeight()
+		box := c.charHeight() - 2*float64(c.scale)
+		draw.Draw(img, image.Rect(int(c.plot.x0), int(y), int(c.plot.x0+box), int(y+box)), &image.Uniform{C: l.color}, image.ZP, draw.Src)
+		c.text(img, l.name, c.plot.x0+box+c.charWidth(), y, alignLeft)
+	}
+
+	var b bytes.Buffer
+	if err := imagepng.Encode(&b, img); err != nil {
+		return nil
+	}
+
+	return b.Bytes()
+}
+
+// text draws s with its top at y, and its left, center or right at x.
+func (c *chart) text(img *image.RGBA, s string, x, y float64, a align) {
+	runes := []rune(s)
+	width := float64(len(runes)) * c.charWidth()
+	switch a {
+	case alignCenter:
+		x -= width / 2
+	case alignRight:
+		x -= width
+	}
+
+	for i, r := range runes {
+		g := glyph(r)
+		left := int(x) + i*int(c.charWidth())
+		for row, bits := range g {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(1<<uint(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				for dy := 0; dy < c.scale; dy++ {
+					for dx := 0; dx < c.scale; dx++ {
+						blend(img, left+col*c.scale+dx, int(y)+row*c.scale+dy, c.fg, 1)
+					}
+				}
+			}
+		}
+	}
+}
+
+// blend draws a pixel of clr with the given opacity over what is there, if
+// it falls inside img.
+func blend(img *image.RGBA, x, y int, clr color.RGBA, alpha float64) {
+	if !image.Pt(x, y).In(img.Bounds()) {
+		return
+	}
+
+	i := img.PixOffset(x, y)
+	pix := img.Pix[i : i+4 : i+4]
+	pix[0] = uint8(float64(clr.R)*alpha + float64(pix[0])*(1-alpha))
+	pix[1] = uint8(float64(clr.G)*alpha + float64(pix[1])*(1-alpha))
+	pix[2] = uint8(float64(clr.B)*alpha + float64(pix[2])*(1-alpha))
+	pix[3] = 0xff
+}
+
+// strokePolyline draws lines through pts, width pixels wide.
+func strokePolyline(img *image.RGBA, pts []point, clr color.RGBA, width float64) {
+	w := int(math.Max(1, math.Floor(width+0.5)))
+	stamp := func(x, y float64) {
+		x0, y0 := int(math.Floor(x))-(w-1)/2, int(math.Floor(y))-(w-1)/2
+		for dy := 0; dy < w; dy++ {
+			for dx := 0; dx < w; dx++ {
+				blend(img, x0+dx, y0+dy, clr, 1)
+			}
+		}
+	}
+
+	if len(pts) == 1 {
+		stamp(pts[0].x, pts[0].y)
+		return
+	}
+	for i := 1; i < len(pts); i++ {
+		p, q := pts[i-1], pts[i]
+		n := int(math.Ceil(2*math.Hypot(q.x-p.x, q.y-p.y))) + 1
+		for k := 0; k <= n; k++ {
+			f := float64(k) / float64(n)
+			stamp(p.x+f*(q.x-p.x), p.y+f*(q.y-p.y))
+		}
+	}
+}
+
+// fillPolygon fills the polygon with corners pts, even-odd, a scanline at a
+// time.
+func fillPolygon(img *image.RGBA, pts []point, clr color.RGBA, alpha float64) {
+	if len(pts) < 3 {
+		return
+	}
+
+	b := img.Bounds()
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range pts {
+		minY = math.Min(minY, p.y)
+		maxY = math.Max(maxY, p.y)
+	}
+
+	var xs []float64
+	for y := int(math.Max(float64(b.Min.Y), math.Floor(minY))); y < b.Max.Y && float64(y) <= maxY; y++ {
+		sy := float64(y) + 0.5
+		xs = xs[:0]
+		for i := range pts {
+			p, q := pts[i], pts[(i+1)%len(pts)]
+			if (p.y <= sy) == (q.y <= sy) {
+				continue
+			}
+			xs = append(xs, p.x+(sy-p.y)/(q.y-p.y)*(q.x-p.x))
+		}
+		sort.Float64s(xs)
+
+		for i := 0; i+1 < len(xs); i += 2 {
+			x0 := int(math.Max(float64(b.Min.X), math.Floor(xs[i]+0.5)))
+			x1 := int(math.Min(float64(b.Max.X), math.Floor(xs[i+1]+0.5)))
+			for x := x0; x < x1; x++ {
+				blend(img, x, y, clr, alpha)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"strconv"
+)
+
+// svg draws the chart as an SVG document.
+func (c *chart) svg() []byte {
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", c.width, c.height, c.width, c.height)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", hexColor(c.bg))
+	fmt.Fprintf(&b, `<defs><clipPath id="plot"><rect x="%s" y="%s" width="%s" height="%s"/></clipPath></defs>`+"\n",
+		num(c.plot.x0), num(c.plot.y0), num(c.plot.x1-c.plot.x0), num(c.plot.y1-c.plot.y0))
+	fmt.Fprintf(&b, `<g font-family="%s" font-size="%s" fill="%s">`+"\n", escape(c.fontName), num(c.fontSize), hexColor(c.fg))
+
+	for _, t := range c.yTicks {
+		fmt.Fprintf(&b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-width="0.4"/>`+"\n",
+			num(c.plot.x0), num(t.pos), num(c.plot.x1), num(t.pos), hexColor(c.grid))
+		if c.showYLabel {
+			fmt.Fprintf(&b, `<text x="%s" y="%s" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n",
+				num(c.plot.x0-4), num(t.pos), escape(t.label))
+		}
+	}
+	for _, t := range c.xTicks {
+		fmt.Fprintf(&b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-width="0.4"/>`+"\n",
+			num(t.pos), num(c.plot.y0), num(t.pos), num(c.plot.y1), hexColor(c.grid))
+		if c.showXLabel {
+			fmt.Fprintf(&b, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="hanging">%s</text>`+"\n",
+				num(t.pos), num(c.xLabelY), escape(t.label))
+		}
+	}
+
+	b.WriteString(`<g clip-path="url(#plot)">` + "\n")
+	for _, s := range c.shapes {
+		for _, area := range s.areas {
+			fmt.Fprintf(&b, `<polygon points="%s" fill="%s" fill-opacity="%s" stroke="none"/>`+"\n",
+				points(area), hexColor(s.color), num(s.alpha))
+		}
+		for _, line := range s.lines {
+			fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%s" stroke-linejoin="round"/>`+"\n",
+				points(line), hexColor(s.color), num(c.lineWidth))
+		}
+	}
+	b.WriteString("</g>\n")
+
+	if c.showXLabel || c.showYLabel {
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1"/>`+"\n",
+			points([]point{{c.plot.x0, c.plot.y0}, {c.plot.x0, c.plot.y1}, {c.plot.x1, c.plot.y1}}), hexColor(c.fg))
+	}
+
+	if c.title != "" {
+		fmt.Fprintf(&b, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="hanging">%s</text>`+"\n",
+			num(float64(c.width)/2), num(c.titleY), escape(c.title))
+	}
+	for i, l := range c.legend {
+		y := c.legendY + float64(i)*c.charHeight()
+		box := c.charHeight() - 2*float64(c.scale)
+		fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+			num(c.plot.x0), num(y), num(box), num(box), hexColor(l.color))
+		fmt.Fprintf(&b, `<text x="%s" y="%s" dominant-baseline="hanging">%s</text>`+"\n",
+			num(c.plot.x0+box+c.charWidth()), num(y), escape(l.name))
+	}
+
+	b.WriteString("</g>\n</svg>\n")
+
+	return b.Bytes()
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func points(pts []point) string {
+	var b []byte
+	for i, p := range pts {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = strconv.AppendFloat(b, p.x, 'f', 2, 64)
+		b = append(b, ',')
+		b = strconv.AppendFloat(b, p.y, 'f', 2, 64)
+	}
+	return string(b)
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+type eventsFunction struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &eventsFunction{}
+	functions := []string{"events"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// events(*tags)
+func (f *eventsFunction) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	store := events.Default()
+	if store == nil {
+		return nil, events.ErrNotConfigured
+	}
+
+	var tags []string
+	for i := range e.Args() {
+		tag, err := e.GetStringArg(i)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	// graphite-web treats a single "*" as "any tag"
+	query := events.Query{From: from, Until: until}
+	if len(tags) != 1 || tags[0] != "*" {
+		query.Tags = tags
+	}
+
+	evs, err := store.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// one point per second, like graphite-web does
+	points := int(until - from)
+	if points < 0 {
+		points = 0
+	}
+	newValues := make([]float64, points)
+	isAbsent := make([]bool, points)
+	for i := range isAbsent {
+		isAbsent[i] = true
+	}
+
+	for _, ev := range evs {
+		i := int(ev.When) - int(from)
+		if i < 0 || i >= points {
+			continue
+		}
+		newValues[i]++
+		isAbsent[i] = false
+	}
+
+	p := types.MetricData{
+		FetchResponse: pb.FetchResponse{
+			Name:      "events(\"" + strings.Join(tags, "\",\"") + "\")",
+			StartTime: from,
+			StopTime:  until,
+			StepTime:  1,
+			Values:    newValues,
+			IsAbsent:  isAbsent,
+		},
+	}
+
+	return []*types.MetricData{&p}, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *eventsFunction) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"events": {
+			Description: "Returns the number of events at this point in time. Usable with\ndrawAsInfinite.\n\nExample:\n\n.. code-block:: none\n\n  &target=events(\"tag-one\", \"tag-two\")\n  &target=events(\"*\")\n\nReturns all events tagged as \"tag-one\" and \"tag-two\" and the second one\nreturns all events.",
+			Function:    "events(*tags)",
+			Group:       "Special",
+			Module:      "graphite.render.functions",
+			Name:        "events",
+			Params: []types.FunctionParam{
+				{
+					Multiple: true,
+					Name:     "tags",
+					Required: true,
+					Type:     types.String,
+				},
+			},
+		},
+	}
+}
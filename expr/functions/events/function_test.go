@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/events"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	store := events.NewRing(10)
+	for _, ev := range []events.Event{
+		{When: 101, What: "deploy api", Tags: []string{"deploy", "api"}},
+		{When: 101, What: "deploy web", Tags: []string{"deploy", "web"}},
+		{When: 103, What: "restart api", Tags: []string{"restart", "api"}},
+		{When: 200, What: "out of range", Tags: []string{"deploy"}},
+	} {
+		if err := store.Add(context.Background(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events.SetDefault(store)
+	defer events.SetDefault(nil)
+
+	nan := math.NaN()
+	tests := []struct {
+		e    parser.Expr
+		want *types.MetricData
+	}{
+		{
+			parser.NewExpr("events", parser.ArgValue("deploy")),
+			types.MakeMetricData(`events("deploy")`, []float64{nan, 2, nan, nan, nan}, 1, 100),
+		},
+		{
+			parser.NewExpr("events", parser.ArgValue("deploy"), parser.ArgValue("api")),
+			types.MakeMetricData(`events("deploy","api")`, []float64{nan, 1, nan, nan, nan}, 1, 100),
+		},
+		{
+			parser.NewExpr("events", parser.ArgValue("*")),
+			types.MakeMetricData(`events("*")`, []float64{nan, 2, nan, 1, nan}, 1, 100),
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.e.Target() + "(" + tt.e.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			g, err := metadata.GetEvaluator().EvalExpr(context.Background(), tt.e, 100, 105, nil)
+			if err != nil {
+				t.Fatalf("failed to eval %s: %+v", testName, err)
+			}
+			if len(g) != 1 {
+				t.Fatalf("expected 1 series, got %d", len(g))
+			}
+			if g[0].Name != tt.want.Name {
+				t.Errorf("bad name: got %s, want %s", g[0].Name, tt.want.Name)
+			}
+			if !th.NearlyEqualMetrics(g[0], tt.want) {
+				t.Errorf("different values: got %v, want %v", g[0].Values, tt.want.Values)
+			}
+		})
+	}
+}
+
+func TestEventsNotConfigured(t *testing.T) {
+	_, err := metadata.GetEvaluator().EvalExpr(context.Background(), parser.NewExpr("events", parser.ArgValue("*")), 0, 1, nil)
+	if err != events.ErrNotConfigured {
+		t.Errorf("expected ErrNotConfigured, got %v", err)
+	}
+}
+
+// blockingStore finds events once its context is done.
+type blockingStore struct{}
+
+func (blockingStore) Add(context.Context, events.Event) error { return nil }
+
+func (blockingStore) Find(ctx context.Context, q events.Query) ([]events.Event, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestEventsCancelled(t *testing.T) {
+	events.SetDefault(blockingStore{})
+	defer events.SetDefault(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f := &eventsFunction{}
+	_, err := f.Do(ctx, parser.NewExpr("events", parser.ArgValue("*")), 0, 1, nil)
+	if err != context.Canceled {
+		t.Errorf("expected the lookup to be cancelled, got %v", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/aliasByNode"
 	"github.com/bookingcom/carbonapi/expr/functions/aliasSub"
 	"github.com/bookingcom/carbonapi/expr/functions/asPercent"
+	"github.com/bookingcom/carbonapi/expr/functions/averageOutsidePercentile"
 	"github.com/bookingcom/carbonapi/expr/functions/averageSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/averageSeriesWithWildcards"
 	"github.com/bookingcom/carbonapi/expr/functions/below"
@@ -24,6 +25,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/derivative"
 	"github.com/bookingcom/carbonapi/expr/functions/diffSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/divideSeries"
+	"github.com/bookingcom/carbonapi/expr/functions/events"
 	"github.com/bookingcom/carbonapi/expr/functions/ewma"
 	"github.com/bookingcom/carbonapi/expr/functions/exclude"
 	"github.com/bookingcom/carbonapi/expr/functions/fallbackSeries"
@@ -33,6 +35,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/group"
 	"github.com/bookingcom/carbonapi/expr/functions/groupByNode"
 	"github.com/bookingcom/carbonapi/expr/functions/highest"
+	"github.com/bookingcom/carbonapi/expr/functions/histogram"
 	"github.com/bookingcom/carbonapi/expr/functions/hitcount"
 	"github.com/bookingcom/carbonapi/expr/functions/holtWintersAberration"
 	"github.com/bookingcom/carbonapi/expr/functions/holtWintersConfidenceBands"
@@ -49,6 +52,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/logarithm"
 	"github.com/bookingcom/carbonapi/expr/functions/lowPass"
 	"github.com/bookingcom/carbonapi/expr/functions/lowest"
+	"github.com/bookingcom/carbonapi/expr/functions/madBasedOutlierDetection"
 	"github.com/bookingcom/carbonapi/expr/functions/mapSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/minMax"
 	"github.com/bookingcom/carbonapi/expr/functions/mostDeviant"
@@ -70,10 +74,14 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/rangeOfSeries"
 	"github.com/bookingcom/carbonapi/expr/functions/reduce"
 	"github.com/bookingcom/carbonapi/expr/functions/removeBelowSeries"
+	"github.com/bookingcom/carbonapi/expr/functions/removeBetweenPercentile"
 	"github.com/bookingcom/carbonapi/expr/functions/removeEmptySeries"
 	"github.com/bookingcom/carbonapi/expr/functions/scale"
 	"github.com/bookingcom/carbonapi/expr/functions/scaleToSeconds"
+	"github.com/bookingcom/carbonapi/expr/functions/script"
+	"github.com/bookingcom/carbonapi/expr/functions/seasonalDecompose"
 	"github.com/bookingcom/carbonapi/expr/functions/seriesList"
+	"github.com/bookingcom/carbonapi/expr/functions/setXFilesFactor"
 	"github.com/bookingcom/carbonapi/expr/functions/sortBy"
 	"github.com/bookingcom/carbonapi/expr/functions/sortByName"
 	"github.com/bookingcom/carbonapi/expr/functions/squareRoot"
@@ -88,6 +96,7 @@ import (
 	"github.com/bookingcom/carbonapi/expr/functions/timeStack"
 	"github.com/bookingcom/carbonapi/expr/functions/transformNull"
 	"github.com/bookingcom/carbonapi/expr/functions/tukey"
+	"github.com/bookingcom/carbonapi/expr/functions/zscore"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/metadata"
 )
@@ -99,7 +108,7 @@ type initFunc struct {
 }
 
 func New(configs map[string]string) {
-	funcs := make([]initFunc, 0, 84)
+	funcs := make([]initFunc, 0, 93)
 
 	funcs = append(funcs, initFunc{name: "absolute", order: absolute.GetOrder(), f: absolute.New})
 
@@ -113,6 +122,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "asPercent", order: asPercent.GetOrder(), f: asPercent.New})
 
+	funcs = append(funcs, initFunc{name: "averageOutsidePercentile", order: averageOutsidePercentile.GetOrder(), f: averageOutsidePercentile.New})
+
 	funcs = append(funcs, initFunc{name: "averageSeries", order: averageSeries.GetOrder(), f: averageSeries.New})
 
 	funcs = append(funcs, initFunc{name: "averageSeriesWithWildcards", order: averageSeriesWithWildcards.GetOrder(), f: averageSeriesWithWildcards.New})
@@ -141,6 +152,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "divideSeries", order: divideSeries.GetOrder(), f: divideSeries.New})
 
+	funcs = append(funcs, initFunc{name: "events", order: events.GetOrder(), f: events.New})
+
 	funcs = append(funcs, initFunc{name: "ewma", order: ewma.GetOrder(), f: ewma.New})
 
 	funcs = append(funcs, initFunc{name: "exclude", order: exclude.GetOrder(), f: exclude.New})
@@ -159,6 +172,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "highest", order: highest.GetOrder(), f: highest.New})
 
+	funcs = append(funcs, initFunc{name: "histogram", order: histogram.GetOrder(), f: histogram.New})
+
 	funcs = append(funcs, initFunc{name: "hitcount", order: hitcount.GetOrder(), f: hitcount.New})
 
 	funcs = append(funcs, initFunc{name: "holtWintersAberration", order: holtWintersAberration.GetOrder(), f: holtWintersAberration.New})
@@ -191,6 +206,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "lowest", order: lowest.GetOrder(), f: lowest.New})
 
+	funcs = append(funcs, initFunc{name: "madBasedOutlierDetection", order: madBasedOutlierDetection.GetOrder(), f: madBasedOutlierDetection.New})
+
 	funcs = append(funcs, initFunc{name: "mapSeries", order: mapSeries.GetOrder(), f: mapSeries.New})
 
 	funcs = append(funcs, initFunc{name: "minMax", order: minMax.GetOrder(), f: minMax.New})
@@ -233,14 +250,22 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "removeBelowSeries", order: removeBelowSeries.GetOrder(), f: removeBelowSeries.New})
 
+	funcs = append(funcs, initFunc{name: "removeBetweenPercentile", order: removeBetweenPercentile.GetOrder(), f: removeBetweenPercentile.New})
+
 	funcs = append(funcs, initFunc{name: "removeEmptySeries", order: removeEmptySeries.GetOrder(), f: removeEmptySeries.New})
 
 	funcs = append(funcs, initFunc{name: "scale", order: scale.GetOrder(), f: scale.New})
 
 	funcs = append(funcs, initFunc{name: "scaleToSeconds", order: scaleToSeconds.GetOrder(), f: scaleToSeconds.New})
 
+	funcs = append(funcs, initFunc{name: "script", order: script.GetOrder(), f: script.New})
+
+	funcs = append(funcs, initFunc{name: "seasonalDecompose", order: seasonalDecompose.GetOrder(), f: seasonalDecompose.New})
+
 	funcs = append(funcs, initFunc{name: "seriesList", order: seriesList.GetOrder(), f: seriesList.New})
 
+	funcs = append(funcs, initFunc{name: "setXFilesFactor", order: setXFilesFactor.GetOrder(), f: setXFilesFactor.New})
+
 	funcs = append(funcs, initFunc{name: "sortBy", order: sortBy.GetOrder(), f: sortBy.New})
 
 	funcs = append(funcs, initFunc{name: "sortByName", order: sortByName.GetOrder(), f: sortByName.New})
@@ -269,6 +294,8 @@ func New(configs map[string]string) {
 
 	funcs = append(funcs, initFunc{name: "tukey", order: tukey.GetOrder(), f: tukey.New})
 
+	funcs = append(funcs, initFunc{name: "zscore", order: zscore.GetOrder(), f: zscore.New})
+
 	sort.Slice(funcs, func(i, j int) bool {
 		if funcs[i].order == interfaces.Any && funcs[j].order == interfaces.Last {
 			return true
@@ -1,6 +1,7 @@
 package groupByNode
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -27,10 +28,10 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-// groupByNode(seriesList, nodeNum, callback)
+// groupByNode(seriesList, nodeNum, callback='average')
 // groupByNodes(seriesList, callback, *nodes)
-func (f *groupByNode) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *groupByNode) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -43,7 +44,7 @@ func (f *groupByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 			return nil, err
 		}
 
-		callback, err = e.GetStringArg(2)
+		callback, err = e.GetStringNamedOrPosArgDefault("callback", 2, "average")
 		if err != nil {
 			return nil, err
 		}
@@ -62,51 +63,46 @@ func (f *groupByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 
 	var results []*types.MetricData
 
-	groups := make(map[string][]*types.MetricData)
-	nodeList := []string{}
-
-	for _, a := range args {
-
-		metric := helper.ExtractMetric(a.Name)
-		nodes := strings.Split(metric, ".")
-		nodeKey := make([]string, 0, len(fields))
-		for _, f := range fields {
-			nodeKey = append(nodeKey, nodes[f])
-		}
-		node := strings.Join(nodeKey, ".")
-		if len(groups[node]) == 0 {
-			nodeList = append(nodeList, node)
-		}
-
-		groups[node] = append(groups[node], a)
-	}
+	groups, nodeList := helper.GroupByNodes(args, fields)
 
 	for _, k := range nodeList {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		k := k // k's reference is used later, so it's important to make it unique per loop
 		v := groups[k]
 
+		// graphite 1.1 takes the name of an aggregation, older versions the
+		// name of a series function
+		if aggregate, ok := helper.GetAggregateFunc(callback); ok {
+			r, err := helper.AggregateSeries(e, helper.Normalize(v), aggregate)
+			if err != nil {
+				return nil, err
+			}
+			r[0].Name = k
+			results = append(results, r...)
+			continue
+		}
+
 		// Ensure that names won't be parsed as consts, appending stub to them
 		expr := fmt.Sprintf("%s(stub_%s)", callback, k)
 
 		// create a stub context to evaluate the callback in
 		nexpr, _, err := parser.ParseExpr(expr)
+		if err != nil {
+			return nil, err
+		}
 		// remove all stub_ prefixes we've prepended before
 		nexpr.SetRawArgs(strings.Replace(nexpr.RawArgs(), "stub_", "", 1))
 		for argIdx := range nexpr.Args() {
-			nexpr.Args()[argIdx].SetTarget(strings.Replace(nexpr.Args()[0].Target(), "stub_", "", 1))
-		}
-		if err != nil {
-			return nil, err
+			nexpr.Args()[argIdx].SetTarget(strings.Replace(nexpr.Args()[argIdx].Target(), "stub_", "", 1))
 		}
 
-		nvalues := values
-		if e.Target() == "groupByNode" || e.Target() == "groupByNodes" {
-			nvalues = map[parser.MetricRequest][]*types.MetricData{
-				parser.MetricRequest{k, from, until}: v,
-			}
+		nvalues := map[parser.MetricRequest][]*types.MetricData{
+			parser.MetricRequest{k, from, until}: v,
 		}
 
-		r, _ := f.Evaluator.EvalExpr(nexpr, from, until, nvalues)
+		r, _ := f.Evaluator.EvalExpr(ctx, nexpr, from, until, nvalues)
 		if r != nil {
 			r[0].Name = k
 			results = append(results, r...)
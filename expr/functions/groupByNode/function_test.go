@@ -113,6 +113,42 @@ func TestGroupByNode(t *testing.T) {
 				"metric1.foo.qux": {types.MakeMetricData("metric1.foo.qux", []float64{13, 15, 17, 19, 21}, 1, now32)},
 			},
 		},
+
+		{
+			parser.NewExpr("groupByNodes",
+				"metric1.foo.*.*",
+				parser.ArgValue("average"),
+				3,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3, 4}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{5, 10}, 2, now32),
+					types.MakeMetricData("metric1.foo.bar1.qux", []float64{6, 7, 8, 9}, 1, now32),
+				},
+			},
+			"groupByNodes_aligned",
+			map[string][]*types.MetricData{
+				"baz": {types.MakeMetricData("baz", []float64{3.25, 6.75}, 2, now32)},
+				"qux": {types.MakeMetricData("qux", []float64{6, 7, 8, 9}, 1, now32)},
+			},
+		},
+		{
+			parser.NewExpr("groupByNode",
+				"metric1.foo.*.*",
+				-2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar1.qux", []float64{3, 4, 5}, 1, now32),
+				},
+			},
+			"groupByNode_default_callback",
+			map[string][]*types.MetricData{
+				"bar1": {types.MakeMetricData("bar1", []float64{2, 3, 4}, 1, now32)},
+			},
+		},
 	}
 
 	for _, tt := range tests {
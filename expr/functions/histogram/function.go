@@ -0,0 +1,186 @@
+package histogram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type histogram struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &histogram{}
+	functions := []string{"heatmapBuckets", "histogramQuantile"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// heatmapBuckets(seriesList), histogramQuantile(seriesList, quantile)
+func (f *histogram) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Target() == "heatmapBuckets" {
+		return heatmapBuckets(args), nil
+	}
+
+	q, err := e.GetFloatArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if q < 0 || q > 1 {
+		return nil, errors.New("quantile must be between 0 and 1")
+	}
+
+	var results []*types.MetricData
+	for _, h := range types.GroupHistograms(args) {
+		first := h.Buckets[0]
+		r := *first
+		r.Name = fmt.Sprintf("histogramQuantile(%s,%g)", h.Name, q)
+		r.Values = make([]float64, h.Len())
+		r.IsAbsent = make([]bool, h.Len())
+		r.StopTime = r.StartTime + int32(len(r.Values))*r.StepTime
+
+		for i := range r.Values {
+			r.Values[i] = quantile(h, i, q)
+			r.IsAbsent[i] = math.IsNaN(r.Values[i])
+			if r.IsAbsent[i] {
+				r.Values[i] = 0
+			}
+		}
+
+		results = append(results, &r)
+	}
+
+	return results, nil
+}
+
+// heatmapBuckets turns the cumulative bucket series into the count of each
+// bucket alone, named by its bound, the way Grafana heatmaps expect them.
+func heatmapBuckets(args []*types.MetricData) []*types.MetricData {
+	var results []*types.MetricData
+	for _, h := range types.GroupHistograms(args) {
+		for j, b := range h.Buckets {
+			r := *b
+			r.Name = types.FormatBound(h.Bounds[j])
+			r.Values = make([]float64, h.Len())
+			r.IsAbsent = make([]bool, h.Len())
+			r.StopTime = r.StartTime + int32(len(r.Values))*r.StepTime
+
+			for i := range r.Values {
+				v, ok := h.Count(j, i)
+				r.Values[i] = v
+				r.IsAbsent[i] = !ok
+			}
+
+			results = append(results, &r)
+		}
+	}
+
+	return results
+}
+
+// quantile estimates the q quantile of point i of the histogram by linear
+// interpolation within the bucket it falls in, as Prometheus'
+// histogram_quantile does. The histogram needs a +Inf bucket.
+func quantile(h *types.Histogram, i int, q float64) float64 {
+	n := len(h.Buckets)
+	if n < 2 || !math.IsInf(h.Bounds[n-1], 1) {
+		return math.NaN()
+	}
+
+	counts := make([]float64, n)
+	for j, b := range h.Buckets {
+		if b.IsAbsent[i] {
+			return math.NaN()
+		}
+		counts[j] = b.Values[i]
+	}
+
+	total := counts[n-1]
+	if total == 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	b := 0
+	for b < n-1 && counts[b] < rank {
+		b++
+	}
+
+	switch {
+	case b == n-1:
+		return h.Bounds[n-2]
+	case b == 0 && h.Bounds[0] <= 0:
+		return h.Bounds[0]
+	}
+
+	start, end := 0.0, h.Bounds[b]
+	count := counts[b]
+	if b > 0 {
+		start = h.Bounds[b-1]
+		count -= counts[b-1]
+		rank -= counts[b-1]
+	}
+	if count == 0 {
+		return end
+	}
+
+	return start + (end-start)*(rank/count)
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *histogram) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"heatmapBuckets": {
+			Description: "Takes the cumulative bucket series of Prometheus-style histograms, named with an le tag\n(latency;le=0.5) or an le last node (latency.le_0_5), and returns the count of each bucket\nalone, named by its upper bound and sorted by it, as Grafana heatmaps expect.\n\nExample:\n\n.. code-block:: none\n\n  &target=heatmapBuckets(app.request_duration.le_*)",
+			Function:    "heatmapBuckets(seriesList)",
+			Group:       "Transform",
+			Module:      "graphite.render.functions.custom",
+			Name:        "heatmapBuckets",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+			},
+		},
+		"histogramQuantile": {
+			Description: "Takes the cumulative bucket series of Prometheus-style histograms, named with an le tag\n(latency;le=0.5) or an le last node (latency.le_0_5), and estimates the given quantile of\neach histogram by interpolating within the bucket it falls in. Histograms need a +Inf bucket.\n\nExample:\n\n.. code-block:: none\n\n  &target=histogramQuantile(app.request_duration.le_*, 0.99)",
+			Function:    "histogramQuantile(seriesList, quantile)",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions.custom",
+			Name:        "histogramQuantile",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "quantile",
+					Required: true,
+					Type:     types.Float,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package histogram
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func buckets() map[parser.MetricRequest][]*types.MetricData {
+	return map[parser.MetricRequest][]*types.MetricData{
+		{"latency.le_*", 0, 1}: {
+			types.MakeMetricData("latency.le_inf", []float64{4, 4, math.NaN()}, 60, 0),
+			types.MakeMetricData("latency.le_0_5", []float64{1, 2, 1}, 60, 0),
+			types.MakeMetricData("latency.le_2", []float64{3, 4, 2}, 60, 0),
+			types.MakeMetricData("other", []float64{9, 9, 9}, 60, 0),
+		},
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("heatmapBuckets", "latency.le_*"),
+			buckets(),
+			[]*types.MetricData{
+				types.MakeMetricData("0.5", []float64{1, 2, 1}, 60, 0),
+				types.MakeMetricData("2", []float64{2, 2, 1}, 60, 0),
+				types.MakeMetricData("+Inf", []float64{1, 0, math.NaN()}, 60, 0),
+			},
+		},
+		{
+			parser.NewExpr("histogramQuantile", "latency.le_*", 0.5),
+			buckets(),
+			[]*types.MetricData{
+				types.MakeMetricData("histogramQuantile(latency,0.5)", []float64{1.25, 0.5, math.NaN()}, 60, 0),
+			},
+		},
+		{
+			parser.NewExpr("histogramQuantile", "latency.le_*", 1),
+			buckets(),
+			[]*types.MetricData{
+				types.MakeMetricData("histogramQuantile(latency,1)", []float64{2, 2, math.NaN()}, 60, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestHistogramQuantileRange(t *testing.T) {
+	_, err := metadata.GetEvaluator().EvalExpr(context.Background(), parser.NewExpr("histogramQuantile", "latency.le_*", 1.5), 0, 1, buckets())
+	if err == nil {
+		t.Error("expected an error for a quantile above 1")
+	}
+}
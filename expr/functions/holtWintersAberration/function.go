@@ -1,6 +1,7 @@
 package holtWintersAberration
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/holtwinters"
@@ -29,26 +30,39 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *holtWintersAberration) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *holtWintersAberration) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArg(e.Args()[0], from-7*86400, until, values)
+	delta, err := e.GetFloatNamedOrPosArgDefault("delta", 1, 3)
 	if err != nil {
 		return nil, err
 	}
 
-	delta, err := e.GetFloatNamedOrPosArgDefault("delta", 1, 3)
+	bootstrapInterval, err := e.GetIntervalNamedOrPosArgDefault("bootstrapInterval", 2, 1, 7*86400)
+	if err != nil {
+		return nil, err
+	}
+
+	seasonality, err := e.GetIntervalNamedOrPosArgDefault("seasonality", 3, 1, 86400)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from-bootstrapInterval, until, values)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, arg := range args {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		var aberration []float64
 
 		stepTime := arg.StepTime
 
-		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta)
+		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta, bootstrapInterval, seasonality)
 
-		windowPoints := 7 * 86400 / stepTime
+		windowPoints := holtwinters.WindowPoints(len(arg.Values), stepTime, bootstrapInterval)
 		series := arg.Values[windowPoints:]
 		absent := arg.IsAbsent[windowPoints:]
 
@@ -69,7 +83,7 @@ func (f *holtWintersAberration) Do(e parser.Expr, from, until int32, values map[
 			Values:    aberration,
 			IsAbsent:  make([]bool, len(aberration)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + int32(windowPoints)*stepTime,
 			StopTime:  arg.StopTime,
 		}}
 
@@ -83,7 +97,7 @@ func (f *holtWintersAberration) Description() map[string]types.FunctionDescripti
 	return map[string]types.FunctionDescription{
 		"holtWintersAberration": {
 			Description: "Performs a Holt-Winters forecast using the series as input data and plots the\npositive or negative deviation of the series data from the forecast.",
-			Function:    "holtWintersAberration(seriesList, delta=3, bootstrapInterval='7d')",
+			Function:    "holtWintersAberration(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
 			Name:        "holtWintersAberration",
@@ -107,6 +121,15 @@ func (f *holtWintersAberration) Description() map[string]types.FunctionDescripti
 					),
 					Type: types.Interval,
 				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
 			},
 		},
 	}
@@ -0,0 +1,47 @@
+package holtWintersAberration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestHoltWintersAberration(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("holtWintersAberration", "metric1", 3, parser.NamedArgs{"bootstrapInterval": parser.ArgValue("3min"), "seasonality": parser.ArgValue("2min")}),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", -180, 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6, 7, 8}, 60, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("holtWintersAberration(metric1)", []float64{1.5630690857499996, 1.7788267387449874, 1.7020657193644153, 1.6402872078680737, 1.3644188097949126}, 60, now32+180),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package holtWintersConfidenceBands
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/holtwinters"
@@ -29,29 +30,43 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *holtWintersConfidenceBands) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *holtWintersConfidenceBands) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArg(e.Args()[0], from-7*86400, until, values)
+	delta, err := e.GetFloatNamedOrPosArgDefault("delta", 1, 3)
 	if err != nil {
 		return nil, err
 	}
 
-	delta, err := e.GetFloatNamedOrPosArgDefault("delta", 1, 3)
+	bootstrapInterval, err := e.GetIntervalNamedOrPosArgDefault("bootstrapInterval", 2, 1, 7*86400)
+	if err != nil {
+		return nil, err
+	}
+
+	seasonality, err := e.GetIntervalNamedOrPosArgDefault("seasonality", 3, 1, 86400)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from-bootstrapInterval, until, values)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, arg := range args {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		stepTime := arg.StepTime
+		windowPoints := holtwinters.WindowPoints(len(arg.Values), stepTime, bootstrapInterval)
 
-		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta)
+		lowerBand, upperBand := holtwinters.HoltWintersConfidenceBands(arg.Values, stepTime, delta, bootstrapInterval, seasonality)
 
 		lowerSeries := types.MetricData{FetchResponse: pb.FetchResponse{
 			Name:      fmt.Sprintf("holtWintersConfidenceLower(%s)", arg.Name),
 			Values:    lowerBand,
 			IsAbsent:  make([]bool, len(lowerBand)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + int32(windowPoints)*stepTime,
 			StopTime:  arg.StopTime,
 		}}
 
@@ -67,7 +82,7 @@ func (f *holtWintersConfidenceBands) Do(e parser.Expr, from, until int32, values
 			Values:    upperBand,
 			IsAbsent:  make([]bool, len(upperBand)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
+			StartTime: arg.StartTime + int32(windowPoints)*stepTime,
 			StopTime:  arg.StopTime,
 		}}
 
@@ -90,7 +105,7 @@ func (f *holtWintersConfidenceBands) Description() map[string]types.FunctionDesc
 	return map[string]types.FunctionDescription{
 		"holtWintersConfidenceBands": {
 			Description: "Performs a Holt-Winters forecast using the series as input data and plots\nupper and lower bands with the predicted forecast deviations.",
-			Function:    "holtWintersConfidenceBands(seriesList, delta=3, bootstrapInterval='7d')",
+			Function:    "holtWintersConfidenceBands(seriesList, delta=3, bootstrapInterval='7d', seasonality='1d')",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
 			Name:        "holtWintersConfidenceBands",
@@ -114,6 +129,15 @@ func (f *holtWintersConfidenceBands) Description() map[string]types.FunctionDesc
 					),
 					Type: types.Interval,
 				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
 			},
 		},
 	}
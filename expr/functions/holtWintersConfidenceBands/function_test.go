@@ -0,0 +1,48 @@
+package holtWintersConfidenceBands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestHoltWintersConfidenceBands(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("holtWintersConfidenceBands", "metric1", 3, parser.ArgValue("3min"), parser.ArgValue("2min")),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", -180, 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6, 7, 8}, 60, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("holtWintersConfidenceLower(metric1)", []float64{0.3257288407500003, 0.23102034233073776, 0.12461814096608581, 0.1092700039137946, 0.10038717366294803}, 60, now32+180),
+				types.MakeMetricData("holtWintersConfidenceUpper(metric1)", []float64{2.4369309142500004, 3.2211732612550126, 4.297934280635585, 5.359712792131926, 6.635581190205087}, 60, now32+180),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package holtWintersForecast
 
 import (
+	"context"
 	"fmt"
+	"math"
+
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/holtwinters"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -28,19 +31,34 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *holtWintersForecast) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *holtWintersForecast) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArgsAndRemoveNonExisting(e, from-7*86400, until, values)
+	bootstrapInterval, err := e.GetIntervalNamedOrPosArgDefault("bootstrapInterval", 1, 1, 7*86400)
+	if err != nil {
+		return nil, err
+	}
+
+	seasonality, err := e.GetIntervalNamedOrPosArgDefault("seasonality", 2, 1, 86400)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from-bootstrapInterval, until, values)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, arg := range args {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		stepTime := arg.StepTime
 
-		predictions, _ := holtwinters.HoltWintersAnalysis(arg.Values, stepTime)
+		// forecasting past the data, to the until of a request into the future
+		future := helper.FuturePoints(arg, until)
+		predictions := holtwinters.HoltWintersForecast(arg.Values, stepTime, seasonality, future)
 
-		windowPoints := 7 * 86400 / stepTime
+		windowPoints := holtwinters.WindowPoints(len(arg.Values), stepTime, bootstrapInterval)
 		predictionsOfInterest := predictions[windowPoints:]
 
 		r := types.MetricData{FetchResponse: pb.FetchResponse{
@@ -48,9 +66,15 @@ func (f *holtWintersForecast) Do(e parser.Expr, from, until int32, values map[pa
 			Values:    predictionsOfInterest,
 			IsAbsent:  make([]bool, len(predictionsOfInterest)),
 			StepTime:  arg.StepTime,
-			StartTime: arg.StartTime + 7*86400,
-			StopTime:  arg.StopTime,
+			StartTime: arg.StartTime + int32(windowPoints)*stepTime,
+			StopTime:  arg.StopTime + int32(future)*stepTime,
 		}}
+		for i, v := range predictionsOfInterest[len(predictionsOfInterest)-future:] {
+			if math.IsNaN(v) {
+				r.Values[len(r.Values)-future+i] = 0
+				r.IsAbsent[len(r.Values)-future+i] = true
+			}
+		}
 
 		results = append(results, &r)
 	}
@@ -62,8 +86,8 @@ func (f *holtWintersForecast) Do(e parser.Expr, from, until int32, values map[pa
 func (f *holtWintersForecast) Description() map[string]types.FunctionDescription {
 	return map[string]types.FunctionDescription{
 		"holtWintersForecast": {
-			Description: "Performs a Holt-Winters forecast using the series as input data. Data from\n`bootstrapInterval` (one week by default) previous to the series is used to bootstrap the initial forecast.",
-			Function:    "holtWintersForecast(seriesList, bootstrapInterval='7d')",
+			Description: "Performs a Holt-Winters forecast using the series as input data. Data from\n`bootstrapInterval` (one week by default) previous to the series is used to bootstrap the initial forecast.\nThe `seasonality` is the length of a season, one day by default. With an until in the future,\nthe forecast goes on past the data up to it.",
+			Function:    "holtWintersForecast(seriesList, bootstrapInterval='7d', seasonality='1d')",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
 			Name:        "holtWintersForecast",
@@ -82,6 +106,15 @@ func (f *holtWintersForecast) Description() map[string]types.FunctionDescription
 					),
 					Type: types.Interval,
 				},
+				{
+					Default: types.NewSuggestion("1d"),
+					Name:    "seasonality",
+					Suggestions: types.NewSuggestions(
+						"1d",
+						"7d",
+					),
+					Type: types.Interval,
+				},
 			},
 		},
 	}
@@ -0,0 +1,55 @@
+package holtWintersForecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestHoltWintersForecast(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	input := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", -180, 1}: {
+			types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6, 7, 8}, 60, now32),
+		},
+	}
+	want := []*types.MetricData{
+		types.MakeMetricData("holtWintersForecast(metric1)", []float64{1.3813298775000002, 1.7260968017928753, 2.2112762108008353, 2.7344913980228602, 3.3679841819340175}, 60, now32+180),
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("holtWintersForecast", "metric1", parser.ArgValue("3min"), parser.ArgValue("2min")),
+			input,
+			want,
+		},
+		{
+			parser.NewExpr("holtWintersForecast", "metric1", parser.NamedArgs{"bootstrapInterval": parser.ArgValue("3min"), "seasonality": parser.ArgValue("2min")}),
+			input,
+			want,
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package keepLastValue
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -28,8 +29,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // keepLastValue(seriesList, limit=inf)
-func (f *keepLastValue) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *keepLastValue) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +63,7 @@ func (f *keepLastValue) Do(e parser.Expr, from, until int32, values map[parser.M
 		missing := 0
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if types.IsNull(v, a.IsAbsent[i]) {
 
 				if (keep < 0 || missing < keep) && !math.IsNaN(prev) {
 					r.Values[i] = prev
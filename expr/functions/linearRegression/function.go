@@ -1,6 +1,7 @@
 package linearRegression
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/bookingcom/carbonapi/expr/helper"
@@ -30,8 +31,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // linearRegression(seriesList, startSourceAt=None, endSourceAt=None)
-func (f *linearRegression) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *linearRegression) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -45,14 +46,17 @@ func (f *linearRegression) Do(e parser.Expr, from, until int32, values map[parse
 		if len(e.Args()) > 2 {
 			r.Name = fmt.Sprintf("linearRegression(%s,'%s','%s')", a.GetName(), e.Args()[1].StringValue(), e.Args()[2].StringValue())
 		} else if len(e.Args()) > 1 {
-			r.Name = fmt.Sprintf("linearRegression(%s,'%s')", a.GetName(), e.Args()[2].StringValue())
+			r.Name = fmt.Sprintf("linearRegression(%s,'%s')", a.GetName(), e.Args()[1].StringValue())
 		} else {
 			r.Name = fmt.Sprintf("linearRegression(%s)", a.GetName())
 		}
 
-		r.Values = make([]float64, len(a.Values))
+		// Extending the line past the data, to the until of a request
+		// into the future
+		future := helper.FuturePoints(a, until)
+		r.Values = make([]float64, len(a.Values)+future)
 		r.IsAbsent = make([]bool, len(r.Values))
-		r.StopTime = a.GetStopTime()
+		r.StopTime = a.GetStopTime() + int32(future)*a.StepTime
 
 		// Removing absent values from original dataset
 		nonNulls := make([]float64, 0)
@@ -96,7 +100,7 @@ func (f *linearRegression) Do(e parser.Expr, from, until int32, values map[parse
 func (f *linearRegression) Description() map[string]types.FunctionDescription {
 	return map[string]types.FunctionDescription{
 		"linearRegression": {
-			Description: "Graphs the liner regression function by least squares method.\n\nTakes one metric or a wildcard seriesList, followed by a quoted string with the\ntime to start the line and another quoted string with the time to end the line.\nThe start and end times are inclusive (default range is from to until). See\n``from / until`` in the render\\_api_ for examples of time formats. Datapoints\nin the range is used to regression.\n\nExample:\n\n.. code-block:: none\n\n  &target=linearRegression(Server.instance01.threads.busy, '-1d')\n  &target=linearRegression(Server.instance*.threads.busy, \"00:00 20140101\",\"11:59 20140630\")",
+			Description: "Graphs the liner regression function by least squares method.\n\nTakes one metric or a wildcard seriesList, followed by a quoted string with the\ntime to start the line and another quoted string with the time to end the line.\nThe start and end times are inclusive (default range is from to until). See\n``from / until`` in the render\\_api_ for examples of time formats. Datapoints\nin the range is used to regression. With an until in the future, the line is\nprojected past the last datapoint up to it.\n\nExample:\n\n.. code-block:: none\n\n  &target=linearRegression(Server.instance01.threads.busy, '-1d')\n  &target=linearRegression(Server.instance*.threads.busy, \"00:00 20140101\",\"11:59 20140630\")",
 			Function:    "linearRegression(seriesList, startSourceAt=None, endSourceAt=None)",
 			Group:       "Calculate",
 			Module:      "graphite.render.functions",
@@ -0,0 +1,123 @@
+package madBasedOutlierDetection
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type madBasedOutlierDetection struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &madBasedOutlierDetection{}
+	functions := []string{"madBasedOutlierDetection"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// madBasedOutlierDetection(seriesList, threshold=3.5)
+func (f *madBasedOutlierDetection) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := e.GetFloatNamedOrPosArgDefault("threshold", 1, 3.5)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.MetricData
+	for _, a := range arg {
+		r := *a
+		r.Name = fmt.Sprintf("madBasedOutlierDetection(%s,%g)", a.Name, threshold)
+		r.Values = make([]float64, len(a.Values))
+		r.IsAbsent = make([]bool, len(a.Values))
+
+		present := make([]float64, 0, len(a.Values))
+		for i, v := range a.Values {
+			if !a.IsAbsent[i] {
+				present = append(present, v)
+			}
+		}
+		med := median(present)
+		deviations := make([]float64, len(present))
+		for i, v := range present {
+			deviations[i] = math.Abs(v - med)
+		}
+		mad := median(deviations)
+
+		for i, v := range a.Values {
+			r.IsAbsent[i] = true
+			if a.IsAbsent[i] || v == med {
+				continue
+			}
+			// Without deviation from the median, every point off it is an
+			// outlier.
+			if mad == 0 || math.Abs(0.6745*(v-med)/mad) > threshold {
+				r.Values[i] = v
+				r.IsAbsent[i] = false
+			}
+		}
+		results = append(results, &r)
+	}
+	return results, nil
+}
+
+// median returns the median of values, the mean of the middle two for an
+// even number of them. It sorts values.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *madBasedOutlierDetection) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"madBasedOutlierDetection": {
+			Description: "Takes one metric or a wildcard seriesList and keeps only the datapoints\nthat are outliers of their series, graphing the others as null.\n\nA datapoint is an outlier if the modified z-score 0.6745 * (x - median) / MAD,\nwhere MAD is the median absolute deviation of the non-null datapoints, is\nabove threshold in absolute value. When the MAD is 0, every datapoint off\nthe median is an outlier.\n\nExample:\n\n.. code-block:: none\n\n  &target=madBasedOutlierDetection(Server.instance01.threads.busy)\n  &target=madBasedOutlierDetection(Server.instance*.threads.busy, 5)",
+			Function:    "madBasedOutlierDetection(seriesList, threshold=3.5)",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions.custom",
+			Name:        "madBasedOutlierDetection",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:    "threshold",
+					Default: types.NewSuggestion(3.5),
+					Suggestions: types.NewSuggestions(
+						3,
+						3.5,
+						5,
+					),
+					Type: types.Float,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package madBasedOutlierDetection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+// fixture is a case of testdata/fixtures.json, generated by
+// internal/anomalyFixtures/gen.py.
+type fixture struct {
+	Name      string     `json:"name"`
+	Threshold float64    `json:"threshold"`
+	Input     []*float64 `json:"input"`
+	Output    []*float64 `json:"output"`
+}
+
+func nulls(values []*float64) []float64 {
+	res := make([]float64, len(values))
+	for i, v := range values {
+		res[i] = math.NaN()
+		if v != nil {
+			res[i] = *v
+		}
+	}
+	return res
+}
+
+func TestMadBasedOutlierDetection(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []fixture
+	if err := json.Unmarshal(b, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range fixtures {
+		tt := th.EvalTestItem{
+			E: parser.NewExpr("madBasedOutlierDetection", "metric1", f.Threshold),
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", nulls(f.Input), 1, 0)},
+			},
+			Want: []*types.MetricData{types.MakeMetricData(fmt.Sprintf("madBasedOutlierDetection(metric1,%g)", f.Threshold), nulls(f.Output), 1, 0)},
+		}
+		t.Run(f.Name, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
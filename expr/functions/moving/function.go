@@ -1,6 +1,7 @@
 package moving
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -29,7 +30,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // movingXyz(seriesList, windowSize)
-func (f *moving) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *moving) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var n int
 	var err error
 
@@ -37,6 +38,10 @@ func (f *moving) Do(e parser.Expr, from, until int32, values map[parser.MetricRe
 
 	var argstr string
 
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
 	switch e.Args()[1].Type() {
 	case parser.EtConst:
 		n, err = e.GetIntArg(1)
@@ -61,7 +66,7 @@ func (f *moving) Do(e parser.Expr, from, until int32, values map[parser.MetricRe
 		start -= int32(n)
 	}
 
-	arg, err := helper.GetSeriesArg(e.Args()[0], start, until, values)
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], start, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +81,9 @@ func (f *moving) Do(e parser.Expr, from, until int32, values map[parser.MetricRe
 	var result []*types.MetricData
 
 	for _, a := range arg {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		w := &types.Windowed{Data: make([]float64, windowSize)}
 
 		r := *a
@@ -1,6 +1,7 @@
 package movingMedian
 
 import (
+	"context"
 	"fmt"
 	"github.com/JaderDias/movingmedian"
 	"github.com/bookingcom/carbonapi/expr/helper"
@@ -30,7 +31,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // movingMedian(seriesList, windowSize)
-func (f *movingMedian) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *movingMedian) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var n int
 	var err error
 
@@ -62,7 +63,7 @@ func (f *movingMedian) Do(e parser.Expr, from, until int32, values map[parser.Me
 		start -= int32(n)
 	}
 
-	arg, err := helper.GetSeriesArg(e.Args()[0], start, until, values)
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], start, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +78,9 @@ func (f *movingMedian) Do(e parser.Expr, from, until int32, values map[parser.Me
 	var result []*types.MetricData
 
 	for _, a := range arg {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		r := *a
 		r.Name = fmt.Sprintf("movingMedian(%s,%s)", a.Name, argstr)
 		r.Values = make([]float64, len(a.Values)-offset)
@@ -1,6 +1,7 @@
 package multiplySeries
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -29,7 +30,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // multiplySeries(factorsSeriesList)
-func (f *multiplySeries) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *multiplySeries) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	r := types.MetricData{
 		FetchResponse: pb.FetchResponse{
 			Name:      fmt.Sprintf("multiplySeries(%s)", e.RawArgs()),
@@ -38,7 +39,7 @@ func (f *multiplySeries) Do(e parser.Expr, from, until int32, values map[parser.
 		},
 	}
 	for _, arg := range e.Args() {
-		series, err := helper.GetSeriesArg(arg, from, until, values)
+		series, err := helper.GetSeriesArg(ctx, arg, from, until, values)
 		if err != nil {
 			return nil, err
 		}
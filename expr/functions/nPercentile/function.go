@@ -1,6 +1,8 @@
 package nPercentile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -27,8 +29,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // nPercentile(seriesList, n)
-func (f *nPercentile) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *nPercentile) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -36,14 +38,12 @@ func (f *nPercentile) Do(e parser.Expr, from, until int32, values map[parser.Met
 	if err != nil {
 		return nil, err
 	}
+	if percent <= 0 {
+		return nil, errors.New("the requested percent must be greater than 0")
+	}
 
 	var results []*types.MetricData
 	for _, a := range arg {
-		r := *a
-		r.Name = fmt.Sprintf("nPercentile(%s,%g)", a.Name, percent)
-		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
-
 		var values []float64
 		for i, v := range a.IsAbsent {
 			if !v {
@@ -51,7 +51,17 @@ func (f *nPercentile) Do(e parser.Expr, from, until int32, values map[parser.Met
 			}
 		}
 
-		value := helper.Percentile(values, percent, true)
+		// graphite-web drops series without any values
+		if len(values) == 0 {
+			continue
+		}
+
+		r := *a
+		r.Name = fmt.Sprintf("nPercentile(%s,%g)", a.Name, percent)
+		r.Values = make([]float64, len(a.Values))
+		r.IsAbsent = make([]bool, len(a.Values))
+
+		value := helper.GraphitePercentile(values, percent, false)
 		for i := range r.Values {
 			r.Values[i] = value
 		}
@@ -0,0 +1,61 @@
+package nPercentile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+// Expected values are graphite-web's.
+func TestNPercentile(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	input := map[parser.MetricRequest][]*types.MetricData{
+		{"metric.*", 0, 1}: {
+			types.MakeMetricData("metric.a", []float64{1, 3, nan, 7, 15, 20, 100}, 1, now32),
+			types.MakeMetricData("metric.empty", []float64{nan, nan, nan}, 1, now32),
+		},
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("nPercentile", "metric.*", 50),
+			input,
+			[]*types.MetricData{types.MakeMetricData("nPercentile(metric.a,50)", []float64{15, 15, 15, 15, 15, 15, 15}, 1, now32)},
+		},
+		{
+			parser.NewExpr("nPercentile", "metric.*", 95),
+			input,
+			[]*types.MetricData{types.MakeMetricData("nPercentile(metric.a,95)", []float64{100, 100, 100, 100, 100, 100, 100}, 1, now32)},
+		},
+		{
+			parser.NewExpr("nPercentile", "metric.*", 5),
+			input,
+			[]*types.MetricData{types.MakeMetricData("nPercentile(metric.a,5)", []float64{1, 1, 1, 1, 1, 1, 1}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package percentileOfSeries
 
 import (
+	"context"
+	"errors"
+
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/types"
@@ -26,9 +29,9 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // percentileOfSeries(seriesList, n, interpolate=False)
-func (f *percentileOfSeries) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *percentileOfSeries) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	// TODO(dgryski): make sure the arrays are all the same 'size'
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -37,6 +40,9 @@ func (f *percentileOfSeries) Do(e parser.Expr, from, until int32, values map[par
 	if err != nil {
 		return nil, err
 	}
+	if percent <= 0 {
+		return nil, errors.New("the requested percent must be greater than 0")
+	}
 
 	interpolate, err := e.GetBoolNamedOrPosArgDefault("interpolate", 2, false)
 	if err != nil {
@@ -44,7 +50,7 @@ func (f *percentileOfSeries) Do(e parser.Expr, from, until int32, values map[par
 	}
 
 	return helper.AggregateSeries(e, args, func(values []float64) float64 {
-		return helper.Percentile(values, percent, interpolate)
+		return helper.GraphitePercentile(values, percent, interpolate)
 	})
 }
 
@@ -0,0 +1,68 @@
+package percentileOfSeries
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+// Expected values are graphite-web's.
+func TestPercentileOfSeries(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	input := map[parser.MetricRequest][]*types.MetricData{
+		{"metric.*", 0, 1}: {
+			types.MakeMetricData("metric.a", []float64{1, 2, 3, nan, 5}, 1, now32),
+			types.MakeMetricData("metric.b", []float64{5, 4, nan, nan, 1}, 1, now32),
+			types.MakeMetricData("metric.c", []float64{3, 8, 9, nan, 2}, 1, now32),
+			types.MakeMetricData("metric.d", []float64{10, nan, nan, nan, 7}, 1, now32),
+		},
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("percentileOfSeries", "metric.*", 50),
+			input,
+			[]*types.MetricData{types.MakeMetricData("percentileOfSeries(metric.*,50)", []float64{5, 4, 9, nan, 5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("percentileOfSeries", "metric.*", 90),
+			input,
+			[]*types.MetricData{types.MakeMetricData("percentileOfSeries(metric.*,90)", []float64{10, 8, 9, nan, 7}, 1, now32)},
+		},
+		{
+			parser.NewExpr("percentileOfSeries", "metric.*", 50, parser.NamedArgs{"interpolate": "true"}),
+			input,
+			[]*types.MetricData{types.MakeMetricData("percentileOfSeries(metric.*,50,interpolate=true)", []float64{4, 4, 6, nan, 3.5}, 1, now32)},
+		},
+		{
+			parser.NewExpr("percentileOfSeries", "metric.*", 25, parser.NamedArgs{"interpolate": "true"}),
+			input,
+			[]*types.MetricData{types.MakeMetricData("percentileOfSeries(metric.*,25,interpolate=true)", []float64{1.5, 2, 3, nan, 1.25}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
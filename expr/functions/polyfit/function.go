@@ -1,6 +1,7 @@
 package polyfit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -31,10 +32,10 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // polyfit(seriesList, degree=1, offset="0d")
-func (f *polyfit) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *polyfit) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	// Fitting Nth degree polynom to the dataset
 	// https://en.wikipedia.org/wiki/Polynomial_regression#Matrix_form_and_calculation_of_estimates
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +59,9 @@ func (f *polyfit) Do(e parser.Expr, from, until int32, values map[parser.MetricR
 	var results []*types.MetricData
 
 	for _, a := range arg {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		r := *a
 		if len(e.Args()) > 2 {
 			r.Name = fmt.Sprintf("polyfit(%s,%d,'%s')", a.Name, degree, e.Args()[2].StringValue())
@@ -1,11 +1,14 @@
 package randomWalk
 
 import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/types"
 	"github.com/bookingcom/carbonapi/pkg/parser"
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
-	"math/rand"
 )
 
 type randomWalk struct {
@@ -27,12 +30,24 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // squareRoot(seriesList)
-func (f *randomWalk) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *randomWalk) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	name, err := e.GetStringArg(0)
 	if err != nil {
 		name = "randomWalk"
 	}
 
+	// Walks given a seed are the same each time, but differ by name.
+	random := rand.Float64
+	if _, ok := e.NamedArgs()["seed"]; ok || len(e.Args()) > 2 {
+		seed, err := e.GetIntNamedOrPosArgDefault("seed", 2, 0)
+		if err != nil {
+			return nil, err
+		}
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		random = rand.New(rand.NewSource(int64(seed) ^ int64(h.Sum64()))).Float64
+	}
+
 	size := until - from
 
 	r := types.MetricData{FetchResponse: pb.FetchResponse{
@@ -45,7 +60,7 @@ func (f *randomWalk) Do(e parser.Expr, from, until int32, values map[parser.Metr
 	}}
 
 	for i := 1; i < len(r.Values)-1; i++ {
-		r.Values[i+1] = r.Values[i] + (rand.Float64() - 0.5)
+		r.Values[i+1] = r.Values[i] + (random() - 0.5)
 	}
 	return []*types.MetricData{&r}, nil
 }
@@ -54,8 +69,8 @@ func (f *randomWalk) Do(e parser.Expr, from, until int32, values map[parser.Metr
 func (f *randomWalk) Description() map[string]types.FunctionDescription {
 	return map[string]types.FunctionDescription{
 		"randomWalk": {
-			Description: "Short Alias: randomWalk()\n\nReturns a random walk starting at 0. This is great for testing when there is\nno real data in whisper.\n\nExample:\n\n.. code-block:: none\n\n  &target=randomWalk(\"The.time.series\")\n\nThis would create a series named \"The.time.series\" that contains points where\nx(t) == x(t-1)+random()-0.5, and x(0) == 0.\nAccepts optional second argument as 'step' parameter (default step is 60 sec)",
-			Function:    "randomWalk(name, step=60)",
+			Description: "Short Alias: randomWalk()\n\nReturns a random walk starting at 0. This is great for testing when there is\nno real data in whisper.\n\nExample:\n\n.. code-block:: none\n\n  &target=randomWalk(\"The.time.series\")\n\nThis would create a series named \"The.time.series\" that contains points where\nx(t) == x(t-1)+random()-0.5, and x(0) == 0.\nAccepts optional second argument as 'step' parameter (default step is 60 sec)\n\nThe walk is the same each time it is drawn with the same 'seed'.",
+			Function:    "randomWalk(name, step=60, seed=None)",
 			Group:       "Special",
 			Module:      "graphite.render.functions",
 			Name:        "randomWalk",
@@ -70,11 +85,15 @@ func (f *randomWalk) Description() map[string]types.FunctionDescription {
 					Name:    "step",
 					Type:    types.Integer,
 				},
+				{
+					Name: "seed",
+					Type: types.Integer,
+				},
 			},
 		},
 		"randomWalkFunction": {
-			Description: "Short Alias: randomWalk()\n\nReturns a random walk starting at 0. This is great for testing when there is\nno real data in whisper.\n\nExample:\n\n.. code-block:: none\n\n  &target=randomWalk(\"The.time.series\")\n\nThis would create a series named \"The.time.series\" that contains points where\nx(t) == x(t-1)+random()-0.5, and x(0) == 0.\nAccepts optional second argument as 'step' parameter (default step is 60 sec)",
-			Function:    "randomWalkFunction(name, step=60)",
+			Description: "Short Alias: randomWalk()\n\nReturns a random walk starting at 0. This is great for testing when there is\nno real data in whisper.\n\nExample:\n\n.. code-block:: none\n\n  &target=randomWalk(\"The.time.series\")\n\nThis would create a series named \"The.time.series\" that contains points where\nx(t) == x(t-1)+random()-0.5, and x(0) == 0.\nAccepts optional second argument as 'step' parameter (default step is 60 sec)\n\nThe walk is the same each time it is drawn with the same 'seed'.",
+			Function:    "randomWalkFunction(name, step=60, seed=None)",
 			Group:       "Special",
 			Module:      "graphite.render.functions",
 			Name:        "randomWalkFunction",
@@ -89,6 +108,10 @@ func (f *randomWalk) Description() map[string]types.FunctionDescription {
 					Name:    "step",
 					Type:    types.Integer,
 				},
+				{
+					Name: "seed",
+					Type: types.Integer,
+				},
 			},
 		},
 	}
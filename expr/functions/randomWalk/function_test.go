@@ -0,0 +1,38 @@
+package randomWalk
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+func TestRandomWalkSeed(t *testing.T) {
+	f := &randomWalk{}
+	walk := func(target string) []float64 {
+		e, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := f.Do(context.Background(), e, 0, 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res[0].Values
+	}
+
+	a := walk("randomWalk('a', seed=42)")
+	if !reflect.DeepEqual(a, walk("randomWalk('a', seed=42)")) {
+		t.Error("Expected the same walk for the same seed")
+	}
+	if reflect.DeepEqual(a, walk("randomWalk('a', seed=43)")) {
+		t.Error("Expected another walk for another seed")
+	}
+	if reflect.DeepEqual(a, walk("randomWalk('b', seed=42)")) {
+		t.Error("Expected another walk for another name")
+	}
+	if !reflect.DeepEqual(a, walk("randomWalk('a', 60, 42)")) {
+		t.Error("Expected the same walk for the same positional seed")
+	}
+}
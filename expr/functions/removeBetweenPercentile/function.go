@@ -0,0 +1,109 @@
+package removeBetweenPercentile
+
+import (
+	"context"
+	"math"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type removeBetweenPercentile struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &removeBetweenPercentile{}
+	functions := []string{"removeBetweenPercentile"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// removeBetweenPercentile(seriesList, n)
+func (f *removeBetweenPercentile) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := e.GetFloatArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if n < 50 {
+		n = 100 - n
+	}
+
+	length := 0
+	for _, a := range args {
+		if len(a.Values) > length {
+			length = len(a.Values)
+		}
+	}
+
+	low := make([]float64, length)
+	high := make([]float64, length)
+	column := make([]float64, len(args))
+	for i := 0; i < length; i++ {
+		for j, a := range args {
+			column[j] = value(a, i)
+		}
+		low[i] = helper.GraphitePercentile(column, 100-n, false)
+		high[i] = helper.GraphitePercentile(column, n, false)
+	}
+
+	var results []*types.MetricData
+	for _, a := range args {
+		for i := 0; i < length; i++ {
+			// absent points are never between the percentiles, so series
+			// with gaps are kept, like graphite-web does
+			if v := value(a, i); !(low[i] < v && v < high[i]) {
+				results = append(results, a)
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func value(a *types.MetricData, i int) float64 {
+	if i >= len(a.Values) || a.IsAbsent[i] {
+		return math.NaN()
+	}
+	return a.Values[i]
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *removeBetweenPercentile) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"removeBetweenPercentile": {
+			Description: "Removes series that do not have an value lying in the x-percentile of all the values at a moment",
+			Function:    "removeBetweenPercentile(seriesList, n)",
+			Group:       "Filter Series",
+			Module:      "graphite.render.functions",
+			Name:        "removeBetweenPercentile",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "n",
+					Required: true,
+					Type:     types.Integer,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package removeBetweenPercentile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+// Expected values are graphite-web's.
+func TestRemoveBetweenPercentile(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	nan := math.NaN()
+
+	input := map[parser.MetricRequest][]*types.MetricData{
+		{"metric.*", 0, 1}: {
+			types.MakeMetricData("metric.a", []float64{1, 1, 1}, 1, now32),
+			types.MakeMetricData("metric.b", []float64{5, 5, 5}, 1, now32),
+			types.MakeMetricData("metric.c", []float64{6, 7, 6}, 1, now32),
+			types.MakeMetricData("metric.d", []float64{7, 6, 7}, 1, now32),
+			types.MakeMetricData("metric.e", []float64{8, 8, 8}, 1, now32),
+			types.MakeMetricData("metric.f", []float64{30, 30, 30}, 1, now32),
+			types.MakeMetricData("metric.gap", []float64{6, nan, 6}, 1, now32),
+		},
+	}
+
+	extremes := []*types.MetricData{
+		types.MakeMetricData("metric.a", []float64{1, 1, 1}, 1, now32),
+		types.MakeMetricData("metric.f", []float64{30, 30, 30}, 1, now32),
+		types.MakeMetricData("metric.gap", []float64{6, nan, 6}, 1, now32),
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("removeBetweenPercentile", "metric.*", 90),
+			input,
+			extremes,
+		},
+		{
+			parser.NewExpr("removeBetweenPercentile", "metric.*", 10),
+			input,
+			extremes,
+		},
+		{
+			parser.NewExpr("removeBetweenPercentile", "metric.*", 70),
+			input,
+			input[parser.MetricRequest{"metric.*", 0, 1}],
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package removeEmptySeries
 
 import (
+	"context"
+
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
 	"github.com/bookingcom/carbonapi/expr/types"
@@ -25,26 +27,30 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-// removeEmptySeries(seriesLists, n), removeZeroSeries(seriesLists, n)
-func (f *removeEmptySeries) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+// removeEmptySeries(seriesLists, xFilesFactor=0), removeZeroSeries(seriesLists, xFilesFactor=0)
+func (f *removeEmptySeries) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: implement xFilesFactor
+	xFilesFactor, err := e.GetFloatNamedOrPosArgDefault("xFilesFactor", 1, 0)
+	if err != nil {
+		return nil, err
+	}
 
 	var results []*types.MetricData
 
 	for _, a := range args {
+		n := 0
 		for i, v := range a.IsAbsent {
-			if !v {
-				if e.Target() == "removeEmptySeries" || (a.Values[i] != 0) {
-					results = append(results, a)
-					break
-				}
+			if !v && (e.Target() == "removeEmptySeries" || a.Values[i] != 0) {
+				n++
 			}
 		}
+		if types.MeetsXFilesFactor(n, len(a.Values), float32(xFilesFactor)) {
+			results = append(results, a)
+		}
 	}
 	return results, nil
 }
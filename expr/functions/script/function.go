@@ -0,0 +1,365 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/lomik/zapwriter"
+	"github.com/spf13/viper"
+	lua "github.com/yuin/gopher-lua"
+	luaparse "github.com/yuin/gopher-lua/parse"
+	"go.uber.org/zap"
+)
+
+// entryPoint is the global every script defines; it is called with the
+// arguments of the render function and returns the resulting series.
+const entryPoint = "render"
+
+var (
+	// ErrTooManyPoints is returned when the series passed to or returned
+	// from a script hold more datapoints than maxPoints allows.
+	ErrTooManyPoints = errors.New("script: too many datapoints")
+	// ErrBadResult is returned when a script returns something that is not
+	// a list of series.
+	ErrBadResult = errors.New("script: render must return a list of series")
+)
+
+type scriptFunc struct {
+	interfaces.FunctionBase
+
+	name        string
+	description string
+	proto       *lua.FunctionProto
+
+	timeout       time.Duration
+	maxPoints     int
+	callStackSize int
+	registrySize  int
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+type functionConfig struct {
+	Name        string
+	File        string
+	Description string
+}
+
+type scriptConfig struct {
+	Enabled bool
+	// Timeout bounds the run time of one call of a script.
+	Timeout time.Duration
+	// MaxPoints bounds the datapoints a script is given and may return.
+	MaxPoints int
+	// CallStackSize bounds the depth of calls, and so of recursion, in a script.
+	CallStackSize int
+	// RegistrySize bounds the values a script holds on its stack at once,
+	// such as the results of unpack.
+	RegistrySize int
+	Functions    []functionConfig
+}
+
+// New loads the scripts listed in configFile, each of which defines one
+// render function. Scripts are compiled once at startup and run in a fresh,
+// sandboxed Lua state on every call.
+func New(configFile string) []interfaces.FunctionMetadata {
+	logger := zapwriter.Logger("functionInit").With(zap.String("function", "script"))
+	if configFile == "" {
+		return nil
+	}
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	err := v.ReadInConfig()
+	if err != nil {
+		logger.Fatal("failed to read config file",
+			zap.Error(err),
+		)
+	}
+
+	cfg := scriptConfig{
+		Timeout:       time.Second,
+		MaxPoints:     1000000,
+		CallStackSize: 64,
+		RegistrySize:  lua.RegistrySize,
+	}
+	err = v.Unmarshal(&cfg)
+	if err != nil {
+		logger.Fatal("failed to parse config",
+			zap.Error(err),
+		)
+	}
+
+	if !cfg.Enabled {
+		logger.Warn("script config found but scripted functions are disabled")
+		return nil
+	}
+
+	res := make([]interfaces.FunctionMetadata, 0, len(cfg.Functions))
+	for _, fc := range cfg.Functions {
+		proto, err := compile(fc.File)
+		if err != nil {
+			logger.Fatal("failed to load script",
+				zap.String("name", fc.Name),
+				zap.String("file", fc.File),
+				zap.Error(err),
+			)
+		}
+		f := &scriptFunc{
+			name:          fc.Name,
+			description:   fc.Description,
+			proto:         proto,
+			timeout:       cfg.Timeout,
+			maxPoints:     cfg.MaxPoints,
+			callStackSize: cfg.CallStackSize,
+			registrySize:  cfg.RegistrySize,
+		}
+		L, err := f.newState(context.Background())
+		if err != nil {
+			logger.Fatal("failed to load script",
+				zap.String("name", fc.Name),
+				zap.String("file", fc.File),
+				zap.Error(err),
+			)
+		}
+		L.Close()
+
+		logger.Info("scripted function loaded",
+			zap.String("name", fc.Name),
+			zap.String("file", fc.File),
+		)
+		res = append(res, interfaces.FunctionMetadata{Name: fc.Name, F: f})
+	}
+	return res
+}
+
+func compile(file string) (*lua.FunctionProto, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	chunk, err := luaparse.Parse(r, file)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, file)
+}
+
+// sandboxedGlobals are removed from the base library so that scripts can
+// neither load code nor touch the process.
+var sandboxedGlobals = []string{
+	"collectgarbage", "dofile", "load", "loadfile", "loadstring", "module", "print", "require",
+}
+
+// sandboxedStrings are removed from the string library, as they build
+// strings far larger than their arguments.
+var sandboxedStrings = []string{"rep"}
+
+// newState runs the top level of the script in a state that has only the
+// base, table, string and math libraries, and checks it defines render. The
+// stacks of the state don't grow past their sizes.
+func (f *scriptFunc) newState(ctx context.Context) (*lua.LState, error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   f.callStackSize,
+		RegistrySize:    f.registrySize,
+		RegistryMaxSize: f.registrySize,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range sandboxedGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+	strs := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	for _, name := range sandboxedStrings {
+		strs.RawSetString(name, lua.LNil)
+	}
+	L.SetContext(ctx)
+
+	L.Push(L.NewFunctionFromProto(f.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if _, ok := L.GetGlobal(entryPoint).(*lua.LFunction); !ok {
+		L.Close()
+		return nil, fmt.Errorf("script: %s does not define function %s", f.name, entryPoint)
+	}
+	return L, nil
+}
+
+// Do calls render with the arguments of the expression: series lists are
+// passed as lists of tables with name, start, stop, step and values, numbers
+// and strings as themselves. Absent datapoints are NaN going in; NaN or nil
+// coming out.
+func (f *scriptFunc) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	L, err := f.newState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer L.Close()
+
+	var first *types.MetricData
+	points := 0
+	args := make([]lua.LValue, 0, len(e.Args()))
+	for _, arg := range e.Args() {
+		switch {
+		case arg.IsName() || arg.IsFunc():
+			series, err := helper.GetSeriesArg(ctx, arg, from, until, values)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range series {
+				points += len(s.Values)
+			}
+			if points > f.maxPoints {
+				return nil, ErrTooManyPoints
+			}
+			if first == nil && len(series) > 0 {
+				first = series[0]
+			}
+			args = append(args, toLua(L, series))
+		case arg.IsConst():
+			args = append(args, lua.LNumber(arg.FloatValue()))
+		default:
+			args = append(args, lua.LString(arg.StringValue()))
+		}
+	}
+
+	err = L.CallByParam(lua.P{
+		Fn:      L.GetGlobal(entryPoint),
+		NRet:    1,
+		Protect: true,
+	}, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("script: %s: %v", f.name, ctx.Err())
+		}
+		return nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	return f.fromLua(e, first, ret)
+}
+
+func toLua(L *lua.LState, series []*types.MetricData) *lua.LTable {
+	list := L.CreateTable(len(series), 0)
+	for _, s := range series {
+		t := L.CreateTable(0, 5)
+		t.RawSetString("name", lua.LString(s.Name))
+		t.RawSetString("start", lua.LNumber(s.StartTime))
+		t.RawSetString("stop", lua.LNumber(s.StopTime))
+		t.RawSetString("step", lua.LNumber(s.StepTime))
+		vals := L.CreateTable(len(s.Values), 0)
+		for i, v := range s.Values {
+			if s.IsAbsent[i] {
+				v = math.NaN()
+			}
+			vals.RawSetInt(i+1, lua.LNumber(v))
+		}
+		t.RawSetString("values", vals)
+		list.Append(t)
+	}
+	return list
+}
+
+func (f *scriptFunc) fromLua(e parser.Expr, first *types.MetricData, ret lua.LValue) ([]*types.MetricData, error) {
+	list, ok := ret.(*lua.LTable)
+	if !ok {
+		return nil, ErrBadResult
+	}
+
+	var results []*types.MetricData
+	points := 0
+	for i := 1; i <= list.MaxN(); i++ {
+		t, ok := list.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			return nil, ErrBadResult
+		}
+		vals, ok := t.RawGetString("values").(*lua.LTable)
+		if !ok {
+			return nil, ErrBadResult
+		}
+		n := vals.MaxN()
+		points += n
+		if points > f.maxPoints {
+			return nil, ErrTooManyPoints
+		}
+
+		name := e.ToString()
+		if s, ok := t.RawGetString("name").(lua.LString); ok {
+			name = string(s)
+		}
+		var start, step int32
+		if first != nil {
+			start, step = first.StartTime, first.StepTime
+		}
+		if v, ok := t.RawGetString("start").(lua.LNumber); ok {
+			start = int32(v)
+		}
+		if v, ok := t.RawGetString("step").(lua.LNumber); ok {
+			step = int32(v)
+		}
+		if step <= 0 {
+			return nil, fmt.Errorf("script: %s returned series %q without a step", f.name, name)
+		}
+
+		values := make([]float64, n)
+		for j := range values {
+			v, ok := vals.RawGetInt(j + 1).(lua.LNumber)
+			if !ok {
+				values[j] = math.NaN()
+				continue
+			}
+			values[j] = float64(v)
+		}
+		results = append(results, types.MakeMetricData(name, values, step, start))
+	}
+	return results, nil
+}
+
+// Description returns the description given to the function in the config.
+func (f *scriptFunc) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		f.name: {
+			Description: f.description,
+			Function:    f.name + "(seriesList, *args)",
+			Group:       "Transform",
+			Module:      "carbonapi.script",
+			Name:        f.name,
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+			},
+		},
+	}
+}
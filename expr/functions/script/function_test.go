@@ -0,0 +1,173 @@
+package script
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+const clampScript = `
+function render(series, floor)
+  local out = {}
+  for i, s in ipairs(series) do
+    local values = {}
+    for j, v in ipairs(s.values) do
+      if v ~= v then
+        values[j] = nil
+      elseif v < floor then
+        values[j] = floor
+      else
+        values[j] = v
+      end
+    end
+    out[i] = {name = "clamp(" .. s.name .. ")", values = values}
+  end
+  return out
+end
+`
+
+const loopScript = `
+function render(series)
+  while true do end
+end
+`
+
+const escapeScript = `
+function render(series)
+  return {{name = tostring(os) .. tostring(io) .. tostring(require) .. tostring(dofile), values = {}}}
+end
+`
+
+const repScript = `
+function render(series)
+  return {{name = ("x"):rep(1e9), values = {}}}
+end
+`
+
+const unpackScript = `
+function render(series)
+  local t = {}
+  for i = 1, 100000 do t[i] = i end
+  return {{values = {unpack(t)}}}
+end
+`
+
+func load(t *testing.T, scripts map[string]string) []interfaces.FunctionMetadata {
+	dir, err := ioutil.TempDir("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := "enabled: true\ntimeout: 100ms\nmaxPoints: 10\nfunctions:\n"
+	for name, body := range scripts {
+		file := filepath.Join(dir, name+".lua")
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config += "  - name: " + name + "\n    file: " + file + "\n"
+	}
+	configFile := filepath.Join(dir, "script.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	md := New(configFile)
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+	return md
+}
+
+func TestScript(t *testing.T) {
+	load(t, map[string]string{"clamp": clampScript})
+
+	now32 := int32(time.Now().Unix())
+	tests := []th.EvalTestItem{
+		{
+			parser.NewExpr("clamp",
+				"metric1", 2,
+			),
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, math.NaN(), 3}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("clamp(metric1)",
+				[]float64{2, math.NaN(), 3}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	md := load(t, map[string]string{"loop": loopScript})
+	f := md[0].F
+
+	now32 := int32(time.Now().Unix())
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3}, 1, now32)},
+		{"metric2", 0, 1}: {types.MakeMetricData("metric2", make([]float64, 11), 1, now32)},
+	}
+
+	_, err := f.Do(context.Background(), parser.NewExpr("loop", "metric1"), 0, 1, values)
+	if err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("runaway script: got %v, want deadline exceeded", err)
+	}
+
+	_, err = f.Do(context.Background(), parser.NewExpr("loop", "metric2"), 0, 1, values)
+	if err != ErrTooManyPoints {
+		t.Errorf("large input: got %v, want %v", err, ErrTooManyPoints)
+	}
+}
+
+func TestScriptMemory(t *testing.T) {
+	md := load(t, map[string]string{"rep": repScript, "unpack": unpackScript})
+
+	now32 := int32(time.Now().Unix())
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1}, 1, now32)},
+	}
+	for _, m := range md {
+		_, err := m.F.Do(context.Background(), parser.NewExpr(m.Name, "metric1"), 0, 1, values)
+		// The allocations are refused, rather than cut short by the timeout.
+		if err == nil || strings.Contains(err.Error(), "deadline") {
+			t.Errorf("%s: got %v, want the script rejected", m.Name, err)
+		}
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	md := load(t, map[string]string{"escape": escapeScript})
+
+	now32 := int32(time.Now().Unix())
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1}, 1, now32)},
+	}
+	res, err := md[0].F.Do(context.Background(), parser.NewExpr("escape", "metric1"), 0, 1, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res[0].Name; got != "nilnilnilnil" {
+		t.Errorf("script sees %q, want no os, io, require or dofile", got)
+	}
+}
@@ -0,0 +1,235 @@
+package seasonalDecompose
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type seasonalDecompose struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &seasonalDecompose{}
+	functions := []string{"seasonalDecompose"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// seasonalDecompose(seriesList, period, component='trend')
+func (f *seasonalDecompose) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 2 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	var period int
+	var err error
+	var scaleByStep bool
+	var argstr string
+
+	switch e.Args()[1].Type() {
+	case parser.EtConst:
+		period, err = e.GetIntArg(1)
+		argstr = strconv.Itoa(period)
+	case parser.EtString:
+		var p32 int32
+		p32, err = e.GetIntervalArg(1, 1)
+		period = int(p32)
+		argstr = fmt.Sprintf("%q", e.Args()[1].StringValue())
+		scaleByStep = true
+	default:
+		err = parser.ErrBadType
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	component, err := e.GetStringNamedOrPosArgDefault("component", 2, "trend")
+	if err != nil {
+		return nil, err
+	}
+	if component != "trend" && component != "seasonal" && component != "resid" {
+		return nil, fmt.Errorf("seasonalDecompose: unknown component %q, want trend, seasonal or resid", component)
+	}
+
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.MetricData
+	for _, a := range arg {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p := period
+		if scaleByStep {
+			p /= int(a.StepTime)
+		}
+		if p < 2 {
+			return nil, fmt.Errorf("seasonalDecompose: the period of %s is %d points, it needs at least 2", a.Name, p)
+		}
+
+		r := *a
+		r.Name = fmt.Sprintf("seasonalDecompose(%s,%s,%q)", a.Name, argstr, component)
+		r.Values = make([]float64, len(a.Values))
+		r.IsAbsent = make([]bool, len(a.Values))
+
+		trend, seasonal, ok := decompose(a.Values, a.IsAbsent, p)
+		for i, v := range a.Values {
+			r.IsAbsent[i] = true
+			if !ok {
+				continue
+			}
+			switch component {
+			case "trend":
+				if trend[i] != nil {
+					r.Values[i], r.IsAbsent[i] = *trend[i], false
+				}
+			case "seasonal":
+				if seasonal[i] != nil {
+					r.Values[i], r.IsAbsent[i] = *seasonal[i], false
+				}
+			case "resid":
+				if !a.IsAbsent[i] && trend[i] != nil && seasonal[i] != nil {
+					r.Values[i], r.IsAbsent[i] = v-*trend[i]-*seasonal[i], false
+				}
+			}
+		}
+		results = append(results, &r)
+	}
+	return results, nil
+}
+
+// decompose splits values into their trend and seasonal components with
+// period, the way the additive model of statsmodels' seasonal_decompose
+// does: the trend is a centered moving average over a period, and the
+// seasonal component repeats the mean of the detrended values at each phase
+// of the period. The trend is nil where its window isn't full, and the
+// seasonal component where its phase has no detrended values. It is not ok
+// for series shorter than two periods.
+func decompose(values []float64, absent []bool, period int) (trend, seasonal []*float64, ok bool) {
+	n := len(values)
+	if n < 2*period {
+		return nil, nil, false
+	}
+
+	// An even period gets a window of period+1 points, with its ends
+	// weighted by half, to be centered.
+	filter := make([]float64, period)
+	for i := range filter {
+		filter[i] = 1 / float64(period)
+	}
+	if period%2 == 0 {
+		filter[0] = 0.5 / float64(period)
+		filter = append(filter, 0.5/float64(period))
+	}
+	half := len(filter) / 2
+
+	trend = make([]*float64, n)
+	for i := half; i+len(filter)-half <= n; i++ {
+		lo := i - half
+		var sum float64
+		full := true
+		for j, w := range filter {
+			if absent[lo+j] {
+				full = false
+				break
+			}
+			sum += w * values[lo+j]
+		}
+		if full {
+			t := sum
+			trend[i] = &t
+		}
+	}
+
+	averages := make([]*float64, period)
+	var centre float64
+	var known int
+	for phase := range averages {
+		var sum float64
+		var count int
+		for i := phase; i < n; i += period {
+			if !absent[i] && trend[i] != nil {
+				sum += values[i] - *trend[i]
+				count++
+			}
+		}
+		if count > 0 {
+			avg := sum / float64(count)
+			averages[phase] = &avg
+			centre += avg
+			known++
+		}
+	}
+	if known > 0 {
+		centre /= float64(known)
+	}
+	for _, avg := range averages {
+		if avg != nil {
+			*avg -= centre
+		}
+	}
+
+	seasonal = make([]*float64, n)
+	for i := range seasonal {
+		seasonal[i] = averages[i%period]
+	}
+
+	return trend, seasonal, true
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *seasonalDecompose) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"seasonalDecompose": {
+			Description: "Takes one metric or a wildcard seriesList, a period as a number N of\ndatapoints or a quoted string with a length of time like '1d', and\ngraphs the component of the additive seasonal decomposition of each series:\n\n- trend: the centered moving average over a period,\n- seasonal: the mean of the detrended datapoints at each phase of the period,\n- resid: what remains of the datapoints without trend and seasonal.\n\nThe trend is null where its window has a null datapoint or doesn't fit the\nseries, and series shorter than two periods graph as null.\n\nExample:\n\n.. code-block:: none\n\n  &target=seasonalDecompose(Server.instance01.requests,'1d')\n  &target=seasonalDecompose(Server.instance01.requests,'1d','resid')",
+			Function:    "seasonalDecompose(seriesList, period, component='trend')",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions.custom",
+			Name:        "seasonalDecompose",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "period",
+					Required: true,
+					Suggestions: types.NewSuggestions(
+						24,
+						"1h",
+						"1d",
+						"7d",
+					),
+					Type: types.IntOrInterval,
+				},
+				{
+					Name:    "component",
+					Default: types.NewSuggestion("trend"),
+					Options: []string{
+						"trend",
+						"seasonal",
+						"resid",
+					},
+					Type: types.String,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package setXFilesFactor
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F)
+	}
+}
+
+func TestSetXFilesFactor(t *testing.T) {
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, math.NaN(), 3, 4}, 60, 0)},
+	}
+
+	for _, target := range []string{"setXFilesFactor", "xFilesFactor"} {
+		got, err := metadata.GetEvaluator().EvalExpr(context.Background(), parser.NewExpr(target, "metric1", 0.75), 0, 1, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].XFilesFactor != 0.75 {
+			t.Fatalf("%s: got %+v, want a series with xFilesFactor 0.75", target, got)
+		}
+
+		got[0].SetValuesPerPoint(2)
+		if absent := got[0].AggregatedAbsent(); !absent[0] || absent[1] {
+			t.Errorf("%s: consolidated absent = %v, want [true false]", target, absent)
+		}
+	}
+
+	if values[parser.MetricRequest{Metric: "metric1", From: 0, Until: 1}][0].XFilesFactor != 0 {
+		t.Error("the input series was modified")
+	}
+
+	if _, err := metadata.GetEvaluator().EvalExpr(context.Background(), parser.NewExpr("xFilesFactor", "metric1", 2), 0, 1, values); err == nil {
+		t.Error("expected an error for an xFilesFactor above 1")
+	}
+}
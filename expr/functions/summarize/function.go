@@ -1,6 +1,7 @@
 package summarize
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -21,7 +22,7 @@ func GetOrder() interfaces.Order {
 func New(configFile string) []interfaces.FunctionMetadata {
 	res := make([]interfaces.FunctionMetadata, 0)
 	f := &summarize{}
-	functions := []string{"summarize"}
+	functions := []string{"summarize", "smartSummarize"}
 	for _, n := range functions {
 		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
 	}
@@ -29,50 +30,75 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // summarize(seriesList, intervalString, func='sum', alignToFrom=False)
-func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	// TODO(dgryski): make sure the arrays are all the same 'size'
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
-	if err != nil {
-		return nil, err
-	}
-	if len(args) == 0 {
-		return nil, nil
-	}
-
+// smartSummarize(seriesList, intervalString, func='sum', alignTo=None)
+func (f *summarize) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	bucketSize, err := e.GetIntervalArg(1, 1)
 	if err != nil {
 		return nil, err
 	}
+	if bucketSize <= 0 {
+		return nil, fmt.Errorf("%s: the interval must be positive", e.Target())
+	}
 
 	summarizeFunction, err := e.GetStringNamedOrPosArgDefault("func", 2, "sum")
 	if err != nil {
 		return nil, err
 	}
+	aggregate, ok := helper.GetSummarizeFunc(summarizeFunction)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported aggregation function %q", e.Target(), summarizeFunction)
+	}
 	_, funcOk := e.NamedArgs()["func"]
 	if !funcOk {
 		funcOk = len(e.Args()) > 2
 	}
 
-	alignToFrom, err := e.GetBoolNamedOrPosArgDefault("alignToFrom", 3, false)
+	var alignToFrom bool
+	var alignTo string
+	var alignOk bool
+	fetchFrom := from
+	if e.Target() == "smartSummarize" {
+		// smartSummarize always aligns its buckets to the start of the
+		// series, which alignTo moves back to the start of a unit of time
+		alignToFrom = true
+		alignTo, err = e.GetStringNamedOrPosArgDefault("alignTo", 3, "")
+		if err != nil {
+			return nil, err
+		}
+		if alignTo != "" {
+			offs, err := parser.AlignToLookback(alignTo)
+			if err != nil {
+				return nil, err
+			}
+			fetchFrom -= offs
+			alignOk = true
+		}
+	} else {
+		alignToFrom, err = e.GetBoolNamedOrPosArgDefault("alignToFrom", 3, false)
+		if err != nil {
+			return nil, err
+		}
+		_, alignOk = e.NamedArgs()["alignToFrom"]
+		if !alignOk {
+			alignOk = len(e.Args()) > 3
+		}
+	}
+
+	// TODO(dgryski): make sure the arrays are all the same 'size'
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], fetchFrom, until, values)
 	if err != nil {
 		return nil, err
 	}
-	_, alignOk := e.NamedArgs()["alignToFrom"]
-	if !alignOk {
-		alignOk = len(e.Args()) > 3
-	}
-
-	start := args[0].StartTime
-	stop := args[0].StopTime
-	if !alignToFrom {
-		start, stop = helper.AlignToBucketSize(start, stop, bucketSize)
+	if len(args) == 0 {
+		return nil, nil
 	}
 
-	buckets := helper.GetBuckets(start, stop, bucketSize)
 	results := make([]*types.MetricData, 0, len(args))
 	for _, arg := range args {
-
-		name := fmt.Sprintf("summarize(%s,'%s'", arg.Name, e.Args()[1].StringValue())
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s(%s,'%s'", e.Target(), arg.Name, e.Args()[1].StringValue())
 		if funcOk || alignOk {
 			// we include the "func" argument in the presence of
 			// "alignToFrom", even if the former was omitted
@@ -84,7 +110,9 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 			// this does not match graphite's behaviour but seems more correct
 			name += fmt.Sprintf(",'%s'", summarizeFunction)
 		}
-		if alignOk {
+		if alignTo != "" {
+			name += fmt.Sprintf(",'%s'", alignTo)
+		} else if alignOk {
 			name += fmt.Sprintf(",%v", alignToFrom)
 		}
 		name += ")"
@@ -102,58 +130,72 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 			continue
 		}
 
+		// Buckets start at the series start with alignToFrom, and at
+		// multiples of their size since the epoch otherwise, as in
+		// graphite-web 1.1. Points go into the bucket their timestamp,
+		// the start of their step, falls in.
+		first := 0
+		start := arg.StartTime
+		if alignTo != "" {
+			aligned, _ := parser.AlignTo(from, alignTo)
+			if aligned > start {
+				first = int((aligned - start + arg.StepTime - 1) / arg.StepTime)
+				start += int32(first) * arg.StepTime
+			}
+		}
+		if !alignToFrom {
+			start -= start % bucketSize
+		}
+		buckets := helper.GetBuckets(start, arg.StopTime, bucketSize)
+		if buckets < 0 {
+			buckets = 0
+		}
+
+		// Aligned to their start, series keep their end, as in
+		// graphite-web.
+		stop := start + buckets*bucketSize
+		if alignToFrom {
+			stop = arg.StopTime
+		}
+
 		r := types.MetricData{FetchResponse: pb.FetchResponse{
 			Name:      name,
-			Values:    make([]float64, buckets, buckets),
-			IsAbsent:  make([]bool, buckets, buckets),
+			Values:    make([]float64, buckets),
+			IsAbsent:  make([]bool, buckets),
 			StepTime:  bucketSize,
 			StartTime: start,
 			StopTime:  stop,
 		}}
 
-		t := arg.StartTime // unadjusted
-		bucketEnd := start + bucketSize
-		values := make([]float64, 0, bucketSize/arg.StepTime)
+		bucketValues := make([]float64, 0, bucketSize/arg.StepTime+1)
 		ridx := 0
-		bucketItems := 0
-		for i, v := range arg.Values {
-			bucketItems++
-			if !arg.IsAbsent[i] {
-				values = append(values, v)
+		flush := func() {
+			r.IsAbsent[ridx] = true
+			if len(bucketValues) > 0 {
+				rv := aggregate(bucketValues)
+				if !math.IsNaN(rv) {
+					r.Values[ridx] = rv
+					r.IsAbsent[ridx] = false
+				}
 			}
-
-			t += arg.StepTime
-
-			if t >= stop {
+			bucketValues = bucketValues[:0]
+		}
+		for i := first; i < len(arg.Values); i++ {
+			idx := int((arg.StartTime + int32(i)*arg.StepTime - start) / bucketSize)
+			if idx >= int(buckets) {
 				break
 			}
-
-			if t >= bucketEnd {
-				rv := helper.SummarizeValues(summarizeFunction, values)
-
-				if math.IsNaN(rv) {
-					r.IsAbsent[ridx] = true
-				}
-
-				r.Values[ridx] = rv
+			for ridx < idx {
+				flush()
 				ridx++
-				bucketEnd += bucketSize
-				bucketItems = 0
-				values = values[:0]
 			}
-		}
-
-		// last partial bucket
-		if bucketItems > 0 {
-			rv := helper.SummarizeValues(summarizeFunction, values)
-			if math.IsNaN(rv) {
-				r.Values[ridx] = 0
-				r.IsAbsent[ridx] = true
-			} else {
-				r.Values[ridx] = rv
-				r.IsAbsent[ridx] = false
+			if !arg.IsAbsent[i] {
+				bucketValues = append(bucketValues, arg.Values[i])
 			}
 		}
+		for ; ridx < int(buckets); ridx++ {
+			flush()
+		}
 
 		results = append(results, &r)
 	}
@@ -164,7 +206,7 @@ func (f *summarize) Do(e parser.Expr, from, until int32, values map[parser.Metri
 func (f *summarize) Description() map[string]types.FunctionDescription {
 	return map[string]types.FunctionDescription{
 		"summarize": {
-			Description: "Summarize the data into interval buckets of a certain size.\n\nBy default, the contents of each interval bucket are summed together. This is\nuseful for counters where each increment represents a discrete event and\nretrieving a \"per X\" value requires summing all the events in that interval.\n\nSpecifying 'average' instead will return the mean for each bucket, which can be more\nuseful when the value is a gauge that represents a certain value in time.\n\nThis function can be used with aggregation functions ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``count``, ``range``, ``multiply`` & ``last``.\n\nBy default, buckets are calculated by rounding to the nearest interval. This\nworks well for intervals smaller than a day. For example, 22:32 will end up\nin the bucket 22:00-23:00 when the interval=1hour.\n\nPassing alignToFrom=true will instead create buckets starting at the from\ntime. In this case, the bucket for 22:32 depends on the from time. If\nfrom=6:30 then the 1hour bucket for 22:32 is 22:30-23:30.\n\nExample:\n\n.. code-block:: none\n\n  &target=summarize(counter.errors, \"1hour\") # total errors per hour\n  &target=summarize(nonNegativeDerivative(gauge.num_users), \"1week\") # new users per week\n  &target=summarize(queue.size, \"1hour\", \"avg\") # average queue size per hour\n  &target=summarize(queue.size, \"1hour\", \"max\") # maximum queue size during each hour\n  &target=summarize(metric, \"13week\", \"avg\", true)&from=midnight+20100101 # 2010 Q1-4",
+			Description: "Summarize the data into interval buckets of a certain size.\n\nBy default, the contents of each interval bucket are summed together. This is\nuseful for counters where each increment represents a discrete event and\nretrieving a \"per X\" value requires summing all the events in that interval.\n\nSpecifying 'average' instead will return the mean for each bucket, which can be more\nuseful when the value is a gauge that represents a certain value in time.\n\nThis function can be used with aggregation functions ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``count``, ``range``, ``multiply`` & ``last``, and with\npercentiles such as ``p50`` or ``p99.9``.\n\nBy default, buckets are calculated by rounding down to a multiple of the interval\nsince the epoch. This works well for intervals smaller than a day. For example,\n22:32 will end up in the bucket 22:00-23:00 when the interval=1hour.\n\nPassing alignToFrom=true will instead create buckets starting at the from\ntime. In this case, the bucket for 22:32 depends on the from time. If\nfrom=6:30 then the 1hour bucket for 22:32 is 22:30-23:30.\n\nExample:\n\n.. code-block:: none\n\n  &target=summarize(counter.errors, \"1hour\") # total errors per hour\n  &target=summarize(nonNegativeDerivative(gauge.num_users), \"1week\") # new users per week\n  &target=summarize(queue.size, \"1hour\", \"avg\") # average queue size per hour\n  &target=summarize(queue.size, \"1hour\", \"max\") # maximum queue size during each hour\n  &target=summarize(metric, \"13week\", \"avg\", true)&from=midnight+20100101 # 2010 Q1-4",
 			Function:    "summarize(seriesList, intervalString, func='sum', alignToFrom=False)",
 			Group:       "Transform",
 			Module:      "graphite.render.functions",
@@ -210,5 +252,60 @@ func (f *summarize) Description() map[string]types.FunctionDescription {
 				},
 			},
 		},
+		"smartSummarize": {
+			Description: "Smarter version of summarize.\n\nThe alignToFrom boolean parameter has been replaced by alignTo and no longer has any effect.\nAlignment can be to years, months, weeks, days, hours, and minutes, in UTC. Weeks start\non Monday, or on the ISO weekday that follows the unit, as in weeks7 for Sunday.\n\nThe buckets start at from, aligned to the start of its alignTo unit of time.\n\nThis function can be used with aggregation functions ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``count``, ``range``, ``multiply`` & ``last``, and with\npercentiles such as ``p50`` or ``p99.9``.\n\nExample:\n\n.. code-block:: none\n\n  &target=smartSummarize(counter.errors, \"1month\", \"sum\", \"months\") # total errors per calendar month",
+			Function:    "smartSummarize(seriesList, intervalString, func='sum', alignTo=None)",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "smartSummarize",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "intervalString",
+					Required: true,
+					Suggestions: types.NewSuggestions(
+						"10min",
+						"1h",
+						"1d",
+					),
+					Type: types.Interval,
+				},
+				{
+					Default: types.NewSuggestion("sum"),
+					Name:    "func",
+					Options: []string{
+						"average",
+						"count",
+						"diff",
+						"last",
+						"max",
+						"median",
+						"min",
+						"multiply",
+						"range",
+						"stddev",
+						"sum",
+					},
+					Type: types.AggFunc,
+				},
+				{
+					Name: "alignTo",
+					Options: []string{
+						"years",
+						"months",
+						"weeks",
+						"days",
+						"hours",
+						"minutes",
+						"seconds",
+					},
+					Type: types.String,
+				},
+			},
+		},
 	}
 }
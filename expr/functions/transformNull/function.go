@@ -1,6 +1,7 @@
 package transformNull
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -27,8 +28,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // transformNull(seriesList, default=0)
-func (f *transformNull) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *transformNull) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +62,7 @@ func (f *transformNull) Do(e parser.Expr, from, until int32, values map[parser.M
 		r.IsAbsent = make([]bool, len(a.Values))
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if types.IsNull(v, a.IsAbsent[i]) {
 				v = defv
 			}
 
@@ -0,0 +1,98 @@
+package zscore
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/bookingcom/carbonapi/expr/helper"
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+type zscore struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &zscore{}
+	functions := []string{"zscore"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// zscore(seriesList)
+func (f *zscore) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*types.MetricData
+	for _, a := range arg {
+		r := *a
+		r.Name = fmt.Sprintf("zscore(%s)", a.Name)
+		r.Values = make([]float64, len(a.Values))
+		r.IsAbsent = make([]bool, len(a.Values))
+
+		var sum float64
+		var n int
+		for i, v := range a.Values {
+			if !a.IsAbsent[i] {
+				sum += v
+				n++
+			}
+		}
+		var mean, variance float64
+		if n > 0 {
+			mean = sum / float64(n)
+			for i, v := range a.Values {
+				if !a.IsAbsent[i] {
+					variance += (v - mean) * (v - mean)
+				}
+			}
+			variance /= float64(n)
+		}
+		stddev := math.Sqrt(variance)
+
+		// A series without points, or with all of them equal, has no
+		// score to give.
+		for i, v := range a.Values {
+			if a.IsAbsent[i] || stddev == 0 {
+				r.IsAbsent[i] = true
+				continue
+			}
+			r.Values[i] = (v - mean) / stddev
+		}
+		results = append(results, &r)
+	}
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *zscore) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"zscore": {
+			Description: "Takes one metric or a wildcard seriesList and replaces each datapoint with\nits standard score: how many standard deviations it lies from the mean of\nthe series. The mean and the (population) standard deviation are those of\nthe non-null datapoints. Series that are constant, or have no datapoints,\nhave no score and graph as null.\n\nExample:\n\n.. code-block:: none\n\n  &target=zscore(Server.instance01.threads.busy)",
+			Function:    "zscore(seriesList)",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions.custom",
+			Name:        "zscore",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package helper
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+)
+
+// aggregateFuncs are the aggregations graphite's aggregate() and the
+// callbacks of groupByNodes know by name. They get the non-absent values of
+// a point.
+var aggregateFuncs = map[string]AggregateFunc{
+	"average": aggAverage,
+	"avg":     aggAverage,
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+	"current": aggLast,
+	"diff": func(values []float64) float64 {
+		r := values[0]
+		for _, v := range values[1:] {
+			r -= v
+		}
+		return r
+	},
+	"last": aggLast,
+	"max": func(values []float64) float64 {
+		r := values[0]
+		for _, v := range values[1:] {
+			r = math.Max(r, v)
+		}
+		return r
+	},
+	"median": func(values []float64) float64 {
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	},
+	"min": func(values []float64) float64 {
+		r := values[0]
+		for _, v := range values[1:] {
+			r = math.Min(r, v)
+		}
+		return r
+	},
+	"multiply": func(values []float64) float64 {
+		r := values[0]
+		for _, v := range values[1:] {
+			r *= v
+		}
+		return r
+	},
+	"range":   aggRange,
+	"rangeOf": aggRange,
+	"stddev": func(values []float64) float64 {
+		avg := aggAverage(values)
+		var sum float64
+		for _, v := range values {
+			sum += (v - avg) * (v - avg)
+		}
+		return math.Sqrt(sum / float64(len(values)))
+	},
+	"sum":   aggSum,
+	"total": aggSum,
+}
+
+func aggSum(values []float64) float64 {
+	var r float64
+	for _, v := range values {
+		r += v
+	}
+	return r
+}
+
+func aggLast(values []float64) float64 {
+	return values[len(values)-1]
+}
+
+func aggAverage(values []float64) float64 {
+	return aggSum(values) / float64(len(values))
+}
+
+func aggRange(values []float64) float64 {
+	min, max := values[0], values[0]
+	for _, v := range values[1:] {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	return max - min
+}
+
+// GetAggregateFunc returns the aggregation called name, graphite's "sum",
+// "average" and so on.
+func GetAggregateFunc(name string) (AggregateFunc, bool) {
+	f, ok := aggregateFuncs[name]
+	return f, ok
+}
+
+// GetSummarizeFunc returns the aggregation of summarize() and
+// smartSummarize() called name: one GetAggregateFunc knows, or a percentile
+// such as "p50" or "p99.9".
+func GetSummarizeFunc(name string) (AggregateFunc, bool) {
+	if f, ok := aggregateFuncs[name]; ok {
+		return f, true
+	}
+	if !strings.HasPrefix(name, "p") {
+		return nil, false
+	}
+	percent, err := strconv.ParseFloat(name[1:], 64)
+	if err != nil {
+		return nil, false
+	}
+
+	return func(values []float64) float64 {
+		return Percentile(values, percent, true)
+	}, true
+}
+
+// GroupByNodes groups the series by the given nodes of their names, the way
+// graphite's groupByNodes does. Negative nodes count from the end of the name
+// and nodes out of range are left out of the key. The keys are returned in the
+// order their first series appear in.
+func GroupByNodes(args []*types.MetricData, nodes []int) (map[string][]*types.MetricData, []string) {
+	groups := make(map[string][]*types.MetricData)
+	var keys []string
+
+	for _, a := range args {
+		parts := strings.Split(ExtractMetric(a.Name), ".")
+		key := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			if n < 0 {
+				n += len(parts)
+			}
+			if n < 0 || n >= len(parts) {
+				continue
+			}
+			key = append(key, parts[n])
+		}
+
+		k := strings.Join(key, ".")
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], a)
+	}
+
+	return groups, keys
+}
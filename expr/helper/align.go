@@ -3,6 +3,8 @@ package helper
 import (
 	"math"
 	"time"
+
+	"github.com/bookingcom/carbonapi/expr/types"
 )
 
 // GetBuckets returns amount buckets for timeSeries (defined with startTime, stopTime and step (bucket) size.
@@ -34,3 +36,85 @@ func AlignToBucketSize(start, stop, bucketSize int32) (int32, int32) {
 
 	return start, newStop
 }
+
+// Normalize brings the series to a common step, the least common multiple of
+// their steps, averaging the points of the finer ones, and then aligns their
+// start and stop times. This is graphite's normalize(); the series passed in
+// are not modified.
+func Normalize(args []*types.MetricData) []*types.MetricData {
+	if len(args) == 0 {
+		return args
+	}
+
+	step := args[0].StepTime
+	for _, a := range args[1:] {
+		step = lcm(step, a.StepTime)
+	}
+
+	normalized := make([]*types.MetricData, len(args))
+	for i, a := range args {
+		if a.StepTime == step || a.StepTime <= 0 {
+			normalized[i] = a
+			continue
+		}
+		normalized[i] = consolidate(a, int(step/a.StepTime))
+	}
+
+	return AlignSeries(normalized)
+}
+
+// consolidate averages every valuesPerPoint points of the series into one.
+// Intervals with too few points for its xFilesFactor are absent.
+func consolidate(a *types.MetricData, valuesPerPoint int) *types.MetricData {
+	n := (len(a.Values) + valuesPerPoint - 1) / valuesPerPoint
+	r := *a
+	r.Values = make([]float64, n)
+	r.IsAbsent = make([]bool, n)
+	r.StepTime = a.StepTime * int32(valuesPerPoint)
+
+	for i := range r.Values {
+		var sum float64
+		var count, total int
+		for j := i * valuesPerPoint; j < (i+1)*valuesPerPoint && j < len(a.Values); j++ {
+			total++
+			if !a.IsAbsent[j] {
+				sum += a.Values[j]
+				count++
+			}
+		}
+		if !types.MeetsXFilesFactor(count, total, a.XFilesFactor) {
+			r.IsAbsent[i] = true
+			continue
+		}
+		r.Values[i] = sum / float64(count)
+	}
+	r.StopTime = r.StartTime + int32(n)*r.StepTime
+
+	return &r
+}
+
+func gcd(a, b int32) int32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func lcm(a, b int32) int32 {
+	if a <= 0 || b <= 0 {
+		return a
+	}
+	return a / gcd(a, b) * b
+}
+
+// FuturePoints returns the number of points a series needs past its stop
+// time to reach until, for the functions that forecast it there. The
+// backends are only asked for data until now, so the series of a request
+// until a time in the future stop before it.
+func FuturePoints(a *types.MetricData, until int32) int {
+	if a.StepTime <= 0 || until <= a.StopTime {
+		return 0
+	}
+
+	return int(GetBuckets(a.StopTime, until, a.StepTime))
+}
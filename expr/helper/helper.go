@@ -1,10 +1,11 @@
 package helper
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -35,12 +36,12 @@ func SetEvaluator(e interfaces.Evaluator) {
 }
 
 // GetSeriesArg returns argument from series.
-func GetSeriesArg(arg parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func GetSeriesArg(ctx context.Context, arg parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if !arg.IsName() && !arg.IsFunc() {
 		return nil, parser.ErrMissingTimeseries
 	}
 
-	a, err := evaluator.EvalExpr(arg, from, until, values)
+	a, err := evaluator.EvalExpr(ctx, arg, from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -59,11 +60,11 @@ func RemoveEmptySeriesFromName(args []*types.MetricData) string {
 }
 
 // GetSeriesArgs returns arguments of series
-func GetSeriesArgs(e []parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func GetSeriesArgs(ctx context.Context, e []parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var args []*types.MetricData
 
 	for _, arg := range e {
-		a, err := GetSeriesArg(arg, from, until, values)
+		a, err := GetSeriesArg(ctx, arg, from, until, values)
 		if err != nil && err != parser.ErrSeriesDoesNotExist {
 			return nil, err
 		}
@@ -79,8 +80,8 @@ func GetSeriesArgs(e []parser.Expr, from, until int32, values map[parser.MetricR
 
 // GetSeriesArgsAndRemoveNonExisting will fetch all required arguments, but will also filter out non existing Series
 // This is needed to be graphite-web compatible in cases when you pass non-existing Series to, for example, sumSeries
-func GetSeriesArgsAndRemoveNonExisting(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := GetSeriesArgs(e.Args(), from, until, values)
+func GetSeriesArgsAndRemoveNonExisting(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := GetSeriesArgs(ctx, e.Args(), from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -96,14 +97,20 @@ func GetSeriesArgsAndRemoveNonExisting(e parser.Expr, from, until int32, values
 type seriesFunc func(*types.MetricData, *types.MetricData) *types.MetricData
 
 // ForEachSeriesDo do action for each serie in list.
-func ForEachSeriesDo(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, function seriesFunc) ([]*types.MetricData, error) {
-	arg, err := GetSeriesArg(e.Args()[0], from, until, values)
+func ForEachSeriesDo(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, function seriesFunc) ([]*types.MetricData, error) {
+	arg, err := GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, parser.ErrMissingTimeseries
 	}
 	var results []*types.MetricData
 
 	for _, a := range arg {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		r := *a
 		r.Name = fmt.Sprintf("%s(%s)", e.Target(), a.Name)
 		r.Values = make([]float64, len(a.Values))
@@ -114,14 +121,28 @@ func ForEachSeriesDo(e parser.Expr, from, until int32, values map[parser.MetricR
 }
 
 // AlignSeries aligns different series together. By default it only prepends and appends NaNs in case of different length, but if ExtrapolatePoints is enabled, it can extrapolate
+//
+// The series passed in may be shared with other expressions, so the ones
+// that need changes are copied first.
 func AlignSeries(args []*types.MetricData) []*types.MetricData {
+	args = append([]*types.MetricData(nil), args...)
+	copied := make([]bool, len(args))
+	own := func(i int) *types.MetricData {
+		if !copied[i] {
+			c := *args[i]
+			args[i] = &c
+			copied[i] = true
+		}
+		return args[i]
+	}
+
 	minStart := args[0].StartTime
 	maxStop := args[0].StopTime
 	maxVals := 0
 	minStepTime := args[0].StepTime
 	for j := 0; j < 2; j++ {
 		if ExtrapolatePoints {
-			for _, arg := range args {
+			for n, arg := range args {
 				if arg.StepTime < minStepTime {
 					minStepTime = arg.StepTime
 				}
@@ -149,6 +170,7 @@ func AlignSeries(args []*types.MetricData) []*types.MetricData {
 						ts += minStepTime
 						i++
 					}
+					arg = own(n)
 					arg.IsAbsent = newIsAbsent
 					arg.Values = newVals
 					arg.StepTime = minStepTime
@@ -156,7 +178,7 @@ func AlignSeries(args []*types.MetricData) []*types.MetricData {
 			}
 		}
 
-		for _, arg := range args {
+		for i, arg := range args {
 			if len(arg.IsAbsent) > maxVals {
 				maxVals = len(arg.IsAbsent)
 			}
@@ -164,6 +186,7 @@ func AlignSeries(args []*types.MetricData) []*types.MetricData {
 				minStart = arg.StartTime
 			}
 			if minStart < arg.StartTime {
+				arg = own(i)
 				valCnt := (arg.StartTime - minStart) / arg.StepTime
 				newVals := make([]float64, valCnt)
 				newVals = append(newVals, arg.Values...)
@@ -182,16 +205,17 @@ func AlignSeries(args []*types.MetricData) []*types.MetricData {
 				maxStop = arg.StopTime
 			}
 			if maxStop > arg.StopTime {
+				arg = own(i)
 				valCnt := (maxStop - arg.StopTime) / arg.StepTime
 				newVals := make([]float64, valCnt)
-				arg.Values = append(arg.Values, newVals...)
+				arg.Values = append(arg.Values[:len(arg.Values):len(arg.Values)], newVals...)
 				arg.StopTime = maxStop
 
 				newIsAbsent := make([]bool, valCnt)
 				for i := range newIsAbsent {
 					newIsAbsent[i] = true
 				}
-				arg.IsAbsent = append(arg.IsAbsent, newIsAbsent...)
+				arg.IsAbsent = append(arg.IsAbsent[:len(arg.IsAbsent):len(arg.IsAbsent)], newIsAbsent...)
 			}
 		}
 	}
@@ -201,10 +225,12 @@ func AlignSeries(args []*types.MetricData) []*types.MetricData {
 // AggregateFunc type that defined aggregate function
 type AggregateFunc func([]float64) float64
 
-// AggregateSeries aggregates series
+// AggregateSeries aggregates series. Points where too few series have a value
+// for the xFilesFactor of the first series are absent.
 func AggregateSeries(e parser.Expr, args []*types.MetricData, function AggregateFunc) ([]*types.MetricData, error) {
 	args = AlignSeries(args)
 	length := len(args[0].Values)
+	xFilesFactor := args[0].XFilesFactor
 	r := *args[0]
 	r.Name = fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs())
 	r.Values = make([]float64, length)
@@ -219,7 +245,7 @@ func AggregateSeries(e parser.Expr, args []*types.MetricData, function Aggregate
 		}
 
 		r.Values[i] = math.NaN()
-		if len(values) > 0 {
+		if types.MeetsXFilesFactor(len(values), len(args), xFilesFactor) {
 			r.Values[i] = function(values)
 		}
 
@@ -229,53 +255,20 @@ func AggregateSeries(e parser.Expr, args []*types.MetricData, function Aggregate
 	return []*types.MetricData{&r}, nil
 }
 
-// SummarizeValues summarizes values
+// SummarizeValues summarizes values with the aggregation called f, one of
+// those GetSummarizeFunc knows. It returns NaN for no values, or an unknown
+// aggregation.
 func SummarizeValues(f string, values []float64) float64 {
-	rv := 0.0
-
 	if len(values) == 0 {
 		return math.NaN()
 	}
 
-	switch f {
-	case "sum", "total":
-		for _, av := range values {
-			rv += av
-		}
-
-	case "avg":
-		for _, av := range values {
-			rv += av
-		}
-		rv /= float64(len(values))
-	case "max":
-		rv = math.Inf(-1)
-		for _, av := range values {
-			if av > rv {
-				rv = av
-			}
-		}
-	case "min":
-		rv = math.Inf(1)
-		for _, av := range values {
-			if av < rv {
-				rv = av
-			}
-		}
-	case "last":
-		if len(values) > 0 {
-			rv = values[len(values)-1]
-		}
-
-	default:
-		f = strings.Split(f, "p")[1]
-		percent, err := strconv.ParseFloat(f, 64)
-		if err == nil {
-			rv = Percentile(values, percent, true)
-		}
+	fn, ok := GetSummarizeFunc(f)
+	if !ok {
+		return math.NaN()
 	}
 
-	return rv
+	return fn(values)
 }
 
 // ExtractMetric extracts metric out of function list
@@ -363,6 +356,48 @@ func Percentile(data []float64, percent float64, interpolate bool) float64 {
 	return (top * remainder) + (secondTop * (1 - remainder))
 }
 
+// GraphitePercentile returns the percent-th percentile of data the way
+// graphite-web's _getPercentile does: the value at the ceiling of the
+// fractional rank, or with interpolate the value at its floor plus the
+// fraction of the distance to the next value. NaNs are ignored, NaN is
+// returned if nothing is left.
+func GraphitePercentile(data []float64, percent float64, interpolate bool) float64 {
+	sorted := make([]float64, 0, len(data))
+	for _, v := range data {
+		if !math.IsNaN(v) {
+			sorted = append(sorted, v)
+		}
+	}
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(sorted)
+
+	fractionalRank := (percent / 100) * float64(len(sorted)+1)
+	rank := int(fractionalRank)
+	rankFraction := fractionalRank - float64(rank)
+
+	if !interpolate {
+		rank += int(math.Ceil(rankFraction))
+	}
+
+	var percentile float64
+	switch {
+	case rank <= 0:
+		percentile = sorted[0]
+	case rank >= len(sorted):
+		percentile = sorted[len(sorted)-1]
+	default:
+		percentile = sorted[rank-1]
+	}
+
+	if interpolate && rank > 0 && rank < len(sorted) {
+		percentile += rankFraction * (sorted[rank] - percentile)
+	}
+
+	return percentile
+}
+
 // MaxValue returns maximum from the list
 func MaxValue(f64s []float64, absent []bool) float64 {
 	m := math.Inf(-1)
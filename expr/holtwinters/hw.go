@@ -26,16 +26,58 @@ func holtWintersDeviation(gamma, actual, prediction, lastSeasonalDev float64) fl
 	return gamma*math.Abs(actual-prediction) + (1-gamma)*lastSeasonalDev
 }
 
-// HoltWintersAnalysis do Holt-Winters Analysis
-func HoltWintersAnalysis(series []float64, step int32) ([]float64, []float64) {
+// HoltWintersAnalysis do Holt-Winters Analysis with seasons of seasonality seconds
+func HoltWintersAnalysis(series []float64, step int32, seasonality int32) ([]float64, []float64) {
+	a := holtWintersAnalysis(series, step, seasonality)
+	return a.predictions, a.deviations
+}
+
+// HoltWintersForecast returns the predictions of HoltWintersAnalysis,
+// followed by those of points more steps past the end of series. Those go
+// on from the last intercept and slope, with the last seasonals; they are
+// NaN if the last value of series is.
+func HoltWintersForecast(series []float64, step int32, seasonality int32, points int) []float64 {
+	a := holtWintersAnalysis(series, step, seasonality)
+
+	n := len(series)
+	predictions := a.predictions
+	for k := 0; k < points; k++ {
+		if n == 0 {
+			predictions = append(predictions, math.NaN())
+			continue
+		}
+
+		var seasonal float64
+		if j := n - a.seasonLength + k%a.seasonLength; j >= 0 {
+			seasonal = a.seasonals[j]
+		}
+		predictions = append(predictions, a.intercepts[n-1]+float64(k+1)*a.slopes[n-1]+seasonal)
+	}
+
+	return predictions
+}
+
+// analysis is the state of a Holt-Winters analysis at each point.
+type analysis struct {
+	seasonLength int
+	intercepts   []float64
+	slopes       []float64
+	seasonals    []float64
+	predictions  []float64
+	deviations   []float64
+}
+
+func holtWintersAnalysis(series []float64, step int32, seasonality int32) analysis {
 	const (
 		alpha = 0.1
 		beta  = 0.0035
 		gamma = 0.1
 	)
 
-	// season is currently one day
-	seasonLength := 24 * 60 * 60 / int(step)
+	seasonLength := int(seasonality / step)
+	if seasonLength < 1 {
+		seasonLength = 1
+	}
 
 	var (
 		intercepts  []float64
@@ -112,16 +154,24 @@ func HoltWintersAnalysis(series []float64, step int32) ([]float64, []float64) {
 		deviations = append(deviations, deviation)
 	}
 
-	return predictions, deviations
+	return analysis{
+		seasonLength: seasonLength,
+		intercepts:   intercepts,
+		slopes:       slopes,
+		seasonals:    seasonals,
+		predictions:  predictions,
+		deviations:   deviations,
+	}
 }
 
-// HoltWintersConfidenceBands do Holt-Winters Confidence Bands
-func HoltWintersConfidenceBands(series []float64, step int32, delta float64) ([]float64, []float64) {
+// HoltWintersConfidenceBands do Holt-Winters Confidence Bands, leaving out
+// the first bootstrapInterval seconds of the series
+func HoltWintersConfidenceBands(series []float64, step int32, delta float64, bootstrapInterval int32, seasonality int32) ([]float64, []float64) {
 	var lowerBand, upperBand []float64
 
-	predictions, deviations := HoltWintersAnalysis(series, step)
+	predictions, deviations := HoltWintersAnalysis(series, step, seasonality)
 
-	windowPoints := 7 * 86400 / step
+	windowPoints := WindowPoints(len(series), step, bootstrapInterval)
 
 	predictionsOfInterest := predictions[windowPoints:]
 	deviationsOfInterest := deviations[windowPoints:]
@@ -139,3 +189,13 @@ func HoltWintersConfidenceBands(series []float64, step int32, delta float64) ([]
 
 	return lowerBand, upperBand
 }
+
+// WindowPoints returns the number of points of a series of the given length
+// that fall into the bootstrap interval.
+func WindowPoints(length int, step int32, bootstrapInterval int32) int {
+	n := int(bootstrapInterval / step)
+	if n > length {
+		n = length
+	}
+	return n
+}
@@ -1,6 +1,7 @@
 package applyByNode
 
 import (
+	"context"
 	"fmt"
 	"github.com/bookingcom/carbonapi/expr/helper"
 	"github.com/bookingcom/carbonapi/expr/interfaces"
@@ -26,8 +27,8 @@ func New(configFile string) []interfaces.RewriteFunctionMetadata {
 	return res
 }
 
-func (f *applyByNode) Do(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *applyByNode) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values)
 	if err != nil {
 		return false, nil, err
 	}
@@ -55,7 +56,7 @@ func (f *applyByNode) Do(e parser.Expr, from, until int32, values map[parser.Met
 		metric := helper.ExtractMetric(a.Name)
 		nodes := strings.Split(metric, ".")
 		node := strings.Join(nodes[0:field], ".")
-		newTarget := strings.Replace(callback, "%", node, -1)
+		newTarget := strings.Replace(callback, "%", parser.EscapeName(node), -1)
 
 		if newName != "" {
 			newTarget = fmt.Sprintf("alias(%s,\"%s\")", newTarget, strings.Replace(newName, "%", node, -1))
@@ -0,0 +1,99 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/interfaces"
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+// FunctionTime is what the calls of a function took.
+type FunctionTime struct {
+	Calls int
+	Time  time.Duration
+}
+
+// FunctionTimeoutError fails an evaluation in which a function ran for
+// longer than the timeout of its trace.
+type FunctionTimeoutError struct {
+	Function string
+	Took     time.Duration
+	Timeout  time.Duration
+}
+
+func (e *FunctionTimeoutError) Error() string {
+	return fmt.Sprintf("%s took %v, more than the %v functions may take", e.Function, e.Took.Round(time.Millisecond), e.Timeout)
+}
+
+// Trace times the functions of the evaluations with the contexts it is
+// attached to, the time of a function including that of the functions it
+// calls. With a Timeout, each function call gets a context that expires
+// after it: the long loops of functions check their context, and a call
+// that runs for longer fails the evaluation.
+type Trace struct {
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	functions map[string]FunctionTime
+}
+
+type traceKey struct{}
+
+// WithTrace has the evaluations with ctx timed by t.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func traceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Functions returns the time taken by each function evaluated.
+func (t *Trace) Functions() map[string]FunctionTime {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	functions := make(map[string]FunctionTime, len(t.functions))
+	for name, ft := range t.functions {
+		functions[name] = ft
+	}
+
+	return functions
+}
+
+func (t *Trace) do(ctx context.Context, f interfaces.Function, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	t0 := time.Now()
+	data, err := f.Do(ctx, e, from, until, values)
+	took := time.Since(t0)
+
+	t.mu.Lock()
+	if t.functions == nil {
+		t.functions = make(map[string]FunctionTime)
+	}
+	ft := t.functions[e.Target()]
+	ft.Calls++
+	ft.Time += took
+	t.functions[e.Target()] = ft
+	t.mu.Unlock()
+
+	// the innermost call that ran out of time is the one to blame
+	if _, ok := err.(*FunctionTimeoutError); ok {
+		return nil, err
+	}
+	if t.Timeout > 0 && took > t.Timeout {
+		return nil, &FunctionTimeoutError{Function: e.Target(), Took: took, Timeout: t.Timeout}
+	}
+
+	return data, err
+}
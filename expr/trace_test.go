@@ -0,0 +1,85 @@
+package expr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/parser"
+)
+
+func TestTrace(t *testing.T) {
+	exp, _, err := parser.ParseExpr("scale(sumSeries(absolute(foo),absolute(bar)),2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo", From: 0, Until: 1}: {types.MakeMetricData("foo", []float64{1, -2, 3}, 1, 0)},
+		{Metric: "bar", From: 0, Until: 1}: {types.MakeMetricData("bar", []float64{-1, 2, 3}, 1, 0)},
+	}
+
+	trace := &Trace{}
+	if _, err := EvalExpr(WithTrace(context.Background(), trace), exp, 0, 1, values); err != nil {
+		t.Fatal(err)
+	}
+
+	functions := trace.Functions()
+	for name, calls := range map[string]int{"scale": 1, "sumSeries": 1, "absolute": 2} {
+		if functions[name].Calls != calls {
+			t.Errorf("expected %d calls of %s, got %d", calls, name, functions[name].Calls)
+		}
+	}
+	if functions["scale"].Time < functions["sumSeries"].Time {
+		t.Errorf("expected the time of scale to include that of sumSeries, got %v", functions)
+	}
+
+	// Evaluations with other contexts aren't timed.
+	if _, err := EvalExpr(context.Background(), exp, 0, 1, values); err != nil {
+		t.Fatal(err)
+	}
+	if got := trace.Functions()["scale"].Calls; got != 1 {
+		t.Errorf("expected no more calls without the trace, got %d", got)
+	}
+}
+
+func TestTraceTimeout(t *testing.T) {
+	exp, _, err := parser.ParseExpr("scale(absolute(foo),2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo", From: 0, Until: 1}: {types.MakeMetricData("foo", []float64{1, -2, 3}, 1, 0)},
+	}
+
+	trace := &Trace{Timeout: time.Nanosecond}
+	_, err = EvalExpr(WithTrace(context.Background(), trace), exp, 0, 1, values)
+	terr, ok := err.(*FunctionTimeoutError)
+	if !ok {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	// scale is out of time before it evaluates its argument, which then
+	// isn't evaluated at all.
+	if terr.Function != "scale" {
+		t.Errorf("expected scale to time out, got %s", terr.Function)
+	}
+	if calls := trace.Functions()["absolute"].Calls; calls != 0 {
+		t.Errorf("expected absolute not to be evaluated, got %d calls", calls)
+	}
+}
+
+func TestEvalExprDone(t *testing.T) {
+	exp, _, err := parser.ParseExpr("scale(absolute(foo),2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo", From: 0, Until: 1}: {types.MakeMetricData("foo", []float64{1, -2, 3}, 1, 0)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := EvalExpr(ctx, exp, 0, 1, values); err != context.Canceled {
+		t.Errorf("expected the evaluation to stop, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package types
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HistogramBucket parses the upper bound of a Prometheus-style histogram
+// bucket out of a series name, either from an le tag (latency;le=0.5) or from
+// a last node like le_0_5, where the first "_" after the integer part stands
+// for the decimal point. It also returns the name of the histogram, which is
+// the name without the bound.
+func HistogramBucket(name string) (histogram string, le float64, ok bool) {
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		tags := strings.Split(name[i+1:], ";")
+		for j, tag := range tags {
+			if !strings.HasPrefix(tag, "le=") {
+				continue
+			}
+			le, err := strconv.ParseFloat(tag[len("le="):], 64)
+			if err != nil {
+				return "", 0, false
+			}
+			rest := append([]string{name[:i]}, tags[:j]...)
+			rest = append(rest, tags[j+1:]...)
+			return strings.Join(rest, ";"), le, true
+		}
+		return "", 0, false
+	}
+
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 || !strings.HasPrefix(name[i+1:], "le_") {
+		return "", 0, false
+	}
+	bound := strings.Replace(name[i+1+len("le_"):], "_", ".", 1)
+	le, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return name[:i], le, true
+}
+
+// Histogram is the buckets of one histogram, sorted by bound.
+type Histogram struct {
+	Name    string
+	Bounds  []float64
+	Buckets []*MetricData
+}
+
+// GroupHistograms groups the bucket series by histogram, in the order the
+// histograms first appear. Series that aren't buckets are left out.
+func GroupHistograms(series []*MetricData) []*Histogram {
+	var histograms []*Histogram
+	byName := make(map[string]*Histogram)
+	for _, s := range series {
+		name, le, ok := HistogramBucket(s.Name)
+		if !ok {
+			continue
+		}
+
+		h, ok := byName[name]
+		if !ok {
+			h = &Histogram{Name: name}
+			byName[name] = h
+			histograms = append(histograms, h)
+		}
+		h.Bounds = append(h.Bounds, le)
+		h.Buckets = append(h.Buckets, s)
+	}
+
+	for _, h := range histograms {
+		sort.Sort(byBound{h})
+	}
+
+	return histograms
+}
+
+type byBound struct{ *Histogram }
+
+func (b byBound) Len() int           { return len(b.Bounds) }
+func (b byBound) Less(i, j int) bool { return b.Bounds[i] < b.Bounds[j] }
+func (b byBound) Swap(i, j int) {
+	b.Bounds[i], b.Bounds[j] = b.Bounds[j], b.Bounds[i]
+	b.Buckets[i], b.Buckets[j] = b.Buckets[j], b.Buckets[i]
+}
+
+// Len is the number of points all buckets have.
+func (h *Histogram) Len() int {
+	n := len(h.Buckets[0].Values)
+	for _, b := range h.Buckets[1:] {
+		if len(b.Values) < n {
+			n = len(b.Values)
+		}
+	}
+
+	return n
+}
+
+// Count returns how many observations of point i fell in bucket j alone, as
+// opposed to the cumulative count of the bucket series. An absent lower
+// bucket counts as empty.
+func (h *Histogram) Count(j, i int) (float64, bool) {
+	b := h.Buckets[j]
+	if b.IsAbsent[i] {
+		return 0, false
+	}
+	if j == 0 || h.Buckets[j-1].IsAbsent[i] {
+		return b.Values[i], true
+	}
+
+	return b.Values[i] - h.Buckets[j-1].Values[i], true
+}
+
+// FormatBound formats a bucket bound the way Prometheus does.
+func FormatBound(le float64) string {
+	if math.IsInf(le, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(le, 'g', -1, 64)
+}
+
+// MarshalHistogram marshals the bucket series to JSON matrices, one per
+// histogram, e.g.
+//
+//	[{"target":"latency","buckets":["0.5","1","+Inf"],"datapoints":[[[3,1,0],60],...]}]
+//
+// where each point has the count of every bucket alone. The points of the
+// first bucket are used for the timestamps.
+func MarshalHistogram(results []*MetricData) []byte {
+	b := []byte{'['}
+
+	for n, h := range GroupHistograms(results) {
+		if n > 0 {
+			b = append(b, ',')
+		}
+
+		b = append(b, `{"target":`...)
+		b = strconv.AppendQuoteToASCII(b, h.Name)
+		b = append(b, `,"buckets":[`...)
+		for j, le := range h.Bounds {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendQuote(b, FormatBound(le))
+		}
+
+		b = append(b, `],"datapoints":[`...)
+		first := h.Buckets[0]
+		for i := 0; i < h.Len(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+
+			b = append(b, "[["...)
+			for j := range h.Buckets {
+				if j > 0 {
+					b = append(b, ',')
+				}
+				if v, ok := h.Count(j, i); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+					b = strconv.AppendFloat(b, v, 'f', -1, 64)
+				} else {
+					b = append(b, "null"...)
+				}
+			}
+			b = append(b, "],"...)
+			b = strconv.AppendInt(b, int64(first.StartTime+int32(i)*first.StepTime), 10)
+			b = append(b, ']')
+		}
+		b = append(b, "]}"...)
+	}
+
+	return append(b, ']')
+}
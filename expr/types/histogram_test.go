@@ -0,0 +1,47 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramBucket(t *testing.T) {
+	tests := []struct {
+		name      string
+		histogram string
+		le        float64
+		ok        bool
+	}{
+		{"latency;le=0.25", "latency", 0.25, true},
+		{"latency;dc=ams;le=+Inf;host=a", "latency;dc=ams;host=a", math.Inf(1), true},
+		{"app.latency.le_0_25", "app.latency", 0.25, true},
+		{"app.latency.le_10", "app.latency", 10, true},
+		{"app.latency.le_inf", "app.latency", math.Inf(1), true},
+		{"app.latency.count", "", 0, false},
+		{"app.latency.le_fast", "", 0, false},
+		{"latency;dc=ams", "", 0, false},
+		{"latency", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		histogram, le, ok := HistogramBucket(tt.name)
+		if histogram != tt.histogram || le != tt.le || ok != tt.ok {
+			t.Errorf("HistogramBucket(%q) = %q, %v, %v, want %q, %v, %v", tt.name, histogram, le, ok, tt.histogram, tt.le, tt.ok)
+		}
+	}
+}
+
+func TestMarshalHistogram(t *testing.T) {
+	results := []*MetricData{
+		MakeMetricData("latency;le=+Inf", []float64{4, math.NaN()}, 60, 120),
+		MakeMetricData("latency;le=0.5", []float64{1, 2}, 60, 120),
+		MakeMetricData("requests", []float64{7, 7}, 60, 120),
+		MakeMetricData("latency;le=1", []float64{3, 3}, 60, 120),
+	}
+
+	got := string(MarshalHistogram(results))
+	want := `[{"target":"latency","buckets":["0.5","1","+Inf"],"datapoints":[[[1,2,1],120],[[2,1,null],180]]}]`
+	if got != want {
+		t.Errorf("MarshalHistogram()\n got: %s\nwant: %s", got, want)
+	}
+}
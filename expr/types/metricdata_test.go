@@ -30,6 +30,48 @@ func TestJSONResponse(t *testing.T) {
 	}
 }
 
+func TestJSONResponseWithMeta(t *testing.T) {
+	fetched := MakeMetricData("metric1", []float64{1, 2, 3, 4}, 100, 100)
+	fetched.Backends = []string{"http://store1:8080", "http://store2:8080"}
+	fetched.HealedPoints = 2
+	fetched.SetValuesPerPoint(2)
+
+	computed := MakeMetricData("sum", []float64{1}, 60, 100)
+
+	b := MarshalJSONWithMeta([]*MetricData{fetched, computed})
+	want := `[{"target":"metric1","datapoints":[[1.5,100],[3.5,300]],"meta":{"backends":["http://store1:8080","http://store2:8080"],"healedPoints":2,"step":200}},` +
+		`{"target":"sum","datapoints":[[1,100]],"meta":{"backends":[],"healedPoints":0,"step":60}}]`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestAggregateValuesXFilesFactor(t *testing.T) {
+	r := MakeMetricData("metric", []float64{1, math.NaN(), math.NaN(), math.NaN(), 5, 7, math.NaN()}, 60, 0)
+	r.XFilesFactor = 0.5
+	r.SetValuesPerPoint(2)
+
+	wantAbsent := []bool{false, true, false, true}
+	if got := r.AggregatedAbsent(); !equalBools(got, wantAbsent) {
+		t.Errorf("absent = %v, want %v", got, wantAbsent)
+	}
+	if got := r.AggregatedValues(); got[0] != 1 || got[2] != 6 {
+		t.Errorf("values = %v, want 1 and 6 at 0 and 2", got)
+	}
+}
+
+func equalBools(a, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func TestRawResponse(t *testing.T) {
 
 	tests := []struct {
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// NullPolicy is what JSON and CSV responses do with null points.
+type NullPolicy struct {
+	// Drop leaves null points out, and series without any other point.
+	Drop bool
+	// Fill gives null points Value.
+	Fill  bool
+	Value float64
+}
+
+// KeepNulls returns null points as they are.
+var KeepNulls = NullPolicy{}
+
+// ParseNullPolicy parses "keep", "drop" or "fill=<value>". Empty is "keep".
+func ParseNullPolicy(s string) (NullPolicy, error) {
+	switch {
+	case s == "" || s == "keep":
+		return KeepNulls, nil
+	case s == "drop":
+		return NullPolicy{Drop: true}, nil
+	case strings.HasPrefix(s, "fill="):
+		v, err := strconv.ParseFloat(strings.TrimPrefix(s, "fill="), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return KeepNulls, fmt.Errorf("invalid fill value in null policy '%s'", s)
+		}
+		return NullPolicy{Fill: true, Value: v}, nil
+	}
+
+	return KeepNulls, fmt.Errorf("unknown null policy '%s'", s)
+}
+
+// IsNull tells if a point is null as graphite sees it: absent, or not a
+// number.
+func IsNull(v float64, absent bool) bool {
+	return absent || math.IsNaN(v)
+}
@@ -0,0 +1,86 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseNullPolicy(t *testing.T) {
+	tests := []struct {
+		in  string
+		out NullPolicy
+		err bool
+	}{
+		{"", KeepNulls, false},
+		{"keep", KeepNulls, false},
+		{"drop", NullPolicy{Drop: true}, false},
+		{"fill=0", NullPolicy{Fill: true}, false},
+		{"fill=-1.5", NullPolicy{Fill: true, Value: -1.5}, false},
+		{"fill=", KeepNulls, true},
+		{"fill=NaN", KeepNulls, true},
+		{"zero", KeepNulls, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseNullPolicy(tt.in)
+		if (err != nil) != tt.err || got != tt.out {
+			t.Errorf("%q: expected %+v (error %v), got %+v (%v)", tt.in, tt.out, tt.err, got, err)
+		}
+	}
+}
+
+func TestMarshalWithNulls(t *testing.T) {
+	// CSV has times in the local zone
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.UTC
+
+	results := []*MetricData{
+		MakeMetricData("foo", []float64{1, math.NaN(), 3}, 60, 0),
+		MakeMetricData("empty", []float64{math.NaN(), math.NaN(), math.NaN()}, 60, 0),
+	}
+	results[0].Values[1] = 0
+	results[0].IsAbsent[1] = true
+
+	tests := []struct {
+		nulls NullPolicy
+		json  string
+		csv   string
+	}{
+		{
+			KeepNulls,
+			`[{"target":"foo","datapoints":[[1,0],[null,60],[3,120]]},{"target":"empty","datapoints":[[null,0],[null,60],[null,120]]}]`,
+			"\"foo\",1970-01-01 00:00:00,1\n\"foo\",1970-01-01 00:01:00,\n\"foo\",1970-01-01 00:02:00,3\n" +
+				"\"empty\",1970-01-01 00:00:00,\n\"empty\",1970-01-01 00:01:00,\n\"empty\",1970-01-01 00:02:00,\n",
+		},
+		{
+			NullPolicy{Drop: true},
+			`[{"target":"foo","datapoints":[[1,0],[3,120]]}]`,
+			"\"foo\",1970-01-01 00:00:00,1\n\"foo\",1970-01-01 00:02:00,3\n",
+		},
+		{
+			NullPolicy{Fill: true, Value: -1},
+			`[{"target":"foo","datapoints":[[1,0],[-1,60],[3,120]]},{"target":"empty","datapoints":[[-1,0],[-1,60],[-1,120]]}]`,
+			"\"foo\",1970-01-01 00:00:00,1\n\"foo\",1970-01-01 00:01:00,-1\n\"foo\",1970-01-01 00:02:00,3\n" +
+				"\"empty\",1970-01-01 00:00:00,-1\n\"empty\",1970-01-01 00:01:00,-1\n\"empty\",1970-01-01 00:02:00,-1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := string(MarshalJSONWithNulls(results, false, tt.nulls)); got != tt.json {
+			t.Errorf("JSON with %+v:\nexpected %s\ngot      %s", tt.nulls, tt.json, got)
+		}
+		if got := string(MarshalCSVWithNulls(results, tt.nulls)); got != tt.csv {
+			t.Errorf("CSV with %+v:\nexpected %q\ngot      %q", tt.nulls, tt.csv, got)
+		}
+	}
+}
+
+func TestMarshalCSVQuotesNames(t *testing.T) {
+	results := []*MetricData{MakeMetricData(`say "hi", all`, []float64{1}, 60, 0)}
+
+	want := "\"say \"\"hi\"\", all\",1970-01-01 00:00:00,1\n"
+	if got := string(MarshalCSV(results)); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
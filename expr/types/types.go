@@ -5,8 +5,12 @@ import (
 	"errors"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/arrow"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/parquet"
+
 	pb "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	pickle "github.com/lomik/og-rek"
 )
@@ -28,6 +32,47 @@ type MetricData struct {
 	aggregatedValues  []float64
 	aggregatedAbsent  []bool
 	AggregateFunction func([]float64, []bool) (float64, bool)
+
+	// Backends are the stores the series was fetched from, and HealedPoints
+	// the number of absent points filled in from other stores when their
+	// responses were merged. Both are only known for fetched series.
+	Backends     []string
+	HealedPoints int
+
+	// Legacy tells the series has points fetched by the name its metric
+	// had before a rename.
+	Legacy bool
+
+	// Rollup is the prefix of the rollup the series was fetched from in
+	// place of its raw data.
+	Rollup string
+
+	// XFilesFactor is the fraction of the points of an interval that have
+	// to be present for it to get a value when the series is consolidated
+	// or aggregated, as in whisper.
+	XFilesFactor float32
+}
+
+// MeetsXFilesFactor reports whether nonNull of total points are enough for an
+// aggregated point to have a value. At least one point always has to be
+// present.
+func MeetsXFilesFactor(nonNull, total int, xFilesFactor float32) bool {
+	if nonNull == 0 || total == 0 {
+		return false
+	}
+
+	return float32(nonNull)/float32(total) >= xFilesFactor
+}
+
+func present(absent []bool) int {
+	n := 0
+	for _, a := range absent {
+		if !a {
+			n++
+		}
+	}
+
+	return n
 }
 
 // MakeMetricData creates new metrics data with given metric timeseries
@@ -56,6 +101,12 @@ func MakeMetricData(name string, values []float64, step, start int32) *MetricDat
 
 // MarshalCSV marshals metric data to CSV
 func MarshalCSV(results []*MetricData) []byte {
+	return MarshalCSVWithNulls(results, KeepNulls)
+}
+
+// MarshalCSVWithNulls marshals metric data to CSV, doing with null points
+// as nulls says.
+func MarshalCSVWithNulls(results []*MetricData, nulls NullPolicy) []byte {
 
 	var b []byte
 
@@ -64,14 +115,22 @@ func MarshalCSV(results []*MetricData) []byte {
 		step := r.StepTime
 		t := r.StartTime
 		for i, v := range r.Values {
+			null := IsNull(v, r.IsAbsent[i])
+			if null && nulls.Drop {
+				t += step
+				continue
+			}
+
 			b = append(b, '"')
-			b = append(b, r.Name...)
+			b = append(b, strings.Replace(r.Name, `"`, `""`, -1)...)
 			b = append(b, '"')
 			b = append(b, ',')
 			b = append(b, time.Unix(int64(t), 0).Format("2006-01-02 15:04:05")...)
 			b = append(b, ',')
-			if !r.IsAbsent[i] {
+			if !null {
 				b = strconv.AppendFloat(b, v, 'f', -1, 64)
+			} else if nulls.Fill {
+				b = strconv.AppendFloat(b, nulls.Value, 'f', -1, 64)
 			}
 			b = append(b, '\n')
 			t += step
@@ -113,6 +172,24 @@ func ConsolidateJSON(maxDataPoints int, results []*MetricData) {
 
 // MarshalJSON marshals metric data to JSON
 func MarshalJSON(results []*MetricData) []byte {
+	return marshalJSON(results, false, KeepNulls)
+}
+
+// MarshalJSONWithMeta marshals metric data to JSON, adding to each series a
+// "meta" object with the backends it came from, the points healed while
+// merging their responses, and its step after consolidation.
+func MarshalJSONWithMeta(results []*MetricData) []byte {
+	return marshalJSON(results, true, KeepNulls)
+}
+
+// MarshalJSONWithNulls is MarshalJSON, or MarshalJSONWithMeta if meta is
+// set, doing with null points as nulls says. Infinite values, which JSON
+// can't represent, count as null.
+func MarshalJSONWithNulls(results []*MetricData, meta bool, nulls NullPolicy) []byte {
+	return marshalJSON(results, meta, nulls)
+}
+
+func marshalJSON(results []*MetricData, meta bool, nulls NullPolicy) []byte {
 	var b []byte
 	b = append(b, '[')
 
@@ -122,6 +199,13 @@ func MarshalJSON(results []*MetricData) []byte {
 			continue
 		}
 
+		absent := r.AggregatedAbsent()
+		values := r.AggregatedValues()
+		if nulls.Drop && !hasJSONValue(values, absent) {
+			// as graphite-web does with noNullPoints
+			continue
+		}
+
 		if topComma {
 			b = append(b, ',')
 		}
@@ -133,8 +217,13 @@ func MarshalJSON(results []*MetricData) []byte {
 
 		var innerComma bool
 		t := r.StartTime
-		absent := r.AggregatedAbsent()
-		for i, v := range r.AggregatedValues() {
+		for i, v := range values {
+			null := absent[i] || math.IsInf(v, 0) || math.IsNaN(v)
+			if null && nulls.Drop {
+				t += r.AggregatedTimeStep()
+				continue
+			}
+
 			if innerComma {
 				b = append(b, ',')
 			}
@@ -142,10 +231,12 @@ func MarshalJSON(results []*MetricData) []byte {
 
 			b = append(b, '[')
 
-			if absent[i] || math.IsInf(v, 0) || math.IsNaN(v) {
-				b = append(b, "null"...)
-			} else {
+			if !null {
 				b = strconv.AppendFloat(b, v, 'f', -1, 64)
+			} else if nulls.Fill {
+				b = strconv.AppendFloat(b, nulls.Value, 'f', -1, 64)
+			} else {
+				b = append(b, "null"...)
 			}
 
 			b = append(b, ',')
@@ -157,7 +248,11 @@ func MarshalJSON(results []*MetricData) []byte {
 			t += r.AggregatedTimeStep()
 		}
 
-		b = append(b, `]}`...)
+		b = append(b, ']')
+		if meta {
+			b = appendJSONMeta(b, r)
+		}
+		b = append(b, '}')
 	}
 
 	b = append(b, ']')
@@ -165,6 +260,40 @@ func MarshalJSON(results []*MetricData) []byte {
 	return b
 }
 
+func hasJSONValue(values []float64, absent []bool) bool {
+	for i, v := range values {
+		if !absent[i] && !math.IsInf(v, 0) && !math.IsNaN(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendJSONMeta(b []byte, r *MetricData) []byte {
+	b = append(b, `,"meta":{"backends":[`...)
+	for i, backend := range r.Backends {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuoteToASCII(b, backend)
+	}
+	b = append(b, `],"healedPoints":`...)
+	b = strconv.AppendInt(b, int64(r.HealedPoints), 10)
+	b = append(b, `,"step":`...)
+	b = strconv.AppendInt(b, int64(r.AggregatedTimeStep()), 10)
+	if r.Legacy {
+		b = append(b, `,"legacy":true`...)
+	}
+	if r.Rollup != "" {
+		b = append(b, `,"rollup":`...)
+		b = strconv.AppendQuoteToASCII(b, r.Rollup)
+	}
+	b = append(b, '}')
+
+	return b
+}
+
 // MarshalPickle marshals metric data to pickle format
 func MarshalPickle(results []*MetricData) []byte {
 
@@ -197,6 +326,33 @@ func MarshalPickle(results []*MetricData) []byte {
 	return buf.Bytes()
 }
 
+// MarshalArrow marshals metric data to an Arrow IPC stream with a row per
+// point
+func MarshalArrow(results []*MetricData) []byte {
+	var buf bytes.Buffer
+
+	w := arrow.NewWriter(&buf)
+	for _, r := range results {
+		w.WriteSeries(r.Name, r.StartTime, r.StepTime, r.Values, r.IsAbsent)
+	}
+	w.Close()
+
+	return buf.Bytes()
+}
+
+// MarshalParquet marshals metric data to a Parquet file with a row per point
+func MarshalParquet(results []*MetricData) []byte {
+	var buf bytes.Buffer
+
+	w := parquet.NewWriter(&buf)
+	for _, r := range results {
+		w.WriteSeries(r.Name, r.StartTime, r.StepTime, r.Values, r.IsAbsent)
+	}
+	w.Close()
+
+	return buf.Bytes()
+}
+
 // MarshalProtobuf marshals metric data to protobuf
 func MarshalProtobuf(results []*MetricData) ([]byte, error) {
 	response := pb.MultiFetchResponse{}
@@ -301,6 +457,7 @@ func (r *MetricData) AggregateValues() {
 
 	for len(v) >= r.ValuesPerPoint {
 		val, abs := r.AggregateFunction(v[:r.ValuesPerPoint], absent[:r.ValuesPerPoint])
+		abs = abs || !MeetsXFilesFactor(present(absent[:r.ValuesPerPoint]), r.ValuesPerPoint, r.XFilesFactor)
 		aggV = append(aggV, val)
 		aggA = append(aggA, abs)
 		v = v[r.ValuesPerPoint:]
@@ -309,6 +466,7 @@ func (r *MetricData) AggregateValues() {
 
 	if len(v) > 0 {
 		val, abs := r.AggregateFunction(v, absent)
+		abs = abs || !MeetsXFilesFactor(present(absent), len(v), r.XFilesFactor)
 		aggV = append(aggV, val)
 		aggA = append(aggA, abs)
 	}
@@ -0,0 +1,156 @@
+#!/usr/bin/env python3
+"""Generates the fixtures of the zscore, madBasedOutlierDetection and
+seasonalDecompose functions.
+
+The expected outputs are computed as numpy and statsmodels compute them:
+numpy.mean, numpy.std (ddof=0) and numpy.median over the non-null values,
+and statsmodels.tsa.seasonal.seasonal_decompose(model="additive") with its
+centred moving average, extended here to nulls, which statsmodels rejects.
+It only needs the standard library, so that the fixtures can be regenerated
+anywhere:
+
+    python3 internal/anomalyFixtures/gen.py
+"""
+
+import json
+import math
+import os
+
+ROOT = os.path.join(os.path.dirname(os.path.abspath(__file__)), "..", "..")
+
+
+def mean(xs):
+    return sum(xs) / len(xs)
+
+
+def std(xs):
+    m = mean(xs)
+    return math.sqrt(sum((x - m) ** 2 for x in xs) / len(xs))
+
+
+def median(xs):
+    s = sorted(xs)
+    n = len(s)
+    if n % 2:
+        return s[n // 2]
+    return (s[n // 2 - 1] + s[n // 2]) / 2
+
+
+def zscore(values):
+    present = [v for v in values if v is not None]
+    if not present:
+        return [None] * len(values)
+    m, sd = mean(present), std(present)
+    if sd == 0:
+        return [None] * len(values)
+    return [None if v is None else (v - m) / sd for v in values]
+
+
+def mad_outliers(values, threshold):
+    present = [v for v in values if v is not None]
+    if not present:
+        return [None] * len(values)
+    med = median(present)
+    mad = median([abs(v - med) for v in present])
+    out = []
+    for v in values:
+        if v is None or v == med:
+            out.append(None)
+        elif mad == 0 or abs(0.6745 * (v - med) / mad) > threshold:
+            out.append(v)
+        else:
+            out.append(None)
+    return out
+
+
+def decompose(values, period):
+    n = len(values)
+    if n < 2 * period:
+        nulls = [None] * n
+        return {"trend": nulls, "seasonal": nulls, "resid": nulls}
+
+    if period % 2:
+        filt = [1.0 / period] * period
+    else:
+        filt = [0.5 / period] + [1.0 / period] * (period - 1) + [0.5 / period]
+    half = len(filt) // 2
+
+    trend = []
+    for i in range(n):
+        lo, hi = i - half, i - half + len(filt)
+        if lo < 0 or hi > n or any(v is None for v in values[lo:hi]):
+            trend.append(None)
+            continue
+        trend.append(sum(f * v for f, v in zip(filt, values[lo:hi])))
+
+    detrended = [None if v is None or t is None else v - t for v, t in zip(values, trend)]
+    averages = []
+    for j in range(period):
+        phase = [d for d in detrended[j::period] if d is not None]
+        averages.append(mean(phase) if phase else None)
+    known = [a for a in averages if a is not None]
+    centre = mean(known) if known else 0
+    averages = [None if a is None else a - centre for a in averages]
+
+    seasonal = [averages[i % period] for i in range(n)]
+    resid = [
+        None if v is None or t is None or s is None else v - t - s
+        for v, t, s in zip(values, trend, seasonal)
+    ]
+    return {"trend": trend, "seasonal": seasonal, "resid": resid}
+
+
+SPIKY = [10, 11, 9, 10, 12, None, 10, 11, 48, 10, 9, None, 11, 10, -20, 10]
+FLAT = [5, 5, 5, None, 5]
+SEASONAL = [
+    round(10 + 5 * math.sin(2 * math.pi * i / 6) + 0.3 * i + (0.7 if i % 5 == 0 else -0.2), 6)
+    for i in range(30)
+]
+SEASONAL_NULLS = [None if i in (4, 17) else v for i, v in enumerate(SEASONAL)]
+
+
+def write(function, cases):
+    path = os.path.join(ROOT, "expr", "functions", function, "testdata", "fixtures.json")
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, "w") as f:
+        json.dump(cases, f, indent=1)
+        f.write("\n")
+
+
+def main():
+    write("zscore", [
+        {"name": "spiky", "input": SPIKY, "output": zscore(SPIKY)},
+        {"name": "flat", "input": FLAT, "output": zscore(FLAT)},
+        {"name": "single", "input": [None, 3, None], "output": zscore([None, 3, None])},
+        {"name": "empty", "input": [None, None], "output": zscore([None, None])},
+        {"name": "seasonal", "input": SEASONAL, "output": zscore(SEASONAL)},
+    ])
+
+    write("madBasedOutlierDetection", [
+        {"name": "spiky", "threshold": 3.5, "input": SPIKY, "output": mad_outliers(SPIKY, 3.5)},
+        {"name": "spiky_loose", "threshold": 10, "input": SPIKY, "output": mad_outliers(SPIKY, 10)},
+        {"name": "flat", "threshold": 3.5, "input": FLAT + [7], "output": mad_outliers(FLAT + [7], 3.5)},
+        {"name": "single", "threshold": 3.5, "input": [None, 3], "output": mad_outliers([None, 3], 3.5)},
+        {"name": "seasonal", "threshold": 1.2, "input": SEASONAL, "output": mad_outliers(SEASONAL, 1.2)},
+    ])
+
+    cases = []
+    for name, values, period in [
+        ("seasonal_even", SEASONAL, 6),
+        ("seasonal_odd", SEASONAL, 5),
+        ("seasonal_nulls", SEASONAL_NULLS, 6),
+        ("short", SEASONAL[:11], 6),
+    ]:
+        for component, output in sorted(decompose(values, period).items()):
+            cases.append({
+                "name": name + "_" + component,
+                "period": period,
+                "component": component,
+                "input": values,
+                "output": output,
+            })
+    write("seasonalDecompose", cases)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrOverBudget is returned when a reservation can't fit in the budget.
+var ErrOverBudget = errors.New("memory budget exceeded")
+
+// MemoryLimiter bounds the bytes held by in-flight requests. Each request
+// reserves its estimated size before doing any work and adjusts the
+// reservation once the actual size is known. Requests that don't fit wait
+// until enough is released.
+type MemoryLimiter struct {
+	mu     sync.Mutex
+	budget int64
+	used   int64
+	// freed is closed, and replaced, whenever memory is released.
+	freed chan struct{}
+}
+
+// NewMemoryLimiter creates a limiter for budget bytes. A budget of 0 or less
+// disables limiting.
+func NewMemoryLimiter(budget int64) *MemoryLimiter {
+	return &MemoryLimiter{
+		budget: budget,
+		freed:  make(chan struct{}),
+	}
+}
+
+// Reservation is the memory held by one request.
+type Reservation struct {
+	l     *MemoryLimiter
+	bytes int64
+}
+
+// Reserve claims n bytes, waiting for memory to be released while the budget
+// is exhausted. It fails with ErrOverBudget if n is larger than the whole
+// budget, or with the context error if ctx is done first.
+func (l *MemoryLimiter) Reserve(ctx context.Context, n int64) (*Reservation, error) {
+	if l.budget <= 0 {
+		return &Reservation{bytes: n}, nil
+	}
+	if n > l.budget {
+		return nil, ErrOverBudget
+	}
+
+	for {
+		l.mu.Lock()
+		if l.used+n <= l.budget {
+			l.used += n
+			l.mu.Unlock()
+			return &Reservation{l: l, bytes: n}, nil
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Adjust replaces the reservation by the actual size of the request, which
+// may take the limiter over budget; later requests then wait.
+func (r *Reservation) Adjust(n int64) {
+	shrunk := n < r.bytes
+	if r.l == nil {
+		r.bytes = n
+		return
+	}
+
+	r.l.mu.Lock()
+	r.l.used += n - r.bytes
+	r.bytes = n
+	r.l.mu.Unlock()
+
+	if shrunk {
+		r.l.wake()
+	}
+}
+
+// Bytes is the size of the reservation.
+func (r *Reservation) Bytes() int64 {
+	return r.bytes
+}
+
+// Release gives the reserved memory back.
+func (r *Reservation) Release() {
+	if r.l == nil {
+		return
+	}
+
+	r.l.mu.Lock()
+	r.l.used -= r.bytes
+	r.bytes = 0
+	r.l.mu.Unlock()
+
+	r.l.wake()
+}
+
+func (l *MemoryLimiter) wake() {
+	l.mu.Lock()
+	close(l.freed)
+	l.freed = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// Used returns how many bytes are reserved.
+func (l *MemoryLimiter) Used() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.used
+}
@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	l := NewMemoryLimiter(100)
+	ctx := context.Background()
+
+	if _, err := l.Reserve(ctx, 101); err != ErrOverBudget {
+		t.Errorf("expected ErrOverBudget for a reservation larger than the budget, got %v", err)
+	}
+
+	r1, err := l.Reserve(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Reserve(short, 50); err != context.DeadlineExceeded {
+		t.Errorf("expected a reservation over budget to time out, got %v", err)
+	}
+
+	reserved := make(chan *Reservation)
+	go func() {
+		r, err := l.Reserve(ctx, 50)
+		if err != nil {
+			t.Error(err)
+		}
+		reserved <- r
+	}()
+
+	r1.Adjust(40)
+	r2 := <-reserved
+	if used := l.Used(); used != 90 {
+		t.Errorf("expected 90 bytes used, got %d", used)
+	}
+
+	r1.Release()
+	r2.Release()
+	if used := l.Used(); used != 0 {
+		t.Errorf("expected nothing used after release, got %d", used)
+	}
+}
+
+func TestMemoryLimiterDisabled(t *testing.T) {
+	l := NewMemoryLimiter(0)
+
+	r, err := l.Reserve(context.Background(), 1<<40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Adjust(10)
+	if r.Bytes() != 10 {
+		t.Errorf("expected the reservation to track its size, got %d", r.Bytes())
+	}
+	r.Release()
+}
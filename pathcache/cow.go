@@ -0,0 +1,146 @@
+package pathcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minPending is how many sets a cowStore takes before publishing them.
+const minPending = 64
+
+// cowStore reads its keys, without locks, from a map that is never
+// modified. Sets are kept aside, where reads of keys not in the map, or
+// expired in it, look too, until there are enough of them, an eighth of the
+// keys, to publish a copy of the map with them. Each key is then copied a
+// handful of times over its life, which suits caches that are read much
+// more than set. Until keys replaced before they expire, which the caches
+// of paths rarely do, are published, all reads look aside.
+type cowStore struct {
+	read atomic.Value // map[string]entry
+	// replaced is 1 while keys of read are replaced by pending ones.
+	replaced int32
+
+	mu      sync.Mutex
+	pending map[string]entry
+	// published is the size of the keys of read, kept with mu held.
+	published uint64
+}
+
+func newCOWStore() *cowStore {
+	s := &cowStore{pending: make(map[string]entry)}
+	s.read.Store(make(map[string]entry))
+
+	go s.clean(time.Minute)
+
+	return s
+}
+
+func (s *cowStore) get(k string) ([]string, bool) {
+	now := time.Now().Unix()
+
+	e, ok := s.read.Load().(map[string]entry)[k]
+	if !ok || e.expires <= now || atomic.LoadInt32(&s.replaced) == 1 {
+		s.mu.Lock()
+		if p, pok := s.pending[k]; pok {
+			e, ok = p, true
+		}
+		s.mu.Unlock()
+	}
+
+	if !ok || e.expires <= now {
+		return nil, false
+	}
+
+	return e.v, true
+}
+
+func (s *cowStore) set(k string, v []string, size uint64, expire int32) {
+	now := time.Now().Unix()
+	e := entry{v: v, size: size, expires: now + int64(expire)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[k] = e
+	read := s.read.Load().(map[string]entry)
+	if old, ok := read[k]; ok && old.expires > now {
+		atomic.StoreInt32(&s.replaced, 1)
+	}
+	if len(s.pending) >= minPending && len(s.pending) >= len(read)/8 {
+		s.publish(now)
+	}
+}
+
+// publish replaces the read map with a copy without the expired keys and
+// with the pending ones. Call with s.mu held.
+func (s *cowStore) publish(now int64) {
+	read := s.read.Load().(map[string]entry)
+
+	next := make(map[string]entry, len(read)+len(s.pending))
+	var size uint64
+	for k, e := range read {
+		if _, ok := s.pending[k]; !ok && e.expires > now {
+			next[k] = e
+			size += e.size
+		}
+	}
+	for k, e := range s.pending {
+		if e.expires > now {
+			next[k] = e
+			size += e.size
+		}
+	}
+
+	s.read.Store(next)
+	s.pending = make(map[string]entry)
+	s.published = size
+	atomic.StoreInt32(&s.replaced, 0)
+}
+
+func (s *cowStore) items() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	read := s.read.Load().(map[string]entry)
+	n := len(read)
+	for k := range s.pending {
+		if _, ok := read[k]; !ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *cowStore) size() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := s.published
+	for _, e := range s.pending {
+		size += e.size
+	}
+
+	return size
+}
+
+func (s *cowStore) each(f func(k string, v []string, expires int64)) {
+	s.mu.Lock()
+	s.publish(time.Now().Unix())
+	read := s.read.Load().(map[string]entry)
+	s.mu.Unlock()
+
+	for k, e := range read {
+		f(k, e.v, e.expires)
+	}
+}
+
+// clean publishes every d, which drops the expired keys.
+func (s *cowStore) clean(d time.Duration) {
+	for range time.Tick(d) {
+		s.mu.Lock()
+		s.publish(time.Now().Unix())
+		s.mu.Unlock()
+	}
+}
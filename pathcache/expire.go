@@ -0,0 +1,82 @@
+package pathcache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dgryski/go-expirecache"
+)
+
+// expireStore keeps the keys in an expirecache.
+type expireStore struct {
+	ec *expirecache.Cache
+
+	// expiries are when the keys of ec expire, which it doesn't tell, for
+	// each.
+	mu       sync.Mutex
+	expiries map[string]int64
+}
+
+func newExpireStore() *expireStore {
+	s := &expireStore{
+		ec:       expirecache.New(0),
+		expiries: make(map[string]int64),
+	}
+
+	go s.ec.ApproximateCleaner(10 * time.Second)
+	go s.cleanExpiries(time.Minute)
+
+	return s
+}
+
+func (s *expireStore) get(k string) ([]string, bool) {
+	if v, ok := s.ec.Get(k); ok {
+		return v.([]string), true
+	}
+
+	return nil, false
+}
+
+func (s *expireStore) set(k string, v []string, size uint64, expire int32) {
+	s.ec.Set(k, v, size, expire)
+
+	s.mu.Lock()
+	s.expiries[k] = time.Now().Unix() + int64(expire)
+	s.mu.Unlock()
+}
+
+func (s *expireStore) items() int {
+	return s.ec.Items()
+}
+
+func (s *expireStore) size() uint64 {
+	return s.ec.Size()
+}
+
+func (s *expireStore) each(f func(k string, v []string, expires int64)) {
+	s.mu.Lock()
+	expiries := make(map[string]int64, len(s.expiries))
+	for k, expires := range s.expiries {
+		expiries[k] = expires
+	}
+	s.mu.Unlock()
+
+	for k, expires := range expiries {
+		if v, ok := s.get(k); ok {
+			f(k, v, expires)
+		}
+	}
+}
+
+func (s *expireStore) cleanExpiries(d time.Duration) {
+	for range time.Tick(d) {
+		now := time.Now().Unix()
+		s.mu.Lock()
+		for k, expires := range s.expiries {
+			if expires <= now {
+				delete(s.expiries, k)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
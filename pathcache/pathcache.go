@@ -1,57 +1,252 @@
 package pathcache
 
 import (
-	"github.com/dgryski/go-expirecache"
-
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/intern"
+)
+
+// The kinds of PathCache New makes.
+const (
+	// KindExpireCache keeps the keys in an expirecache, behind a single
+	// lock.
+	KindExpireCache = "expirecache"
+	// KindSharded spreads the keys over locked shards, for caches set and
+	// read concurrently.
+	KindSharded = "sharded"
+	// KindCopyOnWrite reads the keys without locks from a map that writes
+	// replace, for caches read far more than set.
+	KindCopyOnWrite = "cow"
 )
 
 // PathCache provides general interface to cache find and search queries
-type PathCache struct {
-	ec *expirecache.Cache
+type PathCache interface {
+	// Get returns an an element by key. If not successful - returns also
+	// false in second var.
+	Get(k string) ([]string, bool)
+	// Set allows to set a key (k) to value (v).
+	Set(k string, v []string)
+	// Owners returns the servers of path, or else of its longest cached
+	// prefix of at most Depth segments. Prefixes end before the first
+	// segment that is a glob. It returns false if none is cached.
+	Owners(path string) ([]string, bool)
+	// Depth returns how many leading segments of a path Owners looks up.
+	Depth() int
+	// ECItems returns amount of items in the cache
+	ECItems() int
+	// ECSize returns size of the cache
+	ECSize() uint64
+
+	// Snapshot writes the keys that haven't expired to w, for Restore to
+	// load them in another process.
+	Snapshot(w io.Writer) error
+	// Restore loads a snapshot written by Snapshot. Its keys expire when
+	// they would have in the process that wrote it.
+	Restore(r io.Reader) error
+	// Save writes a snapshot to the file path. It is written aside and
+	// renamed, so that a process stopped halfway leaves the previous one
+	// in place.
+	Save(path string) error
+	// Load restores the snapshot in the file path, if there is one.
+	Load(path string) error
+}
+
+// store is where a cache keeps its keys.
+type store interface {
+	get(k string) ([]string, bool)
+	// set keeps v for k for expire seconds.
+	set(k string, v []string, size uint64, expire int32)
+	items() int
+	size() uint64
+	// each calls f with the keys that haven't expired, and when they
+	// expire.
+	each(f func(k string, v []string, expires int64))
+}
+
+// cache implements PathCache over a store.
+type cache struct {
+	store
 
 	expireDelaySec int32
+	depth          int
+	// names interns the paths and servers set, which the responses they
+	// are set from allocate anew every time
+	names *intern.Pool
 }
 
 // NewPathCache initializes PathCache structure
 func NewPathCache(ExpireDelaySec int32) PathCache {
+	return NewPathCacheDepth(ExpireDelaySec, 1)
+}
 
-	p := PathCache{
-		ec:             expirecache.New(0),
-		expireDelaySec: ExpireDelaySec,
+// NewPathCacheDepth initializes a PathCache whose Owners look paths up by up
+// to depth leading segments.
+func NewPathCacheDepth(ExpireDelaySec int32, depth int) PathCache {
+	return newCache(newExpireStore(), ExpireDelaySec, depth)
+}
+
+// New returns a PathCache of kind, one of the Kind constants, or of
+// KindExpireCache if kind is empty. The paths and servers it is set are
+// interned in names, if it isn't nil.
+func New(kind string, expireDelaySec int32, depth int, names *intern.Pool) (PathCache, error) {
+	var c *cache
+	switch kind {
+	case "", KindExpireCache:
+		c = newCache(newExpireStore(), expireDelaySec, depth)
+	case KindSharded:
+		c = newCache(newShardedStore(), expireDelaySec, depth)
+	case KindCopyOnWrite:
+		c = newCache(newCOWStore(), expireDelaySec, depth)
+	default:
+		return nil, fmt.Errorf("unknown path cache kind '%s'", kind)
 	}
+	c.names = names
 
-	go p.ec.ApproximateCleaner(10 * time.Second)
+	return c, nil
+}
 
-	return p
+func newCache(s store, expireDelaySec int32, depth int) *cache {
+	if depth < 1 {
+		depth = 1
+	}
+
+	return &cache{
+		store:          s,
+		expireDelaySec: expireDelaySec,
+		depth:          depth,
+	}
 }
 
-// ECItems returns amount of items in the cache
-func (p *PathCache) ECItems() int {
-	return p.ec.Items()
+func (p *cache) Depth() int {
+	return p.depth
 }
 
-// ECSize returns size of the cache
-func (p *PathCache) ECSize() uint64 {
-	return p.ec.Size()
+func (p *cache) ECItems() int {
+	return p.items()
 }
 
-// Set allows to set a key (k) to value (v).
-func (p *PathCache) Set(k string, v []string) {
+func (p *cache) ECSize() uint64 {
+	return p.size()
+}
+
+func (p *cache) Set(k string, v []string) {
+	p.names.Strings(v)
+	p.set(p.names.String(k), v, valueSize(v), p.expireDelaySec)
+}
 
+func valueSize(v []string) uint64 {
 	var size uint64
 	for _, vv := range v {
 		size += uint64(len(vv))
 	}
 
-	p.ec.Set(k, v, size, p.expireDelaySec)
+	return size
 }
 
-// Get returns an an element by key. If not successful - returns also false in second var.
-func (p *PathCache) Get(k string) ([]string, bool) {
-	if v, ok := p.ec.Get(k); ok {
-		return v.([]string), true
+func (p *cache) Get(k string) ([]string, bool) {
+	return p.get(k)
+}
+
+func (p *cache) Owners(path string) ([]string, bool) {
+	if v, ok := p.Get(path); ok && len(v) > 0 {
+		return v, true
+	}
+
+	segments := strings.Split(path, ".")
+	n := len(segments) - 1
+	if n > p.depth {
+		n = p.depth
+	}
+	for i, s := range segments[:n] {
+		if strings.ContainsAny(s, "*?[]{}") {
+			n = i
+			break
+		}
+	}
+
+	for ; n > 0; n-- {
+		if v, ok := p.Get(strings.Join(segments[:n], ".")); ok && len(v) > 0 {
+			return v, true
+		}
 	}
 
 	return nil, false
 }
+
+// snapshotEntry is a key of a snapshot, with its servers and when it
+// expires.
+type snapshotEntry struct {
+	Key     string   `json:"key"`
+	Servers []string `json:"servers"`
+	Expires int64    `json:"expires"`
+}
+
+func (p *cache) Snapshot(w io.Writer) error {
+	now := time.Now().Unix()
+	entries := make([]snapshotEntry, 0, p.items())
+	p.each(func(k string, v []string, expires int64) {
+		if expires > now {
+			entries = append(entries, snapshotEntry{Key: k, Servers: v, Expires: expires})
+		}
+	})
+
+	return json.NewEncoder(w).Encode(entries)
+}
+
+func (p *cache) Save(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	err = p.Snapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+func (p *cache) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.Restore(f)
+}
+
+func (p *cache) Restore(r io.Reader) error {
+	var entries []snapshotEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, e := range entries {
+		if e.Expires <= now {
+			continue
+		}
+		p.names.Strings(e.Servers)
+		p.set(p.names.String(e.Key), e.Servers, valueSize(e.Servers), int32(e.Expires-now))
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package pathcache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/bookingcom/carbonapi/pkg/intern"
+)
+
+func TestOwners(t *testing.T) {
+	p := NewPathCacheDepth(60, 2)
+	p.Set("servers", []string{"a", "b", "c"})
+	p.Set("servers.web01", []string{"a"})
+	p.Set("servers.web01.cpu", []string{"b"})
+	p.Set("servers.web02.cpu.user", []string{"c"})
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"servers.web02.cpu.user", []string{"c"}},
+		{"servers.web01.cpu.user", []string{"a"}}, // deeper than the depth
+		{"servers.web01.*", []string{"a"}},
+		{"servers.web0*.cpu", []string{"a", "b", "c"}},
+		{"servers.web03.cpu", []string{"a", "b", "c"}},
+		{"*.web01.cpu", nil},
+		{"other.web01", nil},
+	}
+
+	for _, tt := range tests {
+		got, ok := p.Owners(tt.path)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Owners(%q) = %v, %v, want %v", tt.path, got, ok, tt.want)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	p := NewPathCache(60)
+	p.Set("servers", []string{"a", "b"})
+	p.Set("other", []string{"c"})
+
+	var buf bytes.Buffer
+	if err := p.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewPathCache(60)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.ECItems() != 2 {
+		t.Errorf("Expected 2 restored keys, got %d", restored.ECItems())
+	}
+	if got, ok := restored.Get("servers"); !ok || !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Expected the servers of a restored key, got %v, %v", got, ok)
+	}
+
+	expired := NewPathCache(60)
+	if err := expired.Restore(bytes.NewBufferString(`[{"key":"servers","servers":["a"],"expires":1}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := expired.Get("servers"); ok {
+		t.Error("Expected expired keys not to be restored")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pathcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pathcache.json")
+
+	p := NewPathCache(60)
+	if err := p.Load(path); err != nil {
+		t.Errorf("Expected no snapshot to load nothing, got %v", err)
+	}
+
+	p.Set("servers", []string{"a"})
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewPathCache(60)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := loaded.Get("servers"); !ok || !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("Expected the saved key, got %v, %v", got, ok)
+	}
+}
+
+var kinds = []string{KindExpireCache, KindSharded, KindCopyOnWrite}
+
+func TestKinds(t *testing.T) {
+	for _, kind := range kinds {
+		t.Run(kind, func(t *testing.T) {
+			p, err := New(kind, 60, 2, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Enough keys for the copy-on-write store to publish some.
+			for i := 0; i < 200; i++ {
+				p.Set(fmt.Sprintf("servers.web%03d", i), []string{"a"})
+			}
+			p.Set("servers", []string{"b"})
+			p.Set("servers.web000", []string{"c"})
+
+			if got, ok := p.Get("servers.web000"); !ok || !reflect.DeepEqual(got, []string{"c"}) {
+				t.Errorf("Expected the last set value, got %v, %v", got, ok)
+			}
+			if got, ok := p.Owners("servers.web999.cpu"); !ok || !reflect.DeepEqual(got, []string{"b"}) {
+				t.Errorf("Expected the owners of the prefix, got %v, %v", got, ok)
+			}
+			if _, ok := p.Get("other"); ok {
+				t.Error("Expected a miss for a key never set")
+			}
+			if n := p.ECItems(); n != 201 {
+				t.Errorf("Expected 201 items, got %d", n)
+			}
+
+			var buf bytes.Buffer
+			if err := p.Snapshot(&buf); err != nil {
+				t.Fatal(err)
+			}
+			restored, _ := New(kind, 60, 2, nil)
+			if err := restored.Restore(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if n := restored.ECItems(); n != 201 {
+				t.Errorf("Expected 201 restored items, got %d", n)
+			}
+		})
+	}
+
+	if _, err := New("other", 60, 1, nil); err == nil {
+		t.Error("Expected an error for an unknown kind")
+	}
+}
+
+func TestInterned(t *testing.T) {
+	names := intern.New()
+	for _, kind := range kinds {
+		t.Run(kind, func(t *testing.T) {
+			p, _ := New(kind, 60, 1, names)
+
+			// servers as separately decoded responses name them
+			p.Set("servers.web01", []string{strings.Repeat("a", 2)})
+			p.Set("servers.web02", []string{strings.Repeat("a", 2)})
+
+			a, _ := p.Get("servers.web01")
+			b, _ := p.Get("servers.web02")
+			if unsafe.StringData(a[0]) != unsafe.StringData(b[0]) {
+				t.Error("Expected the servers to share one allocation")
+			}
+		})
+	}
+
+	if n := names.Len(); n != 3 {
+		t.Errorf("Expected the keys and the server to be interned, got %d names", n)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, kind := range []string{KindSharded, KindCopyOnWrite} {
+		t.Run(kind, func(t *testing.T) {
+			p, _ := New(kind, 0, 1, nil)
+			p.Set("servers", []string{"a"})
+			if _, ok := p.Get("servers"); ok {
+				t.Error("Expected expired keys to be missed")
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, kind := range kinds {
+		b.Run(kind, func(b *testing.B) {
+			p, _ := New(kind, 600, 1, nil)
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("servers.web%05d", i)
+				p.Set(keys[i], []string{"a"})
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					p.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMixed sets one key for every 10 it gets, as a zipper answering
+// finds does.
+func BenchmarkMixed(b *testing.B) {
+	for _, kind := range kinds {
+		b.Run(kind, func(b *testing.B) {
+			p, _ := New(kind, 600, 1, nil)
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("servers.web%05d", i)
+				p.Set(keys[i], []string{"a"})
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					k := keys[i%len(keys)]
+					if i%10 == 0 {
+						p.Set(k, []string{"a"})
+					} else {
+						p.Get(k)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
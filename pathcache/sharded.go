@@ -0,0 +1,129 @@
+package pathcache
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// shards is how many locks a shardedStore spreads its keys over.
+const shards = 64
+
+// entry is a key of the stores that keep their own maps.
+type entry struct {
+	v       []string
+	size    uint64
+	expires int64
+}
+
+// shardedStore spreads its keys over shards, each behind its own lock, so
+// that concurrent requests rarely wait for each other.
+type shardedStore struct {
+	shards [shards]shard
+}
+
+type shard struct {
+	sync.RWMutex
+	entries map[string]entry
+	size    uint64
+}
+
+func newShardedStore() *shardedStore {
+	s := &shardedStore{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]entry)
+	}
+
+	go s.clean(10 * time.Second)
+
+	return s
+}
+
+func (s *shardedStore) shard(k string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(k))
+
+	return &s.shards[h.Sum32()%shards]
+}
+
+func (s *shardedStore) get(k string) ([]string, bool) {
+	sh := s.shard(k)
+	sh.RLock()
+	e, ok := sh.entries[k]
+	sh.RUnlock()
+
+	if !ok || e.expires <= time.Now().Unix() {
+		return nil, false
+	}
+
+	return e.v, true
+}
+
+func (s *shardedStore) set(k string, v []string, size uint64, expire int32) {
+	e := entry{v: v, size: size, expires: time.Now().Unix() + int64(expire)}
+
+	sh := s.shard(k)
+	sh.Lock()
+	if old, ok := sh.entries[k]; ok {
+		sh.size -= old.size
+	}
+	sh.entries[k] = e
+	sh.size += size
+	sh.Unlock()
+}
+
+func (s *shardedStore) items() int {
+	var n int
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.RLock()
+		n += len(sh.entries)
+		sh.RUnlock()
+	}
+
+	return n
+}
+
+func (s *shardedStore) size() uint64 {
+	var size uint64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.RLock()
+		size += sh.size
+		sh.RUnlock()
+	}
+
+	return size
+}
+
+func (s *shardedStore) each(f func(k string, v []string, expires int64)) {
+	now := time.Now().Unix()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.RLock()
+		for k, e := range sh.entries {
+			if e.expires > now {
+				f(k, e.v, e.expires)
+			}
+		}
+		sh.RUnlock()
+	}
+}
+
+// clean removes the expired keys every d, a shard at a time.
+func (s *shardedStore) clean(d time.Duration) {
+	for range time.Tick(d) {
+		now := time.Now().Unix()
+		for i := range s.shards {
+			sh := &s.shards[i]
+			sh.Lock()
+			for k, e := range sh.entries {
+				if e.expires <= now {
+					sh.size -= e.size
+					delete(sh.entries, k)
+				}
+			}
+			sh.Unlock()
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Package acl restricts the metric paths requests may read. Roles allow and deny
+metric globs, and users are given roles by name.
+
+A glob covers the paths it matches and everything below them, so
+"servers.team-a.*" covers "servers.team-a.web1.cpu.user". A path denied by any
+role of the user is denied, otherwise it is allowed if one of the roles allows
+it. Paths no role mentions are allowed unless DenyByDefault is set.
+
+Example use:
+
+	a, err := New(Config{
+		DenyByDefault: true,
+		Roles:         map[string]Role{"team-a": {Allow: []string{"servers.team-a.*"}}},
+		Users:         map[string][]string{"alice": {"team-a"}},
+	})
+	p := a.Principal("alice")
+	ok := a.Allowed(p, "servers.team-a.web1.cpu")
+*/
+package acl
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDenied is returned for requests of paths the user may not read.
+var ErrDenied = errors.New("access denied")
+
+// Config configures the access control. No roles disables it.
+type Config struct {
+	// DenyByDefault denies the paths no role of the user allows.
+	DenyByDefault bool            `yaml:"denyByDefault"`
+	Roles         map[string]Role `yaml:"roles"`
+	// Users gives users, by their verified name, their roles.
+	Users map[string][]string `yaml:"users"`
+	// DefaultRoles are the roles of users not in Users, anonymous ones
+	// included.
+	DefaultRoles []string `yaml:"defaultRoles"`
+}
+
+// Role allows and denies metric globs.
+type Role struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// ACL decides which paths users may read.
+type ACL struct {
+	denyByDefault bool
+	roles         map[string]role
+	users         map[string][]string
+	defaultRoles  []string
+}
+
+type role struct {
+	allow []rule
+	deny  []rule
+}
+
+// rule is a glob split in nodes, each node the alternatives of its braces.
+type rule [][]string
+
+// New checks cfg and compiles its roles. It returns a nil ACL if no roles
+// are configured.
+func New(cfg Config) (*ACL, error) {
+	if len(cfg.Roles) == 0 {
+		return nil, nil
+	}
+
+	a := &ACL{
+		denyByDefault: cfg.DenyByDefault,
+		roles:         make(map[string]role, len(cfg.Roles)),
+		users:         cfg.Users,
+		defaultRoles:  cfg.DefaultRoles,
+	}
+	for name, r := range cfg.Roles {
+		var compiled role
+		var err error
+		if compiled.allow, err = compile(r.Allow); err != nil {
+			return nil, fmt.Errorf("role %q: %v", name, err)
+		}
+		if compiled.deny, err = compile(r.Deny); err != nil {
+			return nil, fmt.Errorf("role %q: %v", name, err)
+		}
+		a.roles[name] = compiled
+	}
+
+	for user, roles := range cfg.Users {
+		for _, r := range roles {
+			if _, ok := a.roles[r]; !ok {
+				return nil, fmt.Errorf("user %q: unknown role %q", user, r)
+			}
+		}
+	}
+	for _, r := range cfg.DefaultRoles {
+		if _, ok := a.roles[r]; !ok {
+			return nil, fmt.Errorf("default roles: unknown role %q", r)
+		}
+	}
+
+	return a, nil
+}
+
+func compile(globs []string) ([]rule, error) {
+	rules := make([]rule, 0, len(globs))
+	for _, g := range globs {
+		if g == "" {
+			return nil, errors.New("empty glob")
+		}
+
+		nodes := strings.Split(g, ".")
+		r := make(rule, len(nodes))
+		for i, n := range nodes {
+			r[i] = expandBraces(n)
+			for _, alt := range r[i] {
+				if _, err := path.Match(alt, ""); err != nil {
+					return nil, fmt.Errorf("bad glob %q", g)
+				}
+			}
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// expandBraces expands the alternatives in braces of a glob node, e.g.
+// "{cpu,mem}_*" to "cpu_*" and "mem_*".
+func expandBraces(node string) []string {
+	open := strings.IndexByte(node, '{')
+	if open < 0 {
+		return []string{node}
+	}
+	end := strings.IndexByte(node[open:], '}')
+	if end < 0 {
+		return []string{node}
+	}
+	end += open
+
+	var expanded []string
+	for _, alt := range strings.Split(node[open+1:end], ",") {
+		expanded = append(expanded, expandBraces(node[:open]+alt+node[end+1:])...)
+	}
+
+	return expanded
+}
+
+// matches tells if nodes match the first len(nodes) nodes of r.
+func (r rule) matches(nodes []string) bool {
+	for i, n := range nodes {
+		if i == len(r) {
+			return true
+		}
+
+		ok := false
+		for _, alt := range r[i] {
+			if m, _ := path.Match(alt, n); m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Principal is a user and the roles it has.
+type Principal struct {
+	User  string
+	Roles []string
+}
+
+// Principal returns the user with its roles.
+func (a *ACL) Principal(user string) Principal {
+	roles, ok := a.users[user]
+	if !ok {
+		roles = a.defaultRoles
+	}
+
+	sorted := append([]string(nil), roles...)
+	sort.Strings(sorted)
+
+	return Principal{User: user, Roles: sorted}
+}
+
+// Allowed tells if p may read the metric and everything below it.
+func (a *ACL) Allowed(p Principal, metric string) bool {
+	return a.decide(p, strings.Split(metric, "."), false)
+}
+
+// Visible tells if p may see the branch path in find results, which it may
+// if it may read the branch or something below it.
+func (a *ACL) Visible(p Principal, branch string) bool {
+	return a.decide(p, strings.Split(branch, "."), true)
+}
+
+func (a *ACL) decide(p Principal, nodes []string, branch bool) bool {
+	allowed := false
+	for _, name := range p.Roles {
+		r := a.roles[name]
+		for _, d := range r.deny {
+			if len(nodes) >= len(d) && d.matches(nodes) {
+				return false
+			}
+		}
+		for _, al := range r.allow {
+			if (branch || len(nodes) >= len(al)) && al.matches(nodes) {
+				allowed = true
+			}
+		}
+	}
+
+	return allowed || !a.denyByDefault
+}
+
+type key int
+
+const principalKey key = 0
+
+// NewContext returns a context that carries the principal.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext returns the principal ctx carries.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
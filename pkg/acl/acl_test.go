@@ -0,0 +1,120 @@
+package acl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func testACL(t *testing.T, denyByDefault bool) *ACL {
+	a, err := New(Config{
+		DenyByDefault: denyByDefault,
+		Roles: map[string]Role{
+			"team-a": {
+				Allow: []string{"servers.team-a.*", "apps.{api,web}_*"},
+				Deny:  []string{"servers.team-a.secret"},
+			},
+			"ops": {Allow: []string{"servers"}},
+		},
+		Users:        map[string][]string{"alice": {"team-a"}, "root": {"ops", "team-a"}},
+		DefaultRoles: []string{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestNew(t *testing.T) {
+	if a, err := New(Config{}); a != nil || err != nil {
+		t.Errorf("New without roles = %v, %v, want nil, nil", a, err)
+	}
+
+	bad := []Config{
+		{Roles: map[string]Role{"a": {Allow: []string{""}}}},
+		{Roles: map[string]Role{"a": {Deny: []string{"servers.[a"}}}},
+		{Roles: map[string]Role{"a": {}}, Users: map[string][]string{"alice": {"b"}}},
+		{Roles: map[string]Role{"a": {}}, DefaultRoles: []string{"b"}},
+	}
+	for _, cfg := range bad {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v): expected an error", cfg)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	a := testACL(t, true)
+	alice := a.Principal("alice")
+	root := a.Principal("root")
+	nobody := a.Principal("")
+
+	tests := []struct {
+		p      Principal
+		metric string
+		want   bool
+	}{
+		{alice, "servers.team-a.web1.cpu", true},
+		{alice, "servers.team-a.web1", true},
+		{alice, "servers.team-a", false},
+		{alice, "servers.team-b.web1.cpu", false},
+		{alice, "servers.team-a.secret.key", false},
+		{alice, "apps.api_eu.requests", true},
+		{alice, "apps.db_eu.requests", false},
+		{root, "servers.team-b.web1.cpu", true},
+		// deny wins over the allow of another role
+		{root, "servers.team-a.secret.key", false},
+		{nobody, "servers.team-a.web1.cpu", false},
+	}
+
+	for _, tt := range tests {
+		if got := a.Allowed(tt.p, tt.metric); got != tt.want {
+			t.Errorf("Allowed(%v, %q) = %v, want %v", tt.p.User, tt.metric, got, tt.want)
+		}
+	}
+
+	open := testACL(t, false)
+	if !open.Allowed(open.Principal("alice"), "other.metric") {
+		t.Error("paths no role mentions should be allowed without denyByDefault")
+	}
+	if open.Allowed(open.Principal("alice"), "servers.team-a.secret") {
+		t.Error("denied paths should be denied without denyByDefault")
+	}
+}
+
+func TestVisible(t *testing.T) {
+	a := testACL(t, true)
+	alice := a.Principal("alice")
+
+	for branch, want := range map[string]bool{
+		"servers":                true,
+		"servers.team-a":         true,
+		"servers.team-a.web1":    true,
+		"servers.team-b":         false,
+		"servers.team-a.secret":  false,
+		"apps":                   true,
+		"databases":              false,
+		"apps.web_eu.requests.a": true,
+	} {
+		if got := a.Visible(alice, branch); got != want {
+			t.Errorf("Visible(alice, %q) = %v, want %v", branch, got, want)
+		}
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	a := testACL(t, true)
+
+	if got := a.Principal("root"); !reflect.DeepEqual(got, Principal{User: "root", Roles: []string{"ops", "team-a"}}) {
+		t.Errorf("Principal(root) = %+v", got)
+	}
+
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("empty context carries a principal")
+	}
+	p := a.Principal("alice")
+	if got, ok := FromContext(NewContext(context.Background(), p)); !ok || !reflect.DeepEqual(got, p) {
+		t.Errorf("FromContext = %+v, %v", got, ok)
+	}
+}
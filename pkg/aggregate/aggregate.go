@@ -0,0 +1,195 @@
+/*
+Package aggregate computes the sumSeries, averageSeries and countSeries of
+the series of a glob from partial aggregates of parts of them, such as the
+ones backends that aggregate their own series return, so that a zipper
+fetches a couple of series per backend instead of all of them.
+
+A partial is the sum and the count of the values of its series, point by
+point. The partials of disjoint parts merge into the partial of their
+union; parts that overlap, as the series of replicas do, would count the
+series they share twice, so each series is to be in one part only.
+
+Example use:
+
+	agg, ok := Of(types.FilterFunction{Name: "averageSeries"})
+	p1, _ := Compute(fromOneBackend)
+	p2, _ := Compute(fromAnother)
+	p, err := Merge([]Partial{p1, p2})
+	result := p.Result(agg, "x.*")
+*/
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// The aggregations of the package.
+const (
+	Sum     = "sum"
+	Average = "average"
+	Count   = "count"
+)
+
+// Names are the aggregating functions the package computes, as filtering
+// functions of render requests name them.
+var Names = []string{"aggregate", "averageSeries", "countSeries", "sumSeries"}
+
+// Of returns the aggregation f asks for, if f is an aggregating function:
+// sumSeries, averageSeries, countSeries, or aggregate with one of "sum",
+// "average", "avg" or "count".
+func Of(f types.FilterFunction) (string, bool) {
+	var agg string
+	switch {
+	case f.Name == "sumSeries" && len(f.Arguments) == 0:
+		agg = Sum
+	case f.Name == "averageSeries" && len(f.Arguments) == 0:
+		agg = Average
+	case f.Name == "countSeries" && len(f.Arguments) == 0:
+		agg = Count
+	case f.Name == "aggregate" && len(f.Arguments) == 1:
+		switch f.Arguments[0] {
+		case "sum":
+			agg = Sum
+		case "average", "avg":
+			agg = Average
+		case "count":
+			agg = Count
+		}
+	}
+
+	return agg, agg != ""
+}
+
+// Partials are the filtering functions that ask a backend for the parts of
+// the partial of a glob, in the order of NewPartial.
+var Partials = []types.FilterFunction{
+	{Name: "aggregate", Arguments: []string{Sum}},
+	{Name: "aggregate", Arguments: []string{Count}},
+}
+
+// Partial is the sum and the count of the values of a set of series, point
+// by point.
+type Partial struct {
+	Sum   types.Metric
+	Count types.Metric
+}
+
+// errSteps is returned for partials that don't line up.
+var errSteps = errors.New("partial aggregates have different time ranges or steps")
+
+// NewPartial returns the partial of the series a backend aggregated, as
+// it returns sums and counts, which have to line up.
+func NewPartial(sum, count types.Metric) (Partial, error) {
+	if !aligned(sum, count) {
+		return Partial{}, errSteps
+	}
+
+	return Partial{Sum: sum, Count: count}, nil
+}
+
+// Compute returns the partial of metrics, consolidated to the coarsest of
+// their steps. It reports false for no metrics.
+func Compute(metrics []types.Metric) (Partial, bool) {
+	if len(metrics) == 0 {
+		return Partial{}, false
+	}
+
+	metrics = types.NormalizeSteps(metrics)
+	base := metrics[0]
+	p := Partial{
+		Sum:   empty(base),
+		Count: empty(base),
+	}
+	for i := range p.Count.Values {
+		p.Count.IsAbsent[i] = false
+	}
+	for _, m := range metrics {
+		for i, v := range m.Values {
+			if i >= len(base.Values) || m.IsAbsent[i] {
+				continue
+			}
+			p.Sum.Values[i] += v
+			p.Sum.IsAbsent[i] = false
+			p.Count.Values[i]++
+		}
+	}
+
+	return p, true
+}
+
+// Merge returns the partial of the union of the disjoint sets of series of
+// ps. It fails for partials that don't line up.
+func Merge(ps []Partial) (Partial, error) {
+	if len(ps) == 0 {
+		return Partial{}, errors.New("no partial aggregates")
+	}
+
+	first := ps[0]
+	merged := Partial{Sum: empty(first.Sum), Count: empty(first.Count)}
+	for i := range merged.Count.Values {
+		merged.Count.IsAbsent[i] = false
+	}
+	for _, p := range ps {
+		if !aligned(p.Sum, first.Sum) || !aligned(p.Count, first.Sum) {
+			return Partial{}, errSteps
+		}
+		for i := range p.Sum.Values {
+			if !p.Sum.IsAbsent[i] {
+				merged.Sum.Values[i] += p.Sum.Values[i]
+				merged.Sum.IsAbsent[i] = false
+			}
+			if !p.Count.IsAbsent[i] {
+				merged.Count.Values[i] += p.Count.Values[i]
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// Result returns the aggregate agg of p, named as Graphite names the
+// aggregate of path. Points without values are absent.
+func (p Partial) Result(agg, path string) types.Metric {
+	m := empty(p.Sum)
+	m.Name = fmt.Sprintf("%sSeries(%s)", agg, path)
+	for i, n := range p.Count.Values {
+		if n == 0 || p.Count.IsAbsent[i] {
+			continue
+		}
+		m.IsAbsent[i] = false
+		switch agg {
+		case Sum:
+			m.Values[i] = p.Sum.Values[i]
+		case Average:
+			m.Values[i] = p.Sum.Values[i] / n
+		case Count:
+			m.Values[i] = n
+		}
+	}
+
+	return m
+}
+
+// empty returns a series of the time range and step of m, all absent.
+func empty(m types.Metric) types.Metric {
+	e := types.Metric{
+		Name:      m.Name,
+		StartTime: m.StartTime,
+		StopTime:  m.StopTime,
+		StepTime:  m.StepTime,
+		Values:    make([]float64, len(m.Values)),
+		IsAbsent:  make([]bool, len(m.Values)),
+	}
+	for i := range e.IsAbsent {
+		e.IsAbsent[i] = true
+	}
+
+	return e
+}
+
+func aligned(a, b types.Metric) bool {
+	return a.StartTime == b.StartTime && a.StepTime == b.StepTime && len(a.Values) == len(b.Values)
+}
@@ -0,0 +1,101 @@
+package aggregate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func series(name string, start, step int32, values ...float64) types.Metric {
+	m := types.Metric{Name: name, StartTime: start, StopTime: start + step*int32(len(values)), StepTime: step}
+	for _, v := range values {
+		m.Values = append(m.Values, v)
+		m.IsAbsent = append(m.IsAbsent, math.IsNaN(v))
+	}
+	for i := range m.Values {
+		if m.IsAbsent[i] {
+			m.Values[i] = 0
+		}
+	}
+
+	return m
+}
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		f   types.FilterFunction
+		agg string
+	}{
+		{types.FilterFunction{Name: "sumSeries"}, Sum},
+		{types.FilterFunction{Name: "averageSeries"}, Average},
+		{types.FilterFunction{Name: "countSeries"}, Count},
+		{types.FilterFunction{Name: "aggregate", Arguments: []string{"avg"}}, Average},
+		{types.FilterFunction{Name: "aggregate", Arguments: []string{"median"}}, ""},
+		{types.FilterFunction{Name: "sumSeries", Arguments: []string{"1"}}, ""},
+		{types.FilterFunction{Name: "highestAverage", Arguments: []string{"1"}}, ""},
+	}
+
+	for _, tt := range tests {
+		agg, ok := Of(tt.f)
+		if agg != tt.agg || ok != (tt.agg != "") {
+			t.Errorf("%+v: expected %q, got %q, %v", tt.f, tt.agg, agg, ok)
+		}
+	}
+}
+
+func TestMergedPartials(t *testing.T) {
+	nan := math.NaN()
+	a := series("a", 0, 60, 1, nan, 3, nan)
+	b := series("b", 0, 60, 2, nan, nan, nan)
+	c := series("c", 0, 60, 4, 5, 6, nan)
+
+	whole, _ := Compute([]types.Metric{a, b, c})
+	p1, _ := Compute([]types.Metric{a, b})
+	p2, _ := Compute([]types.Metric{c})
+	merged, err := Merge([]Partial{p1, p2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, agg := range []string{Sum, Average, Count} {
+		if got, expected := merged.Result(agg, "x.*"), whole.Result(agg, "x.*"); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %+v, got %+v", agg, expected, got)
+		}
+	}
+
+	expected := map[string]types.Metric{
+		Sum:     series("sumSeries(x.*)", 0, 60, 7, 5, 9, nan),
+		Average: series("averageSeries(x.*)", 0, 60, 7.0/3, 5, 4.5, nan),
+		Count:   series("countSeries(x.*)", 0, 60, 3, 1, 2, nan),
+	}
+	for agg, e := range expected {
+		if got := merged.Result(agg, "x.*"); !reflect.DeepEqual(got, e) {
+			t.Errorf("%s: expected %+v, got %+v", agg, e, got)
+		}
+	}
+}
+
+func TestComputeSteps(t *testing.T) {
+	p, ok := Compute([]types.Metric{
+		series("a", 0, 60, 1, 3, 5, 7),
+		series("b", 0, 120, 10, 20),
+	})
+	if !ok {
+		t.Fatal("expected a partial")
+	}
+
+	expected := series("sumSeries(x)", 0, 120, 12, 26)
+	if got := p.Result(Sum, "x"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestMergeMisaligned(t *testing.T) {
+	p1, _ := Compute([]types.Metric{series("a", 0, 60, 1, 2)})
+	p2, _ := Compute([]types.Metric{series("b", 0, 120, 1)})
+	if _, err := Merge([]Partial{p1, p2}); err == nil {
+		t.Error("expected an error")
+	}
+}
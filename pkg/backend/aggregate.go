@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+// Capable is implemented by backends that announce what they support.
+type Capable interface {
+	Capabilities() types.Capabilities
+}
+
+// aggregates tells if b aggregates the series it renders itself.
+func aggregates(b Backend) bool {
+	c, ok := b.(Capable)
+	return ok && c.Capabilities().SupportsFilters(aggregate.Partials)
+}
+
+// Aggregate renders the aggregate agg, one of those of package aggregate,
+// of the series matching glob. Every series is aggregated by one backend
+// that has it, those that aggregate their series themselves first, so that
+// replicas don't count it twice; the other backends send the series, which
+// are aggregated here. When the partial aggregates don't line up, all the
+// series are fetched and aggregated here. Aggregate returns nil if no
+// backend has any series.
+func Aggregate(ctx context.Context, backends []Backend, from int32, until int32, glob string, agg string) (*types.Metric, error) {
+	if len(backends) == 0 {
+		return nil, nil
+	}
+
+	ordered := make([]Backend, len(backends))
+	copy(ordered, backends)
+	sort.SliceStable(ordered, func(i, j int) bool { return aggregates(ordered[i]) && !aggregates(ordered[j]) })
+
+	paths, err := assignPaths(ctx, ordered, glob)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		partials []aggregate.Partial
+		errs     []error
+	)
+	for i, b := range ordered {
+		if len(paths[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(b Backend, paths []string) {
+			defer wg.Done()
+			p, ok, err := partial(ctx, b, from, until, paths)
+			mu.Lock()
+			defer mu.Unlock()
+			if f := FanOutFrom(ctx); f.Answered != nil {
+				f.Answered(b, err)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			} else if ok {
+				partials = append(partials, p)
+			}
+		}(b, paths[i])
+	}
+	wg.Wait()
+
+	if len(errs) > 0 && len(partials) == 0 {
+		return nil, errs[0]
+	}
+	if len(partials) == 0 {
+		return nil, nil
+	}
+
+	p, err := aggregate.Merge(partials)
+	if err != nil {
+		metrics, err := Renders(ctx, backends, from, until, []string{glob})
+		if err != nil {
+			return nil, err
+		}
+		if p, ok := aggregate.Compute(metrics); ok {
+			m := p.Result(agg, glob)
+			return &m, nil
+		}
+		return nil, nil
+	}
+	m := p.Result(agg, glob)
+
+	return &m, nil
+}
+
+// assignPaths finds the series of glob on each of backends, and assigns
+// each to the first backend that has it.
+func assignPaths(ctx context.Context, backends []Backend, glob string) ([][]string, error) {
+	found := make([]types.Matches, len(backends))
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b Backend) {
+			defer wg.Done()
+			found[i], errs[i] = b.Find(ctx, glob)
+		}(i, b)
+	}
+	wg.Wait()
+
+	failed := 0
+	assigned := make(map[string]struct{})
+	paths := make([][]string, len(backends))
+	for i, matches := range found {
+		if errs[i] != nil {
+			failed++
+			continue
+		}
+		for _, m := range matches.Matches {
+			if !m.IsLeaf {
+				continue
+			}
+			if _, ok := assigned[m.Path]; ok {
+				continue
+			}
+			assigned[m.Path] = struct{}{}
+			paths[i] = append(paths[i], m.Path)
+		}
+	}
+	if failed == len(backends) {
+		return nil, errors.Wrap(errs[0], "no backend found the series to aggregate")
+	}
+
+	return paths, nil
+}
+
+// partial returns the partial aggregate of paths on b. It reports false
+// if b has none of them.
+func partial(ctx context.Context, b Backend, from int32, until int32, paths []string) (aggregate.Partial, bool, error) {
+	if !aggregates(b) {
+		metrics, err := b.Render(ctx, from, until, paths)
+		if err != nil {
+			return aggregate.Partial{}, false, err
+		}
+		p, ok := aggregate.Compute(metrics)
+		return p, ok, nil
+	}
+
+	// A brace expression asks for exactly the series b is assigned.
+	target := "{" + strings.Join(paths, ",") + "}"
+	parts := make([]types.Metric, 0, len(aggregate.Partials))
+	for _, f := range aggregate.Partials {
+		metrics, err := b.Render(WithFilters(ctx, []types.FilterFunction{f}), from, until, []string{target})
+		if err != nil {
+			return aggregate.Partial{}, false, err
+		}
+		if len(metrics) == 0 {
+			return aggregate.Partial{}, false, nil
+		}
+		// A backend spoken to in a version without filtering functions
+		// sends the series themselves.
+		if len(metrics) > 1 || !strings.HasPrefix(metrics[0].Name, f.Arguments[0]+"Series(") {
+			p, ok := aggregate.Compute(metrics)
+			return p, ok, nil
+		}
+		parts = append(parts, metrics[0])
+	}
+	p, err := aggregate.NewPartial(parts[0], parts[1])
+
+	return p, err == nil, err
+}
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// capable is a mock backend that aggregates the series it renders.
+type capable struct {
+	mock.Backend
+}
+
+func (capable) Capabilities() types.Capabilities {
+	return types.Capabilities{FilterFunctions: aggregate.Names}
+}
+
+// store is a backend holding series with the given values, one point each.
+func store(values map[string]float64, rendered *[]string) mock.Backend {
+	return mock.New(mock.Config{
+		Find: func(_ context.Context, glob string) (types.Matches, error) {
+			m := types.Matches{Name: glob}
+			for path := range values {
+				m.Matches = append(m.Matches, types.Match{Path: path, IsLeaf: true})
+			}
+			return m, nil
+		},
+		Render: func(ctx context.Context, from, until int32, targets []string) ([]types.Metric, error) {
+			*rendered = append(*rendered, strings.Join(targets, ","))
+			var paths []string
+			for _, t := range targets {
+				paths = append(paths, strings.Split(strings.Trim(t, "{}"), ",")...)
+			}
+
+			var metrics []types.Metric
+			for _, p := range paths {
+				if v, ok := values[p]; ok {
+					metrics = append(metrics, types.Metric{Name: p, StartTime: from, StopTime: until, StepTime: until - from, Values: []float64{v}, IsAbsent: []bool{false}})
+				}
+			}
+			if fs := Filters(ctx); len(fs) == 1 && len(metrics) > 0 {
+				agg, _ := aggregate.Of(fs[0])
+				p, _ := aggregate.Compute(metrics)
+				metrics = []types.Metric{p.Result(agg, strings.Join(targets, ","))}
+			}
+			return metrics, nil
+		},
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	var rendered [3][]string
+	backends := []Backend{
+		store(map[string]float64{"x.a": 1, "x.b": 2}, &rendered[0]),
+		capable{store(map[string]float64{"x.b": 2, "x.c": 4}, &rendered[1])},
+		store(map[string]float64{"x.d": 8}, &rendered[2]),
+	}
+
+	for agg, expected := range map[string]float64{aggregate.Sum: 15, aggregate.Average: 3.75, aggregate.Count: 4} {
+		for i := range rendered {
+			rendered[i] = nil
+		}
+
+		m, err := Aggregate(context.Background(), backends, 0, 60, "x.*", agg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil || m.Name != agg+"Series(x.*)" || !reflect.DeepEqual(m.Values, []float64{expected}) {
+			t.Errorf("%s: expected %v, got %+v", agg, expected, m)
+		}
+	}
+
+	// the replica that aggregates is assigned the series both have
+	if len(rendered[1]) != 2 || !strings.Contains(rendered[1][0], "x.b") || strings.Contains(rendered[0][0], "x.b") {
+		t.Errorf("unexpected renders %v", rendered)
+	}
+}
+
+func TestAggregateNoSeries(t *testing.T) {
+	var rendered []string
+	m, err := Aggregate(context.Background(), []Backend{store(nil, &rendered)}, 0, 60, "x.*", aggregate.Sum)
+	if err != nil || m != nil {
+		t.Errorf("expected no aggregate, got %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Kind classifies the errors of backend requests, by which the handlers
+// answering with them pick their status code. Kinds are errors themselves,
+// so that errors.Is(err, backend.Timeout) tells if err is of a kind.
+type Kind int
+
+// The kinds of errors, Internal for all the errors of unknown kinds.
+const (
+	Internal Kind = iota
+	NotFound
+	Timeout
+	Throttled
+	BadRequest
+)
+
+var kindNames = map[Kind]string{
+	Internal:   "backend_internal",
+	NotFound:   "not_found",
+	Timeout:    "timeout",
+	Throttled:  "throttled",
+	BadRequest: "bad_request",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+func (k Kind) Error() string {
+	return strings.Replace(kindNames[k], "_", " ", -1)
+}
+
+// Code is the HTTP status of the responses failed with errors of kind k.
+func (k Kind) Code() int {
+	switch k {
+	case NotFound:
+		return http.StatusNotFound
+	case Timeout:
+		return http.StatusGatewayTimeout
+	case Throttled:
+		return http.StatusServiceUnavailable
+	case BadRequest:
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// KindOfCode is the kind of the errors of backends answering with the HTTP
+// status code.
+func KindOfCode(code int) Kind {
+	switch code {
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return Timeout
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return Throttled
+	case http.StatusBadRequest:
+		return BadRequest
+	}
+
+	return Internal
+}
+
+// Error is an error of a known Kind.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Cause lets errors.Cause of github.com/pkg/errors find the error the kind
+// was given to.
+func (e *Error) Cause() error { return e.Err }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is tells if e is of the kind target.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && k == e.Kind
+}
+
+// Errors are the errors of the requests to several backends that all
+// failed, of the kind they all are, or Internal if they differ.
+type Errors []error
+
+func (es Errors) Error() string {
+	if err := combineErrors(es); err != nil {
+		return "All backend requests failed: " + err.Error()
+	}
+
+	return "All backend requests failed"
+}
+
+// Is tells if es is of the kind target.
+func (es Errors) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && KindOf(es) == k
+}
+
+// KindOf returns the kind of err: that of the *Error or Errors it is or
+// wraps, one told by its cause, such as Timeout for an exceeded deadline,
+// or Internal. Wrapping is followed through both Cause and Unwrap.
+func KindOf(err error) Kind {
+	for err != nil {
+		switch e := err.(type) {
+		case *Error:
+			return e.Kind
+		case Errors:
+			return kindOfAll(e)
+		case Kind:
+			return e
+		case net.Error:
+			if e.Timeout() {
+				return Timeout
+			}
+		}
+		if err == context.DeadlineExceeded {
+			return Timeout
+		}
+
+		switch e := err.(type) {
+		case interface{ Cause() error }:
+			err = e.Cause()
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return Internal
+		}
+	}
+
+	return Internal
+}
+
+func kindOfAll(es Errors) Kind {
+	if len(es) == 0 {
+		return Internal
+	}
+
+	k := KindOf(es[0])
+	for _, err := range es[1:] {
+		if KindOf(err) != k {
+			return Internal
+		}
+	}
+
+	return k
+}
@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestKindOf(t *testing.T) {
+	notFound := &Error{Kind: NotFound, Err: errors.New("Bad response code 404")}
+
+	tests := []struct {
+		name string
+		err  error
+		kind Kind
+	}{
+		{"unknown", errors.New("no"), Internal},
+		{"typed", notFound, NotFound},
+		{"wrapped", errors.Wrap(errors.WithMessage(notFound, "find"), "HTTP call failed"), NotFound},
+		{"deadline", errors.Wrap(context.DeadlineExceeded, "HTTP call failed"), Timeout},
+		{"same kinds", Errors{notFound, errors.Wrap(notFound, "again")}, NotFound},
+		{"different kinds", Errors{notFound, context.DeadlineExceeded}, Internal},
+		{"code", &Error{Kind: KindOfCode(http.StatusTooManyRequests), Err: errors.New("429")}, Throttled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.kind {
+				t.Errorf("expected %s, got %s", tt.kind, got)
+			}
+		})
+	}
+}
+
+func TestErrorsIs(t *testing.T) {
+	err := Errors{&Error{Kind: Timeout, Err: errors.New("slow")}, context.DeadlineExceeded}
+	if !stderrors.Is(err, Timeout) {
+		t.Errorf("expected %v to be a timeout", err)
+	}
+	if stderrors.Is(err, NotFound) {
+		t.Errorf("expected %v not to be not found", err)
+	}
+
+	var typed *Error
+	if !stderrors.As(error(&Error{Kind: BadRequest, Err: errors.New("bad")}), &typed) || typed.Kind.Code() != http.StatusBadRequest {
+		t.Errorf("expected a bad request, got %v", typed)
+	}
+}
@@ -0,0 +1,288 @@
+package backend
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// FanOut schedules the requests Renders, Infos and Finds make to their
+// backends. The zero FanOut sends all requests at once, in the order the
+// backends are given, and waits for every answer.
+type FanOut struct {
+	// Stagger delays the start of each request after the first by this
+	// much more than the one before it.
+	Stagger time.Duration
+	// Jitter adds up to this much to the delay of each request after the
+	// first. The jitter is derived from the UUID of the request, so a
+	// request is scheduled the same way each time it is retried.
+	Jitter time.Duration
+	// OrderByLatency starts the requests to the backends that recently
+	// answered fastest first. Backends that haven't answered yet go first,
+	// so that they get measured.
+	OrderByLatency bool
+
+	// Holders are the backends known to hold the requested paths. Once all
+	// of them answered, the requests to the other backends are cancelled.
+	// If any of them fails, all backends are waited for.
+	Holders []Backend
+	// Seen, if set, is called with each backend that answers with data.
+	Seen func(Backend)
+	// Answered, if set, is called with each backend that answers, and the
+	// error it failed with, if any. Requests cancelled once the fan-out
+	// stopped early aren't answers.
+	Answered func(Backend, error)
+
+	// Alternates are backends known to hold the requested paths that
+	// aren't asked, e.g. those of another region. A request that fails is
+	// sent again to the next of them, after up to FailoverJitter, rather
+	// than the answer missing what the failed backend has.
+	Alternates     []Backend
+	FailoverJitter time.Duration
+	// FailedOver, if set, is called with each backend whose failed
+	// request was sent again, and the alternate it was sent to.
+	FailedOver func(failed Backend, alternate Backend)
+
+	// Replicas tells that the Holders hold the same data. Only the least
+	// loaded of them is asked then, and the other backends only if it fails
+	// or has nothing.
+	Replicas bool
+	// Weights scale the load a backend is picked for as a replica; a
+	// backend with weight 2 takes twice the load of one with weight 1, the
+	// default.
+	Weights map[Backend]float64
+}
+
+type fanOutKey struct{}
+
+// WithFanOut makes the fan-out requests made with ctx follow f.
+func WithFanOut(ctx context.Context, f FanOut) context.Context {
+	return context.WithValue(ctx, fanOutKey{}, f)
+}
+
+// FanOutFrom returns the FanOut of ctx, the zero one if it has none.
+func FanOutFrom(ctx context.Context) FanOut {
+	if f, ok := ctx.Value(fanOutKey{}).(FanOut); ok {
+		return f
+	}
+
+	return FanOut{}
+}
+
+// order returns backends in the order their requests start.
+func (f FanOut) order(backends []Backend) []Backend {
+	if !f.OrderByLatency {
+		return backends
+	}
+
+	ordered := make([]Backend, len(backends))
+	copy(ordered, backends)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return loads.latency(ordered[i]) < loads.latency(ordered[j])
+	})
+
+	return ordered
+}
+
+// delay returns how long the i-th request of ctx waits before it starts.
+func (f FanOut) delay(ctx context.Context, i int) time.Duration {
+	if i == 0 {
+		return 0
+	}
+
+	d := time.Duration(i) * f.Stagger
+	if f.Jitter > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(util.GetUUID(ctx)))
+		binary.Write(h, binary.LittleEndian, int64(i))
+		d += time.Duration(float64(f.Jitter) * float64(h.Sum32()) / (1 << 32))
+	}
+
+	return d
+}
+
+// failoverDelay returns how long the i-th request sent again to an
+// alternate waits. Like the jitter of delay, it's derived from the UUID.
+func (f FanOut) failoverDelay(ctx context.Context, i int) time.Duration {
+	if f.FailoverJitter <= 0 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(util.GetUUID(ctx)))
+	binary.Write(h, binary.LittleEndian, int64(-1-i))
+
+	return time.Duration(float64(f.FailoverJitter) * float64(h.Sum32()) / (1 << 32))
+}
+
+// holders returns the Holders that are among backends, or nil if the
+// fan-out can't stop early.
+func (f FanOut) holders(backends []Backend) []Backend {
+	if len(f.Holders) == 0 {
+		return nil
+	}
+
+	hs := make([]Backend, 0, len(f.Holders))
+	for _, h := range f.Holders {
+		for _, b := range backends {
+			if h == b {
+				hs = append(hs, h)
+				break
+			}
+		}
+	}
+
+	if len(hs) == 0 {
+		return nil
+	}
+
+	return hs
+}
+
+// pick returns the least loaded of the Holders among backends, nil if
+// there are none.
+func (f FanOut) pick(backends []Backend) Backend {
+	var (
+		best      Backend
+		bestScore float64
+	)
+	for _, h := range f.holders(backends) {
+		weight := 1.0
+		if w, ok := f.Weights[h]; ok && w > 0 {
+			weight = w
+		}
+
+		if score := loads.score(h) / weight; best == nil || score < bestScore {
+			best, bestScore = h, score
+		}
+	}
+
+	return best
+}
+
+// fanOut calls each of backends as the FanOut of ctx schedules it, and
+// hands the answers to collect as they come in. collect reports whether an
+// answer had data; if it fails, the fan-out is cancelled and its error
+// returned. The errors of the calls that failed are returned too.
+func fanOut(ctx context.Context, backends []Backend, call func(context.Context, Backend) (interface{}, error), collect func(interface{}) (bool, error)) ([]error, error) {
+	f := FanOutFrom(ctx)
+	if !f.Replicas || len(backends) < 2 {
+		errs, _, err := fanOutTo(ctx, f, backends, call, collect)
+		return errs, err
+	}
+
+	best := f.pick(backends)
+	if best == nil {
+		errs, _, err := fanOutTo(ctx, f, backends, call, collect)
+		return errs, err
+	}
+
+	// The other replicas are asked before any alternate.
+	first := f
+	first.Alternates = nil
+	errs, found, err := fanOutTo(ctx, first, []Backend{best}, call, collect)
+	if found || err != nil {
+		return errs, err
+	}
+
+	rest := make([]Backend, 0, len(backends)-1)
+	for _, b := range backends {
+		if b != best {
+			rest = append(rest, b)
+		}
+	}
+	more, _, err := fanOutTo(ctx, f, rest, call, collect)
+
+	return append(errs, more...), err
+}
+
+// fanOutTo does the fan-out of f to backends. It also reports whether any
+// answer had data.
+func fanOutTo(ctx context.Context, f FanOut, backends []Backend, call func(context.Context, Backend) (interface{}, error), collect func(interface{}) (bool, error)) ([]error, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		backend Backend
+		msg     interface{}
+		err     error
+	}
+
+	answers := make(chan answer, len(backends)+len(f.Alternates))
+	send := func(b Backend, delay time.Duration) {
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				answers <- answer{backend: b, err: ctx.Err()}
+				return
+			}
+		}
+
+		done := loads.start(b)
+		msg, err := call(ctx, b)
+		done(err == nil)
+		answers <- answer{backend: b, msg: msg, err: err}
+	}
+	for i, backend := range f.order(backends) {
+		go send(backend, f.delay(ctx, i))
+	}
+
+	waiting := f.holders(backends)
+	errs := make([]error, 0, len(backends))
+	anyFound := false
+	alternates := f.Alternates
+	for pending := len(backends); pending > 0; pending-- {
+		a := <-answers
+		if f.Answered != nil {
+			f.Answered(a.backend, a.err)
+		}
+		if a.err != nil {
+			waiting = nil
+			if len(alternates) > 0 && ctx.Err() == nil {
+				alt := alternates[0]
+				alternates = alternates[1:]
+				if f.FailedOver != nil {
+					f.FailedOver(a.backend, alt)
+				}
+				go send(alt, f.failoverDelay(ctx, len(f.Alternates)-len(alternates)-1))
+				pending++
+				continue
+			}
+			errs = append(errs, a.err)
+			continue
+		}
+
+		found, err := collect(a.msg)
+		if err != nil {
+			// Returning cancels the backends still sending data.
+			return errs, anyFound, err
+		}
+		if found {
+			anyFound = true
+			if f.Seen != nil {
+				f.Seen(a.backend)
+			}
+		}
+
+		if waiting != nil {
+			for j, h := range waiting {
+				if h == a.backend {
+					waiting = append(waiting[:j], waiting[j+1:]...)
+					break
+				}
+			}
+			if len(waiting) == 0 {
+				return errs, anyFound, nil
+			}
+		}
+	}
+
+	return errs, anyFound, nil
+}
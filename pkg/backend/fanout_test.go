@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+func TestFanOutDelay(t *testing.T) {
+	f := FanOut{Stagger: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}
+	ctx := util.WithUUID(context.Background())
+
+	if d := f.delay(ctx, 0); d != 0 {
+		t.Errorf("Expected the first request to start at once, got %v", d)
+	}
+
+	for i := 1; i < 10; i++ {
+		d := f.delay(ctx, i)
+		if min := time.Duration(i) * f.Stagger; d < min || d >= min+f.Jitter {
+			t.Errorf("Expected delay %d in [%v, %v), got %v", i, min, min+f.Jitter, d)
+		}
+		if again := f.delay(ctx, i); again != d {
+			t.Errorf("Expected delay %d to be deterministic, got %v and %v", i, d, again)
+		}
+	}
+}
+
+func TestFanOutOrderByLatency(t *testing.T) {
+	newBackend := func() Backend {
+		b := mock.New(mock.Config{})
+		return &b
+	}
+	slow, fast, unknown := newBackend(), newBackend(), newBackend()
+	setLoad(slow, 0, time.Second)
+	setLoad(fast, 0, time.Millisecond)
+
+	backends := []Backend{slow, fast, unknown}
+	if got := (FanOut{}).order(backends); got[0] != slow || got[1] != fast || got[2] != unknown {
+		t.Error("Expected the given order without OrderByLatency")
+	}
+
+	got := FanOut{OrderByLatency: true}.order(backends)
+	if got[0] != unknown || got[1] != fast || got[2] != slow {
+		t.Error("Expected unmeasured backends first, then the fastest")
+	}
+	if backends[0] != slow {
+		t.Error("Expected the given backends to be left as they are")
+	}
+}
+
+func TestFindsStopEarly(t *testing.T) {
+	found := func(err error) Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				if err != nil {
+					return types.Matches{}, err
+				}
+				return types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo", IsLeaf: true}}}, nil
+			},
+		})
+		return &b
+	}
+	blocked := func() Backend {
+		b := mock.New(mock.Config{
+			Find: func(ctx context.Context, _ string) (types.Matches, error) {
+				<-ctx.Done()
+				return types.Matches{}, ctx.Err()
+			},
+		})
+		return &b
+	}
+
+	holder := found(nil)
+	var seen, answered []Backend
+	ctx := WithFanOut(context.Background(), FanOut{
+		Holders:  []Backend{holder},
+		Seen:     func(b Backend) { seen = append(seen, b) },
+		Answered: func(b Backend, _ error) { answered = append(answered, b) },
+	})
+	got, err := Finds(ctx, []Backend{blocked(), holder}, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Matches) != 1 {
+		t.Errorf("Expected the match of the holder, got %v", got.Matches)
+	}
+	if len(seen) != 1 || seen[0] != holder {
+		t.Errorf("Expected the holder to be seen, got %v", seen)
+	}
+	if len(answered) != 1 || answered[0] != holder {
+		t.Errorf("Expected only the holder to answer, got %v", answered)
+	}
+
+	// Without a working holder all backends are waited for.
+	failing := found(errors.New("no"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx = WithFanOut(ctx, FanOut{Holders: []Backend{failing}})
+	if _, err := Finds(ctx, []Backend{blocked(), failing}, "foo"); err == nil {
+		t.Error("Expected the fan-out to wait for the blocked backend")
+	}
+}
+
+func setLoad(b Backend, inFlight int, latency time.Duration) {
+	loads.mu.Lock()
+	defer loads.mu.Unlock()
+
+	l := loads.get(b)
+	l.inFlight, l.latency = inFlight, latency
+	l.recent = append(l.recent[:0], latency)
+}
+
+func TestFindsPickReplica(t *testing.T) {
+	var calls []string
+	replica := func(name string) Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				calls = append(calls, name)
+				return types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo", IsLeaf: true}}}, nil
+			},
+		})
+		return &b
+	}
+	busy, idle, heavy, other := replica("busy"), replica("idle"), replica("heavy"), replica("other")
+	setLoad(busy, 3, 10*time.Millisecond)
+	setLoad(idle, 0, 10*time.Millisecond)
+	setLoad(heavy, 1, 10*time.Millisecond)
+
+	ctx := WithFanOut(context.Background(), FanOut{
+		Holders:  []Backend{busy, idle},
+		Replicas: true,
+	})
+	if _, err := Finds(ctx, []Backend{busy, idle, other}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "idle" {
+		t.Errorf("Expected only the idle replica to be asked, got %v", calls)
+	}
+
+	// With weights, a busier replica may still take the request.
+	calls = nil
+	ctx = WithFanOut(context.Background(), FanOut{
+		Holders:  []Backend{idle, heavy},
+		Replicas: true,
+		Weights:  map[Backend]float64{heavy: 4},
+	})
+	if _, err := Finds(ctx, []Backend{idle, heavy}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "heavy" {
+		t.Errorf("Expected the heavier weighted replica to be asked, got %v", calls)
+	}
+}
+
+func TestFindsPickReplicaFallsBack(t *testing.T) {
+	empty := mock.New(mock.Config{})
+	full := mock.New(mock.Config{
+		Find: func(context.Context, string) (types.Matches, error) {
+			return types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo", IsLeaf: true}}}, nil
+		},
+	})
+	setLoad(&empty, 0, time.Millisecond)
+	setLoad(&full, 5, time.Second)
+
+	ctx := WithFanOut(context.Background(), FanOut{
+		Holders:  []Backend{&empty, &full},
+		Replicas: true,
+	})
+	got, err := Finds(ctx, []Backend{&empty, &full}, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Matches) != 1 {
+		t.Errorf("Expected the other replicas to be asked when the picked one has nothing, got %v", got.Matches)
+	}
+}
+
+func TestFindsFailOver(t *testing.T) {
+	found := func(path string) Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{Name: "foo", Matches: []types.Match{{Path: path, IsLeaf: true}}}, nil
+			},
+		})
+		return &b
+	}
+	failing := func() Backend {
+		b := mock.New(mock.Config{
+			Find: func(context.Context, string) (types.Matches, error) {
+				return types.Matches{}, errors.New("no")
+			},
+		})
+		return &b
+	}
+
+	near, broken, alternate := found("foo.near"), failing(), found("foo.far")
+	var failed []Backend
+	ctx := WithFanOut(util.WithUUID(context.Background()), FanOut{
+		Alternates:     []Backend{failing(), alternate},
+		FailoverJitter: time.Millisecond,
+		FailedOver:     func(b Backend, _ Backend) { failed = append(failed, b) },
+	})
+	got, err := Finds(ctx, []Backend{near, broken}, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Matches) != 2 {
+		t.Errorf("Expected the matches of the near backend and the alternate, got %v", got.Matches)
+	}
+	if len(failed) != 2 || failed[0] != broken || failed[1] == broken {
+		t.Errorf("Expected the broken backend, then the failing alternate, to fail over, got %v", failed)
+	}
+
+	// Once the alternates are used up, the errors are returned.
+	ctx = WithFanOut(context.Background(), FanOut{Alternates: []Backend{failing()}})
+	if _, err := Finds(ctx, []Backend{failing(), failing()}, "foo"); err == nil {
+		t.Error("Expected an error when the alternate fails too")
+	}
+}
@@ -0,0 +1,23 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+type filtersKey struct{}
+
+// WithFilters has Renders with ctx return only the series the filtering
+// functions fs keep. Backends that support them are asked to apply them,
+// so that they send less; Renders applies them to the merged responses
+// either way.
+func WithFilters(ctx context.Context, fs []types.FilterFunction) context.Context {
+	return context.WithValue(ctx, filtersKey{}, fs)
+}
+
+// Filters returns the filtering functions of ctx.
+func Filters(ctx context.Context) []types.FilterFunction {
+	fs, _ := ctx.Value(filtersKey{}).([]types.FilterFunction)
+	return fs
+}
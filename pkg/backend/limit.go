@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+type limitKey struct{}
+
+// WithPointLimit caps the datapoints Renders may fetch with ctx. Once the
+// merged responses go over the limit, the outstanding backend requests are
+// cancelled and Renders fails with a *TooLargeError. A limit of 0 or less
+// means no limit.
+func WithPointLimit(ctx context.Context, points int) context.Context {
+	return context.WithValue(ctx, limitKey{}, points)
+}
+
+func pointLimit(ctx context.Context) int {
+	if points, ok := ctx.Value(limitKey{}).(int); ok {
+		return points
+	}
+
+	return 0
+}
+
+// TooLargeError is returned by Renders when the backends return more
+// datapoints than the request is allowed to fetch.
+type TooLargeError struct {
+	// Points is how many datapoints were fetched when the request was
+	// stopped; the full result may be larger.
+	Points int
+	Limit  int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("result too large: fetched %d datapoints, the limit is %d", e.Points, e.Limit)
+}
+
+// pointCounter tracks the size of the merge of render responses. Replicas
+// return the same series, so a series counts once, with its longest length.
+type pointCounter struct {
+	bySeries map[string]int
+	total    int
+}
+
+func (c *pointCounter) add(metrics []types.Metric) int {
+	if c.bySeries == nil {
+		c.bySeries = make(map[string]int)
+	}
+
+	for _, m := range metrics {
+		if n := len(m.Values); n > c.bySeries[m.Name] {
+			c.total += n - c.bySeries[m.Name]
+			c.bySeries[m.Name] = n
+		}
+	}
+
+	return c.total
+}
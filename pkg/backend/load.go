@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyDecay is the weight of older answers in the latency of a
+	// backend; each answer moves it by 1/latencyDecay of the difference.
+	latencyDecay = 4
+	// loadWindow is how many recent answers the p95 of a backend is
+	// taken over.
+	loadWindow = 32
+	// minLatency stands in for the latency of backends that haven't
+	// answered yet, so that their load still counts.
+	minLatency = time.Millisecond
+)
+
+// loads keeps the load of the backends fan-outs went to.
+var loads = loadTracker{byBackend: make(map[Backend]*backendLoad)}
+
+type loadTracker struct {
+	mu        sync.Mutex
+	byBackend map[Backend]*backendLoad
+}
+
+type backendLoad struct {
+	inFlight int
+	latency  time.Duration // moving average
+	recent   []time.Duration
+	next     int
+}
+
+// get returns the load of b, nil if b can't be tracked. Call with t.mu held.
+func (t *loadTracker) get(b Backend) *backendLoad {
+	// Backends are kept by identity, which some of them, e.g. mocks, have
+	// none of.
+	if !reflect.TypeOf(b).Comparable() {
+		return nil
+	}
+
+	l, ok := t.byBackend[b]
+	if !ok {
+		l = &backendLoad{recent: make([]time.Duration, 0, loadWindow)}
+		t.byBackend[b] = l
+	}
+
+	return l
+}
+
+// start counts a request to b in flight. The returned func ends it, and
+// takes its latency into account if it succeeded.
+func (t *loadTracker) start(b Backend) func(ok bool) {
+	t.mu.Lock()
+	l := t.get(b)
+	if l != nil {
+		l.inFlight++
+	}
+	t.mu.Unlock()
+
+	if l == nil {
+		return func(bool) {}
+	}
+
+	t0 := time.Now()
+	return func(ok bool) {
+		d := time.Since(t0)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		l.inFlight--
+		if !ok {
+			return
+		}
+
+		if l.latency == 0 {
+			l.latency = d
+		} else {
+			l.latency += (d - l.latency) / latencyDecay
+		}
+
+		if len(l.recent) < loadWindow {
+			l.recent = append(l.recent, d)
+		} else {
+			l.recent[l.next] = d
+			l.next = (l.next + 1) % loadWindow
+		}
+	}
+}
+
+// latency returns the recent latency of b, 0 if it hasn't answered yet.
+func (t *loadTracker) latency(b Backend) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l := t.get(b); l != nil {
+		return l.latency
+	}
+
+	return 0
+}
+
+// score rates the load of b as the p95 of its recent answers times the
+// requests it has in flight, counting the next one.
+func (t *loadTracker) score(b Backend) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := t.get(b)
+	if l == nil {
+		return 0
+	}
+
+	p95 := minLatency
+	if n := len(l.recent); n > 0 {
+		sorted := make([]time.Duration, n)
+		copy(sorted, l.recent)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		if d := sorted[(n*95-1)/100]; d > p95 {
+			p95 = d
+		}
+	}
+
+	return float64(l.inFlight+1) * float64(p95)
+}
@@ -9,6 +9,14 @@ Example use:
 		}
 	})
 	got, err := b.Info(ctx, "foo")
+
+Server is a fake backend over HTTP, for tests that need the network path:
+
+	s := NewServer(ServerConfig{
+		Metrics: map[string]Data{"foo.bar": Constant(60, 1)},
+	})
+	defer s.Close()
+	b, err := net.New(net.Config{Address: s.URL})
 */
 package mock
 
@@ -26,6 +34,9 @@ type Backend struct {
 	info     func(context.Context, string) ([]types.Info, error)
 	render   func(context.Context, int32, int32, []string) ([]types.Metric, error)
 	contains func([]string) bool
+	list     func(context.Context) ([]string, error)
+
+	tombstones func(context.Context) ([]string, error)
 }
 
 // Config configures a mock Backend. Define ad-hoc functions to return
@@ -37,6 +48,9 @@ type Config struct {
 	Info     func(context.Context, string) ([]types.Info, error)
 	Render   func(context.Context, int32, int32, []string) ([]types.Metric, error)
 	Contains func([]string) bool
+	List     func(context.Context) ([]string, error)
+
+	Tombstones func(context.Context) ([]string, error)
 }
 
 var (
@@ -45,6 +59,7 @@ var (
 	noInfo     func(context.Context, string) ([]types.Info, error)                   = func(context.Context, string) ([]types.Info, error) { return nil, nil }
 	noRender   func(context.Context, int32, int32, []string) ([]types.Metric, error) = func(context.Context, int32, int32, []string) ([]types.Metric, error) { return nil, nil }
 	noContains func([]string) bool                                                   = func([]string) bool { return true }
+	noList     func(context.Context) ([]string, error)                               = func(context.Context) ([]string, error) { return nil, nil }
 )
 
 func (b Backend) Find(ctx context.Context, query string) (types.Matches, error) {
@@ -55,6 +70,14 @@ func (b Backend) Info(ctx context.Context, target string) ([]types.Info, error)
 	return b.info(ctx, target)
 }
 
+func (b Backend) List(ctx context.Context) ([]string, error) {
+	return b.list(ctx)
+}
+
+func (b Backend) Tombstones(ctx context.Context) ([]string, error) {
+	return b.tombstones(ctx)
+}
+
 func (b Backend) Render(ctx context.Context, from int32, until int32, targets []string) ([]types.Metric, error) {
 	return b.render(ctx, from, until, targets)
 }
@@ -95,6 +118,18 @@ func New(cfg Config) Backend {
 		b.contains = noContains
 	}
 
+	if cfg.List != nil {
+		b.list = cfg.List
+	} else {
+		b.list = noList
+	}
+
+	if cfg.Tombstones != nil {
+		b.tombstones = cfg.Tombstones
+	} else {
+		b.tombstones = noList
+	}
+
 	return b
 }
 
@@ -0,0 +1,262 @@
+package mock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/index"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+)
+
+// The paths a Server serves, as the net backend calls them.
+const (
+	FindPath   = "/metrics/find"
+	InfoPath   = "/info"
+	ListPath   = "/metrics/list/"
+	RenderPath = "/render"
+)
+
+// Data returns the series of a metric for a render request.
+type Data func(name string, from, until int32) types.Metric
+
+// Constant returns a series with the given step and value everywhere.
+func Constant(step int32, value float64) Data {
+	return func(name string, from, until int32) types.Metric {
+		start := from - from%step
+		n := int((until - start + step - 1) / step)
+		m := types.Metric{
+			Name:      name,
+			StartTime: start,
+			StopTime:  start + int32(n)*step,
+			StepTime:  step,
+			Values:    make([]float64, n),
+			IsAbsent:  make([]bool, n),
+		}
+		for i := range m.Values {
+			m.Values[i] = value
+		}
+		return m
+	}
+}
+
+// Fixed returns the same series whatever the time range asked for.
+func Fixed(m types.Metric) Data {
+	return func(name string, from, until int32) types.Metric {
+		m.Name = name
+		return m
+	}
+}
+
+// ServerConfig configures a Server.
+type ServerConfig struct {
+	// Metrics are the metrics the server has, by name.
+	Metrics map[string]Data
+	// Latency delays every response.
+	Latency time.Duration
+	// Errors makes requests to the given paths fail with the given HTTP
+	// status code.
+	Errors map[string]int
+}
+
+// Server is a fake go-carbon. It serves finds, infos, lists and renders of a
+// programmable dataset over HTTP in the carbonapi_v2 protocol, so code that
+// talks to real backends can be tested without one. Point a net backend at
+// its URL.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	metrics  map[string]Data
+	idx      *index.Index
+	latency  time.Duration
+	errors   map[string]int
+	requests map[string]int
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer(cfg ServerConfig) *Server {
+	s := &Server{
+		metrics:  make(map[string]Data),
+		latency:  cfg.Latency,
+		errors:   make(map[string]int),
+		requests: make(map[string]int),
+	}
+	for name, data := range cfg.Metrics {
+		s.metrics[name] = data
+	}
+	for path, code := range cfg.Errors {
+		s.errors[path] = code
+	}
+	s.reindex()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(FindPath, s.handle(FindPath, s.find))
+	mux.HandleFunc(InfoPath, s.handle(InfoPath, s.info))
+	mux.HandleFunc(ListPath, s.handle(ListPath, s.list))
+	mux.HandleFunc(RenderPath, s.handle(RenderPath, s.render))
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// SetMetric adds a metric to the dataset, or replaces it.
+func (s *Server) SetMetric(name string, data Data) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metrics[name] = data
+	s.reindex()
+}
+
+// SetLatency changes the delay of responses.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = latency
+}
+
+// SetError makes requests to path fail with the HTTP status code, or succeed
+// again if code is 0.
+func (s *Server) SetError(path string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if code == 0 {
+		delete(s.errors, path)
+	} else {
+		s.errors[path] = code
+	}
+}
+
+// Requests returns how many requests were made to path.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+func (s *Server) reindex() {
+	names := make([]string, 0, len(s.metrics))
+	for name := range s.metrics {
+		names = append(names, name)
+	}
+	s.idx = index.New(names)
+}
+
+func (s *Server) handle(path string, serve func(*http.Request, *index.Index) ([]byte, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[path]++
+		latency, code, idx := s.latency, s.errors[path], s.idx
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if code != 0 {
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := serve(r, idx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(body)
+	}
+}
+
+func (s *Server) find(r *http.Request, idx *index.Index) ([]byte, error) {
+	matches, err := idx.Find(r.FormValue("query"))
+	if err != nil {
+		return nil, err
+	}
+
+	return carbonapi_v2.FindEncoder(matches)
+}
+
+func (s *Server) info(r *http.Request, idx *index.Index) ([]byte, error) {
+	target := r.FormValue("target")
+
+	s.mu.Lock()
+	_, ok := s.metrics[target]
+	s.mu.Unlock()
+
+	var infos []types.Info
+	if ok {
+		infos = append(infos, types.Info{
+			Host:              r.Host,
+			Name:              target,
+			AggregationMethod: "average",
+		})
+	}
+
+	return carbonapi_v2.InfoEncoder(infos)
+}
+
+func (s *Server) list(r *http.Request, idx *index.Index) ([]byte, error) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.metrics))
+	for name := range s.metrics {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	return carbonapi_v2.ListEncoder(names)
+}
+
+func (s *Server) render(r *http.Request, idx *index.Index) ([]byte, error) {
+	from, err := strconv.Atoi(r.FormValue("from"))
+	if err != nil {
+		return nil, err
+	}
+	until, err := strconv.Atoi(r.FormValue("until"))
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []types.Metric
+	seen := make(map[string]bool)
+	for _, target := range r.Form["target"] {
+		matches, err := idx.Find(target)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matches.Matches {
+			if !m.IsLeaf || seen[m.Path] {
+				continue
+			}
+			seen[m.Path] = true
+
+			s.mu.Lock()
+			data := s.metrics[m.Path]
+			s.mu.Unlock()
+
+			metrics = append(metrics, data(m.Path, int32(from), int32(until)))
+		}
+	}
+
+	return carbonapi_v2.RenderEncoder(metrics)
+}
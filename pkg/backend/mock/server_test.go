@@ -0,0 +1,97 @@
+package mock
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(ServerConfig{
+		Metrics: map[string]Data{
+			"foo.bar": Constant(60, 1),
+			"foo.baz": Fixed(types.Metric{StartTime: 0, StopTime: 120, StepTime: 60, Values: []float64{1, 2}, IsAbsent: []bool{false, false}}),
+		},
+	})
+	defer s.Close()
+
+	b, err := net.New(net.Config{Address: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	matches, err := b.Find(ctx, "foo.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches.Matches) != 2 {
+		t.Errorf("found %v, want foo.bar and foo.baz", matches.Matches)
+	}
+
+	metrics, err := b.Render(ctx, 100, 400, []string{"foo.ba{r,z}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("rendered %d metrics, want 2", len(metrics))
+	}
+	if m := metrics[0]; m.Name != "foo.bar" || m.StartTime != 60 || m.StepTime != 60 || len(m.Values) != 6 {
+		t.Errorf("unexpected constant series %+v", m)
+	}
+	if m := metrics[1]; m.Name != "foo.baz" || len(m.Values) != 2 {
+		t.Errorf("unexpected fixed series %+v", m)
+	}
+
+	names, err := b.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "foo.bar" {
+		t.Errorf("listed %v", names)
+	}
+
+	infos, err := b.Info(ctx, "foo.bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name != "foo.bar" {
+		t.Errorf("unexpected infos %+v", infos)
+	}
+
+	if got := s.Requests(RenderPath); got != 1 {
+		t.Errorf("counted %d render requests, want 1", got)
+	}
+}
+
+func TestServerFailures(t *testing.T) {
+	s := NewServer(ServerConfig{
+		Metrics: map[string]Data{"foo.bar": Constant(60, 1)},
+		Errors:  map[string]int{FindPath: http.StatusServiceUnavailable},
+	})
+	defer s.Close()
+
+	b, err := net.New(net.Config{Address: s.URL, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := b.Find(ctx, "foo.*"); err == nil {
+		t.Error("expected the find to fail")
+	}
+
+	s.SetError(FindPath, 0)
+	if _, err := b.Find(ctx, "foo.*"); err != nil {
+		t.Errorf("unexpected error after clearing it: %v", err)
+	}
+
+	s.SetLatency(time.Second)
+	if _, err := b.Render(ctx, 0, 60, []string{"foo.bar"}); err == nil {
+		t.Error("expected the render to time out")
+	}
+}
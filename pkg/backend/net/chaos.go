@@ -0,0 +1,78 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Chaos injects faults into the calls to a backend, to exercise the
+// timeout and merge paths of its callers in staging without breaking the
+// backend itself. Each fault happens to a call with its probability,
+// between 0 and 1.
+type Chaos struct {
+	// Latency delays calls by this much.
+	Latency            time.Duration
+	LatencyProbability float64
+	// ErrorProbability fails calls with ErrInjected before they are made.
+	ErrorProbability float64
+	// TruncateProbability cuts response bodies in half.
+	TruncateProbability float64
+	// ContentTypeProbability answers calls with a content type no decoder
+	// handles.
+	ContentTypeProbability float64
+}
+
+// ErrInjected is the error of the calls Chaos fails.
+var ErrInjected = errors.New("injected fault")
+
+// contentTypeChaos is the content type Chaos replaces the right one with.
+const contentTypeChaos = "text/html"
+
+func happens(probability float64) bool {
+	return probability > 0 && rand.Float64() < probability
+}
+
+// before delays or fails a call about to be made.
+func (c *Chaos) before(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Latency > 0 && happens(c.LatencyProbability) {
+		timer := time.NewTimer(c.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if happens(c.ErrorProbability) {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// after mangles the response of a call, read into buf, and returns the
+// content type the call is answered with.
+func (c *Chaos) after(contentType string, buf *bytes.Buffer) string {
+	if c == nil {
+		return contentType
+	}
+
+	if happens(c.TruncateProbability) {
+		buf.Truncate(buf.Len() / 2)
+	}
+
+	if happens(c.ContentTypeProbability) {
+		return contentTypeChaos
+	}
+
+	return contentType
+}
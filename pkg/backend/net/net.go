@@ -1,9 +1,19 @@
 // Package net implements a backend that communicates over a network.
 // It uses HTTP and protocol buffers for communication.
+//
+// Carbonservers are spoken to in version 2 of the carbonapi protocol. Other
+// zippers can be spoken to in version 3, which they serve under /api/v3/,
+// and backends can be left to negotiate it: those announcing their
+// capabilities at /capabilities/ are spoken to in the richest version they
+// support, the others are tried in version 3 and stay with version 2 if
+// they turn out not to serve it.
 package net
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -11,13 +21,19 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/bloom"
+	"github.com/bookingcom/carbonapi/pkg/inflight"
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
 	"github.com/bookingcom/carbonapi/util"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -30,10 +46,41 @@ type Backend struct {
 	limiter chan struct{}
 	logger  *zap.Logger
 
+	// protocol is one of protoV2, protoV3 or protoAuto, the last until the
+	// backend has answered a version 3 request. Backends configured with
+	// protoAuto get theirs from their capabilities, when they announce them.
+	protocol   *int32
+	configured int32
+
 	tlds  map[string]struct{}
+	caps  types.Capabilities
 	mutex *sync.Mutex
+
+	// paths holds the metrics of the backend and their parents, when
+	// falsePositiveRate is set and the backend lists its metrics.
+	paths             *bloom.Filter
+	falsePositiveRate float64
+
+	chaos     *Chaos
+	rewrite   *Rewrite
+	durations prometheus.Histogram
 }
 
+// The protocols backends can be spoken to in.
+const (
+	ProtocolV2   = "v2"
+	ProtocolV3   = "v3"
+	ProtocolAuto = "auto" // Version 3, falling back to version 2.
+)
+
+const (
+	protoV2 int32 = iota
+	protoV3
+	protoAuto
+)
+
+const contentTypeV3 = "application/x-carbonapi-v3-pb"
+
 // Config configures an HTTP backend.
 //
 // The only required field is Address, which must be of the form
@@ -47,8 +94,26 @@ type Config struct {
 	Timeout time.Duration // Set request timeout. Defaults to no timeout.
 	Limit   int           // Set limit of concurrent requests to backend. Defaults to no limit.
 	Logger  *zap.Logger   // Logger to use. Defaults to a no-op logger.
+	// Protocol is one of ProtocolV2, ProtocolV3 or ProtocolAuto. Defaults
+	// to ProtocolV2.
+	Protocol string
+	// Chaos injects faults into the calls to the backend. Only for testing.
+	Chaos *Chaos
+	// Rewrite maps the paths asked of the backend to those it stores its
+	// metrics under, and the paths it answers back. Defaults to none.
+	Rewrite *Rewrite
+	// Durations observes how long calls take, in seconds.
+	Durations prometheus.Histogram
+	// FalsePositiveRate, when set, makes Probe list the metrics of the
+	// backend into a bloom filter that Contains looks targets up in. It is
+	// the rate of targets the backend doesn't have that Contains reports
+	// it has. Defaults to looking up only the top-level domains.
+	FalsePositiveRate float64
 }
 
+// listTimeout is how long Probe waits for the backend to list its metrics.
+const listTimeout = time.Minute
+
 var fmtProto = []string{"protobuf"}
 
 // New creates a new backend from the given configuration.
@@ -65,6 +130,19 @@ func New(cfg Config) (*Backend, error) {
 	b.address = address
 	b.scheme = scheme
 
+	proto := protoV2
+	switch cfg.Protocol {
+	case "", ProtocolV2:
+	case ProtocolV3:
+		proto = protoV3
+	case ProtocolAuto:
+		proto = protoAuto
+	default:
+		return nil, errors.Errorf("unknown protocol '%s'", cfg.Protocol)
+	}
+	b.protocol = &proto
+	b.configured = proto
+
 	if cfg.Timeout > 0 {
 		b.timeout = cfg.Timeout
 	} else {
@@ -87,6 +165,11 @@ func New(cfg Config) (*Backend, error) {
 		b.logger = zap.New(nil)
 	}
 
+	b.chaos = cfg.Chaos
+	b.rewrite = cfg.Rewrite
+	b.durations = cfg.Durations
+	b.falsePositiveRate = cfg.FalsePositiveRate
+
 	return b, nil
 }
 
@@ -169,8 +252,30 @@ func (b Backend) request(ctx context.Context, u *url.URL, body io.Reader) (*http
 }
 
 func (b Backend) do(ctx context.Context, req *http.Request) (string, []byte, error) {
+	buf := new(bytes.Buffer)
+	contentType, err := b.doInto(ctx, req, buf)
+
+	return contentType, buf.Bytes(), err
+}
+
+// doInto is do reading the response body into buf, which lets callers that
+// decode the body right away reuse their buffers.
+func (b Backend) doInto(ctx context.Context, req *http.Request, buf *bytes.Buffer) (string, error) {
+	query := inflight.FromContext(ctx)
+	defer query.Call(b.address)()
+
+	if b.durations != nil {
+		defer func(t0 time.Time) {
+			b.durations.Observe(time.Since(t0).Seconds())
+		}(time.Now())
+	}
+
+	if err := b.chaos.before(ctx); err != nil {
+		return "", err
+	}
+
 	if err := b.enter(ctx); err != nil {
-		return "", nil, err
+		return "", err
 	}
 
 	resp, err := b.client.Do(req)
@@ -178,7 +283,7 @@ func (b Backend) do(ctx context.Context, req *http.Request) (string, []byte, err
 		if resp != nil && resp.Body != nil {
 			resp.Body.Close()
 		}
-		return "", nil, err
+		return "", err
 	}
 
 	if err := b.leave(); err != nil {
@@ -189,17 +294,27 @@ func (b Backend) do(ctx context.Context, req *http.Request) (string, []byte, err
 		)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	if resp.ContentLength > 0 {
+		buf.Grow(int(resp.ContentLength))
+	}
+	_, err = buf.ReadFrom(query.Counting(resp.Body))
 	resp.Body.Close()
 	if err != nil {
-		return "", nil, err
+		return "", err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", body, errors.Errorf("Bad response code %d", resp.StatusCode)
+		return "", &backend.Error{Kind: backend.KindOfCode(resp.StatusCode), Err: badResponseCode(resp.StatusCode)}
 	}
 
-	return resp.Header.Get("Content-Type"), body, nil
+	return b.chaos.after(resp.Header.Get("Content-Type"), buf), nil
+}
+
+// badResponseCode is the error of calls answered with other than 200 OK.
+type badResponseCode int
+
+func (c badResponseCode) Error() string {
+	return fmt.Sprintf("Bad response code %d", int(c))
 }
 
 // Call makes a call to a backend.
@@ -218,14 +333,140 @@ func (b Backend) call(ctx context.Context, u *url.URL, body io.Reader) (string,
 	return b.do(ctx, req)
 }
 
-// Probe performs a single update of the backend's top-level domains.
+// callInto is call reading the response body into buf.
+func (b Backend) callInto(ctx context.Context, u *url.URL, body io.Reader, buf *bytes.Buffer) (string, error) {
+	ctx, cancel := b.setTimeout(ctx)
+	defer cancel()
+
+	req, err := b.request(ctx, u, body)
+	if err != nil {
+		return "", err
+	}
+
+	return b.doInto(ctx, req, buf)
+}
+
+// callV3Into POSTs a version 3 request to the backend, reading the response
+// body into buf.
+func (b Backend) callV3Into(ctx context.Context, path string, body []byte, buf *bytes.Buffer) (string, error) {
+	ctx, cancel := b.setTimeout(ctx)
+	defer cancel()
+
+	req, err := b.request(ctx, b.url(path), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Method = "POST"
+	req.Header.Set("Content-Type", contentTypeV3)
+
+	return b.doInto(ctx, req, buf)
+}
+
+// callProtocol makes a call in the protocol of the backend, v3 and v2
+// making it in either version. A backend still negotiating is tried in
+// version 3 first.
+func (b Backend) callProtocol(buf *bytes.Buffer, v3, v2 func() (string, error)) (string, error) {
+	if atomic.LoadInt32(b.protocol) == protoV2 {
+		return v2()
+	}
+
+	contentType, err := v3()
+	if !b.negotiate(err) {
+		return contentType, err
+	}
+	buf.Reset()
+
+	return v2()
+}
+
+// negotiate settles the protocol of a backend still negotiating after a
+// version 3 call that returned err. It tells if the call has to be made
+// again in version 2.
+func (b Backend) negotiate(err error) bool {
+	if atomic.LoadInt32(b.protocol) != protoAuto {
+		return false
+	}
+
+	if err == nil {
+		atomic.CompareAndSwapInt32(b.protocol, protoAuto, protoV3)
+		return false
+	}
+
+	// Servers without the version 3 handlers don't route the requests, or
+	// refuse to POST to them.
+	switch errors.Cause(err) {
+	case badResponseCode(http.StatusNotFound), badResponseCode(http.StatusMethodNotAllowed), badResponseCode(http.StatusNotImplemented):
+	default:
+		return false
+	}
+
+	if atomic.CompareAndSwapInt32(b.protocol, protoAuto, protoV2) {
+		b.logger.Info("Backend does not serve carbonapi_v3, using v2",
+			zap.String("host", b.address),
+			zap.Error(err),
+		)
+	}
+
+	return true
+}
+
+// Prewarm opens conns connections to the backend ahead of the first
+// requests, by making that many requests at once. The connections stay open
+// as long as the client keeps that many idle connections per host. Any HTTP
+// response counts; Prewarm fails only if connecting does.
+func (b Backend) Prewarm(ctx context.Context, conns int) error {
+	errs := make(chan error, conns)
+	for i := 0; i < conns; i++ {
+		go func() {
+			req, err := b.request(ctx, b.url("/"), nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			req.Method = "HEAD"
+
+			resp, err := b.client.Do(req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			errs <- nil
+		}()
+	}
+
+	var err error
+	for i := 0; i < conns; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Probe performs a single update of the backend's capabilities and
+// top-level domains, and of its paths filter if it has one.
 func (b *Backend) Probe() {
+	b.TryProbe()
+}
+
+// TryProbe is Probe, which tells if the backend failed to list its
+// top-level domains.
+func (b *Backend) TryProbe() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	matches, err := b.Find(ctx, "*")
+	announced := b.probeCapabilities(ctx)
+
+	if b.falsePositiveRate > 0 {
+		b.probePaths()
+	}
+
+	matches, contentType, err := b.find(ctx, "*")
 	if err != nil {
-		return
+		return err
 	}
 
 	tlds := make(map[string]struct{})
@@ -235,7 +476,128 @@ func (b *Backend) Probe() {
 
 	b.mutex.Lock()
 	b.tlds = tlds
+	// Backends that don't announce their capabilities at least support
+	// the format they answered in.
+	if format, ok := formats[contentType]; ok && !announced {
+		b.caps = types.Capabilities{Formats: []string{format}}
+	}
 	b.mutex.Unlock()
+
+	return nil
+}
+
+// probePaths rebuilds the bloom filter of the metrics of the backend and of
+// their parents. The previous one is kept if the backend fails to list
+// them.
+func (b *Backend) probePaths() {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	t0 := time.Now()
+	names, err := b.List(ctx)
+	if err != nil {
+		b.logger.Warn("Backend failed to list its metrics",
+			zap.String("host", b.address),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// Parents are shared by many metrics, they are counted once so that
+	// the filter isn't sized for far more elements than it holds.
+	parents := make(map[string]struct{})
+	for _, name := range names {
+		for i := strings.LastIndexByte(name, '.'); i > 0; i = strings.LastIndexByte(name[:i], '.') {
+			if _, ok := parents[name[:i]]; ok {
+				break
+			}
+			parents[name[:i]] = struct{}{}
+		}
+	}
+
+	paths := bloom.New(len(names)+len(parents), b.falsePositiveRate)
+	for _, name := range names {
+		paths.Add(name)
+	}
+	for parent := range parents {
+		paths.Add(parent)
+	}
+
+	b.mutex.Lock()
+	b.paths = paths
+	b.mutex.Unlock()
+
+	b.logger.Debug("Rebuilt the paths filter of backend",
+		zap.String("host", b.address),
+		zap.Int("paths", paths.Len()),
+		zap.Int("bytes", paths.Size()),
+		zap.Duration("runtime", time.Since(t0)),
+	)
+}
+
+// formats maps the content types of responses to their formats.
+var formats = map[string]string{
+	contentTypeV3:            types.FormatCarbonAPIv3,
+	"application/x-protobuf": types.FormatCarbonAPIv2,
+	"application/json":       types.FormatJSON,
+	"application/pickle":     types.FormatPickle,
+}
+
+// probeCapabilities asks the backend for its capabilities, and tells if it
+// announced them. Backends configured with ProtocolAuto are then spoken to
+// in the richest version they support. The others keep negotiating.
+func (b *Backend) probeCapabilities(ctx context.Context) bool {
+	u := b.url("/capabilities/")
+	u.RawQuery = url.Values{"format": []string{"json"}}.Encode()
+
+	contentType, resp, err := b.call(ctx, u, nil)
+	if err != nil || !strings.HasPrefix(contentType, "application/json") {
+		return false
+	}
+
+	var caps types.Capabilities
+	if err := json.Unmarshal(resp, &caps); err != nil || len(caps.Formats) == 0 {
+		b.logger.Warn("Backend announced bad capabilities",
+			zap.String("host", b.address),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	b.mutex.Lock()
+	b.caps = caps
+	b.mutex.Unlock()
+
+	if b.configured == protoAuto {
+		proto := protoV2
+		if caps.Supports(types.FormatCarbonAPIv3) {
+			proto = protoV3
+		}
+		atomic.StoreInt32(b.protocol, proto)
+	}
+
+	return true
+}
+
+// Capabilities returns what the backend was last found to support.
+func (b Backend) Capabilities() types.Capabilities {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.caps
+}
+
+// TLDs returns the top-level domains the backend was last probed for.
+func (b Backend) TLDs() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	tlds := make([]string, 0, len(b.tlds))
+	for tld := range b.tlds {
+		tlds = append(tlds, tld)
+	}
+
+	return tlds
 }
 
 // Contains reports whether the backend contains any of the given targets.
@@ -243,6 +605,10 @@ func (b Backend) Contains(targets []string) bool {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
+	if b.paths != nil {
+		return b.containsPaths(targets)
+	}
+
 	if len(b.tlds) == 0 {
 		return true
 	}
@@ -270,15 +636,85 @@ func (b Backend) Contains(targets []string) bool {
 	return false
 }
 
+// containsPaths looks targets up in the paths filter. Globs are looked up
+// by their segments before the first one with wildcards. Call with b.mutex
+// held.
+func (b Backend) containsPaths(targets []string) bool {
+	for _, target := range targets {
+		prefix := target
+		if i := strings.IndexAny(target, "*?{}[]"); i >= 0 {
+			prefix = target[:i]
+			if j := strings.LastIndexByte(prefix, '.'); j >= 0 {
+				prefix = prefix[:j]
+			} else {
+				return true
+			}
+		}
+
+		if b.paths.Test(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Render fetches raw metrics from a backend.
 func (b Backend) Render(ctx context.Context, from int32, until int32, targets []string) ([]types.Metric, error) {
-	u := b.url("/render")
-	u, body := carbonapiV2RenderEncoder(u, from, until, targets)
+	if b.rewrite == nil {
+		return b.render(ctx, from, until, targets)
+	}
 
-	contentType, resp, err := b.call(ctx, u, body)
+	native := b.rewrite.outAll(targets)
+	if len(native) == 0 {
+		return nil, nil
+	}
+	metrics, err := b.render(ctx, from, until, native)
+	kept := metrics[:0]
+	for _, m := range metrics {
+		if name, ok := b.rewrite.in(m.Name); ok {
+			m.Name = name
+			kept = append(kept, m)
+		}
+	}
+
+	return kept, err
+}
+
+// render is Render of the paths the backend stores.
+func (b Backend) render(ctx context.Context, from int32, until int32, targets []string) ([]types.Metric, error) {
+	// The decoders copy what they keep, so the buffer can go back to the
+	// pool as soon as the response is decoded.
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	contentType, err := b.callProtocol(buf,
+		func() (string, error) {
+			// Backends that don't apply the filters send all the series,
+			// which Renders filters.
+			fs := backend.Filters(ctx)
+			if !b.Capabilities().SupportsFilters(fs) {
+				fs = nil
+			}
+			requests := make([]carbonapi_v3.FetchRequest, 0, len(targets))
+			for _, target := range targets {
+				requests = append(requests, carbonapi_v3.FetchRequest{Name: target, From: from, Until: until, Filters: fs})
+			}
+			body, err := carbonapi_v3.RenderRequestEncoder(requests)
+			if err != nil {
+				return "", err
+			}
+			return b.callV3Into(ctx, "/api/v3/render", body, buf)
+		},
+		func() (string, error) {
+			u, body := carbonapiV2RenderEncoder(b.url("/render"), from, until, targets)
+			return b.callInto(ctx, u, body, buf)
+		},
+	)
 	if err != nil {
 		return nil, errors.Wrap(err, "HTTP call failed")
 	}
+	resp := buf.Bytes()
 
 	var metrics []types.Metric
 
@@ -295,8 +731,8 @@ func (b Backend) Render(ctx context.Context, from int32, until int32, targets []
 	case "application/x-msgpack":
 		// TODO(gmagnusson)
 
-	case "application/x-carbonapi-v3-pb":
-		// TODO(gmagnusson)
+	case contentTypeV3:
+		metrics, err = carbonapi_v3.RenderDecoder(resp)
 
 	default:
 		return nil, errors.Errorf("Unknown content type '%s'", contentType)
@@ -323,13 +759,54 @@ func carbonapiV2RenderEncoder(u *url.URL, from int32, until int32, targets []str
 
 // Info fetches metadata about a metric from a backend.
 func (b Backend) Info(ctx context.Context, metric string) ([]types.Info, error) {
-	u := b.url("/info")
-	u, body := carbonapiV2InfoEncoder(u, metric)
+	if b.rewrite == nil {
+		return b.info(ctx, metric)
+	}
 
-	_, resp, err := b.call(ctx, u, body)
+	native, rule, ok := b.rewrite.out(metric)
+	if !ok {
+		return nil, nil
+	}
+	infos, err := b.info(ctx, native)
+	kept := infos[:0]
+	for _, info := range infos {
+		if name, ok := rule.in(info.Name); ok {
+			info.Name = name
+			kept = append(kept, info)
+		}
+	}
+
+	return kept, err
+}
+
+// info is Info of a path the backend stores.
+func (b Backend) info(ctx context.Context, metric string) ([]types.Info, error) {
+	buf := new(bytes.Buffer)
+	contentType, err := b.callProtocol(buf,
+		func() (string, error) {
+			body, err := carbonapi_v3.InfoRequestEncoder([]string{metric})
+			if err != nil {
+				return "", err
+			}
+			return b.callV3Into(ctx, "/api/v3/info", body, buf)
+		},
+		func() (string, error) {
+			u, body := carbonapiV2InfoEncoder(b.url("/info"), metric)
+			return b.callInto(ctx, u, body, buf)
+		},
+	)
 	if err != nil {
 		return nil, errors.Wrap(err, "HTTP call failed")
 	}
+	resp := buf.Bytes()
+
+	if contentType == contentTypeV3 {
+		infos, err := carbonapi_v3.InfoDecoder(resp)
+		if err != nil {
+			return nil, errors.Wrap(err, "Protobuf unmarshal failed")
+		}
+		return infos, nil
+	}
 
 	single, err := carbonapi_v2.IsInfoResponse(resp)
 	if err != nil {
@@ -362,13 +839,54 @@ func carbonapiV2InfoEncoder(u *url.URL, metric string) (*url.URL, io.Reader) {
 
 // Find resolves globs and finds metrics in a backend.
 func (b Backend) Find(ctx context.Context, query string) (types.Matches, error) {
-	u := b.url("/metrics/find")
-	u, body := carbonapiV2FindEncoder(u, query)
+	matches, _, err := b.find(ctx, query)
+
+	return matches, err
+}
+
+// find is Find also returning the content type of the response, empty if
+// the backend wasn't asked.
+func (b Backend) find(ctx context.Context, query string) (types.Matches, string, error) {
+	if b.rewrite == nil {
+		return b.findNative(ctx, query)
+	}
+
+	native, rule, ok := b.rewrite.out(query)
+	if !ok {
+		return types.Matches{Name: query, Matches: b.rewrite.parents(query)}, "", nil
+	}
+	matches, contentType, err := b.findNative(ctx, native)
+	kept := types.Matches{Name: query}
+	for _, m := range matches.Matches {
+		if p, ok := rule.in(m.Path); ok {
+			m.Path = p
+			kept.Matches = append(kept.Matches, m)
+		}
+	}
 
-	contentType, resp, err := b.call(ctx, u, body)
+	return kept, contentType, err
+}
+
+// findNative is find of a query for the paths the backend stores.
+func (b Backend) findNative(ctx context.Context, query string) (types.Matches, string, error) {
+	buf := new(bytes.Buffer)
+	contentType, err := b.callProtocol(buf,
+		func() (string, error) {
+			body, err := carbonapi_v3.FindRequestEncoder([]string{query})
+			if err != nil {
+				return "", err
+			}
+			return b.callV3Into(ctx, "/api/v3/find", body, buf)
+		},
+		func() (string, error) {
+			u, body := carbonapiV2FindEncoder(b.url("/metrics/find"), query)
+			return b.callInto(ctx, u, body, buf)
+		},
+	)
 	if err != nil {
-		return types.Matches{}, errors.Wrap(err, "HTTP call failed")
+		return types.Matches{}, "", errors.Wrap(err, "HTTP call failed")
 	}
+	resp := buf.Bytes()
 
 	var matches types.Matches
 
@@ -385,18 +903,23 @@ func (b Backend) Find(ctx context.Context, query string) (types.Matches, error)
 	case "application/x-msgpack":
 		// TODO(gmagnusson)
 
-	case "application/x-carbonapi-v3-pb":
-		// TODO(gmagnusson)
+	case contentTypeV3:
+		var all []types.Matches
+		all, err = carbonapi_v3.FindDecoder(resp)
+		matches.Name = query
+		if len(all) > 0 {
+			matches = all[0]
+		}
 
 	default:
-		return types.Matches{}, errors.Errorf("Unknown content type '%s'", contentType)
+		return types.Matches{}, contentType, errors.Errorf("Unknown content type '%s'", contentType)
 	}
 
 	if err != nil {
-		return matches, errors.Wrap(err, "Protobuf unmarshal failed")
+		return matches, contentType, errors.Wrap(err, "Protobuf unmarshal failed")
 	}
 
-	return matches, nil
+	return matches, contentType, nil
 }
 
 func carbonapiV2FindEncoder(u *url.URL, query string) (*url.URL, io.Reader) {
@@ -408,3 +931,70 @@ func carbonapiV2FindEncoder(u *url.URL, query string) (*url.URL, io.Reader) {
 
 	return u, nil
 }
+
+// List fetches the names of all metrics a backend has.
+func (b Backend) List(ctx context.Context) ([]string, error) {
+	u := b.url("/metrics/list/")
+	vals := url.Values{
+		"format": fmtProto,
+	}
+	u.RawQuery = vals.Encode()
+
+	_, resp, err := b.call(ctx, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "HTTP call failed")
+	}
+
+	metrics, err := carbonapi_v2.ListDecoder(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "Protobuf unmarshal failed")
+	}
+
+	if b.rewrite != nil {
+		kept := metrics[:0]
+		for _, name := range metrics {
+			if name, ok := b.rewrite.in(name); ok {
+				kept = append(kept, name)
+			}
+		}
+		metrics = kept
+	}
+
+	return metrics, nil
+}
+
+// Tombstones fetches the metrics a backend deleted recently. Backends that
+// don't serve them have none.
+func (b Backend) Tombstones(ctx context.Context) ([]string, error) {
+	u := b.url("/metrics/tombstones/")
+	vals := url.Values{
+		"format": fmtProto,
+	}
+	u.RawQuery = vals.Encode()
+
+	_, resp, err := b.call(ctx, u, nil)
+	if err != nil {
+		switch errors.Cause(err) {
+		case badResponseCode(http.StatusNotFound), badResponseCode(http.StatusNotImplemented):
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "HTTP call failed")
+	}
+
+	metrics, err := carbonapi_v2.ListDecoder(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "Protobuf unmarshal failed")
+	}
+
+	if b.rewrite != nil {
+		kept := metrics[:0]
+		for _, name := range metrics {
+			if name, ok := b.rewrite.in(name); ok {
+				kept = append(kept, name)
+			}
+		}
+		metrics = kept
+	}
+
+	return metrics, nil
+}
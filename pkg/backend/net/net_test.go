@@ -4,12 +4,25 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestAddress(t *testing.T) {
@@ -85,6 +98,108 @@ func TestContains(t *testing.T) {
 	}
 }
 
+func TestContainsPaths(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics/list/" {
+			http.NotFound(w, r)
+			return
+		}
+		blob, err := carbonapi_v2.ListEncoder([]string{"foo.bar.baz", "foo.qux"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:           server.URL,
+		Client:            server.Client(),
+		FalsePositiveRate: 0.0001,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Probe()
+
+	tests := []struct {
+		targets []string
+		want    bool
+	}{
+		{[]string{"foo.bar.baz"}, true},
+		{[]string{"foo.bar"}, true},
+		{[]string{"foo"}, true},
+		{[]string{"foo.bar.qux"}, false},
+		{[]string{"bar"}, false},
+		{[]string{"bar", "foo.qux"}, true},
+		{[]string{"foo.*.baz"}, true},
+		{[]string{"foo.b{a,o}r"}, true},
+		{[]string{"bar.*"}, false},
+		{[]string{"*.bar"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := b.Contains(tt.targets); got != tt.want {
+			t.Errorf("Expected Contains(%v) to be %v, got %v", tt.targets, tt.want, got)
+		}
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics/tombstones/" {
+			http.NotFound(w, r)
+			return
+		}
+		blob, err := carbonapi_v2.ListEncoder([]string{"foo.bar", "foo.baz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.Tombstones(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"foo.bar", "foo.baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected tombstones %v, got %v", want, got)
+	}
+}
+
+func TestTombstonesNotServed(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.Tombstones(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error from a backend without tombstones, got %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected no tombstones, got %v", got)
+	}
+}
+
 func TestCall(t *testing.T) {
 	exp := []byte("OK")
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -211,6 +326,39 @@ func TestDo(t *testing.T) {
 	}
 }
 
+func TestDoDurations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	durations := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"})
+	b, err := New(Config{
+		Address:   strings.TrimPrefix(server.URL, "http://"),
+		Client:    server.Client(),
+		Durations: durations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := b.request(context.Background(), b.url("/render"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.do(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	var m dto.Metric
+	if err := durations.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.GetHistogram().GetSampleCount(); n != 1 {
+		t.Errorf("Expected the call to be observed, got %d observations", n)
+	}
+}
+
 func TestDoHTTPTimeout(t *testing.T) {
 	d := time.Nanosecond
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -462,3 +610,571 @@ func TestCarbonapiv2FindEncoder(t *testing.T) {
 	}
 
 }
+
+func BenchmarkRender(b *testing.B) {
+	metrics := make([]types.Metric, 100)
+	for i := range metrics {
+		metrics[i] = types.Metric{
+			Name:     fmt.Sprintf("servers.host%04d.cpu.user", i),
+			StopTime: 1440 * 60,
+			StepTime: 60,
+			Values:   make([]float64, 1440),
+			IsAbsent: make([]bool, 1440),
+		}
+	}
+	blob, err := carbonapi_v2.RenderEncoder(metrics)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	bk, err := New(Config{Address: server.URL})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := bk.Render(context.Background(), 0, 1440*60, []string{"servers.*.cpu.user"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	var mu sync.Mutex
+	conns := make(map[string]bool)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the requests so each needs its own connection.
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns[c.RemoteAddr().String()] = true
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Prewarm(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) != 4 {
+		t.Errorf("Expected 4 connections, got %d", len(conns))
+	}
+
+	closed, err := New(Config{Address: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Prewarm(context.Background(), 1); err == nil {
+		t.Error("Expected an error when the backend can't be reached")
+	}
+}
+
+func TestProtocolV3(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var blob []byte
+		switch r.URL.Path {
+		case "/api/v3/find":
+			globs, _ := carbonapi_v3.FindRequestDecoder(body)
+			blob, _ = carbonapi_v3.FindEncoder([]types.Matches{{Name: globs[0], Matches: []types.Match{{Path: "foo.bar", IsLeaf: true}}}})
+		case "/api/v3/render":
+			requests, _ := carbonapi_v3.RenderRequestDecoder(body)
+			blob, _ = carbonapi_v3.RenderEncoder([]types.Metric{{
+				Name:      requests[0].Name,
+				StartTime: requests[0].From,
+				StopTime:  requests[0].Until,
+				StepTime:  60,
+				Values:    []float64{1, 0},
+				IsAbsent:  []bool{false, true},
+			}})
+		case "/api/v3/info":
+			names, _ := carbonapi_v3.InfoRequestDecoder(body)
+			blob, _ = carbonapi_v3.InfoEncoder([]types.Info{{Host: "store", Name: names[0]}})
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-carbonapi-v3-pb")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolV3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := b.Find(context.Background(), "foo.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches.Name != "foo.*" || len(matches.Matches) != 1 || matches.Matches[0].Path != "foo.bar" {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+
+	metrics, err := b.Render(context.Background(), 0, 120, []string{"foo.bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "foo.bar" || metrics[0].StopTime != 120 || !metrics[0].IsAbsent[1] {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+
+	infos, err := b.Info(context.Background(), "foo.bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Host != "store" || infos[0].Name != "foo.bar" {
+		t.Errorf("unexpected infos %+v", infos)
+	}
+
+	expected := []string{"POST /api/v3/find", "POST /api/v3/render", "POST /api/v3/info"}
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("expected requests %v, got %v", expected, paths)
+	}
+}
+
+func TestRenderFilters(t *testing.T) {
+	fs := []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"5"}}}
+	tests := []struct {
+		name      string
+		announced string
+		pushed    bool
+	}{
+		{"supported", `{"formats":["carbonapi_v3_pb"],"filterFunctions":["highestAverage","highestMax"]}`, true},
+		{"unsupported", `{"formats":["carbonapi_v3_pb"],"filterFunctions":["highestMax"]}`, false},
+		{"not announced", `{"formats":["carbonapi_v3_pb"]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []types.FilterFunction
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/capabilities/":
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(tt.announced))
+				case "/api/v3/render":
+					body, _ := ioutil.ReadAll(r.Body)
+					requests, _ := carbonapi_v3.RenderRequestDecoder(body)
+					got = requests[0].Filters
+					w.Header().Set("Content-Type", "application/x-carbonapi-v3-pb")
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			b, err := New(Config{
+				Address:  server.URL,
+				Client:   server.Client(),
+				Protocol: ProtocolAuto,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Probe()
+
+			if _, err := b.Render(backend.WithFilters(context.Background(), fs), 0, 60, []string{"foo.*"}); err != nil {
+				t.Fatal(err)
+			}
+			if pushed := reflect.DeepEqual(got, fs); pushed != tt.pushed {
+				t.Errorf("expected the filters pushed %v, got %+v", tt.pushed, got)
+			}
+		})
+	}
+}
+
+func TestProtocolAutoFallsBack(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/metrics/find" {
+			http.NotFound(w, r)
+			return
+		}
+		blob, _ := carbonapi_v2.FindEncoder(types.Matches{Name: "foo", Matches: []types.Match{{Path: "foo"}}})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolAuto,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		matches, err := b.Find(context.Background(), "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches.Matches) != 1 {
+			t.Errorf("unexpected matches %+v", matches)
+		}
+	}
+
+	expected := []string{"/api/v3/find", "/metrics/find", "/metrics/find"}
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("expected requests %v, got %v", expected, paths)
+	}
+}
+
+func TestProtocolAutoKeepsV3(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail || r.URL.Path != "/api/v3/find" {
+			http.NotFound(w, r)
+			return
+		}
+		blob, _ := carbonapi_v3.FindEncoder([]types.Matches{{Name: "foo"}})
+		w.Header().Set("Content-Type", "application/x-carbonapi-v3-pb")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolAuto,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Find(context.Background(), "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once a backend served version 3, its errors are not taken for a
+	// lack of support.
+	fail = true
+	if _, err := b.Find(context.Background(), "foo"); err == nil {
+		t.Error("expected an error")
+	}
+	if atomic.LoadInt32(b.protocol) != protoV3 {
+		t.Error("expected the backend to stay with version 3")
+	}
+}
+
+func TestUnknownProtocol(t *testing.T) {
+	if _, err := New(Config{Address: "localhost", Protocol: "v4"}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	tests := []struct {
+		name      string
+		announced string // JSON capabilities, or none
+		v3        bool
+		protocol  int32
+		formats   []string
+		paths     []string
+	}{
+		{
+			name:      "announces v3",
+			announced: `{"name":"zipper","formats":["carbonapi_v3_pb","carbonapi_v2_pb"]}`,
+			v3:        true,
+			protocol:  protoV3,
+			formats:   []string{"carbonapi_v3_pb", "carbonapi_v2_pb"},
+			paths:     []string{"/capabilities/", "/api/v3/find"},
+		},
+		{
+			name:      "announces v2",
+			announced: `{"formats":["carbonapi_v2_pb"]}`,
+			protocol:  protoV2,
+			formats:   []string{"carbonapi_v2_pb"},
+			paths:     []string{"/capabilities/", "/metrics/find"},
+		},
+		{
+			name:     "sniffed",
+			protocol: protoV2,
+			formats:  []string{"carbonapi_v2_pb"},
+			paths:    []string{"/capabilities/", "/api/v3/find", "/metrics/find"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				switch {
+				case r.URL.Path == "/capabilities/" && tt.announced != "":
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(tt.announced))
+				case r.URL.Path == "/api/v3/find" && tt.v3:
+					blob, _ := carbonapi_v3.FindEncoder([]types.Matches{{Name: "*", Matches: []types.Match{{Path: "foo"}}}})
+					w.Header().Set("Content-Type", "application/x-carbonapi-v3-pb")
+					w.Write(blob)
+				case r.URL.Path == "/metrics/find":
+					blob, _ := carbonapi_v2.FindEncoder(types.Matches{Name: "*", Matches: []types.Match{{Path: "foo"}}})
+					w.Header().Set("Content-Type", "application/x-protobuf")
+					w.Write(blob)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			b, err := New(Config{
+				Address:  server.URL,
+				Client:   server.Client(),
+				Protocol: ProtocolAuto,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b.Probe()
+
+			if got := atomic.LoadInt32(b.protocol); got != tt.protocol {
+				t.Errorf("expected protocol %d, got %d", tt.protocol, got)
+			}
+			if got := b.Capabilities().Formats; fmt.Sprint(got) != fmt.Sprint(tt.formats) {
+				t.Errorf("expected formats %v, got %v", tt.formats, got)
+			}
+			if fmt.Sprint(paths) != fmt.Sprint(tt.paths) {
+				t.Errorf("expected requests %v, got %v", tt.paths, paths)
+			}
+			if !b.Contains([]string{"foo.bar"}) || b.Contains([]string{"bar.foo"}) {
+				t.Error("expected the top-level domains to be probed")
+			}
+		})
+	}
+}
+
+func TestProbeCapabilitiesKeepsConfiguredProtocol(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/capabilities/" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"formats":["carbonapi_v3_pb"]}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Probe()
+
+	if atomic.LoadInt32(b.protocol) != protoV2 {
+		t.Error("expected the configured protocol to be kept")
+	}
+	if !b.Capabilities().Supports(types.FormatCarbonAPIv3) {
+		t.Error("expected the capabilities to be recorded")
+	}
+}
+
+func TestChaos(t *testing.T) {
+	exp := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(exp)
+	}))
+	defer server.Close()
+
+	newBackend := func(c *Chaos) *Backend {
+		b, err := New(Config{
+			Address: strings.TrimPrefix(server.URL, "http://"),
+			Client:  server.Client(),
+			Chaos:   c,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	do := func(ctx context.Context, b *Backend) (string, []byte, error) {
+		req, err := b.request(ctx, b.url("/render"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.do(ctx, req)
+	}
+
+	contentType, got, err := do(context.Background(), newBackend(&Chaos{}))
+	if err != nil || contentType != "application/x-protobuf" || !bytes.Equal(got, exp) {
+		t.Errorf("Expected no faults without probabilities, got %q %q %v", contentType, got, err)
+	}
+
+	if _, _, err := do(context.Background(), newBackend(&Chaos{ErrorProbability: 1})); err != ErrInjected {
+		t.Errorf("Expected an injected error, got %v", err)
+	}
+
+	if _, got, _ := do(context.Background(), newBackend(&Chaos{TruncateProbability: 1})); !bytes.Equal(got, exp[:len(exp)/2]) {
+		t.Errorf("Expected a truncated body, got %q", got)
+	}
+
+	if contentType, _, _ := do(context.Background(), newBackend(&Chaos{ContentTypeProbability: 1})); contentType != contentTypeChaos {
+		t.Errorf("Expected a wrong content type, got %q", contentType)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := do(ctx, newBackend(&Chaos{Latency: time.Minute, LatencyProbability: 1})); err != context.DeadlineExceeded {
+		t.Errorf("Expected the injected latency to run into the timeout, got %v", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	var asked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var blob []byte
+		switch r.URL.Path {
+		case "/api/v3/find":
+			globs, _ := carbonapi_v3.FindRequestDecoder(body)
+			asked = append(asked, globs[0])
+			blob, _ = carbonapi_v3.FindEncoder([]types.Matches{{Name: globs[0], Matches: []types.Match{
+				{Path: "servers.foo", IsLeaf: true},
+				{Path: "other.foo", IsLeaf: true},
+			}}})
+		case "/api/v3/render":
+			requests, _ := carbonapi_v3.RenderRequestDecoder(body)
+			var metrics []types.Metric
+			for _, req := range requests {
+				asked = append(asked, req.Name)
+				metrics = append(metrics, types.Metric{Name: req.Name, StepTime: 60})
+			}
+			blob, _ = carbonapi_v3.RenderEncoder(metrics)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-carbonapi-v3-pb")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	rewrite, err := NewRewrite([]RewriteRule{{StripPrefix: "dc1.east."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolV3,
+		Rewrite:  rewrite,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := b.Find(context.Background(), "dc1.east.*.foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.Matches{Name: "dc1.east.*.foo", Matches: []types.Match{
+		{Path: "dc1.east.servers.foo", IsLeaf: true},
+		{Path: "dc1.east.other.foo", IsLeaf: true},
+	}}
+	if fmt.Sprint(matches) != fmt.Sprint(expected) {
+		t.Errorf("expected matches %+v, got %+v", expected, matches)
+	}
+
+	// The backend doesn't have the parents of the prefix.
+	matches, err = b.Find(context.Background(), "dc*.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches.Matches) != 1 || matches.Matches[0] != (types.Match{Path: "dc1.east"}) {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+
+	metrics, err := b.Render(context.Background(), 0, 60, []string{"dc1.east.servers.foo", "dc2.servers.foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "dc1.east.servers.foo" {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+
+	if expected := []string{"*.foo", "servers.foo"}; fmt.Sprint(asked) != fmt.Sprint(expected) {
+		t.Errorf("expected the backend asked for %v, got %v", expected, asked)
+	}
+}
+
+func TestRewriteRules(t *testing.T) {
+	rw, err := NewRewrite([]RewriteRule{
+		{Regex: `^servers\.(\w+)\.cpu$`, Replacement: "hosts.$1.cpu.total", ReverseRegex: `^hosts\.(\w+)\.cpu\.total$`, ReverseReplacement: "servers.$1.cpu"},
+		{AddPrefix: "dc1."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, native string
+	}{
+		{"servers.web1.cpu", "hosts.web1.cpu.total"},
+		{"servers.web1.mem", "dc1.servers.web1.mem"},
+	}
+	for _, tt := range tests {
+		native, _, ok := rw.out(tt.path)
+		if !ok || native != tt.native {
+			t.Errorf("expected %s to map to %s, got %s", tt.path, tt.native, native)
+		}
+		if p, ok := rw.in(native); !ok || p != tt.path {
+			t.Errorf("expected %s to map back to %s, got %s", native, tt.path, p)
+		}
+	}
+	if p, ok := rw.in("dc2.servers.web1.mem"); ok {
+		t.Errorf("expected a path no rule maps back to be dropped, got %s", p)
+	}
+
+	for _, rules := range [][]RewriteRule{
+		{{}},
+		{{StripPrefix: "a.", AddPrefix: "b."}},
+		{{Regex: "a", Replacement: "b"}},
+		{{Regex: "(", ReverseRegex: "a"}},
+	} {
+		if _, err := NewRewrite(rules); err == nil {
+			t.Errorf("expected rules %+v to be invalid", rules)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package net
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/pkg/errors"
+)
+
+// Rewrite maps the paths asked of a backend to those it stores its metrics
+// under, and the paths of its responses back, so that clusters keeping
+// their metrics under different roots can be federated. A backend with a
+// Rewrite has only the paths its rules map.
+type Rewrite struct {
+	rules []rewriteRule
+}
+
+// RewriteRule is a mapping of a Rewrite. Exactly one of StripPrefix,
+// AddPrefix and Regex is set.
+type RewriteRule struct {
+	// StripPrefix is cut off the paths asked of the backend, which has
+	// only those starting with it, and put back on the paths it answers.
+	StripPrefix string
+	// AddPrefix is put on the paths asked of the backend, and cut off the
+	// paths it answers. Those without it are dropped.
+	AddPrefix string
+	// Regex rewrites the paths asked of the backend it matches with
+	// Replacement, and ReverseRegex the paths it answers with
+	// ReverseReplacement, as regexp.ReplaceAllString does. The answered
+	// paths ReverseRegex doesn't match are dropped.
+	Regex              string
+	Replacement        string
+	ReverseRegex       string
+	ReverseReplacement string
+}
+
+type rewriteRule struct {
+	RewriteRule
+	regex   *regexp.Regexp
+	reverse *regexp.Regexp
+}
+
+// NewRewrite returns the Rewrite of rules, which map a path by the first of
+// them that matches it.
+func NewRewrite(rules []RewriteRule) (*Rewrite, error) {
+	rw := &Rewrite{rules: make([]rewriteRule, 0, len(rules))}
+	for i, r := range rules {
+		set := 0
+		for _, s := range []string{r.StripPrefix, r.AddPrefix, r.Regex} {
+			if s != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, errors.Errorf("rewrite rule %d needs exactly one of a prefix to strip, a prefix to add or a regex", i)
+		}
+
+		rule := rewriteRule{RewriteRule: r}
+		if r.Regex != "" {
+			if r.ReverseRegex == "" {
+				return nil, errors.Errorf("rewrite rule %d needs a reverse regex to map responses back", i)
+			}
+			var err error
+			if rule.regex, err = regexp.Compile(r.Regex); err != nil {
+				return nil, errors.Wrapf(err, "rewrite rule %d", i)
+			}
+			if rule.reverse, err = regexp.Compile(r.ReverseRegex); err != nil {
+				return nil, errors.Wrapf(err, "rewrite rule %d", i)
+			}
+		}
+		rw.rules = append(rw.rules, rule)
+	}
+
+	return rw, nil
+}
+
+// out returns the path the backend has p under, and the rule that maps it,
+// if any does.
+func (rw *Rewrite) out(p string) (string, *rewriteRule, bool) {
+	for i := range rw.rules {
+		if q, ok := rw.rules[i].out(p); ok {
+			return q, &rw.rules[i], true
+		}
+	}
+
+	return "", nil, false
+}
+
+// outAll returns the paths the backend has ps under, without those no rule
+// maps.
+func (rw *Rewrite) outAll(ps []string) []string {
+	out := make([]string, 0, len(ps))
+	for _, p := range ps {
+		if q, _, ok := rw.out(p); ok {
+			out = append(out, q)
+		}
+	}
+
+	return out
+}
+
+// in maps p, answered by the backend, back by the first rule that can.
+func (rw *Rewrite) in(p string) (string, bool) {
+	for _, r := range rw.rules {
+		if q, ok := r.in(p); ok {
+			return q, true
+		}
+	}
+
+	return "", false
+}
+
+// parents answers a find for the parents of the prefixes the rules strip,
+// which the backend doesn't have, so that they can be browsed down to.
+func (rw *Rewrite) parents(query string) []types.Match {
+	segments := strings.Split(query, ".")
+
+	var matches []types.Match
+	seen := make(map[string]bool)
+	for _, r := range rw.rules {
+		if r.StripPrefix == "" {
+			continue
+		}
+		prefix := strings.Split(strings.TrimSuffix(r.StripPrefix, "."), ".")
+		if len(segments) > len(prefix) {
+			continue
+		}
+
+		matched := true
+		for i, s := range segments {
+			if ok, _ := path.Match(s, prefix[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		p := strings.Join(prefix[:len(segments)], ".")
+		if matched && !seen[p] {
+			seen[p] = true
+			matches = append(matches, types.Match{Path: p})
+		}
+	}
+
+	return matches
+}
+
+func (r *rewriteRule) out(p string) (string, bool) {
+	switch {
+	case r.StripPrefix != "":
+		if !strings.HasPrefix(p, r.StripPrefix) {
+			return "", false
+		}
+		return p[len(r.StripPrefix):], true
+	case r.AddPrefix != "":
+		return r.AddPrefix + p, true
+	}
+
+	if !r.regex.MatchString(p) {
+		return "", false
+	}
+	return r.regex.ReplaceAllString(p, r.Replacement), true
+}
+
+func (r *rewriteRule) in(p string) (string, bool) {
+	switch {
+	case r.StripPrefix != "":
+		return r.StripPrefix + p, true
+	case r.AddPrefix != "":
+		if !strings.HasPrefix(p, r.AddPrefix) {
+			return "", false
+		}
+		return p[len(r.AddPrefix):], true
+	}
+
+	if !r.reverse.MatchString(p) {
+		return "", false
+	}
+	return r.reverse.ReplaceAllString(p, r.ReverseReplacement), true
+}
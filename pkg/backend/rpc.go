@@ -12,18 +12,23 @@ backends:
 
     var bs []Backend
     metrics, err := Renders(ctx, bs, from, until, targets)
+
+How the requests to several backends are scheduled is set per request:
+
+    ctx = WithFanOut(ctx, FanOut{Stagger: 10 * time.Millisecond})
 */
 package backend
 
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/bookingcom/carbonapi/pkg/filter"
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/util"
 
-	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -38,6 +43,22 @@ type Backend interface {
 	Probe()                 // Probe updates internal state of the backend.
 }
 
+// Prober is implemented by backends whose probes tell if they failed.
+type Prober interface {
+	TryProbe() error
+}
+
+// Lister is implemented by backends that can enumerate all their metrics.
+type Lister interface {
+	List(context.Context) ([]string, error)
+}
+
+// Tombstoner is implemented by backends that list the metrics they deleted
+// recently, which their finds may still return for a while.
+type Tombstoner interface {
+	Tombstones(context.Context) ([]string, error)
+}
+
 // TODO(gmagnusson): ^ Remove IsAbsent: IsAbsent[i] => Values[i] == NaN
 // Doing math on NaN is expensive, but assuming that all functions will treat a
 // default value of 0 intelligently is wrong (see multiplication). Thus math
@@ -50,35 +71,36 @@ func Renders(ctx context.Context, backends []Backend, from int32, until int32, t
 		return nil, nil
 	}
 
-	msgCh := make(chan []types.Metric, len(backends))
-	errCh := make(chan error, len(backends))
-	for _, backend := range backends {
-		go func(b Backend) {
-			msg, err := b.Render(ctx, from, until, targets)
-			if err != nil {
-				errCh <- err
-			} else {
-				msgCh <- msg
-			}
-		}(backend)
-	}
-
+	limit := pointLimit(ctx)
+	var points pointCounter
 	msgs := make([][]types.Metric, 0, len(backends))
-	errs := make([]error, 0, len(backends))
-	for i := 0; i < len(backends); i++ {
-		select {
-		case msg := <-msgCh:
-			msgs = append(msgs, msg)
-		case err := <-errCh:
-			errs = append(errs, err)
-		}
+	errs, err := fanOut(ctx, backends,
+		func(ctx context.Context, b Backend) (interface{}, error) {
+			return b.Render(ctx, from, until, targets)
+		},
+		func(msg interface{}) (bool, error) {
+			m := msg.([]types.Metric)
+			if n := points.add(m); limit > 0 && n > limit {
+				return false, &TooLargeError{Points: n, Limit: limit}
+			}
+			msgs = append(msgs, m)
+			return len(m) > 0, nil
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := checkErrs(ctx, errs, len(backends), backends[0].Logger()); err != nil {
 		return nil, err
 	}
 
-	return types.MergeMetrics(msgs), nil
+	metrics := types.MergeMetrics(msgs)
+	if fs := Filters(ctx); len(fs) > 0 {
+		metrics = filter.Apply(metrics, fs)
+	}
+
+	return metrics, nil
 }
 
 // Infos makes Info calls to multiple backends.
@@ -87,29 +109,17 @@ func Infos(ctx context.Context, backends []Backend, metric string) ([]types.Info
 		return nil, nil
 	}
 
-	msgCh := make(chan []types.Info, len(backends))
-	errCh := make(chan error, len(backends))
-	for _, backend := range backends {
-		go func(b Backend) {
-			msg, err := b.Info(ctx, metric)
-			if err != nil {
-				errCh <- err
-			} else {
-				msgCh <- msg
-			}
-		}(backend)
-	}
-
 	msgs := make([][]types.Info, 0, len(backends))
-	errs := make([]error, 0, len(backends))
-	for i := 0; i < len(backends); i++ {
-		select {
-		case msg := <-msgCh:
-			msgs = append(msgs, msg)
-		case err := <-errCh:
-			errs = append(errs, err)
-		}
-	}
+	errs, _ := fanOut(ctx, backends,
+		func(ctx context.Context, b Backend) (interface{}, error) {
+			return b.Info(ctx, metric)
+		},
+		func(msg interface{}) (bool, error) {
+			m := msg.([]types.Info)
+			msgs = append(msgs, m)
+			return len(m) > 0, nil
+		},
+	)
 
 	if err := checkErrs(ctx, errs, len(backends), backends[0].Logger()); err != nil {
 		return nil, err
@@ -124,29 +134,17 @@ func Finds(ctx context.Context, backends []Backend, query string) (types.Matches
 		return types.Matches{}, nil
 	}
 
-	msgCh := make(chan types.Matches, len(backends))
-	errCh := make(chan error, len(backends))
-	for _, backend := range backends {
-		go func(b Backend) {
-			msg, err := b.Find(ctx, query)
-			if err != nil {
-				errCh <- err
-			} else {
-				msgCh <- msg
-			}
-		}(backend)
-	}
-
 	msgs := make([]types.Matches, 0, len(backends))
-	errs := make([]error, 0, len(backends))
-	for i := 0; i < len(backends); i++ {
-		select {
-		case msg := <-msgCh:
-			msgs = append(msgs, msg)
-		case err := <-errCh:
-			errs = append(errs, err)
-		}
-	}
+	errs, _ := fanOut(ctx, backends,
+		func(ctx context.Context, b Backend) (interface{}, error) {
+			return b.Find(ctx, query)
+		},
+		func(msg interface{}) (bool, error) {
+			m := msg.(types.Matches)
+			msgs = append(msgs, m)
+			return len(m.Matches) > 0, nil
+		},
+	)
 
 	if err := checkErrs(ctx, errs, len(backends), backends[0].Logger()); err != nil {
 		return types.Matches{}, err
@@ -177,7 +175,7 @@ func checkErrs(ctx context.Context, errs []error, limit int, logger *zap.Logger)
 	}
 
 	if len(errs) >= limit {
-		return errors.WithMessage(combineErrors(errs), "All backend requests failed")
+		return Errors(errs)
 	}
 
 	logger.Warn("Some requests failed",
@@ -204,6 +202,7 @@ func combineErrors(errs []error) error {
 	for m, c := range msgs {
 		ms = append(ms, fmt.Sprintf("%s: %d backends", m, c))
 	}
+	sort.Strings(ms)
 
 	return fmt.Errorf("%s", strings.Join(ms, "\n"))
 }
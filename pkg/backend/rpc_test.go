@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/bookingcom/carbonapi/pkg/backend/mock"
@@ -104,6 +105,37 @@ func TestCarbonapiv2Renders(t *testing.T) {
 	}
 }
 
+func TestCarbonapiv2RendersFilters(t *testing.T) {
+	series := func(name string, v float64) types.Metric {
+		return types.Metric{Name: name, Values: []float64{v}, IsAbsent: []bool{false}}
+	}
+	var backends []Backend
+	for _, ms := range [][]types.Metric{
+		{series("a", 1), series("b", 5)},
+		{series("c", 3), series("d", 4)},
+	} {
+		ms := ms
+		backends = append(backends, mock.New(mock.Config{
+			Render: func(context.Context, int32, int32, []string) ([]types.Metric, error) { return ms, nil },
+		}))
+	}
+
+	ctx := WithFilters(context.Background(), []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"2"}}})
+	got, err := Renders(ctx, backends, 0, 1, []string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, m := range got {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	if fmt.Sprint(names) != "[b d]" {
+		t.Errorf("expected the series [b d], got %v", names)
+	}
+}
+
 func TestCarbonapiv2RendersError(t *testing.T) {
 	render := func(context.Context, int32, int32, []string) ([]types.Metric, error) {
 		return nil, errors.New("No")
@@ -117,6 +149,41 @@ func TestCarbonapiv2RendersError(t *testing.T) {
 	}
 }
 
+func TestCarbonapiv2RendersPointLimit(t *testing.T) {
+	series := func(name string, n int) types.Metric {
+		return types.Metric{Name: name, Values: make([]float64, n), IsAbsent: make([]bool, n)}
+	}
+	fixed := func(metrics ...types.Metric) Backend {
+		return mock.New(mock.Config{
+			Render: func(context.Context, int32, int32, []string) ([]types.Metric, error) {
+				return metrics, nil
+			},
+		})
+	}
+	blocked := mock.New(mock.Config{
+		Render: func(ctx context.Context, _ int32, _ int32, _ []string) ([]types.Metric, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+
+	// Replicas of the same series count once.
+	replicas := []Backend{fixed(series("foo", 10)), fixed(series("foo", 10))}
+	if _, err := Renders(WithPointLimit(context.Background(), 10), replicas, 0, 1, []string{"foo"}); err != nil {
+		t.Errorf("Expected replicas to fit in the limit, got %v", err)
+	}
+
+	backends := []Backend{fixed(series("foo", 10), series("bar", 10)), blocked}
+	_, err := Renders(WithPointLimit(context.Background(), 15), backends, 0, 1, []string{"*"})
+	tooLarge, ok := err.(*TooLargeError)
+	if !ok {
+		t.Fatalf("Expected a TooLargeError, got %v", err)
+	}
+	if tooLarge.Points != 20 || tooLarge.Limit != 15 {
+		t.Errorf("Expected 20 points over a limit of 15, got %+v", tooLarge)
+	}
+}
+
 func TestCarbonapiv2InfosCorrectMerge(t *testing.T) {
 	backends := []Backend{
 		mock.New(mock.Config{
@@ -0,0 +1,127 @@
+/*
+Package blocklist matches the metrics of requests against patterns that
+aren't to be served, such as the globs of a runaway dashboard that take
+storage down.
+
+Globs are matched segment by segment against the metrics as they are
+requested, globs included, so "*.*.*.*" blocks exactly "*.*.*.*" and
+"servers.*" blocks "servers.web1" and "servers.*", but not "servers.web1.cpu".
+Regexes are matched against the whole of the requested metrics.
+
+Example use:
+
+	l, err := Parse(strings.NewReader(`[{glob: "*.*.*.*"}, {regex: "^servers\\..*\\.sar\\."}]`))
+	pattern, ok := l.Blocked("*.*.*.*") // "*.*.*.*", true
+*/
+package blocklist
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Pattern is a glob or a regex of metrics not to serve.
+type Pattern struct {
+	Glob  string `yaml:"glob"`
+	Regex string `yaml:"regex"`
+}
+
+// List is a set of patterns.
+type List struct {
+	globs   []string
+	regexes []*regexp.Regexp
+}
+
+// Parse reads a YAML list of patterns.
+func Parse(r io.Reader) (*List, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []Pattern
+	if err := yaml.UnmarshalStrict(b, &patterns); err != nil {
+		return nil, err
+	}
+
+	return New(patterns)
+}
+
+// Load reads the patterns of file.
+func Load(file string) (*List, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// New checks patterns and returns their list.
+func New(patterns []Pattern) (*List, error) {
+	l := &List{}
+	for i, p := range patterns {
+		switch {
+		case p.Glob != "" && p.Regex == "":
+			for _, s := range strings.Split(p.Glob, ".") {
+				if _, err := path.Match(s, ""); err != nil {
+					return nil, fmt.Errorf("blocklist pattern %d: bad glob %q", i, p.Glob)
+				}
+			}
+			l.globs = append(l.globs, p.Glob)
+		case p.Regex != "" && p.Glob == "":
+			re, err := regexp.Compile(p.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("blocklist pattern %d: %v", i, err)
+			}
+			l.regexes = append(l.regexes, re)
+		default:
+			return nil, fmt.Errorf("blocklist pattern %d needs either a glob or a regex", i)
+		}
+	}
+
+	return l, nil
+}
+
+// Len returns the number of patterns of l.
+func (l *List) Len() int {
+	return len(l.globs) + len(l.regexes)
+}
+
+// Blocked returns the first pattern metric matches, if any does.
+func (l *List) Blocked(metric string) (string, bool) {
+	segments := strings.Split(metric, ".")
+	for _, g := range l.globs {
+		if matchGlob(strings.Split(g, "."), segments) {
+			return g, true
+		}
+	}
+	for _, re := range l.regexes {
+		if re.MatchString(metric) {
+			return re.String(), true
+		}
+	}
+
+	return "", false
+}
+
+func matchGlob(glob, segments []string) bool {
+	if len(glob) != len(segments) {
+		return false
+	}
+	for i, g := range glob {
+		if ok, _ := path.Match(g, segments[i]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
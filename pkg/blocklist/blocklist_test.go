@@ -0,0 +1,54 @@
+package blocklist
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlocked(t *testing.T) {
+	l, err := Parse(strings.NewReader(`
+- glob: "*.*.*.*"
+- glob: "servers.*"
+- regex: "^apps\\..*\\.debug\\."
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 3 {
+		t.Errorf("expected 3 patterns, got %d", l.Len())
+	}
+
+	tests := []struct {
+		metric  string
+		pattern string
+	}{
+		{"*.*.*.*", "*.*.*.*"},
+		{"a.b.c.d", "*.*.*.*"},
+		{"*.*.*", ""},
+		{"servers.web1", "servers.*"},
+		{"servers.*", "servers.*"},
+		{"servers.web1.cpu", ""},
+		{"apps.shop.debug.latency.p99", `^apps\..*\.debug\.`},
+		{"apps.shop.latency", ""},
+	}
+	for _, tt := range tests {
+		pattern, ok := l.Blocked(tt.metric)
+		if pattern != tt.pattern || ok != (tt.pattern != "") {
+			t.Errorf("Blocked(%q) = %q, %v, want %q", tt.metric, pattern, ok, tt.pattern)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	bad := [][]Pattern{
+		{{}},
+		{{Glob: "a", Regex: "a"}},
+		{{Glob: "a.[b"}},
+		{{Regex: "("}},
+	}
+	for _, patterns := range bad {
+		if _, err := New(patterns); err == nil {
+			t.Errorf("New(%+v): expected an error", patterns)
+		}
+	}
+}
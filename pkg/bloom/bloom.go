@@ -0,0 +1,100 @@
+/*
+Package bloom implements bloom filters of strings: sets that take a few bits
+per element, and that may report elements they don't hold, at a rate chosen
+when they are made, but never miss one they do.
+
+Example use:
+
+	f := New(len(names), 0.01)
+	for _, name := range names {
+		f.Add(name)
+	}
+	if !f.Test("foo.bar") {
+		// "foo.bar" is certainly not one of names.
+	}
+*/
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a bloom filter. It is safe for concurrent Tests, but not for
+// Adds concurrent with anything else.
+type Filter struct {
+	bits []uint64
+	m    uint64 // The number of bits.
+	k    uint64 // The number of bits set per element.
+	n    int
+}
+
+// New returns a filter sized for n elements, which reports elements it
+// doesn't hold at rate fp, between 0 and 1, once they are added.
+func New(n int, fp float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fp <= 0 || fp >= 1 {
+		fp = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hashes returns two hashes of s, which the k bits of s are derived from.
+func hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+
+	// The second hash is odd, so that it steps through all the bits.
+	return sum, (sum>>32 | sum<<32) | 1
+}
+
+// Add adds s to the filter.
+func (f *Filter) Add(s string) {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.n++
+}
+
+// Test reports whether s may have been added to the filter. It is false
+// only for strings that certainly weren't.
+func (f *Filter) Test(s string) bool {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Len returns the number of Adds.
+func (f *Filter) Len() int {
+	return f.n
+}
+
+// Size returns the bytes the bits of the filter take.
+func (f *Filter) Size() int {
+	return len(f.bits) * 8
+}
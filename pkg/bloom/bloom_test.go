@@ -0,0 +1,50 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add("servers.web" + strconv.Itoa(i) + ".cpu")
+	}
+
+	for i := 0; i < n; i++ {
+		if name := "servers.web" + strconv.Itoa(i) + ".cpu"; !f.Test(name) {
+			t.Fatalf("Expected %s to be in the filter", name)
+		}
+	}
+
+	var fp int
+	for i := 0; i < n; i++ {
+		if f.Test("servers.db" + strconv.Itoa(i) + ".cpu") {
+			fp++
+		}
+	}
+	// Twice the rate asked for, for the odd unlucky hash.
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Errorf("Expected a false positive rate of about 0.01, got %v", rate)
+	}
+
+	if f.Len() != n {
+		t.Errorf("Expected %d elements, got %d", n, f.Len())
+	}
+	// About 9.6 bits per element for 1%.
+	if size := f.Size(); size > n*10/8+8 {
+		t.Errorf("Expected the filter to take about %d bytes, got %d", n*10/8, size)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	f := New(0, 0)
+	if f.Test("foo") {
+		t.Error("Expected an empty filter to hold nothing")
+	}
+	f.Add("foo")
+	if !f.Test("foo") {
+		t.Error("Expected foo to be in the filter")
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Package buildinfo exports what a process was built from, and a hash of the
+configuration it loaded, as Prometheus gauges. Processes built from another
+version, or running with another configuration, than the rest of their fleet
+then stand out on a dashboard.
+
+Example use:
+
+	info := buildinfo.New(BuildVersion, BuildRevision)
+	info.SetConfig(config)
+	prometheus.MustRegister(info)
+*/
+package buildinfo
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	buildInfoDesc = prometheus.NewDesc(
+		"build_info",
+		"Always 1, labelled with the version, Go version and git revision the process was built from",
+		[]string{"version", "goversion", "revision"}, nil,
+	)
+	configHashDesc = prometheus.NewDesc(
+		"config_hash",
+		"Hash of the loaded configuration, which differs between processes with different configurations",
+		nil, nil,
+	)
+)
+
+// Info is the build and configuration of a process.
+type Info struct {
+	version  string
+	revision string
+
+	configHash uint32
+}
+
+// New returns the Info of a process built from version, at git revision.
+func New(version, revision string) *Info {
+	return &Info{
+		version:  version,
+		revision: revision,
+	}
+}
+
+// SetConfig hashes the configuration config, as encoded in JSON.
+func (i *Info) SetConfig(config interface{}) error {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	h := fnv.New32a()
+	h.Write(b)
+	atomic.StoreUint32(&i.configHash, h.Sum32())
+
+	return nil
+}
+
+// ConfigHash returns the hash of the configuration.
+func (i *Info) ConfigHash() uint32 {
+	return atomic.LoadUint32(&i.configHash)
+}
+
+// Describe implements prometheus.Collector.
+func (i *Info) Describe(ch chan<- *prometheus.Desc) {
+	ch <- buildInfoDesc
+	ch <- configHashDesc
+}
+
+// Collect implements prometheus.Collector.
+func (i *Info) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(buildInfoDesc, prometheus.GaugeValue, 1, i.version, runtime.Version(), i.revision)
+	// A 32 bit hash is exact as a float64.
+	ch <- prometheus.MustNewConstMetric(configHashDesc, prometheus.GaugeValue, float64(i.ConfigHash()))
+}
@@ -0,0 +1,55 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollect(t *testing.T) {
+	info := New("1.2.3", "abcdef")
+
+	type config struct {
+		Listen string
+	}
+	if err := info.SetConfig(config{Listen: ":8080"}); err != nil {
+		t.Fatal(err)
+	}
+	hash := info.ConfigHash()
+
+	ch := make(chan prometheus.Metric, 2)
+	info.Collect(ch)
+	close(ch)
+
+	var got []dto.Metric
+	for m := range ch {
+		var d dto.Metric
+		if err := m.Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(got))
+	}
+
+	labels := make(map[string]string)
+	for _, l := range got[0].GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["version"] != "1.2.3" || labels["revision"] != "abcdef" || labels["goversion"] != runtime.Version() {
+		t.Errorf("Unexpected build_info labels %v", labels)
+	}
+	if v := got[1].GetGauge().GetValue(); v != float64(hash) || hash == 0 {
+		t.Errorf("Expected config_hash %d, got %v", hash, v)
+	}
+
+	if err := info.SetConfig(config{Listen: ":8081"}); err != nil {
+		t.Fatal(err)
+	}
+	if info.ConfigHash() == hash {
+		t.Error("Expected the hash to change with the configuration")
+	}
+}
@@ -0,0 +1,139 @@
+// Package carbon sends points to carbon, in its plaintext or pickle
+// protocol, https://graphite.readthedocs.io/en/latest/feeding-carbon.html.
+package carbon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	pickle "github.com/lomik/og-rek"
+)
+
+// The protocols points are sent in.
+const (
+	Plaintext = "plaintext"
+	Pickle    = "pickle"
+)
+
+// PickleBatch is the most points a pickle message has, to keep them well
+// under the size carbon accepts.
+const PickleBatch = 500
+
+// Point is a value of a metric at a time.
+type Point struct {
+	Path      string
+	Value     float64
+	Timestamp int32
+}
+
+// Client sends points to a carbon listening at an address.
+type Client struct {
+	address  string
+	protocol string
+	timeout  time.Duration
+}
+
+// New returns a client sending to address in protocol, which is Plaintext
+// or Pickle. Sends time out after timeout.
+func New(address, protocol string, timeout time.Duration) (*Client, error) {
+	if address == "" {
+		return nil, errors.New("no carbon address")
+	}
+	if protocol != Plaintext && protocol != Pickle {
+		return nil, fmt.Errorf("unsupported carbon protocol %q, it must be %s or %s", protocol, Plaintext, Pickle)
+	}
+
+	return &Client{
+		address:  address,
+		protocol: protocol,
+		timeout:  timeout,
+	}, nil
+}
+
+// Send sends points over a new connection.
+func (c *Client) Send(points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if c.timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+
+	w := bufio.NewWriter(conn)
+	if c.protocol == Pickle {
+		err = writePickle(w, points)
+	} else {
+		err = writePlaintext(w, points)
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// writePlaintext writes a line "<path> <value> <timestamp>" per point.
+func writePlaintext(w io.Writer, points []Point) error {
+	var b []byte
+	for _, p := range points {
+		b = b[:0]
+		b = append(b, p.Path...)
+		b = append(b, ' ')
+		b = strconv.AppendFloat(b, p.Value, 'f', -1, 64)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, int64(p.Timestamp), 10)
+		b = append(b, '\n')
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePickle writes messages of the points as a pickled list of
+// (path, (timestamp, value)), each after its length as 4 bytes big endian.
+func writePickle(w io.Writer, points []Point) error {
+	var buf bytes.Buffer
+	for len(points) > 0 {
+		n := len(points)
+		if n > PickleBatch {
+			n = PickleBatch
+		}
+
+		list := make([]interface{}, n)
+		for i, p := range points[:n] {
+			list[i] = pickle.Tuple{p.Path, pickle.Tuple{int64(p.Timestamp), p.Value}}
+		}
+		points = points[n:]
+
+		buf.Reset()
+		if err := pickle.NewEncoder(&buf).Encode(list); err != nil {
+			return err
+		}
+
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(buf.Len()))
+		if _, err := w.Write(size[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package carbon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	pickle "github.com/lomik/og-rek"
+	"go.uber.org/zap"
+)
+
+// receive returns the address of a listener, and a channel of what the
+// first connection to it sends.
+func receive(t *testing.T) (string, <-chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan []byte, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			ch <- nil
+			return
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		ch <- b
+	}()
+
+	return l.Addr().String(), ch
+}
+
+func TestSendPlaintext(t *testing.T) {
+	addr, ch := receive(t)
+	c, err := New(addr, Plaintext, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send([]Point{{"a.b", 1.5, 100}, {"a.c", -2, 160}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(<-ch), "a.b 1.5 100\na.c -2 160\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestSendPickle(t *testing.T) {
+	addr, ch := receive(t)
+	c, err := New(addr, Pickle, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points := make([]Point, PickleBatch+1)
+	for i := range points {
+		points[i] = Point{"a.b", float64(i), int32(60 * i)}
+	}
+	if err := c.Send(points); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(<-ch)
+	var got []interface{}
+	for r.Len() > 0 {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		v, err := pickle.NewDecoder(bytes.NewReader(msg)).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v.([]interface{})...)
+	}
+
+	if len(got) != len(points) {
+		t.Fatalf("Expected %d points, got %d", len(points), len(got))
+	}
+	last := got[PickleBatch].(pickle.Tuple)
+	point := last[1].(pickle.Tuple)
+	if last[0] != "a.b" || point[0] != int64(60*PickleBatch) || point[1] != float64(PickleBatch) {
+		t.Errorf("Expected the last point, got %v", last)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("", Plaintext, 0); err == nil {
+		t.Error("Expected an error without an address")
+	}
+	if _, err := New("localhost:2003", "udp", 0); err == nil {
+		t.Error("Expected an error for an unknown protocol")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c, err := New(addr, Plaintext, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRelay(c, 3, 2, time.Hour, zap.NewNop())
+
+	if err := r.Put([]Point{{"a", 1, 60}, {"b", 2, 60}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Put([]Point{{"c", 3, 60}, {"d", 4, 60}}); err != ErrQueueFull {
+		t.Errorf("Expected the queue to be full, got %v", err)
+	}
+
+	// nothing listens, so the full batch stays queued
+	for i := 0; i < 100 && r.Queued() != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := r.Queued(); n != 2 {
+		t.Fatalf("Expected the points to stay queued, got %d", n)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("can't listen again:", err)
+	}
+	defer l.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		received <- b
+	}()
+
+	r.Close()
+	if got := string(<-received); got != "a 1 60\nb 2 60\n" {
+		t.Errorf("Expected the queued points to be sent on close, got %q", got)
+	}
+	if n := r.Queued(); n != 0 {
+		t.Errorf("Expected an empty queue, got %d", n)
+	}
+}
@@ -0,0 +1,137 @@
+package carbon
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrQueueFull is returned for points that don't fit in the queue of a
+// relay.
+var ErrQueueFull = errors.New("carbon relay queue is full")
+
+// Relay queues points and sends them to carbon in batches, in the
+// background. Batches that fail to send stay queued, to be sent again, so
+// while carbon is slow or down the queue fills up, and Put refuses points
+// rather than wait, for its callers to back off.
+type Relay struct {
+	client   *Client
+	size     int
+	batch    int
+	interval time.Duration
+	logger   *zap.Logger
+
+	mu    sync.Mutex
+	queue []Point
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRelay returns a relay that queues up to size points, and sends them
+// with client in batches of up to batch points, whenever it has a full
+// batch and at least every interval.
+func NewRelay(client *Client, size, batch int, interval time.Duration, logger *zap.Logger) *Relay {
+	r := &Relay{
+		client:   client,
+		size:     size,
+		batch:    batch,
+		interval: interval,
+		logger:   logger,
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.loop()
+
+	return r
+}
+
+// Put queues points, all of them or, with ErrQueueFull, none.
+func (r *Relay) Put(points []Point) error {
+	r.mu.Lock()
+	if len(r.queue)+len(points) > r.size {
+		r.mu.Unlock()
+		return ErrQueueFull
+	}
+	r.queue = append(r.queue, points...)
+	full := len(r.queue) >= r.batch
+	r.mu.Unlock()
+
+	if full {
+		select {
+		case r.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Queued returns the number of points waiting to be sent.
+func (r *Relay) Queued() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.queue)
+}
+
+// Close sends what is queued a last time, and stops the relay.
+func (r *Relay) Close() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Relay) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.kick:
+		case <-r.stop:
+			r.flush()
+			return
+		}
+
+		r.flush()
+	}
+}
+
+// flush sends the queue a batch at a time, until it is empty or a batch
+// fails. Only flush takes points off the queue, so a batch is taken off
+// once it is sent.
+func (r *Relay) flush() {
+	for {
+		r.mu.Lock()
+		n := len(r.queue)
+		if n > r.batch {
+			n = r.batch
+		}
+		batch := append([]Point(nil), r.queue[:n]...)
+		r.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		if err := r.client.Send(batch); err != nil {
+			r.logger.Warn("failed to send to carbon",
+				zap.String("address", r.client.address),
+				zap.Int("points", n),
+				zap.Error(err),
+			)
+			return
+		}
+
+		r.mu.Lock()
+		r.queue = r.queue[n:]
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,187 @@
+// Package compat compares render responses of carbonapi with the ones of a
+// reference graphite-web.
+package compat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Series is a series of a format=json render response.
+type Series struct {
+	Target     string        `json:"target"`
+	Datapoints [][2]*float64 `json:"datapoints"`
+}
+
+// ParseJSON parses a format=json render response.
+func ParseJSON(body []byte) ([]Series, error) {
+	var series []Series
+	if err := json.Unmarshal(body, &series); err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// Comparator decides whether the values two responses have for a point match.
+// Absent points never get to the comparator.
+type Comparator interface {
+	Equal(want, got float64) bool
+}
+
+// Exact only accepts equal values.
+type Exact struct{}
+
+// Equal implements Comparator.
+func (Exact) Equal(want, got float64) bool {
+	return want == got || (math.IsNaN(want) && math.IsNaN(got))
+}
+
+// Tolerance accepts values within an absolute or a relative difference of
+// each other, whichever is larger.
+type Tolerance struct {
+	Absolute float64
+	Relative float64
+}
+
+// Equal implements Comparator.
+func (t Tolerance) Equal(want, got float64) bool {
+	if (Exact{}).Equal(want, got) {
+		return true
+	}
+
+	allowed := math.Max(t.Absolute, t.Relative*math.Max(math.Abs(want), math.Abs(got)))
+	return math.Abs(want-got) <= allowed
+}
+
+// Kind is the kind of a Difference.
+type Kind int
+
+const (
+	// MissingSeries is a series only the reference returned.
+	MissingSeries Kind = iota
+	// UnexpectedSeries is a series only carbonapi returned.
+	UnexpectedSeries
+	// Value is a point the responses disagree on, including one of them
+	// having no value for it.
+	Value
+)
+
+func (k Kind) String() string {
+	switch k {
+	case MissingSeries:
+		return "missing series"
+	case UnexpectedSeries:
+		return "unexpected series"
+	case Value:
+		return "value"
+	}
+
+	return "unknown"
+}
+
+// Difference is one way in which two responses differ. Want and Got are nil
+// for absent points.
+type Difference struct {
+	Target    string
+	Kind      Kind
+	Timestamp int64
+	Want      *float64
+	Got       *float64
+}
+
+func (d Difference) String() string {
+	if d.Kind != Value {
+		return fmt.Sprintf("%s: %s", d.Target, d.Kind)
+	}
+
+	return fmt.Sprintf("%s at %d: want %s, got %s", d.Target, d.Timestamp, formatValue(d.Want), formatValue(d.Got))
+}
+
+func formatValue(v *float64) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprint(*v)
+}
+
+// Diff compares the series carbonapi returned with the reference ones. Series
+// are matched by target and points by timestamp; a point missing from one
+// response counts as absent there. Differences are sorted by target and
+// timestamp.
+func Diff(want, got []Series, cmp Comparator) []Difference {
+	var diffs []Difference
+
+	gotByTarget := make(map[string]Series, len(got))
+	for _, s := range got {
+		gotByTarget[s.Target] = s
+	}
+	wantByTarget := make(map[string]bool, len(want))
+
+	for _, w := range want {
+		wantByTarget[w.Target] = true
+		g, ok := gotByTarget[w.Target]
+		if !ok {
+			diffs = append(diffs, Difference{Target: w.Target, Kind: MissingSeries})
+			continue
+		}
+		diffs = append(diffs, diffPoints(w, g, cmp)...)
+	}
+
+	for _, g := range got {
+		if !wantByTarget[g.Target] {
+			diffs = append(diffs, Difference{Target: g.Target, Kind: UnexpectedSeries})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		if diffs[i].Target != diffs[j].Target {
+			return diffs[i].Target < diffs[j].Target
+		}
+		return diffs[i].Timestamp < diffs[j].Timestamp
+	})
+
+	return diffs
+}
+
+func diffPoints(want, got Series, cmp Comparator) []Difference {
+	wantPoints := points(want)
+	gotPoints := points(got)
+
+	var diffs []Difference
+	for ts, w := range wantPoints {
+		g := gotPoints[ts]
+		if !sameValue(w, g, cmp) {
+			diffs = append(diffs, Difference{Target: want.Target, Kind: Value, Timestamp: ts, Want: w, Got: g})
+		}
+	}
+	for ts, g := range gotPoints {
+		if _, ok := wantPoints[ts]; !ok && g != nil {
+			diffs = append(diffs, Difference{Target: want.Target, Kind: Value, Timestamp: ts, Got: g})
+		}
+	}
+
+	return diffs
+}
+
+func points(s Series) map[int64]*float64 {
+	m := make(map[int64]*float64, len(s.Datapoints))
+	for _, p := range s.Datapoints {
+		if p[1] == nil {
+			continue
+		}
+		m[int64(*p[1])] = p[0]
+	}
+
+	return m
+}
+
+func sameValue(want, got *float64, cmp Comparator) bool {
+	if want == nil || got == nil {
+		return want == nil && got == nil
+	}
+
+	return cmp.Equal(*want, *got)
+}
@@ -0,0 +1,65 @@
+package compat
+
+import (
+	"testing"
+)
+
+func TestTolerance(t *testing.T) {
+	tests := []struct {
+		tol       Tolerance
+		want, got float64
+		equal     bool
+	}{
+		{Tolerance{}, 1, 1, true},
+		{Tolerance{}, 1, 1.0000001, false},
+		{Tolerance{Absolute: 0.01}, 1, 1.005, true},
+		{Tolerance{Absolute: 0.01}, 1, 1.02, false},
+		{Tolerance{Relative: 0.01}, 1000, 1005, true},
+		{Tolerance{Relative: 0.01}, 1000, 1020, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.tol.Equal(tt.want, tt.got); got != tt.equal {
+			t.Errorf("%+v.Equal(%v, %v) = %v, want %v", tt.tol, tt.want, tt.got, got, tt.equal)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want, err := ParseJSON([]byte(`[
+		{"target": "a", "datapoints": [[1, 60], [2, 120], [null, 180], [4, 240]]},
+		{"target": "b", "datapoints": [[1, 60]]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseJSON([]byte(`[
+		{"target": "a", "datapoints": [[1.0001, 60], [3, 120], [null, 180], [null, 240], [5, 300]]},
+		{"target": "c", "datapoints": [[1, 60]]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := Diff(want, got, Tolerance{Absolute: 0.001})
+
+	expected := []string{
+		"a at 120: want 2, got 3",
+		"a at 240: want 4, got null",
+		"a at 300: want null, got 5",
+		"b: missing series",
+		"c: unexpected series",
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("got %d differences, want %d: %v", len(diffs), len(expected), diffs)
+	}
+	for i, d := range diffs {
+		if d.String() != expected[i] {
+			t.Errorf("difference %d: got %q, want %q", i, d.String(), expected[i])
+		}
+	}
+
+	if diffs := Diff(want, want, Exact{}); len(diffs) != 0 {
+		t.Errorf("a response differs from itself: %v", diffs)
+	}
+}
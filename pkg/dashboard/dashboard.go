@@ -0,0 +1,161 @@
+/*
+Package dashboard implements storage for graphite-web dashboards, which the
+graphite composer saves, loads and finds through /dashboard/.
+
+A dashboard is a name and its state, the JSON document the composer keeps
+its graphs and settings in. Stores keep states as they get them.
+
+Example use:
+
+	s := NewMemory()
+	err := s.Save(ctx, "ops", json.RawMessage(`{"graphs":[]}`))
+	names, err := s.Find(ctx, "ops")
+*/
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotConfigured is returned when dashboards are requested but no
+	// store is set up.
+	ErrNotConfigured = errors.New("dashboard store is not configured")
+	// ErrNotFound is returned for dashboards that don't exist.
+	ErrNotFound = errors.New("dashboard does not exist")
+	// ErrBadState is returned when a state isn't a JSON document.
+	ErrBadState = errors.New("dashboard state must be JSON")
+	// ErrBadName is returned for dashboard names a store can't keep.
+	ErrBadName = errors.New("dashboard name is not allowed")
+)
+
+// Store codifies the operations a dashboard backend supports.
+type Store interface {
+	Save(ctx context.Context, name string, state json.RawMessage) error
+	Load(ctx context.Context, name string) (json.RawMessage, error)
+	// Find returns the names, sorted, of the dashboards that contain all
+	// whitespace separated terms of query, ignoring case.
+	Find(ctx context.Context, query string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Config configures a dashboard store.
+type Config struct {
+	Type        string        `yaml:"type"` // One of "memory", "file" or "graphiteWeb". Empty disables dashboards.
+	Path        string        `yaml:"path"` // File the "file" store keeps dashboards in.
+	GraphiteWeb string        `yaml:"graphiteWeb"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// New creates a store from the given configuration.
+// It returns a nil Store if dashboards are disabled.
+func New(cfg Config) (Store, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "memory":
+		return NewMemory(), nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, errors.New("file dashboard store requires a path")
+		}
+		return NewFile(cfg.Path)
+	case "graphiteWeb":
+		if cfg.GraphiteWeb == "" {
+			return nil, errors.New("graphiteWeb dashboard store requires a URL")
+		}
+		return NewGraphiteWeb(cfg.GraphiteWeb, cfg.Timeout), nil
+	}
+
+	return nil, errors.Errorf("unknown dashboard store type '%s'", cfg.Type)
+}
+
+// Memory keeps dashboards in memory.
+type Memory struct {
+	mu    sync.RWMutex
+	items map[string]json.RawMessage
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{items: make(map[string]json.RawMessage)}
+}
+
+// Save stores the state of the dashboard, replacing any it had.
+func (m *Memory) Save(ctx context.Context, name string, state json.RawMessage) error {
+	if !json.Valid(state) {
+		return ErrBadState
+	}
+
+	m.mu.Lock()
+	m.items[name] = append(json.RawMessage(nil), state...)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Load returns the state of the dashboard.
+func (m *Memory) Load(ctx context.Context, name string) (json.RawMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state, ok := m.items[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return state, nil
+}
+
+// Find returns the names of the dashboards matching query.
+func (m *Memory) Find(ctx context.Context, query string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return match(m.items, query), nil
+}
+
+// Delete removes the dashboard.
+func (m *Memory) Delete(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.items[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.items, name)
+
+	return nil
+}
+
+// match returns the sorted names of items that contain all terms of query,
+// ignoring case, as graphite-web matches them.
+func match(items map[string]json.RawMessage, query string) []string {
+	terms := strings.Fields(strings.ToLower(query))
+
+	names := make([]string, 0)
+next:
+	for name := range items {
+		lower := strings.ToLower(name)
+		for _, t := range terms {
+			if !strings.Contains(lower, t) {
+				continue next
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,144 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if err := s.Save(ctx, "broken", json.RawMessage(`{"graphs":`)); err != ErrBadState {
+		t.Errorf("expected ErrBadState, got %v", err)
+	}
+
+	for _, name := range []string{"Ops overview", "ops-db", "web"} {
+		if err := s.Save(ctx, name, json.RawMessage(`{"name":"`+name+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, err := s.Load(ctx, "ops-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(state) != `{"name":"ops-db"}` {
+		t.Errorf("unexpected state %s", state)
+	}
+	if _, err := s.Load(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Ops overview", "ops-db", "web"}},
+		{"OPS", []string{"Ops overview", "ops-db"}},
+		{"ops view", []string{"Ops overview"}},
+		{"nothing", []string{}},
+	}
+	for _, tt := range tests {
+		names, err := s.Find(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("query %q: expected %v, got %v", tt.query, tt.want, names)
+		}
+	}
+
+	if err := s.Delete(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "web"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dashboards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dashboards.json")
+
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, f)
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, _ := reopened.Find(context.Background(), "")
+	if want := []string{"Ops overview", "ops-db"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v after reopening, got %v", want, names)
+	}
+}
+
+func TestGraphiteWeb(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dashboard/save/ops":
+			if r.FormValue("state") != `{"graphs":[]}` {
+				t.Errorf("unexpected state %q", r.FormValue("state"))
+			}
+			w.Write([]byte(`{"success":true}`))
+		case "/dashboard/load/ops":
+			w.Write([]byte(`{"state":{"graphs":[]}}`))
+		case "/dashboard/load/missing":
+			w.Write([]byte(`{"error":"Dashboard 'missing' does not exist. "}`))
+		case "/dashboard/find/":
+			if r.FormValue("query") != "op" {
+				t.Errorf("unexpected query %q", r.FormValue("query"))
+			}
+			w.Write([]byte(`{"dashboards":[{"name":"ops"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	g := NewGraphiteWeb(srv.URL+"/", time.Second)
+
+	if err := g.Save(ctx, "ops", json.RawMessage(`{"graphs":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := g.Load(ctx, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(state) != `{"graphs":[]}` {
+		t.Errorf("unexpected state %s", state)
+	}
+	if _, err := g.Load(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	names, err := g.Find(ctx, "op")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"ops"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	if err := g.Delete(ctx, "other"); err == nil {
+		t.Error("expected an error for a 404 response")
+	}
+}
@@ -0,0 +1,129 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// File keeps dashboards in memory and, after every change, in a JSON file
+// that it loads them from on start.
+type File struct {
+	mu    sync.RWMutex
+	path  string
+	items map[string]json.RawMessage
+}
+
+// NewFile creates a store backed by the file at path, loading the dashboards
+// it has if it exists.
+func NewFile(path string) (*File, error) {
+	f := &File{
+		path:  path,
+		items: make(map[string]json.RawMessage),
+	}
+
+	blob, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &f.items); err != nil {
+		return nil, errors.Wrapf(err, "reading dashboards from %s", path)
+	}
+
+	return f, nil
+}
+
+// Save stores the state of the dashboard, replacing any it had.
+func (f *File) Save(ctx context.Context, name string, state json.RawMessage) error {
+	if !json.Valid(state) {
+		return ErrBadState
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev, had := f.items[name]
+	f.items[name] = append(json.RawMessage(nil), state...)
+	if err := f.flush(); err != nil {
+		if had {
+			f.items[name] = prev
+		} else {
+			delete(f.items, name)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Load returns the state of the dashboard.
+func (f *File) Load(ctx context.Context, name string) (json.RawMessage, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	state, ok := f.items[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return state, nil
+}
+
+// Find returns the names of the dashboards matching query.
+func (f *File) Find(ctx context.Context, query string) ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return match(f.items, query), nil
+}
+
+// Delete removes the dashboard.
+func (f *File) Delete(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev, ok := f.items[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(f.items, name)
+	if err := f.flush(); err != nil {
+		f.items[name] = prev
+		return err
+	}
+
+	return nil
+}
+
+// flush writes the dashboards to a temporary file it then renames over the
+// store's, so a crash never leaves it half written.
+func (f *File) flush() error {
+	blob, err := json.Marshal(f.items)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "writing dashboards failed")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(blob); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "writing dashboards failed")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "writing dashboards failed")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), f.path), "writing dashboards failed")
+}
@@ -0,0 +1,125 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GraphiteWeb proxies dashboards to an external graphite-web instance.
+type GraphiteWeb struct {
+	base    string
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewGraphiteWeb creates a store backed by the graphite-web at the given URL.
+func NewGraphiteWeb(base string, timeout time.Duration) *GraphiteWeb {
+	return &GraphiteWeb{
+		base:    strings.TrimRight(base, "/"),
+		client:  &http.Client{},
+		timeout: timeout,
+	}
+}
+
+// response is what graphite-web's dashboard views answer with. Failures are
+// reported in Error with a 200 response code.
+type response struct {
+	Success    bool            `json:"success"`
+	Error      string          `json:"error"`
+	State      json.RawMessage `json:"state"`
+	Dashboards []struct {
+		Name string `json:"name"`
+	} `json:"dashboards"`
+}
+
+// Save posts the state of the dashboard to graphite-web.
+func (g *GraphiteWeb) Save(ctx context.Context, name string, state json.RawMessage) error {
+	if !json.Valid(state) {
+		return ErrBadState
+	}
+
+	form := url.Values{"state": []string{string(state)}}
+	_, err := g.call(ctx, "POST", "/dashboard/save/"+url.PathEscape(name), strings.NewReader(form.Encode()))
+
+	return err
+}
+
+// Load fetches the state of the dashboard from graphite-web.
+func (g *GraphiteWeb) Load(ctx context.Context, name string) (json.RawMessage, error) {
+	resp, err := g.call(ctx, "GET", "/dashboard/load/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.State, nil
+}
+
+// Find queries graphite-web's /dashboard/find.
+func (g *GraphiteWeb) Find(ctx context.Context, query string) ([]string, error) {
+	resp, err := g.call(ctx, "GET", "/dashboard/find/?"+url.Values{"query": []string{query}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(resp.Dashboards))
+	for _, d := range resp.Dashboards {
+		names = append(names, d.Name)
+	}
+
+	return names, nil
+}
+
+// Delete removes the dashboard from graphite-web.
+func (g *GraphiteWeb) Delete(ctx context.Context, name string) error {
+	_, err := g.call(ctx, "POST", "/dashboard/delete/"+url.PathEscape(name), nil)
+	return err
+}
+
+func (g *GraphiteWeb) call(ctx context.Context, method, path string, body io.Reader) (response, error) {
+	var r response
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, g.base+path, body)
+	if err != nil {
+		return r, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, errors.Wrap(err, "HTTP call failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return r, errors.Errorf("Bad response code %d", resp.StatusCode)
+	}
+
+	blob, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(blob, &r); err != nil {
+		return r, errors.Wrap(err, "JSON unmarshal failed")
+	}
+	if r.Error != "" {
+		if strings.Contains(r.Error, "does not exist") {
+			return r, ErrNotFound
+		}
+		return r, errors.New(r.Error)
+	}
+
+	return r, nil
+}
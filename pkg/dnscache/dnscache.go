@@ -0,0 +1,176 @@
+/*
+Package dnscache caches DNS lookups of backend hostnames in process, so a
+slow or flaky DNS server doesn't slow down or fail every new connection.
+
+Lookups older than the TTL are refreshed in the background while the cached
+addresses keep being used, and a failed refresh keeps them. Connections that
+fail to every cached address look the host up again before giving up.
+
+Example use:
+
+	r := dnscache.New(time.Minute)
+	transport := &http.Transport{
+		DialContext: r.DialContext(dialer.DialContext),
+	}
+*/
+package dnscache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialFunc dials an address, as net.Dialer.DialContext does.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+type entry struct {
+	addrs      []string
+	resolved   time.Time
+	refreshing bool
+}
+
+// Resolver is a caching resolver. It is safe for concurrent use.
+type Resolver struct {
+	ttl    time.Duration
+	lookup func(ctx context.Context, host string) ([]string, error)
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// New creates a resolver that refreshes lookups older than ttl.
+func New(ttl time.Duration) *Resolver {
+	return &Resolver{
+		ttl:     ttl,
+		lookup:  net.DefaultResolver.LookupHost,
+		now:     time.Now,
+		entries: make(map[string]*entry),
+	}
+}
+
+// LookupHost returns the addresses of host, from the cache if it has them.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	e, ok := r.entries[host]
+	if ok {
+		if r.now().Sub(e.resolved) > r.ttl && !e.refreshing {
+			e.refreshing = true
+			go r.refresh(host)
+		}
+		addrs := e.addrs
+		r.mu.Unlock()
+		return addrs, nil
+	}
+	r.mu.Unlock()
+
+	return r.resolve(ctx, host)
+}
+
+func (r *Resolver) resolve(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.lookup(ctx, host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[host]
+	if err != nil || len(addrs) == 0 {
+		if ok {
+			// Keep what we had rather than fail until DNS recovers.
+			e.refreshing = false
+			return e.addrs, nil
+		}
+		if err == nil {
+			err = &net.DNSError{Err: "no addresses", Name: host}
+		}
+		return nil, err
+	}
+
+	r.entries[host] = &entry{addrs: addrs, resolved: r.now()}
+
+	return addrs, nil
+}
+
+func (r *Resolver) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r.resolve(ctx, host)
+}
+
+// Refresh looks all the cached hosts up again.
+func (r *Resolver) Refresh(ctx context.Context) {
+	r.mu.Lock()
+	hosts := make([]string, 0, len(r.entries))
+	for host := range r.entries {
+		hosts = append(hosts, host)
+	}
+	r.mu.Unlock()
+
+	for _, host := range hosts {
+		r.resolve(ctx, host)
+	}
+}
+
+// DialContext wraps dial to connect to the cached addresses of the host.
+// If none of them accepts the connection, the host is looked up again and
+// the new addresses are tried.
+func (r *Resolver) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := dialAny(ctx, dial, network, addrs, port)
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+
+		fresh, lerr := r.resolve(ctx, host)
+		if lerr != nil || sameAddrs(fresh, addrs) {
+			return nil, err
+		}
+
+		return dialAny(ctx, dial, network, fresh, port)
+	}
+}
+
+func dialAny(ctx context.Context, dial DialFunc, network string, addrs []string, port string) (net.Conn, error) {
+	var err error
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dial(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
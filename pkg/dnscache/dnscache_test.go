@@ -0,0 +1,129 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDNS struct {
+	mu      sync.Mutex
+	addrs   map[string][]string
+	err     error
+	lookups int
+}
+
+func (f *fakeDNS) lookup(ctx context.Context, host string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.addrs[host], nil
+}
+
+func (f *fakeDNS) set(host string, addrs []string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.addrs[host] = addrs
+	f.err = err
+}
+
+func (f *fakeDNS) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lookups
+}
+
+func newResolver(dns *fakeDNS, now *time.Time) *Resolver {
+	r := New(time.Minute)
+	r.lookup = dns.lookup
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func TestLookupHost(t *testing.T) {
+	dns := &fakeDNS{addrs: map[string][]string{"store": {"10.0.0.1"}}}
+	now := time.Unix(0, 0)
+	r := newResolver(dns, &now)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		addrs, err := r.LookupHost(ctx, "store")
+		if err != nil || len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+			t.Fatalf("unexpected lookup %v, %v", addrs, err)
+		}
+	}
+	if dns.count() != 1 {
+		t.Errorf("expected 1 lookup, got %d", dns.count())
+	}
+
+	// A failed refresh keeps the cached addresses.
+	dns.set("store", nil, errors.New("SERVFAIL"))
+	now = now.Add(2 * time.Minute)
+	r.Refresh(ctx)
+	if addrs, err := r.LookupHost(ctx, "store"); err != nil || addrs[0] != "10.0.0.1" {
+		t.Errorf("expected the stale addresses to be kept, got %v, %v", addrs, err)
+	}
+
+	if _, err := r.LookupHost(ctx, "unknown"); err == nil {
+		t.Error("expected an error for a host never resolved")
+	}
+
+	dns.set("store", []string{"10.0.0.2"}, nil)
+	r.Refresh(ctx)
+	if addrs, _ := r.LookupHost(ctx, "store"); addrs[0] != "10.0.0.2" {
+		t.Errorf("expected the refreshed address, got %v", addrs)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	dns := &fakeDNS{addrs: map[string][]string{"store": {"10.0.0.1"}}}
+	now := time.Unix(0, 0)
+	r := newResolver(dns, &now)
+
+	var dialed []string
+	dial := r.DialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if address != "10.0.0.2:8080" {
+			return nil, errors.New("connection refused")
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	})
+
+	if _, err := dial(context.Background(), "tcp", "store:8080"); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+
+	// The host moved; a failed dial looks it up again.
+	dns.set("store", []string{"10.0.0.2"}, nil)
+	conn, err := dial(context.Background(), "tcp", "store:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	expected := []string{"10.0.0.1:8080", "10.0.0.1:8080", "10.0.0.2:8080"}
+	if len(dialed) != len(expected) {
+		t.Fatalf("expected dials to %v, got %v", expected, dialed)
+	}
+	for i := range expected {
+		if dialed[i] != expected[i] {
+			t.Errorf("expected dials to %v, got %v", expected, dialed)
+			break
+		}
+	}
+
+	dialed = nil
+	if _, err := dial(context.Background(), "tcp", "10.0.0.3:8080"); err == nil || len(dialed) != 1 || dns.count() != 3 {
+		t.Errorf("expected IP addresses to be dialed without lookups, got %v", dialed)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Package events implements storage for Graphite events, the annotations that
+graphite-web serves from /events/get_data and draws with the events() render
+function.
+
+Example use:
+
+	s := NewRing(1000)
+	err := s.Add(ctx, Event{What: "deploy", Tags: []string{"deploy", "api"}})
+	evs, err := s.Find(ctx, Query{From: from, Until: until, Tags: []string{"deploy"}})
+*/
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotConfigured is returned when events are requested but no store is set up.
+	ErrNotConfigured = errors.New("events store is not configured")
+	// ErrEmptyWhat is returned when an event without a description is added.
+	ErrEmptyWhat = errors.New("event must have a 'what' field")
+)
+
+// Event is a single annotation.
+type Event struct {
+	ID   int64    `json:"id"`
+	When int64    `json:"when"`
+	What string   `json:"what"`
+	Data string   `json:"data"`
+	Tags []string `json:"tags"`
+}
+
+// UnmarshalJSON accepts tags both as a list and as the space separated string
+// used by graphite-web 0.9.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		ID   int64           `json:"id"`
+		When int64           `json:"when"`
+		What string          `json:"what"`
+		Data string          `json:"data"`
+		Tags json.RawMessage `json:"tags"`
+	}
+
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	e.ID = raw.ID
+	e.When = raw.When
+	e.What = raw.What
+	e.Data = raw.Data
+	e.Tags = nil
+
+	if len(raw.Tags) == 0 || string(raw.Tags) == "null" {
+		return nil
+	}
+
+	if raw.Tags[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw.Tags, &s); err != nil {
+			return err
+		}
+		e.Tags = ParseTags(s)
+		return nil
+	}
+
+	return json.Unmarshal(raw.Tags, &e.Tags)
+}
+
+// ParseTags splits a tag string on whitespace and commas.
+func ParseTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
+}
+
+// Query selects events from a store.
+type Query struct {
+	From  int32
+	Until int32
+	Tags  []string
+	// Union selects events having any of the tags instead of all of them.
+	Union bool
+}
+
+// Match reports whether the event satisfies the query.
+func (q Query) Match(e Event) bool {
+	if e.When < int64(q.From) || e.When > int64(q.Until) {
+		return false
+	}
+
+	if len(q.Tags) == 0 {
+		return true
+	}
+
+	have := make(map[string]struct{}, len(e.Tags))
+	for _, t := range e.Tags {
+		have[t] = struct{}{}
+	}
+
+	for _, t := range q.Tags {
+		_, ok := have[t]
+		if ok && q.Union {
+			return true
+		}
+		if !ok && !q.Union {
+			return false
+		}
+	}
+
+	return !q.Union
+}
+
+// Store codifies the operations an events backend supports.
+type Store interface {
+	Add(context.Context, Event) error
+	Find(context.Context, Query) ([]Event, error)
+}
+
+// Config configures an events store.
+type Config struct {
+	Type        string        `yaml:"type"` // One of "ring", "redis" or "graphiteWeb". Empty disables events.
+	Size        int           `yaml:"size"` // Number of events kept by the ring and redis stores.
+	Timeout     time.Duration `yaml:"timeout"`
+	Redis       RedisConfig   `yaml:"redis"`
+	GraphiteWeb string        `yaml:"graphiteWeb"` // Base URL of a graphite-web instance.
+}
+
+// RedisConfig configures the redis store.
+type RedisConfig struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Key      string `yaml:"key"`
+}
+
+// New creates a store from the given configuration.
+// It returns a nil Store if events are disabled.
+func New(cfg Config) (Store, error) {
+	if cfg.Size <= 0 {
+		cfg.Size = 10000
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "ring":
+		return NewRing(cfg.Size), nil
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return nil, errors.New("redis events store requires an address")
+		}
+		return NewRedis(cfg.Redis, cfg.Size, cfg.Timeout), nil
+	case "graphiteWeb":
+		if cfg.GraphiteWeb == "" {
+			return nil, errors.New("graphiteWeb events store requires a URL")
+		}
+		return NewGraphiteWeb(cfg.GraphiteWeb, cfg.Timeout), nil
+	}
+
+	return nil, errors.Errorf("unknown events store type '%s'", cfg.Type)
+}
+
+var (
+	defaultStore Store
+	defaultMutex sync.RWMutex
+)
+
+// SetDefault sets the store used by the events() render function.
+func SetDefault(s Store) {
+	defaultMutex.Lock()
+	defaultStore = s
+	defaultMutex.Unlock()
+}
+
+// Default returns the store used by the events() render function.
+func Default() Store {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+
+	return defaultStore
+}
+
+func sortByWhen(evs []Event) {
+	sort.SliceStable(evs, func(i, j int) bool {
+		return evs[i].When < evs[j].When
+	})
+}
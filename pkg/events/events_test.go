@@ -0,0 +1,133 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestQueryMatch(t *testing.T) {
+	e := Event{When: 10, What: "deploy", Tags: []string{"deploy", "api"}}
+
+	tests := []struct {
+		name string
+		q    Query
+		want bool
+	}{
+		{"no tags", Query{From: 0, Until: 20}, true},
+		{"before range", Query{From: 11, Until: 20}, false},
+		{"after range", Query{From: 0, Until: 9}, false},
+		{"all tags", Query{From: 0, Until: 20, Tags: []string{"deploy", "api"}}, true},
+		{"missing tag", Query{From: 0, Until: 20, Tags: []string{"deploy", "web"}}, false},
+		{"union", Query{From: 0, Until: 20, Tags: []string{"deploy", "web"}, Union: true}, true},
+		{"union no match", Query{From: 0, Until: 20, Tags: []string{"web"}, Union: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Match(e); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTags(t *testing.T) {
+	var evs []Event
+	blob := `[{"what":"a","tags":"one two,three"},{"what":"b","tags":["four"]},{"what":"c"}]`
+	if err := json.Unmarshal([]byte(blob), &evs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{"one", "two", "three"}, {"four"}, nil}
+	for i, e := range evs {
+		if !reflect.DeepEqual(e.Tags, want[i]) {
+			t.Errorf("event %d: expected tags %v, got %v", i, want[i], e.Tags)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	ctx := context.Background()
+	r := NewRing(2)
+
+	if err := r.Add(ctx, Event{When: 1}); err != ErrEmptyWhat {
+		t.Errorf("expected ErrEmptyWhat, got %v", err)
+	}
+
+	for i, what := range []string{"first", "second", "third"} {
+		if err := r.Add(ctx, Event{When: int64(3 - i), What: what}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evs, err := r.Find(ctx, Query{From: 0, Until: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(evs) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evs))
+	}
+	if evs[0].What != "third" || evs[1].What != "second" {
+		t.Errorf("unexpected events %+v", evs)
+	}
+	if evs[0].ID != 3 {
+		t.Errorf("expected ID 3, got %d", evs[0].ID)
+	}
+}
+
+func TestGraphiteWeb(t *testing.T) {
+	var posted Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events/":
+			json.NewDecoder(r.Body).Decode(&posted)
+		case "/events/get_data":
+			if r.FormValue("tags") != "deploy api" || r.FormValue("set") != "union" {
+				http.Error(w, "bad query", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`[{"id":2,"when":20,"what":"b","tags":"api"},{"id":1,"when":10,"what":"a","tags":"deploy"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Type: "graphiteWeb", GraphiteWeb: srv.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := s.Add(ctx, Event{What: "deploy", Tags: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if posted.What != "deploy" || !reflect.DeepEqual(posted.Tags, []string{"a", "b"}) {
+		t.Errorf("unexpected posted event %+v", posted)
+	}
+
+	evs, err := s.Find(ctx, Query{From: 0, Until: 30, Tags: []string{"deploy", "api"}, Union: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || evs[0].What != "a" || evs[1].What != "b" {
+		t.Errorf("unexpected events %+v", evs)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if s, err := New(Config{}); s != nil || err != nil {
+		t.Errorf("expected disabled store, got %v, %v", s, err)
+	}
+	if _, err := New(Config{Type: "redis"}); err == nil {
+		t.Error("expected error for redis without address")
+	}
+	if _, err := New(Config{Type: "unknown"}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
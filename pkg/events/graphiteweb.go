@@ -0,0 +1,120 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GraphiteWeb proxies events to an external graphite-web instance.
+type GraphiteWeb struct {
+	base    string
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewGraphiteWeb creates a store backed by the graphite-web at the given URL.
+func NewGraphiteWeb(base string, timeout time.Duration) *GraphiteWeb {
+	return &GraphiteWeb{
+		base:    strings.TrimRight(base, "/"),
+		client:  &http.Client{},
+		timeout: timeout,
+	}
+}
+
+// Add posts an event to graphite-web.
+func (g *GraphiteWeb) Add(ctx context.Context, e Event) error {
+	if e.What == "" {
+		return ErrEmptyWhat
+	}
+
+	blob, err := json.Marshal(struct {
+		What string `json:"what"`
+		Tags string `json:"tags"`
+		When int64  `json:"when,omitempty"`
+		Data string `json:"data"`
+	}{
+		What: e.What,
+		Tags: strings.Join(e.Tags, " "),
+		When: e.When,
+		Data: e.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", g.base+"/events/", bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "HTTP call failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Bad response code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Find fetches events from graphite-web's /events/get_data.
+func (g *GraphiteWeb) Find(ctx context.Context, q Query) ([]Event, error) {
+	vals := url.Values{
+		"from":  []string{strconv.Itoa(int(q.From))},
+		"until": []string{strconv.Itoa(int(q.Until))},
+	}
+	if len(q.Tags) > 0 {
+		vals.Set("tags", strings.Join(q.Tags, " "))
+	}
+	if q.Union {
+		vals.Set("set", "union")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", g.base+"/events/get_data?"+vals.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "HTTP call failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Bad response code %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var evs []Event
+	if err := json.Unmarshal(body, &evs); err != nil {
+		return nil, errors.Wrap(err, "JSON unmarshal failed")
+	}
+
+	sortByWhen(evs)
+
+	return evs, nil
+}
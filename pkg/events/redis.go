@@ -0,0 +1,228 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Redis stores events in a redis sorted set scored by event time.
+type Redis struct {
+	cfg     RedisConfig
+	size    int
+	timeout time.Duration
+}
+
+// NewRedis creates a store keeping up to size events in redis.
+func NewRedis(cfg RedisConfig, size int, timeout time.Duration) *Redis {
+	if cfg.Key == "" {
+		cfg.Key = "carbonapi:events"
+	}
+
+	return &Redis{
+		cfg:     cfg,
+		size:    size,
+		timeout: timeout,
+	}
+}
+
+// Add stores an event and trims the set down to the configured size.
+func (r *Redis) Add(ctx context.Context, e Event) error {
+	if e.What == "" {
+		return ErrEmptyWhat
+	}
+
+	if e.When == 0 {
+		e.When = time.Now().Unix()
+	}
+
+	c, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	id, err := c.do("INCR", r.cfg.Key+":id")
+	if err != nil {
+		return err
+	}
+	e.ID, _ = id.(int64)
+
+	blob, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.do("ZADD", r.cfg.Key, strconv.FormatInt(e.When, 10), string(blob)); err != nil {
+		return err
+	}
+
+	_, err = c.do("ZREMRANGEBYRANK", r.cfg.Key, "0", strconv.Itoa(-r.size-1))
+
+	return err
+}
+
+// Find returns the events matching the query, oldest first.
+func (r *Redis) Find(ctx context.Context, q Query) ([]Event, error) {
+	c, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	reply, err := c.do("ZRANGEBYSCORE", r.cfg.Key, strconv.Itoa(int(q.From)), strconv.Itoa(int(q.Until)))
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New("redis: unexpected reply to ZRANGEBYSCORE")
+	}
+
+	evs := make([]Event, 0, len(items))
+	for _, item := range items {
+		blob, ok := item.([]byte)
+		if !ok {
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal(blob, &e); err != nil {
+			continue
+		}
+
+		if q.Match(e) {
+			evs = append(evs, e)
+		}
+	}
+
+	sortByWhen(evs)
+
+	return evs, nil
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: r.timeout}
+	conn, err := d.DialContext(ctx, "tcp", r.cfg.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "redis: dial failed")
+	}
+
+	deadline := time.Now().Add(r.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	if r.cfg.Password != "" {
+		if _, err := c.do("AUTH", r.cfg.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if r.cfg.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(r.cfg.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do sends a command using the RESP protocol and reads a single reply.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	if _, err := c.Write(buf); err != nil {
+		return nil, errors.Wrap(err, "redis: write failed")
+	}
+
+	return readReply(c.r)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "redis: read failed")
+	}
+
+	if len(line) < 3 {
+		return nil, errors.New("redis: short reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return nil, errors.Errorf("redis: %s", line[1:])
+
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "redis: bad bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, errors.Wrap(err, "redis: read failed")
+		}
+
+		return b[:n], nil
+
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "redis: bad array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+
+		return items, nil
+	}
+
+	return nil, errors.Errorf("redis: unknown reply type '%c'", line[0])
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Ring is an in-memory store keeping the most recent events.
+type Ring struct {
+	mutex  sync.RWMutex
+	events []Event
+	next   int
+	full   bool
+	lastID int64
+}
+
+// NewRing creates an in-memory store holding up to size events.
+func NewRing(size int) *Ring {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &Ring{
+		events: make([]Event, size),
+	}
+}
+
+// Add stores an event, evicting the oldest one if the ring is full.
+func (r *Ring) Add(ctx context.Context, e Event) error {
+	if e.What == "" {
+		return ErrEmptyWhat
+	}
+
+	if e.When == 0 {
+		e.When = time.Now().Unix()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lastID++
+	e.ID = r.lastID
+
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+
+	return nil
+}
+
+// Find returns the events matching the query, oldest first.
+func (r *Ring) Find(ctx context.Context, q Query) ([]Event, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.events)
+	}
+
+	evs := make([]Event, 0)
+	for i := 0; i < n; i++ {
+		if e := r.events[i]; q.Match(e) {
+			evs = append(evs, e)
+		}
+	}
+
+	sortByWhen(evs)
+
+	return evs, nil
+}
@@ -0,0 +1,241 @@
+/*
+Package filter evaluates the filtering functions of Graphite, those that
+keep some of the series of a list as they are, on fetched metrics, so that
+the zippers and stores that fetch them can return only the series the
+function keeps.
+
+The functions are given without their series list, e.g. highestAverage(x.*, 5)
+is the function highestAverage with the arguments ["5"], and
+filterSeries(x.*, 'max', '>', 100) is filterSeries with ["max", ">", "100"].
+The series of ties are all kept, so that applying a function to a superset
+of the series, such as a list a store has filtered already, keeps what
+applying it to the full list would.
+
+Example use:
+
+	fs := []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"5"}}}
+	if err := Check(fs); err != nil {
+		return err
+	}
+	metrics = Apply(metrics, fs)
+*/
+package filter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// Names are the functions the package evaluates.
+var Names = []string{
+	"filterSeries",
+	"highestAverage",
+	"highestCurrent",
+	"highestMax",
+	"lowestAverage",
+	"lowestCurrent",
+}
+
+// ranks are the aggregations the highest and lowest functions rank by.
+var ranks = map[string]string{
+	"highestAverage": "average",
+	"highestCurrent": "current",
+	"highestMax":     "max",
+	"lowestAverage":  "average",
+	"lowestCurrent":  "current",
+}
+
+var aggregations = map[string]func(types.Metric) float64{
+	"average": average,
+	"avg":     average,
+	"current": current,
+	"last":    current,
+	"max":     max,
+	"min":     min,
+	"sum":     sum,
+}
+
+var operators = map[string]func(v, threshold float64) bool{
+	"=":  func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+}
+
+// Check returns an error for the first of fs that isn't a function the
+// package evaluates with valid arguments.
+func Check(fs []types.FilterFunction) error {
+	for _, f := range fs {
+		if _, err := compile(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Apply returns the metrics all of fs keep, in turn. Functions Check
+// rejects keep all metrics.
+func Apply(metrics []types.Metric, fs []types.FilterFunction) []types.Metric {
+	for _, f := range fs {
+		keep, err := compile(f)
+		if err != nil {
+			continue
+		}
+		metrics = keep(metrics)
+	}
+
+	return metrics
+}
+
+func compile(f types.FilterFunction) (func([]types.Metric) []types.Metric, error) {
+	if agg, ok := ranks[f.Name]; ok {
+		if len(f.Arguments) != 1 {
+			return nil, fmt.Errorf("%s takes the number of series to keep", f.Name)
+		}
+		n, err := strconv.Atoi(f.Arguments[0])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s: bad number of series %q", f.Name, f.Arguments[0])
+		}
+		highest := f.Name[0] == 'h'
+		return func(metrics []types.Metric) []types.Metric {
+			return top(metrics, n, aggregations[agg], highest)
+		}, nil
+	}
+
+	if f.Name != "filterSeries" {
+		return nil, fmt.Errorf("unknown filtering function %q", f.Name)
+	}
+	if len(f.Arguments) != 3 {
+		return nil, fmt.Errorf("filterSeries takes an aggregation, an operator and a threshold")
+	}
+	agg, ok := aggregations[f.Arguments[0]]
+	if !ok {
+		return nil, fmt.Errorf("filterSeries: unknown aggregation %q", f.Arguments[0])
+	}
+	op, ok := operators[f.Arguments[1]]
+	if !ok {
+		return nil, fmt.Errorf("filterSeries: unknown operator %q", f.Arguments[1])
+	}
+	threshold, err := strconv.ParseFloat(f.Arguments[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("filterSeries: bad threshold %q", f.Arguments[2])
+	}
+
+	return func(metrics []types.Metric) []types.Metric {
+		kept := metrics[:0:0]
+		for _, m := range metrics {
+			if v := agg(m); !math.IsNaN(v) && op(v, threshold) {
+				kept = append(kept, m)
+			}
+		}
+		return kept
+	}, nil
+}
+
+// top returns the n metrics with the highest, or lowest, aggregates, and
+// those tied with the last of them. Metrics without an aggregate are left
+// out, as the functions of the render API leave them out.
+func top(metrics []types.Metric, n int, agg func(types.Metric) float64, highest bool) []types.Metric {
+	type ranked struct {
+		i int
+		v float64
+	}
+	rs := make([]ranked, 0, len(metrics))
+	for i, m := range metrics {
+		if v := agg(m); !math.IsNaN(v) {
+			rs = append(rs, ranked{i, v})
+		}
+	}
+	if len(rs) <= n {
+		kept := make([]types.Metric, 0, len(rs))
+		for _, r := range rs {
+			kept = append(kept, metrics[r.i])
+		}
+		return kept
+	}
+
+	sort.SliceStable(rs, func(i, j int) bool {
+		if highest {
+			return rs[i].v > rs[j].v
+		}
+		return rs[i].v < rs[j].v
+	})
+	cut := n
+	for n > 0 && cut < len(rs) && rs[cut].v == rs[n-1].v {
+		cut++
+	}
+	rs = rs[:cut]
+	sort.Slice(rs, func(i, j int) bool { return rs[i].i < rs[j].i })
+
+	kept := make([]types.Metric, 0, len(rs))
+	for _, r := range rs {
+		kept = append(kept, metrics[r.i])
+	}
+
+	return kept
+}
+
+func average(m types.Metric) float64 {
+	var total float64
+	var n int
+	for i, v := range m.Values {
+		if !m.IsAbsent[i] {
+			total += v
+			n++
+		}
+	}
+
+	return total / float64(n)
+}
+
+func current(m types.Metric) float64 {
+	for i := len(m.Values) - 1; i >= 0; i-- {
+		if !m.IsAbsent[i] {
+			return m.Values[i]
+		}
+	}
+
+	return math.NaN()
+}
+
+// max and min are those of the render API, -Inf and +Inf for metrics
+// without values.
+func max(m types.Metric) float64 {
+	v := math.Inf(-1)
+	for i, x := range m.Values {
+		if !m.IsAbsent[i] && x > v {
+			v = x
+		}
+	}
+
+	return v
+}
+
+func min(m types.Metric) float64 {
+	v := math.Inf(1)
+	for i, x := range m.Values {
+		if !m.IsAbsent[i] && x < v {
+			v = x
+		}
+	}
+
+	return v
+}
+
+func sum(m types.Metric) float64 {
+	var total float64
+	for i, v := range m.Values {
+		if !m.IsAbsent[i] {
+			total += v
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,97 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func series(name string, values ...float64) types.Metric {
+	m := types.Metric{Name: name}
+	for _, v := range values {
+		m.Values = append(m.Values, v)
+		m.IsAbsent = append(m.IsAbsent, math.IsNaN(v))
+	}
+
+	return m
+}
+
+func TestApply(t *testing.T) {
+	nan := math.NaN()
+	metrics := []types.Metric{
+		series("a", 1, 2, 3),
+		series("b", 10, 0, nan),
+		series("c", 4, 4, 4),
+		series("d", nan, nan),
+		series("e", 0, 8, 1),
+	}
+
+	tests := []struct {
+		name     string
+		filters  []types.FilterFunction
+		expected string
+	}{
+		{"highestAverage", []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"2"}}}, "[b c]"},
+		{"highestCurrent", []types.FilterFunction{{Name: "highestCurrent", Arguments: []string{"1"}}}, "[c]"},
+		{"highestMax", []types.FilterFunction{{Name: "highestMax", Arguments: []string{"2"}}}, "[b e]"},
+		{"lowestAverage", []types.FilterFunction{{Name: "lowestAverage", Arguments: []string{"1"}}}, "[a]"},
+		{"lowestCurrent", []types.FilterFunction{{Name: "lowestCurrent", Arguments: []string{"2"}}}, "[b e]"},
+		{"fewer than n", []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"10"}}}, "[a b c e]"},
+		{"filterSeries", []types.FilterFunction{{Name: "filterSeries", Arguments: []string{"max", ">=", "4"}}}, "[b c e]"},
+		{"filterSeries sum", []types.FilterFunction{{Name: "filterSeries", Arguments: []string{"sum", "<", "9"}}}, "[a d]"},
+		{"in turn", []types.FilterFunction{
+			{Name: "filterSeries", Arguments: []string{"max", ">=", "4"}},
+			{Name: "lowestAverage", Arguments: []string{"1"}},
+		}, "[e]"},
+		{"unknown", []types.FilterFunction{{Name: "sortByName"}}, "[a b c d e]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, m := range Apply(metrics, tt.filters) {
+				names = append(names, m.Name)
+			}
+			if got := fmt.Sprint(names); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestApplyKeepsTies(t *testing.T) {
+	metrics := []types.Metric{series("a", 1), series("b", 2), series("c", 2), series("d", 0)}
+
+	var names []string
+	for _, m := range Apply(metrics, []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"1"}}}) {
+		names = append(names, m.Name)
+	}
+	if fmt.Sprint(names) != "[b c]" {
+		t.Errorf("expected [b c], got %v", names)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		filter types.FilterFunction
+		ok     bool
+	}{
+		{types.FilterFunction{Name: "highestAverage", Arguments: []string{"5"}}, true},
+		{types.FilterFunction{Name: "highestAverage"}, false},
+		{types.FilterFunction{Name: "lowestCurrent", Arguments: []string{"-1"}}, false},
+		{types.FilterFunction{Name: "filterSeries", Arguments: []string{"avg", "!=", "0.5"}}, true},
+		{types.FilterFunction{Name: "filterSeries", Arguments: []string{"median", ">", "1"}}, false},
+		{types.FilterFunction{Name: "filterSeries", Arguments: []string{"max", "~", "1"}}, false},
+		{types.FilterFunction{Name: "filterSeries", Arguments: []string{"max", ">", "x"}}, false},
+		{types.FilterFunction{Name: "sortByName"}, false},
+	}
+
+	for _, tt := range tests {
+		err := Check([]types.FilterFunction{tt.filter})
+		if (err == nil) != tt.ok {
+			t.Errorf("%+v: expected ok %v, got error %v", tt.filter, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Package histogram keeps durations in Prometheus histograms, and reads their
+buckets back as expvars so that graphite is sent the counts Prometheus
+scrapes, rather than counts kept on the side.
+
+Example use:
+
+	v := New(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "The duration of HTTP requests",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, "handler")
+	v.Observe(250*time.Millisecond, "render")
+	for i := 0; i < v.Buckets(); i++ {
+		lower, upper := v.Bounds(i)
+		graphite.Register(fmt.Sprintf("requests_in_%dms_to_%dms", lower, upper), v.Bucket(i))
+	}
+*/
+package histogram
+
+import (
+	"expvar"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Vec is a histogram of durations, in seconds, partitioned by labels.
+type Vec struct {
+	*prometheus.HistogramVec
+	bounds []float64
+}
+
+// New returns a histogram with the buckets of opts, or the Prometheus
+// default ones.
+func New(opts prometheus.HistogramOpts, labels ...string) *Vec {
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	return &Vec{
+		HistogramVec: prometheus.NewHistogramVec(opts, labels),
+		bounds:       opts.Buckets,
+	}
+}
+
+// Observe counts d for the label values. It does nothing on a nil Vec.
+func (v *Vec) Observe(d time.Duration, labels ...string) {
+	if v == nil {
+		return
+	}
+
+	v.WithLabelValues(labels...).Observe(d.Seconds())
+}
+
+// Buckets returns the number of buckets, counting the last one, of the
+// durations over all the bounds.
+func (v *Vec) Buckets() int {
+	return len(v.bounds) + 1
+}
+
+// Bounds returns the bounds of bucket i in milliseconds. The last bucket,
+// which has no upper bound, is given one as far from its lower bound as
+// the bucket before it: the same distance if the buckets are linear, the
+// same ratio otherwise.
+func (v *Vec) Bounds(i int) (lower, upper int) {
+	n := len(v.bounds)
+
+	var lo, up float64
+	if i > 0 {
+		lo = v.bounds[i-1]
+	}
+	switch {
+	case i < n:
+		up = v.bounds[i]
+	case n == 1:
+		up = 2 * lo
+	case linear(v.bounds):
+		up = lo + v.bounds[n-1] - v.bounds[n-2]
+	default:
+		up = lo * v.bounds[n-1] / v.bounds[n-2]
+	}
+
+	return ms(lo), ms(up)
+}
+
+func linear(bounds []float64) bool {
+	step := bounds[1] - bounds[0]
+	for i := 2; i < len(bounds); i++ {
+		if math.Abs(bounds[i]-bounds[i-1]-step) > 1e-9 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ms(seconds float64) int {
+	return int(math.Round(seconds * 1000))
+}
+
+// Bucket returns the number of durations in bucket i, over all the label
+// values, as an expvar.
+func (v *Vec) Bucket(i int) expvar.Var {
+	return bucket{v: v, i: i}
+}
+
+type bucket struct {
+	v *Vec
+	i int
+}
+
+func (b bucket) String() string {
+	return strconv.FormatUint(b.v.Counts()[b.i], 10)
+}
+
+// Counts returns the number of durations in each bucket, over all the label
+// values.
+func (v *Vec) Counts() []uint64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		v.Collect(ch)
+		close(ch)
+	}()
+
+	counts := make([]uint64, v.Buckets())
+	for m := range ch {
+		var d dto.Metric
+		if m.Write(&d) != nil || d.Histogram == nil {
+			continue
+		}
+		// Prometheus keeps cumulative counts, the count of a bucket is
+		// the difference with the one before it.
+		for i := range counts {
+			counts[i] += cumulative(d.Histogram, i) - cumulative(d.Histogram, i-1)
+		}
+	}
+
+	return counts
+}
+
+func cumulative(h *dto.Histogram, i int) uint64 {
+	buckets := h.GetBucket()
+	switch {
+	case i < 0:
+		return 0
+	case i >= len(buckets):
+		return h.GetSampleCount()
+	default:
+		return buckets[i].GetCumulativeCount()
+	}
+}
@@ -0,0 +1,72 @@
+package histogram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		buckets []float64
+		want    [][2]int
+	}{
+		{
+			name:    "linear",
+			buckets: prometheus.LinearBuckets(0.1, 0.1, 3),
+			want:    [][2]int{{0, 100}, {100, 200}, {200, 300}, {300, 400}},
+		},
+		{
+			name:    "exponential",
+			buckets: prometheus.ExponentialBuckets(0.05, 2, 3),
+			want:    [][2]int{{0, 50}, {50, 100}, {100, 200}, {200, 400}},
+		},
+		{
+			name:    "single",
+			buckets: []float64{0.5},
+			want:    [][2]int{{0, 500}, {500, 1000}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New(prometheus.HistogramOpts{Name: "test", Buckets: tt.buckets})
+			if v.Buckets() != len(tt.want) {
+				t.Fatalf("Expected %d buckets, got %d", len(tt.want), v.Buckets())
+			}
+			for i, want := range tt.want {
+				if lower, upper := v.Bounds(i); lower != want[0] || upper != want[1] {
+					t.Errorf("Expected bucket %d to be %v, got [%d %d]", i, want, lower, upper)
+				}
+			}
+		})
+	}
+}
+
+func TestBucket(t *testing.T) {
+	v := New(prometheus.HistogramOpts{
+		Name:    "test",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 2),
+	}, "handler")
+
+	v.Observe(50*time.Millisecond, "render")
+	v.Observe(150*time.Millisecond, "render")
+	v.Observe(150*time.Millisecond, "find")
+	v.Observe(time.Second, "find")
+
+	want := []string{"1", "2", "1"}
+	for i, w := range want {
+		if got := v.Bucket(i).String(); got != w {
+			t.Errorf("Expected %s durations in bucket %d, got %s", w, i, got)
+		}
+	}
+
+	if counts := v.Counts(); len(counts) != 3 || counts[0] != 1 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("Expected counts [1 2 1], got %v", counts)
+	}
+
+	var nilVec *Vec
+	nilVec.Observe(time.Second, "render")
+}
@@ -0,0 +1,103 @@
+package index
+
+import (
+	"regexp"
+	"strings"
+)
+
+// globToRegexp translates a graphite glob into an anchored regular
+// expression. Wildcards never match across dots.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			b.WriteString(`[^.]*`)
+		case '?':
+			b.WriteString(`[^.]`)
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(glob[i:]))
+				i = len(glob)
+				break
+			}
+			b.WriteString(strings.Replace(glob[i:i+end+1], `\`, `\\`, -1))
+			i += end
+		case '{':
+			braces++
+			b.WriteString(`(?:`)
+		case ',':
+			if braces > 0 {
+				b.WriteByte('|')
+			} else {
+				b.WriteByte(',')
+			}
+		case '}':
+			if braces > 0 {
+				braces--
+				b.WriteByte(')')
+			} else {
+				b.WriteString(`\}`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	for ; braces > 0; braces-- {
+		b.WriteByte(')')
+	}
+
+	b.WriteByte('$')
+
+	return regexp.Compile(b.String())
+}
+
+// literals returns the fixed prefix of a glob and the literal fragments
+// every match must contain. Alternatives inside braces are optional and
+// therefore skipped.
+func literals(glob string) (string, []string) {
+	prefix := len(glob)
+	if i := strings.IndexAny(glob, "*?[{"); i >= 0 {
+		prefix = i
+	}
+
+	var frags []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			frags = append(frags, cur.String())
+			cur.Reset()
+		}
+	}
+
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '{':
+			braces++
+			flush()
+		case c == '}' && braces > 0:
+			braces--
+		case braces > 0:
+		case c == '[':
+			flush()
+			if end := strings.IndexByte(glob[i:], ']'); end >= 0 {
+				i += end
+			}
+		case c == '*' || c == '?':
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+
+	return glob[:prefix], frags
+}
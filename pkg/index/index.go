@@ -0,0 +1,196 @@
+/*
+Package index implements an in-memory index of metric names that answers
+find queries without asking the backends.
+
+Names are kept sorted so that queries with a fixed prefix only look at a
+range of them, and every name is indexed by its trigrams so that queries
+with literal fragments, e.g. *.cpu.*, only look at names containing them.
+
+Example use:
+
+	idx := New(names)
+	matches, err := idx.Find("foo.*.bar")
+*/
+package index
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// Index is an immutable set of metric names.
+type Index struct {
+	names    []string
+	trigrams map[string][]int32
+	built    time.Time
+}
+
+// New builds an index from the given metric names. Duplicates are dropped.
+func New(names []string) *Index {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
+	uniq := sorted[:0]
+	for i, n := range sorted {
+		if i == 0 || n != sorted[i-1] {
+			uniq = append(uniq, n)
+		}
+	}
+
+	idx := &Index{
+		names:    uniq,
+		trigrams: make(map[string][]int32),
+		built:    time.Now(),
+	}
+
+	seen := make(map[string]struct{})
+	for i, n := range uniq {
+		for k := range seen {
+			delete(seen, k)
+		}
+
+		for j := 0; j+3 <= len(n); j++ {
+			t := n[j : j+3]
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			idx.trigrams[t] = append(idx.trigrams[t], int32(i))
+		}
+	}
+
+	return idx
+}
+
+// Len returns the number of metric names in the index.
+func (idx *Index) Len() int {
+	return len(idx.names)
+}
+
+// Age returns how long ago the index was built.
+func (idx *Index) Age() time.Duration {
+	return time.Since(idx.built)
+}
+
+// Find returns the paths matching a graphite glob, the same way a backend's
+// /metrics/find does: a path is a leaf if it is a full metric name and a
+// branch if it is a prefix of longer ones.
+func (idx *Index) Find(query string) (types.Matches, error) {
+	re, err := globToRegexp(query)
+	if err != nil {
+		return types.Matches{}, err
+	}
+
+	depth := strings.Count(query, ".") + 1
+
+	type key struct {
+		path string
+		leaf bool
+	}
+	seen := make(map[key]struct{})
+
+	res := types.Matches{Name: query}
+	for _, i := range idx.candidates(query) {
+		name := idx.names[i]
+
+		path, ok := prefix(name, depth)
+		if !ok || !re.MatchString(path) {
+			continue
+		}
+
+		k := key{path: path, leaf: path == name}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		res.Matches = append(res.Matches, types.Match{Path: k.path, IsLeaf: k.leaf})
+	}
+
+	return res, nil
+}
+
+// candidates returns the positions of the names that may match the query.
+func (idx *Index) candidates(query string) []int32 {
+	pfx, frags := literals(query)
+
+	lo := sort.SearchStrings(idx.names, pfx)
+	hi := lo + sort.Search(len(idx.names)-lo, func(i int) bool {
+		return !strings.HasPrefix(idx.names[lo+i], pfx)
+	})
+
+	var set []int32
+	filtered := false
+	for _, f := range frags {
+		for j := 0; j+3 <= len(f); j++ {
+			posting := idx.trigrams[f[j:j+3]]
+			if !filtered {
+				set = posting
+				filtered = true
+			} else {
+				set = intersect(set, posting)
+			}
+
+			if len(set) == 0 {
+				return nil
+			}
+		}
+	}
+
+	if !filtered {
+		set = make([]int32, 0, hi-lo)
+		for i := lo; i < hi; i++ {
+			set = append(set, int32(i))
+		}
+		return set
+	}
+
+	res := make([]int32, 0, len(set))
+	for _, i := range set {
+		if int(i) >= lo && int(i) < hi {
+			res = append(res, i)
+		}
+	}
+
+	return res
+}
+
+func intersect(a, b []int32) []int32 {
+	res := make([]int32, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+
+	return res
+}
+
+// prefix returns the first n dot separated segments of name.
+func prefix(name string, n int) (string, bool) {
+	end := 0
+	for ; n > 0; n-- {
+		i := strings.IndexByte(name[end:], '.')
+		if i < 0 {
+			return name, n == 1
+		}
+
+		if n == 1 {
+			return name[:end+i], true
+		}
+		end += i + 1
+	}
+
+	return "", false
+}
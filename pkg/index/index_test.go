@@ -0,0 +1,84 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+var names = []string{
+	"carbon.agents.host1.cpu",
+	"carbon.agents.host2.cpu",
+	"carbon.agents.host2.memory",
+	"servers.web01.cpu.user",
+	"servers.web01.cpu.system",
+	"servers.web02.cpu.user",
+	"servers.db01.disk.used",
+	"servers.web01.cpu.user", // duplicate
+}
+
+func TestFind(t *testing.T) {
+	idx := New(names)
+
+	tests := []struct {
+		query string
+		want  []types.Match
+	}{
+		{"servers", []types.Match{{Path: "servers"}}},
+		{"*", []types.Match{{Path: "carbon"}, {Path: "servers"}}},
+		{"servers.*", []types.Match{{Path: "servers.db01"}, {Path: "servers.web01"}, {Path: "servers.web02"}}},
+		{"servers.web*.cpu.user", []types.Match{
+			{Path: "servers.web01.cpu.user", IsLeaf: true},
+			{Path: "servers.web02.cpu.user", IsLeaf: true},
+		}},
+		{"*.*.*.cpu", []types.Match{
+			{Path: "carbon.agents.host1.cpu", IsLeaf: true},
+			{Path: "carbon.agents.host2.cpu", IsLeaf: true},
+		}},
+		{"servers.{web01,db01}.*", []types.Match{{Path: "servers.db01.disk"}, {Path: "servers.web01.cpu"}}},
+		{"servers.web0[2-9].cpu.*", []types.Match{{Path: "servers.web02.cpu.user", IsLeaf: true}}},
+		{"servers.web0?.cpu.sys*", []types.Match{{Path: "servers.web01.cpu.system", IsLeaf: true}}},
+		{"carbon.agents.host2.cpu.idle", nil},
+		{"nothing.*", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := idx.Find(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got.Matches, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got.Matches)
+			}
+		})
+	}
+}
+
+func TestLen(t *testing.T) {
+	if got := New(names).Len(); got != 7 {
+		t.Errorf("expected duplicates to be dropped, got %d names", got)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	tests := []struct {
+		glob   string
+		prefix string
+		frags  []string
+	}{
+		{"foo.bar", "foo.bar", []string{"foo.bar"}},
+		{"foo.*.bar", "foo.", []string{"foo.", ".bar"}},
+		{"*.{a,b}.cpu", "", []string{".", ".cpu"}},
+		{"foo.b[ae]r.x?z", "foo.b", []string{"foo.b", "r.x", "z"}},
+	}
+
+	for _, tt := range tests {
+		prefix, frags := literals(tt.glob)
+		if prefix != tt.prefix || !reflect.DeepEqual(frags, tt.frags) {
+			t.Errorf("%s: expected %q %q, got %q %q", tt.glob, tt.prefix, tt.frags, prefix, frags)
+		}
+	}
+}
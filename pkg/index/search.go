@@ -0,0 +1,95 @@
+package index
+
+import (
+	"sort"
+	"strings"
+)
+
+// Match kinds, best first.
+const (
+	matchExact = iota
+	matchPrefix
+	matchSegment
+	matchSubstring
+	matchFuzzy
+)
+
+type searchResult struct {
+	name  string
+	kind  int
+	score int
+}
+
+// Search returns up to limit metric names matching query, ignoring case.
+// Names containing the query come first, ranked exact, prefix, at the start
+// of a segment and anywhere else; then names containing the characters of
+// the query in order, ranked by how spread out they are. Shorter names win
+// ties. A limit <= 0 means no limit.
+func (idx *Index) Search(query string, limit int) []string {
+	query = strings.ToLower(query)
+	if query == "" {
+		return nil
+	}
+
+	var found []searchResult
+	for _, name := range idx.names {
+		if r, ok := rank(strings.ToLower(name), query); ok {
+			r.name = name
+			found = append(found, r)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if len(a.name) != len(b.name) {
+			return len(a.name) < len(b.name)
+		}
+		return a.name < b.name
+	})
+
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+
+	res := make([]string, 0, len(found))
+	for _, r := range found {
+		res = append(res, r.name)
+	}
+
+	return res
+}
+
+// rank scores a lower cased name against a lower cased query, lower is better.
+func rank(name, query string) (searchResult, bool) {
+	switch i := strings.Index(name, query); {
+	case name == query:
+		return searchResult{kind: matchExact}, true
+	case i == 0:
+		return searchResult{kind: matchPrefix}, true
+	case i > 0 && name[i-1] == '.':
+		return searchResult{kind: matchSegment, score: i}, true
+	case i > 0:
+		return searchResult{kind: matchSubstring, score: i}, true
+	}
+
+	// fuzzy: every query character in order, scored by the gaps between them
+	gaps, last := 0, -1
+	for i := 0; i < len(query); i++ {
+		j := strings.IndexByte(name[last+1:], query[i])
+		if j < 0 {
+			return searchResult{}, false
+		}
+		if last >= 0 {
+			gaps += j
+		}
+		last += j + 1
+	}
+
+	return searchResult{kind: matchFuzzy, score: gaps}, true
+}
@@ -0,0 +1,45 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	idx := New([]string{
+		"servers.web01.cpu.user",
+		"servers.web01.CPU.system",
+		"servers.db01.disk.used",
+		"cpu",
+		"cpu.total",
+		"apps.cpuhog.count",
+		"servers.web01.memory.cached",
+	})
+
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"cpu", 0, []string{
+			"cpu",
+			"cpu.total",
+			"apps.cpuhog.count",
+			"servers.web01.cpu.user",
+			"servers.web01.CPU.system",
+		}},
+		{"cpu", 2, []string{"cpu", "cpu.total"}},
+		{"WEB01.CPU.USER", 0, []string{"servers.web01.cpu.user"}},
+		{"dbused", 0, []string{"servers.db01.disk.used"}},
+		{"web01mc", 0, []string{"servers.web01.memory.cached"}},
+		{"nothere", 0, []string{}},
+		{"", 0, nil},
+	}
+
+	for _, tt := range tests {
+		got := idx.Search(tt.query, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q, %d): got %v, want %v", tt.query, tt.limit, got, tt.want)
+		}
+	}
+}
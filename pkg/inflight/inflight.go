@@ -0,0 +1,204 @@
+/*
+Package inflight keeps the queries being served and their progress, so that
+they can be listed, and cancelled, while they run.
+
+Example use:
+
+	queries := NewRegistry()
+
+	ctx, done := queries.Start(ctx, "render", targets)
+	defer done()
+
+	// and in the backend client, for each call
+	finish := FromContext(ctx).Call(address)
+	defer finish()
+	body := FromContext(ctx).Counting(resp.Body)
+*/
+package inflight
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/util"
+)
+
+type key int
+
+const queryKey key = 0
+
+// Query is a query being served. A nil Query tracks nothing.
+type Query struct {
+	id      string
+	uuid    string
+	handler string
+	targets []string
+	started time.Time
+	cancel  context.CancelFunc
+
+	bytes int64 // atomic
+
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+// Status is a snapshot of a query.
+type Status struct {
+	ID      string   `json:"id"`
+	UUID    string   `json:"uuid"`
+	Handler string   `json:"handler"`
+	Targets []string `json:"targets"`
+	// Age is how long the query has run, in seconds.
+	Age float64 `json:"age"`
+	// Pending are the backends still to answer.
+	Pending []string `json:"pending"`
+	// BytesReceived is what the backends sent so far.
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// FromContext returns the query ctx serves, nil if there is none.
+func FromContext(ctx context.Context) *Query {
+	q, _ := ctx.Value(queryKey).(*Query)
+	return q
+}
+
+// Call counts a call to the backend at address as pending until the
+// returned func is called.
+func (q *Query) Call(address string) func() {
+	if q == nil {
+		return func() {}
+	}
+
+	q.mu.Lock()
+	q.pending[address]++
+	q.mu.Unlock()
+
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if q.pending[address]--; q.pending[address] <= 0 {
+			delete(q.pending, address)
+		}
+	}
+}
+
+// Counting returns r counting what is read from it as received.
+func (q *Query) Counting(r io.Reader) io.Reader {
+	if q == nil {
+		return r
+	}
+
+	return countingReader{r: r, q: q}
+}
+
+type countingReader struct {
+	r io.Reader
+	q *Query
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.q.bytes, int64(n))
+
+	return n, err
+}
+
+func (q *Query) status(now time.Time) Status {
+	q.mu.Lock()
+	pending := make([]string, 0, len(q.pending))
+	for address := range q.pending {
+		pending = append(pending, address)
+	}
+	q.mu.Unlock()
+	sort.Strings(pending)
+
+	return Status{
+		ID:            q.id,
+		UUID:          q.uuid,
+		Handler:       q.handler,
+		Targets:       q.targets,
+		Age:           now.Sub(q.started).Seconds(),
+		Pending:       pending,
+		BytesReceived: atomic.LoadInt64(&q.bytes),
+	}
+}
+
+// Registry keeps the queries being served.
+type Registry struct {
+	mu      sync.Mutex
+	next    uint64
+	queries map[string]*Query
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{queries: make(map[string]*Query)}
+}
+
+// Start registers a query of handler for targets, served with the returned
+// context, which is cancelled when the query is. The query is served until
+// the returned func is called.
+func (r *Registry) Start(ctx context.Context, handler string, targets []string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	r.mu.Lock()
+	r.next++
+	// Requests of a carbonapi share its UUID, so queries get their own ID.
+	q := &Query{
+		id:      strconv.FormatUint(r.next, 10),
+		uuid:    util.GetUUID(ctx),
+		handler: handler,
+		targets: targets,
+		started: time.Now(),
+		cancel:  cancel,
+		pending: make(map[string]int),
+	}
+	r.queries[q.id] = q
+	r.mu.Unlock()
+
+	return context.WithValue(ctx, queryKey, q), func() {
+		r.mu.Lock()
+		delete(r.queries, q.id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// List returns the queries being served, oldest first.
+func (r *Registry) List() []Status {
+	r.mu.Lock()
+	queries := make([]*Query, 0, len(r.queries))
+	for _, q := range r.queries {
+		queries = append(queries, q)
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	list := make([]Status, len(queries))
+	for i, q := range queries {
+		list[i] = q.status(now)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Age > list[j].Age })
+
+	return list
+}
+
+// Cancel cancels the query with the given ID, and reports whether there was
+// one.
+func (r *Registry) Cancel(id string) bool {
+	r.mu.Lock()
+	q, ok := r.queries[id]
+	r.mu.Unlock()
+
+	if ok {
+		q.cancel()
+	}
+
+	return ok
+}
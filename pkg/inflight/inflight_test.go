@@ -0,0 +1,62 @@
+package inflight
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	ctx, done := r.Start(context.Background(), "render", []string{"foo.*"})
+	q := FromContext(ctx)
+	if q == nil {
+		t.Fatal("Expected the query in its context")
+	}
+
+	finishA := q.Call("a:8080")
+	finishB := q.Call("b:8080")
+	if _, err := ioutil.ReadAll(q.Counting(strings.NewReader("12345"))); err != nil {
+		t.Fatal(err)
+	}
+	finishA()
+
+	list := r.List()
+	if len(list) != 1 {
+		t.Fatalf("Expected one query, got %v", list)
+	}
+	s := list[0]
+	if s.Handler != "render" || len(s.Targets) != 1 || s.Targets[0] != "foo.*" {
+		t.Errorf("Unexpected query %+v", s)
+	}
+	if len(s.Pending) != 1 || s.Pending[0] != "b:8080" {
+		t.Errorf("Expected b to be pending, got %v", s.Pending)
+	}
+	if s.BytesReceived != 5 {
+		t.Errorf("Expected 5 bytes received, got %d", s.BytesReceived)
+	}
+	finishB()
+
+	if r.Cancel("unknown") {
+		t.Error("Expected unknown queries not to be cancelled")
+	}
+	if !r.Cancel(s.ID) || ctx.Err() != context.Canceled {
+		t.Error("Expected the query to be cancelled")
+	}
+
+	done()
+	if list := r.List(); len(list) != 0 {
+		t.Errorf("Expected no queries once done, got %v", list)
+	}
+}
+
+func TestNilQuery(t *testing.T) {
+	q := FromContext(context.Background())
+	q.Call("a:8080")()
+	r := strings.NewReader("x")
+	if q.Counting(r) != r {
+		t.Error("Expected a nil query to leave readers as they are")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Package intern deduplicates strings, so that equal metric names held by
+different caches and responses share one allocation.
+
+A Pool forgets the strings nobody asked for during a whole generation, so it
+doesn't keep metrics that were deleted from the backends forever:
+
+	names := intern.New()
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			names.Rotate()
+		}
+	}()
+	name = names.String(name)
+*/
+package intern
+
+import (
+	"sync"
+)
+
+// Pool is a set of interned strings. It is safe for concurrent use. A nil
+// Pool interns nothing, its strings are returned as they are.
+type Pool struct {
+	mu       sync.Mutex
+	current  map[string]string
+	previous map[string]string
+	// promoted counts the strings of previous also in current.
+	promoted int
+}
+
+// New creates an empty pool.
+func New() *Pool {
+	return &Pool{
+		current:  make(map[string]string),
+		previous: make(map[string]string),
+	}
+}
+
+// String returns the pooled string equal to s, adding s if there is none.
+func (p *Pool) String(s string) string {
+	if p == nil {
+		return s
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.intern(s)
+}
+
+// Strings replaces the strings of ss by their pooled copies.
+func (p *Pool) Strings(ss []string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, s := range ss {
+		ss[i] = p.intern(s)
+	}
+}
+
+func (p *Pool) intern(s string) string {
+	if is, ok := p.current[s]; ok {
+		return is
+	}
+
+	if is, ok := p.previous[s]; ok {
+		s = is
+		p.promoted++
+	}
+	p.current[s] = s
+
+	return s
+}
+
+// Rotate starts a new generation. Strings not interned since the previous
+// call are dropped from the pool.
+func (p *Pool) Rotate() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.previous = p.current
+	p.current = make(map[string]string, len(p.previous))
+	p.promoted = 0
+}
+
+// Len returns the number of strings in the pool.
+func (p *Pool) Len() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.current) + len(p.previous) - p.promoted
+}
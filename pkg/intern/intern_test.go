@@ -0,0 +1,60 @@
+package intern
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func same(a, b string) bool {
+	return unsafe.StringData(a) == unsafe.StringData(b)
+}
+
+func TestString(t *testing.T) {
+	p := New()
+
+	a := strings.Repeat("foo.bar", 2)
+	b := strings.Repeat("foo.bar", 2)
+	if same(a, b) {
+		t.Fatal("expected separate allocations to start with")
+	}
+
+	if got := p.String(a); !same(got, a) {
+		t.Error("expected the first string to be pooled")
+	}
+	if got := p.String(b); got != b || !same(got, a) {
+		t.Error("expected an equal string to be replaced by the pooled one")
+	}
+
+	ss := []string{strings.Repeat("foo.bar", 2), "baz"}
+	p.Strings(ss)
+	if !same(ss[0], a) {
+		t.Error("expected Strings to replace by pooled strings")
+	}
+	if p.Len() != 2 {
+		t.Errorf("expected 2 pooled strings, got %d", p.Len())
+	}
+}
+
+func TestRotate(t *testing.T) {
+	p := New()
+
+	kept := p.String(strings.Repeat("kept", 2))
+	p.String(strings.Repeat("dropped", 2))
+
+	p.Rotate()
+	if got := p.String(strings.Repeat("kept", 2)); !same(got, kept) {
+		t.Error("expected a string of the previous generation to be reused")
+	}
+	if p.Len() != 2 {
+		t.Errorf("expected 2 pooled strings before the second rotation, got %d", p.Len())
+	}
+
+	p.Rotate()
+	if p.Len() != 1 {
+		t.Errorf("expected strings unused for a generation to be dropped, got %d", p.Len())
+	}
+	if got := p.String(strings.Repeat("kept", 2)); !same(got, kept) {
+		t.Error("expected a string used in every generation to stay pooled")
+	}
+}
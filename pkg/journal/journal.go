@@ -0,0 +1,186 @@
+/*
+Package journal records the parameters of requests, by their Carbon UUID, so
+that a request can be issued again as it was, e.g. to reproduce a graph a
+user reported wrong.
+
+The journal is a ring of two files: once the current one takes half the
+size of the journal, it replaces the previous one, whose requests are lost,
+and a new one is started.
+
+Example use:
+
+	j, err := Open("/var/lib/carbonapi/journal", 64<<20)
+	j.Record(FromRequest(uuid, "render", req))
+
+	e, ok, err := Find("/var/lib/carbonapi/journal", uuid)
+*/
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is the parameters of a request.
+type Entry struct {
+	UUID    string     `json:"uuid"`
+	Time    time.Time  `json:"time"`
+	Handler string     `json:"handler"`
+	Method  string     `json:"method"`
+	Path    string     `json:"path"`
+	Params  url.Values `json:"params"`
+}
+
+// FromRequest returns the entry of req, whose form it parses.
+func FromRequest(uuid, handler string, req *http.Request) Entry {
+	req.ParseForm()
+
+	return Entry{
+		UUID:    uuid,
+		Time:    time.Now(),
+		Handler: handler,
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Params:  req.Form,
+	}
+}
+
+// previous returns the file of the previous half of the journal in path.
+func previous(path string) string {
+	return path + ".1"
+}
+
+// Journal records entries to a file.
+type Journal struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the journal in path, which takes at most about maxSize bytes.
+// Entries are added to those already in it.
+func Open(path string, maxSize int64) (*Journal, error) {
+	j := &Journal{
+		path:    path,
+		maxSize: maxSize,
+	}
+
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	j.f = f
+	j.size = fi.Size()
+
+	return nil
+}
+
+// Record adds e to the journal.
+func (j *Journal) Record(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.size+int64(len(b)) > j.maxSize/2 && j.size > 0 {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.f.Write(b)
+	j.size += int64(n)
+
+	return err
+}
+
+// rotate makes the current file the previous one, and starts a new one.
+// Call with j.mu held.
+func (j *Journal) rotate() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(j.path, previous(j.path)); err != nil {
+		return err
+	}
+
+	return j.open()
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.f.Close()
+}
+
+// Find returns the entry of the request uuid in the journal in path. It
+// returns false if there is none.
+func Find(path, uuid string) (Entry, bool, error) {
+	var found Entry
+	var ok bool
+	for _, p := range []string{previous(path), path} {
+		err := each(p, func(e Entry) {
+			if e.UUID == uuid {
+				found, ok = e, true
+			}
+		})
+		if err != nil {
+			return Entry{}, false, err
+		}
+	}
+
+	return found, ok, nil
+}
+
+// each calls f with the entries of the file path, if there is one. Lines
+// that aren't entries, e.g. cut short by a crash, are skipped.
+func each(path string, f func(Entry)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	s.Buffer(nil, 16<<20)
+	for s.Scan() {
+		var e Entry
+		if json.Unmarshal(s.Bytes(), &e) == nil {
+			f(e)
+		}
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,73 @@
+package journal
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := Open(path, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/render/?target=foo.bar", strings.NewReader("from=-1h"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := j.Record(FromRequest("first", "render", req)); err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok, err := Find(path, "first")
+	if err != nil || !ok {
+		t.Fatalf("Expected the request to be found, got %v %v", ok, err)
+	}
+	if e.Handler != "render" || e.Method != "POST" || e.Path != "/render/" || e.Params.Get("target") != "foo.bar" || e.Params.Get("from") != "-1h" {
+		t.Errorf("Expected the parameters of the request, got %+v", e)
+	}
+
+	// Each entry takes about 150 bytes, the first is in the previous file
+	// after 7 more, and gone after 14.
+	for i := 0; i < 7; i++ {
+		j.Record(FromRequest(strconv.Itoa(i), "find", httptest.NewRequest("GET", "/metrics/find/?query=*", nil)))
+	}
+	if _, ok, _ := Find(path, "first"); !ok {
+		t.Error("Expected the request to be kept in the previous file")
+	}
+	for i := 7; i < 14; i++ {
+		j.Record(FromRequest(strconv.Itoa(i), "find", httptest.NewRequest("GET", "/metrics/find/?query=*", nil)))
+	}
+	if _, ok, _ := Find(path, "first"); ok {
+		t.Error("Expected the request to be dropped")
+	}
+	if _, ok, _ := Find(path, "13"); !ok {
+		t.Error("Expected the last request to be found")
+	}
+	j.Close()
+
+	// Reopened, the journal goes on where it was.
+	if j, err = Open(path, 2048); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.size == 0 {
+		t.Error("Expected the size of the current file to be kept")
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	if _, ok, err := Find(filepath.Join(os.TempDir(), "no-such-journal"), "x"); ok || err != nil {
+		t.Errorf("Expected nothing to be found, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Package listener opens the public listeners of carbonapi and carbonzipper
+with tunable socket options.
+
+With ReusePort, several sockets are bound to the same address, each served
+by its own accept loop, and other processes can bind it too. The kernel
+spreads new connections over all of them, which allows running several
+processes on one host and replacing a binary by starting the new one before
+stopping the old one.
+*/
+package listener
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Config configures the listening sockets.
+type Config struct {
+	// ReusePort binds the address with SO_REUSEPORT.
+	ReusePort bool `yaml:"reusePort"`
+	// Acceptors is how many sockets to bind with ReusePort, each with its
+	// own accept loop. Defaults to 1.
+	Acceptors int `yaml:"acceptors"`
+	// KeepAlive is the TCP keep-alive period of accepted connections, 0
+	// for the Go default and negative to disable keep-alives.
+	KeepAlive time.Duration `yaml:"keepAlive"`
+	// Backlog is the length of the queue of connections waiting to be
+	// accepted, 0 for the system default.
+	Backlog int `yaml:"backlog"`
+}
+
+// Listen opens the listeners for addr.
+func Listen(addr string, cfg Config) ([]net.Listener, error) {
+	n := 1
+	if cfg.ReusePort && cfg.Acceptors > 1 {
+		n = cfg.Acceptors
+	}
+
+	lc := net.ListenConfig{
+		KeepAlive: cfg.KeepAlive,
+		Control:   control(cfg),
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err == nil && cfg.Backlog > 0 {
+			err = setBacklog(l, cfg.Backlog)
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// Serve serves s on all the listeners and returns the first error.
+func Serve(s *http.Server, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(l)
+	}
+
+	return <-errs
+}
@@ -0,0 +1,70 @@
+package listener
+
+import (
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only supported on Linux")
+	}
+
+	listeners, err := Listen("127.0.0.1:0", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listeners[0].Addr().String()
+	listeners[0].Close()
+
+	listeners, err = Listen(addr, Config{ReusePort: true, Acceptors: 3, Backlog: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 3 {
+		t.Fatalf("expected 3 listeners, got %d", len(listeners))
+	}
+
+	// Another process could bind the address too.
+	other, err := Listen(addr, Config{ReusePort: true})
+	if err != nil {
+		t.Fatalf("expected the address to be shared, got %v", err)
+	}
+	other[0].Close()
+
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	defer s.Close()
+	go Serve(s, listeners)
+
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Errorf("expected ok, got %q", body)
+		}
+	}
+}
+
+func TestListenWithoutReusePort(t *testing.T) {
+	listeners, err := Listen("127.0.0.1:0", Config{Acceptors: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].Close()
+
+	if len(listeners) != 1 {
+		t.Errorf("expected acceptors to need reusePort, got %d listeners", len(listeners))
+	}
+
+	if _, err := Listen(listeners[0].Addr().String(), Config{}); err == nil {
+		t.Error("expected the address to be taken")
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package listener
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func control(cfg Config) func(network, address string, c syscall.RawConn) error {
+	if !cfg.ReusePort {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// setBacklog calls listen again on the socket, which on Linux changes the
+// length of its accept queue.
+func setBacklog(l net.Listener, backlog int) error {
+	sc, err := l.(*net.TCPListener).SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	cerr := sc.Control(func(fd uintptr) {
+		err = unix.Listen(int(fd), backlog)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
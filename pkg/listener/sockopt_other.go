@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package listener
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func control(cfg Config) func(network, address string, c syscall.RawConn) error {
+	if !cfg.ReusePort {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("SO_REUSEPORT is only supported on Linux")
+	}
+}
+
+func setBacklog(l net.Listener, backlog int) error {
+	return errors.New("setting the listen backlog is only supported on Linux")
+}
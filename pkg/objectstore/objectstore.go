@@ -0,0 +1,185 @@
+// Package objectstore uploads objects to S3, to GCS and to local
+// directories, named by URLs:
+//
+//	s3://bucket/key
+//	gs://bucket/key
+//	file:///path
+//
+// Both S3 and GCS are written to with a PUT signed by AWS Signature Version
+// 4; GCS takes it with HMAC keys through its XML API.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config configures the services objects are uploaded to.
+type Config struct {
+	S3  ServiceConfig `yaml:"s3"`
+	GCS ServiceConfig `yaml:"gcs"`
+}
+
+// ServiceConfig configures S3 or GCS. An empty Endpoint is that of the
+// service, https://s3.<region>.amazonaws.com or https://storage.googleapis.com,
+// and buckets are addressed by path under it.
+type ServiceConfig struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"accessKeyID"`
+	SecretAccessKey string `yaml:"secretAccessKey"`
+}
+
+// Store uploads objects.
+type Store struct {
+	mu     sync.RWMutex
+	s3     ServiceConfig
+	gcs    ServiceConfig
+	client *http.Client
+}
+
+// New returns a store uploading with client.
+func New(cfg Config, client *http.Client) *Store {
+	s := &Store{client: client}
+	s.Update(cfg)
+
+	return s
+}
+
+// Update replaces the services and credentials of s with those of cfg, as
+// when the credentials are rotated.
+func (s *Store) Update(cfg Config) {
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = "us-east-1"
+	}
+	if cfg.S3.Endpoint == "" {
+		cfg.S3.Endpoint = "https://s3." + cfg.S3.Region + ".amazonaws.com"
+	}
+	if cfg.GCS.Region == "" {
+		cfg.GCS.Region = "auto"
+	}
+	if cfg.GCS.Endpoint == "" {
+		cfg.GCS.Endpoint = "https://storage.googleapis.com"
+	}
+
+	s.mu.Lock()
+	s.s3, s.gcs = cfg.S3, cfg.GCS
+	s.mu.Unlock()
+}
+
+// Validate checks that dest is a URL objects can be uploaded to.
+func Validate(dest string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "s3", "gs":
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("%s needs a bucket and a key", dest)
+		}
+	case "file":
+		if u.Host != "" || u.Path == "" {
+			return fmt.Errorf("%s needs an absolute path", dest)
+		}
+	default:
+		return fmt.Errorf("unsupported destination %s, it must be s3://, gs:// or file://", dest)
+	}
+
+	return nil
+}
+
+// Put uploads body to dest.
+func (s *Store) Put(ctx context.Context, dest string, body []byte, contentType string) error {
+	if err := Validate(dest); err != nil {
+		return err
+	}
+	u, _ := url.Parse(dest)
+
+	s.mu.RLock()
+	s3, gcs := s.s3, s.gcs
+	s.mu.RUnlock()
+
+	switch u.Scheme {
+	case "s3":
+		return s.put(ctx, s3, u.Host, u.Path, body, contentType)
+	case "gs":
+		return s.put(ctx, gcs, u.Host, u.Path, body, contentType)
+	default:
+		return writeFile(u.Path, body)
+	}
+}
+
+func (s *Store) put(ctx context.Context, svc ServiceConfig, bucket, key string, body []byte, contentType string) error {
+	if svc.AccessKeyID == "" || svc.SecretAccessKey == "" {
+		return errors.New("no credentials to upload to " + bucket)
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(svc.Endpoint, "/"))
+	if err != nil {
+		return err
+	}
+	u.Path += "/" + bucket + "/" + strings.TrimPrefix(key, "/")
+	u.RawPath = uriEncode(u.Path)
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	signV4(req, svc, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("PUT %s: %s: %s", u, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// writeFile writes to a temporary file next to path, and renames it, so
+// that readers never see part of the object.
+func writeFile(path string, body []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(body); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
@@ -95,6 +95,8 @@ type Expr interface {
 
 	// GetIntervalArg returns interval typed argument.
 	GetIntervalArg(n int, defaultSign int) (int32, error)
+	// GetIntervalNamedOrPosArgDefault returns specific positioned interval-typed argument or replace it with default if none found.
+	GetIntervalNamedOrPosArgDefault(k string, n int, defaultSign int, v int32) (int32, error)
 
 	// GetIntervalArg returns n-th argument as string.
 	GetStringArg(n int) (string, error)
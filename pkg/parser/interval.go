@@ -2,6 +2,7 @@ package parser
 
 import (
 	"strconv"
+	"time"
 )
 
 // IntervalString converts a sign and string into a number of seconds
@@ -64,6 +65,99 @@ func IntervalString(s string, defaultSign int) (int32, error) {
 	return totalInterval, nil
 }
 
+// AlignTo aligns t back to the start of its second, minute, hour, day,
+// week, month or year in UTC, the way smartSummarize's alignTo does. unit is
+// any of the units IntervalString knows, without a count. Weeks can be
+// followed by the ISO weekday they start on, 1 for Monday, the default, to 7
+// for Sunday, as in "weeks7".
+func AlignTo(t int32, unit string) (int32, error) {
+	u, weekday, err := alignUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+
+	tm := time.Unix(int64(t), 0).UTC()
+	switch u {
+	case "s":
+		return t, nil
+	case "min":
+		return t - t%60, nil
+	case "h":
+		return t - t%3600, nil
+	case "d":
+		return t - t%86400, nil
+	case "w":
+		iso := int(tm.Weekday())
+		if iso == 0 {
+			iso = 7
+		}
+		days := (iso - weekday + 7) % 7
+		return t - t%86400 - int32(days)*86400, nil
+	case "mon":
+		return int32(time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()), nil
+	default:
+		return int32(time.Date(tm.Year(), 1, 1, 0, 0, 0, 0, time.UTC).Unix()), nil
+	}
+}
+
+// AlignToLookback returns the most AlignTo moves times back for unit, for
+// the data before them to be fetched.
+func AlignToLookback(unit string) (int32, error) {
+	u, _, err := alignUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+
+	switch u {
+	case "s":
+		return 0, nil
+	case "min":
+		return 60, nil
+	case "h":
+		return 60 * 60, nil
+	case "d":
+		return 24 * 60 * 60, nil
+	case "w":
+		return 7 * 24 * 60 * 60, nil
+	case "mon":
+		return 31 * 24 * 60 * 60, nil
+	default:
+		return 366 * 24 * 60 * 60, nil
+	}
+}
+
+func alignUnit(unit string) (string, int, error) {
+	weekday := 1
+	if n := len(unit); n > 1 && '1' <= unit[n-1] && unit[n-1] <= '7' {
+		weekday = int(unit[n-1] - '0')
+		unit = unit[:n-1]
+		switch unit {
+		case "w", "week", "weeks":
+		default:
+			return "", 0, ErrUnknownTimeUnits
+		}
+	}
+
+	switch unit {
+	case "s", "sec", "secs", "second", "seconds":
+		return "s", weekday, nil
+	case "m", "min", "mins", "minute", "minutes":
+		return "min", weekday, nil
+	case "h", "hour", "hours":
+		return "h", weekday, nil
+	case "d", "day", "days":
+		return "d", weekday, nil
+	case "w", "week", "weeks":
+		return "w", weekday, nil
+	case "mon", "month", "months":
+		return "mon", weekday, nil
+	case "y", "year", "years":
+		return "y", weekday, nil
+	}
+
+	return "", 0, ErrUnknownTimeUnits
+}
+
 func TruthyBool(s string) bool {
 	switch s {
 	case "", "0", "false", "False", "no", "No":
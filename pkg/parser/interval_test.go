@@ -51,3 +51,40 @@ func TestInterval(t *testing.T) {
 		}
 	}
 }
+
+func TestAlignTo(t *testing.T) {
+	// Wed 2014-09-10 10:32:17 UTC
+	const ts = 1410345137
+
+	var tests = []struct {
+		unit string
+		want int32
+	}{
+		{"seconds", ts},
+		{"min", 1410345120},
+		{"hours", 1410343200},
+		{"d", 1410307200},
+		{"weeks", 1410134400},  // Mon 2014-09-08
+		{"weeks7", 1410048000}, // Sun 2014-09-07
+		{"weeks3", 1410307200}, // Wed 2014-09-10
+		{"months", 1409529600},
+		{"y", 1388534400},
+	}
+
+	for _, tt := range tests {
+		got, err := AlignTo(ts, tt.unit)
+		if err != nil || got != tt.want {
+			t.Errorf("AlignTo(%d, %q)=%d, %v, want %d", ts, tt.unit, got, err, tt.want)
+		}
+		lookback, err := AlignToLookback(tt.unit)
+		if err != nil || ts-got > lookback {
+			t.Errorf("AlignToLookback(%q)=%d, %v, want at least %d", tt.unit, lookback, err, ts-got)
+		}
+	}
+
+	for _, unit := range []string{"", "1d", "days2", "fortnights"} {
+		if _, err := AlignTo(ts, unit); err != ErrUnknownTimeUnits {
+			t.Errorf("AlignTo(%d, %q) returned %v, want %v", ts, unit, err, ErrUnknownTimeUnits)
+		}
+	}
+}
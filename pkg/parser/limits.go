@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits bounds the complexity of the targets of a request, as machine
+// generated expressions can nest deep enough, or fetch and glob enough, to
+// take seconds to parse and evaluate. A zero field doesn't limit.
+type Limits struct {
+	// MaxDepth bounds how deep function calls nest in a target.
+	MaxDepth int `yaml:"maxDepth"`
+	// MaxFetches bounds the series names the targets fetch together.
+	MaxFetches int `yaml:"maxFetches"`
+	// MaxGlobs bounds the wildcards in the series names of the targets
+	// together.
+	MaxGlobs int `yaml:"maxGlobs"`
+}
+
+// Complexity measures expressions.
+type Complexity struct {
+	// Depth is how deep function calls nest, 0 for a bare series name.
+	Depth int
+	// Fetches is how many series names there are.
+	Fetches int
+	// Globs is how many wildcards, *, ?, [ and {, the names have.
+	Globs int
+}
+
+// Measure returns the complexity of e.
+func Measure(e Expr) Complexity {
+	var c Complexity
+	measure(e, 0, &c)
+
+	return c
+}
+
+func measure(e Expr, depth int, c *Complexity) {
+	switch e.Type() {
+	case EtName:
+		c.Fetches++
+		c.Globs += strings.Count(e.Target(), "*") + strings.Count(e.Target(), "?") +
+			strings.Count(e.Target(), "[") + strings.Count(e.Target(), "{")
+	case EtFunc:
+		depth++
+		for _, a := range e.Args() {
+			measure(a, depth, c)
+		}
+		for _, a := range e.NamedArgs() {
+			measure(a, depth, c)
+		}
+	}
+
+	if depth > c.Depth {
+		c.Depth = depth
+	}
+}
+
+// Add adds the complexity of another target of the same request.
+func (c *Complexity) Add(o Complexity) {
+	if o.Depth > c.Depth {
+		c.Depth = o.Depth
+	}
+	c.Fetches += o.Fetches
+	c.Globs += o.Globs
+}
+
+// Check returns an error saying which limit c is over, if any.
+func (l Limits) Check(c Complexity) error {
+	if l.MaxDepth > 0 && c.Depth > l.MaxDepth {
+		return fmt.Errorf("expression too complex: functions nest %d deep, more than %d", c.Depth, l.MaxDepth)
+	}
+	if l.MaxFetches > 0 && c.Fetches > l.MaxFetches {
+		return fmt.Errorf("expression too complex: %d series names, more than %d", c.Fetches, l.MaxFetches)
+	}
+	if l.MaxGlobs > 0 && c.Globs > l.MaxGlobs {
+		return fmt.Errorf("expression too complex: %d wildcards, more than %d", c.Globs, l.MaxGlobs)
+	}
+
+	return nil
+}
+
+// CheckNesting checks how deep the parentheses of target nest against
+// MaxDepth before it's parsed, so that the parser doesn't recurse as deep.
+func (l Limits) CheckNesting(target string) error {
+	if l.MaxDepth <= 0 {
+		return nil
+	}
+
+	depth := 0
+	var quote byte
+	for i := 0; i < len(target); i++ {
+		ch := target[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\\':
+			i++
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '(':
+			depth++
+			if depth > l.MaxDepth {
+				return fmt.Errorf("expression too complex: functions nest more than %d deep", l.MaxDepth)
+			}
+		case ch == ')':
+			depth--
+		}
+	}
+
+	return nil
+}
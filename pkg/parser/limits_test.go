@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	tests := []struct {
+		target string
+		want   Complexity
+	}{
+		{target: "foo.bar", want: Complexity{Depth: 0, Fetches: 1}},
+		{target: "sumSeries(foo.*.{a,b})", want: Complexity{Depth: 1, Fetches: 1, Globs: 2}},
+		{target: "divideSeries(sumSeries(a.*.errors),sumSeries(a.?.requests))", want: Complexity{Depth: 2, Fetches: 2, Globs: 2}},
+		{target: "alias(scale(foo.bar,2),'a*b')", want: Complexity{Depth: 2, Fetches: 1}},
+		{target: "foo.[ab].*|sumSeries()|scale(10)", want: Complexity{Depth: 2, Fetches: 1, Globs: 2}},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.target)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		if got := Measure(e); got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.target, tt.want, got)
+		}
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	l := Limits{MaxDepth: 3, MaxFetches: 4, MaxGlobs: 5}
+
+	var c Complexity
+	c.Add(Complexity{Depth: 3, Fetches: 2, Globs: 3})
+	c.Add(Complexity{Depth: 1, Fetches: 2, Globs: 2})
+	if err := l.Check(c); err != nil {
+		t.Errorf("expected %+v within %+v, got %v", c, l, err)
+	}
+
+	for _, c := range []Complexity{
+		{Depth: 4},
+		{Fetches: 5},
+		{Globs: 6},
+	} {
+		if err := l.Check(c); err == nil {
+			t.Errorf("expected %+v over %+v", c, l)
+		}
+	}
+
+	if err := (Limits{}).Check(Complexity{Depth: 1000, Fetches: 1000, Globs: 1000}); err != nil {
+		t.Errorf("expected no limits by default, got %v", err)
+	}
+}
+
+func TestLimitsCheckNesting(t *testing.T) {
+	l := Limits{MaxDepth: 3}
+
+	deep := strings.Repeat("absolute(", 1000) + "foo" + strings.Repeat(")", 1000)
+	if err := l.CheckNesting(deep); err == nil {
+		t.Error("expected deep nesting to be refused")
+	}
+	if err := l.CheckNesting("a(b(c(foo)),d(e(bar)))"); err != nil {
+		t.Errorf("expected sibling calls within the limit, got %v", err)
+	}
+	if err := l.CheckNesting(`alias(foo,'((((')`); err != nil {
+		t.Errorf("expected parentheses in strings to be ignored, got %v", err)
+	}
+	if err := l.CheckNesting(`alias(foo\(\(\(\(.bar,'x')`); err != nil {
+		t.Errorf("expected escaped parentheses in names to be ignored, got %v", err)
+	}
+	if err := (Limits{}).CheckNesting(deep); err != nil {
+		t.Errorf("expected no limit by default, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxMacroDepth limits how deep macros may use other macros, which also stops
+// macros that end up calling themselves.
+const maxMacroDepth = 10
+
+var (
+	macroSignature = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*\(([^()]*)\)\s*$`)
+	macroParam     = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+type macro struct {
+	params []string
+	body   string
+}
+
+// Macros are user defined functions. A call of a macro is replaced by its
+// body with the arguments of the call substituted for its parameters.
+type Macros map[string]macro
+
+// NewMacros parses macro definitions. The keys are signatures like
+// "errRate(svc)", the values the bodies the calls expand to, referring to the
+// parameters as $svc or ${svc}. Arguments are substituted as written in the
+// call, string arguments without their quotes.
+func NewMacros(defs map[string]string) (Macros, error) {
+	m := make(Macros, len(defs))
+	for signature, body := range defs {
+		match := macroSignature.FindStringSubmatch(signature)
+		if match == nil {
+			return nil, fmt.Errorf("macro %q: signature must look like name(param, ...)", signature)
+		}
+
+		name := match[1]
+		if _, ok := m[name]; ok {
+			return nil, fmt.Errorf("macro %q: defined more than once", name)
+		}
+
+		var params []string
+		known := make(map[string]bool)
+		if strings.TrimSpace(match[2]) != "" {
+			for _, p := range strings.Split(match[2], ",") {
+				p = strings.TrimSpace(p)
+				if !macroParam.MatchString("$"+p) || known[p] {
+					return nil, fmt.Errorf("macro %q: bad parameter %q", name, p)
+				}
+				known[p] = true
+				params = append(params, p)
+			}
+		}
+
+		for _, ref := range macroParam.FindAllStringSubmatch(body, -1) {
+			p := ref[1] + ref[2]
+			if !known[p] {
+				return nil, fmt.Errorf("macro %q: body uses unknown parameter %q", name, p)
+			}
+		}
+
+		if _, e, err := ParseExpr(macroParam.ReplaceAllString(body, "x")); err != nil || e != "" {
+			return nil, fmt.Errorf("macro %q: can't parse body %q", name, body)
+		}
+
+		m[name] = macro{params: params, body: body}
+	}
+
+	return m, nil
+}
+
+// Expand replaces the calls of macros in e, and in what they expand to, with
+// the macro bodies.
+func (m Macros) Expand(e Expr) (Expr, error) {
+	if len(m) == 0 {
+		return e, nil
+	}
+
+	for depth := 0; ; depth++ {
+		s, expanded, err := m.expand(e.toExpr().(*expr))
+		if err != nil {
+			return nil, err
+		}
+		if !expanded {
+			return e, nil
+		}
+		if depth == maxMacroDepth {
+			return nil, fmt.Errorf("macros nested deeper than %d, do they call themselves?", maxMacroDepth)
+		}
+
+		var rest string
+		e, rest, err = ParseExpr(s)
+		if err != nil || rest != "" {
+			return nil, fmt.Errorf("macro expanded to %q that can't be parsed", s)
+		}
+	}
+}
+
+// expand returns e as a string, with the outermost macro calls replaced.
+func (m Macros) expand(e *expr) (string, bool, error) {
+	if e.etype != EtFunc {
+		return e.ToString(), false, nil
+	}
+
+	if mac, ok := m[e.target]; ok {
+		s, err := mac.call(e)
+		return s, true, err
+	}
+
+	args := make([]string, 0, len(e.args)+len(e.namedArgs))
+	expanded := false
+	for _, a := range e.args {
+		s, ok, err := m.expand(a)
+		if err != nil {
+			return "", false, err
+		}
+		args = append(args, s)
+		expanded = expanded || ok
+	}
+
+	names := make([]string, 0, len(e.namedArgs))
+	for k := range e.namedArgs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		s, ok, err := m.expand(e.namedArgs[k])
+		if err != nil {
+			return "", false, err
+		}
+		args = append(args, k+"="+s)
+		expanded = expanded || ok
+	}
+
+	if !expanded {
+		return e.ToString(), false, nil
+	}
+
+	return fmt.Sprintf("%s(%s)", e.target, strings.Join(args, ",")), true, nil
+}
+
+func (mac macro) call(e *expr) (string, error) {
+	values := make(map[string]string, len(mac.params))
+	for i, a := range e.args {
+		if i >= len(mac.params) {
+			return "", fmt.Errorf("%s: too many arguments, takes %d", e.target, len(mac.params))
+		}
+		values[mac.params[i]] = macroArg(a)
+	}
+	for k, a := range e.namedArgs {
+		if _, ok := values[k]; ok {
+			return "", fmt.Errorf("%s: %s given more than once", e.target, k)
+		}
+		values[k] = macroArg(a)
+	}
+
+	for _, p := range mac.params {
+		if _, ok := values[p]; !ok {
+			return "", fmt.Errorf("%s: missing argument %s", e.target, p)
+		}
+	}
+	if len(values) != len(mac.params) {
+		return "", fmt.Errorf("%s: unknown argument, takes %s", e.target, strings.Join(mac.params, ", "))
+	}
+
+	return macroParam.ReplaceAllStringFunc(mac.body, func(ref string) string {
+		return values[strings.Trim(ref, "${}")]
+	}), nil
+}
+
+func macroArg(e *expr) string {
+	if e.etype == EtString {
+		return e.valStr
+	}
+	return e.ToString()
+}
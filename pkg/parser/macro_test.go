@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestMacrosExpand(t *testing.T) {
+	macros, err := NewMacros(map[string]string{
+		"errRate(svc)":           "divideSeries(sumSeries(services.$svc.errors),sumSeries(services.${svc}.requests))",
+		"titled(series, title)":  "alias($series,'$title')",
+		"errPercent(svc)":        "scale(errRate($svc),100)",
+		"constant()":             "constantLine(1)",
+		"loop(x)":                "loop($x)",
+		"scaled(series, factor)": "scale($series,$factor)",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		target string
+		want   string
+		err    bool
+	}{
+		{target: "sumSeries(a.*)", want: "sumSeries(a.*)"},
+		{target: "errRate(api)", want: "divideSeries(sumSeries(services.api.errors),sumSeries(services.api.requests))"},
+		{target: "errRate(svc=api)", want: "divideSeries(sumSeries(services.api.errors),sumSeries(services.api.requests))"},
+		{target: "errPercent(api)", want: "scale(divideSeries(sumSeries(services.api.errors),sumSeries(services.api.requests)),100)"},
+		{target: "titled(sumSeries(a.*), 'all of a')", want: "alias(sumSeries(a.*),'all of a')"},
+		{target: "legendValue(constant(), 'avg')", want: "legendValue(constantLine(1),'avg')"},
+		{target: "errRate(api)|scaled(factor=0.5)", want: "scale(divideSeries(sumSeries(services.api.errors),sumSeries(services.api.requests)),0.5)"},
+		{target: "errRate(api, web)", err: true},
+		{target: "errRate()", err: true},
+		{target: "errRate(service=api)", err: true},
+		{target: "loop(a)", err: true},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.target)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.target, err)
+		}
+
+		got, err := macros.Expand(e)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", tt.target, got.ToString())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.target, err)
+			continue
+		}
+		if got.ToString() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.target, got.ToString(), tt.want)
+		}
+	}
+}
+
+func TestNewMacrosErrors(t *testing.T) {
+	for _, defs := range []map[string]string{
+		{"errRate": "sumSeries(a)"},
+		{"errRate(svc": "sumSeries(a)"},
+		{"errRate(svc, svc)": "sumSeries($svc)"},
+		{"errRate(svc)": "sumSeries($other)"},
+		{"errRate(svc)": "sumSeries($svc"},
+	} {
+		if _, err := NewMacros(defs); err == nil {
+			t.Errorf("%v: expected an error", defs)
+		}
+	}
+}
@@ -52,6 +52,8 @@ func (e *expr) ToString() string {
 		s = strings.Replace(s, `\`, `\\`, -1)
 		s = strings.Replace(s, `'`, `\'`, -1)
 		return "'" + s + "'"
+	case EtName:
+		return EscapeName(e.target)
 	}
 
 	return e.target
@@ -167,10 +169,35 @@ func (e *expr) Metrics() []MetricRequest {
 
 			return r2
 		case "holtWintersForecast", "holtWintersConfidenceBands", "holtWintersAberration":
+			// the bootstrap interval follows delta, which forecasts don't take
+			pos := 2
+			if e.target == "holtWintersForecast" {
+				pos = 1
+			}
+			bootstrapInterval, err := e.GetIntervalNamedOrPosArgDefault("bootstrapInterval", pos, 1, 7*86400)
+			if err != nil {
+				return nil
+			}
+			for i := range r {
+				r[i].From -= bootstrapInterval // starts bootstrapInterval before where the original starts
+			}
+		case "smartSummarize":
+			alignTo, err := e.GetStringNamedOrPosArgDefault("alignTo", 3, "")
+			if err != nil || alignTo == "" {
+				break
+			}
+			offs, err := AlignToLookback(alignTo)
+			if err != nil {
+				return nil
+			}
 			for i := range r {
-				r[i].From -= 7 * 86400 // starts -7 days from where the original starts
+				r[i].From -= offs // the aligned from is at most offs before it
 			}
 		case "movingAverage", "movingMedian", "movingMin", "movingMax", "movingSum":
+			if len(e.args) < 2 {
+				// evaluation reports the missing window
+				break
+			}
 			switch e.args[1].etype {
 			case EtString:
 				offs, err := e.GetIntervalArg(1, 1)
@@ -205,6 +232,31 @@ func (e *expr) GetIntervalArg(n int, defaultSign int) (int32, error) {
 	return seconds, nil
 }
 
+func (e *expr) GetIntervalNamedOrPosArgDefault(k string, n int, defaultSign int, v int32) (int32, error) {
+	var val string
+	if a := e.getNamedArg(k); a != nil {
+		if a.etype != EtString {
+			return 0, ErrBadType
+		}
+		val = a.valStr
+	} else {
+		if len(e.args) <= n {
+			return v, nil
+		}
+		if e.args[n].etype != EtString {
+			return 0, ErrBadType
+		}
+		val = e.args[n].valStr
+	}
+
+	seconds, err := IntervalString(val, defaultSign)
+	if err != nil {
+		return 0, ErrBadType
+	}
+
+	return seconds, nil
+}
+
 func (e *expr) GetStringArg(n int) (string, error) {
 	if len(e.args) <= n {
 		return "", ErrMissingArgument
@@ -554,6 +606,7 @@ func parseName(s string) (string, string) {
 	var (
 		braces, i, w int
 		r            rune
+		escaped      bool
 	)
 
 FOR:
@@ -565,6 +618,13 @@ FOR:
 		}
 
 		switch s[i] {
+		case '\\':
+			if i+1 == len(s) {
+				break FOR
+			}
+			_, n := utf8.DecodeRuneInString(s[i+1:])
+			w += n
+			escaped = true
 		case '{':
 			braces++
 		case '}':
@@ -586,13 +646,76 @@ FOR:
 
 	}
 
-	if i == len(s) {
-		return s, ""
+	if escaped {
+		return unescapeName(s[:i]), s[i:]
 	}
 
 	return s[:i], s[i:]
 }
 
+// unescapeName drops the backslashes that escape characters in name.
+func unescapeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) {
+			i++
+		}
+		b.WriteByte(name[i])
+	}
+
+	return b.String()
+}
+
+// EscapeName escapes the characters of the series name name that would end
+// it in an expression, such as spaces, commas outside braces, parentheses,
+// quotes and letters of scripts outside RangeTables, with backslashes.
+// The parser reads the escaped name back as name.
+func EscapeName(name string) string {
+	var b strings.Builder
+	braces := 0
+	for i, w := 0, 0; i < len(name); i += w {
+		var r rune
+		r, w = utf8.DecodeRuneInString(name[i:])
+		escape := false
+		switch {
+		case r < utf8.RuneSelf && IsNameChar(byte(r)):
+		case r == '{':
+			braces++
+		case r == '}':
+			if braces == 0 {
+				escape = true
+			} else {
+				braces--
+			}
+		case r == ',':
+			escape = braces == 0
+		case r == '\\':
+			escape = true
+		case r != utf8.RuneError && unicode.In(r, RangeTables...):
+		default:
+			escape = true
+		}
+
+		if escape {
+			b.WriteByte('\\')
+		}
+		b.WriteString(name[i : i+w])
+	}
+
+	// A name that reads as a number, or fails to, is escaped so as not to be
+	// taken for one.
+	escaped := b.String()
+	if escaped != "" && (isDigit(escaped[0]) || escaped[0] == '-' || escaped[0] == '+') {
+		_, rest, err := parseConst(escaped)
+		next, _ := utf8.DecodeRuneInString(rest)
+		if err != nil || !unicode.IsLetter(next) {
+			escaped = "\\" + escaped
+		}
+	}
+
+	return escaped
+}
+
 func parseString(s string) (string, string, error) {
 
 	if s[0] != '\'' && s[0] != '"' {
@@ -373,3 +373,113 @@ func TestParseExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestMetricsHoltWinters(t *testing.T) {
+	tests := []struct {
+		s    string
+		from int32
+	}{
+		{`holtWintersForecast(metric1)`, -7 * 86400},
+		{`holtWintersForecast(metric1,"3d")`, -3 * 86400},
+		{`holtWintersConfidenceBands(metric1,2,"1h")`, -3600},
+		{`holtWintersAberration(metric1,bootstrapInterval="2d",seasonality="1h")`, -2 * 86400},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.s)
+		if err != nil {
+			t.Fatalf("parse for %+v failed: err=%v", tt.s, err)
+		}
+		m := e.Metrics()
+		if len(m) != 1 || m[0].From != tt.from {
+			t.Errorf("metrics for %+v: got %+v, want from %d", tt.s, m, tt.from)
+		}
+	}
+}
+
+func TestMetricsMovingWindow(t *testing.T) {
+	tests := []struct {
+		s    string
+		from int32
+	}{
+		{`movingAverage(metric1,"5min")`, -300},
+		{`movingSum(metric1,5)`, 0},
+		{`smartSummarize(metric1,"1d","sum","months")`, -31 * 86400},
+		{`smartSummarize(metric1,"1h",alignTo="hours")`, -3600},
+		{`smartSummarize(metric1,"1h")`, 0},
+		// the missing window is left for evaluation to report
+		{`movingAverage(metric1)`, 0},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.s)
+		if err != nil {
+			t.Fatalf("parse for %+v failed: err=%v", tt.s, err)
+		}
+		m := e.Metrics()
+		if len(m) != 1 || m[0].From != tt.from {
+			t.Errorf("metrics for %+v: got %+v, want from %d", tt.s, m, tt.from)
+		}
+	}
+}
+
+func TestEscapedNames(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: `foo\ bar.baz`, want: "foo bar.baz"},
+		{target: `foo\,bar`, want: "foo,bar"},
+		{target: `foo.{a,b}`, want: "foo.{a,b}"},
+		{target: `foo\(1\).bar`, want: "foo(1).bar"},
+		{target: `\1.5`, want: "1.5"},
+		{target: `path\\to`, want: `path\to`},
+		{target: `caf\é.bar`, want: "café.bar"},
+		{target: `a\=b`, want: "a=b"},
+	}
+
+	for _, tt := range tests {
+		e, rest, err := ParseExpr(tt.target)
+		if err != nil || rest != "" {
+			t.Errorf("%s: unexpected %q, %v", tt.target, rest, err)
+			continue
+		}
+		if !e.IsName() || e.Target() != tt.want {
+			t.Errorf("%s: expected the name %q, got %q", tt.target, tt.want, e.Target())
+		}
+		if got := EscapeName(tt.want); got != tt.target {
+			t.Errorf("%q: expected to be escaped to %s, got %s", tt.want, tt.target, got)
+		}
+	}
+
+	e, _, err := ParseExpr(`sumSeries(foo\ bar.*,baz)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Args()) != 2 || e.Args()[0].Target() != "foo bar.*" {
+		t.Errorf("unexpected arguments %v", e.Args())
+	}
+	if m := e.Metrics(); len(m) != 2 || m[0].Metric != "foo bar.*" {
+		t.Errorf("expected the unescaped name to be fetched, got %v", m)
+	}
+}
+
+func FuzzEscapeName(f *testing.F) {
+	for _, name := range []string{"foo.bar", "foo bar", "a,b", "a.{b,c}", "}{", "1.5", "-1", "1metric", `a\b`, "мет.рика", "a|b", "x=1", "\xff", "0Ћ0"} {
+		f.Add(name)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if name == "" {
+			return
+		}
+		escaped := EscapeName(name)
+		e, rest, err := ParseExpr(escaped)
+		if err != nil || rest != "" {
+			t.Fatalf("%q escaped to %q doesn't parse: %q, %v", name, escaped, rest, err)
+		}
+		if !e.IsName() || e.Target() != name {
+			t.Fatalf("%q escaped to %q parses to %q", name, escaped, e.Target())
+		}
+	})
+}
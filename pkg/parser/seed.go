@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// seededFunctions are the functions whose results are random unless they are
+// given a seed argument, with the position of that argument.
+var seededFunctions = map[string]int{
+	"randomWalk":         2,
+	"randomWalkFunction": 2,
+}
+
+// Seed gives the calls in e of functions whose results are random, that
+// weren't given a seed, seed. Evaluating e twice with the same seed then gives
+// the same results.
+func Seed(e Expr, seed int64) (Expr, error) {
+	s, seeded := doSeed(e.toExpr().(*expr), strconv.FormatInt(seed, 10))
+	if !seeded {
+		return e, nil
+	}
+
+	e, rest, err := ParseExpr(s)
+	if err != nil || rest != "" {
+		return nil, fmt.Errorf("seeded expression %q can't be parsed", s)
+	}
+
+	return e, nil
+}
+
+// doSeed returns e as a string, with seed added to the calls that need it.
+func doSeed(e *expr, seed string) (string, bool) {
+	if e.etype != EtFunc {
+		return e.ToString(), false
+	}
+
+	args := make([]string, 0, len(e.args)+len(e.namedArgs)+1)
+	seeded := false
+	for _, a := range e.args {
+		s, ok := doSeed(a, seed)
+		args = append(args, s)
+		seeded = seeded || ok
+	}
+
+	names := make([]string, 0, len(e.namedArgs))
+	for k := range e.namedArgs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		s, ok := doSeed(e.namedArgs[k], seed)
+		args = append(args, k+"="+s)
+		seeded = seeded || ok
+	}
+
+	if pos, ok := seededFunctions[e.target]; ok && !hasSeed(e, pos) {
+		args = append(args, "seed="+seed)
+		seeded = true
+	}
+
+	if !seeded {
+		return e.ToString(), false
+	}
+
+	return fmt.Sprintf("%s(%s)", e.target, strings.Join(args, ",")), true
+}
+
+// hasSeed tells if the call e was given a seed, by name or at pos.
+func hasSeed(e *expr, pos int) bool {
+	if _, ok := e.namedArgs["seed"]; ok {
+		return true
+	}
+
+	return len(e.args) > pos
+}
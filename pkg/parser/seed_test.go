@@ -0,0 +1,34 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "sumSeries(a.*)", want: "sumSeries(a.*)"},
+		{target: "randomWalk('x')", want: "randomWalk('x',seed=42)"},
+		{target: "randomWalk('x', seed=7)", want: "randomWalk('x', seed=7)"},
+		{target: "randomWalk('x', 60, 7)", want: "randomWalk('x', 60, 7)"},
+		{target: "sumSeries(randomWalk('x'),randomWalkFunction('y',step=10))", want: "sumSeries(randomWalk('x',seed=42),randomWalkFunction('y',step=10,seed=42))"},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.target)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.target, err)
+		}
+
+		got, err := Seed(e, 42)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.target, err)
+			continue
+		}
+		if got.ToString() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.target, got.ToString(), tt.want)
+		}
+	}
+}
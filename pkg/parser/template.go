@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var templateVar = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}|\$([A-Za-z0-9_]+)`)
+
+// Templates are the values of the variables of template() calls given with
+// the request, as graphite-web takes them from template[name]=value
+// parameters. They override the defaults given in the calls.
+type Templates map[string]string
+
+// TemplatesFromForm collects the template[name]=value parameters of a
+// request.
+func TemplatesFromForm(form url.Values) Templates {
+	var t Templates
+	for k, v := range form {
+		if len(v) == 0 || !strings.HasPrefix(k, "template[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+		if t == nil {
+			t = make(Templates)
+		}
+		t[k[len("template["):len(k)-1]] = v[0]
+	}
+
+	return t
+}
+
+// Expand replaces the template(seriesList, *args, **kwargs) calls in e with
+// their first argument, in which $name or ${name} is replaced by the value of
+// the variable. Positional arguments are the variables $1, $2, ..., named
+// ones the variables of that name, and the request's variables override both.
+// Variables without a value are left as written.
+func (t Templates) Expand(e Expr) (Expr, error) {
+	for depth := 0; ; depth++ {
+		s, expanded, err := t.expand(e.toExpr().(*expr))
+		if err != nil {
+			return nil, err
+		}
+		if !expanded {
+			return e, nil
+		}
+		if depth == maxMacroDepth {
+			return nil, fmt.Errorf("templates nested deeper than %d", maxMacroDepth)
+		}
+
+		var rest string
+		e, rest, err = ParseExpr(s)
+		if err != nil || rest != "" {
+			return nil, fmt.Errorf("template expanded to %q that can't be parsed", s)
+		}
+	}
+}
+
+// expand returns e as a string, with the outermost template calls replaced.
+func (t Templates) expand(e *expr) (string, bool, error) {
+	if e.etype != EtFunc {
+		return e.ToString(), false, nil
+	}
+
+	if e.target == "template" {
+		s, err := t.call(e)
+		return s, true, err
+	}
+
+	args := make([]string, 0, len(e.args)+len(e.namedArgs))
+	expanded := false
+	for _, a := range e.args {
+		s, ok, err := t.expand(a)
+		if err != nil {
+			return "", false, err
+		}
+		args = append(args, s)
+		expanded = expanded || ok
+	}
+
+	names := make([]string, 0, len(e.namedArgs))
+	for k := range e.namedArgs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		s, ok, err := t.expand(e.namedArgs[k])
+		if err != nil {
+			return "", false, err
+		}
+		args = append(args, k+"="+s)
+		expanded = expanded || ok
+	}
+
+	if !expanded {
+		return e.ToString(), false, nil
+	}
+
+	return fmt.Sprintf("%s(%s)", e.target, strings.Join(args, ",")), true, nil
+}
+
+func (t Templates) call(e *expr) (string, error) {
+	if len(e.args) == 0 {
+		return "", ErrMissingArgument
+	}
+
+	values := make(map[string]string, len(e.args)-1+len(e.namedArgs)+len(t))
+	for i, a := range e.args[1:] {
+		values[strconv.Itoa(i+1)] = macroArg(a)
+	}
+	for k, a := range e.namedArgs {
+		values[k] = macroArg(a)
+	}
+	for k, v := range t {
+		values[k] = v
+	}
+
+	return templateVar.ReplaceAllStringFunc(e.args[0].ToString(), func(ref string) string {
+		if v, ok := values[strings.Trim(ref, "${}")]; ok {
+			return v
+		}
+		return ref
+	}), nil
+}
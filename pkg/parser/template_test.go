@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestTemplatesExpand(t *testing.T) {
+	tests := []struct {
+		target    string
+		templates Templates
+		want      string
+	}{
+		{target: "sumSeries(hosts.$host.cpu)", want: "sumSeries(hosts.$host.cpu)"},
+		{target: "template(hosts.$hostname.cpu)", templates: Templates{"hostname": "worker1"}, want: "hosts.worker1.cpu"},
+		{target: "template(hosts.$hostname.cpu, hostname='worker1')", want: "hosts.worker1.cpu"},
+		{target: "template(hosts.$hostname.cpu, hostname='worker1')", templates: Templates{"hostname": "worker2"}, want: "hosts.worker2.cpu"},
+		{target: "template(hosts.$1.$2, 'worker1', 'cpu')", want: "hosts.worker1.cpu"},
+		{target: "sumSeries(template(hosts.${host}_a.cpu, host=web))", want: "sumSeries(hosts.web_a.cpu)"},
+		{target: "template(scale(hosts.$host.cpu, $factor), host=web, factor=2)", want: "scale(hosts.web.cpu, 2)"},
+		{target: "template(hosts.$host.cpu)", want: "hosts.$host.cpu"},
+	}
+
+	for _, tt := range tests {
+		e, _, err := ParseExpr(tt.target)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.target, err)
+		}
+
+		got, err := tt.templates.Expand(e)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.target, err)
+			continue
+		}
+		if got.ToString() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.target, got.ToString(), tt.want)
+		}
+	}
+
+	e, _, err := ParseExpr("template()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Templates(nil).Expand(e); err == nil {
+		t.Error("expected an error for template() without a series list")
+	}
+}
+
+func TestTemplatesFromForm(t *testing.T) {
+	form := url.Values{
+		"template[host]": {"web1"},
+		"template[dc]":   {"ams", "lon"},
+		"template":       {"plain"},
+		"target":         {"template(a.$host)"},
+	}
+
+	want := Templates{"host": "web1", "dc": "ams"}
+	if got := TemplatesFromForm(form); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
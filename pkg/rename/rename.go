@@ -0,0 +1,153 @@
+/*
+Package rename maps metric paths between the names they had before a rename
+and the names they have after it, so that both can be queried during a
+migration.
+
+Patterns are metric paths whose segments can be "*", which stand for any
+segment and are carried over in order. A path matches a pattern if its leading
+segments do, and its other segments are appended to the mapped path.
+
+Example use:
+
+	t, err := Parse(strings.NewReader(`[{from: "servers.*.cpu", to: "hosts.*.cpu"}]`))
+	p, legacy, ok := t.Map("servers.web1.cpu.user") // "hosts.web1.cpu.user", true, true
+	p, legacy, ok = t.Map("hosts.web1.cpu.user")    // "servers.web1.cpu.user", false, true
+*/
+package rename
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule renames the paths matching From to those matching To.
+type Rule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Table is a set of rules. Paths are mapped by the first rule either side
+// of which they match.
+type Table struct {
+	rules [][2][]string
+}
+
+// Parse reads a YAML list of rules.
+func Parse(r io.Reader) (*Table, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := yaml.UnmarshalStrict(b, &rules); err != nil {
+		return nil, err
+	}
+
+	return New(rules)
+}
+
+// Load reads the rules of file.
+func Load(file string) (*Table, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// New checks rules and returns their table.
+func New(rules []Rule) (*Table, error) {
+	t := &Table{rules: make([][2][]string, 0, len(rules))}
+	for i, r := range rules {
+		from, to := strings.Split(r.From, "."), strings.Split(r.To, ".")
+		if err := check(from); err != nil {
+			return nil, fmt.Errorf("rename %d: from: %v", i, err)
+		}
+		if err := check(to); err != nil {
+			return nil, fmt.Errorf("rename %d: to: %v", i, err)
+		}
+		if wildcards(from) != wildcards(to) {
+			return nil, fmt.Errorf("rename %d: %s and %s have different numbers of wildcards", i, r.From, r.To)
+		}
+		t.rules = append(t.rules, [2][]string{from, to})
+	}
+
+	return t, nil
+}
+
+func check(pattern []string) error {
+	for _, s := range pattern {
+		if s == "" || s != "*" && strings.ContainsAny(s, "*?[]{}") {
+			return fmt.Errorf("%q is not a pattern", strings.Join(pattern, "."))
+		}
+	}
+
+	return nil
+}
+
+func wildcards(pattern []string) int {
+	n := 0
+	for _, s := range pattern {
+		if s == "*" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Len returns the number of rules of t.
+func (t *Table) Len() int {
+	return len(t.rules)
+}
+
+// Map returns the other name of path, which may be a glob, and whether
+// path is the legacy one. Globs are mapped only if their leading segments
+// spell out the literal segments of a pattern.
+func (t *Table) Map(path string) (other string, legacy bool, ok bool) {
+	segments := strings.Split(path, ".")
+	for _, r := range t.rules {
+		if other, ok := apply(segments, r[0], r[1]); ok {
+			return other, true, true
+		}
+		if other, ok := apply(segments, r[1], r[0]); ok {
+			return other, false, true
+		}
+	}
+
+	return "", false, false
+}
+
+func apply(segments, from, to []string) (string, bool) {
+	if len(segments) < len(from) {
+		return "", false
+	}
+
+	var captured []string
+	for i, s := range from {
+		if s == "*" {
+			captured = append(captured, segments[i])
+		} else if segments[i] != s {
+			return "", false
+		}
+	}
+
+	mapped := make([]string, 0, len(to)+len(segments)-len(from))
+	for _, s := range to {
+		if s == "*" {
+			s, captured = captured[0], captured[1:]
+		}
+		mapped = append(mapped, s)
+	}
+	mapped = append(mapped, segments[len(from):]...)
+
+	return strings.Join(mapped, "."), true
+}
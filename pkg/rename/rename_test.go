@@ -0,0 +1,58 @@
+package rename
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	tb, err := Parse(strings.NewReader(`
+- from: "servers.*.cpu"
+  to: "hosts.*.cpu"
+- from: "legacy.*.*"
+  to: "apps.*.metrics.*"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		other  string
+		legacy bool
+		ok     bool
+	}{
+		{"servers.web1.cpu", "hosts.web1.cpu", true, true},
+		{"servers.web1.cpu.user", "hosts.web1.cpu.user", true, true},
+		{"hosts.*.cpu.{user,system}", "servers.*.cpu.{user,system}", false, true},
+		{"legacy.shop.latency", "apps.shop.metrics.latency", true, true},
+		{"apps.shop.metrics.latency.p99", "legacy.shop.latency.p99", false, true},
+		{"servers.web1", "", false, false},
+		{"servers.web1.mem", "", false, false},
+		{"*.web1.cpu", "", false, false},
+	}
+	for _, tt := range tests {
+		other, legacy, ok := tb.Map(tt.path)
+		if other != tt.other || legacy != tt.legacy || ok != tt.ok {
+			t.Errorf("Map(%q) = %q, %v, %v, want %q, %v, %v", tt.path, other, legacy, ok, tt.other, tt.legacy, tt.ok)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	bad := [][]Rule{
+		{{From: "a.*", To: "b"}},
+		{{From: "a.b*", To: "c.*"}},
+		{{From: "a..b", To: "c"}},
+		{{From: "", To: "c"}},
+	}
+	for _, rules := range bad {
+		if _, err := New(rules); err == nil {
+			t.Errorf("New(%+v): expected an error", rules)
+		}
+	}
+
+	if _, err := Parse(strings.NewReader(`[{from: a, to: b, until: c}]`)); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Package scrub redacts secrets and personal data, like tokens or customer IDs
+embedded in metric names, from what is logged.
+
+Example use:
+
+	s, err := New(Config{Patterns: []string{`customers\.([0-9]+)\.`, `token=[^&]+`}})
+	logger = s.Wrap(logger)
+	logger.Info("slow request", zap.String("url", "/render?target=customers.42.orders&token=abc"))
+	// logs url "/render?target=customers.[REDACTED].orders&[REDACTED]"
+*/
+package scrub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultReplacement replaces what is redacted unless configured otherwise.
+const DefaultReplacement = "[REDACTED]"
+
+// Config configures scrubbing. No patterns disables it.
+type Config struct {
+	// Patterns are regular expressions of what to redact. Of patterns with
+	// groups only the groups are redacted, of others the whole match.
+	Patterns    []string `yaml:"patterns"`
+	Replacement string   `yaml:"replacement"`
+}
+
+// Scrubber redacts the configured patterns. A nil Scrubber redacts nothing.
+type Scrubber struct {
+	patterns    []*regexp.Regexp
+	replacement string
+}
+
+// New compiles the patterns of cfg. It returns a nil Scrubber if there are
+// none.
+func New(cfg Config) (*Scrubber, error) {
+	if len(cfg.Patterns) == 0 {
+		return nil, nil
+	}
+
+	s := &Scrubber{replacement: cfg.Replacement}
+	if s.replacement == "" {
+		s.replacement = DefaultReplacement
+	}
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", p, err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+
+	return s, nil
+}
+
+// String returns v with the patterns redacted.
+func (s *Scrubber) String(v string) string {
+	if s == nil {
+		return v
+	}
+
+	for _, re := range s.patterns {
+		if re.NumSubexp() == 0 {
+			v = re.ReplaceAllLiteralString(v, s.replacement)
+			continue
+		}
+
+		matches := re.FindAllStringSubmatchIndex(v, -1)
+		if matches == nil {
+			continue
+		}
+		var b []byte
+		last := 0
+		for _, m := range matches {
+			for g := 2; g+1 < len(m); g += 2 {
+				if m[g] < last {
+					// unmatched or nested in a group already redacted
+					continue
+				}
+				b = append(b, v[last:m[g]]...)
+				b = append(b, s.replacement...)
+				last = m[g+1]
+			}
+		}
+		v = string(append(b, v[last:]...))
+	}
+
+	return v
+}
+
+// Wrap returns a logger that redacts the patterns from the messages and
+// fields logger logs.
+func (s *Scrubber) Wrap(logger *zap.Logger) *zap.Logger {
+	if s == nil {
+		return logger
+	}
+
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return core{Core: c, s: s}
+	}))
+}
+
+type core struct {
+	zapcore.Core
+	s *Scrubber
+}
+
+func (c core) With(fields []zapcore.Field) zapcore.Core {
+	return core{Core: c.Core.With(c.s.fields(fields)), s: c.s}
+}
+
+func (c core) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c core) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = c.s.String(e.Message)
+	return c.Core.Write(e, c.s.fields(fields))
+}
+
+// fields redacts string fields and, through their JSON, reflected ones.
+func (s *Scrubber) fields(fields []zapcore.Field) []zapcore.Field {
+	scrubbed := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = s.String(f.String)
+		case zapcore.StringerType:
+			if v, ok := f.Interface.(fmt.Stringer); ok {
+				f = zap.String(f.Key, s.String(v.String()))
+			}
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f = zap.String(f.Key, s.String(err.Error()))
+			}
+		case zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType, zapcore.ReflectType:
+			f = s.reflected(f)
+		}
+		scrubbed[i] = f
+	}
+
+	return scrubbed
+}
+
+func (s *Scrubber) reflected(f zapcore.Field) zapcore.Field {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(f.Interface); err != nil {
+		return f
+	}
+
+	b := bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+	redacted := s.String(string(b))
+	if redacted == string(b) {
+		return f
+	}
+	if !json.Valid([]byte(redacted)) {
+		return zap.String(f.Key, redacted)
+	}
+
+	return zap.Reflect(f.Key, json.RawMessage(redacted))
+}
@@ -0,0 +1,79 @@
+package scrub
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestString(t *testing.T) {
+	s, err := New(Config{Patterns: []string{`customers\.([0-9]+)\.`, `token=[^&]+`, `(q)|(b)x`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"servers.web1.cpu":                             "servers.web1.cpu",
+		"customers.42.orders":                          "customers.[REDACTED].orders",
+		"sum(customers.42.orders,customers.7.orders)":  "sum(customers.[REDACTED].orders,customers.[REDACTED].orders)",
+		"/render?target=customers.42.orders&token=abc": "/render?target=customers.[REDACTED].orders&[REDACTED]",
+		"bx": "[REDACTED]x",
+		"/render?token=abc&target=customers.1.orders&z": "/render?[REDACTED]&target=customers.[REDACTED].orders&z",
+	}
+	for in, want := range tests {
+		if got := s.String(in); got != want {
+			t.Errorf("String(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	var nilScrubber *Scrubber
+	if got := nilScrubber.String("customers.42.orders"); got != "customers.42.orders" {
+		t.Errorf("nil Scrubber changed %q", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if s, err := New(Config{}); s != nil || err != nil {
+		t.Errorf("New without patterns = %v, %v, want nil, nil", s, err)
+	}
+	if _, err := New(Config{Patterns: []string{"("}}); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	s, err := New(Config{Patterns: []string{`customers\.([0-9]+)`, `token=[^&"]+`}, Replacement: "***"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.DebugLevel)
+	logger := s.Wrap(zap.New(core)).With(zap.String("target", "customers.42.orders"))
+
+	logger.Debug("fetching customers.7",
+		zap.String("url", "/render?token=abc&target=x"),
+		zap.Strings("metrics", []string{"customers.1.a", "customers.2.b"}),
+		zap.Any("data", struct {
+			Url string `json:"url"`
+		}{"/render?target=customers.3.orders&token=xyz"}),
+		zap.Error(errors.New("no data for customers.5")),
+		zap.Int("count", 2),
+	)
+
+	got := buf.String()
+	for _, secret := range []string{"42", "customers.7", "abc", "customers.1", "customers.2", "customers.3", "xyz", "customers.5"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("log has %q: %s", secret, got)
+		}
+	}
+	for _, want := range []string{`"msg":"fetching customers.***"`, `"data":{"url":"/render?target=customers.***.orders\u0026***"}`, `"count":2`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log lacks %s: %s", want, got)
+		}
+	}
+}
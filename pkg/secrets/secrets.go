@@ -0,0 +1,317 @@
+/*
+Package secrets resolves references to secrets in configuration values, so
+that passwords and keys need not be kept in configuration files.
+
+A reference is replaced by the secret it names:
+
+	${env:NAME}             the environment variable NAME
+	${file:/path}           the content of a file, without trailing newlines,
+	                        as container orchestrators mount secrets
+	${vault:path#field}     a field of the secret at path in HashiCorp Vault,
+	                        read with the KV version 1 or 2 API
+
+Secrets from Vault may have a lease, after which they have to be read again,
+as dynamic credentials are revoked when theirs expires.
+
+Example use:
+
+	r := New(Config{Vault: VaultConfig{Address: "https://vault:8200"}})
+	resolved := parsed
+	ttl, err := r.Resolve(ctx, &resolved)
+*/
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config configures where secrets are read from.
+type Config struct {
+	Vault VaultConfig `yaml:"vault"`
+}
+
+// VaultConfig configures the reads from Vault.
+type VaultConfig struct {
+	// Address is that of Vault, VAULT_ADDR by default.
+	Address string `yaml:"address"`
+	// TokenFile holds the token to read secrets with, VAULT_TOKEN by
+	// default.
+	TokenFile string        `yaml:"tokenFile"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+var reference = regexp.MustCompile(`\$\{(env|file|vault):([^}]+)\}`)
+
+// Resolver resolves references to secrets.
+type Resolver struct {
+	vault     VaultConfig
+	client    *http.Client
+	lookupEnv func(string) (string, bool)
+}
+
+// New returns a Resolver reading from the sources of cfg.
+func New(cfg Config) *Resolver {
+	if cfg.Vault.Timeout <= 0 {
+		cfg.Vault.Timeout = 10 * time.Second
+	}
+
+	return &Resolver{
+		vault:     cfg.Vault,
+		client:    &http.Client{Timeout: cfg.Vault.Timeout},
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+// Resolve replaces the references in the strings of the struct v points to,
+// and in those of its fields, slices and maps, which are copied so that
+// what v shared with other values keeps its references. It returns how
+// long until the first of the secrets expires, 0 if none does.
+func (r *Resolver) Resolve(ctx context.Context, v interface{}) (time.Duration, error) {
+	p := reflect.ValueOf(v)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return 0, errors.New("secrets: Resolve needs a non-nil pointer")
+	}
+
+	res := &resolution{
+		Resolver: r,
+		ctx:      ctx,
+		read:     make(map[string]vaultSecret),
+	}
+	resolved, err := res.value(p.Elem())
+	if err != nil {
+		return 0, err
+	}
+	p.Elem().Set(resolved)
+
+	return res.ttl, nil
+}
+
+// resolution is one run of Resolve, which reads every secret from Vault
+// once.
+type resolution struct {
+	*Resolver
+	ctx  context.Context
+	read map[string]vaultSecret
+	ttl  time.Duration
+}
+
+// value returns a copy of v with its references resolved.
+func (r *resolution) value(v reflect.Value) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := r.expand(v.String())
+		if err != nil {
+			return v, err
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.SetString(s)
+		return out, nil
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, nil
+		}
+		e, err := r.value(v.Elem())
+		if err != nil {
+			return v, err
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(e)
+		return out, nil
+
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if !out.Field(i).CanSet() {
+				continue
+			}
+			f, err := r.value(v.Field(i))
+			if err != nil {
+				return v, errors.Wrap(err, v.Type().Field(i).Name)
+			}
+			out.Field(i).Set(f)
+		}
+		return out, nil
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := r.value(v.Index(i))
+			if err != nil {
+				return v, err
+			}
+			out.Index(i).Set(e)
+		}
+		return out, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			e, err := r.value(v.MapIndex(k))
+			if err != nil {
+				return v, errors.Wrap(err, fmt.Sprint(k.Interface()))
+			}
+			out.SetMapIndex(k, e)
+		}
+		return out, nil
+	}
+
+	return v, nil
+}
+
+// expand replaces the references in s.
+func (r *resolution) expand(s string) (string, error) {
+	matches := reference.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		source, ref := s[m[2]:m[3]], s[m[4]:m[5]]
+		secret, err := r.secret(source, ref)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[last:m[0]])
+		b.WriteString(secret)
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+
+	return b.String(), nil
+}
+
+func (r *resolution) secret(source, ref string) (string, error) {
+	switch source {
+	case "env":
+		v, ok := r.lookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return v, nil
+	case "file":
+		b, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	default:
+		return r.fromVault(ref)
+	}
+}
+
+// vaultSecret is a secret read from Vault.
+type vaultSecret struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+// fields returns the fields of s, those under data for version 2 of the KV
+// API.
+func (s vaultSecret) fields() map[string]interface{} {
+	if inner, ok := s.Data["data"].(map[string]interface{}); ok {
+		if _, ok := s.Data["metadata"]; ok {
+			return inner
+		}
+	}
+
+	return s.Data
+}
+
+// fromVault reads the field of the secret ref, "path#field", names.
+func (r *resolution) fromVault(ref string) (string, error) {
+	hash := strings.LastIndexByte(ref, '#')
+	if hash <= 0 || hash == len(ref)-1 {
+		return "", fmt.Errorf("vault reference %q must be path#field", ref)
+	}
+	path, field := strings.Trim(ref[:hash], "/"), ref[hash+1:]
+
+	s, ok := r.read[path]
+	if !ok {
+		var err error
+		if s, err = r.readVault(path); err != nil {
+			return "", errors.Wrapf(err, "reading %s from vault", path)
+		}
+		r.read[path] = s
+		if lease := time.Duration(s.LeaseDuration) * time.Second; lease > 0 && (r.ttl == 0 || lease < r.ttl) {
+			r.ttl = lease
+		}
+	}
+
+	v, ok := s.fields()[field]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field %s", path, field)
+	}
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+
+	return fmt.Sprint(v), nil
+}
+
+func (r *resolution) readVault(path string) (vaultSecret, error) {
+	addr := r.vault.Address
+	if addr == "" {
+		addr, _ = r.lookupEnv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return vaultSecret{}, errors.New("no vault address configured")
+	}
+
+	token, _ := r.lookupEnv("VAULT_TOKEN")
+	if r.vault.TokenFile != "" {
+		b, err := ioutil.ReadFile(r.vault.TokenFile)
+		if err != nil {
+			return vaultSecret{}, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	req = req.WithContext(r.ctx)
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return vaultSecret{}, fmt.Errorf("vault answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var s vaultSecret
+	if err := json.Unmarshal(body, &s); err != nil {
+		return vaultSecret{}, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type redis struct {
+	Address  string
+	Password string
+}
+
+type config struct {
+	Name    string
+	Redis   redis
+	Clients map[string]string
+	Hosts   []string
+	Backup  *redis
+	Timeout time.Duration
+	secret  string
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "t0ken" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/carbonapi":
+			w.Write([]byte(`{"lease_duration": 0, "data": {"data": {"exporter": "from-kv2"}, "metadata": {"version": 3}}}`))
+		case "/v1/database/creds/redis":
+			w.Write([]byte(`{"lease_duration": 3600, "data": {"password": "from-lease", "port": 6379}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	r := New(Config{Vault: VaultConfig{Address: vault.URL}})
+	r.lookupEnv = func(name string) (string, bool) {
+		switch name {
+		case "REDIS_HOST":
+			return "redis.local", true
+		case "VAULT_TOKEN":
+			return "t0ken", true
+		}
+		return "", false
+	}
+
+	raw := config{
+		Name: "plain ${not:a-reference}",
+		Redis: redis{
+			Address:  "${env:REDIS_HOST}:${vault:database/creds/redis#port}",
+			Password: "${vault:database/creds/redis#password}",
+		},
+		Clients: map[string]string{"exporter": "${vault:secret/data/carbonapi#exporter}"},
+		Hosts:   []string{"${env:REDIS_HOST}"},
+		Backup:  &redis{Password: "${file:" + file + "}"},
+		Timeout: time.Second,
+		secret:  "${env:REDIS_HOST}",
+	}
+	resolved := raw
+	ttl, err := r.Resolve(context.Background(), &resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl != time.Hour {
+		t.Errorf("expected the lease of an hour, got %v", ttl)
+	}
+	if resolved.Name != raw.Name {
+		t.Errorf("expected %q untouched, got %q", raw.Name, resolved.Name)
+	}
+	if resolved.Redis.Address != "redis.local:6379" || resolved.Redis.Password != "from-lease" {
+		t.Errorf("unexpected redis config %+v", resolved.Redis)
+	}
+	if resolved.Clients["exporter"] != "from-kv2" {
+		t.Errorf("expected the KV version 2 secret, got %q", resolved.Clients["exporter"])
+	}
+	if resolved.Hosts[0] != "redis.local" {
+		t.Errorf("expected the host from the environment, got %q", resolved.Hosts[0])
+	}
+	if resolved.Backup.Password != "from-file" {
+		t.Errorf("expected the password from the file, got %q", resolved.Backup.Password)
+	}
+	if resolved.Timeout != time.Second || resolved.secret != raw.secret {
+		t.Errorf("expected the other fields to be copied, got %+v", resolved)
+	}
+
+	// The raw config keeps its references to be resolved again.
+	if raw.Clients["exporter"] != "${vault:secret/data/carbonapi#exporter}" || raw.Hosts[0] != "${env:REDIS_HOST}" || raw.Backup.Password != "${file:"+file+"}" {
+		t.Errorf("expected the raw config to be left alone, got %+v", raw)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	r := New(Config{})
+	r.lookupEnv = func(string) (string, bool) { return "", false }
+
+	for _, c := range []config{
+		{Name: "${env:MISSING}"},
+		{Name: "${file:/nonexistent/secret}"},
+		{Name: "${vault:secret/carbonapi}"},
+		{Clients: map[string]string{"a": "${vault:secret/carbonapi#a}"}},
+	} {
+		if _, err := r.Resolve(context.Background(), &c); err == nil {
+			t.Errorf("expected an error resolving %+v", c)
+		}
+	}
+}
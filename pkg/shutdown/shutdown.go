@@ -0,0 +1,69 @@
+/*
+Package shutdown stops servers without cutting the requests they are
+answering. Told to stop, by SIGTERM or SIGINT, a server stops accepting
+connections, lets the requests in flight finish for up to a drain timeout,
+and then runs the hooks of the process, e.g. to save its caches and close
+its connections to backends.
+*/
+package shutdown
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Serve serves s on listeners until the process is told to stop, then
+// drains s and runs hooks in order. It returns the first error of a
+// listener, or nil once the hooks have run.
+func Serve(s *http.Server, listeners []net.Listener, drain time.Duration, logger *zap.Logger, hooks ...func()) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	return serve(s, listeners, drain, stop, logger, hooks)
+}
+
+func serve(s *http.Server, listeners []net.Listener, drain time.Duration, stop <-chan os.Signal, logger *zap.Logger, hooks []func()) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(l)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		logger.Info("shutting down",
+			zap.String("signal", sig.String()),
+			zap.Duration("drain_timeout", drain),
+		)
+	}
+
+	t0 := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Warn("requests still in flight after the drain timeout were cut",
+			zap.Error(err),
+		)
+		s.Close()
+	}
+	logger.Info("drained",
+		zap.Duration("runtime", time.Since(t0)),
+	)
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package shutdown
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServeDrains(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})}
+
+	stop := make(chan os.Signal, 1)
+	var hooked bool
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(s, []net.Listener{l}, time.Minute, stop, zap.NewNop(), []func(){func() { hooked = true }})
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(b)
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	// New connections are refused while the request in flight finishes.
+	for {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			break
+		}
+		c.Close()
+		time.Sleep(time.Millisecond)
+	}
+	if hooked {
+		t.Error("Expected the hooks to run once drained")
+	}
+
+	close(release)
+	if b := <-body; b != "done" {
+		t.Errorf("Expected the request in flight to finish, got %q", b)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if !hooked {
+		t.Error("Expected the hooks to run")
+	}
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})}
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(s, []net.Listener{l}, 10*time.Millisecond, stop, zap.NewNop(), nil)
+	}()
+
+	go http.Get("http://" + l.Addr().String())
+	<-started
+	stop <- syscall.SIGTERM
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected requests to be cut after the drain timeout")
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Package signature verifies requests signed by machine-to-machine callers with
+a secret they share with the server, for callers such as batch exporters that
+have no other way to authenticate.
+
+A signed request names its client in the X-Carbonapi-Client header, and has
+the Unix time it was signed at in X-Carbonapi-Timestamp and the hex-encoded
+HMAC-SHA256 of the timestamp, the path and query of the request as sent on
+its request line, and its body, each followed by a newline, in
+X-Carbonapi-Signature. The request line is what is signed so that handlers
+that rewrite the URL, such as those stripping a route prefix, do not break
+the signature. Signatures older
+or newer than MaxSkew are refused, so a replayed request stops working once
+it expires.
+
+Example use:
+
+	v, err := New(Config{Clients: map[string]string{"exporter": "s3cret"}})
+	client, err := v.Verify(req)
+
+and on the client:
+
+	err := Sign(req, "exporter", "s3cret", time.Now())
+*/
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The headers of a signed request.
+const (
+	ClientHeader    = "X-Carbonapi-Client"
+	TimestampHeader = "X-Carbonapi-Timestamp"
+	SignatureHeader = "X-Carbonapi-Signature"
+)
+
+// DefaultMaxSkew is how old signatures may be unless configured otherwise.
+const DefaultMaxSkew = 5 * time.Minute
+
+// maxBody bounds the bodies that are read to verify their signature.
+const maxBody = 10 << 20
+
+// The reasons requests are refused for.
+var (
+	ErrUnsigned      = errors.New("request is not signed")
+	ErrUnknownClient = errors.New("unknown client")
+	ErrExpired       = errors.New("signature expired")
+	ErrBadSignature  = errors.New("bad signature")
+)
+
+// Config configures the verification. No clients disables it.
+type Config struct {
+	// Clients are the shared secrets of the callers, by client name.
+	Clients map[string]string `yaml:"clients"`
+	// MaxSkew is how far from now the timestamp of a request may be.
+	MaxSkew time.Duration `yaml:"maxSkew"`
+	// Required refuses unsigned requests, which are otherwise served as
+	// they would be without verification.
+	Required bool `yaml:"required"`
+}
+
+// Verifier verifies the signatures of requests.
+type Verifier struct {
+	mu       sync.RWMutex
+	secrets  map[string][]byte
+	maxSkew  time.Duration
+	required bool
+	now      func() time.Time
+}
+
+// New checks cfg. It returns a nil Verifier if no clients are configured.
+func New(cfg Config) (*Verifier, error) {
+	if len(cfg.Clients) == 0 {
+		return nil, nil
+	}
+	if cfg.MaxSkew < 0 {
+		return nil, errors.New("negative maxSkew")
+	}
+
+	v := &Verifier{
+		secrets:  make(map[string][]byte, len(cfg.Clients)),
+		maxSkew:  cfg.MaxSkew,
+		required: cfg.Required,
+		now:      time.Now,
+	}
+	if v.maxSkew == 0 {
+		v.maxSkew = DefaultMaxSkew
+	}
+	for client, secret := range cfg.Clients {
+		if secret == "" {
+			return nil, fmt.Errorf("client %q has no secret", client)
+		}
+		v.secrets[client] = []byte(secret)
+	}
+
+	return v, nil
+}
+
+// Verify checks the signature of r and returns the client that signed it.
+// Unsigned requests are let through with no client unless signatures are
+// required. The body of r is read, and replaced to be read again.
+func (v *Verifier) Verify(r *http.Request) (string, error) {
+	v.mu.RLock()
+	secrets, maxSkew, required := v.secrets, v.maxSkew, v.required
+	v.mu.RUnlock()
+
+	client := r.Header.Get(ClientHeader)
+	if client == "" && r.Header.Get(SignatureHeader) == "" {
+		if required {
+			return "", ErrUnsigned
+		}
+		return "", nil
+	}
+
+	secret, ok := secrets[client]
+	if !ok {
+		return client, ErrUnknownClient
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return client, errors.Wrap(ErrBadSignature, "bad timestamp")
+	}
+	skew := v.now().Sub(time.Unix(ts, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return client, ErrExpired
+	}
+
+	want, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return client, ErrBadSignature
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return client, err
+	}
+	if !hmac.Equal(want, mac(secret, ts, requestURI(r), body)) {
+		return client, ErrBadSignature
+	}
+
+	return client, nil
+}
+
+// Update replaces the clients and settings of v with those of cfg, as
+// when their secrets are rotated.
+func (v *Verifier) Update(cfg Config) error {
+	n, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return errors.New("no clients")
+	}
+
+	v.mu.Lock()
+	v.secrets, v.maxSkew, v.required = n.secrets, n.maxSkew, n.required
+	v.mu.Unlock()
+
+	return nil
+}
+
+// Sign signs r, as of now, for client with its secret. The body of r is
+// read, and replaced to be read again.
+func Sign(r *http.Request, client, secret string, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	ts := now.Unix()
+	r.Header.Set(ClientHeader, client)
+	r.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	r.Header.Set(SignatureHeader, hex.EncodeToString(mac([]byte(secret), ts, requestURI(r), body)))
+
+	return nil
+}
+
+// requestURI is the path and query of r as on its request line. Requests
+// being sent have none yet, and are signed with what will be sent.
+func requestURI(r *http.Request) string {
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+
+	return r.URL.RequestURI()
+}
+
+func mac(secret []byte, ts int64, uri string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%d\n%s\n", ts, uri)
+	h.Write(body)
+	h.Write([]byte{'\n'})
+
+	return h.Sum(nil)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody))
+	r.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the body")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+type key int
+
+const clientKey key = 0
+
+// NewContext returns a context that carries the client that signed the
+// request.
+func NewContext(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey, client)
+}
+
+// FromContext returns the client ctx carries, or "" if the request was not
+// signed.
+func FromContext(ctx context.Context) string {
+	if client, ok := ctx.Value(clientKey).(string); ok {
+		return client
+	}
+
+	return ""
+}
@@ -0,0 +1,120 @@
+package signature
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	v, err := New(Config{Clients: map[string]string{"exporter": "s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	req := httptest.NewRequest("POST", "/render/?format=json", strings.NewReader("target=foo.bar"))
+	if err := Sign(req, "exporter", "s3cret", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := v.Verify(req)
+	if client != "exporter" || err != nil {
+		t.Errorf("expected exporter's signature to verify, got %q, %v", client, err)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "target=foo.bar" {
+		t.Errorf("expected the body to be readable after verifying, got %q", body)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	if client, err := v.Verify(req); client != "" || err != nil {
+		t.Errorf("expected unsigned requests through, got %q, %v", client, err)
+	}
+	v.required = true
+	if _, err := v.Verify(req); err != ErrUnsigned {
+		t.Errorf("expected %v, got %v", ErrUnsigned, err)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	Sign(req, "exporter", "s3cret", now.Add(-10*time.Minute))
+	if _, err := v.Verify(req); err != ErrExpired {
+		t.Errorf("expected %v for an old signature, got %v", ErrExpired, err)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	Sign(req, "exporter", "guess", now)
+	if _, err := v.Verify(req); err != ErrBadSignature {
+		t.Errorf("expected %v for the wrong secret, got %v", ErrBadSignature, err)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	Sign(req, "exporter", "s3cret", now)
+	req.RequestURI = "/render/?target=secrets.*"
+	if _, err := v.Verify(req); err != ErrBadSignature {
+		t.Errorf("expected %v for a changed query, got %v", ErrBadSignature, err)
+	}
+
+	req = httptest.NewRequest("GET", "/graphite/render/?target=foo.bar", nil)
+	Sign(req, "exporter", "s3cret", now)
+	req.URL.Path = "/render/"
+	if _, err := v.Verify(req); err != nil {
+		t.Errorf("expected the signature to verify after the URL was rewritten, got %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	Sign(req, "intruder", "s3cret", now)
+	if _, err := v.Verify(req); err != ErrUnknownClient {
+		t.Errorf("expected %v, got %v", ErrUnknownClient, err)
+	}
+
+	req = httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	Sign(req, "exporter", "s3cret", now)
+	req.Header.Set(TimestampHeader, "soon")
+	if _, err := v.Verify(req); errors.Cause(err) != ErrBadSignature {
+		t.Errorf("expected %v for a bad timestamp, got %v", ErrBadSignature, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	v, err := New(Config{Clients: map[string]string{"exporter": "old"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Update(Config{Clients: map[string]string{"exporter": "new"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+	Sign(req, "exporter", "old", time.Now())
+	if _, err := v.Verify(req); err != ErrBadSignature {
+		t.Errorf("expected the old secret to be refused, got %v", err)
+	}
+	Sign(req, "exporter", "new", time.Now())
+	if _, err := v.Verify(req); err != nil {
+		t.Errorf("expected the new secret to verify, got %v", err)
+	}
+
+	if err := v.Update(Config{}); err == nil {
+		t.Error("expected an error updating to no clients")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if v, err := New(Config{}); v != nil || err != nil {
+		t.Errorf("expected no verifier without clients, got %v, %v", v, err)
+	}
+	if _, err := New(Config{Clients: map[string]string{"exporter": ""}}); err == nil {
+		t.Error("expected an error for a client without a secret")
+	}
+	v, err := New(Config{Clients: map[string]string{"exporter": "s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.maxSkew != DefaultMaxSkew {
+		t.Errorf("expected the default skew, got %v", v.maxSkew)
+	}
+}
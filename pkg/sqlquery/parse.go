@@ -0,0 +1,526 @@
+/*
+Package sqlquery runs read-only SELECTs over the points of rendered series,
+as the rows of a virtual table
+
+	series(name, time, value)
+
+with a row per point: the name of its series, its timestamp, and its value,
+NULL if it is absent. The series are those of the graphite target the WHERE
+clause asks for, which, with the bounds it puts on time, is what the caller
+renders before running the query over the result.
+
+The queries look like:
+
+	SELECT column [AS alias], ... | *
+	FROM series
+	WHERE target = 'target' [AND condition ...]
+	[GROUP BY name | time, ...]
+	[ORDER BY column | position [ASC | DESC], ...]
+	[LIMIT n]
+
+where a column is name, time, value, or avg, sum, min, max or count of time
+or value, or count(*). Conditions compare name with a string (=, !=, LIKE),
+time with an epoch or a graphite time like '-1h' (<, <=, >, >=), and value
+with a number, or check that value IS [NOT] NULL. Keywords are case
+insensitive.
+
+Example use:
+
+	q, err := Parse("SELECT name, max(value) FROM series WHERE target = 'servers.*.cpu' AND time >= '-1h' GROUP BY name ORDER BY 2 DESC LIMIT 5")
+	// render q.Target from q.From until q.Until
+	res, err := q.Run(series, from, until, 100000)
+*/
+package sqlquery
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type field int
+
+const (
+	fieldName field = iota
+	fieldTime
+	fieldValue
+	fieldRows // the rows themselves, as in count(*)
+)
+
+var fieldNames = map[string]field{
+	"name":  fieldName,
+	"time":  fieldTime,
+	"value": fieldValue,
+}
+
+var aggregates = map[string]bool{
+	"avg":   true,
+	"sum":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+// column is a column of the result.
+type column struct {
+	label string
+	// agg is the aggregate of field, if any.
+	agg   string
+	field field
+}
+
+// filter is a condition on the rows.
+type filter struct {
+	field field
+	op    string
+	str   string
+	num   float64
+	like  *regexp.Regexp
+}
+
+type order struct {
+	column int
+	desc   bool
+}
+
+// Query is a parsed query.
+type Query struct {
+	// Target is the graphite target whose series the query is over.
+	Target string
+	// From and Until are the times the target is to be rendered between,
+	// as the from and until parameters of a render. They are empty if the
+	// query doesn't bound time.
+	From, Until string
+
+	fromOp, untilOp string
+
+	columns []column
+	filters []filter
+	groupBy []field
+	orders  []order
+	limit   int
+}
+
+// grouped tells if the query aggregates rows.
+func (q *Query) grouped() bool {
+	if len(q.groupBy) > 0 {
+		return true
+	}
+	for _, c := range q.columns {
+		if c.agg != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the keyword or symbol s.
+func (p *parser) accept(s string) bool {
+	if p.peek().is(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return fmt.Errorf("expected %s, got %s", strings.ToUpper(s), p.peek())
+	}
+	return nil
+}
+
+// Parse parses a query.
+func Parse(s string) (*Query, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	q := &Query{limit: -1}
+
+	if err := p.expect("select"); err != nil {
+		return nil, err
+	}
+	if err := p.parseColumns(q); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("from"); err != nil {
+		return nil, err
+	}
+	if t := p.next(); !t.is("series") {
+		return nil, fmt.Errorf("unknown table %s, there is only series", t)
+	}
+
+	if err := p.expect("where"); err != nil {
+		return nil, errors.New("a WHERE clause must give the target")
+	}
+	for {
+		if err := p.parseCondition(q); err != nil {
+			return nil, err
+		}
+		if p.peek().is("or") {
+			return nil, errors.New("conditions can only be joined with AND")
+		}
+		if !p.accept("and") {
+			break
+		}
+	}
+	if q.Target == "" {
+		return nil, errors.New("the WHERE clause must give the target, as target = '...'")
+	}
+
+	if p.accept("group") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		if err := p.parseGroupBy(q); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("order") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		if err := p.parseOrderBy(q); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("limit") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return nil, fmt.Errorf("bad LIMIT %s", t)
+		}
+		q.limit = n
+	}
+
+	p.accept(";")
+	if t := p.next(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s", t)
+	}
+
+	if err := q.check(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (p *parser) parseColumns(q *Query) error {
+	if p.accept("*") {
+		q.columns = []column{
+			{label: "name", field: fieldName},
+			{label: "time", field: fieldTime},
+			{label: "value", field: fieldValue},
+		}
+		return nil
+	}
+
+	for {
+		c, err := p.parseColumn()
+		if err != nil {
+			return err
+		}
+		q.columns = append(q.columns, c)
+
+		if !p.accept(",") {
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseColumn() (column, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return column{}, fmt.Errorf("expected a column, got %s", t)
+	}
+	name := strings.ToLower(t.text)
+
+	var c column
+	if p.accept("(") {
+		if !aggregates[name] {
+			return column{}, fmt.Errorf("unknown function %s", t)
+		}
+		c.agg = name
+
+		arg := p.next()
+		switch {
+		case arg.is("*") && name == "count":
+			c.field = fieldRows
+			c.label = "count(*)"
+		case arg.is("value") || arg.is("time"):
+			c.field = fieldNames[strings.ToLower(arg.text)]
+			c.label = name + "(" + strings.ToLower(arg.text) + ")"
+		default:
+			return column{}, fmt.Errorf("%s of %s: can only aggregate time or value", name, arg)
+		}
+
+		if err := p.expect(")"); err != nil {
+			return column{}, err
+		}
+	} else {
+		f, ok := fieldNames[name]
+		if !ok {
+			return column{}, fmt.Errorf("unknown column %s", t)
+		}
+		c.field = f
+		c.label = name
+	}
+
+	if p.accept("as") {
+		alias := p.next()
+		if alias.kind != tokIdent && alias.kind != tokString {
+			return column{}, fmt.Errorf("bad alias %s", alias)
+		}
+		c.label = alias.text
+	}
+
+	return c, nil
+}
+
+func (p *parser) parseCondition(q *Query) error {
+	t := p.next()
+	if t.is("target") {
+		if q.Target != "" {
+			return errors.New("the target is given more than once")
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		v := p.next()
+		if v.kind != tokString || v.text == "" {
+			return fmt.Errorf("the target must be a string, got %s", v)
+		}
+		q.Target = v.text
+		return nil
+	}
+
+	f, ok := fieldNames[strings.ToLower(t.text)]
+	if t.kind != tokIdent || !ok {
+		return fmt.Errorf("expected a condition on target, name, time or value, got %s", t)
+	}
+
+	switch f {
+	case fieldName:
+		return p.parseNameCondition(q)
+	case fieldTime:
+		return p.parseTimeCondition(q)
+	default:
+		return p.parseValueCondition(q)
+	}
+}
+
+func (p *parser) parseNameCondition(q *Query) error {
+	op := p.next()
+	v := p.next()
+	if v.kind != tokString {
+		return fmt.Errorf("name must be compared with a string, got %s", v)
+	}
+
+	switch {
+	case op.is("="), op.is("!="), op.is("<>"):
+		q.filters = append(q.filters, filter{field: fieldName, op: normalizeOp(op.text), str: v.text})
+	case op.is("like"):
+		q.filters = append(q.filters, filter{field: fieldName, op: "like", like: likePattern(v.text)})
+	default:
+		return fmt.Errorf("name can't be compared with %s", op)
+	}
+
+	return nil
+}
+
+func (p *parser) parseTimeCondition(q *Query) error {
+	op := p.next()
+	v := p.next()
+	if v.kind != tokString && v.kind != tokNumber {
+		return fmt.Errorf("time must be compared with an epoch or a time, got %s", v)
+	}
+
+	switch {
+	case op.is(">"), op.is(">="):
+		if q.fromOp != "" {
+			return errors.New("time is bounded from below more than once")
+		}
+		q.From, q.fromOp = v.text, op.text
+	case op.is("<"), op.is("<="):
+		if q.untilOp != "" {
+			return errors.New("time is bounded from above more than once")
+		}
+		q.Until, q.untilOp = v.text, op.text
+	default:
+		return fmt.Errorf("time can only be bounded with <, <=, > or >=, not %s", op)
+	}
+
+	return nil
+}
+
+func (p *parser) parseValueCondition(q *Query) error {
+	if p.accept("is") {
+		op := "null"
+		if p.accept("not") {
+			op = "not null"
+		}
+		if err := p.expect("null"); err != nil {
+			return err
+		}
+		q.filters = append(q.filters, filter{field: fieldValue, op: op})
+		return nil
+	}
+
+	op := p.next()
+	if op.kind != tokSymbol || strings.IndexAny(op.text, "=<>") < 0 {
+		return fmt.Errorf("value can't be compared with %s", op)
+	}
+	v := p.next()
+	n, err := strconv.ParseFloat(v.text, 64)
+	if v.kind != tokNumber || err != nil {
+		return fmt.Errorf("value must be compared with a number, got %s", v)
+	}
+	q.filters = append(q.filters, filter{field: fieldValue, op: normalizeOp(op.text), num: n})
+
+	return nil
+}
+
+func (p *parser) parseGroupBy(q *Query) error {
+	for {
+		t := p.next()
+		f, ok := fieldNames[strings.ToLower(t.text)]
+		if t.kind != tokIdent || !ok || f == fieldValue {
+			return fmt.Errorf("can only group by name or time, not %s", t)
+		}
+		q.groupBy = append(q.groupBy, f)
+
+		if !p.accept(",") {
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseOrderBy(q *Query) error {
+	for {
+		i, err := p.parseOrderColumn(q)
+		if err != nil {
+			return err
+		}
+
+		o := order{column: i}
+		if p.accept("desc") {
+			o.desc = true
+		} else {
+			p.accept("asc")
+		}
+		q.orders = append(q.orders, o)
+
+		if !p.accept(",") {
+			return nil
+		}
+	}
+}
+
+// parseOrderColumn returns the index of the column to order by, given by
+// its position, its label, or as it was selected.
+func (p *parser) parseOrderColumn(q *Query) (int, error) {
+	if t := p.peek(); t.kind == tokNumber {
+		p.next()
+		n, err := strconv.Atoi(t.text)
+		if err != nil || n < 1 || n > len(q.columns) {
+			return 0, fmt.Errorf("no column %s to order by", t)
+		}
+		return n - 1, nil
+	}
+
+	if t := p.peek(); t.kind == tokIdent && !p.tokens[p.pos+1].is("(") {
+		for i, selected := range q.columns {
+			if strings.EqualFold(selected.label, t.text) {
+				p.next()
+				return i, nil
+			}
+		}
+	}
+
+	c, err := p.parseColumn()
+	if err != nil {
+		return 0, err
+	}
+	for i, selected := range q.columns {
+		if strings.EqualFold(selected.label, c.label) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("can only order by the selected columns, not %s", c.label)
+}
+
+// check checks that the columns of a grouped query are aggregates or
+// grouped by.
+func (q *Query) check() error {
+	if !q.grouped() {
+		return nil
+	}
+
+	for _, c := range q.columns {
+		if c.agg != "" {
+			continue
+		}
+		grouped := false
+		for _, f := range q.groupBy {
+			grouped = grouped || f == c.field
+		}
+		if !grouped {
+			return fmt.Errorf("%s must be grouped by, or aggregated", c.label)
+		}
+	}
+
+	return nil
+}
+
+func normalizeOp(op string) string {
+	if op == "<>" {
+		return "!="
+	}
+	return op
+}
+
+// likePattern compiles a LIKE pattern, where % matches any string and _ any
+// character.
+func likePattern(s string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range s {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
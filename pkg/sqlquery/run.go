@@ -0,0 +1,354 @@
+package sqlquery
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Series is a rendered series whose points are rows.
+type Series struct {
+	Name   string
+	Start  int32
+	Step   int32
+	Values []float64
+	Absent []bool
+}
+
+// Result is the rows of a query, each with a value per column: a string,
+// an int64 timestamp, a float64, or nil for NULL.
+type Result struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// ErrTooManyRows is returned by Run for queries with more rows than asked.
+type ErrTooManyRows int
+
+func (e ErrTooManyRows) Error() string {
+	return fmt.Sprintf("query has more than %d rows, aggregate them or add a LIMIT", int(e))
+}
+
+type row struct {
+	name  string
+	time  int64
+	value float64
+	null  bool
+}
+
+// Run runs the query over series, rendered from from until until. A query
+// whose result has more than maxRows rows, if it is positive, fails with
+// ErrTooManyRows.
+func (q *Query) Run(series []Series, from, until int32, maxRows int) (Result, error) {
+	res := Result{Columns: make([]string, len(q.columns))}
+	for i, c := range q.columns {
+		res.Columns[i] = c.label
+	}
+
+	var rows []row
+	for _, s := range series {
+		if s.Step <= 0 {
+			continue
+		}
+		for i, v := range s.Values {
+			r := row{
+				name:  s.Name,
+				time:  int64(s.Start) + int64(i)*int64(s.Step),
+				value: v,
+				null:  math.IsNaN(v) || i < len(s.Absent) && s.Absent[i],
+			}
+			if q.inRange(r.time, from, until) && q.keep(r) {
+				rows = append(rows, r)
+			}
+		}
+	}
+
+	if q.grouped() {
+		res.Rows = q.aggregate(rows)
+	} else {
+		if maxRows > 0 && len(rows) > maxRows && (q.limit < 0 || q.limit > maxRows) {
+			return Result{}, ErrTooManyRows(maxRows)
+		}
+		res.Rows = make([][]interface{}, len(rows))
+		for i, r := range rows {
+			res.Rows[i] = q.project(r)
+		}
+	}
+
+	q.sort(res.Rows)
+	if q.limit >= 0 && len(res.Rows) > q.limit {
+		res.Rows = res.Rows[:q.limit]
+	}
+	if maxRows > 0 && len(res.Rows) > maxRows {
+		return Result{}, ErrTooManyRows(maxRows)
+	}
+	if res.Rows == nil {
+		res.Rows = [][]interface{}{}
+	}
+
+	return res, nil
+}
+
+// inRange tells if t is within the bounds the query puts on time.
+func (q *Query) inRange(t int64, from, until int32) bool {
+	if q.fromOp == ">" && t <= int64(from) || t < int64(from) {
+		return false
+	}
+	if q.untilOp == "<" && t >= int64(until) || t > int64(until) {
+		return false
+	}
+	return true
+}
+
+// keep tells if r meets the conditions of the query.
+func (q *Query) keep(r row) bool {
+	for _, f := range q.filters {
+		switch f.field {
+		case fieldName:
+			var ok bool
+			switch f.op {
+			case "=":
+				ok = r.name == f.str
+			case "!=":
+				ok = r.name != f.str
+			case "like":
+				ok = f.like.MatchString(r.name)
+			}
+			if !ok {
+				return false
+			}
+
+		case fieldValue:
+			switch f.op {
+			case "null":
+				if !r.null {
+					return false
+				}
+			case "not null":
+				if r.null {
+					return false
+				}
+			default:
+				// comparisons with NULL are never true
+				if r.null || !compare(r.value, f.op, f.num) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func compare(a float64, op string, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// project returns the columns of r.
+func (q *Query) project(r row) []interface{} {
+	out := make([]interface{}, len(q.columns))
+	for i, c := range q.columns {
+		out[i] = r.get(c.field)
+	}
+	return out
+}
+
+func (r row) get(f field) interface{} {
+	switch f {
+	case fieldName:
+		return r.name
+	case fieldTime:
+		return r.time
+	case fieldValue:
+		return number(r.value, r.null)
+	}
+	return nil
+}
+
+// number returns v as a cell, nil if it is NULL or can't be encoded in JSON.
+func number(v float64, null bool) interface{} {
+	if null || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+type group struct {
+	key  row
+	aggs []aggregator
+}
+
+// aggregate returns a row per group of rows, in the order the groups are
+// first seen.
+func (q *Query) aggregate(rows []row) [][]interface{} {
+	var groups []*group
+	byKey := make(map[row]*group)
+	for _, r := range rows {
+		var key row
+		for _, f := range q.groupBy {
+			switch f {
+			case fieldName:
+				key.name = r.name
+			case fieldTime:
+				key.time = r.time
+			}
+		}
+
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{key: key, aggs: make([]aggregator, len(q.columns))}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		for i, c := range q.columns {
+			if c.agg == "" {
+				continue
+			}
+			switch c.field {
+			case fieldRows:
+				g.aggs[i].add(0, false)
+			case fieldTime:
+				g.aggs[i].add(float64(r.time), false)
+			case fieldValue:
+				g.aggs[i].add(r.value, r.null)
+			}
+		}
+	}
+
+	// aggregates of no rows at all are a single row, as in SQL
+	if len(groups) == 0 && len(q.groupBy) == 0 {
+		groups = append(groups, &group{aggs: make([]aggregator, len(q.columns))})
+	}
+
+	out := make([][]interface{}, len(groups))
+	for i, g := range groups {
+		out[i] = make([]interface{}, len(q.columns))
+		for j, c := range q.columns {
+			if c.agg == "" {
+				out[i][j] = g.key.get(c.field)
+				continue
+			}
+			out[i][j] = g.aggs[j].result(c.agg, c.field == fieldTime)
+		}
+	}
+
+	return out
+}
+
+// aggregator aggregates the values of a column, leaving out NULLs.
+type aggregator struct {
+	count    int
+	sum      float64
+	min, max float64
+}
+
+func (a *aggregator) add(v float64, null bool) {
+	if null {
+		return
+	}
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.count++
+	a.sum += v
+}
+
+func (a *aggregator) result(agg string, isTime bool) interface{} {
+	if agg == "count" {
+		return int64(a.count)
+	}
+	if a.count == 0 {
+		return nil
+	}
+
+	var v float64
+	switch agg {
+	case "sum":
+		v = a.sum
+	case "avg":
+		v = a.sum / float64(a.count)
+	case "min":
+		v = a.min
+	case "max":
+		v = a.max
+	}
+	if isTime && agg != "avg" {
+		return int64(v)
+	}
+
+	return number(v, false)
+}
+
+// sort orders rows as the query asks, keeping their order otherwise.
+func (q *Query) sort(rows [][]interface{}) {
+	if len(q.orders) == 0 {
+		return
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range q.orders {
+			c := compareCells(rows[i][o.column], rows[j][o.column])
+			if c == 0 {
+				continue
+			}
+			if o.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// compareCells compares cells of the same column. NULLs come first.
+func compareCells(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if sa, ok := a.(string); ok {
+		return strings.Compare(sa, b.(string))
+	}
+
+	fa, fb := toFloat(a), toFloat(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
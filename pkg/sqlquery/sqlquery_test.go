@@ -0,0 +1,119 @@
+package sqlquery
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+var testSeries = []Series{
+	{Name: "a", Start: 100, Step: 10, Values: []float64{1, 2, math.NaN(), 4}, Absent: []bool{false, false, true, false}},
+	{Name: "b", Start: 100, Step: 10, Values: []float64{5, 1, 3, 0}},
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{
+			query: "SELECT * FROM series WHERE target = 'x.*' AND name = 'a'",
+			want:  [][]interface{}{{"a", int64(100), 1.0}, {"a", int64(110), 2.0}, {"a", int64(120), nil}, {"a", int64(130), 4.0}},
+		},
+		{
+			query: "select time, value from series where target = 'x.*' and name like 'b%' and value >= 1 order by value desc limit 2",
+			want:  [][]interface{}{{int64(100), 5.0}, {int64(120), 3.0}},
+		},
+		{
+			query: "SELECT name, max(value) AS peak, count(value), count(*) FROM series WHERE target = 'x.*' GROUP BY name ORDER BY peak",
+			want:  [][]interface{}{{"a", 4.0, int64(3), int64(4)}, {"b", 5.0, int64(4), int64(4)}},
+		},
+		{
+			query: "SELECT time, sum(value) FROM series WHERE target = 'x.*' AND time > 100 AND time <= 130 GROUP BY time",
+			want:  [][]interface{}{{int64(110), 3.0}, {int64(120), 3.0}, {int64(130), 4.0}},
+		},
+		{
+			query: "SELECT avg(value), min(time) FROM series WHERE target = 'x.*' AND value IS NOT NULL AND name != 'b'",
+			want:  [][]interface{}{{7.0 / 3, int64(100)}},
+		},
+		{
+			query: "SELECT name, time FROM series WHERE target = 'x.*' AND value IS NULL;",
+			want:  [][]interface{}{{"a", int64(120)}},
+		},
+		{
+			query: "SELECT max(value) FROM series WHERE target = 'x.*' AND value > 10",
+			want:  [][]interface{}{{nil}},
+		},
+	}
+
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if q.Target != "x.*" {
+			t.Errorf("%q: expected the target x.*, got %q", tt.query, q.Target)
+		}
+
+		res, err := q.Run(testSeries, 100, 130, 0)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(res.Rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, res.Rows)
+		}
+	}
+}
+
+func TestParseTimeBounds(t *testing.T) {
+	q, err := Parse("SELECT * FROM series WHERE time >= '-1h' AND target = 'sumSeries(x.*)' AND time < 1600000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Target != "sumSeries(x.*)" || q.From != "-1h" || q.Until != "1600000000" {
+		t.Errorf("Expected the target and bounds, got %q from %q until %q", q.Target, q.From, q.Until)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		"SELECT * FROM series",
+		"SELECT * FROM series WHERE name = 'a'",
+		"SELECT * FROM metrics WHERE target = 'x'",
+		"SELECT * FROM series WHERE target = 'x' OR name = 'a'",
+		"SELECT name, value FROM series WHERE target = 'x' GROUP BY name",
+		"SELECT median(value) FROM series WHERE target = 'x'",
+		"SELECT * FROM series WHERE target = 'x' AND time = 5",
+		"SELECT * FROM series WHERE target = 'x' AND time > 5 AND time > 6",
+		"SELECT * FROM series WHERE target = 'x' AND value > 'a'",
+		"SELECT * FROM series WHERE target = 'x' ORDER BY 4",
+		"SELECT name FROM series WHERE target = 'x' ORDER BY value",
+		"SELECT * FROM series WHERE target = 'x' LIMIT -1",
+		"SELECT * FROM series WHERE target = 'x' DROP",
+		"SELECT * FROM series WHERE target = 'x",
+	} {
+		if _, err := Parse(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestRunTooManyRows(t *testing.T) {
+	q, err := Parse("SELECT * FROM series WHERE target = 'x.*'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Run(testSeries, 100, 130, 5); err != ErrTooManyRows(5) {
+		t.Errorf("Expected too many rows, got %v", err)
+	}
+
+	q, err = Parse("SELECT * FROM series WHERE target = 'x.*' LIMIT 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := q.Run(testSeries, 100, 130, 5); err != nil || len(res.Rows) != 5 {
+		t.Errorf("Expected a limit to keep the rows under the maximum, got %v", err)
+	}
+}
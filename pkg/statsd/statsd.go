@@ -0,0 +1,187 @@
+/*
+Package statsd sends expvar metrics to a StatsD or DogStatsD agent, alongside
+or instead of graphite.
+
+Example use:
+
+	c, err := New(Config{Address: "127.0.0.1:8125", Prefix: "carbon.api", Interval: time.Minute}, "")
+	c.Register("requests", requests)
+	// sends carbon.api.requests:<requests since the last flush>|c every minute
+*/
+package statsd
+
+import (
+	"bytes"
+	"expvar"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacket keeps packets within the MTU of most networks.
+const maxPacket = 1432
+
+// Config configures a client. An empty Address disables it.
+type Config struct {
+	Address string `yaml:"address"`
+	// Prefix is prepended to the names of all metrics.
+	Prefix string `yaml:"prefix"`
+	// Interval is how often metrics are sent.
+	Interval time.Duration `yaml:"interval"`
+	// SampleRate is the share, between 0 and 1, of counter updates sent.
+	// The agent scales them back up. 0 sends all of them.
+	SampleRate float64 `yaml:"sampleRate"`
+	// DogStatsD sends Tags, "key:value", with every metric.
+	DogStatsD bool     `yaml:"dogstatsd"`
+	Tags      []string `yaml:"tags"`
+}
+
+// Registry is what metrics are registered with, e.g. a graphite client.
+type Registry interface {
+	Register(name string, v expvar.Var)
+}
+
+// Tee registers metrics with all its registries.
+type Tee []Registry
+
+// Register registers v as name with all registries.
+func (t Tee) Register(name string, v expvar.Var) {
+	for _, r := range t {
+		r.Register(name, v)
+	}
+}
+
+// Client sends the metrics registered with it every interval. Integers,
+// which count events, are sent as counters of the events since the last
+// flush; all other numbers as gauges.
+type Client struct {
+	conn   net.Conn
+	prefix string
+	trim   string
+	rate   float64
+	tags   string
+
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric struct {
+	name string
+	v    expvar.Var
+	last int64
+}
+
+// New returns a client sending to c.Address every c.Interval. Names
+// registered with trim in front are sent without it, e.g. the hostname that
+// graphite names carry and DogStatsD has as a tag.
+func New(c Config, trim string) (*Client, error) {
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		conn: conn,
+		trim: trim,
+		rate: c.SampleRate,
+	}
+	if c.Prefix != "" {
+		client.prefix = strings.TrimSuffix(c.Prefix, ".") + "."
+	}
+	if c.DogStatsD && len(c.Tags) > 0 {
+		client.tags = "|#" + strings.Join(c.Tags, ",")
+	}
+
+	if c.Interval > 0 {
+		go func() {
+			for range time.Tick(c.Interval) {
+				client.Flush()
+			}
+		}()
+	}
+
+	return client, nil
+}
+
+// Register sends v as name from the next flush on.
+func (c *Client) Register(name string, v expvar.Var) {
+	m := metric{name: c.prefix + sanitize(strings.TrimPrefix(name, c.trim)), v: v}
+	if i, ok := v.(*expvar.Int); ok {
+		m.last = i.Value()
+	}
+
+	c.mu.Lock()
+	c.metrics = append(c.metrics, m)
+	c.mu.Unlock()
+}
+
+// sanitize replaces what the StatsD protocol uses as separators.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Flush sends the metrics now.
+func (c *Client) Flush() {
+	c.mu.Lock()
+	var lines []string
+	for i := range c.metrics {
+		if line, ok := c.line(&c.metrics[i]); ok {
+			lines = append(lines, line)
+		}
+	}
+	c.mu.Unlock()
+
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacket {
+			c.conn.Write(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		c.conn.Write(packet.Bytes())
+	}
+}
+
+// line returns the line of m, and whether there is one to send. Call with
+// c.mu held.
+func (c *Client) line(m *metric) (string, bool) {
+	if i, ok := m.v.(*expvar.Int); ok {
+		v := i.Value()
+		delta := v - m.last
+		m.last = v
+		if delta == 0 {
+			return "", false
+		}
+
+		// Sampled updates are dropped here, and scaled back up by the
+		// agent from the rate sent with the others.
+		if c.rate > 0 && c.rate < 1 {
+			if rand.Float64() >= c.rate {
+				return "", false
+			}
+			return m.name + ":" + strconv.FormatInt(delta, 10) + "|c|@" + strconv.FormatFloat(c.rate, 'f', -1, 64) + c.tags, true
+		}
+		return m.name + ":" + strconv.FormatInt(delta, 10) + "|c" + c.tags, true
+	}
+
+	s := m.v.String()
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return "", false
+	}
+
+	return m.name + ":" + s + "|g" + c.tags, true
+}
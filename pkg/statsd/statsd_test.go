@@ -0,0 +1,97 @@
+package statsd
+
+import (
+	"expvar"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UDPConn) []string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxPacket)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestClient(t *testing.T) {
+	conn := listen(t)
+	defer conn.Close()
+
+	c, err := New(Config{
+		Address:   conn.LocalAddr().String(),
+		Prefix:    "carbon.api",
+		DogStatsD: true,
+		Tags:      []string{"env:test"},
+	}, "carbon.api.host1.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := new(expvar.Int)
+	requests.Add(5)
+	size := new(expvar.Float)
+	size.Set(1.5)
+	c.Register("carbon.api.host1.requests", requests)
+	c.Register("carbon.api.host1.cache_size", size)
+	c.Register("carbon.api.host1.buckets", expvar.Func(func() interface{} { return []int{1} }))
+
+	requests.Add(3)
+	c.Flush()
+	exp := []string{"carbon.api.cache_size:1.5|g|#env:test", "carbon.api.requests:3|c|#env:test"}
+	if got := receive(t, conn); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+
+	// Counters without updates aren't sent.
+	c.Flush()
+	exp = []string{"carbon.api.cache_size:1.5|g|#env:test"}
+	if got := receive(t, conn); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+}
+
+func TestClientSampleRate(t *testing.T) {
+	conn := listen(t)
+	defer conn.Close()
+
+	c, err := New(Config{Address: conn.LocalAddr().String(), SampleRate: 0.999999}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := new(expvar.Int)
+	c.Register("requests", requests)
+
+	requests.Add(2)
+	c.Flush()
+	if got := receive(t, conn); len(got) != 1 || got[0] != "requests:2|c|@0.999999" {
+		t.Errorf("Expected a sampled counter, got %q", got)
+	}
+}
+
+func TestTee(t *testing.T) {
+	var a, b names
+	Tee{&a, &b}.Register("x", new(expvar.Int))
+	if len(a) != 1 || len(b) != 1 {
+		t.Error("Expected the metric in all registries")
+	}
+}
+
+type names []string
+
+func (n *names) Register(name string, _ expvar.Var) { *n = append(*n, name) }
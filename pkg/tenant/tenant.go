@@ -0,0 +1,187 @@
+/*
+Package tenant lets one carbonapi serve several tenants, each seeing only its
+own metric namespace. Requests name their tenant in a header set by a trusted
+proxy, or by their verified user, and metric paths are moved into and out of
+the tenant's namespace on their way to and from the stores.
+
+Example use:
+
+	t, err := New(Config{Header: "X-Tenant", Tenants: map[string]Tenant{"team-a": {Namespace: "team_a"}}})
+	id, err := t.Identify(r, user)
+	path := t.Namespace(id).Prefix("servers.*.cpu") // "team_a.servers.*.cpu"
+*/
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoTenant is returned for requests that don't name a tenant when one
+	// is required.
+	ErrNoTenant = errors.New("request names no tenant")
+	// ErrUnknownTenant is returned for requests that name a tenant that
+	// isn't configured.
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+// Config configures tenancy. No tenants disables it.
+type Config struct {
+	// Header is the request header that names the tenant. It must be set by
+	// an authenticating proxy in front of carbonapi that drops it from the
+	// requests it receives.
+	Header string `yaml:"header"`
+	// FromUser takes the tenant from the verified user of the request when
+	// the header isn't set.
+	FromUser bool `yaml:"fromUser"`
+	// Required rejects requests that name no tenant. Otherwise they see all
+	// metrics.
+	Required bool              `yaml:"required"`
+	Tenants  map[string]Tenant `yaml:"tenants"`
+}
+
+// Tenant configures one tenant.
+type Tenant struct {
+	// Namespace is the path prefix of the tenant's metrics, e.g. "team_a".
+	Namespace string `yaml:"namespace"`
+	// MaxConcurrentRequests limits how many requests of the tenant are
+	// served at the same time. Zero is no limit.
+	MaxConcurrentRequests int `yaml:"maxConcurrentRequests"`
+}
+
+// Tenancy identifies the tenants of requests and enforces their quotas.
+type Tenancy struct {
+	cfg   Config
+	slots map[string]chan struct{}
+}
+
+// New checks cfg and sets up its tenants. It returns a nil Tenancy if no
+// tenants are configured.
+func New(cfg Config) (*Tenancy, error) {
+	if len(cfg.Tenants) == 0 {
+		return nil, nil
+	}
+	if cfg.Header == "" && !cfg.FromUser {
+		return nil, errors.New("tenants need a header or fromUser to be identified by")
+	}
+
+	t := &Tenancy{
+		cfg:   cfg,
+		slots: make(map[string]chan struct{}),
+	}
+	for id, tc := range cfg.Tenants {
+		ns := tc.Namespace
+		if ns == "" || strings.HasPrefix(ns, ".") || strings.HasSuffix(ns, ".") || strings.ContainsAny(ns, "*?[]{}") {
+			return nil, fmt.Errorf("tenant %q: namespace %q must be a plain metric path", id, ns)
+		}
+		if tc.MaxConcurrentRequests < 0 {
+			return nil, fmt.Errorf("tenant %q: maxConcurrentRequests can't be negative", id)
+		}
+		if tc.MaxConcurrentRequests > 0 {
+			t.slots[id] = make(chan struct{}, tc.MaxConcurrentRequests)
+		}
+	}
+
+	return t, nil
+}
+
+// Identify returns the tenant r names, or "" if it names none and none is
+// required. user is the verified user of r, or "" if it is anonymous.
+func (t *Tenancy) Identify(r *http.Request, user string) (string, error) {
+	var id string
+	if t.cfg.Header != "" {
+		id = r.Header.Get(t.cfg.Header)
+	}
+	if id == "" && t.cfg.FromUser {
+		id = user
+	}
+
+	if id == "" {
+		if t.cfg.Required {
+			return "", ErrNoTenant
+		}
+		return "", nil
+	}
+
+	if _, ok := t.cfg.Tenants[id]; !ok {
+		return "", ErrUnknownTenant
+	}
+
+	return id, nil
+}
+
+// Acquire takes one of the tenant's request slots, which release gives back.
+// It returns false if the tenant has no slot left.
+func (t *Tenancy) Acquire(id string) (release func(), ok bool) {
+	slots, limited := t.slots[id]
+	if !limited {
+		return func() {}, true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// Namespace returns the namespace of the tenant, or "" for none.
+func (t *Tenancy) Namespace(id string) Namespace {
+	if t == nil || id == "" {
+		return ""
+	}
+
+	return Namespace(t.cfg.Tenants[id].Namespace)
+}
+
+// Namespace is the path prefix of a tenant's metrics. The empty namespace
+// is all metrics.
+type Namespace string
+
+// Prefix moves path into the namespace.
+func (ns Namespace) Prefix(path string) string {
+	if ns == "" {
+		return path
+	}
+
+	return string(ns) + "." + path
+}
+
+// Strip moves path out of the namespace. It returns false if path isn't in
+// it.
+func (ns Namespace) Strip(path string) (string, bool) {
+	if ns == "" {
+		return path, true
+	}
+
+	prefix := string(ns) + "."
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+
+	return path[len(prefix):], true
+}
+
+type key int
+
+const tenantKey key = 0
+
+// NewContext returns a context that carries the tenant.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// FromContext returns the tenant ctx carries, or "".
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantKey).(string); ok {
+		return id
+	}
+
+	return ""
+}
@@ -0,0 +1,127 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func testTenancy(t *testing.T) *Tenancy {
+	tn, err := New(Config{
+		Header:   "X-Tenant",
+		FromUser: true,
+		Required: true,
+		Tenants: map[string]Tenant{
+			"team-a": {Namespace: "teams.a"},
+			"team-b": {Namespace: "teams.b", MaxConcurrentRequests: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tn
+}
+
+func TestNew(t *testing.T) {
+	if tn, err := New(Config{}); tn != nil || err != nil {
+		t.Errorf("New without tenants = %v, %v, want nil, nil", tn, err)
+	}
+
+	bad := []Config{
+		{Tenants: map[string]Tenant{"a": {Namespace: "a"}}},
+		{Header: "X-Tenant", Tenants: map[string]Tenant{"a": {}}},
+		{Header: "X-Tenant", Tenants: map[string]Tenant{"a": {Namespace: "a.*"}}},
+		{Header: "X-Tenant", Tenants: map[string]Tenant{"a": {Namespace: "a."}}},
+		{Header: "X-Tenant", Tenants: map[string]Tenant{"a": {Namespace: "a", MaxConcurrentRequests: -1}}},
+	}
+	for _, cfg := range bad {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v): expected an error", cfg)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	tn := testTenancy(t)
+
+	tests := []struct {
+		header   string
+		user     string
+		username string
+		want     string
+		err      error
+	}{
+		{header: "team-a", want: "team-a"},
+		{user: "team-b", want: "team-b"},
+		{header: "team-a", user: "team-b", want: "team-a"},
+		{username: "team-b", err: ErrNoTenant},
+		{header: "team-c", err: ErrUnknownTenant},
+		{err: ErrNoTenant},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/render", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Tenant", tt.header)
+		}
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, "secret")
+		}
+
+		got, err := tn.Identify(r, tt.user)
+		if got != tt.want || err != tt.err {
+			t.Errorf("Identify(header %q, user %q, username %q) = %q, %v, want %q, %v", tt.header, tt.user, tt.username, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	tn := testTenancy(t)
+
+	release, ok := tn.Acquire("team-b")
+	if !ok {
+		t.Fatal("expected a slot for team-b")
+	}
+	if _, ok := tn.Acquire("team-b"); ok {
+		t.Error("expected team-b to be out of slots")
+	}
+	if _, ok := tn.Acquire("team-a"); !ok {
+		t.Error("expected team-a to be unlimited")
+	}
+
+	release()
+	if _, ok := tn.Acquire("team-b"); !ok {
+		t.Error("expected a slot for team-b after the release")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	ns := testTenancy(t).Namespace("team-a")
+
+	if got := ns.Prefix("servers.*.cpu"); got != "teams.a.servers.*.cpu" {
+		t.Errorf("Prefix = %q", got)
+	}
+	if got, ok := ns.Strip("teams.a.servers.web.cpu"); !ok || got != "servers.web.cpu" {
+		t.Errorf("Strip = %q, %v", got, ok)
+	}
+	if _, ok := ns.Strip("teams.ab.servers.web.cpu"); ok {
+		t.Error("Strip accepted a path of another namespace")
+	}
+
+	if got := Namespace("").Prefix("a.b"); got != "a.b" {
+		t.Errorf("empty Prefix = %q", got)
+	}
+	if got := (*Tenancy)(nil).Namespace("team-a"); got != "" {
+		t.Errorf("nil Tenancy has namespace %q", got)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != "" {
+		t.Errorf("FromContext of an empty context = %q", got)
+	}
+	if got := FromContext(NewContext(context.Background(), "team-a")); got != "team-a" {
+		t.Errorf("FromContext = %q, want team-a", got)
+	}
+}
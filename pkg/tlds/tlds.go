@@ -0,0 +1,259 @@
+/*
+Package tlds keeps the top-level domains of backends up to date with deltas,
+rather than with the full list of them every probe.
+
+A backend keeps its domains in an Index, whose generation moves on each time
+they change, and answers probes with the Delta since the generation the
+prober last saw. The prober applies it to its Servers, which map domains to
+the backends that have them.
+
+Example use:
+
+	// On the backend.
+	idx := NewIndex(16)
+	idx.Update([]string{"carbon", "servers"})
+	delta := idx.Since(since)
+
+	// On the prober.
+	s := NewServers()
+	s.Apply("http://backend:8080", delta)
+	since = s.Generation("http://backend:8080")
+*/
+package tlds
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delta is how the domains of a backend changed since a generation. Full
+// deltas, sent to probers whose generation is too old or unknown, have all
+// the domains in Added.
+type Delta struct {
+	Generation uint64   `json:"generation"`
+	Full       bool     `json:"full"`
+	Added      []string `json:"added"`
+	Removed    []string `json:"removed"`
+}
+
+// change is what an update of an Index changed, which led to generation.
+type change struct {
+	generation uint64
+	added      []string
+	removed    []string
+}
+
+// Index is the domains of a backend, and the changes of the last few of
+// their generations.
+type Index struct {
+	mu         sync.Mutex
+	generation uint64
+	tlds       map[string]struct{}
+	history    []change
+	maxHistory int
+}
+
+// NewIndex returns an empty index that keeps the changes of maxHistory
+// generations. Its generations count from the time it is made, so that
+// probers that saw an earlier index, of a backend since restarted, are
+// sent full deltas.
+func NewIndex(maxHistory int) *Index {
+	return &Index{
+		generation: uint64(time.Now().UnixNano()),
+		tlds:       make(map[string]struct{}),
+		maxHistory: maxHistory,
+	}
+}
+
+// Update replaces the domains with tlds. The generation moves on if they
+// changed, which Update tells.
+func (i *Index) Update(tlds []string) bool {
+	next := make(map[string]struct{}, len(tlds))
+	for _, tld := range tlds {
+		next[tld] = struct{}{}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var c change
+	for tld := range next {
+		if _, ok := i.tlds[tld]; !ok {
+			c.added = append(c.added, tld)
+		}
+	}
+	for tld := range i.tlds {
+		if _, ok := next[tld]; !ok {
+			c.removed = append(c.removed, tld)
+		}
+	}
+	if len(c.added) == 0 && len(c.removed) == 0 {
+		return false
+	}
+
+	i.generation++
+	c.generation = i.generation
+	i.tlds = next
+	i.history = append(i.history, c)
+	if len(i.history) > i.maxHistory {
+		i.history = i.history[len(i.history)-i.maxHistory:]
+	}
+
+	return true
+}
+
+// Generation returns the current generation.
+func (i *Index) Generation() uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.generation
+}
+
+// Since returns the delta from generation to the current one. It is full
+// if the changes since generation are no longer kept, or if generation
+// isn't one of the index.
+func (i *Index) Since(generation uint64) Delta {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if generation == i.generation {
+		return Delta{Generation: generation}
+	}
+
+	if generation > i.generation || len(i.history) == 0 || generation+1 < i.history[0].generation {
+		d := Delta{Generation: i.generation, Full: true, Added: make([]string, 0, len(i.tlds))}
+		for tld := range i.tlds {
+			d.Added = append(d.Added, tld)
+		}
+		sort.Strings(d.Added)
+		return d
+	}
+
+	// Domains added then removed, or the other way round, only end up in
+	// the last of the two.
+	present := make(map[string]bool)
+	for _, c := range i.history {
+		if c.generation <= generation {
+			continue
+		}
+		for _, tld := range c.added {
+			present[tld] = true
+		}
+		for _, tld := range c.removed {
+			present[tld] = false
+		}
+	}
+
+	d := Delta{Generation: i.generation}
+	for tld, ok := range present {
+		if ok {
+			d.Added = append(d.Added, tld)
+		} else {
+			d.Removed = append(d.Removed, tld)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+
+	return d
+}
+
+// Servers maps domains to the servers that have them, from the deltas of
+// each server. It isn't safe for concurrent use.
+type Servers struct {
+	generations map[string]uint64
+	byServer    map[string]map[string]struct{}
+	byTLD       map[string][]string
+}
+
+// NewServers returns servers that have no domains.
+func NewServers() *Servers {
+	return &Servers{
+		generations: make(map[string]uint64),
+		byServer:    make(map[string]map[string]struct{}),
+		byTLD:       make(map[string][]string),
+	}
+}
+
+// Generation returns the generation of the last delta of server applied, or
+// 0 if there is none.
+func (s *Servers) Generation(server string) uint64 {
+	return s.generations[server]
+}
+
+// Apply applies a delta of server, and returns the domains whose servers
+// changed. The lists of servers of those domains are replaced, not
+// modified, so that the lists returned before can be kept.
+func (s *Servers) Apply(server string, d Delta) []string {
+	own, ok := s.byServer[server]
+	if !ok {
+		own = make(map[string]struct{})
+		s.byServer[server] = own
+	}
+	s.generations[server] = d.Generation
+
+	added, removed := d.Added, d.Removed
+	if d.Full {
+		removed = nil
+		full := make(map[string]struct{}, len(d.Added))
+		for _, tld := range d.Added {
+			full[tld] = struct{}{}
+		}
+		for tld := range own {
+			if _, ok := full[tld]; !ok {
+				removed = append(removed, tld)
+			}
+		}
+	}
+
+	var changed []string
+	for _, tld := range added {
+		if _, ok := own[tld]; ok {
+			continue
+		}
+		own[tld] = struct{}{}
+		servers := make([]string, len(s.byTLD[tld]), len(s.byTLD[tld])+1)
+		copy(servers, s.byTLD[tld])
+		s.byTLD[tld] = append(servers, server)
+		changed = append(changed, tld)
+	}
+	for _, tld := range removed {
+		if _, ok := own[tld]; !ok {
+			continue
+		}
+		delete(own, tld)
+		servers := make([]string, 0, len(s.byTLD[tld]))
+		for _, other := range s.byTLD[tld] {
+			if other != server {
+				servers = append(servers, other)
+			}
+		}
+		if len(servers) == 0 {
+			delete(s.byTLD, tld)
+		} else {
+			s.byTLD[tld] = servers
+		}
+		changed = append(changed, tld)
+	}
+
+	return changed
+}
+
+// Get returns the servers that have tld.
+func (s *Servers) Get(tld string) []string {
+	return s.byTLD[tld]
+}
+
+// Each calls f with each domain and its servers.
+func (s *Servers) Each(f func(tld string, servers []string)) {
+	for tld, servers := range s.byTLD {
+		f(tld, servers)
+	}
+}
+
+// Len returns the number of domains.
+func (s *Servers) Len() int {
+	return len(s.byTLD)
+}
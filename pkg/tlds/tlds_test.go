@@ -0,0 +1,107 @@
+package tlds
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIndexSince(t *testing.T) {
+	idx := NewIndex(2)
+	start := idx.Generation()
+
+	if idx.Update(nil) {
+		t.Error("Expected no change from an empty update")
+	}
+	if !idx.Update([]string{"a", "b"}) {
+		t.Error("Expected a change")
+	}
+	g1 := idx.Generation()
+	if idx.Update([]string{"b", "a"}) || idx.Generation() != g1 {
+		t.Error("Expected the same domains not to move the generation on")
+	}
+	idx.Update([]string{"b", "c"})
+	g2 := idx.Generation()
+	idx.Update([]string{"c", "a"})
+
+	tests := []struct {
+		name  string
+		since uint64
+		want  Delta
+	}{
+		{
+			name:  "current",
+			since: idx.Generation(),
+			want:  Delta{Generation: idx.Generation()},
+		},
+		{
+			name:  "one behind",
+			since: g2,
+			want:  Delta{Generation: idx.Generation(), Added: []string{"a"}, Removed: []string{"b"}},
+		},
+		{
+			name:  "two behind",
+			since: g1,
+			want:  Delta{Generation: idx.Generation(), Added: []string{"a", "c"}, Removed: []string{"b"}},
+		},
+		{
+			name:  "forgotten",
+			since: start,
+			want:  Delta{Generation: idx.Generation(), Full: true, Added: []string{"a", "c"}},
+		},
+		{
+			name:  "unknown",
+			since: 0,
+			want:  Delta{Generation: idx.Generation(), Full: true, Added: []string{"a", "c"}},
+		},
+		{
+			name:  "from the future",
+			since: idx.Generation() + 1,
+			want:  Delta{Generation: idx.Generation(), Full: true, Added: []string{"a", "c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idx.Since(tt.since); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestServersApply(t *testing.T) {
+	s := NewServers()
+
+	changed := s.Apply("x", Delta{Generation: 1, Full: true, Added: []string{"a", "b"}})
+	s.Apply("y", Delta{Generation: 5, Full: true, Added: []string{"b"}})
+	if len(changed) != 2 || s.Generation("x") != 1 || s.Generation("y") != 5 {
+		t.Errorf("Expected 2 changed domains and the generations to be kept, got %v", changed)
+	}
+
+	kept := s.Get("b")
+	if !reflect.DeepEqual(kept, []string{"x", "y"}) {
+		t.Errorf("Expected b on x and y, got %v", kept)
+	}
+
+	changed = s.Apply("x", Delta{Generation: 2, Added: []string{"c"}, Removed: []string{"b"}})
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"b", "c"}) {
+		t.Errorf("Expected b and c to change, got %v", changed)
+	}
+	if got := s.Get("b"); !reflect.DeepEqual(got, []string{"y"}) {
+		t.Errorf("Expected b on y, got %v", got)
+	}
+	if !reflect.DeepEqual(kept, []string{"x", "y"}) {
+		t.Errorf("Expected the lists returned before to be kept, got %v", kept)
+	}
+
+	s.Apply("y", Delta{Generation: 6, Full: true, Added: []string{"c"}})
+	if s.Get("b") != nil || !reflect.DeepEqual(s.Get("c"), []string{"x", "y"}) || s.Len() != 2 {
+		t.Errorf("Expected full deltas to remove the domains they don't have, got b %v c %v", s.Get("b"), s.Get("c"))
+	}
+
+	if changed := s.Apply("y", Delta{Generation: 6}); len(changed) != 0 {
+		t.Errorf("Expected empty deltas to change nothing, got %v", changed)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Package topk finds the heaviest of many keys, e.g. the queries that cost the
+most backend time, in bounded memory, with the space-saving algorithm of
+Metwally, Agrawal and El Abbadi.
+
+Example use:
+
+	s := NewRolling(10, 10*time.Minute)
+	s.Add("sumSeries(servers.*.cpu)", 0.25)
+	for _, item := range s.Top() {
+		fmt.Println(item.Key, item.Count)
+	}
+*/
+package topk
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// counters is how many keys a Stream keeps per key it reports, which makes
+// the counts of the reported ones close to exact for skewed loads.
+const counters = 10
+
+// Item is a key and its estimated weight. The key weighed at least Count
+// minus Error.
+type Item struct {
+	Key   string  `json:"key"`
+	Count float64 `json:"count"`
+	Error float64 `json:"error"`
+}
+
+// Stream keeps the heaviest keys added to it. It is not safe for concurrent
+// use.
+type Stream struct {
+	k     int
+	items map[string]*Item
+}
+
+// New returns a stream that reports its k heaviest keys.
+func New(k int) *Stream {
+	return &Stream{k: k, items: make(map[string]*Item, k*counters)}
+}
+
+// Add adds weight to key. Once the stream is full, a new key takes the place
+// of the lightest one, and its weight.
+func (s *Stream) Add(key string, weight float64) {
+	if it, ok := s.items[key]; ok {
+		it.Count += weight
+		return
+	}
+
+	if len(s.items) < s.k*counters {
+		s.items[key] = &Item{Key: key, Count: weight}
+		return
+	}
+
+	var min *Item
+	for _, it := range s.items {
+		if min == nil || it.Count < min.Count {
+			min = it
+		}
+	}
+	delete(s.items, min.Key)
+	s.items[key] = &Item{Key: key, Count: min.Count + weight, Error: min.Count}
+}
+
+// Top returns the k heaviest keys, heaviest first.
+func (s *Stream) Top() []Item {
+	return top(s.items, s.k)
+}
+
+func top(items map[string]*Item, k int) []Item {
+	list := make([]Item, 0, len(items))
+	for _, it := range items {
+		list = append(list, *it)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Key < list[j].Key
+	})
+	if len(list) > k {
+		list = list[:k]
+	}
+
+	return list
+}
+
+// Rolling keeps the heaviest keys of recent windows. It is safe for
+// concurrent use.
+type Rolling struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	started  time.Time
+	current  *Stream
+	previous *Stream
+}
+
+// NewRolling returns a stream that reports its k heaviest keys over the
+// last one to two windows.
+func NewRolling(k int, window time.Duration) *Rolling {
+	r := &Rolling{
+		window:   window,
+		now:      time.Now,
+		current:  New(k),
+		previous: New(k),
+	}
+	r.started = r.now()
+
+	return r
+}
+
+// rotate starts a new window once the current one is over. Call with r.mu
+// held.
+func (r *Rolling) rotate() {
+	now := r.now()
+	if now.Sub(r.started) < r.window {
+		return
+	}
+
+	if now.Sub(r.started) < 2*r.window {
+		r.previous = r.current
+	} else {
+		r.previous = New(r.current.k)
+	}
+	r.current = New(r.current.k)
+	r.started = now
+}
+
+// Add adds weight to key.
+func (r *Rolling) Add(key string, weight float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotate()
+	r.current.Add(key, weight)
+}
+
+// Top returns the k heaviest keys of the current and the previous window,
+// heaviest first.
+func (r *Rolling) Top() []Item {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotate()
+	merged := make(map[string]*Item, len(r.current.items)+len(r.previous.items))
+	for _, s := range []*Stream{r.previous, r.current} {
+		for key, it := range s.items {
+			if m, ok := merged[key]; ok {
+				m.Count += it.Count
+				m.Error += it.Error
+				continue
+			}
+			c := *it
+			merged[key] = &c
+		}
+	}
+
+	return top(merged, r.current.k)
+}
@@ -0,0 +1,53 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	s := New(2)
+	for i := 0; i < 1000; i++ {
+		s.Add(fmt.Sprintf("rare%d", i), 1)
+		if i%10 == 0 {
+			s.Add("heavy", 10)
+			s.Add("medium", 5)
+		}
+	}
+
+	got := s.Top()
+	if len(got) != 2 || got[0].Key != "heavy" || got[1].Key != "medium" {
+		t.Fatalf("Expected heavy and medium, got %v", got)
+	}
+	if got[0].Count-got[0].Error > 1000 || got[0].Count < 1000 {
+		t.Errorf("Expected heavy to weigh 1000 within its error, got %+v", got[0])
+	}
+	if len(s.items) != 2*counters {
+		t.Errorf("Expected %d counters, got %d", 2*counters, len(s.items))
+	}
+}
+
+func TestRolling(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewRolling(2, time.Minute)
+	r.now = func() time.Time { return now }
+	r.started = now
+
+	r.Add("old", 5)
+	now = now.Add(time.Minute)
+	r.Add("new", 3)
+	if got := r.Top(); len(got) != 2 || got[0].Key != "old" || got[1].Key != "new" {
+		t.Errorf("Expected the previous window to count, got %v", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got := r.Top(); len(got) != 1 || got[0].Key != "new" {
+		t.Errorf("Expected only the last window, got %v", got)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if got := r.Top(); len(got) != 0 {
+		t.Errorf("Expected nothing after idle windows, got %v", got)
+	}
+}
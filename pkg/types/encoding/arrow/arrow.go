@@ -0,0 +1,288 @@
+/*
+Package arrow encodes series in the Apache Arrow IPC streaming format,
+https://arrow.apache.org/docs/format/Columnar.html#ipc-streaming-format, as
+a table with a row per point:
+
+	name:  utf8, the name of the series
+	time:  timestamp[s, tz=UTC]
+	value: float64, null for absent points
+
+The rows are sent in record batches of at most BatchRows rows, so that
+readers can process large responses a batch at a time.
+
+The flatbuffers of the messages are built by hand, after Schema.fbs and
+Message.fbs of the Arrow format, of which the parts used are:
+
+	table Message { version: MetadataVersion; header: MessageHeader; bodyLength: long; }
+	union MessageHeader { Schema = 1, RecordBatch = 3 }
+	table Schema { endianness: Endianness; fields: [Field]; }
+	table Field { name: string; nullable: bool; type: Type; dictionary: DictionaryEncoding; children: [Field]; }
+	union Type { FloatingPoint = 3, Utf8 = 5, Timestamp = 10 }
+	table FloatingPoint { precision: Precision; }
+	table Timestamp { unit: TimeUnit; timezone: string; }
+	struct FieldNode { length: long; null_count: long; }
+	struct Buffer { offset: long; length: long; }
+	table RecordBatch { length: long; nodes: [FieldNode]; buffers: [Buffer]; }
+*/
+package arrow
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// BatchRows is the most rows a record batch has.
+const BatchRows = 1 << 16
+
+const (
+	metadataV5 = 4
+
+	headerSchema      = 1
+	headerRecordBatch = 3
+
+	typeFloatingPoint = 3
+	typeUtf8          = 5
+	typeTimestamp     = 10
+
+	precisionDouble = 2
+	unitSecond      = 0
+)
+
+// Writer writes the points of series as a stream of record batches.
+type Writer struct {
+	w   io.Writer
+	err error
+
+	wroteSchema bool
+
+	rows        int
+	nameOffsets []int32
+	names       []byte
+	times       []int64
+	values      []float64
+	validity    []byte
+	nulls       int
+}
+
+// NewWriter returns a writer to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:           w,
+		nameOffsets: []int32{0},
+	}
+}
+
+// WriteSeries adds the points of a series, writing out the batches it
+// fills.
+func (w *Writer) WriteSeries(name string, start, step int32, values []float64, absent []bool) error {
+	for i, v := range values {
+		w.names = append(w.names, name...)
+		w.nameOffsets = append(w.nameOffsets, int32(len(w.names)))
+		w.times = append(w.times, int64(start)+int64(i)*int64(step))
+
+		if w.rows%8 == 0 {
+			w.validity = append(w.validity, 0)
+		}
+		if i < len(absent) && absent[i] {
+			w.values = append(w.values, 0)
+			w.nulls++
+		} else {
+			w.values = append(w.values, v)
+			w.validity[w.rows/8] |= 1 << uint(w.rows%8)
+		}
+		w.rows++
+
+		if w.rows == BatchRows {
+			w.flush()
+		}
+	}
+
+	return w.err
+}
+
+// Close writes out the last batch and the end of the stream. It doesn't
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if w.rows > 0 {
+		w.flush()
+	}
+	if !w.wroteSchema {
+		w.writeSchema()
+	}
+	if w.err == nil {
+		_, w.err = w.w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	}
+
+	return w.err
+}
+
+func (w *Writer) flush() {
+	if !w.wroteSchema {
+		w.writeSchema()
+	}
+
+	var body body
+	var nodes [][2]int64
+
+	// name: validity, offsets, data
+	nodes = append(nodes, [2]int64{int64(w.rows), 0})
+	body.add(nil)
+	offsets := body.start()
+	for _, o := range w.nameOffsets {
+		body.buf = appendUint32(body.buf, uint32(o))
+	}
+	body.end(offsets)
+	body.add(w.names)
+
+	// time: validity, data
+	nodes = append(nodes, [2]int64{int64(w.rows), 0})
+	body.add(nil)
+	times := body.start()
+	for _, t := range w.times {
+		body.buf = appendUint64(body.buf, uint64(t))
+	}
+	body.end(times)
+
+	// value: validity, data
+	nodes = append(nodes, [2]int64{int64(w.rows), int64(w.nulls)})
+	if w.nulls > 0 {
+		body.add(w.validity)
+	} else {
+		body.add(nil)
+	}
+	values := body.start()
+	for _, v := range w.values {
+		body.buf = appendUint64(body.buf, math.Float64bits(v))
+	}
+	body.end(values)
+
+	b := newBuilder()
+	nodesVector := b.createPairVector(nodes)
+	buffersVector := b.createPairVector(body.buffers)
+	b.startTable(3)
+	b.addInt64(0, int64(w.rows))
+	b.addOffset(1, nodesVector)
+	b.addOffset(2, buffersVector)
+	batch := b.endTable()
+
+	w.writeMessage(message(b, headerRecordBatch, batch, len(body.buf)), body.buf)
+
+	w.rows = 0
+	w.nameOffsets = w.nameOffsets[:1]
+	w.names = w.names[:0]
+	w.times = w.times[:0]
+	w.values = w.values[:0]
+	w.validity = w.validity[:0]
+	w.nulls = 0
+}
+
+func (w *Writer) writeSchema() {
+	b := newBuilder()
+
+	utf8 := emptyTable(b)
+	fields := []int{field(b, "name", false, typeUtf8, utf8)}
+
+	timezone := b.createString("UTC")
+	b.startTable(2)
+	b.addInt16(0, unitSecond)
+	b.addOffset(1, timezone)
+	fields = append(fields, field(b, "time", false, typeTimestamp, b.endTable()))
+
+	b.startTable(1)
+	b.addInt16(0, precisionDouble)
+	fields = append(fields, field(b, "value", true, typeFloatingPoint, b.endTable()))
+
+	fieldsVector := b.createOffsetVector(fields)
+	b.startTable(4)
+	b.addOffset(1, fieldsVector)
+	schema := b.endTable()
+
+	w.writeMessage(message(b, headerSchema, schema, 0), nil)
+	w.wroteSchema = true
+}
+
+// writeMessage writes an encapsulated message: a continuation marker, the
+// length of the metadata, padded to 8 bytes, the metadata, and the body.
+func (w *Writer) writeMessage(metadata, body []byte) {
+	if w.err != nil {
+		return
+	}
+
+	padded := (len(metadata) + 7) &^ 7
+	buf := make([]byte, 0, 8+padded)
+	buf = appendUint32(buf, 0xffffffff)
+	buf = appendUint32(buf, uint32(padded))
+	buf = append(buf, metadata...)
+	buf = append(buf, make([]byte, padded-len(metadata))...)
+
+	if _, w.err = w.w.Write(buf); w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(body)
+}
+
+func message(b *builder, headerType uint8, header int, bodyLength int) []byte {
+	b.startTable(5)
+	b.addInt16(0, metadataV5)
+	b.addUint8(1, headerType)
+	b.addOffset(2, header)
+	b.addInt64(3, int64(bodyLength))
+
+	return b.finish(b.endTable())
+}
+
+func field(b *builder, name string, nullable bool, typeType uint8, typ int) int {
+	nameString := b.createString(name)
+	children := b.createOffsetVector(nil)
+
+	b.startTable(7)
+	b.addOffset(0, nameString)
+	b.addBool(1, nullable)
+	b.addUint8(2, typeType)
+	b.addOffset(3, typ)
+	b.addOffset(5, children)
+
+	return b.endTable()
+}
+
+func emptyTable(b *builder) int {
+	b.startTable(0)
+	return b.endTable()
+}
+
+// body is the buffers of a record batch, each padded to 8 bytes.
+type body struct {
+	buf     []byte
+	buffers [][2]int64
+}
+
+func (b *body) start() int {
+	return len(b.buf)
+}
+
+// end adds the buffer written since start.
+func (b *body) end(start int) {
+	b.buffers = append(b.buffers, [2]int64{int64(start), int64(len(b.buf) - start)})
+	for len(b.buf)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *body) add(p []byte) {
+	start := b.start()
+	b.buf = append(b.buf, p...)
+	b.end(start)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
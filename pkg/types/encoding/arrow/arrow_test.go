@@ -0,0 +1,220 @@
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+var le = binary.LittleEndian
+
+// table reads a flatbuffer table.
+type table struct {
+	buf []byte
+	pos int
+}
+
+func (t table) field(i int) int {
+	vtable := t.pos - int(int32(le.Uint32(t.buf[t.pos:])))
+	if o := 4 + 2*i; o < int(le.Uint16(t.buf[vtable:])) {
+		return int(le.Uint16(t.buf[vtable+o:]))
+	}
+	return 0
+}
+
+func (t table) int64(i int) int64 {
+	if o := t.field(i); o != 0 {
+		return int64(le.Uint64(t.buf[t.pos+o:]))
+	}
+	return 0
+}
+
+func (t table) int16(i int) int16 {
+	if o := t.field(i); o != 0 {
+		return int16(le.Uint16(t.buf[t.pos+o:]))
+	}
+	return 0
+}
+
+func (t table) uint8(i int) uint8 {
+	if o := t.field(i); o != 0 {
+		return t.buf[t.pos+o]
+	}
+	return 0
+}
+
+func (t table) indirect(i int) int {
+	p := t.pos + t.field(i)
+	return p + int(le.Uint32(t.buf[p:]))
+}
+
+func (t table) table(i int) table {
+	return table{t.buf, t.indirect(i)}
+}
+
+func (t table) string(i int) string {
+	p := t.indirect(i)
+	return string(t.buf[p+4 : p+4+int(le.Uint32(t.buf[p:]))])
+}
+
+// vector returns the position of the elements of a vector, and their number.
+func (t table) vector(i int) (int, int) {
+	p := t.indirect(i)
+	return p + 4, int(le.Uint32(t.buf[p:]))
+}
+
+type encapsulated struct {
+	header     table
+	headerType uint8
+	body       []byte
+}
+
+func readMessages(t *testing.T, b []byte) []encapsulated {
+	var messages []encapsulated
+	for {
+		if len(b) < 8 || le.Uint32(b) != 0xffffffff {
+			t.Fatalf("Expected a continuation marker, got %v", b)
+		}
+		n := int(le.Uint32(b[4:]))
+		if n == 0 {
+			if len(b) != 8 {
+				t.Errorf("Expected the stream to end, %d bytes are left", len(b)-8)
+			}
+			return messages
+		}
+		if (8+n)%8 != 0 {
+			t.Errorf("Expected the metadata to be padded to 8 bytes, got %d", n)
+		}
+
+		meta := b[8 : 8+n]
+		m := table{meta, int(le.Uint32(meta))}
+		if v := m.int16(0); v != metadataV5 {
+			t.Errorf("Expected version V5, got %d", v)
+		}
+		bodyLength := int(m.int64(3))
+		messages = append(messages, encapsulated{
+			header:     m.table(2),
+			headerType: m.uint8(1),
+			body:       b[8+n : 8+n+bodyLength],
+		})
+		b = b[8+n+bodyLength:]
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteSeries("a", 100, 10, []float64{1, 0, 3}, []bool{false, true, false}); err != nil {
+		t.Fatal(err)
+	}
+	long := make([]float64, BatchRows)
+	for i := range long {
+		long[i] = float64(i)
+	}
+	if err := w.WriteSeries("bb", 0, 1, long, make([]bool, len(long))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := readMessages(t, buf.Bytes())
+	if len(messages) != 3 {
+		t.Fatalf("Expected a schema and 2 batches, got %d messages", len(messages))
+	}
+
+	schema := messages[0]
+	if schema.headerType != headerSchema {
+		t.Fatalf("Expected a schema first, got %d", schema.headerType)
+	}
+	start, n := schema.header.vector(1)
+	want := []struct {
+		name     string
+		nullable bool
+		typ      uint8
+	}{
+		{"name", false, typeUtf8},
+		{"time", false, typeTimestamp},
+		{"value", true, typeFloatingPoint},
+	}
+	if n != len(want) {
+		t.Fatalf("Expected %d fields, got %d", len(want), n)
+	}
+	for i, f := range want {
+		p := start + 4*i
+		field := table{schema.header.buf, p + int(le.Uint32(schema.header.buf[p:]))}
+		if field.string(0) != f.name || (field.uint8(1) == 1) != f.nullable || field.uint8(2) != f.typ {
+			t.Errorf("Expected field %+v, got %s %d %d", f, field.string(0), field.uint8(1), field.uint8(2))
+		}
+	}
+
+	var names []string
+	var times []int64
+	var values []float64
+	var valid []bool
+	nulls := 0
+	for _, m := range messages[1:] {
+		if m.headerType != headerRecordBatch {
+			t.Fatalf("Expected a record batch, got %d", m.headerType)
+		}
+		rows := int(m.header.int64(0))
+		nodesStart, nodes := m.header.vector(1)
+		buffersStart, buffers := m.header.vector(2)
+		if nodes != 3 || buffers != 7 {
+			t.Fatalf("Expected 3 nodes and 7 buffers, got %d %d", nodes, buffers)
+		}
+		if nodesStart%8 != 0 || buffersStart%8 != 0 {
+			t.Errorf("Expected the structs to be aligned to 8 bytes")
+		}
+
+		buffer := func(i int) []byte {
+			p := buffersStart + 16*i
+			off, length := le.Uint64(m.header.buf[p:]), le.Uint64(m.header.buf[p+8:])
+			if off%8 != 0 {
+				t.Errorf("Expected buffer %d to be aligned to 8 bytes", i)
+			}
+			return m.body[off : off+length]
+		}
+
+		offsets, data := buffer(1), buffer(2)
+		for i := 0; i < rows; i++ {
+			names = append(names, string(data[le.Uint32(offsets[4*i:]):le.Uint32(offsets[4*i+4:])]))
+			times = append(times, int64(le.Uint64(buffer(4)[8*i:])))
+			values = append(values, math.Float64frombits(le.Uint64(buffer(6)[8*i:])))
+			validity := buffer(5)
+			valid = append(valid, len(validity) == 0 || validity[i/8]&(1<<uint(i%8)) != 0)
+		}
+
+		nullCount := int(le.Uint64(m.header.buf[nodesStart+2*16+8:]))
+		if nullCount == 0 && len(buffer(5)) != 0 {
+			t.Errorf("Expected no validity buffer without nulls")
+		}
+		nulls += nullCount
+	}
+
+	if nulls != 1 {
+		t.Errorf("Expected 1 null, got %d", nulls)
+	}
+	if len(names) != BatchRows+3 {
+		t.Fatalf("Expected %d rows, got %d", BatchRows+3, len(names))
+	}
+	if names[0] != "a" || times[1] != 110 || values[2] != 3 || valid[1] || !valid[2] {
+		t.Errorf("Expected the points of a, got %v %v %v %v", names[:3], times[:3], values[:3], valid[:3])
+	}
+	last := len(names) - 1
+	if names[last] != "bb" || times[last] != BatchRows-1 || values[last] != BatchRows-1 || !valid[last] {
+		t.Errorf("Expected the last point of bb, got %v %v %v %v", names[last], times[last], values[last], valid[last])
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := readMessages(t, buf.Bytes()); len(messages) != 1 || messages[0].headerType != headerSchema {
+		t.Errorf("Expected only a schema, got %d messages", len(messages))
+	}
+}
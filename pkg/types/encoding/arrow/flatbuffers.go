@@ -0,0 +1,197 @@
+package arrow
+
+import (
+	"encoding/binary"
+)
+
+// builder builds a flatbuffer back to front, as the flatbuffers libraries
+// do, so that the offsets to the objects a table or vector refers to, which
+// are built first, point forward. Objects are referred to by their offset
+// from the end of the buffer.
+type builder struct {
+	buf      []byte
+	head     int
+	minAlign int
+
+	// vtable has the offsets of the fields of the table being built, 0 for
+	// the ones not set.
+	vtable    []int
+	objectEnd int
+}
+
+func newBuilder() *builder {
+	b := &builder{
+		buf:      make([]byte, 256),
+		minAlign: 1,
+	}
+	b.head = len(b.buf)
+
+	return b
+}
+
+func (b *builder) offset() int {
+	return len(b.buf) - b.head
+}
+
+// grow makes room for n more bytes in front of what is built.
+func (b *builder) grow(n int) {
+	for b.head < n {
+		used := b.offset()
+		buf := make([]byte, 2*len(b.buf))
+		copy(buf[len(buf)-used:], b.buf[b.head:])
+		b.buf = buf
+		b.head = len(buf) - used
+	}
+}
+
+// prep pads the buffer so that size bytes, written after additional ones,
+// are aligned to size.
+func (b *builder) prep(size, additional int) {
+	if size > b.minAlign {
+		b.minAlign = size
+	}
+	pad := -(b.offset() + additional) & (size - 1)
+	b.grow(pad + size + additional)
+	for i := 0; i < pad; i++ {
+		b.head--
+		b.buf[b.head] = 0
+	}
+}
+
+func (b *builder) placeUint8(v uint8) {
+	b.head--
+	b.buf[b.head] = v
+}
+
+func (b *builder) placeUint16(v uint16) {
+	b.head -= 2
+	binary.LittleEndian.PutUint16(b.buf[b.head:], v)
+}
+
+func (b *builder) placeUint32(v uint32) {
+	b.head -= 4
+	binary.LittleEndian.PutUint32(b.buf[b.head:], v)
+}
+
+func (b *builder) placeUint64(v uint64) {
+	b.head -= 8
+	binary.LittleEndian.PutUint64(b.buf[b.head:], v)
+}
+
+// prependOffset writes an offset to the object at off, relative to where
+// it is written.
+func (b *builder) prependOffset(off int) {
+	b.prep(4, 0)
+	b.placeUint32(uint32(b.offset() - off + 4))
+}
+
+func (b *builder) createString(s string) int {
+	b.prep(4, len(s)+1)
+	b.placeUint8(0)
+	b.head -= len(s)
+	copy(b.buf[b.head:], s)
+	b.placeUint32(uint32(len(s)))
+
+	return b.offset()
+}
+
+// createOffsetVector writes a vector of the objects at offs.
+func (b *builder) createOffsetVector(offs []int) int {
+	b.prep(4, 4*len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		b.prependOffset(offs[i])
+	}
+	b.placeUint32(uint32(len(offs)))
+
+	return b.offset()
+}
+
+// createPairVector writes a vector of structs of two longs, as the
+// FieldNode and Buffer structs of arrow are.
+func (b *builder) createPairVector(pairs [][2]int64) int {
+	b.prep(4, 16*len(pairs))
+	b.prep(8, 16*len(pairs))
+	for i := len(pairs) - 1; i >= 0; i-- {
+		b.placeUint64(uint64(pairs[i][1]))
+		b.placeUint64(uint64(pairs[i][0]))
+	}
+	b.prep(4, 0)
+	b.placeUint32(uint32(len(pairs)))
+
+	return b.offset()
+}
+
+func (b *builder) startTable(fields int) {
+	b.vtable = make([]int, fields)
+	b.objectEnd = b.offset()
+}
+
+func (b *builder) addUint8(field int, v uint8) {
+	b.prep(1, 0)
+	b.placeUint8(v)
+	b.vtable[field] = b.offset()
+}
+
+func (b *builder) addBool(field int, v bool) {
+	var u uint8
+	if v {
+		u = 1
+	}
+	b.addUint8(field, u)
+}
+
+func (b *builder) addInt16(field int, v int16) {
+	b.prep(2, 0)
+	b.placeUint16(uint16(v))
+	b.vtable[field] = b.offset()
+}
+
+func (b *builder) addInt64(field int, v int64) {
+	b.prep(8, 0)
+	b.placeUint64(uint64(v))
+	b.vtable[field] = b.offset()
+}
+
+func (b *builder) addOffset(field int, off int) {
+	b.prependOffset(off)
+	b.vtable[field] = b.offset()
+}
+
+// endTable writes the vtable of the table, and returns the table.
+func (b *builder) endTable() int {
+	// the offset to the vtable, set once it is written
+	b.prep(4, 0)
+	b.placeUint32(0)
+	object := b.offset()
+
+	n := len(b.vtable)
+	for n > 0 && b.vtable[n-1] == 0 {
+		n--
+	}
+	for i := n - 1; i >= 0; i-- {
+		var off int
+		if b.vtable[i] != 0 {
+			off = object - b.vtable[i]
+		}
+		b.prep(2, 0)
+		b.placeUint16(uint16(off))
+	}
+	b.prep(2, 0)
+	b.placeUint16(uint16(object - b.objectEnd))
+	b.prep(2, 0)
+	b.placeUint16(uint16((n + 2) * 2))
+
+	vtable := b.offset()
+	binary.LittleEndian.PutUint32(b.buf[len(b.buf)-object:], uint32(int32(vtable-object)))
+	b.vtable = nil
+
+	return object
+}
+
+// finish writes the offset to root, and returns the flatbuffer.
+func (b *builder) finish(root int) []byte {
+	b.prep(b.minAlign, 4)
+	b.prependOffset(root)
+
+	return b.buf[b.head:]
+}
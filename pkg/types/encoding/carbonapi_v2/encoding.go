@@ -11,6 +11,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"sync"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
 
@@ -202,8 +203,25 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 	return out.Marshal()
 }
 
+// multiFetchPool keeps the responses RenderDecoder unmarshals into, so the
+// slice of FetchResponses is reused between renders. Their values are fresh
+// allocations on every unmarshal and are handed out, not reused.
+var multiFetchPool = sync.Pool{
+	New: func() interface{} { return new(carbonapi_v2_pb.MultiFetchResponse) },
+}
+
+func putMultiFetch(resp *carbonapi_v2_pb.MultiFetchResponse) {
+	for i := range resp.Metrics {
+		resp.Metrics[i] = carbonapi_v2_pb.FetchResponse{}
+	}
+	resp.Metrics = resp.Metrics[:0]
+	multiFetchPool.Put(resp)
+}
+
 func RenderDecoder(blob []byte) ([]types.Metric, error) {
-	resp := carbonapi_v2_pb.MultiFetchResponse{}
+	resp := multiFetchPool.Get().(*carbonapi_v2_pb.MultiFetchResponse)
+	defer putMultiFetch(resp)
+
 	if err := resp.Unmarshal(blob); err != nil {
 		return nil, err
 	}
@@ -234,3 +252,20 @@ func RenderDecoder(blob []byte) ([]types.Metric, error) {
 
 	return metrics, nil
 }
+
+func ListEncoder(metrics []string) ([]byte, error) {
+	out := carbonapi_v2_pb.ListMetricsResponse{
+		Metrics: metrics,
+	}
+
+	return out.Marshal()
+}
+
+func ListDecoder(blob []byte) ([]string, error) {
+	resp := carbonapi_v2_pb.ListMetricsResponse{}
+	if err := resp.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	return resp.Metrics, nil
+}
@@ -1,6 +1,7 @@
 package carbonapi_v2
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
@@ -201,3 +202,55 @@ func TestResponseRenderUnmarshal(t *testing.T) {
 		t.Error("Metrics not equal")
 	}
 }
+
+func TestResponseListUnmarshal(t *testing.T) {
+	blob, err := ListEncoder([]string{"foo.bar", "foo.baz"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := ListDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(got) != 2 || got[0] != "foo.bar" || got[1] != "foo.baz" {
+		t.Errorf("Unexpected metrics %v", got)
+	}
+}
+
+func renderBlob(b *testing.B, series, points int) []byte {
+	metrics := make([]types.Metric, series)
+	for i := range metrics {
+		metrics[i] = types.Metric{
+			Name:      fmt.Sprintf("servers.host%04d.cpu.user", i),
+			StartTime: 0,
+			StopTime:  int32(points * 60),
+			StepTime:  60,
+			Values:    make([]float64, points),
+			IsAbsent:  make([]bool, points),
+		}
+	}
+
+	blob, err := RenderEncoder(metrics)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return blob
+}
+
+func BenchmarkRenderDecoder(b *testing.B) {
+	blob := renderBlob(b, 100, 1440)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := RenderDecoder(blob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
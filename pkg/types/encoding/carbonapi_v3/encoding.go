@@ -0,0 +1,460 @@
+/*
+Package carbonapi_v3 defines encoding and decoding methods for the requests
+and responses of version 3 of the carbonapi protocol, in which requests are
+POSTed as protocol buffers too.
+
+The messages are encoded by hand after the carbonapi_v3_pb schema of
+github.com/go-graphite/protocol, of which the fields used are:
+
+	message MultiGlobRequest { repeated string metrics = 1; int64 startTime = 2; int64 stopTime = 3; }
+	message GlobMatch { string path = 1; bool isLeaf = 2; }
+	message GlobResponse { string name = 1; repeated GlobMatch matches = 2; }
+	message MultiGlobResponse { repeated GlobResponse metrics = 1; }
+
+	message FilteringFunction { string name = 1; repeated string arguments = 2; }
+	message FetchRequest {
+		string name = 1; int64 startTime = 2; int64 stopTime = 3; string pathExpression = 5;
+		repeated FilteringFunction filterFunctions = 6;
+	}
+	message MultiFetchRequest { repeated FetchRequest metrics = 1; }
+	message FetchResponse {
+		string name = 1; string pathExpression = 2; string consolidationFunc = 3;
+		int64 startTime = 4; int64 stopTime = 5; int64 stepTime = 6;
+		float xFilesFactor = 7; repeated double values = 9;
+		int64 requestStartTime = 11; int64 requestStopTime = 12;
+	}
+	message MultiFetchResponse { repeated FetchResponse metrics = 1; }
+
+	message MultiMetricsInfoRequest { repeated string names = 1; }
+	message Retention { int64 secondsPerPoint = 1; int64 numberOfPoints = 2; }
+	message MetricsInfoResponse {
+		string name = 1; string consolidationFunc = 2; float xFilesFactor = 3;
+		int64 maxRetention = 4; repeated Retention retentions = 5;
+	}
+	message MultiMetricsInfoResponse { repeated MetricsInfoResponse metrics = 1; }
+	message ZipperInfoResponse { map<string, MultiMetricsInfoResponse> info = 1; }
+
+Absent points are NaN values in version 3.
+*/
+package carbonapi_v3
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// FetchRequest asks for the series of a metric or glob.
+type FetchRequest struct {
+	Name  string
+	From  int32
+	Until int32
+	// Filters are applied, in turn, to the series of the request by the
+	// backends that support them.
+	Filters []types.FilterFunction
+}
+
+func FindRequestEncoder(globs []string) ([]byte, error) {
+	var e encoder
+	for _, g := range globs {
+		e.repeatedString(1, g)
+	}
+
+	return e.buf, nil
+}
+
+func FindRequestDecoder(blob []byte) ([]string, error) {
+	var globs []string
+	d := decoder{blob}
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if field == 1 && wire == wireBytes {
+			g, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			globs = append(globs, g)
+			continue
+		}
+		if err := d.skip(wire); err != nil {
+			return nil, err
+		}
+	}
+
+	return globs, nil
+}
+
+func FindEncoder(matches []types.Matches) ([]byte, error) {
+	var e encoder
+	for _, m := range matches {
+		e.message(1, func(e *encoder) {
+			e.string(1, m.Name)
+			for _, match := range m.Matches {
+				e.message(2, func(e *encoder) {
+					e.string(1, match.Path)
+					e.bool(2, match.IsLeaf)
+				})
+			}
+		})
+	}
+
+	return e.buf, nil
+}
+
+func FindDecoder(blob []byte) ([]types.Matches, error) {
+	var matches []types.Matches
+	err := decodeRepeated(blob, 1, func(d *decoder) error {
+		var m types.Matches
+		err := decodeFields(d, func(field, wire int, d *decoder) (bool, error) {
+			switch {
+			case field == 1 && wire == wireBytes:
+				s, err := d.string()
+				m.Name = s
+				return true, err
+			case field == 2 && wire == wireBytes:
+				b, err := d.bytes()
+				if err != nil {
+					return true, err
+				}
+				match, err := decodeGlobMatch(b)
+				m.Matches = append(m.Matches, match)
+				return true, err
+			}
+			return false, nil
+		})
+		matches = append(matches, m)
+		return err
+	})
+
+	return matches, err
+}
+
+func decodeGlobMatch(blob []byte) (types.Match, error) {
+	var m types.Match
+	err := decodeFields(&decoder{blob}, func(field, wire int, d *decoder) (bool, error) {
+		switch {
+		case field == 1 && wire == wireBytes:
+			s, err := d.string()
+			m.Path = s
+			return true, err
+		case field == 2 && wire == wireVarint:
+			v, err := d.uvarint()
+			m.IsLeaf = v != 0
+			return true, err
+		}
+		return false, nil
+	})
+
+	return m, err
+}
+
+func RenderRequestEncoder(requests []FetchRequest) ([]byte, error) {
+	var e encoder
+	for _, r := range requests {
+		e.message(1, func(e *encoder) {
+			e.string(1, r.Name)
+			e.int64(2, int64(r.From))
+			e.int64(3, int64(r.Until))
+			e.string(5, r.Name)
+			for _, f := range r.Filters {
+				e.message(6, func(e *encoder) {
+					e.string(1, f.Name)
+					for _, a := range f.Arguments {
+						e.repeatedString(2, a)
+					}
+				})
+			}
+		})
+	}
+
+	return e.buf, nil
+}
+
+func RenderRequestDecoder(blob []byte) ([]FetchRequest, error) {
+	var requests []FetchRequest
+	err := decodeRepeated(blob, 1, func(d *decoder) error {
+		var r FetchRequest
+		var path string
+		err := decodeFields(d, func(field, wire int, d *decoder) (bool, error) {
+			var err error
+			var v uint64
+			switch {
+			case field == 1 && wire == wireBytes:
+				r.Name, err = d.string()
+			case field == 2 && wire == wireVarint:
+				v, err = d.uvarint()
+				r.From = int32(v)
+			case field == 3 && wire == wireVarint:
+				v, err = d.uvarint()
+				r.Until = int32(v)
+			case field == 5 && wire == wireBytes:
+				path, err = d.string()
+			case field == 6 && wire == wireBytes:
+				var b []byte
+				if b, err = d.bytes(); err != nil {
+					return true, err
+				}
+				var f types.FilterFunction
+				f, err = decodeFilterFunction(b)
+				r.Filters = append(r.Filters, f)
+			default:
+				return false, nil
+			}
+			return true, err
+		})
+		// the path expression is what to fetch, the name only names it
+		if path != "" {
+			r.Name = path
+		}
+		requests = append(requests, r)
+		return err
+	})
+
+	return requests, err
+}
+
+func decodeFilterFunction(blob []byte) (types.FilterFunction, error) {
+	var f types.FilterFunction
+	err := decodeFields(&decoder{blob}, func(field, wire int, d *decoder) (bool, error) {
+		if wire != wireBytes || (field != 1 && field != 2) {
+			return false, nil
+		}
+		s, err := d.string()
+		if field == 1 {
+			f.Name = s
+		} else {
+			f.Arguments = append(f.Arguments, s)
+		}
+		return true, err
+	})
+
+	return f, err
+}
+
+func RenderEncoder(metrics []types.Metric) ([]byte, error) {
+	var e encoder
+	for _, m := range metrics {
+		values := make([]float64, len(m.Values))
+		for i, v := range m.Values {
+			if i < len(m.IsAbsent) && m.IsAbsent[i] {
+				v = math.NaN()
+			}
+			values[i] = v
+		}
+
+		e.message(1, func(e *encoder) {
+			e.string(1, m.Name)
+			e.string(2, m.Name)
+			e.int64(4, int64(m.StartTime))
+			e.int64(5, int64(m.StopTime))
+			e.int64(6, int64(m.StepTime))
+			e.packedDoubles(9, values)
+		})
+	}
+
+	return e.buf, nil
+}
+
+func RenderDecoder(blob []byte) ([]types.Metric, error) {
+	var metrics []types.Metric
+	err := decodeRepeated(blob, 1, func(d *decoder) error {
+		var m types.Metric
+		err := decodeFields(d, func(field, wire int, d *decoder) (bool, error) {
+			var err error
+			var v uint64
+			switch {
+			case field == 1 && wire == wireBytes:
+				m.Name, err = d.string()
+			case field == 4 && wire == wireVarint:
+				v, err = d.uvarint()
+				m.StartTime = int32(v)
+			case field == 5 && wire == wireVarint:
+				v, err = d.uvarint()
+				m.StopTime = int32(v)
+			case field == 6 && wire == wireVarint:
+				v, err = d.uvarint()
+				m.StepTime = int32(v)
+			case field == 9 && (wire == wireBytes || wire == wireFixed64):
+				m.Values, err = d.doubles(wire, m.Values)
+			default:
+				return false, nil
+			}
+			return true, err
+		})
+
+		m.IsAbsent = make([]bool, len(m.Values))
+		for i, v := range m.Values {
+			if math.IsNaN(v) {
+				m.Values[i] = 0
+				m.IsAbsent[i] = true
+			}
+		}
+		metrics = append(metrics, m)
+		return err
+	})
+
+	return metrics, err
+}
+
+func InfoRequestEncoder(names []string) ([]byte, error) {
+	return FindRequestEncoder(names)
+}
+
+func InfoRequestDecoder(blob []byte) ([]string, error) {
+	return FindRequestDecoder(blob)
+}
+
+func InfoEncoder(infos []types.Info) ([]byte, error) {
+	byHost := make(map[string][]types.Info)
+	var hosts []string
+	for _, info := range infos {
+		if _, ok := byHost[info.Host]; !ok {
+			hosts = append(hosts, info.Host)
+		}
+		byHost[info.Host] = append(byHost[info.Host], info)
+	}
+	sort.Strings(hosts)
+
+	var e encoder
+	for _, host := range hosts {
+		e.message(1, func(e *encoder) {
+			e.repeatedString(1, host)
+			e.message(2, func(e *encoder) {
+				for _, info := range byHost[host] {
+					e.message(1, func(e *encoder) {
+						e.string(1, info.Name)
+						e.string(2, info.AggregationMethod)
+						e.float32(3, info.XFilesFactor)
+						e.int64(4, int64(info.MaxRetention))
+						for _, r := range info.Retentions {
+							e.message(5, func(e *encoder) {
+								e.int64(1, int64(r.SecondsPerPoint))
+								e.int64(2, int64(r.NumberOfPoints))
+							})
+						}
+					})
+				}
+			})
+		})
+	}
+
+	return e.buf, nil
+}
+
+func InfoDecoder(blob []byte) ([]types.Info, error) {
+	var infos []types.Info
+	err := decodeRepeated(blob, 1, func(d *decoder) error {
+		var host string
+		var hostInfos []types.Info
+		err := decodeFields(d, func(field, wire int, d *decoder) (bool, error) {
+			switch {
+			case field == 1 && wire == wireBytes:
+				s, err := d.string()
+				host = s
+				return true, err
+			case field == 2 && wire == wireBytes:
+				b, err := d.bytes()
+				if err != nil {
+					return true, err
+				}
+				return true, decodeRepeated(b, 1, func(d *decoder) error {
+					info, err := decodeInfo(d)
+					hostInfos = append(hostInfos, info)
+					return err
+				})
+			}
+			return false, nil
+		})
+		for _, info := range hostInfos {
+			info.Host = host
+			infos = append(infos, info)
+		}
+		return err
+	})
+
+	return infos, err
+}
+
+func decodeInfo(d *decoder) (types.Info, error) {
+	var info types.Info
+	err := decodeFields(d, func(field, wire int, d *decoder) (bool, error) {
+		var err error
+		var v uint64
+		switch {
+		case field == 1 && wire == wireBytes:
+			info.Name, err = d.string()
+		case field == 2 && wire == wireBytes:
+			info.AggregationMethod, err = d.string()
+		case field == 3 && wire == wireFixed32:
+			info.XFilesFactor, err = d.float32()
+		case field == 4 && wire == wireVarint:
+			v, err = d.uvarint()
+			info.MaxRetention = int32(v)
+		case field == 5 && wire == wireBytes:
+			var b []byte
+			if b, err = d.bytes(); err != nil {
+				return true, err
+			}
+			var r types.Retention
+			err = decodeFields(&decoder{b}, func(field, wire int, d *decoder) (bool, error) {
+				if wire != wireVarint || (field != 1 && field != 2) {
+					return false, nil
+				}
+				v, err := d.uvarint()
+				if field == 1 {
+					r.SecondsPerPoint = int32(v)
+				} else {
+					r.NumberOfPoints = int32(v)
+				}
+				return true, err
+			})
+			info.Retentions = append(info.Retentions, r)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+
+	return info, err
+}
+
+// decodeFields calls decode with each field of the message in d, skipping
+// those it doesn't read.
+func decodeFields(d *decoder, decode func(field, wire int, d *decoder) (bool, error)) error {
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return err
+		}
+		read, err := decode(field, wire, d)
+		if err != nil {
+			return err
+		}
+		if !read {
+			if err := d.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeRepeated calls decode with each message of the repeated field of
+// the message in blob.
+func decodeRepeated(blob []byte, field int, decode func(d *decoder) error) error {
+	return decodeFields(&decoder{blob}, func(f, wire int, d *decoder) (bool, error) {
+		if f != field || wire != wireBytes {
+			return false, nil
+		}
+		b, err := d.bytes()
+		if err != nil {
+			return true, err
+		}
+		return true, decode(&decoder{b})
+	})
+}
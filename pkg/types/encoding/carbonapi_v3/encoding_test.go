@@ -0,0 +1,165 @@
+package carbonapi_v3
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestFindRoundTrip(t *testing.T) {
+	globs := []string{"foo.*", "bar.{a,b}"}
+	blob, err := FindRequestEncoder(globs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotGlobs, err := FindRequestDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotGlobs, globs) {
+		t.Errorf("expected globs %v, got %v", globs, gotGlobs)
+	}
+
+	matches := []types.Matches{
+		{Name: "foo.*", Matches: []types.Match{{Path: "foo.a", IsLeaf: true}, {Path: "foo.b"}}},
+		{Name: "bar.{a,b}"},
+	}
+	blob, err = FindEncoder(matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FindDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, matches) {
+		t.Errorf("expected %+v, got %+v", matches, got)
+	}
+}
+
+// GlobResponse is the same in versions 2 and 3, which checks the encoding
+// against generated code.
+func TestFindEncoderWire(t *testing.T) {
+	blob, err := FindEncoder([]types.Matches{{Name: "foo.*", Matches: []types.Match{{Path: "foo.a", IsLeaf: true}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := (&carbonapi_v2_pb.GlobResponse{
+		Name:    "foo.*",
+		Matches: []carbonapi_v2_pb.GlobMatch{{Path: "foo.a", IsLeaf: true}},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := decoder{blob}
+	if field, wire, err := d.next(); err != nil || field != 1 || wire != wireBytes {
+		t.Fatalf("expected field 1 of wire type 2, got %d, %d, %v", field, wire, err)
+	}
+	inner, err := d.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inner, v2) {
+		t.Errorf("expected %x, got %x", v2, inner)
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	requests := []FetchRequest{
+		{Name: "foo.*", From: 100, Until: 200, Filters: []types.FilterFunction{
+			{Name: "highestAverage", Arguments: []string{"5"}},
+			{Name: "filterSeries", Arguments: []string{"max", ">", ""}},
+		}},
+		{Name: "bar", From: 0, Until: 60},
+	}
+	blob, err := RenderRequestEncoder(requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotRequests, err := RenderRequestDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotRequests, requests) {
+		t.Errorf("expected %+v, got %+v", requests, gotRequests)
+	}
+
+	metrics := []types.Metric{{
+		Name:      "foo.a",
+		StartTime: 100,
+		StopTime:  220,
+		StepTime:  60,
+		Values:    []float64{1.5, 0},
+		IsAbsent:  []bool{false, true},
+	}}
+	blob, err = RenderEncoder(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := RenderDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, metrics) {
+		t.Errorf("expected %+v, got %+v", metrics, got)
+	}
+}
+
+func TestRenderDecoderUnpacked(t *testing.T) {
+	var e encoder
+	e.message(1, func(e *encoder) {
+		e.string(1, "foo")
+		for _, v := range []float64{1, math.NaN()} {
+			e.tag(9, wireFixed64)
+			e.buf = appendUint64(e.buf, math.Float64bits(v))
+		}
+		// unknown fields are skipped
+		e.string(3, "average")
+		e.float32(7, 0.5)
+	})
+
+	got, err := RenderDecoder(e.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.Metric{{Name: "foo", Values: []float64{1, 0}, IsAbsent: []bool{false, true}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestInfoRoundTrip(t *testing.T) {
+	infos := []types.Info{
+		{Host: "a:8080", Name: "foo", AggregationMethod: "avg", MaxRetention: 86400, XFilesFactor: 0.5,
+			Retentions: []types.Retention{{SecondsPerPoint: 60, NumberOfPoints: 1440}}},
+		{Host: "b:8080", Name: "foo", AggregationMethod: "sum"},
+	}
+	blob, err := InfoEncoder(infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := InfoDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, infos) {
+		t.Errorf("expected %+v, got %+v", infos, got)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	blob, err := FindEncoder([]types.Matches{{Name: "foo", Matches: []types.Match{{Path: "foo"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindDecoder(blob[:len(blob)-2]); err == nil {
+		t.Error("expected an error for a truncated message")
+	}
+}
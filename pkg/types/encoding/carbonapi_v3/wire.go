@@ -0,0 +1,215 @@
+package carbonapi_v3
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Protocol buffer wire types,
+// https://developers.google.com/protocol-buffers/docs/encoding
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+// encoder appends fields to a message. Like proto3 it leaves out fields with
+// the zero value, but not elements of repeated fields.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field, wire int) {
+	e.buf = appendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = appendUvarint(e.buf, v)
+}
+
+func (e *encoder) int64(field int, v int64) {
+	e.varint(field, uint64(v))
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.varint(field, 1)
+	}
+}
+
+func (e *encoder) float32(field int, v float32) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed32)
+	e.buf = appendUint32(e.buf, math.Float32bits(v))
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.buf = appendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.bytes(field, []byte(s))
+}
+
+// repeatedString appends an element of a repeated string field, which is
+// kept even if empty.
+func (e *encoder) repeatedString(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+func (e *encoder) message(field int, encode func(*encoder)) {
+	var m encoder
+	encode(&m)
+	e.bytes(field, m.buf)
+}
+
+func (e *encoder) packedDoubles(field int, vs []float64) {
+	if len(vs) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = appendUvarint(e.buf, uint64(8*len(vs)))
+	for _, v := range vs {
+		e.buf = appendUint64(e.buf, math.Float64bits(v))
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	return append(b, scratch[:n]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], v)
+	return append(b, scratch[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], v)
+	return append(b, scratch[:]...)
+}
+
+// decoder reads the fields of a message one by one.
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) done() bool {
+	return len(d.buf) == 0
+}
+
+// next returns the number and wire type of the next field.
+func (d *decoder) next() (int, int, error) {
+	v, err := d.uvarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 7), nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+func (d *decoder) fixed32() (uint32, error) {
+	if len(d.buf) < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v, nil
+}
+
+func (d *decoder) fixed64() (uint64, error) {
+	if len(d.buf) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(d.buf)) < n {
+		return nil, errTruncated
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+func (d *decoder) float32() (float32, error) {
+	v, err := d.fixed32()
+	return math.Float32frombits(v), err
+}
+
+// doubles reads a repeated double field, packed or not.
+func (d *decoder) doubles(wire int, vs []float64) ([]float64, error) {
+	if wire == wireFixed64 {
+		v, err := d.fixed64()
+		return append(vs, math.Float64frombits(v)), err
+	}
+
+	b, err := d.bytes()
+	if err != nil {
+		return vs, err
+	}
+	if len(b)%8 != 0 {
+		return vs, errors.New("bad packed doubles")
+	}
+	for ; len(b) > 0; b = b[8:] {
+		vs = append(vs, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	}
+	return vs, nil
+}
+
+// skip skips the value of a field of the given wire type.
+func (d *decoder) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = d.uvarint()
+	case wireFixed64:
+		_, err = d.fixed64()
+	case wireBytes:
+		_, err = d.bytes()
+	case wireFixed32:
+		_, err = d.fixed32()
+	default:
+		err = errors.Errorf("unsupported wire type %d", wire)
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+/*
+Package parquet encodes series as an Apache Parquet file,
+https://parquet.apache.org/docs/file-format/, with a row per point:
+
+	required binary name (STRING);
+	required int64 time (TIMESTAMP(MILLIS, true));
+	optional double value;
+
+where values are null for absent points. The rows are written in row
+groups of at most RowGroupRows rows, each column of a group in a single
+gzipped data page with plain encoding.
+
+The metadata is encoded by hand in the thrift compact protocol, after
+parquet.thrift, of which the fields used are:
+
+	struct FileMetaData { 1: i32 version; 2: list<SchemaElement> schema; 3: i64 num_rows; 4: list<RowGroup> row_groups; }
+	struct SchemaElement {
+		1: Type type; 3: FieldRepetitionType repetition_type; 4: string name; 5: i32 num_children;
+		6: ConvertedType converted_type; 10: LogicalType logicalType;
+	}
+	union LogicalType { 1: StringType STRING; 8: TimestampType TIMESTAMP; }
+	struct TimestampType { 1: bool isAdjustedToUTC; 2: TimeUnit unit; }
+	union TimeUnit { 1: MilliSeconds MILLIS; }
+	struct RowGroup { 1: list<ColumnChunk> columns; 2: i64 total_byte_size; 3: i64 num_rows; }
+	struct ColumnChunk { 2: i64 file_offset; 3: ColumnMetaData meta_data; }
+	struct ColumnMetaData {
+		1: Type type; 2: list<Encoding> encodings; 3: list<string> path_in_schema; 4: CompressionCodec codec;
+		5: i64 num_values; 6: i64 total_uncompressed_size; 7: i64 total_compressed_size; 9: i64 data_page_offset;
+	}
+	struct PageHeader { 1: PageType type; 2: i32 uncompressed_page_size; 3: i32 compressed_page_size; 5: DataPageHeader data_page_header; }
+	struct DataPageHeader { 1: i32 num_values; 2: Encoding encoding; 3: Encoding definition_level_encoding; 4: Encoding repetition_level_encoding; }
+*/
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// RowGroupRows is the most rows a row group has.
+const RowGroupRows = 1 << 16
+
+const magic = "PAR1"
+
+// Physical types
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// Repetition types
+const (
+	required = 0
+	optional = 1
+)
+
+// Converted types
+const (
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+)
+
+// Encodings, compression codecs and page types
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+	codecGzip     = 2
+	pageData      = 0
+)
+
+// column is a column of a row group, as it is written.
+type column struct {
+	typ              int32
+	name             string
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type rowGroup struct {
+	columns []column
+	rows    int64
+}
+
+// Writer writes the points of series as a Parquet file.
+type Writer struct {
+	w      io.Writer
+	offset int64
+	err    error
+
+	groups []rowGroup
+
+	rows   int
+	names  []byte
+	times  []byte
+	values []byte
+	// defined has the definition level of each value: false for nulls
+	defined []bool
+}
+
+// NewWriter returns a writer to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteSeries adds the points of a series, writing out the row groups it
+// fills.
+func (w *Writer) WriteSeries(name string, start, step int32, values []float64, absent []bool) error {
+	for i, v := range values {
+		w.names = appendUint32(w.names, uint32(len(name)))
+		w.names = append(w.names, name...)
+		w.times = appendUint64(w.times, uint64((int64(start)+int64(i)*int64(step))*1000))
+
+		ok := i >= len(absent) || !absent[i]
+		w.defined = append(w.defined, ok)
+		if ok {
+			w.values = appendUint64(w.values, math.Float64bits(v))
+		}
+		w.rows++
+
+		if w.rows == RowGroupRows {
+			w.flush()
+		}
+	}
+
+	return w.err
+}
+
+// Close writes out the last row group and the footer. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.rows > 0 {
+		w.flush()
+	}
+	if w.offset == 0 {
+		w.write([]byte(magic))
+	}
+
+	footer := w.footer()
+	w.write(footer)
+	w.write(appendUint32(nil, uint32(len(footer))))
+	w.write([]byte(magic))
+
+	return w.err
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(b)
+	w.offset += int64(n)
+}
+
+func (w *Writer) flush() {
+	if w.offset == 0 {
+		w.write([]byte(magic))
+	}
+
+	levels := encodeLevels(w.defined)
+	values := appendUint32(nil, uint32(len(levels)))
+	values = append(values, levels...)
+	values = append(values, w.values...)
+
+	g := rowGroup{rows: int64(w.rows)}
+	g.columns = append(g.columns, w.writePage(typeByteArray, "name", w.names))
+	g.columns = append(g.columns, w.writePage(typeInt64, "time", w.times))
+	g.columns = append(g.columns, w.writePage(typeDouble, "value", values))
+	w.groups = append(w.groups, g)
+
+	w.rows = 0
+	w.names = w.names[:0]
+	w.times = w.times[:0]
+	w.values = w.values[:0]
+	w.defined = w.defined[:0]
+}
+
+// writePage writes a column of a row group as a single data page.
+func (w *Writer) writePage(typ int32, name string, data []byte) column {
+	var compressed bytes.Buffer
+	z := gzip.NewWriter(&compressed)
+	z.Write(data)
+	z.Close()
+
+	var t thriftWriter
+	t.i32(1, pageData)
+	t.i32(2, int32(len(data)))
+	t.i32(3, int32(compressed.Len()))
+	t.beginStruct(5)
+	t.i32(1, int32(w.rows))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.endStruct()
+	t.buf = append(t.buf, 0)
+
+	c := column{
+		typ:              typ,
+		name:             name,
+		offset:           w.offset,
+		uncompressedSize: int64(len(t.buf) + len(data)),
+		compressedSize:   int64(len(t.buf) + compressed.Len()),
+	}
+	w.write(t.buf)
+	w.write(compressed.Bytes())
+
+	return c
+}
+
+func (w *Writer) footer() []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+
+	t.list(2, compactStruct, 4)
+	t.beginStruct(0)
+	t.string(4, "schema")
+	t.i32(5, 3)
+	t.endStruct()
+
+	t.beginStruct(0)
+	t.i32(1, typeByteArray)
+	t.i32(3, required)
+	t.string(4, "name")
+	t.i32(6, convertedUTF8)
+	t.beginStruct(10)
+	t.beginStruct(1)
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+
+	t.beginStruct(0)
+	t.i32(1, typeInt64)
+	t.i32(3, required)
+	t.string(4, "time")
+	t.i32(6, convertedTimestampMillis)
+	t.beginStruct(10)
+	t.beginStruct(8)
+	t.bool(1, true)
+	t.beginStruct(2)
+	t.beginStruct(1)
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+
+	t.beginStruct(0)
+	t.i32(1, typeDouble)
+	t.i32(3, optional)
+	t.string(4, "value")
+	t.endStruct()
+
+	var rows int64
+	for _, g := range w.groups {
+		rows += g.rows
+	}
+	t.i64(3, rows)
+
+	t.list(4, compactStruct, len(w.groups))
+	for _, g := range w.groups {
+		t.beginStruct(0)
+		t.list(1, compactStruct, len(g.columns))
+		var size int64
+		for _, c := range g.columns {
+			size += c.uncompressedSize
+
+			t.beginStruct(0)
+			t.i64(2, c.offset)
+			t.beginStruct(3)
+			t.i32(1, c.typ)
+			t.list(2, compactI32, 2)
+			t.i32Elem(encodingPlain)
+			t.i32Elem(encodingRLE)
+			t.list(3, compactBinary, 1)
+			t.stringElem(c.name)
+			t.i32(4, codecGzip)
+			t.i64(5, g.rows)
+			t.i64(6, c.uncompressedSize)
+			t.i64(7, c.compressedSize)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, size)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+
+	return append(t.buf, 0)
+}
+
+// encodeLevels encodes definition levels of one bit in runs of the
+// RLE/bit-packing hybrid encoding.
+func encodeLevels(defined []bool) []byte {
+	var b []byte
+	for i := 0; i < len(defined); {
+		j := i + 1
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		b = appendUvarint(b, uint64(j-i)<<1)
+		if defined[i] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		i = j
+	}
+
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,170 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+// thriftReader reads structs of the thrift compact protocol into maps of
+// their fields by id.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		b := r.buf[r.pos]
+		r.pos++
+		if b == 0 {
+			return fields
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+		fields[id] = r.readValue(b & 0x0f)
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) interface{} {
+	switch typ {
+	case compactTrue:
+		return true
+	case compactFalse:
+		return false
+	case compactI32, compactI64:
+		return r.varint()
+	case compactBinary:
+		n := int(r.uvarint())
+		r.pos += n
+		return string(r.buf[r.pos-n : r.pos])
+	case compactList:
+		b := r.buf[r.pos]
+		r.pos++
+		n := int(b >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.readValue(b & 0x0f)
+		}
+		return list
+	case compactStruct:
+		return r.readStruct()
+	}
+	panic("unknown type")
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteSeries("a", 100, 10, []float64{1, 0, 3}, []bool{false, true, false}); err != nil {
+		t.Fatal(err)
+	}
+	long := make([]float64, RowGroupRows)
+	for i := range long {
+		long[i] = float64(i)
+	}
+	if err := w.WriteSeries("bb", 0, 1, long, make([]bool, len(long))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatal("Expected the file to start and end with PAR1")
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := (&thriftReader{buf: b[len(b)-8-size : len(b)-8]}).readStruct()
+
+	if footer[3].(int64) != RowGroupRows+3 {
+		t.Errorf("Expected %d rows, got %v", RowGroupRows+3, footer[3])
+	}
+	schema := footer[2].([]interface{})
+	var names []string
+	for _, e := range schema {
+		names = append(names, e.(map[int16]interface{})[4].(string))
+	}
+	if len(names) != 4 || names[1] != "name" || names[2] != "time" || names[3] != "value" {
+		t.Errorf("Expected the name, time and value columns, got %v", names)
+	}
+
+	groups := footer[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 row groups, got %d", len(groups))
+	}
+
+	// the values of the first row group
+	first := groups[0].(map[int16]interface{})
+	if first[3].(int64) != RowGroupRows {
+		t.Errorf("Expected a full row group, got %v rows", first[3])
+	}
+	meta := first[1].([]interface{})[2].(map[int16]interface{})[3].(map[int16]interface{})
+	r := &thriftReader{buf: b, pos: int(meta[9].(int64))}
+	header := r.readStruct()
+	compressed := b[r.pos : r.pos+int(header[3].(int64))]
+	z, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != int(header[2].(int64)) {
+		t.Errorf("Expected the uncompressed size of the page, got %d", len(page))
+	}
+
+	levels := int(binary.LittleEndian.Uint32(page))
+	// runs of 1 defined value, 1 null and the rest defined
+	want := append(appendUvarint(nil, 1<<1), 1)
+	want = append(appendUvarint(want, 1<<1), 0)
+	want = append(appendUvarint(want, (RowGroupRows-2)<<1), 1)
+	if !bytes.Equal(page[4:4+levels], want) {
+		t.Errorf("Expected runs of 1 defined, 1 null and the rest defined, got %v", page[4:4+levels])
+	}
+	values := page[4+levels:]
+	if len(values) != 8*(RowGroupRows-1) {
+		t.Fatalf("Expected %d values, got %d bytes", RowGroupRows-1, len(values))
+	}
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(values[8:])); v != 3 {
+		t.Errorf("Expected the third point of a after the null, got %v", v)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := (&thriftReader{buf: b[len(b)-8-size : len(b)-8]}).readStruct()
+	if footer[3].(int64) != 0 || len(footer[4].([]interface{})) != 0 {
+		t.Errorf("Expected no rows, got %v", footer)
+	}
+}
@@ -0,0 +1,106 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// Thrift compact protocol types,
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+const (
+	compactTrue   = 1
+	compactFalse  = 2
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// thriftWriter appends a struct in the thrift compact protocol.
+type thriftWriter struct {
+	buf    []byte
+	lastID int16
+	// ids are the last field ids of the structs the one written is in
+	ids []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = appendVarint(t.buf, int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, compactI32)
+	t.buf = appendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, compactI64)
+	t.buf = appendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.field(id, compactTrue)
+	} else {
+		t.field(id, compactFalse)
+	}
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.field(id, compactBinary)
+	t.buf = appendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// beginStruct starts a struct field; a zero id starts a struct that is an
+// element of a list.
+func (t *thriftWriter) beginStruct(id int16) {
+	if id != 0 {
+		t.field(id, compactStruct)
+	}
+	t.ids = append(t.ids, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.lastID = t.ids[len(t.ids)-1]
+	t.ids = t.ids[:len(t.ids)-1]
+}
+
+func (t *thriftWriter) list(id int16, elemType byte, n int) {
+	t.field(id, compactList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.buf = appendUvarint(t.buf, uint64(n))
+	}
+}
+
+// i32Elem and stringElem append elements of lists.
+func (t *thriftWriter) i32Elem(v int32) {
+	t.buf = appendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) stringElem(s string) {
+	t.buf = appendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendVarint appends a zigzag varint, which is how thrift encodes ints.
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
@@ -0,0 +1,107 @@
+package types
+
+import "fmt"
+
+// StepPolicy decides how the parts of a series that have different steps,
+// e.g. from backends with different retentions, are merged.
+type StepPolicy int
+
+const (
+	// StepFinest merges at the finest step. Parts at other steps can't fill
+	// its gaps.
+	StepFinest StepPolicy = iota
+	// StepCoarsest consolidates all parts to the coarsest step before
+	// merging, averaging the points in each of its intervals.
+	StepCoarsest
+)
+
+var stepPolicy = StepFinest
+
+// SetStepPolicy sets how MergeMetrics merges parts with different steps.
+func SetStepPolicy(p StepPolicy) {
+	stepPolicy = p
+}
+
+// ParseStepPolicy parses "finest" or "coarsest". Empty is finest.
+func ParseStepPolicy(s string) (StepPolicy, error) {
+	switch s {
+	case "", "finest":
+		return StepFinest, nil
+	case "coarsest":
+		return StepCoarsest, nil
+	}
+
+	return StepFinest, fmt.Errorf("unknown step policy %q", s)
+}
+
+// NormalizeSteps consolidates the parts of a series to the coarsest step among
+// them. The coarsest part comes first and the others are aligned to its
+// points. Parts whose step doesn't divide the coarsest step are dropped.
+func NormalizeSteps(metrics []Metric) []Metric {
+	if len(metrics) < 2 {
+		return metrics
+	}
+
+	base := 0
+	for i, m := range metrics {
+		if m.StepTime > metrics[base].StepTime {
+			base = i
+		}
+	}
+	coarse := metrics[base]
+
+	normalized := make([]Metric, 0, len(metrics))
+	normalized = append(normalized, coarse)
+	for i, m := range metrics {
+		if i == base || m.StepTime <= 0 || coarse.StepTime%m.StepTime != 0 {
+			continue
+		}
+
+		normalized = append(normalized, consolidate(m, coarse))
+	}
+
+	return normalized
+}
+
+// consolidate averages the points of m over each interval of base.
+func consolidate(m, base Metric) Metric {
+	if m.StepTime == base.StepTime && m.StartTime == base.StartTime && len(m.Values) == len(base.Values) {
+		return m
+	}
+
+	n := len(base.Values)
+	sums := make([]float64, n)
+	counts := make([]int, n)
+	for j, v := range m.Values {
+		t := m.StartTime + int32(j)*m.StepTime
+		if m.IsAbsent[j] || t < base.StartTime {
+			continue
+		}
+
+		i := int((t - base.StartTime) / base.StepTime)
+		if i >= n {
+			break
+		}
+		sums[i] += v
+		counts[i]++
+	}
+
+	values := make([]float64, n)
+	absent := make([]bool, n)
+	for i := range values {
+		if counts[i] == 0 {
+			absent[i] = true
+			continue
+		}
+		values[i] = sums[i] / float64(counts[i])
+	}
+
+	return Metric{
+		Name:      m.Name,
+		StartTime: base.StartTime,
+		StopTime:  base.StopTime,
+		StepTime:  base.StepTime,
+		Values:    values,
+		IsAbsent:  absent,
+	}
+}
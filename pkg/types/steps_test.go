@@ -0,0 +1,136 @@
+package types
+
+import (
+	"testing"
+)
+
+// mixedRetention is one series from two stores: one keeps 10s points but
+// lost the second half of the range, the other only keeps 30s points and
+// lost the first one.
+func mixedRetention() [][]Metric {
+	return [][]Metric{
+		[]Metric{
+			Metric{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  60,
+				StepTime:  10,
+				Values:    []float64{1, 2, 3, 0, 0, 0},
+				IsAbsent:  []bool{false, false, false, true, true, true},
+			},
+		},
+		[]Metric{
+			Metric{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  60,
+				StepTime:  30,
+				Values:    []float64{0, 20},
+				IsAbsent:  []bool{true, false},
+			},
+		},
+	}
+}
+
+func TestMergeMixedRetentionFinest(t *testing.T) {
+	defer SetStepPolicy(stepPolicy)
+	SetStepPolicy(StepFinest)
+
+	got := MergeMetrics(mixedRetention())
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 0,
+		StopTime:  60,
+		StepTime:  10,
+		Values:    []float64{1, 2, 3, 0, 0, 0},
+		IsAbsent:  []bool{false, false, false, true, true, true},
+	}
+	if len(got) != 1 || !MetricsEqual(got[0], expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestMergeMixedRetentionCoarsest(t *testing.T) {
+	defer SetStepPolicy(stepPolicy)
+	SetStepPolicy(StepCoarsest)
+
+	got := MergeMetrics(mixedRetention())
+
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 0,
+		StopTime:  60,
+		StepTime:  30,
+		Values:    []float64{2, 20},
+		IsAbsent:  []bool{false, false},
+	}
+	if len(got) != 1 || !MetricsEqual(got[0], expected) {
+		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
+	}
+}
+
+func TestNormalizeSteps(t *testing.T) {
+	metrics := []Metric{
+		Metric{
+			Name:      "metric",
+			StartTime: 100,
+			StopTime:  160,
+			StepTime:  20,
+			Values:    []float64{1, 2, 3},
+			IsAbsent:  []bool{false, false, false},
+		},
+		Metric{
+			Name:      "metric",
+			StartTime: 60,
+			StopTime:  180,
+			StepTime:  60,
+			Values:    []float64{0, 0},
+			IsAbsent:  []bool{true, true},
+		},
+		// 25s doesn't divide 60s
+		Metric{
+			Name:      "metric",
+			StartTime: 100,
+			StopTime:  150,
+			StepTime:  25,
+			Values:    []float64{7, 7},
+			IsAbsent:  []bool{false, false},
+		},
+	}
+
+	got := NormalizeSteps(metrics)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(got))
+	}
+
+	if !MetricsEqual(got[0], metrics[1]) {
+		t.Errorf("Expected the coarsest metric first, got %+v", got[0])
+	}
+
+	// 100 falls in [60, 120), 120 and 140 in [120, 180)
+	expected := Metric{
+		Name:      "metric",
+		StartTime: 60,
+		StopTime:  180,
+		StepTime:  60,
+		Values:    []float64{1, 2.5},
+		IsAbsent:  []bool{false, false},
+	}
+	if !MetricsEqual(got[1], expected) {
+		t.Errorf("Consolidation failed\nExp: %+v\nGot: %+v\n", expected, got[1])
+	}
+}
+
+func TestParseStepPolicy(t *testing.T) {
+	for s, want := range map[string]StepPolicy{"": StepFinest, "finest": StepFinest, "coarsest": StepCoarsest} {
+		got, err := ParseStepPolicy(s)
+		if err != nil || got != want {
+			t.Errorf("ParseStepPolicy(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+
+	if _, err := ParseStepPolicy("median"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
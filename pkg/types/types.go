@@ -105,6 +105,10 @@ func mergeMetrics(metrics []Metric) Metric {
 		return metrics[0]
 	}
 
+	if stepPolicy == StepCoarsest {
+		metrics = NormalizeSteps(metrics)
+	}
+
 	sort.Sort(byStepTime(metrics))
 	healed := 0
 
@@ -178,6 +182,63 @@ type Retention struct {
 	NumberOfPoints  int32
 }
 
+// The formats responses can be served in, as Capabilities list them.
+const (
+	FormatCarbonAPIv3 = "carbonapi_v3_pb"
+	FormatCarbonAPIv2 = "carbonapi_v2_pb"
+	FormatJSON        = "json"
+	FormatPickle      = "pickle"
+)
+
+// Capabilities describe what a backend supports, as it announces them at
+// /capabilities/.
+type Capabilities struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Formats are the formats responses are served in, richest first.
+	Formats []string `json:"formats"`
+	// FilterFunctions are the filtering functions the backend applies to
+	// the series it renders, when render requests carry them.
+	FilterFunctions []string `json:"filterFunctions,omitempty"`
+}
+
+// Supports tells if responses can be served in format.
+func (c Capabilities) Supports(format string) bool {
+	for _, f := range c.Formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SupportsFilters tells if the backend applies all of fs.
+func (c Capabilities) SupportsFilters(fs []FilterFunction) bool {
+	for _, f := range fs {
+		supported := false
+		for _, name := range c.FilterFunctions {
+			if name == f.Name {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterFunction is a filtering function, such as highestAverage, that
+// render requests ask backends to apply to the series they fetch. Its
+// arguments leave out the series list.
+type FilterFunction struct {
+	Name      string
+	Arguments []string
+}
+
 // Matches describes a glob match from a Graphite store.
 type Matches struct {
 	Name    string
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"reflect"
@@ -15,10 +16,10 @@ import (
 )
 
 type FuncEvaluator struct {
-	eval func(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error)
+	eval func(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error)
 }
 
-func (evaluator *FuncEvaluator) EvalExpr(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (evaluator *FuncEvaluator) EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if e.IsName() {
 		return values[parser.MetricRequest{Metric: e.Target(), From: from, Until: until}], nil
 	} else if e.IsConst() {
@@ -32,7 +33,7 @@ func (evaluator *FuncEvaluator) EvalExpr(e parser.Expr, from, until int32, value
 		return nil, parser.ErrMissingArgument
 	}
 
-	return evaluator.eval(e, from, until, values)
+	return evaluator.eval(ctx, e, from, until, values)
 }
 
 func EvaluatorFromFunc(function interfaces.Function) interfaces.Evaluator {
@@ -45,9 +46,9 @@ func EvaluatorFromFunc(function interfaces.Function) interfaces.Evaluator {
 
 func EvaluatorFromFuncWithMetadata(metadata map[string]interfaces.Function) interfaces.Evaluator {
 	e := &FuncEvaluator{
-		eval: func(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+		eval: func(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 			if f, ok := metadata[e.Target()]; ok {
-				return f.Do(e, from, until, values)
+				return f.Do(ctx, e, from, until, values)
 			}
 			return nil, fmt.Errorf("unknown function: %v", e.Target())
 		},
@@ -69,6 +70,7 @@ func DeepClone(original map[parser.MetricRequest][]*types.MetricData) map[parser
 					Values:    make([]float64, len(originalMetric.Values)),
 					IsAbsent:  make([]bool, len(originalMetric.IsAbsent)),
 				},
+				XFilesFactor: originalMetric.XFilesFactor,
 			}
 
 			copy(copiedMetric.Values, originalMetric.Values)
@@ -194,7 +196,7 @@ func TestSummarizeEvalExpr(t *testing.T, tt *SummarizeEvalTestItem) {
 
 	t.Run(tt.Name, func(t *testing.T) {
 		originalMetrics := DeepClone(tt.M)
-		g, err := evaluator.EvalExpr(tt.E, 0, 1, tt.M)
+		g, err := evaluator.EvalExpr(context.Background(), tt.E, 0, 1, tt.M)
 		if err != nil {
 			t.Errorf("failed to eval %v: %+v", tt.Name, err)
 			return
@@ -230,7 +232,7 @@ func TestMultiReturnEvalExpr(t *testing.T, tt *MultiReturnEvalTestItem) {
 	evaluator := metadata.GetEvaluator()
 
 	originalMetrics := DeepClone(tt.M)
-	g, err := evaluator.EvalExpr(tt.E, 0, 1, tt.M)
+	g, err := evaluator.EvalExpr(context.Background(), tt.E, 0, 1, tt.M)
 	if err != nil {
 		t.Errorf("failed to eval %v: %+v", tt.Name, err)
 		return
@@ -278,7 +280,7 @@ func TestEvalExpr(t *testing.T, tt *EvalTestItem) {
 	evaluator := metadata.GetEvaluator()
 	originalMetrics := DeepClone(tt.M)
 	testName := tt.E.Target() + "(" + tt.E.RawArgs() + ")"
-	g, err := evaluator.EvalExpr(tt.E, 0, 1, tt.M)
+	g, err := evaluator.EvalExpr(context.Background(), tt.E, 0, 1, tt.M)
 	if err != nil {
 		t.Errorf("failed to eval %s: %+v", testName, err)
 		return
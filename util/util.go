@@ -1,19 +1,25 @@
-// Package util provides UUIDs for CarbonAPI and CarbonZipper HTTP requests.
+// Package util provides UUIDs for CarbonAPI and CarbonZipper HTTP requests,
+// and the chain of zippers the requests went through.
 package util
 
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/satori/go.uuid"
 )
 
 type key int
 
+// HeaderUUID is the header that carries the Carbon UUID of a request.
+const HeaderUUID = "X-CTX-Carbon-UUID"
+
 const (
-	ctxHeaderUUID = "X-CTX-Carbon-UUID"
+	ctxHeaderForwardedBy = "X-Forwarded-By"
 
-	uuidKey key = 0
+	uuidKey        key = 0
+	forwardedByKey key = 1
 )
 
 // GetUUID gets the Carbon UUID of a request.
@@ -25,14 +31,49 @@ func GetUUID(ctx context.Context) string {
 	return ""
 }
 
-// MarshalCtx ensures that outgoing HTTP requests have a Carbon UUID.
+// MarshalCtx ensures that outgoing HTTP requests have a Carbon UUID, and
+// the zippers the request went through, if any.
 func MarshalCtx(ctx context.Context, request *http.Request) *http.Request {
 	ctx = WithUUID(ctx)
-	request.Header.Add(ctxHeaderUUID, GetUUID(ctx))
+	request.Header.Add(HeaderUUID, GetUUID(ctx))
+
+	if chain := GetForwardedBy(ctx); len(chain) > 0 {
+		request.Header.Set(ctxHeaderForwardedBy, strings.Join(chain, ", "))
+	}
 
 	return request
 }
 
+// GetForwardedBy gets the zippers, first to last, a request went through.
+func GetForwardedBy(ctx context.Context) []string {
+	if chain := ctx.Value(forwardedByKey); chain != nil {
+		return chain.([]string)
+	}
+
+	return nil
+}
+
+// WithForwardedBy sets the zippers a request went through, which MarshalCtx
+// passes on to the backends.
+func WithForwardedBy(ctx context.Context, chain []string) context.Context {
+	return context.WithValue(ctx, forwardedByKey, chain)
+}
+
+// ForwardedBy reads the zippers an incoming request went through from its
+// X-Forwarded-By headers.
+func ForwardedBy(r *http.Request) []string {
+	var chain []string
+	for _, h := range r.Header[ctxHeaderForwardedBy] {
+		for _, id := range strings.Split(h, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				chain = append(chain, id)
+			}
+		}
+	}
+
+	return chain
+}
+
 // WithUUID ensures that a context has a Carbon UUID.
 func WithUUID(ctx context.Context) context.Context {
 	if id := GetUUID(ctx); id != "" {
@@ -54,7 +95,7 @@ func UUIDHandler(h http.Handler) http.Handler {
 }
 
 func (h uuidHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	id := r.Header.Get(ctxHeaderUUID)
+	id := r.Header.Get(HeaderUUID)
 	if id == "" {
 		id = uuid.NewV4().String()
 	}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestForwardedBy(t *testing.T) {
+	in := httptest.NewRequest("GET", "/render", nil)
+	in.Header.Add("X-Forwarded-By", "global:8080, eu:8080")
+	in.Header.Add("X-Forwarded-By", "eu-west:8080")
+
+	chain := ForwardedBy(in)
+	if expected := []string{"global:8080", "eu:8080", "eu-west:8080"}; !reflect.DeepEqual(chain, expected) {
+		t.Fatalf("expected %v, got %v", expected, chain)
+	}
+
+	ctx := WithForwardedBy(context.Background(), append(chain, "local:8080"))
+	out := MarshalCtx(ctx, httptest.NewRequest("GET", "/render", nil))
+	if got, expected := out.Header.Get("X-Forwarded-By"), "global:8080, eu:8080, eu-west:8080, local:8080"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	out = MarshalCtx(context.Background(), httptest.NewRequest("GET", "/render", nil))
+	if _, ok := out.Header["X-Forwarded-By"]; ok {
+		t.Error("expected no X-Forwarded-By header without a chain")
+	}
+}
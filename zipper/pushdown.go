@@ -0,0 +1,214 @@
+package zipper
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/pkg/aggregate"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+type filtersKey struct{}
+
+// WithFilters has Render with ctx ask the servers that apply the filtering
+// functions fs to apply them to the targets, so that they send only the
+// series fs keep. The other servers send all the series, so the functions
+// have to be evaluated on the rendered series still.
+func WithFilters(ctx context.Context, fs []types.FilterFunction) context.Context {
+	return context.WithValue(ctx, filtersKey{}, fs)
+}
+
+// Filters returns the filtering functions of ctx.
+func Filters(ctx context.Context) []types.FilterFunction {
+	fs, _ := ctx.Value(filtersKey{}).([]types.FilterFunction)
+	return fs
+}
+
+// serverCapabilities are the capabilities the servers announced at the last
+// probe. Servers that announce none, such as stores, are only spoken to in
+// version 2.
+type serverCapabilities struct {
+	mu       sync.RWMutex
+	byServer map[string]types.Capabilities
+}
+
+func (c *serverCapabilities) get(server string) types.Capabilities {
+	if c == nil {
+		return types.Capabilities{}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.byServer[server]
+}
+
+func (c *serverCapabilities) set(byServer map[string]types.Capabilities) {
+	c.mu.Lock()
+	c.byServer = byServer
+	c.mu.Unlock()
+}
+
+// probeCapabilities asks the servers for the capabilities they announce at
+// /capabilities/.
+func (z *Zipper) probeCapabilities() {
+	logger := z.logger.With(zap.String("function", "probeCapabilities"))
+	ctx, cancel := context.WithTimeout(util.WithUUID(context.Background()), z.timeout)
+	defer cancel()
+
+	servers := uniqueServers(z.backends)
+	ch := make(chan ServerResponse, len(servers))
+	for _, server := range servers {
+		go z.singleGet(ctx, logger, "/capabilities/", server, ch)
+	}
+
+	byServer := make(map[string]types.Capabilities)
+	for range servers {
+		r := <-ch
+		if r.err != nil || r.response == nil {
+			continue
+		}
+
+		var c types.Capabilities
+		if err := json.Unmarshal(r.response, &c); err != nil {
+			if ce := logger.Check(zap.DebugLevel, "server announced no capabilities"); ce != nil {
+				ce.Write(
+					zap.String("server", r.server),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		byServer[r.server] = c
+	}
+
+	z.capabilities.set(byServer)
+}
+
+// pushdown returns the servers to push fs down to: those that announced
+// that they serve version 3 and apply all of fs. Aggregates are only pushed
+// down to a lone server, as those of several servers would be merged as
+// replicas, and those of the servers asked in version 2 added to the
+// aggregated series.
+func (z *Zipper) pushdown(servers []string, fs []types.FilterFunction) map[string]bool {
+	if len(fs) == 0 {
+		return nil
+	}
+	if aggregates(fs) && len(uniqueServers(servers)) != 1 {
+		return nil
+	}
+
+	pushed := make(map[string]bool)
+	for _, server := range servers {
+		c := z.capabilities.get(server)
+		if c.Supports(types.FormatCarbonAPIv3) && c.SupportsFilters(fs) {
+			pushed[server] = true
+		}
+	}
+
+	return pushed
+}
+
+// aggregates tells if fs aggregate the series they are applied to.
+func aggregates(fs []types.FilterFunction) bool {
+	for _, f := range fs {
+		if _, ok := aggregate.Of(f); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderFrom asks servers for the targets in the request of uri, but for
+// those fs are pushed down to, which are asked in version 3 with fs. Those
+// of them that fail are asked again in version 2.
+func (z *Zipper) renderFrom(ctx context.Context, logger *zap.Logger, servers []string, uri string, targets []string, from, until int32, fs []types.FilterFunction, stats *Stats) []ServerResponse {
+	pushed := z.pushdown(servers, fs)
+	if len(pushed) == 0 {
+		return z.multiGet(ctx, logger, servers, uri, stats)
+	}
+
+	var v3, v2 []string
+	for _, server := range uniqueServers(servers) {
+		if pushed[server] {
+			v3 = append(v3, server)
+		} else {
+			v2 = append(v2, server)
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		v2Resp   []ServerResponse
+		v2Stats  Stats
+		failed   []string
+		answered = make(map[string]bool)
+	)
+	if len(v2) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v2Resp = z.multiGet(ctx, logger, v2, uri, &v2Stats)
+		}()
+	}
+	responses := z.renderV3(ctx, logger, v3, targets, from, until, fs, stats)
+	wg.Wait()
+	stats.Timeouts += v2Stats.Timeouts
+
+	for _, r := range responses {
+		answered[r.server] = true
+	}
+	for _, server := range v3 {
+		if !answered[server] {
+			failed = append(failed, server)
+		}
+	}
+	if len(failed) > 0 && ctx.Err() == nil {
+		responses = append(responses, z.multiGet(ctx, logger, failed, uri, stats)...)
+	}
+
+	return append(responses, v2Resp...)
+}
+
+// renderV3 asks servers for the targets with fs in version 3, and returns
+// their responses in version 2, as the other servers send them.
+func (z *Zipper) renderV3(ctx context.Context, logger *zap.Logger, servers []string, targets []string, from, until int32, fs []types.FilterFunction, stats *Stats) []ServerResponse {
+	requests := make([]carbonapi_v3.FetchRequest, len(targets))
+	for i, target := range targets {
+		requests[i] = carbonapi_v3.FetchRequest{Name: target, From: from, Until: until, Filters: fs}
+	}
+	body, err := carbonapi_v3.RenderRequestEncoder(requests)
+	if err != nil {
+		return nil
+	}
+
+	responses := z.multiDo(ctx, logger, servers, "/api/v3/render", body, stats)
+	converted := responses[:0]
+	for _, r := range responses {
+		if r.response != nil {
+			metrics, err := carbonapi_v3.RenderDecoder(r.response)
+			if err == nil {
+				r.response, err = carbonapi_v2.RenderEncoder(metrics)
+			}
+			if err != nil {
+				logger.Error("error decoding carbonapi_v3 response",
+					zap.String("server", r.server),
+					zap.Error(err),
+				)
+				stats.RenderErrors++
+				continue
+			}
+		}
+		stats.Pushdowns++
+		converted = append(converted, r)
+	}
+
+	return converted
+}
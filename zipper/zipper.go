@@ -1,8 +1,10 @@
 package zipper
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -16,6 +18,9 @@ import (
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/limiter"
 	"github.com/bookingcom/carbonapi/pathcache"
+	"github.com/bookingcom/carbonapi/pkg/intern"
+	"github.com/bookingcom/carbonapi/pkg/tlds"
+	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/util"
 	pb3 "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	"github.com/pkg/errors"
@@ -37,11 +42,26 @@ type Zipper struct {
 	keepAliveInterval      time.Duration
 
 	pathCache pathcache.PathCache
+	// names interns the metric names of the responses merged, which the
+	// path cache and the query caches keep too.
+	names *intern.Pool
+
+	// incrementalProbe has the probes fetch the deltas of the top-level
+	// domains of the backends, which are kept in tlds, except from those
+	// in noDeltas, which don't serve them.
+	incrementalProbe bool
+	tlds             *tlds.Servers
+	noDeltas         map[string]bool
+
+	// capabilities tell the servers that filtering functions of renders
+	// are pushed down to.
+	capabilities *serverCapabilities
 
 	backends                  []string
 	concurrencyLimitPerServer int
 	maxIdleConnsPerHost       int
 	corruptionThreshold       float64
+	stepPolicy                types.StepPolicy
 
 	sendStats func(*Stats)
 
@@ -67,6 +87,23 @@ type Stats struct {
 
 	CacheMisses int64
 	CacheHits   int64
+	// Rebroadcasts counts the requests the servers known to own their
+	// paths had nothing for, which were then sent to the other backends.
+	Rebroadcasts int64
+	// Pushdowns counts the renders servers applied filtering functions to.
+	Pushdowns int64
+
+	// Series describes how each rendered series was merged.
+	Series map[string]SeriesMeta
+}
+
+// SeriesMeta describes how a rendered series was merged from the responses
+// of the backends.
+type SeriesMeta struct {
+	// Backends are the servers whose responses had the series.
+	Backends []string
+	// Healed is the number of absent points filled in from other responses.
+	Healed int
 }
 
 type nameLeaf struct {
@@ -84,6 +121,12 @@ func NewZipper(sender func(*Stats), config cfg.Zipper, logger *zap.Logger) *Zipp
 		sendStats: sender,
 
 		pathCache: config.PathCache,
+		names:     config.Names,
+
+		incrementalProbe: config.IncrementalProbe,
+		tlds:             tlds.NewServers(),
+		noDeltas:         make(map[string]bool),
+		capabilities:     &serverCapabilities{},
 
 		storageClient:             &http.Client{},
 		backends:                  config.Common.Backends,
@@ -102,6 +145,9 @@ func NewZipper(sender func(*Stats), config cfg.Zipper, logger *zap.Logger) *Zipp
 		zap.Any("config", config),
 	)
 
+	// carbonapi rejects invalid policies at startup
+	z.stepPolicy, _ = types.ParseStepPolicy(config.StepPolicy)
+
 	if z.concurrencyLimitPerServer != 0 {
 		limiterServers := z.backends
 		z.limiter = limiter.NewServerLimiter(limiterServers, z.concurrencyLimitPerServer)
@@ -153,6 +199,7 @@ func (z *Zipper) mergeResponses(responses []ServerResponse, stats *Stats) ([]str
 
 	servers := make([]string, 0, len(responses))
 	metrics := make(map[string][]pb3.FetchResponse)
+	sources := make(map[string][]string)
 
 	for _, r := range responses {
 		var d pb3.MultiFetchResponse
@@ -174,7 +221,9 @@ func (z *Zipper) mergeResponses(responses []ServerResponse, stats *Stats) ([]str
 		}
 		stats.MemoryUsage += int64(d.Size())
 		for _, m := range d.Metrics {
-			metrics[m.GetName()] = append(metrics[m.GetName()], m)
+			m.Name = z.names.String(m.Name)
+			metrics[m.Name] = append(metrics[m.Name], m)
+			sources[m.Name] = append(sources[m.Name], r.server)
 		}
 		servers = append(servers, r.server)
 	}
@@ -184,9 +233,11 @@ func (z *Zipper) mergeResponses(responses []ServerResponse, stats *Stats) ([]str
 	}
 
 	var multi pb3.MultiFetchResponse
+	stats.Series = make(map[string]SeriesMeta, len(metrics))
 	for name, decoded := range metrics {
-		m := z.mergeMetrics(name, decoded, stats)
+		m, healed := z.mergeMetrics(name, decoded, stats)
 		multi.Metrics = append(multi.Metrics, m)
+		stats.Series[name] = SeriesMeta{Backends: sources[name], Healed: healed}
 	}
 
 	stats.MemoryUsage += int64(multi.Size())
@@ -194,7 +245,9 @@ func (z *Zipper) mergeResponses(responses []ServerResponse, stats *Stats) ([]str
 	return servers, &multi
 }
 
-func (z *Zipper) mergeMetrics(name string, decoded []pb3.FetchResponse, stats *Stats) pb3.FetchResponse {
+// mergeMetrics merges the responses for one series. It also returns how many
+// absent points were healed.
+func (z *Zipper) mergeMetrics(name string, decoded []pb3.FetchResponse, stats *Stats) (pb3.FetchResponse, int) {
 	logger := z.logger.With(zap.String("function", "mergeResponses"))
 
 	if ce := logger.Check(zap.DebugLevel, "decoded response"); ce != nil {
@@ -211,18 +264,54 @@ func (z *Zipper) mergeMetrics(name string, decoded []pb3.FetchResponse, stats *S
 			)
 		}
 
-		return decoded[0]
+		return decoded[0], 0
+	}
+
+	if z.stepPolicy == types.StepCoarsest {
+		decoded = normalizeSteps(decoded)
 	}
 
 	// Use the metric with the highest resolution as our base
 	sort.Sort(byStepTime(decoded))
 	metric := decoded[0]
-	z.mergeValues(&metric, decoded[1:], stats, logger)
+	healed := z.mergeValues(&metric, decoded[1:], stats, logger)
 
-	return metric
+	return metric, healed
 }
 
-func (z *Zipper) mergeValues(metric *pb3.FetchResponse, others []pb3.FetchResponse, stats *Stats, logger *zap.Logger) {
+// normalizeSteps consolidates the responses for a series to the coarsest step
+// among them, see types.NormalizeSteps.
+func normalizeSteps(decoded []pb3.FetchResponse) []pb3.FetchResponse {
+	metrics := make([]types.Metric, len(decoded))
+	for i, d := range decoded {
+		metrics[i] = types.Metric{
+			Name:      d.Name,
+			StartTime: d.StartTime,
+			StopTime:  d.StopTime,
+			StepTime:  d.StepTime,
+			Values:    d.Values,
+			IsAbsent:  d.IsAbsent,
+		}
+	}
+
+	metrics = types.NormalizeSteps(metrics)
+
+	normalized := make([]pb3.FetchResponse, len(metrics))
+	for i, m := range metrics {
+		normalized[i] = pb3.FetchResponse{
+			Name:      m.Name,
+			StartTime: m.StartTime,
+			StopTime:  m.StopTime,
+			StepTime:  m.StepTime,
+			Values:    m.Values,
+			IsAbsent:  m.IsAbsent,
+		}
+	}
+
+	return normalized
+}
+
+func (z *Zipper) mergeValues(metric *pb3.FetchResponse, others []pb3.FetchResponse, stats *Stats, logger *zap.Logger) int {
 	healed := 0
 	for i := range metric.Values {
 		if !metric.IsAbsent[i] {
@@ -251,6 +340,8 @@ func (z *Zipper) mergeValues(metric *pb3.FetchResponse, others []pb3.FetchRespon
 	if c > z.corruptionThreshold {
 		logger.With(zap.Float64("corruption", c)).Error("metric corruption spotted", zap.String("metric_name", metric.Name))
 	}
+
+	return healed
 }
 
 func (z *Zipper) infoUnpackPB(responses []ServerResponse, stats *Stats) map[string]pb3.InfoResponse {
@@ -346,6 +437,7 @@ func (z *Zipper) findUnpackPB(responses []ServerResponse, stats *Stats) ([]pb3.G
 		}
 
 		for _, match := range metric.Matches {
+			match.Path = z.names.String(match.Path)
 			n := nameLeaf{match.Path, match.IsLeaf}
 			_, ok := seen[n]
 			if !ok {
@@ -365,6 +457,11 @@ func (z *Zipper) findUnpackPB(responses []ServerResponse, stats *Stats) ([]pb3.G
 }
 
 func (z *Zipper) doProbe() {
+	if z.incrementalProbe {
+		z.doIncrementalProbe()
+		return
+	}
+
 	stats := &Stats{}
 	logger := z.logger.With(zap.String("function", "probe"))
 	ctx := util.WithUUID(context.Background())
@@ -408,12 +505,116 @@ func (z *Zipper) doProbe() {
 	}
 }
 
+// doIncrementalProbe asks the backends for how their top-level domains
+// changed since the last probe, and applies the deltas to the servers of the
+// domains. The backends that don't serve deltas, such as carbonservers, are
+// asked for all their domains, which are applied as full deltas. All the
+// domains are then set in the path cache again, so that they don't expire,
+// but from the lists of servers kept, which only change with the deltas.
+func (z *Zipper) doIncrementalProbe() {
+	stats := &Stats{}
+	logger := z.logger.With(zap.String("function", "probe"))
+	ctx, cancel := context.WithTimeout(util.WithUUID(context.Background()), z.timeout)
+	defer cancel()
+
+	servers := uniqueServers(z.backends)
+	var incremental, legacy []string
+	for _, server := range servers {
+		if z.noDeltas[server] {
+			legacy = append(legacy, server)
+		} else {
+			incremental = append(incremental, server)
+		}
+	}
+
+	ch := make(chan ServerResponse, len(incremental))
+	for _, server := range incremental {
+		uri := fmt.Sprintf("/metrics/tlds/?format=json&since=%d", z.tlds.Generation(server))
+		go z.singleGet(ctx, logger, uri, server, ch)
+	}
+
+	var changed []string
+	received := 0
+GATHER:
+	for received < len(incremental) {
+		select {
+		case r := <-ch:
+			received++
+			switch {
+			case r.err != nil:
+				logger.Warn("failed to fetch the delta of top-level domains",
+					zap.String("server", r.server),
+					zap.Error(r.err),
+				)
+				stats.FindErrors++
+			case r.response == nil:
+				// Not found, the backend is asked for all its domains
+				// from now on.
+				z.noDeltas[r.server] = true
+				legacy = append(legacy, r.server)
+			default:
+				var d tlds.Delta
+				if err := json.Unmarshal(r.response, &d); err != nil {
+					logger.Warn("failed to decode the delta of top-level domains",
+						zap.String("server", r.server),
+						zap.Error(err),
+					)
+					stats.FindErrors++
+					continue
+				}
+				changed = append(changed, z.tlds.Apply(r.server, d)...)
+			}
+		case <-ctx.Done():
+			stats.Timeouts++
+			break GATHER
+		}
+	}
+
+	if len(legacy) > 0 {
+		responses := z.multiGet(ctx, logger, legacy, "/metrics/find/?format=protobuf&query=%2A", stats)
+		_, paths := z.findUnpackPB(responses, stats)
+
+		byServer := make(map[string][]string)
+		for path, servers := range paths {
+			for _, server := range servers {
+				byServer[server] = append(byServer[server], path)
+			}
+		}
+		for _, r := range responses {
+			changed = append(changed, z.tlds.Apply(r.server, tlds.Delta{Full: true, Added: byServer[r.server]})...)
+		}
+	}
+
+	z.sendStats(stats)
+
+	z.tlds.Each(func(tld string, servers []string) {
+		z.pathCache.Set(tld, servers)
+	})
+	// Domains no backend has any more are cached without servers, which
+	// finds treat as not cached.
+	for _, tld := range changed {
+		if z.tlds.Get(tld) == nil {
+			z.pathCache.Set(tld, nil)
+		}
+	}
+
+	logger.Info("TLD Probe run results",
+		zap.String("carbonzipper_uuid", util.GetUUID(ctx)),
+		zap.Int("paths_count", z.tlds.Len()),
+		zap.Int("paths_changed", len(changed)),
+		zap.Int("legacy_backends", len(legacy)),
+		zap.Int("backends", len(servers)),
+	)
+}
+
 func (z *Zipper) probeTlds() {
 	for {
 		select {
 		case <-z.probeTicker.C:
+			z.probeCapabilities()
 			z.doProbe()
 		case <-z.ProbeForce:
+			z.probeCapabilities()
 			z.doProbe()
 		case <-z.ProbeQuit:
 			z.probeTicker.Stop()
@@ -422,7 +623,19 @@ func (z *Zipper) probeTlds() {
 	}
 }
 
+// Close stops probing the backends and closes the idle connections to
+// them.
+func (z *Zipper) Close() {
+	close(z.ProbeQuit)
+	z.storageClient.CloseIdleConnections()
+}
+
 func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server string, ch chan<- ServerResponse) {
+	z.singleDo(ctx, logger, uri, server, nil, ch)
+}
+
+// singleDo GETs uri from server, or POSTs body to it if there is one.
+func (z *Zipper) singleDo(ctx context.Context, logger *zap.Logger, uri, server string, body []byte, ch chan<- ServerResponse) {
 	logger = logger.With(zap.String("handler", "singleGet"))
 
 	u, err := url.Parse(server + uri)
@@ -439,6 +652,9 @@ func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server
 	}
 
 	req, err := http.NewRequest("GET", u.String(), nil)
+	if body != nil {
+		req, err = http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	}
 	if err != nil {
 		if ce := logger.Check(zap.DebugLevel, "failed to create new request"); ce != nil {
 			ce.Write(zap.Error(err))
@@ -447,6 +663,9 @@ func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server
 		ch <- ServerResponse{server: server, response: nil, err: errors.Wrap(err, "Failed to create new request")}
 		return
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
 	req = util.MarshalCtx(ctx, req)
 
 	logger = logger.With(zap.String("query", server+"/"+uri))
@@ -485,7 +704,7 @@ func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server
 		return
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		if ce := logger.Check(zap.DebugLevel, "error reading body"); ce != nil {
 			ce.Write(zap.Error(err))
@@ -495,10 +714,16 @@ func (z *Zipper) singleGet(ctx context.Context, logger *zap.Logger, uri, server
 		return
 	}
 
-	ch <- ServerResponse{server: server, response: body, err: nil}
+	ch <- ServerResponse{server: server, response: respBody, err: nil}
 }
 
 func (z *Zipper) multiGet(ctx context.Context, logger *zap.Logger, servers []string, uri string, stats *Stats) []ServerResponse {
+	return z.multiDo(ctx, logger, servers, uri, nil, stats)
+}
+
+// multiDo makes the request of singleDo to each of servers, and returns the
+// responses of those that answered.
+func (z *Zipper) multiDo(ctx context.Context, logger *zap.Logger, servers []string, uri string, body []byte, stats *Stats) []ServerResponse {
 	logger = logger.With(
 		zap.String("handler", "multiGet"),
 		zap.String("uri", uri),
@@ -511,10 +736,14 @@ func (z *Zipper) multiGet(ctx context.Context, logger *zap.Logger, servers []str
 		)
 	}
 
+	// each server is asked once, so that the gathering below waits for
+	// exactly the servers queried
+	servers = uniqueServers(servers)
+
 	// buffered channel so the goroutines don't block on send
 	ch := make(chan ServerResponse, len(servers))
 	for _, server := range servers {
-		go z.singleGet(ctx, logger, uri, server, ch)
+		go z.singleDo(ctx, logger, uri, server, body, ch)
 	}
 
 	responses := make([]ServerResponse, 0, len(servers))
@@ -602,54 +831,125 @@ func netOpErrorMessage(err *net.OpError) string {
 	}
 }
 
-func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, target string, from, until int32) (*pb3.MultiFetchResponse, *Stats, error) {
+// Render fetches the targets, all for the same time range, in one request to
+// each backend. The filtering functions of ctx, see WithFilters, are pushed
+// down to the backends that apply them.
+func (z *Zipper) Render(ctx context.Context, logger *zap.Logger, targets []string, from, until int32) (*pb3.MultiFetchResponse, *Stats, error) {
 	stats := &Stats{}
 
 	rewrite, _ := url.Parse("http://127.0.0.1/render/")
 
 	v := url.Values{
-		"target": []string{target},
+		"target": targets,
 		"format": []string{"protobuf"},
 		"from":   []string{strconv.Itoa(int(from))},
 		"until":  []string{strconv.Itoa(int(until))},
 	}
 	rewrite.RawQuery = v.Encode()
 
-	var serverList []string
-	var ok bool
-	var responses []ServerResponse
-
-	rewrite.RawQuery = v.Encode()
-
-	// lookup the server list for this metric, or use all the servers if it's unknown
-	if serverList, ok = z.pathCache.Get(target); !ok || serverList == nil || len(serverList) == 0 {
+	// lookup the server list for these metrics, or use all the servers if any is unknown
+	serverList, cached := z.batchServers(targets)
+	if !cached {
 		stats.CacheMisses++
 		serverList = z.backends
 	} else {
 		stats.CacheHits++
 	}
 
-	responses = z.multiGet(ctx, logger, serverList, rewrite.RequestURI(), stats)
+	fs := Filters(ctx)
+	fetch := func(serverList []string) ([]ServerResponse, []string, *pb3.MultiFetchResponse) {
+		responses := z.renderFrom(ctx, logger, serverList, rewrite.RequestURI(), targets, from, until, fs, stats)
+		for i := range responses {
+			stats.MemoryUsage += int64(len(responses[i].response))
+		}
+
+		servers, metrics := z.mergeResponses(responses, stats)
+		return responses, servers, metrics
+	}
 
-	for i := range responses {
-		stats.MemoryUsage += int64(len(responses[i].response))
+	responses, servers, metrics := fetch(serverList)
+	if metrics == nil && cached {
+		// the cache may be stale, e.g. after metrics moved
+		if others := z.otherBackends(serverList); len(others) > 0 {
+			stats.Rebroadcasts++
+			responses, servers, metrics = fetch(others)
+		}
 	}
 
 	if len(responses) == 0 {
 		return nil, stats, errors.New(errNoResponses)
 	}
 
-	servers, metrics := z.mergeResponses(responses, stats)
-
 	if metrics == nil {
 		return nil, stats, errors.New(errNoMetricsFetched)
 	}
 
-	z.pathCache.Set(target, servers)
+	// the servers that answered a batch may only have some of its targets
+	if len(targets) == 1 {
+		z.pathCache.Set(targets[0], servers)
+	}
 
 	return metrics, stats, nil
 }
 
+// batchServers returns the union of the cached server lists of the targets.
+// It reports false if any of them isn't cached.
+func (z *Zipper) batchServers(targets []string) ([]string, bool) {
+	seen := make(map[string]struct{})
+	var union []string
+	for _, t := range targets {
+		servers, ok := z.pathCache.Owners(t)
+		if !ok {
+			return nil, false
+		}
+
+		for _, s := range servers {
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				union = append(union, s)
+			}
+		}
+	}
+
+	return union, len(union) > 0
+}
+
+// isPlainPrefix reports whether path has at most depth segments and no
+// globs.
+func isPlainPrefix(path string, depth int) bool {
+	return !strings.ContainsAny(path, "*?[]{}") && strings.Count(path, ".") < depth
+}
+
+// otherBackends returns the backends not among servers.
+func (z *Zipper) otherBackends(servers []string) []string {
+	asked := make(map[string]struct{}, len(servers))
+	for _, s := range servers {
+		asked[s] = struct{}{}
+	}
+
+	others := make([]string, 0, len(z.backends))
+	for _, b := range z.backends {
+		if _, ok := asked[b]; !ok {
+			others = append(others, b)
+		}
+	}
+
+	return others
+}
+
+func uniqueServers(servers []string) []string {
+	seen := make(map[string]struct{}, len(servers))
+	unique := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			unique = append(unique, s)
+		}
+	}
+
+	return unique
+}
+
 func (z *Zipper) Info(ctx context.Context, logger *zap.Logger, target string) (map[string]pb3.InfoResponse, *Stats, error) {
 	stats := &Stats{}
 	var serverList []string
@@ -703,16 +1003,10 @@ func (z *Zipper) Find(ctx context.Context, logger *zap.Logger, query string) ([]
 		v.Set("query", query)
 		rewrite.RawQuery = v.Encode()
 
-		var tld string
-		if i := strings.IndexByte(query, '.'); i > 0 {
-			tld = query[:i]
-		}
-
-		// lookup tld in our map of where they live to reduce the set of
-		// servers we bug with our find
-		var backends []string
-		var ok bool
-		if backends, ok = z.pathCache.Get(tld); !ok || backends == nil || len(backends) == 0 {
+		// lookup the query, or its prefix, in our map of where they live to
+		// reduce the set of servers we bug with our find
+		backends, cached := z.pathCache.Owners(query)
+		if !cached {
 			stats.CacheMisses++
 			backends = z.backends
 		} else {
@@ -720,12 +1014,19 @@ func (z *Zipper) Find(ctx context.Context, logger *zap.Logger, query string) ([]
 		}
 
 		responses := z.multiGet(ctx, logger, backends, rewrite.RequestURI(), stats)
+		m, paths := z.findUnpackPB(responses, stats)
+		if len(m) == 0 && cached {
+			// the cache may be stale, e.g. after metrics moved
+			if others := z.otherBackends(backends); len(others) > 0 {
+				stats.Rebroadcasts++
+				responses = z.multiGet(ctx, logger, others, rewrite.RequestURI(), stats)
+				m, paths = z.findUnpackPB(responses, stats)
+			}
+		}
 
 		if len(responses) == 0 {
 			return nil, stats, errors.New(errNoResponses)
 		}
-
-		m, paths := z.findUnpackPB(responses, stats)
 		metrics = append(metrics, m...)
 
 		// update our cache of which servers have which metrics
@@ -747,6 +1048,12 @@ func (z *Zipper) Find(ctx context.Context, logger *zap.Logger, query string) ([]
 			z.pathCache.Set(k, servers)
 		}
 		z.pathCache.Set(query, allServers)
+
+		// every server with metrics under a prefix has children of it, so
+		// a find for its children learns all the servers of the prefix
+		if prefix := strings.TrimSuffix(query, ".*"); prefix != query && len(allServers) > 0 && isPlainPrefix(prefix, z.pathCache.Depth()) {
+			z.pathCache.Set(prefix, allServers)
+		}
 	}
 
 	return metrics, stats, nil
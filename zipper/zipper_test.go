@@ -1,9 +1,25 @@
 package zipper
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
 	"testing"
-
+	"time"
+	"unsafe"
+
+	"github.com/bookingcom/carbonapi/pathcache"
+	"github.com/bookingcom/carbonapi/pkg/intern"
+	"github.com/bookingcom/carbonapi/pkg/tlds"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
 	pb3 "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	"go.uber.org/zap"
 )
@@ -321,6 +337,139 @@ func TestMergeResponsesDifferingStepTimes6(t *testing.T) {
 	doTest(t, input, expected)
 }
 
+func TestMergeResponsesSeriesMeta(t *testing.T) {
+	input := []pb3.MultiFetchResponse{
+		pb3.MultiFetchResponse{
+			Metrics: []pb3.FetchResponse{
+				pb3.FetchResponse{
+					Name:     "metric",
+					Values:   []float64{1, 0, 0},
+					IsAbsent: []bool{false, true, true},
+					StepTime: 1,
+				},
+				pb3.FetchResponse{
+					Name:     "other",
+					Values:   []float64{1},
+					IsAbsent: []bool{false},
+					StepTime: 1,
+				},
+			},
+		},
+		pb3.MultiFetchResponse{
+			Metrics: []pb3.FetchResponse{
+				pb3.FetchResponse{
+					Name:     "metric",
+					Values:   []float64{0, 2, 0},
+					IsAbsent: []bool{true, false, true},
+					StepTime: 1,
+				},
+			},
+		},
+	}
+
+	z := &Zipper{
+		logger: zap.New(nil),
+	}
+	stats := &Stats{}
+	if _, err := getTestResponse(z, stats, input); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]SeriesMeta{
+		"metric": SeriesMeta{Backends: []string{"server_0", "server_1"}, Healed: 1},
+		"other":  SeriesMeta{Backends: []string{"server_0"}},
+	}
+	if !reflect.DeepEqual(stats.Series, expected) {
+		t.Errorf("Series mismatch\nExp: %+v\nGot: %+v\n", expected, stats.Series)
+	}
+}
+
+func TestMergeResponsesMixedRetention(t *testing.T) {
+	// 10s points for the first half, 30s points for the second half
+	input := []pb3.MultiFetchResponse{
+		pb3.MultiFetchResponse{
+			Metrics: []pb3.FetchResponse{
+				pb3.FetchResponse{
+					Name:      "metric",
+					StartTime: 0,
+					StopTime:  60,
+					StepTime:  10,
+					Values:    []float64{1, 2, 3, 0, 0, 0},
+					IsAbsent:  []bool{false, false, false, true, true, true},
+				},
+			},
+		},
+		pb3.MultiFetchResponse{
+			Metrics: []pb3.FetchResponse{
+				pb3.FetchResponse{
+					Name:      "metric",
+					StartTime: 0,
+					StopTime:  60,
+					StepTime:  30,
+					Values:    []float64{0, 20},
+					IsAbsent:  []bool{true, false},
+				},
+			},
+		},
+	}
+
+	expected := pb3.MultiFetchResponse{
+		Metrics: []pb3.FetchResponse{
+			pb3.FetchResponse{
+				Name:      "metric",
+				StartTime: 0,
+				StopTime:  60,
+				StepTime:  30,
+				Values:    []float64{2, 20},
+				IsAbsent:  []bool{false, false},
+			},
+		},
+	}
+
+	z := &Zipper{
+		logger:     zap.New(nil),
+		stepPolicy: types.StepCoarsest,
+	}
+	got, err := getTestResponse(z, &Stats{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Equal(expected) {
+		t.Errorf("Response mismatch\nExp: %+v\nGot: %+v\n", expected, *got)
+	}
+}
+
+func TestMergeResponsesInterned(t *testing.T) {
+	input := []pb3.MultiFetchResponse{
+		pb3.MultiFetchResponse{
+			Metrics: []pb3.FetchResponse{
+				pb3.FetchResponse{Name: "metric", Values: []float64{1}, IsAbsent: []bool{false}, StepTime: 1},
+			},
+		},
+	}
+
+	z := &Zipper{
+		logger: zap.New(nil),
+		names:  intern.New(),
+	}
+	first, err := getTestResponse(z, &Stats{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getTestResponse(z, &Stats{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unsafe.StringData(first.Metrics[0].Name) != unsafe.StringData(second.Metrics[0].Name) {
+		t.Error("expected the merged responses to share the interned name")
+	}
+	if z.names.Len() != 1 {
+		t.Errorf("expected 1 interned name, got %d", z.names.Len())
+	}
+}
+
 func doTest(t *testing.T, input []pb3.MultiFetchResponse, expected pb3.MultiFetchResponse) {
 	z := &Zipper{
 		logger: zap.New(nil),
@@ -355,3 +504,296 @@ func getTestResponse(z *Zipper, stats *Stats, input []pb3.MultiFetchResponse) (*
 
 	return got, nil
 }
+
+func TestRenderFromOwners(t *testing.T) {
+	var staleHits, ownerHits int32
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&staleHits, 1)
+		http.NotFound(w, r)
+	}))
+	defer stale.Close()
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ownerHits, 1)
+		resp := pb3.MultiFetchResponse{Metrics: []pb3.FetchResponse{{
+			Name:     r.FormValue("target"),
+			StopTime: 60,
+			StepTime: 60,
+			Values:   []float64{1},
+			IsAbsent: []bool{false},
+		}}}
+		blob, _ := resp.Marshal()
+		w.Write(blob)
+	}))
+	defer owner.Close()
+
+	z := &Zipper{
+		storageClient: &http.Client{},
+		pathCache:     pathcache.NewPathCache(60),
+		backends:      []string{stale.URL, owner.URL},
+		logger:        zap.New(nil),
+	}
+
+	// The probe put the metrics of foo on the stale server, so it's asked
+	// first, and the others once it has nothing.
+	z.pathCache.Set("foo", []string{stale.URL, stale.URL})
+	got, stats, err := z.Render(context.Background(), z.logger, []string{"foo.bar"}, 0, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Metrics) != 1 || got.Metrics[0].Name != "foo.bar" {
+		t.Errorf("Expected foo.bar, got %v", got.Metrics)
+	}
+	if stats.CacheHits != 1 || stats.Rebroadcasts != 1 {
+		t.Errorf("Expected a cache hit and a rebroadcast, got %+v", stats)
+	}
+	if staleHits != 1 || ownerHits != 1 {
+		t.Errorf("Expected each server to be asked once, got %d and %d", staleHits, ownerHits)
+	}
+
+	// The owner is known now, and is the only server asked.
+	_, stats, err = z.Render(context.Background(), z.logger, []string{"foo.bar"}, 0, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rebroadcasts != 0 || staleHits != 1 || ownerHits != 2 {
+		t.Errorf("Expected only the owner to be asked, got %+v, %d and %d", stats, staleHits, ownerHits)
+	}
+}
+
+func TestFindLearnsPrefixes(t *testing.T) {
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := pb3.GlobResponse{Name: r.FormValue("query"), Matches: []pb3.GlobMatch{
+			{Path: "servers.web01.cpu", IsLeaf: false},
+		}}
+		blob, _ := resp.Marshal()
+		w.Write(blob)
+	}))
+	defer store.Close()
+
+	z := &Zipper{
+		storageClient: &http.Client{},
+		pathCache:     pathcache.NewPathCacheDepth(60, 2),
+		backends:      []string{store.URL},
+		logger:        zap.New(nil),
+	}
+
+	if _, _, err := z.Find(context.Background(), z.logger, "servers.web01.*"); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := z.pathCache.Get("servers.web01"); !ok || !reflect.DeepEqual(got, []string{store.URL}) {
+		t.Errorf("Expected the prefix to be learned, got %v", got)
+	}
+	if got, ok := z.pathCache.Owners("servers.web01.mem.free"); !ok || !reflect.DeepEqual(got, []string{store.URL}) {
+		t.Errorf("Expected the owners of the prefix, got %v", got)
+	}
+
+	if _, _, err := z.Find(context.Background(), z.logger, "servers.web01.mem.*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := z.pathCache.Get("servers.web01.mem"); ok {
+		t.Error("Expected prefixes deeper than the depth not to be learned")
+	}
+}
+
+func TestIncrementalProbe(t *testing.T) {
+	idx := tlds.NewIndex(4)
+	idx.Update([]string{"carbon", "servers"})
+	var sinces []string
+	zipper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics/tlds/" {
+			t.Errorf("Expected zippers to be asked for deltas, got %s", r.URL.Path)
+		}
+		sinces = append(sinces, r.FormValue("since"))
+		since, _ := strconv.ParseUint(r.FormValue("since"), 10, 64)
+		blob, _ := json.Marshal(idx.Since(since))
+		w.Write(blob)
+	}))
+	defer zipper.Close()
+
+	var finds int32
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics/find/" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&finds, 1)
+		resp := pb3.GlobResponse{Name: "*", Matches: []pb3.GlobMatch{{Path: "servers"}}}
+		blob, _ := resp.Marshal()
+		w.Write(blob)
+	}))
+	defer store.Close()
+
+	z := &Zipper{
+		storageClient:    &http.Client{},
+		pathCache:        pathcache.NewPathCache(60),
+		backends:         []string{zipper.URL, store.URL},
+		timeout:          time.Second,
+		incrementalProbe: true,
+		tlds:             tlds.NewServers(),
+		noDeltas:         make(map[string]bool),
+		sendStats:        func(*Stats) {},
+		logger:           zap.New(nil),
+	}
+
+	z.doProbe()
+	if got, _ := z.pathCache.Get("carbon"); !reflect.DeepEqual(got, []string{zipper.URL}) {
+		t.Errorf("Expected carbon on the zipper, got %v", got)
+	}
+	if got, _ := z.pathCache.Get("servers"); len(got) != 2 {
+		t.Errorf("Expected servers on both backends, got %v", got)
+	}
+
+	idx.Update([]string{"servers", "hosts"})
+	z.doProbe()
+	if _, ok := z.pathCache.Get("hosts"); !ok {
+		t.Error("Expected the added domain to be cached")
+	}
+	if got, _ := z.pathCache.Get("carbon"); len(got) != 0 {
+		t.Errorf("Expected the removed domain to have no servers, got %v", got)
+	}
+
+	want := []string{"0", strconv.FormatUint(z.tlds.Generation(zipper.URL)-1, 10)}
+	if !reflect.DeepEqual(sinces, want) {
+		t.Errorf("Expected the zipper to be asked for deltas since %v, got %v", want, sinces)
+	}
+	if atomic.LoadInt32(&finds) != 2 {
+		t.Errorf("Expected the store to be asked for all its domains each probe, got %d finds", finds)
+	}
+}
+
+func TestRenderPushdown(t *testing.T) {
+	series := func(names ...string) []types.Metric {
+		var ms []types.Metric
+		for i, name := range names {
+			ms = append(ms, types.Metric{
+				Name:     name,
+				StopTime: 60,
+				StepTime: 60,
+				Values:   []float64{float64(i)},
+				IsAbsent: []bool{false},
+			})
+		}
+		return ms
+	}
+
+	var pushed [][]types.FilterFunction
+	var failV3 int32
+	zipper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/capabilities/":
+			blob, _ := json.Marshal(types.Capabilities{
+				Formats:         []string{types.FormatCarbonAPIv3},
+				FilterFunctions: []string{"highestAverage"},
+			})
+			w.Write(blob)
+		case "/api/v3/render":
+			if atomic.LoadInt32(&failV3) == 1 {
+				http.Error(w, "no", http.StatusInternalServerError)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			requests, err := carbonapi_v3.RenderRequestDecoder(body)
+			if err != nil || len(requests) != 1 {
+				t.Errorf("Expected a render request, got %v, %v", requests, err)
+			}
+			pushed = append(pushed, requests[0].Filters)
+			blob, _ := carbonapi_v3.RenderEncoder(series("zipper.b"))
+			w.Write(blob)
+		case "/render/":
+			blob, _ := carbonapi_v2.RenderEncoder(series("zipper.a", "zipper.b"))
+			w.Write(blob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer zipper.Close()
+
+	var storeRenders int32
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/render/" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&storeRenders, 1)
+		blob, _ := carbonapi_v2.RenderEncoder(series("store.a"))
+		w.Write(blob)
+	}))
+	defer store.Close()
+
+	z := &Zipper{
+		storageClient: &http.Client{},
+		pathCache:     pathcache.NewPathCache(60),
+		backends:      []string{zipper.URL, store.URL},
+		timeout:       time.Second,
+		capabilities:  &serverCapabilities{},
+		logger:        zap.New(nil),
+	}
+	z.probeCapabilities()
+
+	fs := []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"1"}}}
+	names := func(resp *pb3.MultiFetchResponse) map[string]bool {
+		got := make(map[string]bool)
+		for _, m := range resp.Metrics {
+			got[m.Name] = true
+		}
+		return got
+	}
+
+	got, stats, err := z.Render(WithFilters(context.Background(), fs), z.logger, []string{"*.*"}, 0, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"zipper.b": true, "store.a": true}; !reflect.DeepEqual(names(got), want) {
+		t.Errorf("Expected the filtered series of the zipper and all of the store, got %v", names(got))
+	}
+	if !reflect.DeepEqual(pushed, [][]types.FilterFunction{fs}) || stats.Pushdowns != 1 {
+		t.Errorf("Expected the filter to be pushed down to the zipper only, got %v and %d pushdowns", pushed, stats.Pushdowns)
+	}
+	if storeRenders != 1 {
+		t.Errorf("Expected the store to be asked in version 2, got %d renders", storeRenders)
+	}
+
+	// A zipper that fails in version 3 is asked again without the filter.
+	atomic.StoreInt32(&failV3, 1)
+	got, stats, err = z.Render(WithFilters(context.Background(), fs), z.logger, []string{"*.*"}, 0, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"zipper.a": true, "zipper.b": true, "store.a": true}; !reflect.DeepEqual(names(got), want) {
+		t.Errorf("Expected all the series after the fallback, got %v", names(got))
+	}
+	if stats.Pushdowns != 0 {
+		t.Errorf("Expected no pushdown, got %d", stats.Pushdowns)
+	}
+}
+
+func TestPushdownAggregates(t *testing.T) {
+	v3 := types.Capabilities{
+		Formats:         []string{types.FormatCarbonAPIv3},
+		FilterFunctions: []string{"highestAverage", "sumSeries"},
+	}
+	z := &Zipper{capabilities: &serverCapabilities{}}
+	z.capabilities.set(map[string]types.Capabilities{"a": v3, "b": v3})
+
+	sum := []types.FilterFunction{{Name: "sumSeries"}}
+	highest := []types.FilterFunction{{Name: "highestAverage", Arguments: []string{"1"}}}
+	tests := []struct {
+		name    string
+		servers []string
+		fs      []types.FilterFunction
+		want    map[string]bool
+	}{
+		{"aggregate to a lone server", []string{"a"}, sum, map[string]bool{"a": true}},
+		{"aggregate to a lone server twice", []string{"a", "a"}, sum, map[string]bool{"a": true}},
+		{"aggregate to several servers", []string{"a", "b"}, sum, nil},
+		{"filter to several servers", []string{"a", "b"}, highest, map[string]bool{"a": true, "b": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := z.pushdown(tt.servers, tt.fs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}